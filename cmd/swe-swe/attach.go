@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/choonkeat/swe-swe/internal/wsproto"
+	"github.com/gorilla/websocket"
+)
+
+// attachDetachKey is the byte that detaches `swe-swe attach` from the session
+// without sending anything to it (Ctrl-], as in telnet).
+const attachDetachKey = 0x1d
+
+// attachProtocolVersion is the session WebSocket protocol version attach
+// speaks, sent as ?proto= so a server that no longer speaks it says so.
+const attachProtocolVersion = "1"
+
+// attachTarget is a resolved session to attach to: the server base URL, the
+// session UUID, and any query params carried over from a pasted session URL.
+type attachTarget struct {
+	base  *url.URL
+	uuid  string
+	query url.Values
+}
+
+// parseAttachTarget accepts either a full session URL as copied from the
+// browser (http://host:1977/session/<uuid>?assistant=claude) or a bare UUID,
+// which is resolved against server.
+func parseAttachTarget(arg, server string) (attachTarget, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return attachTarget{}, errors.New("missing session URL or UUID")
+	}
+	if !strings.Contains(arg, "://") {
+		base, err := url.Parse(server)
+		if err != nil || base.Host == "" {
+			return attachTarget{}, fmt.Errorf("invalid --server %q", server)
+		}
+		if strings.ContainsAny(arg, "/?#") {
+			return attachTarget{}, fmt.Errorf("invalid session UUID %q", arg)
+		}
+		return attachTarget{base: &url.URL{Scheme: base.Scheme, Host: base.Host}, uuid: arg, query: url.Values{}}, nil
+	}
+
+	u, err := url.Parse(arg)
+	if err != nil || u.Host == "" {
+		return attachTarget{}, fmt.Errorf("invalid session URL %q", arg)
+	}
+	var uuid string
+	for _, prefix := range []string{"/session/", "/ws/"} {
+		if strings.HasPrefix(u.Path, prefix) {
+			uuid = strings.Trim(strings.TrimPrefix(u.Path, prefix), "/")
+		}
+	}
+	if uuid == "" || strings.Contains(uuid, "/") {
+		return attachTarget{}, fmt.Errorf("URL %q is not a session URL (expected /session/<uuid>)", arg)
+	}
+	return attachTarget{base: &url.URL{Scheme: u.Scheme, Host: u.Host}, uuid: uuid, query: u.Query()}, nil
+}
+
+// wsURL returns the session WebSocket URL. The server requires an assistant
+// param on every connect but only uses it when creating a session; attach
+// never creates one, so any value routes to the live session. inputID, when
+// set, is sent as ?input= so the server reads the sequenced frames runAttach
+// sends.
+func (t attachTarget) wsURL(inputID string) string {
+	q := url.Values{}
+	for k, v := range t.query {
+		q[k] = v
+	}
+	if q.Get("assistant") == "" {
+		q.Set("assistant", "claude")
+	}
+	q.Set("proto", attachProtocolVersion)
+	if inputID != "" {
+		q.Set("input", inputID)
+	}
+	scheme := "ws"
+	if t.base.Scheme == "https" {
+		scheme = "wss"
+	}
+	u := url.URL{Scheme: scheme, Host: t.base.Host, Path: "/ws/" + t.uuid, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// attachLogin exchanges the server password for the auth cookie the browser
+// would hold. The login handler answers a good password with a redirect that
+// sets the cookie, so redirects are not followed.
+func attachLogin(client *http.Client, base *url.URL, password string) (*http.Cookie, error) {
+	c := *client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := c.PostForm(base.String()+"/swe-swe-auth/login", url.Values{"password": {password}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	for _, ck := range resp.Cookies() {
+		if ck.Name == "swe_swe_session" && ck.Value != "" {
+			return ck, nil
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, errors.New("login rate limited; wait a few minutes")
+	}
+	return nil, fmt.Errorf("login failed (HTTP %d): check --password / SWE_SWE_PASSWORD", resp.StatusCode)
+}
+
+// newAttachInputID returns a random input stream id for ?input=.
+func newAttachInputID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// attachInputFrames splits a read from stdin into the frames sent for it.
+// Inside a sequenced frame the server still reads a leading 0x00-0x04 as an
+// opcode (a resize, upload or paste), so a keystroke in that range is sent
+// as a frame of its own, which is too short to be one.
+func attachInputFrames(data []byte) [][]byte {
+	var frames [][]byte
+	for len(data) > 0 {
+		n := 1
+		if data[0] > wsproto.OpSequenced {
+			n = bytes.IndexFunc(data, func(r rune) bool { return r <= rune(wsproto.OpSequenced) })
+			if n < 0 {
+				n = len(data)
+			}
+		}
+		frames = append(frames, append([]byte(nil), data[:n]...))
+		data = data[n:]
+	}
+	return frames
+}
+
+// gunzipSnapshot decompresses a reassembled chunk payload that starts with
+// the gzip magic (scrollback is compressed; the screen snapshot is not).
+func gunzipSnapshot(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// errDetached is returned by runAttach when the user pressed the detach key.
+var errDetached = errors.New("detached")
+
+// runAttach pumps a session WebSocket against a terminal until the session
+// exits, the connection drops, or the user detaches. It returns the agent's
+// exit code when the server reports one. resize delivers terminal size
+// changes; the first value should be the initial size. Stdin goes out in
+// sequenced frames (wsproto.Sequenced), so the connection must have been
+// opened with ?input= (attachTarget.wsURL).
+func runAttach(conn *websocket.Conn, stdin io.Reader, stdout io.Writer, resize <-chan [2]uint16) (int, error) {
+	var writeMu sync.Mutex
+	send := func(data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	detached := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case size := <-resize:
+				if err := send(wsproto.Resize(size[0], size[1])); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		var seq uint32
+		sendInput := func(data []byte) error {
+			for _, frame := range attachInputFrames(data) {
+				seq++
+				if err := send(wsproto.Sequenced(seq, frame)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		buf := make([]byte, 4096)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				data := buf[:n]
+				if i := bytes.IndexByte(data, attachDetachKey); i >= 0 {
+					sendInput(data[:i])
+					close(detached)
+					conn.Close()
+					return
+				}
+				if sendInput(data) != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var chunks wsproto.Assembler
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-detached:
+				return 0, errDetached
+			default:
+			}
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) {
+				switch ce.Code {
+				case websocket.CloseNormalClosure, websocket.CloseGoingAway:
+					return 0, nil
+				}
+				return 1, fmt.Errorf("connection closed: %s (code %d)", ce.Text, ce.Code)
+			}
+			return 1, err
+		}
+
+		if msgType == websocket.BinaryMessage {
+			if _, _, _, ok := wsproto.ParseChunk(data); ok {
+				payload, complete, err := chunks.Add(data)
+				if err == nil && complete {
+					payload, err = gunzipSnapshot(payload)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "\r\n[swe-swe attach] dropped snapshot: %v\r\n", err)
+					continue
+				}
+				if complete {
+					stdout.Write(payload)
+				}
+				continue
+			}
+			stdout.Write(data)
+			continue
+		}
+
+		var msg struct {
+			Type     string `json:"type"`
+			ExitCode int    `json:"exitCode"`
+			Message  string `json:"message"`
+			Code     string `json:"code"`
+		}
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		switch msg.Type {
+		case "exit":
+			fmt.Fprintf(stdout, "\r\n[session exited with code %d]\r\n", msg.ExitCode)
+			return msg.ExitCode, nil
+		case "session_gone":
+			return 1, errors.New("session has ended (or the UUID is unknown)")
+		case "session_error":
+			if msg.Code == "protocol_mismatch" {
+				return 1, fmt.Errorf("session error: %s (update swe-swe to match the server)", msg.Message)
+			}
+			return 1, fmt.Errorf("session error: %s", msg.Message)
+		}
+	}
+}
+
+// handleAttach implements `swe-swe attach <url|uuid>`: join a live session
+// from a real terminal, the way a second browser tab would.
+func handleAttach() {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	defaultServer := "http://localhost:1977"
+	if port := os.Getenv("SWE_PORT"); port != "" {
+		defaultServer = "http://localhost:" + port
+	}
+	server := fs.String("server", defaultServer, "Server base URL used when a bare session UUID is given")
+	password := fs.String("password", os.Getenv("SWE_SWE_PASSWORD"), "Server password (default: $SWE_SWE_PASSWORD)")
+	insecure := fs.Bool("insecure", false, "Skip TLS certificate verification (self-signed --ssl=selfsign setups)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: swe-swe attach [options] <session-url|session-uuid>
+
+Join a running session from this terminal. Press Ctrl-] to detach.
+
+Options:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[2:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	target, err := parseAttachTarget(fs.Arg(0), *server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: *insecure}
+	header := http.Header{}
+	if *password != "" {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		cookie, err := attachLogin(client, target.base, *password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		header.Set("Cookie", cookie.String())
+	}
+
+	dialer := websocket.Dialer{TLSClientConfig: tlsConfig}
+	conn, resp, err := dialer.Dial(target.wsURL(newAttachInputID()), header)
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusFound) {
+			err = fmt.Errorf("not authorized (HTTP %d): pass --password or set SWE_SWE_PASSWORD", resp.StatusCode)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	restore, err := makeTerminalRaw(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	resize, stopResize := watchTerminalSize(os.Stdout)
+	fmt.Fprintf(os.Stderr, "[attached to %s -- Ctrl-] to detach]\r\n", target.uuid)
+
+	code, err := runAttach(conn, os.Stdin, os.Stdout, resize)
+	stopResize()
+	restore()
+	switch {
+	case errors.Is(err, errDetached):
+		fmt.Fprintf(os.Stderr, "\n[detached from %s]\n", target.uuid)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+	}
+	os.Exit(code)
+}
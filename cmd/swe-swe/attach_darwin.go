@@ -0,0 +1,9 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// termios ioctl requests (named differently on each kernel).
+const (
+	ioctlReadTermios  = unix.TIOCGETA
+	ioctlWriteTermios = unix.TIOCSETA
+)
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeTerminalRaw is unsupported here: `swe-swe attach` needs a Unix terminal.
+func makeTerminalRaw(f *os.File) (func(), error) {
+	return nil, errors.New("swe-swe attach is only supported on Linux and macOS terminals")
+}
+
+// watchTerminalSize never reports a size on unsupported platforms.
+func watchTerminalSize(f *os.File) (<-chan [2]uint16, func()) {
+	return make(chan [2]uint16), func() {}
+}
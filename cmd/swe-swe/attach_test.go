@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/choonkeat/swe-swe/internal/wsproto"
+	"github.com/gorilla/websocket"
+)
+
+func TestParseAttachTarget(t *testing.T) {
+	tgt, err := parseAttachTarget("https://box.example.com:1977/session/abc-123?assistant=codex&session=chat", "http://localhost:1977")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tgt.uuid != "abc-123" || tgt.base.String() != "https://box.example.com:1977" {
+		t.Errorf("got uuid=%q base=%q", tgt.uuid, tgt.base)
+	}
+	ws, _ := url.Parse(tgt.wsURL("in-1"))
+	if ws.Scheme != "wss" || ws.Path != "/ws/abc-123" || ws.Query().Get("assistant") != "codex" || ws.Query().Get("session") != "chat" || ws.Query().Get("input") != "in-1" {
+		t.Errorf("wsURL = %s", ws)
+	}
+
+	tgt, err = parseAttachTarget("abc-123", "http://localhost:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tgt.wsURL(""); got != "ws://localhost:9000/ws/abc-123?assistant=claude&proto=1" {
+		t.Errorf("bare uuid wsURL = %s", got)
+	}
+
+	for _, bad := range []string{"", "http://host/", "http://host/other/abc", "abc/def"} {
+		if _, err := parseAttachTarget(bad, "http://localhost:1977"); err == nil {
+			t.Errorf("parseAttachTarget(%q) should fail", bad)
+		}
+	}
+}
+
+func TestAttachInputFrames(t *testing.T) {
+	for in, want := range map[string][]string{
+		"ls\r":                   {"ls\r"},
+		"\x03":                   {"\x03"},
+		"\x03\x01ab\x04":         {"\x03", "\x01", "ab", "\x04"},
+		"q\x00\x00\x00\x18\x00P": {"q", "\x00", "\x00", "\x00", "\x18", "\x00", "P"},
+	} {
+		var got []string
+		for _, f := range attachInputFrames([]byte(in)) {
+			got = append(got, string(f))
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("attachInputFrames(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if id := newAttachInputID(); len(id) != 32 {
+		t.Errorf("input id %q", id)
+	}
+}
+
+func TestGunzipSnapshot(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("scrollback history"))
+	zw.Close()
+	if got, err := gunzipSnapshot(gz.Bytes()); err != nil || string(got) != "scrollback history" {
+		t.Errorf("gzip payload = %q, %v", got, err)
+	}
+	if got, err := gunzipSnapshot([]byte("hi")); err != nil || string(got) != "hi" {
+		t.Errorf("plain payload = %q, %v", got, err)
+	}
+	if _, err := gunzipSnapshot([]byte{0x1f, 0x8b, 0}); err == nil {
+		t.Error("bad gzip should fail")
+	}
+}
+
+func TestAttachLogin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/swe-swe-auth/login" || r.FormValue("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "swe_swe_session", Value: "signed"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	}))
+	defer srv.Close()
+	base, _ := url.Parse(srv.URL)
+
+	ck, err := attachLogin(srv.Client(), base, "secret")
+	if err != nil || ck.Value != "signed" {
+		t.Fatalf("attachLogin = %v, %v", ck, err)
+	}
+	if _, err := attachLogin(srv.Client(), base, "wrong"); err == nil {
+		t.Error("wrong password should fail")
+	}
+}
+
+// TestRunAttach drives runAttach against a fake session endpoint: snapshot
+// chunks and PTY output reach stdout, stdin arrives in sequenced frames and
+// the resize as a bare one, and an exit message ends the attach with the
+// agent's exit code.
+func TestRunAttach(t *testing.T) {
+	received := make(chan []byte, 4)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, chunk := range wsproto.Chunks([]byte("SNAP"), 3) {
+			conn.WriteMessage(websocket.BinaryMessage, chunk)
+		}
+		conn.WriteMessage(websocket.BinaryMessage, []byte("live"))
+		for i := 0; i < 2; i++ {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- data
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"exit","exitCode":3}`))
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resize := make(chan [2]uint16, 1)
+	resize <- [2]uint16{24, 80}
+	stdinR, stdinW := io.Pipe()
+	defer stdinW.Close()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		stdinW.Write([]byte("ls\r"))
+	}()
+
+	var out bytes.Buffer
+	code, err := runAttach(conn, stdinR, &out, resize)
+	if err != nil || code != 3 {
+		t.Fatalf("runAttach = %d, %v; want 3, nil", code, err)
+	}
+	if !strings.HasPrefix(out.String(), "SNAPlive") {
+		t.Errorf("stdout = %q", out.String())
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		got[string(<-received)] = true
+	}
+	if !got[string(wsproto.Resize(24, 80))] || !got[string(wsproto.Sequenced(1, []byte("ls\r")))] {
+		t.Errorf("server received %v", got)
+	}
+}
+
+func TestRunAttachDetachKey(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := runAttach(conn, strings.NewReader("x\x1d"), io.Discard, make(chan [2]uint16))
+	if err != errDetached || code != 0 {
+		t.Errorf("runAttach = %d, %v; want 0, errDetached", code, err)
+	}
+}
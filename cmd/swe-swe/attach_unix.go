@@ -0,0 +1,69 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeTerminalRaw puts the terminal on f into raw mode (no echo, no line
+// buffering, no signal keys -- Ctrl-C goes to the session) and returns a
+// function that restores the previous mode.
+func makeTerminalRaw(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	old, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &raw); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlWriteTermios, old) }, nil
+}
+
+// watchTerminalSize reports the size of the terminal on f now and on every
+// SIGWINCH as [rows, cols]. The returned stop function ends the watch.
+func watchTerminalSize(f *os.File) (<-chan [2]uint16, func()) {
+	out := make(chan [2]uint16, 1)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGWINCH)
+	done := make(chan struct{})
+
+	report := func() {
+		ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+		if err != nil || ws.Row == 0 || ws.Col == 0 {
+			return
+		}
+		select {
+		case out <- [2]uint16{ws.Row, ws.Col}:
+		case <-done:
+		}
+	}
+	go func() {
+		report()
+		for {
+			select {
+			case <-sig:
+				report()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out, func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
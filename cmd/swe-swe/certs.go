@@ -16,6 +16,8 @@ import (
 
 // generateSelfSignedCert creates a self-signed TLS certificate and key for HTTPS.
 // The certificate is valid for localhost, 127.0.0.1, and optionally an extra host.
+// Hostnames also get *.preview.{host}, for SWE_PREVIEW_DOMAIN=preview.{host}
+// per-session preview subdomains.
 // Files are written to certsDir as server.crt and server.key.
 func generateSelfSignedCert(certsDir string, extraHost string) error {
 	// Generate RSA private key
@@ -31,7 +33,7 @@ func generateSelfSignedCert(certsDir string, extraHost string) error {
 	}
 
 	// Base DNS names and IPs
-	dnsNames := []string{"localhost", "*.localhost"}
+	dnsNames := []string{"localhost", "*.localhost", "*.preview.localhost"}
 	ipAddresses := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
 
 	// Add extra host if provided (can be IP or hostname)
@@ -42,7 +44,7 @@ func generateSelfSignedCert(certsDir string, extraHost string) error {
 			ipAddresses = append(ipAddresses, ip)
 		} else {
 			// It's a hostname
-			dnsNames = append(dnsNames, extraHost)
+			dnsNames = append(dnsNames, extraHost, "*.preview."+extraHost)
 		}
 		commonName = extraHost
 	}
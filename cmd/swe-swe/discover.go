@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/choonkeat/swe-swe/internal/mdns"
+)
+
+// handleDiscover lists the swe-swe servers advertising themselves on the
+// local network (servers started with SWE_MDNS=1), with the URL to open each.
+func handleDiscover() {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 2*time.Second, "How long to wait for servers to answer")
+	fs.Parse(os.Args[2:])
+
+	found, err := mdns.Browse(context.Background(), *timeout)
+	if err != nil {
+		log.Fatalf("Failed to query the local network: %v", err)
+	}
+	if len(found) == 0 {
+		fmt.Println("No swe-swe servers found on the local network")
+		fmt.Println("(servers advertise themselves when started with SWE_MDNS=1 and bound to a LAN address)")
+		return
+	}
+	printDiscovered(os.Stdout, found)
+}
+
+// printDiscovered writes one line per server: name, URL, version.
+func printDiscovered(w io.Writer, found []mdns.Instance) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tURL\tVERSION")
+	for _, in := range found {
+		version := in.TextValue("version")
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", in.Name, in.URL(), version)
+		for _, ip := range in.Addrs[min(1, len(in.Addrs)):] {
+			alt := strings.Replace(in.URL(), in.Addrs[0].String(), ip.String(), 1)
+			fmt.Fprintf(tw, "\t%s\t\n", alt)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/choonkeat/swe-swe/internal/mdns"
+)
+
+func TestPrintDiscovered(t *testing.T) {
+	var buf bytes.Buffer
+	printDiscovered(&buf, []mdns.Instance{
+		{Name: "swe-swe on laptop", Port: 1977, Addrs: []net.IP{net.IPv4(192, 168, 1, 20), net.IPv4(10, 0, 0, 5)}, Text: []string{"version=v2.1.0"}},
+		{Name: "ci", Host: "ci.local.", Port: 8080},
+	})
+	want := "NAME               URL                        VERSION\n" +
+		"swe-swe on laptop  http://192.168.1.20:1977/  v2.1.0\n" +
+		"                   http://10.0.0.5:1977/      \n" +
+		"ci                 http://ci.local:8080/      -\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
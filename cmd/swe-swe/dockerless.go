@@ -51,9 +51,9 @@ func isDockerlessProject(sweDir string) bool {
 
 // dockerlessGOOSGuard reports whether a dockerless init is allowed for the
 // given GOOS. The embedded payload binaries are static-Linux only (Phase 1),
-// and the server still relies on two Linux-only couplings -- the abstract
-// unix socket `@swe-swe-broker` and util-linux `script -T/-I/-O` recording
-// flags -- so dumping them on macOS/Windows would produce a broken setup.
+// and the server still relies on a Linux-only coupling -- the abstract unix
+// socket `@swe-swe-broker` -- so dumping them on macOS/Windows would produce a
+// broken setup.
 // Mac-native dockerless (darwin binaries + portable couplings) is Phase 6.
 func dockerlessGOOSGuard(goos string) error {
 	switch goos {
@@ -61,8 +61,8 @@ func dockerlessGOOSGuard(goos string) error {
 		return nil
 	case "darwin":
 		// macOS is supported but the runtime ports are still in progress
-		// (Phase 6): the per-session credential broker and PTY recording are
-		// Linux-specific and degrade on darwin -- see dockerlessDarwinWarning.
+		// (Phase 6): the per-session credential broker is Linux-specific and
+		// degrades on darwin -- see dockerlessDarwinWarning.
 		return nil
 	default:
 		return fmt.Errorf("swe-swe init --dockerless supports Linux and macOS hosts (this is a %s build); use Docker mode here", goos)
@@ -72,7 +72,7 @@ func dockerlessGOOSGuard(goos string) error {
 // dockerlessDarwinWarning is printed on a macOS dockerless init so the user
 // knows which subsystems are not yet ported (Phase 6).
 const dockerlessDarwinWarning = "Note: macOS dockerless is experimental. The per-session git credential " +
-	"broker and PTY session recording are not yet ported to macOS and will be " +
+	"broker is not yet ported to macOS and will be " +
 	"inactive; all six tabs otherwise work. Track: tasks/2026-06-27-dockerless-single-binary.md (Phase 6)."
 
 // executeDockerlessInit performs a host-native (no-Docker) init: it dumps the
@@ -347,7 +347,7 @@ func dockerlessMCPServers() map[string]mcpServerSpec {
 	return map[string]mcpServerSpec{
 		"swe-swe-agent-chat": sh("exec swe-npx -y @choonkeat/agent-chat --theme-cookie swe-swe-theme --welcome-replies \"What can you help me with?,Give me an overview of this project,What has changed recently?,/swe-swe:recordings-list-orphaned\" --autocomplete-triggers /=slash-command --autocomplete-url http://localhost:$SWE_SERVER_PORT/api/autocomplete/$SESSION_UUID?key=$MCP_AUTH_KEY"),
 		"swe-swe-playwright": sh("exec mcp-lazy-init --init-method POST --init-url http://localhost:$SWE_SERVER_PORT/api/session/$SESSION_UUID/browser/start?key=$MCP_AUTH_KEY -- npx -y @playwright/mcp@latest --cdp-endpoint http://localhost:$BROWSER_CDP_PORT"),
-		"swe-swe-preview":    sh("exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/proxy/$SESSION_UUID/preview/mcp"),
+		"swe-swe-preview":    sh("exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp/preview?key=$MCP_AUTH_KEY"),
 		"swe-swe-whiteboard": {Command: "swe-npx", Args: []string{"-y", "@choonkeat/agent-whiteboard"}},
 		"swe-swe":            sh("exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp?key=$MCP_AUTH_KEY"),
 	}
@@ -11,7 +11,7 @@ import (
 )
 
 // Dockerless runs the host-native binaries directly; today those binaries
-// are Linux-only (abstract-socket broker + GNU `script` recording flags), so
+// are Linux-only (abstract-socket broker), so
 // `swe-swe init --dockerless` must refuse on a non-Linux CLI rather than
 // dump binaries that cannot run. Mac-native support is Phase 6.
 func TestDockerlessGOOSGuard(t *testing.T) {
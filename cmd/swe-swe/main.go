@@ -29,6 +29,10 @@ func main() {
 		handleList()
 	case "proxy":
 		handleProxy()
+	case "attach":
+		handleAttach()
+	case "discover":
+		handleDiscover()
 	case "-h", "--help":
 		printUsage()
 	default:
@@ -45,6 +49,8 @@ Native Commands:
   init [options]                         Initialize a new swe-swe project
   list                                   List all initialized swe-swe projects (auto-prunes stale ones)
   proxy <command>                        Proxy host commands to containers with real-time streaming
+  attach <session-url|uuid>              Join a running session from this terminal (Ctrl-] to detach)
+  discover [--timeout 2s]                List swe-swe servers advertising on the local network (mDNS)
 
 Pass-through Commands:
   All other commands (up, down, build, ps, logs, exec, etc.) are passed directly
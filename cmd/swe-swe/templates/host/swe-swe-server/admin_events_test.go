@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func readAdminEvent(t *testing.T, conn *websocket.Conn) adminEvent {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ev adminEvent
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestAdminEventsStream(t *testing.T) {
+	s := &Session{UUID: "admin-test", Name: "fix login", Assistant: "claude", wsClients: map[*SafeConn]bool{}}
+	registerTestSession(t, "admin-test", s)
+
+	srv := httptest.NewServer(http.HandlerFunc(handleAdminEvents))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+adminEventsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sync := readAdminEvent(t, conn)
+	if sync.Type != "sync" || len(sync.Sessions) != 1 || sync.Sessions[0].Name != "fix login" {
+		t.Fatalf("sync = %+v", sync)
+	}
+
+	s.BroadcastStatus()
+	s.BroadcastStatus() // unchanged: no second event
+	updateAgentStates() // no output yet: busy
+	s.BroadcastStatus()
+	publishAdminExit("admin-test", 2)
+	publishAdminEvent(adminEvent{Type: "recording_kept", Recording: "rec-1"})
+
+	var got []string
+	for len(got) < 6 {
+		ev := readAdminEvent(t, conn)
+		switch ev.Type {
+		case "status":
+			got = append(got, ev.Type+" "+ev.Info.Name)
+		case "agent_state":
+			got = append(got, ev.Type+" "+ev.State)
+		case "attention":
+			got = append(got, ev.Type+" "+ev.Reason)
+		case "session_exited":
+			got = append(got, ev.Type+" "+ev.Session)
+		case "recording_kept":
+			got = append(got, ev.Type+" "+ev.Recording)
+		default:
+			t.Errorf("unexpected %+v", ev)
+		}
+	}
+	want := []string{"status fix login", "agent_state busy", "status fix login", "session_exited admin-test", "attention exited", "recording_kept rec-1"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAdminEventsDropSlowSubscriber(t *testing.T) {
+	ch := subscribeAdminEvents()
+	defer unsubscribeAdminEvents(ch)
+	for i := 0; i <= adminEventQueue; i++ {
+		publishAdminEvent(adminEvent{Type: "recording_kept", Recording: "rec"})
+	}
+	n := 0
+	for data := range ch {
+		var ev adminEvent
+		if err := json.Unmarshal(data, &ev); err != nil || ev.Type != "recording_kept" {
+			t.Fatalf("event %s, %v", data, err)
+		}
+		n++
+	}
+	if n != adminEventQueue {
+		t.Errorf("%d events before the drop, want %d", n, adminEventQueue)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastAgentChatChecks shrinks the sidecar's intervals for the test.
+func fastAgentChatChecks(t *testing.T, grace time.Duration) {
+	t.Helper()
+	oldInterval, oldGrace, oldBackoff := agentChatCheckInterval, agentChatStartGrace, agentChatMaxBackoff
+	agentChatCheckInterval, agentChatStartGrace, agentChatMaxBackoff = 20*time.Millisecond, grace, 50*time.Millisecond
+	t.Cleanup(func() {
+		agentChatCheckInterval, agentChatStartGrace, agentChatMaxBackoff = oldInterval, oldGrace, oldBackoff
+	})
+}
+
+// runSidecar runs a's loop until the test ends, and waits for it to return
+// so the loop never outlives fastAgentChatChecks.
+func runSidecar(t *testing.T, a *agentChatSidecar, onChange func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if a.command == "" {
+			a.watch(ctx, onChange)
+		} else {
+			a.supervise(ctx, onChange)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func waitForState(t *testing.T, a *agentChatSidecar, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Status() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %q, want %q", a.Status(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAgentChatSidecarWatchesPort(t *testing.T) {
+	fastAgentChatChecks(t, time.Minute)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	a := newAgentChatSidecar("s1", port, "", t.TempDir(), nil)
+	var changes atomic.Int32
+	runSidecar(t, a, func() { changes.Add(1) })
+	waitForState(t, a, agentChatListening)
+	ln.Close()
+	waitForState(t, a, agentChatNoListener)
+	if changes.Load() < 2 {
+		t.Errorf("onChange called %d times, want >= 2", changes.Load())
+	}
+}
+
+func TestAgentChatSidecarRestartsSilentServer(t *testing.T) {
+	fastAgentChatChecks(t, 100*time.Millisecond)
+	dir := t.TempDir()
+	starts := filepath.Join(dir, "starts")
+	// Never listens on the port: killed after the grace, then restarted.
+	a := newAgentChatSidecar("s1", 1, "echo x >> starts; exec sleep 30", dir, os.Environ())
+	runSidecar(t, a, func() {})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(starts)
+		if strings.Count(string(data), "x") >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server started %d times, want a restart", strings.Count(string(data), "x"))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAgentChatSidecarRestartsExitedServer(t *testing.T) {
+	fastAgentChatChecks(t, time.Minute)
+	dir := t.TempDir()
+	a := newAgentChatSidecar("s1", 1, "echo x >> starts; exit 1", dir, os.Environ())
+	runSidecar(t, a, func() {})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(filepath.Join(dir, "starts"))
+		if strings.Count(string(data), "x") >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server started %d times, want restarts", strings.Count(string(data), "x"))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStatusPayloadAgentChatStatus(t *testing.T) {
+	s := &Session{UUID: "abcdef", SessionMode: "chat", AgentChatPort: 4001}
+	if _, ok := s.buildStatusPayload(0, 24, 80)["agentChatStatus"]; ok {
+		t.Error("agentChatStatus sent without a sidecar")
+	}
+	s.AgentChat = newAgentChatSidecar(s.UUID, 4001, "", "", nil)
+	if got := s.buildStatusPayload(0, 24, 80)["agentChatStatus"]; got != agentChatNoListener {
+		t.Errorf("agentChatStatus = %v, want %q", got, agentChatNoListener)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hinshun/vt10x"
+)
+
+func withReadyPatterns(t *testing.T, patterns map[string]*regexp.Regexp) {
+	t.Helper()
+	saved := readyPatterns
+	readyPatterns = patterns
+	t.Cleanup(func() { readyPatterns = saved })
+}
+
+func TestLoadReadyPatterns(t *testing.T) {
+	withReadyPatterns(t, nil)
+	t.Setenv("SWE_READY_PATTERN_CUSTOM", `^ready>`)
+	t.Setenv("SWE_READY_PATTERN_GOOSE", "none")
+	if err := loadReadyPatterns(); err != nil {
+		t.Fatal(err)
+	}
+	if re := readyPatterns["claude"]; re == nil || !re.MatchString("  ? for shortcuts") {
+		t.Errorf("claude = %v", re)
+	}
+	if re := readyPatterns["custom"]; re == nil || re.String() != "^ready>" {
+		t.Errorf("custom = %v", re)
+	}
+	if re := readyPatterns["goose"]; re != nil {
+		t.Errorf("goose = %v, want none", re)
+	}
+	if re := readyPatterns["aider"]; re == nil || !re.MatchString("Added main.go\nask> \n") || re.MatchString("a -> b\n") {
+		t.Errorf("aider = %v", re)
+	}
+
+	t.Setenv("SWE_READY_PATTERN_CLAUDE", "(")
+	if err := loadReadyPatterns(); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
+
+func TestWaitForAgentReadyPattern(t *testing.T) {
+	withReadyPatterns(t, map[string]*regexp.Regexp{"claude": regexp.MustCompile(`\? for shortcuts`)})
+	s := &Session{UUID: "ready-test", Assistant: "claude", vt: vt10x.New(vt10x.WithSize(40, 5))}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		ptyOutput(s, "Welcome to Claude\r\n")
+		time.Sleep(200 * time.Millisecond)
+		ptyOutput(s, "> \r\n  ? for shortcuts")
+	}()
+	start := time.Now()
+	if !s.waitForAgentReady(5 * time.Second) {
+		t.Fatal("pattern not seen")
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > agentQuietSettle {
+		t.Errorf("ready after %v", elapsed)
+	}
+}
+
+func TestWaitForAgentReadyFallsBackToQuiet(t *testing.T) {
+	withReadyPatterns(t, nil)
+	s := &Session{UUID: "ready-test"}
+	start := time.Now()
+	s.waitForAgentReady(400 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("returned after %v with no output yet", elapsed)
+	}
+
+	s.ring.Write([]byte("$"))
+	start = time.Now()
+	if s.waitForAgentReady(5*time.Second) || time.Since(start) > agentQuietSettle+time.Second {
+		t.Errorf("quiet output took %v", time.Since(start))
+	}
+}
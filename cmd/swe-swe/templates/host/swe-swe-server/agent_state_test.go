@@ -0,0 +1,77 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hinshun/vt10x"
+)
+
+func withApprovalPatterns(t *testing.T, patterns map[string]*regexp.Regexp) {
+	t.Helper()
+	saved := approvalPatterns
+	approvalPatterns = patterns
+	t.Cleanup(func() { approvalPatterns = saved })
+}
+
+func TestLoadApprovalPatterns(t *testing.T) {
+	withApprovalPatterns(t, nil)
+	t.Setenv("SWE_APPROVAL_PATTERN_CODEX", "none")
+	if err := loadApprovalPatterns(); err != nil {
+		t.Fatal(err)
+	}
+	if re := approvalPatterns["claude"]; re == nil || !re.MatchString(" Do you want to proceed?\n ❯ 1. Yes") {
+		t.Errorf("claude = %v", re)
+	}
+	if re := approvalPatterns["aider"]; re == nil || !re.MatchString("Create new file? (Y)es/(N)o [Yes]:") {
+		t.Errorf("aider = %v", re)
+	}
+	if re := approvalPatterns["codex"]; re != nil {
+		t.Errorf("codex = %v, want none", re)
+	}
+	t.Setenv("SWE_APPROVAL_PATTERN_CLAUDE", "[")
+	if err := loadApprovalPatterns(); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
+
+func TestComputeAgentState(t *testing.T) {
+	withApprovalPatterns(t, map[string]*regexp.Regexp{"claude": regexp.MustCompile(`Do you want to [^\n]*\?`)})
+	s := &Session{UUID: "state-test", Assistant: "claude", vt: vt10x.New(vt10x.WithSize(40, 5))}
+	now := time.Now()
+
+	if got := s.computeAgentState(now); got != agentStateBusy {
+		t.Errorf("before any output: %q", got)
+	}
+	ptyOutput(s, "Thinking...")
+	if got := s.computeAgentState(time.Now()); got != agentStateBusy {
+		t.Errorf("just after output: %q", got)
+	}
+	if got := s.computeAgentState(time.Now().Add(agentBusyWindow)); got != agentStateIdle {
+		t.Errorf("after quiet: %q", got)
+	}
+	ptyOutput(s, "\r\nDo you want to proceed?\r\n> 1. Yes")
+	if got := s.computeAgentState(time.Now().Add(agentBusyWindow)); got != agentStateWaitingApproval {
+		t.Errorf("at approval prompt: %q", got)
+	}
+
+	s.Assistant = "goose" // no pattern
+	if got := s.computeAgentState(time.Now().Add(agentBusyWindow)); got != agentStateIdle {
+		t.Errorf("no pattern: %q", got)
+	}
+}
+
+func TestAgentStateInStatus(t *testing.T) {
+	s := &Session{UUID: "state-test"}
+	if _, ok := s.buildStatusPayload(0, 24, 80)["agentState"]; ok {
+		t.Error("agentState sent before it is known")
+	}
+	s.agentState.Store(agentStateWaitingApproval)
+	if got := s.buildStatusPayload(0, 24, 80)["agentState"]; got != agentStateWaitingApproval {
+		t.Errorf("agentState = %v", got)
+	}
+	if got := agentStateSummaryStatus(s.AgentState()); got != "amber" {
+		t.Errorf("summary status = %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestParseApprovalScreen(t *testing.T) {
+	screen := `> clean up the build
+● I'll remove the build directory.
+
+╭──────────────────────────────────────────────────────────╮
+│ Bash command                                             │
+│                                                          │
+│   rm -rf build                                           │
+│   Remove build dir                                       │
+│                                                          │
+│ Do you want to proceed?                                  │
+│ ❯ 1. Yes                                                 │
+│   2. Yes, and don't ask again for rm commands            │
+│   3. No, and tell Claude what to do differently (esc)    │
+╰──────────────────────────────────────────────────────────╯
+`
+	req, ok := parseApprovalScreen(screen, regexp.MustCompile(`Do you want to [^\n]*\?`))
+	if !ok || req.Question != "Do you want to proceed?" {
+		t.Fatalf("question = %q, %v", req.Question, ok)
+	}
+	if want := []string{"Bash command", "rm -rf build", "Remove build dir"}; !slices.Equal(req.Context, want) {
+		t.Errorf("context = %q, want %q", req.Context, want)
+	}
+	if want := []string{"1. Yes", "2. Yes, and don't ask again for rm commands", "3. No, and tell Claude what to do differently (esc)"}; !slices.Equal(req.Options, want) {
+		t.Errorf("options = %q, want %q", req.Options, want)
+	}
+
+	req, ok = parseApprovalScreen("aider> /run make\n\n\nmake: ok\nAdd command output to the chat? (Y)es/(N)o [Yes]:\n", regexp.MustCompile(`\(Y\)es/\(N\)o`))
+	if !ok || req.Question != "Add command output to the chat? (Y)es/(N)o [Yes]:" || !slices.Equal(req.Context, []string{"make: ok"}) || req.Options != nil {
+		t.Errorf("aider: %+v, %v", req, ok)
+	}
+	if _, ok := parseApprovalScreen("idle\n", regexp.MustCompile(`proceed\?`)); ok {
+		t.Error("no prompt on screen: want not ok")
+	}
+}
+
+func TestLoadApprovalKeys(t *testing.T) {
+	t.Cleanup(func() { approvalKeys = nil })
+	t.Setenv("SWE_APPROVE_KEYS_CUSTOM", `yes\r`)
+	t.Setenv("SWE_DENY_KEYS_CLAUDE", "none")
+	if err := loadApprovalKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if got := approvalKeys["claude"]; got != (approvalKeySet{Approve: "1"}) {
+		t.Errorf("claude = %q", got)
+	}
+	if got := approvalKeys["custom"]; got != (approvalKeySet{Approve: "yes\r"}) {
+		t.Errorf("custom = %q", got)
+	}
+	if got := approvalKeys["aider"]; got != (approvalKeySet{Approve: "y\r", Deny: "n\r"}) {
+		t.Errorf("aider = %q", got)
+	}
+	t.Setenv("SWE_DENY_KEYS_CLAUDE", `\q`)
+	if err := loadApprovalKeys(); err == nil {
+		t.Error("bad escape: want an error")
+	}
+}
+
+func TestAnswerApproval(t *testing.T) {
+	t.Cleanup(func() { approvalKeys = nil })
+	approvalKeys = map[string]approvalKeySet{"claude": {Approve: "1", Deny: "\x1b"}, "goose": {Approve: "\r"}}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := &Session{UUID: "approval-test", Assistant: "claude", PTY: w}
+
+	if err := s.answerApproval("approve", "", "ana"); err != errNoApproval {
+		t.Errorf("nothing pending: %v", err)
+	}
+	s.approval.pending = &approvalRequest{ID: "7", Question: "Do you want to proceed?"}
+	if s.pendingApproval() == nil {
+		t.Error("pendingApproval: want the request")
+	}
+	if err := s.answerApproval("deny", "6", "ana"); err != errApprovalStale {
+		t.Errorf("old id: %v", err)
+	}
+	if err := s.answerApproval("deny", "7", "ana"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.answerApproval("approve", "7", "bob"); err != errApprovalStale {
+		t.Errorf("second answer: %v", err)
+	}
+	if s.pendingApproval() != nil {
+		t.Error("answered request still pending")
+	}
+	w.Close()
+	if got, _ := io.ReadAll(r); string(got) != "\x1b" {
+		t.Errorf("typed %q, want Esc", got)
+	}
+
+	s.Assistant = "goose"
+	if err := s.answerApproval("deny", "", "ana"); err != errApprovalNoKeys {
+		t.Errorf("goose deny: %v", err)
+	}
+}
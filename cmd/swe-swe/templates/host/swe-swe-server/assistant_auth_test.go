@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckAuth(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("AGENT_API_KEY", "")
+	t.Setenv("AGENT_HOME", "")
+	t.Setenv("SWE_AUTH_CHECK_AGENT", "")
+	cfg := AssistantConfig{Name: "Agent", Binary: "agent", AuthEnv: []string{"AGENT_API_KEY"}, AuthFiles: []string{"$AGENT_HOME/auth.json", "~/.agent/auth.json"}}
+
+	// The unset $AGENT_HOME path is skipped.
+	if got := checkAuth(cfg); got != (assistantAuth{State: authLoggedOut, Detail: "no AGENT_API_KEY, ~/.agent/auth.json"}) {
+		t.Errorf("nothing set: %+v", got)
+	}
+	creds := filepath.Join(home, ".agent", "auth.json")
+	os.MkdirAll(filepath.Dir(creds), 0o755)
+	os.WriteFile(creds, nil, 0o600)
+	if got := checkAuth(cfg); got.State != authLoggedOut {
+		t.Errorf("empty credentials file: %+v", got)
+	}
+	os.WriteFile(creds, []byte(`{"token":"x"}`), 0o600)
+	if got := checkAuth(cfg); got.State != authOK {
+		t.Errorf("credentials file: %+v", got)
+	}
+	os.Remove(creds)
+	t.Setenv("AGENT_API_KEY", "sk-test")
+	if got := checkAuth(cfg); got.State != authOK {
+		t.Errorf("API key: %+v", got)
+	}
+	if got := checkAuth(AssistantConfig{Binary: "agent"}); got.State != "" {
+		t.Errorf("no check: %+v", got)
+	}
+
+	for override, want := range map[string]string{
+		"none":                     "",
+		"file:~/missing,~/.agent2": authLoggedOut,
+		"file:~/.agent2":           authLoggedOut,
+		"true":                     authOK,
+		"exit 3":                   authLoggedOut,
+	} {
+		t.Setenv("SWE_AUTH_CHECK_AGENT", override)
+		if got := checkAuth(cfg); got.State != want {
+			t.Errorf("%q: %+v, want %q", override, got, want)
+		}
+	}
+	os.WriteFile(filepath.Join(home, ".agent2"), []byte("x"), 0o600)
+	t.Setenv("SWE_AUTH_CHECK_AGENT", "file:~/missing, ~/.agent2")
+	if got := checkAuth(cfg); got.State != authOK {
+		t.Errorf("file override: %+v", got)
+	}
+}
+
+func TestNewSessionRefusesLoggedOutAgent(t *testing.T) {
+	withSessionLimits(t, "", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLAUDE_TEST_KEY", "")
+	availableAssistants = []AssistantConfig{{Name: "Claude", Binary: "claude", AuthEnv: []string{"CLAUDE_TEST_KEY"}}}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/session/new", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handleNewSessionAPI(rr, req)
+		return rr
+	}
+
+	rr := post(url.Values{"assistant": {"claude"}})
+	var body struct{ Error, Message, Assistant, Detail string }
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusConflict {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if body.Error != "not_logged_in" || body.Assistant != "claude" || body.Detail != "no CLAUDE_TEST_KEY" || !strings.HasPrefix(body.Message, "Claude does not look logged in") {
+		t.Errorf("body = %+v", body)
+	}
+
+	if rr := post(url.Values{"assistant": {"claude"}, "auth_ack": {"1"}}); rr.Code != http.StatusFound {
+		t.Errorf("auth_ack=1: status %d: %s", rr.Code, rr.Body.String())
+	}
+	t.Setenv("CLAUDE_TEST_KEY", "sk-test")
+	if rr := post(url.Values{"assistant": {"claude"}}); rr.Code != http.StatusFound {
+		t.Errorf("logged in: status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAuthCheckAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/auth-check?assistant=claude", nil))
+	if !strings.Contains(rr.Body.String(), `"auth":"ok"`) {
+		t.Errorf("auth-check: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	handleAuthCheckAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/auth-check?assistant=nope", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown assistant: %d", rr.Code)
+	}
+}
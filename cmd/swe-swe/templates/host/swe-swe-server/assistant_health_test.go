@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadAssistantCheckInterval(t *testing.T) {
+	for v, want := range map[string]time.Duration{"": defaultAssistantCheckInterval, "off": 0, "0": 0, "30m": 30 * time.Minute} {
+		t.Setenv("SWE_ASSISTANT_CHECK_INTERVAL", v)
+		if err := loadAssistantCheckInterval(); err != nil || assistantCheckInterval != want {
+			t.Errorf("%q: %v, %v", v, assistantCheckInterval, err)
+		}
+	}
+	for _, v := range []string{"10s", "often"} {
+		t.Setenv("SWE_ASSISTANT_CHECK_INTERVAL", v)
+		if err := loadAssistantCheckInterval(); err == nil {
+			t.Errorf("%q: want an error", v)
+		}
+	}
+	assistantCheckInterval = defaultAssistantCheckInterval
+}
+
+func TestAssistantVersion(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "agent")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\necho\necho '  1.2.3 (Agent)  '\necho more\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if v := assistantVersion(bin); v != "1.2.3 (Agent)" {
+		t.Errorf("version = %q", v)
+	}
+	if v := assistantVersion(filepath.Join(t.TempDir(), "missing")); v != "" {
+		t.Errorf("missing binary: version = %q", v)
+	}
+}
+
+func TestRecordAssistantStatuses(t *testing.T) {
+	saved := installedAssistants()
+	defer func() {
+		setAvailableAssistants(saved)
+		assistantHealth.statuses = map[string]assistantStatus{}
+	}()
+	assistantHealth.statuses = map[string]assistantStatus{}
+	events := subscribeAdminEvents()
+	defer unsubscribeAdminEvents(events)
+
+	offered := func() (binaries []string) {
+		for _, a := range installedAssistants() {
+			binaries = append(binaries, a.Binary)
+		}
+		return binaries
+	}
+	claude := func(installed bool, version string) []assistantStatus {
+		return []assistantStatus{{Binary: "claude", Installed: installed, Version: version}}
+	}
+
+	// The first check only records.
+	recordAssistantStatuses(claude(true, "1.0"))
+	if !slices.Contains(offered(), "claude") || slices.Contains(offered(), "gemini") {
+		t.Errorf("offered %v", offered())
+	}
+	// A failed --version keeps the version last seen, and is not a change.
+	recordAssistantStatuses(claude(true, ""))
+	if v := installedAssistantVersion("claude"); v != "1.0" {
+		t.Errorf("version after a failed --version = %q", v)
+	}
+	recordAssistantStatuses(claude(true, "1.1"))
+	recordAssistantStatuses(claude(false, ""))
+	if slices.Contains(offered(), "claude") || !slices.Contains(offered(), "shell") {
+		t.Errorf("offered %v after claude was removed", offered())
+	}
+	recordAssistantStatuses(claude(true, "1.2"))
+
+	var got []string
+	for len(events) > 0 {
+		var ev adminEvent
+		json.Unmarshal(<-events, &ev)
+		got = append(got, ev.Type+" "+ev.Assistant+" "+ev.Reason+" "+ev.From+">"+ev.To)
+	}
+	want := []string{
+		"assistant_changed claude version 1.0>1.1",
+		"assistant_changed claude missing 1.1>",
+		"assistant_changed claude installed >1.2",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,322 @@
+// auth_roles.go -- named users, roles, and what each role may do.
+//
+// With just SWE_SWE_PASSWORD, whoever knows the password can do everything.
+// SWE_AUTH_USERS adds named users, each with their own password and a role:
+//
+//	SWE_AUTH_USERS="alice:admin:pw-a,bob:developer:pw-b,carol:viewer:pw-c"
+//
+// (config file: "auth": {"users": ["alice:admin:pw-a", ...]}). The login form
+// is unchanged: the password alone says who is logging in, so every password
+// must be unique. The master password still works and logs in as the admin
+// user "admin", which is why no other user may take that name. A user's cookie
+// names them ("timestamp|user:NAME|hmac", auth.go) and stops validating when
+// they are removed from the list; a role change applies at their next request.
+//
+// A role is a set of permissions:
+//
+//	input           type into sessions, start them, and make any request
+//	                that is not a read (viewers get GET/HEAD/OPTIONS only)
+//	yolo            toggle YOLO mode
+//	end-any         end sessions another user started
+//	recordings-any  delete kept recordings of another user's sessions
+//	admin           the server-wide endpoints (adminOnlyPath): config, log
+//	                level, version check, shutdown/reboot, exec, usage
+//	                reports, data export/import/purge, backups and /ws/admin
+//
+// The built-in roles are viewer (nothing), developer (input, yolo) and admin
+// (everything). SWE_AUTH_ROLES redefines viewer or developer, or adds roles:
+//
+//	SWE_AUTH_ROLES="developer=input+yolo+end-any,ci=input"
+//
+// admin cannot be redefined, so a typo cannot lock every admin out.
+//
+// Sessions record who started them (RecordingMetadata.Owner) and recordings
+// who kept them (KeptBy). A session or recording with no owner -- started by
+// a webhook, an agent, or before users existed -- belongs to nobody, so
+// end-any and recordings-any only gate other users' ones. Shared-session
+// guests are not users: what they may do is set by their share
+// (session_share.go, session_share_links.go). Without SWE_SWE_PASSWORD there
+// is no login and everyone is an admin.
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	permInput         = "input"
+	permYolo          = "yolo"
+	permEndAny        = "end-any"
+	permRecordingsAny = "recordings-any"
+	permAdmin         = "admin"
+
+	roleAdmin     = "admin"
+	roleDeveloper = "developer"
+	roleViewer    = "viewer"
+
+	// authMasterUser is who the master password logs in as.
+	authMasterUser = "admin"
+	// authUserScopePrefix marks the scope field of a user's cookie.
+	authUserScopePrefix = "user:"
+)
+
+var allPerms = []string{permInput, permYolo, permEndAny, permRecordingsAny, permAdmin}
+
+// authPerms is a role's permission set.
+type authPerms map[string]bool
+
+func newAuthPerms(perms ...string) authPerms {
+	p := authPerms{}
+	for _, perm := range perms {
+		p[perm] = true
+	}
+	return p
+}
+
+// authUser is one SWE_AUTH_USERS entry.
+type authUser struct {
+	Name     string
+	Role     string
+	password string
+}
+
+var (
+	defaultAuthRoles = map[string]authPerms{
+		roleAdmin:     newAuthPerms(allPerms...),
+		roleDeveloper: newAuthPerms(permInput, permYolo),
+		roleViewer:    newAuthPerms(),
+	}
+	authRoles = defaultAuthRoles
+	authUsers []authUser
+
+	authUserNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+)
+
+// loadAuthUsers applies SWE_AUTH_ROLES and SWE_AUTH_USERS.
+func loadAuthUsers() error {
+	roles, err := parseAuthRoles(os.Getenv("SWE_AUTH_ROLES"))
+	if err != nil {
+		return fmt.Errorf("SWE_AUTH_ROLES: %w", err)
+	}
+	users, err := parseAuthUsers(os.Getenv("SWE_AUTH_USERS"), roles, os.Getenv("SWE_SWE_PASSWORD"))
+	if err != nil {
+		return fmt.Errorf("SWE_AUTH_USERS: %w", err)
+	}
+	authRoles, authUsers = roles, users
+	if len(users) > 0 {
+		names := make([]string, len(users))
+		for i, u := range users {
+			names[i] = u.Name + " (" + u.Role + ")"
+		}
+		log.Printf("Auth users: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// parseAuthRoles parses "role=perm+perm,role=" on top of the built-in roles.
+func parseAuthRoles(s string) (map[string]authPerms, error) {
+	roles := make(map[string]authPerms, len(defaultAuthRoles))
+	for name, perms := range defaultAuthRoles {
+		roles[name] = perms
+	}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !authUserNameRe.MatchString(name) {
+			return nil, fmt.Errorf("%q: want role=perm+perm", entry)
+		}
+		if name == roleAdmin {
+			return nil, fmt.Errorf("the admin role cannot be redefined")
+		}
+		perms := authPerms{}
+		for _, perm := range strings.Split(list, "+") {
+			perm = strings.TrimSpace(perm)
+			if perm == "" {
+				continue
+			}
+			if !newAuthPerms(allPerms...)[perm] {
+				return nil, fmt.Errorf("%s: unknown permission %q (want %s)", name, perm, strings.Join(allPerms, ", "))
+			}
+			perms[perm] = true
+		}
+		roles[name] = perms
+	}
+	return roles, nil
+}
+
+// parseAuthUsers parses "name:role:password,..." against roles.
+func parseAuthUsers(s string, roles map[string]authPerms, master string) ([]authUser, error) {
+	var users []authUser
+	names := map[string]bool{}
+	passwords := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[2] == "" {
+			return nil, fmt.Errorf("entry %d: want name:role:password", len(users)+1)
+		}
+		u := authUser{Name: parts[0], Role: parts[1], password: parts[2]}
+		switch {
+		case !authUserNameRe.MatchString(u.Name):
+			return nil, fmt.Errorf("invalid user name %q", u.Name)
+		case u.Name == authMasterUser:
+			return nil, fmt.Errorf("the user name %q is the master password's", authMasterUser)
+		case names[u.Name]:
+			return nil, fmt.Errorf("user %s is listed twice", u.Name)
+		case roles[u.Role] == nil:
+			return nil, fmt.Errorf("user %s: unknown role %q", u.Name, u.Role)
+		case passwords[u.password] || u.password == master:
+			return nil, fmt.Errorf("user %s: password is not unique", u.Name)
+		}
+		names[u.Name], passwords[u.password] = true, true
+		users = append(users, u)
+	}
+	if len(users) > 0 && master == "" {
+		return nil, fmt.Errorf("users need embedded auth (SWE_SWE_PASSWORD)")
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+// lookupAuthUser returns the named user, or nil.
+func lookupAuthUser(name string) *authUser {
+	for i := range authUsers {
+		if authUsers[i].Name == name {
+			return &authUsers[i]
+		}
+	}
+	return nil
+}
+
+// authUserByPassword returns the user whose password this is, or nil. Every
+// password is compared, so the time taken does not say which one matched.
+func authUserByPassword(password string) *authUser {
+	var found *authUser
+	for i := range authUsers {
+		if subtle.ConstantTimeCompare([]byte(password), []byte(authUsers[i].password)) == 1 {
+			found = &authUsers[i]
+		}
+	}
+	return found
+}
+
+// authSignUserCookie is authSignScopedCookie for a named user.
+func authSignUserCookie(secret, name string) string {
+	return authSignScopedCookie(secret, authUserScopePrefix+name)
+}
+
+// authIdentity is who made a request and what their role lets them do.
+type authIdentity struct {
+	User  string // "" when auth is off or for a shared-session guest
+	Role  string
+	perms authPerms
+}
+
+func (id authIdentity) can(perm string) bool { return id.perms[perm] }
+
+// identityFor resolves a verified cookie's user ("" for the master password).
+func identityFor(user string) authIdentity {
+	if user == "" {
+		return authIdentity{User: authMasterUser, Role: roleAdmin, perms: authRoles[roleAdmin]}
+	}
+	u := lookupAuthUser(user)
+	if u == nil {
+		return authIdentity{User: user, Role: roleViewer, perms: authPerms{}}
+	}
+	return authIdentity{User: u.Name, Role: u.Role, perms: authRoles[u.Role]}
+}
+
+// requestIdentity is who made r, read from its auth cookie.
+func requestIdentity(r *http.Request) authIdentity {
+	everything := authIdentity{Role: roleAdmin, perms: newAuthPerms(allPerms...)}
+	secret := os.Getenv("SWE_SWE_PASSWORD")
+	if secret == "" {
+		return everything
+	}
+	cookie, err := r.Cookie(authCookieName)
+	if err != nil {
+		// authMiddleware let it through: an exempt path with its own auth.
+		return everything
+	}
+	scope, _, user, valid := authVerifyCookieUser(cookie.Value, secret)
+	if !valid || scope != "" {
+		// A guest is limited by their share instead.
+		return everything
+	}
+	return identityFor(user)
+}
+
+// adminOnlyPath reports whether path is a server-wide endpoint that needs the
+// admin permission (and is never open to a shared-session guest).
+func adminOnlyPath(path string) bool {
+	switch {
+	case path == "/api/server/shutdown",
+		path == "/api/server/reboot",
+		path == "/api/exec",
+		path == "/api/config",
+		path == "/api/version/check",
+		path == "/api/log-level",
+		strings.HasPrefix(path, "/api/reports/"),
+		strings.HasPrefix(path, "/api/data/"),
+		path == "/api/backups",
+		path == "/api/secrets",
+		strings.HasPrefix(path, "/api/secrets/"),
+		path == adminEventsPath:
+		return true
+	}
+	return false
+}
+
+// allowsRequest is the role policy for an HTTP request. path is "" where no
+// path of ours applies (a preview subdomain, the per-port proxies); forwarded
+// is as in shareViewOnlyAllowed.
+func (id authIdentity) allowsRequest(method, path string, forwarded bool) bool {
+	if adminOnlyPath(path) && !id.can(permAdmin) {
+		return false
+	}
+	if !id.can(permInput) {
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return !forwarded
+		}
+		return false
+	}
+	return true
+}
+
+// mayEnd reports whether id may end sess.
+func (id authIdentity) mayEnd(sess *Session) bool {
+	if id.can(permEndAny) {
+		return true
+	}
+	sess.mu.RLock()
+	owner := sess.Owner
+	sess.mu.RUnlock()
+	return owner == "" || owner == id.User
+}
+
+// mayDeleteRecording reports whether id may delete the recording.
+func (id authIdentity) mayDeleteRecording(meta *RecordingMetadata) bool {
+	if id.can(permRecordingsAny) || meta == nil || meta.KeptAt == nil {
+		return true
+	}
+	owner := meta.Owner
+	if owner == "" {
+		owner = meta.KeptBy
+	}
+	return owner == "" || owner == id.User
+}
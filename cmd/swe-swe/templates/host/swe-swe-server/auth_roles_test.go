@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withAuthUsers configures users and roles for the test.
+func withAuthUsers(t *testing.T, master, users, roles string) {
+	t.Helper()
+	savedRoles, savedUsers := authRoles, authUsers
+	t.Cleanup(func() { authRoles, authUsers = savedRoles, savedUsers })
+	t.Setenv("SWE_SWE_PASSWORD", master)
+	t.Setenv("SWE_AUTH_USERS", users)
+	t.Setenv("SWE_AUTH_ROLES", roles)
+	if err := loadAuthUsers(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadAuthUsersRejects(t *testing.T) {
+	savedRoles, savedUsers := authRoles, authUsers
+	t.Cleanup(func() { authRoles, authUsers = savedRoles, savedUsers })
+	for _, tc := range []struct{ master, users, roles string }{
+		{"master", "alice:developer", ""},
+		{"master", "alice:developer:", ""},
+		{"master", "al ice:developer:pw", ""},
+		{"master", "admin:developer:pw", ""},
+		{"master", "alice:developer:pw,alice:viewer:pw2", ""},
+		{"master", "alice:owner:pw", ""},
+		{"master", "alice:developer:pw,bob:viewer:pw", ""},
+		{"master", "alice:developer:master", ""},
+		{"", "alice:developer:pw", ""},
+		{"master", "", "admin=input"},
+		{"master", "", "developer=input+sudo"},
+		{"master", "", "developer"},
+	} {
+		t.Setenv("SWE_SWE_PASSWORD", tc.master)
+		t.Setenv("SWE_AUTH_USERS", tc.users)
+		t.Setenv("SWE_AUTH_ROLES", tc.roles)
+		if err := loadAuthUsers(); err == nil {
+			t.Errorf("%+v accepted", tc)
+		}
+	}
+}
+
+func TestAuthUserLoginAndCookie(t *testing.T) {
+	withAuthUsers(t, "master", "bob:developer:pw-b, carol:ci:pw-c", "ci=input+end-any")
+
+	form := url.Values{"password": {"pw-c"}}
+	req := httptest.NewRequest(http.MethodPost, "/swe-swe-auth/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	authLoginPostHandler(rr, req, "master")
+	if rr.Code != http.StatusFound || len(rr.Result().Cookies()) != 1 {
+		t.Fatalf("login: status %d", rr.Code)
+	}
+	cookie := rr.Result().Cookies()[0].Value
+	scope, _, user, valid := authVerifyCookieUser(cookie, "master")
+	if !valid || scope != "" || user != "carol" {
+		t.Fatalf("cookie = scope %q user %q valid %v", scope, user, valid)
+	}
+	if id := identityFor(user); id.Role != "ci" || !id.can(permEndAny) || id.can(permYolo) {
+		t.Errorf("identity = %+v", id)
+	}
+	// Still a full user for everything that only knows scopes.
+	if s, ok := authVerifyCookieScoped(cookie, "master"); !ok || s != "" {
+		t.Errorf("scoped verify = %q, %v", s, ok)
+	}
+
+	// Removing the user kills the cookie.
+	t.Setenv("SWE_AUTH_USERS", "bob:developer:pw-b")
+	if err := loadAuthUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if authVerifyCookie(cookie, "master") {
+		t.Error("removed user's cookie still valid")
+	}
+
+	// The master password is the admin.
+	if id := identityFor(""); id.User != authMasterUser || !id.can(permAdmin) {
+		t.Errorf("master identity = %+v", id)
+	}
+}
+
+func TestAuthMiddlewareRoles(t *testing.T) {
+	withAuthUsers(t, "master", "bob:developer:pw-b,carol:viewer:pw-c", "")
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "master")
+
+	for _, tc := range []struct {
+		user, method, path string
+		want               int
+	}{
+		{"carol", http.MethodGet, "/api/recording/list", http.StatusOK},
+		{"carol", http.MethodPost, "/api/session/new", http.StatusForbidden},
+		{"carol", http.MethodGet, "/api/config", http.StatusForbidden},
+		{"bob", http.MethodPost, "/api/session/new", http.StatusOK},
+		{"bob", http.MethodGet, "/api/config", http.StatusForbidden},
+		{"bob", http.MethodPost, "/api/data/import", http.StatusForbidden},
+		{"", http.MethodGet, "/api/config", http.StatusOK},
+	} {
+		value := authSignCookie("master")
+		if tc.user != "" {
+			value = authSignUserCookie("master", tc.user)
+		}
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: value})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("%s %s %s: status %d, want %d", tc.user, tc.method, tc.path, rr.Code, tc.want)
+		}
+	}
+
+	// A viewer gets no WebSocket on the per-port proxies.
+	proxy := requireAuthCookie("master", func(string) bool { return true }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignUserCookie("master", "carol")})
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("viewer proxy WebSocket: status %d", rr.Code)
+	}
+}
+
+func TestRoleOwnership(t *testing.T) {
+	withAuthUsers(t, "master", "bob:developer:pw-b,dave:developer:pw-d", "")
+	bob, dave, admin := identityFor("bob"), identityFor("dave"), identityFor("")
+
+	sess := &Session{Owner: "dave"}
+	if bob.mayEnd(sess) || !dave.mayEnd(sess) || !admin.mayEnd(sess) || !bob.mayEnd(&Session{}) {
+		t.Error("end policy")
+	}
+
+	dir := withTempRecordingsDir(t)
+	kept := time.Now()
+	write := func(uuid string, meta RecordingMetadata) {
+		data, _ := json.Marshal(meta)
+		os.WriteFile(filepath.Join(dir, "session-"+uuid+".metadata.json"), data, 0644)
+	}
+	del := func(uuid, user string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/recording/"+uuid, nil)
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignUserCookie("master", user)})
+		rr := httptest.NewRecorder()
+		handleDeleteRecording(rr, req, uuid)
+		return rr.Code
+	}
+	const davesKept = "11111111-1111-1111-1111-111111111111"
+	const davesRecent = "22222222-2222-2222-2222-222222222222"
+	write(davesKept, RecordingMetadata{UUID: davesKept, Owner: "dave", KeptAt: &kept})
+	write(davesRecent, RecordingMetadata{UUID: davesRecent, Owner: "dave"})
+	if code := del(davesKept, "bob"); code != http.StatusForbidden {
+		t.Errorf("bob deleting dave's kept recording: %d", code)
+	}
+	if code := del(davesRecent, "bob"); code != http.StatusNoContent {
+		t.Errorf("bob deleting dave's recent recording: %d", code)
+	}
+	if code := del(davesKept, "dave"); code != http.StatusNoContent {
+		t.Errorf("dave deleting own kept recording: %d", code)
+	}
+	if !admin.mayDeleteRecording(&RecordingMetadata{Owner: "dave", KeptAt: &kept}) ||
+		dave.mayDeleteRecording(&RecordingMetadata{KeptBy: "bob", KeptAt: &kept}) {
+		t.Error("recording policy")
+	}
+}
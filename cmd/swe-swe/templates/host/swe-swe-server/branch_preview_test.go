@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBranchNameChanges(t *testing.T) {
+	for name, want := range map[string][]string{
+		"fix-login":      nil,
+		"Café Déjà Vu":   {"accents removed", "lowercased", "spaces became hyphens"},
+		"fix login!":     {"spaces became hyphens", "characters other than letters, digits, '_', '.', '/' and '-' became hyphens"},
+		"a--b//c":        {"repeated '-', '/' or '.' collapsed"},
+		"-refs.lock":     {`".lock" suffix removed`, "leading or trailing '-', '/' or '.' removed"},
+		"feature/x_y.z1": nil,
+	} {
+		if got := branchNameChanges(name, deriveBranchName(name)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPreviewBranch(t *testing.T) {
+	dir, run := gitTestRepo(t)
+	run("branch", "-M", "main")
+	run("branch", "feature/x")
+	run("update-ref", "refs/remotes/origin/shipped", "HEAD")
+	worktrees := filepath.Join(filepath.Dir(dir), "worktrees")
+	if err := os.MkdirAll(filepath.Join(worktrees, "old-work"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, branch, workDir, conflict string
+	}{
+		{"Main", "main", dir, "current"},
+		{"old-work", "old-work", filepath.Join(worktrees, "old-work"), "worktree"},
+		{"feature/x", "feature/x", filepath.Join(worktrees, "feature--x"), "local"},
+		{"origin/shipped", "shipped", filepath.Join(worktrees, "shipped"), "remote"},
+		{"Fix Login!", "fix-login", filepath.Join(worktrees, "fix-login"), ""},
+	} {
+		p := previewBranch(tc.name, dir)
+		if !p.Valid || p.Branch != tc.branch || p.WorkDir != tc.workDir || p.Conflict != tc.conflict {
+			t.Errorf("%q: %+v", tc.name, p)
+		}
+		if p.Changed != (tc.name != tc.branch) || p.Changed != (len(p.Changes) > 0) {
+			t.Errorf("%q: changed=%v changes=%q", tc.name, p.Changed, p.Changes)
+		}
+	}
+	if p := previewBranch("origin/shipped", dir); !reflect.DeepEqual(p.Changes, []string{`remote prefix "origin/" dropped`}) {
+		t.Errorf("remote prefix: %q", p.Changes)
+	}
+	if p := previewBranch("!!!", dir); p.Valid || p.Branch != "" || p.Error == "" || p.WorkDir != "" {
+		t.Errorf("unusable name: %+v", p)
+	}
+}
+
+func TestBranchPreviewAPI(t *testing.T) {
+	setPathPolicyRoots(t)
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleBranchPreviewAPI(rr, httptest.NewRequest(http.MethodGet, "/api/branch/preview?"+query, nil))
+		return rr
+	}
+	if rr := get("name="); rr.Code != http.StatusBadRequest {
+		t.Errorf("no name: %d", rr.Code)
+	}
+	if rr := get("name=x&pwd=/etc"); rr.Code != http.StatusBadRequest {
+		t.Errorf("pwd outside the workspace: %d", rr.Code)
+	}
+
+	rr := get("name=Fix+login&pwd=" + filepath.Join(reposDir, "r", "workspace"))
+	var p branchPreview
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if p.Branch != "fix-login" || p.WorkDir != filepath.Join(reposDir, "r", "worktrees", "fix-login") || p.Conflict != "" {
+		t.Errorf("repo preview = %+v", p)
+	}
+
+	rr = get("name=Fix+login")
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.RepoPath != workspaceDir || p.WorkDir != filepath.Join(worktreeDir, "fix-login") {
+		t.Errorf("workspace preview = %+v", p)
+	}
+}
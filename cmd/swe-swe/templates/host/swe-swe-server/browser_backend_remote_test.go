@@ -123,24 +123,19 @@ func atoiOrZero(s string) int {
 // guest->host dials through it, so a shared number turns the proxy into a
 // dial-to-self loop. Remote mode must therefore allocate session CDP ports
 // outside the published range.
-func TestFindAvailablePortQuintupleRemoteCDPOffset(t *testing.T) {
+func TestCDPListenPortRemoteOffset(t *testing.T) {
 	oldBackend := agentViewBackend
 	defer func() { agentViewBackend = oldBackend }()
+	ports := portAssignment{CDP: cdpPortStart}
 
 	agentViewBackend = "local"
-	_, _, _, cdpLocal, _, err := findAvailablePortQuintuple()
-	if err != nil {
-		t.Fatalf("local quintuple: %v", err)
-	}
+	cdpLocal := ports.cdpListenPort()
 	if cdpLocal < cdpPortStart || cdpLocal > cdpPortEnd {
 		t.Errorf("local mode CDP port %d outside %d-%d", cdpLocal, cdpPortStart, cdpPortEnd)
 	}
 
 	agentViewBackend = "http://backend.example:9333"
-	_, _, _, cdpRemote, _, err := findAvailablePortQuintuple()
-	if err != nil {
-		t.Fatalf("remote quintuple: %v", err)
-	}
+	cdpRemote := ports.cdpListenPort()
 	if cdpRemote != cdpLocal+remoteCDPProxyOffset {
 		t.Errorf("remote mode CDP port = %d, want %d (local %d + offset %d)",
 			cdpRemote, cdpLocal+remoteCDPProxyOffset, cdpLocal, remoteCDPProxyOffset)
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func getCapabilities(t *testing.T, user string) serverCapabilities {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/capabilities", nil)
+	if user != "" {
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignUserCookie("master", user)})
+	}
+	rr := httptest.NewRecorder()
+	handleCapabilitiesAPI(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d", rr.Code)
+	}
+	var c serverCapabilities
+	if err := json.Unmarshal(rr.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCapabilitiesFollowRole(t *testing.T) {
+	withAuthUsers(t, "master", "bob:developer:pw-b,carol:viewer:pw-c", "")
+
+	bob := getCapabilities(t, "bob")
+	if bob.Auth.Mode != "users" || bob.Auth.User != "bob" || bob.Auth.Role != "developer" {
+		t.Errorf("bob auth = %+v", bob.Auth)
+	}
+	if !reflect.DeepEqual(bob.Auth.Permissions, []string{permInput, permYolo}) || !bob.Yolo.Allowed || !bob.Uploads.Allowed {
+		t.Errorf("bob = %+v", bob)
+	}
+	carol := getCapabilities(t, "carol")
+	if len(carol.Auth.Permissions) != 0 || carol.Yolo.Allowed || carol.Uploads.Allowed {
+		t.Errorf("carol = %+v", carol)
+	}
+
+	withAuthUsers(t, "", "", "")
+	if open := getCapabilities(t, ""); open.Auth.Mode != "none" || !open.Yolo.Allowed || len(open.Auth.Permissions) != len(allPerms) {
+		t.Errorf("no auth = %+v", open.Auth)
+	}
+}
+
+func TestCapabilitiesPathMode(t *testing.T) {
+	saved := proxyMode
+	t.Cleanup(func() { proxyMode = saved })
+	proxyMode = proxyModePath
+	savedDir := recordingsDir
+	t.Cleanup(func() { recordingsDir = savedDir })
+	recordingsDir = t.TempDir()
+
+	c := getCapabilities(t, "")
+	if c.Preview.ProxyMode != "path" || c.Preview.ProxyPorts || c.FilesTab || c.AgentView {
+		t.Errorf("path mode = %+v", c)
+	}
+	if !c.Recordings.Enabled {
+		t.Error("recordings disabled with a writable recordings dir")
+	}
+	recordingsDir = recordingsDir + "/missing"
+	if getCapabilities(t, "").Recordings.Enabled {
+		t.Error("recordings enabled without a recordings dir")
+	}
+}
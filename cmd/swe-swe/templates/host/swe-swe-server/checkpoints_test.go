@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCheckpointConfig(t *testing.T) {
+	for v, want := range map[string]checkpointConfig{
+		"prompt":            {OnPrompt: true},
+		"10m":               {Interval: 10 * time.Minute},
+		" prompt , 1h ":     {OnPrompt: true, Interval: time.Hour},
+		"prompt,5m,always":  {OnPrompt: true, Interval: 5 * time.Minute, Always: true},
+		"always,prompt,,2m": {OnPrompt: true, Interval: 2 * time.Minute, Always: true},
+	} {
+		got, err := parseCheckpointConfig(v)
+		if err != nil || got != want {
+			t.Errorf("%q = %+v, %v; want %+v", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"always", "30s", "prompt,often", ","} {
+		if _, err := parseCheckpointConfig(v); err == nil {
+			t.Errorf("%q: no error", v)
+		}
+	}
+}
+
+// withCheckpoints sets checkpointSettings for the test.
+func withCheckpoints(t *testing.T, cfg checkpointConfig) {
+	t.Helper()
+	old := checkpointSettings
+	checkpointSettings = cfg
+	t.Cleanup(func() { checkpointSettings = old })
+}
+
+func readTestFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(data)
+}
+
+func TestCheckpointTakeAndRestore(t *testing.T) {
+	dir, run := gitTestRepo(t)
+	withCheckpoints(t, checkpointConfig{OnPrompt: true})
+	if c := newSessionCheckpointer("s", t.TempDir(), func() bool { return true }); c != nil {
+		t.Error("checkpointer for a non-git directory")
+	}
+	c := newSessionCheckpointer("s", dir, func() bool { return true })
+	if c == nil {
+		t.Fatal("no checkpointer")
+	}
+	defer c.Close()
+
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("todo\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "build.log"), []byte("noise\n"), 0644)
+	first, err := c.take("first", false)
+	if err != nil || first == nil {
+		t.Fatalf("take = %+v, %v", first, err)
+	}
+	if again, err := c.take("same", false); again != nil || err != nil {
+		t.Errorf("unchanged tree checkpointed again: %+v, %v", again, err)
+	}
+
+	// The checkpoint left HEAD, the index and git status alone.
+	status, _ := gitInWorkDir(dir, "status", "--porcelain")
+	if !strings.Contains(status, " M main.go") || !strings.Contains(status, "?? notes.txt") {
+		t.Errorf("status after checkpoint:\n%s", status)
+	}
+	if head := gitHeadCommit(dir); head != first.Head {
+		t.Errorf("HEAD %s, checkpoint head %s", head, first.Head)
+	}
+
+	// The agent wrecks the tree: deletes, rewrites, adds, and commits.
+	os.Remove(filepath.Join(dir, "README.md"))
+	os.Remove(filepath.Join(dir, "notes.txt"))
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("broken\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "junk.txt"), []byte("junk\n"), 0644)
+	run("add", "-A")
+	run("commit", "-q", "-m", "oops")
+
+	restored, before, err := c.restore(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Commit != first.Commit || before == nil || before.Reason != "before restore" {
+		t.Errorf("restored %+v, before %+v", restored, before)
+	}
+	for name, want := range map[string]string{
+		"README.md": "hello\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"notes.txt": "todo\n",
+		"build.log": "noise\n", // ignored files are not touched
+	} {
+		if got := readTestFile(t, dir, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "junk.txt")); !os.IsNotExist(err) {
+		t.Errorf("junk.txt survived restore: %v", err)
+	}
+
+	list, err := c.list()
+	if err != nil || len(list) != 2 {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+	if list[0].Reason != "before restore" || list[1].Reason != "first" || list[1].Commit != first.Commit {
+		t.Errorf("list order = %+v", list)
+	}
+
+	// The restore can itself be undone.
+	if _, _, err := c.restore(before.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, dir, "main.go"); got != "broken\n" {
+		t.Errorf("undo restore: main.go = %q", got)
+	}
+	if _, _, err := c.restore("deadbeef"); err != errCheckpointNotFound {
+		t.Errorf("unknown id: %v", err)
+	}
+}
+
+func TestCheckpointOnPrompt(t *testing.T) {
+	dir, _ := gitTestRepo(t)
+	withCheckpoints(t, checkpointConfig{OnPrompt: true})
+	// noteInput checks YOLO mode on its own goroutine; asked says it has.
+	var yolo atomic.Bool
+	asked := make(chan struct{}, 1)
+	c := newSessionCheckpointer("s", dir, func() bool {
+		select {
+		case asked <- struct{}{}:
+		default:
+		}
+		return yolo.Load()
+	})
+	defer c.Close()
+	waitAsked := func() {
+		t.Helper()
+		select {
+		case <-asked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("prompt never reached the YOLO check")
+		}
+	}
+
+	waitForCheckpoints := func(n int) []checkpoint {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			list, _ := c.list()
+			if len(list) >= n || time.Now().After(deadline) {
+				return list
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// Not in YOLO mode: nothing.
+	c.noteInput([]byte("fix it\r"))
+	waitAsked()
+	if list, _ := c.list(); len(list) != 0 {
+		t.Fatalf("checkpointed outside YOLO mode: %+v", list)
+	}
+
+	yolo.Store(true)
+	c.noteInput([]byte("x"))
+	c.noteInput([]byte("\x7fadd tests"))
+	c.noteInput([]byte("\r"))
+	waitAsked()
+	list := waitForCheckpoints(1)
+	if len(list) != 1 || list[0].Reason != "prompt: add tests" {
+		t.Fatalf("list = %+v", list)
+	}
+}
+
+func TestSessionCheckpointsAPI(t *testing.T) {
+	dir, _ := gitTestRepo(t)
+	withCheckpoints(t, checkpointConfig{OnPrompt: true})
+	sess := &Session{}
+	registerTestSession(t, "cp-session", sess)
+	registerTestSession(t, "cp-off", &Session{})
+	sess.Checkpoints = newSessionCheckpointer("cp-session", dir, func() bool { return true })
+	defer sess.Checkpoints.Close()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleSessionCheckpointsAPI(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	if rr := do(http.MethodGet, "/api/session/cp-off/checkpoints"); rr.Code != http.StatusConflict {
+		t.Errorf("off: %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/session/nope/checkpoints"); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown session: %d", rr.Code)
+	}
+
+	rr := do(http.MethodPost, "/api/session/cp-session/checkpoints")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", rr.Code, rr.Body.String())
+	}
+	var cp checkpoint
+	json.Unmarshal(rr.Body.Bytes(), &cp)
+	if cp.Reason != "manual" || len(cp.ID) != 12 {
+		t.Errorf("created %+v", cp)
+	}
+
+	rr = do(http.MethodGet, "/api/session/cp-session/checkpoints")
+	var got struct {
+		Checkpoints []checkpoint `json:"checkpoints"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil || len(got.Checkpoints) != 1 || got.Checkpoints[0].ID != cp.ID {
+		t.Fatalf("GET: %s", rr.Body.String())
+	}
+
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("changed\n"), 0644)
+	if rr := do(http.MethodPost, "/api/session/cp-session/checkpoints/"+cp.ID+"/restore"); rr.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", rr.Code, rr.Body.String())
+	}
+	if got := readTestFile(t, dir, "README.md"); got != "hello\n" {
+		t.Errorf("README.md = %q", got)
+	}
+	if rr := do(http.MethodPost, "/api/session/cp-session/checkpoints/0000000/restore"); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown checkpoint: %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/api/session/cp-session/checkpoints"); rr.Code != http.StatusNotFound {
+		t.Errorf("DELETE: %d", rr.Code)
+	}
+}
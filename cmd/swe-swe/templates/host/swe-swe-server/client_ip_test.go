@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, bad := parseTrustedProxies(" 172.18.0.2, 10.0.0.0/8 ,, nope, fd00::1, 300.1.1.1/8")
+	if len(nets) != 3 || len(bad) != 2 || bad[0] != "nope" {
+		t.Fatalf("nets %v bad %v", nets, bad)
+	}
+	if !nets[0].Contains([]byte{172, 18, 0, 2}) || nets[0].Contains([]byte{172, 18, 0, 3}) {
+		t.Errorf("single IP became %v", nets[0])
+	}
+	if nets, _ := parseTrustedProxies("private"); len(nets) != len(privateProxyNets) {
+		t.Errorf("private = %v", nets)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("SWE_TRUSTED_PROXIES", "172.18.0.0/16, 127.0.0.1")
+	for _, tc := range []struct {
+		name   string
+		peer   string
+		xff    string
+		realIP string
+		want   string
+	}{
+		{"untrusted peer: headers ignored", "203.0.113.7:5555", "10.9.9.9", "10.9.9.8", "203.0.113.7"},
+		{"trusted proxy: forwarded client", "172.18.0.2:40000", "198.51.100.4", "", "198.51.100.4"},
+		{"forged left hop skipped", "172.18.0.2:40000", "6.6.6.6, 198.51.100.4", "", "198.51.100.4"},
+		{"chain of trusted proxies", "127.0.0.1:1", "198.51.100.4, 172.18.0.9", "", "198.51.100.4"},
+		{"X-Real-IP without X-Forwarded-For", "172.18.0.2:40000", "", "198.51.100.5", "198.51.100.5"},
+		{"bad X-Real-IP: the peer", "172.18.0.2:40000", "", "not-an-ip", "172.18.0.2"},
+		{"no headers: the peer", "172.18.0.2:40000", "", "", "172.18.0.2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.peer
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+			if got := clientIP(r); got != tc.want {
+				t.Errorf("clientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTailnetUser(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	r.Header.Set("X-Forwarded-For", "100.101.102.103")
+	r.Header.Set("Tailscale-User-Login", "alice@example.com")
+	r.Header.Set("Tailscale-User-Name", "=?utf-8?q?Alice_M=C3=BCller?=")
+
+	t.Setenv("SWE_TRUSTED_PROXIES", "127.0.0.1")
+	if login, _ := tailnetUser(r); login != "" {
+		t.Errorf("identity read without SWE_TAILSCALE_IDENTITY: %q", login)
+	}
+	t.Setenv("SWE_TAILSCALE_IDENTITY", "1")
+	login, name := tailnetUser(r)
+	if login != "alice@example.com" || name != "Alice M\u00fcller" {
+		t.Errorf("got %q %q", login, name)
+	}
+	if got := clientLabel(r); got != "alice@example.com (100.101.102.103)" {
+		t.Errorf("clientLabel = %q", got)
+	}
+
+	// From a peer that is not a trusted proxy the headers are forged.
+	r.RemoteAddr = "100.64.0.9:50000"
+	if login, _ := tailnetUser(r); login != "" || clientLabel(r) != "100.64.0.9" {
+		t.Errorf("untrusted peer: %q %q", login, clientLabel(r))
+	}
+}
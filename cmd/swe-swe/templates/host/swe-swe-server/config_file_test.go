@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	got, err := parseConfigFile([]byte(`{
+		"listen": {"port": 2000},
+		"ports": {"preview": "3100-3119"},
+		"auth": {"trustForwardedFor": true, "password": null},
+		"agentView": {"tunnel": true},
+		"landing": {"disable": false},
+		"exec": {"allow": ["make test", "git log"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"listen.port":            "2000",
+		"ports.preview":          "3100-3119",
+		"auth.trustForwardedFor": "true",
+		"agentView.tunnel":       "1",
+		"landing.disable":        "false",
+		"exec.allow":             "make test,git log",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestParseConfigFileRejectsUnknownKeys(t *testing.T) {
+	_, err := parseConfigFile([]byte(`{"ports": {"previw": "3000-3019"}, "retention": {"days": 7}}`))
+	if err == nil || !strings.Contains(err.Error(), "ports.previw") || !strings.Contains(err.Error(), "retention.days") {
+		t.Errorf("err = %v, want both unknown keys named", err)
+	}
+	if _, err := parseConfigFile([]byte(`{"exec": {"allow": [1]}}`)); err == nil {
+		t.Error("non-string list entry should fail")
+	}
+	if _, err := parseConfigFile([]byte(`not json`)); err == nil {
+		t.Error("invalid JSON should fail")
+	}
+}
+
+// testConfigFlags mirrors the main() flags the settings table refers to.
+func testConfigFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("shell", "claude", "")
+	fs.String("workspace", "", "")
+	fs.String("agent-view", "local", "")
+	return fs
+}
+
+func TestApplyServerConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "swe-swe.json")
+	os.WriteFile(path, []byte(`{
+		"assistant": {"shell": "codex"},
+		"paths": {"workspace": "/from-config"},
+		"ports": {"preview": "3100-3119", "public": "5100-5119"},
+		"agentView": {"backend": "off"},
+		"auth": {"password": "hunter2"}
+	}`), 0o644)
+
+	t.Setenv("SWE_PREVIEW_PORTS", "3200-3219") // env beats config
+	t.Setenv("SWE_PUBLIC_PORTS", "")           // empty env: config applies
+	t.Setenv("SWE_WORKSPACE_DIR", "")
+	t.Setenv("SWE_AGENT_VIEW", "")
+	t.Setenv("SWE_SWE_PASSWORD", "")
+
+	fs := testConfigFlags()
+	fs.Parse([]string{"-agent-view", "local"}) // flag beats config
+
+	sources, err := applyServerConfig(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		key, source, got, want string
+	}{
+		{"ports.preview", configSourceEnv, os.Getenv("SWE_PREVIEW_PORTS"), "3200-3219"},
+		{"ports.public", configSourceFile, os.Getenv("SWE_PUBLIC_PORTS"), "5100-5119"},
+		{"paths.workspace", configSourceFile, os.Getenv("SWE_WORKSPACE_DIR"), "/from-config"},
+		{"assistant.shell", configSourceFile, fs.Lookup("shell").Value.String(), "codex"},
+		{"agentView.backend", configSourceFlag, fs.Lookup("agent-view").Value.String(), "local"},
+		{"ports.cdp", configSourceDefault, "", ""},
+	}
+	for _, c := range checks {
+		if sources[c.key] != c.source || c.got != c.want {
+			t.Errorf("%s: source=%s value=%q; want source=%s value=%q", c.key, sources[c.key], c.got, c.source, c.want)
+		}
+	}
+
+	report := buildConfigReport(fs, path, sources)
+	settings := report["settings"].(map[string]configEntry)
+	if e := settings["auth.password"]; e.Value != "********" || e.Source != configSourceFile {
+		t.Errorf("auth.password = %+v, want masked from config", e)
+	}
+	if e := settings["assistant.shell"]; e.Value != "codex" {
+		t.Errorf("assistant.shell = %+v", e)
+	}
+}
+
+func TestApplyServerConfigMissingFile(t *testing.T) {
+	if _, err := applyServerConfig(testConfigFlags(), filepath.Join(t.TempDir(), "nope.json")); err == nil {
+		t.Error("missing config file should fail")
+	}
+}
+
+func TestHandleConfigAPIMasksSecrets(t *testing.T) {
+	t.Setenv("SWE_BROWSER_BACKEND_TOKEN", "s3cret")
+	rr := httptest.NewRecorder()
+	handleConfigAPI(rr, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "s3cret") {
+		t.Error("response leaks a secret")
+	}
+	var resp struct {
+		Settings map[string]configEntry `json:"settings"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Settings["agentView.browserBackendToken"].Value; got != "********" {
+		t.Errorf("token = %q, want masked", got)
+	}
+
+	rr = httptest.NewRecorder()
+	handleConfigAPI(rr, httptest.NewRequest(http.MethodPost, "/api/config", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d, want 405", rr.Code)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePurgeFilter(t *testing.T) {
+	for _, q := range []url.Values{{}, {"before": {"yesterday"}}, {"repo": {" "}}} {
+		if _, err := parsePurgeFilter(q); err == nil {
+			t.Errorf("parsePurgeFilter(%v): want an error", q)
+		}
+	}
+	v, _ := url.ParseQuery("before=2026-10-13&repo=api&visitor=Alice")
+	f, err := parsePurgeFilter(v)
+	if err != nil || !f.Before.Equal(time.Date(2026, 10, 13, 0, 0, 0, 0, time.Local)) || f.Repo != "api" || f.Visitor != "Alice" {
+		t.Errorf("parsePurgeFilter = %+v, %v", f, err)
+	}
+	v, _ = url.ParseQuery("before=2026-10-13T09:00:00Z")
+	if f, err := parsePurgeFilter(v); err != nil || !f.Before.Equal(time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("RFC 3339: %+v, %v", f, err)
+	}
+}
+
+func TestPurgeDataDeletesSelectedSessions(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	writeUsageRecordings(t)
+	if err := collectUsage(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"session-" + usageClaudeRun + ".log",
+		"session-" + usageClaudeRun + ".shares.json",
+		"session-" + usageClaudeRun + "-" + usageLiveRun + ".events.jsonl",
+		"session-" + usageCodexRun + ".log",
+	} {
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+	before := time.Date(2026, 10, 13, 0, 0, 0, 0, time.Local)
+	wantFiles := []string{
+		"session-" + usageClaudeRun + "-" + usageLiveRun + ".events.jsonl",
+		"session-" + usageClaudeRun + "-" + usageLiveRun + ".metadata.json",
+		"session-" + usageClaudeRun + ".log",
+		"session-" + usageClaudeRun + ".metadata.json",
+		"session-" + usageClaudeRun + ".shares.json",
+	}
+
+	dry, err := purgeData(purgeFilter{Before: before}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dry.Recordings, []string{usageClaudeRun}) || !reflect.DeepEqual(dry.Files, wantFiles) || dry.UsageEntries != 1 {
+		t.Errorf("dry run = %+v", dry)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "session-"+usageClaudeRun+".log")); err != nil {
+		t.Fatalf("dry run deleted files: %v", err)
+	}
+
+	report, err := purgeData(purgeFilter{Before: before}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Files, wantFiles) || report.UsageEntries != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	for _, name := range wantFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s survived the purge", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "session-"+usageCodexRun+".log")); err != nil {
+		t.Errorf("unselected recording deleted: %v", err)
+	}
+	if ledger, _ := readUsageLedger(); len(ledger) != 1 || ledger[0].UUID != usageCodexRun {
+		t.Errorf("ledger = %+v", ledger)
+	}
+
+	// The ledger outlives recordings: its entries go by the filter too. The
+	// never-ended session has no ledger entry of its own.
+	os.Remove(filepath.Join(dir, "session-"+usageCodexRun+".metadata.json"))
+	report, err = purgeData(purgeFilter{Repo: "workspace"}, false)
+	if err != nil || !reflect.DeepEqual(report.Recordings, []string{usageLiveRun}) || report.UsageEntries != 1 {
+		t.Errorf("repo purge = %+v, %v", report, err)
+	}
+}
+
+func TestPurgeDataRemovesSessionUploads(t *testing.T) {
+	withTempRecordingsDir(t)
+	workDir := t.TempDir()
+	uploads := filepath.Join(workDir, ".swe-swe", "uploads")
+	os.MkdirAll(uploads, 0755)
+	start := time.Now().Add(-2 * time.Hour)
+	end := start.Add(time.Hour)
+	for name, mtime := range map[string]time.Time{
+		"during.png":  start.Add(time.Minute),
+		"earlier.png": start.Add(-time.Minute),
+		"later.png":   end.Add(time.Minute),
+	} {
+		path := filepath.Join(uploads, name)
+		os.WriteFile(path, []byte("x"), 0644)
+		os.Chtimes(path, mtime, mtime)
+	}
+	writeMetadataFile(t, usageClaudeRun, RecordingMetadata{Agent: "Claude", WorkDir: workDir, StartedAt: start, EndedAt: &end})
+
+	report, err := purgeData(purgeFilter{Before: time.Now()}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Uploads, []string{filepath.Join(uploads, "during.png")}) {
+		t.Errorf("uploads = %v", report.Uploads)
+	}
+	left, _ := os.ReadDir(uploads)
+	if len(left) != 2 {
+		t.Errorf("uploads left = %v; want earlier.png and later.png", left)
+	}
+}
+
+func TestPurgeDataRemovesVisitorEntries(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	at := time.Date(2026, 10, 12, 9, 0, 0, 0, time.Local)
+	writeMetadataFile(t, usageClaudeRun, RecordingMetadata{Agent: "Claude", StartedAt: at, EndedAt: &at, Visitors: []Visitor{
+		{JoinedAt: at, IP: "203.0.113.7"},
+		{JoinedAt: at, IP: "198.51.100.1", Name: "Alice"},
+		{JoinedAt: at, IP: "198.51.100.2"},
+	}})
+	writeMetadataFile(t, usageCodexRun, RecordingMetadata{Agent: "Codex", StartedAt: at, EndedAt: &at, Visitors: []Visitor{{JoinedAt: at, IP: "203.0.113.7"}}})
+
+	for visitor, want := range map[string]int{"203.0.113.7": 2, "Alice": 1, "192.0.2.1": 0} {
+		report, err := purgeData(purgeFilter{Visitor: visitor}, false)
+		if err != nil || report.VisitorEntries != want || len(report.Recordings) != 0 {
+			t.Errorf("visitor %s: report = %+v, %v; want %d entries", visitor, report, err, want)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "session-"+usageClaudeRun+".metadata.json"))
+	var meta RecordingMetadata
+	json.Unmarshal(data, &meta)
+	if len(meta.Visitors) != 1 || meta.Visitors[0].IP != "198.51.100.2" || meta.Agent != "Claude" {
+		t.Errorf("metadata after purge = %+v", meta)
+	}
+}
+
+func TestDataPurgeAPI(t *testing.T) {
+	withTempRecordingsDir(t)
+	for _, tc := range []struct {
+		method, query string
+		code          int
+	}{
+		{http.MethodGet, "?before=2026-01-01", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "", http.StatusBadRequest},
+		{http.MethodDelete, "?before=soon", http.StatusBadRequest},
+		{http.MethodDelete, "?before=2026-01-01&dryRun=1", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		handleDataPurgeAPI(w, httptest.NewRequest(tc.method, "/api/data/purge"+tc.query, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.query, w.Code, tc.code)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func withDeepLinkTemplate(t *testing.T) {
+	t.Helper()
+	saved := availableAssistants
+	availableAssistants = []AssistantConfig{{Name: "Claude", Binary: "claude"}, {Name: "Codex", Binary: "codex"}}
+	t.Cleanup(func() { availableAssistants = saved })
+	tmpl, err := parsePageTemplate("deep-link", "deep-link.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deepLinkTemplate = tmpl
+}
+
+func TestParseDeepLink(t *testing.T) {
+	withDeepLinkTemplate(t)
+
+	req, name, err := parseDeepLink(url.Values{"branch": {"Fix login bug"}, "prompt": {"  fix #12  "}})
+	if err != nil || req.Assistant != "claude" || name != "Claude" || req.Branch != "fix-login-bug" || req.Prompt != "fix #12" || req.Repo != "" {
+		t.Errorf("defaults: %+v %q %v", req, name, err)
+	}
+	if _, name, err := parseDeepLink(url.Values{"assistant": {"codex"}, "repo": {"https://github.com/o/r.git"}}); err != nil || name != "Codex" {
+		t.Errorf("codex: %q %v", name, err)
+	}
+	for label, v := range map[string]url.Values{
+		"assistant": {"assistant": {"nope"}},
+		"prompt":    {"prompt": {strings.Repeat("x", deepLinkMaxPrompt+1)}},
+		"repo":      {"repo": {"https://"}},
+	} {
+		if _, _, err := parseDeepLink(v); err == nil {
+			t.Errorf("%s: accepted", label)
+		}
+	}
+}
+
+func TestDeepLinkConfirmHasNoSideEffects(t *testing.T) {
+	withDeepLinkTemplate(t)
+	setPathPolicyRoots(t)
+
+	q := url.Values{"repo": {"https://github.com/o/r"}, "branch": {"fix-12"}, "prompt": {"fix <b>#12</b>\nthen test"}}
+	rr := httptest.NewRecorder()
+	handleDeepLink(rr, httptest.NewRequest(http.MethodGet, deepLinkPath+"?"+q.Encode(), nil))
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(body, `action="/new"`) || !strings.Contains(body, "(cloned first)") {
+		t.Fatalf("status %d:\n%s", rr.Code, body)
+	}
+	if !strings.Contains(body, "fix &lt;b&gt;#12&lt;/b&gt;") || strings.Contains(body, "<b>#12") {
+		t.Errorf("prompt not escaped:\n%s", body)
+	}
+	if entries, _ := os.ReadDir(reposDir); len(entries) != 1 {
+		t.Errorf("GET touched %s: %v", reposDir, entries)
+	}
+
+	rr = httptest.NewRecorder()
+	handleDeepLink(rr, httptest.NewRequest(http.MethodGet, deepLinkPath+"?assistant=nope", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Unknown assistant") || strings.Contains(rr.Body.String(), "<form") {
+		t.Errorf("bad assistant: status %d:\n%s", rr.Code, rr.Body.String())
+	}
+}
+
+func postDeepLink(t *testing.T, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, deepLinkPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handleDeepLink(rr, req)
+	return rr
+}
+
+// stagedFromRedirect returns the params staged for the session a deep link
+// redirected to.
+func stagedFromRedirect(t *testing.T, rr *httptest.ResponseRecorder) (SessionParams, url.Values) {
+	t.Helper()
+	if rr.Code != http.StatusFound {
+		t.Fatalf("status %d:\n%s", rr.Code, rr.Body.String())
+	}
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(loc.Path, "/session/") {
+		t.Fatalf("Location = %q", rr.Header().Get("Location"))
+	}
+	staged, ok := takePendingSession(strings.TrimPrefix(loc.Path, "/session/"))
+	if !ok || staged.kind != "new" {
+		t.Fatalf("nothing staged for %s", loc.Path)
+	}
+	return staged.params, loc.Query()
+}
+
+func TestDeepLinkOpenWorkspace(t *testing.T) {
+	withDeepLinkTemplate(t)
+
+	params, q := stagedFromRedirect(t, postDeepLink(t, url.Values{"assistant": {"codex"}, "branch": {"fix-12"}, "prompt": {"fix #12"}}))
+	if params.Assistant != "codex" || params.Branch != "fix-12" || params.Prompt != "fix #12" || params.RepoPath != "" {
+		t.Errorf("staged %+v", params)
+	}
+	if q.Get("assistant") != "codex" || q.Get("branch") != "fix-12" || q.Has("pwd") {
+		t.Errorf("redirect query %v", q)
+	}
+
+	if rr := postDeepLink(t, url.Values{"assistant": {"nope"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("bad assistant: status %d", rr.Code)
+	}
+}
+
+func TestDeepLinkOpenClonesRepo(t *testing.T) {
+	withDeepLinkTemplate(t)
+	setPathPolicyRoots(t)
+
+	src := filepath.Join(t.TempDir(), "src")
+	for _, args := range [][]string{
+		{"init", "-q", src},
+		{"-C", src, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	repo := "file://" + src
+
+	for i := 0; i < 2; i++ { // clone, then fetch
+		params, q := stagedFromRedirect(t, postDeepLink(t, url.Values{"repo": {repo}}))
+		want := filepath.Join(reposDir, sanitizeRepoURL(repo), "workspace")
+		if params.RepoPath != want || q.Get("pwd") != want || q.Get("assistant") != "claude" {
+			t.Errorf("pass %d: staged %+v, query %v", i, params, q)
+		}
+		if _, err := os.Stat(filepath.Join(want, ".git")); err != nil {
+			t.Errorf("pass %d: not cloned: %v", i, err)
+		}
+	}
+
+	rr := postDeepLink(t, url.Values{"repo": {"file://" + filepath.Join(t.TempDir(), "missing")}})
+	if rr.Code != http.StatusBadGateway || !strings.Contains(rr.Body.String(), "Git clone failed") {
+		t.Errorf("missing repo: status %d:\n%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTypeInitialPrompt(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := &Session{UUID: "deep-link-test", PTY: w}
+	s.ring.Write([]byte("claude> \r\n")) // the agent has drawn its prompt
+
+	done := make(chan struct{})
+	go func() {
+		s.typeInitialPrompt("fix #12\nthen test")
+		w.Close()
+		close(done)
+	}()
+	got, _ := io.ReadAll(r)
+	<-done
+	if want := "\x1b[200~fix #12\nthen test\x1b[201~\r"; string(got) != want {
+		t.Errorf("typed %q, want %q", got, want)
+	}
+}
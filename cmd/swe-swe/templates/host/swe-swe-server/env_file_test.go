@@ -24,7 +24,7 @@ func TestLoadEnvFileExpandsAgainstSessionEnv(t *testing.T) {
 		"OTHER=1",
 	}
 
-	got := loadEnvFile(envPath, envLookup(sessionEnv))
+	got := loadEnvFile(envPath, "", envLookup(sessionEnv))
 	if len(got) != 1 {
 		t.Fatalf("expected 1 entry, got %d: %v", len(got), got)
 	}
@@ -45,7 +45,7 @@ func TestLoadEnvFileFallsBackToOsGetenv(t *testing.T) {
 
 	t.Setenv("WHO", "world")
 
-	got := loadEnvFile(envPath, envLookup([]string{"PATH=/ignored"}))
+	got := loadEnvFile(envPath, "", envLookup([]string{"PATH=/ignored"}))
 	if len(got) != 1 || got[0] != "GREETING=hello world" {
 		t.Errorf("expected GREETING=hello world, got %v", got)
 	}
@@ -63,7 +63,7 @@ func TestLoadEnvFileLocalReferencesWinOverSessionEnv(t *testing.T) {
 	}
 
 	sessionEnv := []string{"WHO=session"}
-	got := loadEnvFile(envPath, envLookup(sessionEnv))
+	got := loadEnvFile(envPath, "", envLookup(sessionEnv))
 	if len(got) != 2 || got[1] != "GREETING=hello local" {
 		t.Errorf("expected GREETING=hello local, got %v", got)
 	}
@@ -89,7 +89,7 @@ func TestLoadEnvFileNilLookupFallsBackToOsGetenv(t *testing.T) {
 		t.Fatalf("write env: %v", err)
 	}
 	t.Setenv("HOME", "/root")
-	got := loadEnvFile(envPath, nil)
+	got := loadEnvFile(envPath, "", nil)
 	if len(got) != 1 || got[0] != "X=/root" {
 		t.Errorf("expected X=/root, got %v", got)
 	}
@@ -185,6 +185,30 @@ func TestBuildSessionEnv_FileWinsOverStore(t *testing.T) {
 	}
 }
 
+// TestBuildSessionEnv_SkipRepoEnv: an untrusted checkout's .swe-swe/env is
+// not loaded, while the store still is.
+func TestBuildSessionEnv_SkipRepoEnv(t *testing.T) {
+	sid := "sid-skip-repo-env"
+	defer clearSessionEnv(sid)
+
+	workDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(workDir, ".swe-swe"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, ".swe-swe", "env"), []byte("FROM_FILE=yes\n"), 0644); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+	setSessionEnv(sid, "STORE_ONLY=yes\n")
+
+	env := buildSessionEnv(SessionEnvParams{SID: sid, WorkDir: workDir, SessionMode: "terminal", SkipRepoEnv: true})
+	if _, ok := envValue(env, "FROM_FILE"); ok {
+		t.Error("FROM_FILE set; .swe-swe/env must be skipped")
+	}
+	if v, _ := envValue(env, "STORE_ONLY"); v != "yes" {
+		t.Errorf("STORE_ONLY = %q, want yes", v)
+	}
+}
+
 func strSliceHas(ss []string, want string) bool {
 	for _, s := range ss {
 		if s == want {
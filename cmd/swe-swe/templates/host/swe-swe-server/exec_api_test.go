@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitExecArgs(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"git log --oneline -5", []string{"git", "log", "--oneline", "-5"}},
+		{`  git   log  --format='%h %s' `, []string{"git", "log", "--format=%h %s"}},
+		{`make test ARGS="-run Foo"`, []string{"make", "test", "ARGS=-run Foo"}},
+		{`ls a\ b ''`, []string{"ls", "a b", ""}},
+		// No shell semantics: metacharacters are plain words.
+		{"ls; rm -rf $HOME | cat", []string{"ls;", "rm", "-rf", "$HOME", "|", "cat"}},
+	}
+	for _, c := range cases {
+		got, err := splitExecArgs(c.in)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitExecArgs(%q) = %q, %v; want %q", c.in, got, err, c.want)
+		}
+	}
+	for _, bad := range []string{`git log "oops`, `ls \`} {
+		if _, err := splitExecArgs(bad); err == nil {
+			t.Errorf("splitExecArgs(%q) should fail", bad)
+		}
+	}
+}
+
+func TestExecAllowed(t *testing.T) {
+	allow := parseExecAllowlist("git log, make test ,,ls")
+	cases := []struct {
+		argv []string
+		want bool
+	}{
+		{[]string{"git", "log"}, true},
+		{[]string{"git", "log", "--oneline"}, true},
+		{[]string{"make", "test", "V=1"}, true},
+		{[]string{"ls"}, true},
+		{[]string{"git"}, false},
+		{[]string{"git", "-c", "core.pager=sh", "log"}, false},
+		{[]string{"make", "deploy"}, false},
+		{[]string{"/bin/ls"}, false},
+	}
+	for _, c := range cases {
+		if got := execAllowed(allow, c.argv); got != c.want {
+			t.Errorf("execAllowed(%q) = %v, want %v", c.argv, got, c.want)
+		}
+	}
+	if execAllowed(parseExecAllowlist(""), []string{"ls"}) {
+		t.Error("empty allowlist should deny everything")
+	}
+}
+
+func TestExecDeniedArg(t *testing.T) {
+	cases := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"go", "test", "./..."}, ""},
+		{[]string{"go", "test", "-run", "TestX", "-count=1", "./..."}, ""},
+		{[]string{"go", "test", "-exec=/bin/sh", "./..."}, "-exec=/bin/sh"},
+		{[]string{"go", "test", "-exec", "/bin/sh", "./..."}, "-exec"},
+		{[]string{"go", "test", "--toolexec=/tmp/x", "./..."}, "--toolexec=/tmp/x"},
+		{[]string{"go", "vet", "-vettool=/tmp/x", "./..."}, "-vettool=/tmp/x"},
+		{[]string{"go", "test", "-c", "-o", "/etc/cron.d/x"}, "-o"},
+		{[]string{"go", "test", "-coverprofile=/tmp/c.out"}, "-coverprofile=/tmp/c.out"},
+		{[]string{"git", "diff", "--output=/home/app/.bashrc"}, "--output=/home/app/.bashrc"},
+		{[]string{"git", "log", "--output", "/tmp/x"}, "--output"},
+		{[]string{"git", "log", "--oneline", "-o"}, ""},
+		{[]string{"make", "test", "-fevil.mk"}, "-fevil.mk"},
+		{[]string{"make", "test", "V=1"}, ""},
+		{[]string{"npm", "test", "--script-shell=/tmp/x"}, "--script-shell=/tmp/x"},
+		{[]string{"ls", "-la"}, ""},
+	}
+	for _, c := range cases {
+		if got := execDeniedArg(c.argv); got != c.want {
+			t.Errorf("execDeniedArg(%q) = %q, want %q", c.argv, got, c.want)
+		}
+	}
+}
+
+func TestResolveExecWorkDir(t *testing.T) {
+	defer func(ws, wt, rp string) { workspaceDir, worktreeDir, reposDir = ws, wt, rp }(workspaceDir, worktreeDir, reposDir)
+	workspaceDir, worktreeDir, reposDir = "/workspace", "/worktrees", "/repos"
+
+	for in, want := range map[string]string{
+		"":                      "/workspace",
+		"/workspace":            "/workspace",
+		"/workspace/sub/":       "/workspace/sub",
+		"/worktrees/feat":       "/worktrees/feat",
+		"/repos/acme/workspace": "/repos/acme/workspace",
+	} {
+		if got, err := resolveExecWorkDir(in); err != nil || got != want {
+			t.Errorf("resolveExecWorkDir(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"/etc", "/workspace/../etc", "/workspacex", "workspace", "/repos"} {
+		if _, err := resolveExecWorkDir(bad); err == nil {
+			t.Errorf("resolveExecWorkDir(%q) should fail", bad)
+		}
+	}
+}
+
+// setExecTestEnv points the workspace at dir and replaces the allowlist for
+// the duration of the test.
+func setExecTestEnv(t *testing.T, dir, allow string) {
+	t.Helper()
+	prevDir, prevAllow := workspaceDir, execAllowlist
+	t.Cleanup(func() { workspaceDir, execAllowlist = prevDir, prevAllow })
+	workspaceDir = dir
+	execAllowlist = parseExecAllowlist(allow)
+}
+
+// execLines runs handleExecAPI and decodes the NDJSON response.
+func execLines(t *testing.T, body string) (int, []map[string]interface{}) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handleExecAPI(rr, httptest.NewRequest(http.MethodPost, "/api/exec", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		return rr.Code, nil
+	}
+	var lines []map[string]interface{}
+	sc := bufio.NewScanner(rr.Body)
+	for sc.Scan() {
+		var m map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("bad NDJSON line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, m)
+	}
+	return rr.Code, lines
+}
+
+func TestHandleExecAPIStreamsOutputAndExitCode(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "hello.txt"), nil, 0o644)
+	setExecTestEnv(t, dir, "ls,sh -c")
+
+	code, lines := execLines(t, `{"cmd": "ls"}`)
+	if code != http.StatusOK || len(lines) < 2 {
+		t.Fatalf("ls: code=%d lines=%v", code, lines)
+	}
+	if out := lines[0]; out["type"] != "output" || !strings.Contains(out["data"].(string), "hello.txt") {
+		t.Errorf("first line = %v, want ls output", out)
+	}
+	if last := lines[len(lines)-1]; last["type"] != "exit" || last["exitCode"] != float64(0) {
+		t.Errorf("last line = %v, want exit 0", last)
+	}
+
+	// Combined output and a non-zero exit code, argv form.
+	_, lines = execLines(t, `{"cmd": ["sh", "-c", "echo out; echo err >&2; exit 3"]}`)
+	var output strings.Builder
+	for _, l := range lines[:len(lines)-1] {
+		output.WriteString(l["data"].(string))
+	}
+	if got := output.String(); !strings.Contains(got, "out\n") || !strings.Contains(got, "err\n") {
+		t.Errorf("combined output = %q", got)
+	}
+	if last := lines[len(lines)-1]; last["exitCode"] != float64(3) {
+		t.Errorf("exit line = %v, want exitCode 3", last)
+	}
+}
+
+func TestHandleExecAPITimeoutKillsProcessGroup(t *testing.T) {
+	setExecTestEnv(t, t.TempDir(), "sh -c")
+
+	start := time.Now()
+	_, lines := execLines(t, `{"cmd": ["sh", "-c", "sleep 30 & wait"], "timeout": 0.3}`)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("timeout took %s", elapsed)
+	}
+	last := lines[len(lines)-1]
+	if last["type"] != "exit" || last["timedOut"] != true {
+		t.Errorf("exit line = %v, want timedOut", last)
+	}
+}
+
+func TestExecRequestTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		seconds float64
+		want    time.Duration
+	}{
+		{0, execDefaultTimeout},
+		{-1, execDefaultTimeout},
+		{0.5, 500 * time.Millisecond},
+		{execMaxTimeout.Seconds() + 1, execMaxTimeout},
+		{1e12, execMaxTimeout},
+	} {
+		if got := (execRequest{Timeout: tc.seconds}).timeout(); got != tc.want {
+			t.Errorf("timeout(%g) = %s, want %s", tc.seconds, got, tc.want)
+		}
+	}
+}
+
+func TestHandleExecAPIRejects(t *testing.T) {
+	setExecTestEnv(t, t.TempDir(), "ls,go test,git diff")
+
+	cases := []struct {
+		body string
+		want int
+	}{
+		{`{"cmd": "rm -rf /"}`, http.StatusForbidden},
+		{`{"cmd": "go test -exec=/bin/sh ./..."}`, http.StatusForbidden},
+		{`{"cmd": ["go", "test", "-toolexec", "/bin/sh", "./..."]}`, http.StatusForbidden},
+		{`{"cmd": "git diff --output=/tmp/x"}`, http.StatusForbidden},
+		{`{"cmd": ""}`, http.StatusBadRequest},
+		{`{"cmd": 42}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+		{`{"cmd": "ls", "workDir": "/etc"}`, http.StatusBadRequest},
+		{`{"cmd": "ls", "sessionUUID": "no-such-session"}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if code, _ := execLines(t, c.body); code != c.want {
+			t.Errorf("%s: status %d, want %d", c.body, code, c.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handleExecAPI(rr, httptest.NewRequest(http.MethodGet, "/api/exec", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status %d, want 405", rr.Code)
+	}
+}
@@ -15,7 +15,7 @@ func renderForkConfirm(t *testing.T, data forkConfirmData) string {
 	if err != nil {
 		t.Fatalf("read fork-confirm template: %v", err)
 	}
-	tmpl, err := template.New("fork-confirm").Parse(string(content))
+	tmpl, err := template.New("fork-confirm").Funcs(pageTemplateFuncs).Parse(string(content))
 	if err != nil {
 		t.Fatalf("parse fork-confirm template: %v", err)
 	}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCatalogsCoverEnglishKeys(t *testing.T) {
+	en := catalogs[defaultLocale]
+	if len(en) == 0 {
+		t.Fatal("en.json missing or empty")
+	}
+	for name, msgs := range catalogs {
+		for key, msg := range en {
+			tr, ok := msgs[key]
+			if !ok {
+				t.Errorf("%s.json: missing %s", name, key)
+				continue
+			}
+			if got, want := strings.Count(tr, "%"), strings.Count(msg, "%"); got != want {
+				t.Errorf("%s.json: %s has %d verbs, en has %d", name, key, got, want)
+			}
+		}
+		for key := range msgs {
+			if _, ok := en[key]; !ok {
+				t.Errorf("%s.json: %s is not in en.json", name, key)
+			}
+		}
+	}
+}
+
+func TestTr(t *testing.T) {
+	if got := tr("en", "terminal.processExited", 3); got != "[Process exited (code 3)]" {
+		t.Errorf("en = %q", got)
+	}
+	if got := tr("es", "terminal.processExited", 3); got != "[El proceso termin\u00f3 (c\u00f3digo 3)]" {
+		t.Errorf("es = %q", got)
+	}
+	if got := tr("xx", "agentChat.title"); got != "Agent Chat" {
+		t.Errorf("unknown locale = %q", got)
+	}
+	if got := tr("es", "no.such.key"); got != "no.such.key" {
+		t.Errorf("unknown key = %q", got)
+	}
+	if got := trHTML("en", "preview.startApp", "<b>x</b>"); got != "Start a hot-reload web app on <b>x</b>" {
+		t.Errorf("trHTML = %q", got)
+	}
+}
+
+func TestRequestLocale(t *testing.T) {
+	for _, tc := range []struct{ cookie, accept, want string }{
+		{"", "", "en"},
+		{"", "es-MX,es;q=0.9,en;q=0.8", "es"},
+		{"", "fr-FR, ja;q=0.5, en;q=0.7", "en"},
+		{"", "fr, ja;q=0.5", "ja"},
+		{"", "zh-TW", "zh-CN"},
+		{"", "zh-cn", "zh-CN"},
+		{"", "es;q=0, ja", "ja"},
+		{"", "*", "en"},
+		{"ja", "es", "ja"},
+		{"klingon", "es", "es"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept-Language", tc.accept)
+		}
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: localeCookie, Value: tc.cookie})
+		}
+		if got := requestLocale(r); got != tc.want {
+			t.Errorf("cookie=%q Accept-Language=%q: %q, want %q", tc.cookie, tc.accept, got, tc.want)
+		}
+	}
+}
+
+// fakePreviewErrorPage mimics agent-reverse-proxy's "app not running" page.
+const fakePreviewErrorPage = `<html data-theme="dark"><head><title>App Preview</title></head><body>
+<h1>App Preview</h1>
+<div class="instruction-label">Tell your agent:</div>
+<div class="instruction-text">Start a hot-reload web app on <span class="port">localhost:3000</span></div>
+<div class="note">localhost:3000 unreachable. We can only use path-based proxy, so SPAs (React, Vue, etc.) must use hash-based routing (e.g. /#/dashboard)</div>
+<span id="status-text">Listening for app...</span>
+</body></html>`
+
+func TestLocalizePreviewErrorPage(t *testing.T) {
+	h := localizePreviewErrorPage(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, fakePreviewErrorPage)
+		case "/app502":
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "<h1>App Preview</h1> upstream says no")
+		default:
+			fmt.Fprint(w, "<h1>App Preview</h1>")
+		}
+	}))
+	get := func(path, lang string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Language", lang)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := get("/down", "es")
+	body := rr.Body.String()
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("status = %d", rr.Code)
+	}
+	for _, want := range []string{
+		`<html lang="es" data-theme="dark">`,
+		`<title>Vista previa de la app</title>`,
+		`Dile a tu agente:`,
+		`Inicia una app web con recarga en caliente en <span class="port">localhost:3000</span>`,
+		"<div class=\"note\">localhost:3000 no est\u00e1 disponible.",
+		`<span id="status-text">Esperando la app...</span>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("localized page lacks %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Tell your agent") {
+		t.Error("English text left in the localized page")
+	}
+
+	if got := get("/down", "en").Body.String(); got != fakePreviewErrorPage {
+		t.Errorf("English page changed: %q", got)
+	}
+	if rr := get("/app502", "es"); rr.Code != http.StatusBadGateway || rr.Body.String() != "<h1>App Preview</h1> upstream says no" {
+		t.Errorf("app's own 502 rewritten: %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := get("/ok", "es"); rr.Code != http.StatusOK || rr.Body.String() != "<h1>App Preview</h1>" {
+		t.Errorf("200 response rewritten: %d %q", rr.Code, rr.Body.String())
+	}
+}
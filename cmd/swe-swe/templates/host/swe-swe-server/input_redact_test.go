@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInputRedactor(t *testing.T) {
+	var x inputRedactor
+	x.output([]byte("$ sudo ls\r\n\x1b[1m[sudo] password for alice: \x1b[0m"))
+	for _, tc := range []struct{ in, want string }{
+		{"hun", "***"},
+		{"ter2\r", "****\r"},
+		{"ls\r", "ls\r"},
+	} {
+		if got := string(x.input([]byte(tc.in), false)); got != tc.want {
+			t.Errorf("input(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	x.output([]byte("Enter a number: "))
+	if got := string(x.input([]byte("42\r"), false)); got != "42\r" {
+		t.Errorf("non-password prompt masked: %q", got)
+	}
+	if got := string(x.input([]byte("abc\rdef"), true)); got != "***\r***" {
+		t.Errorf("no-echo input = %q", got)
+	}
+
+	for _, prompt := range []string{"Password:", "Enter passphrase for key '/root/.ssh/id_ed25519': ", "? Paste your authentication token: "} {
+		if !atPasswordPrompt([]byte("earlier output\r\n" + prompt)) {
+			t.Errorf("%q not a password prompt", prompt)
+		}
+	}
+	for _, output := range []string{"password: set\r\n$ ", "Spinning: ", "Input tokens: "} {
+		if atPasswordPrompt([]byte(output)) {
+			t.Errorf("%q taken for a password prompt", output)
+		}
+	}
+}
+
+func TestSessionRecorderMasksPasswords(t *testing.T) {
+	withTempRecordingsDir(t)
+	now, advance := fakeClock()
+	r, err := startSessionRecorderAt("session-redact", "bash", 24, 80, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Output([]byte("Password: "))
+	advance(time.Second)
+	r.Input([]byte("hunter2\r"))
+	r.InputNoEcho([]byte("s3cret\r"))
+	r.Input([]byte("ls\r"))
+	r.Close(0)
+
+	_, timing, input := readRecording(t, "session-redact")
+	if strings.Contains(input, "hunter2") || strings.Contains(input, "s3cret") || !strings.Contains(input, "*******\r******\rls\r") {
+		t.Errorf("input = %q", input)
+	}
+	if !strings.Contains(timing, "I 1.000000 8\nI 0.000000 7\n") || !strings.HasSuffix(timing, inputRedactedHeader) {
+		t.Errorf("timing:\n%s", timing)
+	}
+}
+
+// writeLegacyRecording writes a recording as the recorder did before input
+// redaction: plain input and no INPUT_REDACTED line.
+func writeLegacyRecording(t *testing.T, recUUID string) {
+	t.Helper()
+	header := "Script started on 2026-01-02 14:00:00+00:00 [COMMAND=\"bash\"]\n"
+	var logData, input, timing strings.Builder
+	logData.WriteString(header)
+	input.WriteString(header)
+	for _, e := range []struct {
+		typ  byte
+		data string
+	}{
+		{'O', "$ "},
+		{'I', "sudo ls\r"},
+		{'O', "sudo ls\r\n\x1b[1m[sudo] password for alice: \x1b[0m"},
+		{'I', "hunter2"},
+		{'I', "\r"},
+		{'O', "\r\nfile\r\n$ "},
+		{'I', "exit\r"},
+	} {
+		fmt.Fprintf(&timing, "%c 0.100000 %d\n", e.typ, len(e.data))
+		if e.typ == 'I' {
+			input.WriteString(e.data)
+		} else {
+			logData.WriteString(e.data)
+		}
+	}
+	base := recordingsDir + "/session-" + recUUID
+	for ext, data := range map[string]string{".log": logData.String(), ".input": input.String(), ".timing": timing.String()} {
+		if err := os.WriteFile(base+ext, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRedactEndedRecordingInputs(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	const recUUID = "5b1e0c2a-9d4f-4e6b-8a7c-3f2d1e0b9a8c"
+	writeLegacyRecording(t, recUUID)
+	entries := func() []os.DirEntry {
+		e, _ := os.ReadDir(dir)
+		return e
+	}
+	read := func(ext string) string {
+		b, _ := os.ReadFile(dir + "/session-" + recUUID + ext)
+		return string(b)
+	}
+
+	redactEndedRecordingInputs(entries(), map[string]bool{recUUID: true})
+	if !strings.Contains(read(".input"), "hunter2") {
+		t.Fatal("active recording was rewritten")
+	}
+
+	redactEndedRecordingInputs(entries(), map[string]bool{})
+	input := read(".input")
+	if !strings.HasSuffix(input, "sudo ls\r*******\rexit\r") {
+		t.Errorf("input = %q", input)
+	}
+	if !strings.HasSuffix(read(".timing"), inputRedactedHeader) {
+		t.Errorf("timing not marked:\n%s", read(".timing"))
+	}
+
+	// Marked recordings are left alone.
+	redactEndedRecordingInputs(entries(), map[string]bool{})
+	if n := strings.Count(read(".timing"), "INPUT_REDACTED"); n != 1 || read(".input") != input {
+		t.Errorf("second pass changed the recording (%d markers)", n)
+	}
+
+	// The masked password is not a chapter.
+	chapters := findChapters(strings.NewReader(read(".timing")), stripInputHeader([]byte(input)))
+	var prompts []string
+	for _, c := range chapters {
+		prompts = append(prompts, c.prompt)
+	}
+	if strings.Join(prompts, ",") != "sudo ls,exit" {
+		t.Errorf("chapters = %q", prompts)
+	}
+}
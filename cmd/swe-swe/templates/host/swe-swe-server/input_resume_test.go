@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInputResumeState(t *testing.T) {
+	var s inputResumeState
+	now := time.Now()
+	if last := s.attach("tab1", now); last != 0 {
+		t.Fatalf("new stream at %d", last)
+	}
+	for _, seq := range []uint32{1, 2} {
+		if !s.accept("tab1", seq) {
+			t.Errorf("accept(%d) = false", seq)
+		}
+	}
+	if s.accept("tab1", 2) || s.accept("tab1", 1) {
+		t.Error("replayed frame accepted")
+	}
+	if !s.accept("unknown", 1) {
+		t.Error("frame on an unattached id dropped")
+	}
+
+	// The connection drops; a reconnect within the window resumes.
+	s.detach("tab1", now)
+	if last := s.attach("tab1", now.Add(time.Minute)); last != 2 {
+		t.Errorf("resumed at %d, want 2", last)
+	}
+	if !s.accept("tab1", 3) || s.accept("tab1", 3) {
+		t.Error("resumed stream does not dedupe")
+	}
+
+	// Past the window an idle stream starts over; a connected one does not.
+	s.detach("tab1", now.Add(time.Minute))
+	s.attach("tab2", now)
+	s.accept("tab2", 5)
+	later := now.Add(time.Minute + inputResumeWindow + time.Second)
+	if last := s.attach("tab1", later); last != 0 {
+		t.Errorf("expired stream resumed at %d", last)
+	}
+	if last := s.attach("tab2", later); last != 5 {
+		t.Errorf("connected stream expired: %d", last)
+	}
+}
+
+func TestInputResumeStateCap(t *testing.T) {
+	var s inputResumeState
+	now := time.Now()
+	for i := 0; i < inputResumeMaxStreams; i++ {
+		id := fmt.Sprintf("tab%d", i)
+		s.attach(id, now)
+		s.accept(id, 1)
+		s.detach(id, now.Add(time.Duration(i)*time.Second))
+	}
+	s.attach("new", now)
+	if len(s.streams) != inputResumeMaxStreams {
+		t.Errorf("%d streams, want %d", len(s.streams), inputResumeMaxStreams)
+	}
+	if _, ok := s.streams["tab0"]; ok {
+		t.Error("least recently used stream kept")
+	}
+}
+
+func TestValidInputStreamID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"3f2a9c1d-tab_1":         true,
+		"":                       false,
+		"a b":                    false,
+		"../x":                   false,
+		string(make([]byte, 65)): false,
+	} {
+		if got := validInputStreamID(id); got != want {
+			t.Errorf("validInputStreamID(%q) = %v", id, got)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package termsnap
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hinshun/vt10x"
+)
+
+func TestTracked(t *testing.T) {
+	vt := Track(vt10x.New(vt10x.WithSize(20, 4)))
+	gen, ok := Generation(vt)
+	if !ok || gen != 0 {
+		t.Fatalf("Generation = %d, %v", gen, ok)
+	}
+	vt.Write([]byte("hello"))
+	vt.Resize(30, 5)
+	if vt.Gen() != 2 {
+		t.Errorf("Gen = %d after a write and a resize", vt.Gen())
+	}
+	if _, ok := Generation(vt10x.New()); ok {
+		t.Error("untracked terminal has a generation")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	vt := vt10x.New(vt10x.WithSize(20, 4))
+	vt.Write([]byte("$ ls\r\n\x1b[31ma.go\x1b[0m b.go"))
+	screen := Copy(vt)
+	want := ANSI(vt)
+	vt.Write([]byte("\x1b[2Jgone"))
+	if got := ANSI(screen); !bytes.Equal(got, want) {
+		t.Errorf("ANSI(Copy) = %q, want %q", got, want)
+	}
+}
+
+func TestCacheSharesRender(t *testing.T) {
+	var c Cache
+	var renders atomic.Int32
+	release := make(chan struct{})
+	render := func() []byte {
+		renders.Add(1)
+		<-release
+		return []byte("snap-1")
+	}
+
+	// The first caller renders; those asking for the same generation
+	// meanwhile wait for it.
+	var wg sync.WaitGroup
+	got := make([][]byte, 5)
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i] = c.Get(1, render)
+		}()
+	}
+	for renders.Load() == 0 {
+		runtime.Gosched() // until the first render starts
+	}
+	pending, ok := c.Reuse(1)
+	if !ok {
+		t.Fatal("render in progress not offered for reuse")
+	}
+	close(release)
+	if got := pending(); string(got) != "snap-1" {
+		t.Errorf("reused pending render = %q", got)
+	}
+	wg.Wait()
+	if n := renders.Load(); n != 1 {
+		t.Errorf("%d renders for one generation", n)
+	}
+	for i, g := range got {
+		if string(g) != "snap-1" {
+			t.Errorf("caller %d got %q", i, g)
+		}
+	}
+	if get, ok := c.Reuse(1); !ok || string(get()) != "snap-1" {
+		t.Errorf("Reuse(1) = %v", ok)
+	}
+
+	// A new generation renders again; an older one is not kept over it.
+	c.Get(3, func() []byte { return []byte("snap-3") })
+	c.Get(2, func() []byte { return []byte("snap-2") })
+	if _, ok := c.Reuse(1); ok {
+		t.Error("Reuse(1) after the screen changed")
+	}
+	if get, ok := c.Reuse(3); !ok || string(get()) != "snap-3" {
+		t.Errorf("Reuse(3) = %v", ok)
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	vt := vt10x.New(vt10x.WithSize(200, 50))
+	for i := 0; i < 60; i++ {
+		vt.Write([]byte("\x1b[32mPASS\x1b[0m github.com/example/pkg/sub 0.42s \x1b[41m!\x1b[0m\r\n"))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Copy(vt)
+	}
+}
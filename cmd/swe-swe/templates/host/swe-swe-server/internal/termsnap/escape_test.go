@@ -0,0 +1,79 @@
+package termsnap
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDSRWatcher(t *testing.T) {
+	var w DSRWatcher
+	for _, tc := range []struct {
+		read string
+		want int
+	}{
+		{"plain output", 0},
+		{"\x1b[6n", 1},
+		{"a\x1b[6nb\x1b[6n", 2},
+		{"split \x1b[", 0},
+		{"6", 0},
+		{"n done", 1},
+		{"\x1b", 0},
+		{"[5n not it", 0},
+	} {
+		if got := w.Queries([]byte(tc.read)); got != tc.want {
+			t.Errorf("Queries(%q) = %d, want %d", tc.read, got, tc.want)
+		}
+	}
+	if got := string(CursorReport(24, 1)); got != "\x1b[24;1R" {
+		t.Errorf("CursorReport = %q", got)
+	}
+}
+
+func TestBackgroundWatcher(t *testing.T) {
+	var w BackgroundWatcher
+	for _, tc := range []struct {
+		read string
+		want int
+	}{
+		{"\x1b]11;rgb:0/0/0\x1b\\", 0},
+		{"\x1b]11;?\x07", 1},
+		{"vim \x1b]1", 0},
+		{"1;?\x1b\\", 1},
+		{"\x1b]10;?\x07", 0},
+	} {
+		if got := w.Queries([]byte(tc.read)); got != tc.want {
+			t.Errorf("Queries(%q) = %d, want %d", tc.read, got, tc.want)
+		}
+	}
+}
+
+// FuzzDSRWatcher checks that however the output is split into reads, the
+// watcher finds the queries in the whole of it.
+func FuzzDSRWatcher(f *testing.F) {
+	f.Add([]byte("a\x1b[6nb\x1b[6\x1b[6n"), uint8(3))
+	f.Add([]byte("\x1b\x1b[[6n6n"), uint8(1))
+	f.Fuzz(func(t *testing.T, data []byte, step uint8) {
+		if step == 0 {
+			return
+		}
+		var w DSRWatcher
+		got := 0
+		for rest := data; len(rest) > 0; {
+			n := min(int(step), len(rest))
+			got += w.Queries(rest[:n])
+			rest = rest[n:]
+		}
+		if want := bytes.Count(data, DSRQuery); got != want {
+			t.Fatalf("%q in reads of %d: %d queries, want %d", data, step, got, want)
+		}
+	})
+}
+
+func BenchmarkDSRWatcher(b *testing.B) {
+	var w DSRWatcher
+	read := bytes.Repeat([]byte("output \x1b[32mok\x1b[0m "), 32*1024/20)
+	b.SetBytes(int64(len(read)))
+	for i := 0; i < b.N; i++ {
+		w.Queries(read)
+	}
+}
@@ -0,0 +1,148 @@
+package termsnap
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRingSince(t *testing.T) {
+	r := NewRing(16)
+	r.Write([]byte("hello "))
+	r.Write([]byte("world"))
+	if got, ok := r.Since(6); !ok || string(got) != "world" {
+		t.Errorf("since 6 = %q, %v", got, ok)
+	}
+	if got, ok := r.Since(11); !ok || len(got) != 0 {
+		t.Errorf("since 11 = %q, %v", got, ok)
+	}
+	if _, ok := r.Since(12); ok {
+		t.Error("offset past the end")
+	}
+
+	// Wrapped: the first bytes are gone.
+	r.Write(make([]byte, 16-3))
+	r.Write([]byte("tail"))
+	if _, ok := r.Since(6); ok {
+		t.Error("offset no longer in the ring")
+	}
+	if got, ok := r.Since(r.Total() - 4); !ok || string(got) != "tail" {
+		t.Errorf("since end-4 = %q, %v", got, ok)
+	}
+}
+
+func TestZeroRing(t *testing.T) {
+	var r Ring
+	if r.Bytes() != nil || r.Len() != 0 || r.Total() != 0 {
+		t.Fatal("zero ring not empty")
+	}
+	r.Write(make([]byte, DefaultRingSize+1))
+	if r.Len() != DefaultRingSize || r.Total() != DefaultRingSize+1 {
+		t.Errorf("len %d total %d", r.Len(), r.Total())
+	}
+}
+
+func TestRingGrows(t *testing.T) {
+	var r Ring
+	if st := r.Stats(); st.Allocated != 0 || st.Capacity != DefaultRingSize {
+		t.Fatalf("zero ring: %+v", st)
+	}
+	r.Write([]byte("$ ls\r\n"))
+	if st := r.Stats(); st.Allocated != ringMinAlloc || st.Held != 6 {
+		t.Errorf("after a short write: %+v", st)
+	}
+	r.Write(make([]byte, 3*ringMinAlloc))
+	if st := r.Stats(); st.Allocated != 3*ringMinAlloc+6 || st.Written != int64(st.Held) {
+		t.Errorf("after a long write: %+v", st)
+	}
+	r.Write([]byte("x"))
+	if st := r.Stats(); st.Allocated != 2*(3*ringMinAlloc+6) {
+		t.Errorf("growth does not double: %+v", st)
+	}
+	if got := r.Bytes(); string(got[:6]) != "$ ls\r\n" || got[len(got)-1] != 'x' {
+		t.Errorf("bytes moved wrong: %q...%q", got[:6], got[len(got)-1:])
+	}
+}
+
+// FuzzRing checks the ring against the whole stream: after any sequence of
+// writes it holds the stream's last size bytes, and Since agrees.
+func FuzzRing(f *testing.F) {
+	f.Add([]byte("hello world, this wraps around"), uint8(7), uint8(5))
+	f.Add([]byte{}, uint8(1), uint8(1))
+	// Start growing rings from one byte, so the fuzzer's short streams grow
+	// them through several sizes.
+	defer func(n int) { ringMinAlloc = n }(ringMinAlloc)
+	ringMinAlloc = 1
+	f.Fuzz(func(t *testing.T, data []byte, size, step uint8) {
+		if size == 0 || step == 0 {
+			return
+		}
+		r := NewRing(int(size))
+		for rest := data; len(rest) > 0; {
+			n := min(int(step), len(rest))
+			r.Write(rest[:n])
+			rest = rest[n:]
+			// Vary the write sizes: the next one is longer.
+			step++
+		}
+		want := data[max(0, len(data)-int(size)):]
+		if got := r.Bytes(); !bytes.Equal(got, want) && !(len(got) == 0 && len(want) == 0) {
+			t.Fatalf("holds %q, want %q", got, want)
+		}
+		if r.Total() != int64(len(data)) {
+			t.Fatalf("total %d, want %d", r.Total(), len(data))
+		}
+		if st := r.Stats(); st.Allocated > int(size) || st.Held != len(want) {
+			t.Fatalf("stats %+v for %d bytes held", st, len(want))
+		}
+		for off := int64(0); off <= int64(len(data)); off++ {
+			got, ok := r.Since(off)
+			if inRing := off >= int64(len(data)-len(want)); ok != inRing {
+				t.Fatalf("since %d: ok=%v", off, ok)
+			}
+			if ok && !bytes.Equal(got, data[off:]) && len(got)+len(data[off:]) > 0 {
+				t.Fatalf("since %d = %q, want %q", off, got, data[off:])
+			}
+		}
+	})
+}
+
+func BenchmarkRingWrite(b *testing.B) {
+	var r Ring
+	chunk := bytes.Repeat([]byte("output "), 4096/7)
+	b.SetBytes(int64(len(chunk)))
+	for i := 0; i < b.N; i++ {
+		r.Write(chunk)
+	}
+}
+
+// BenchmarkRingWriteSmall is a chatty agent: a spinner or a streamed reply
+// arrives a few bytes per PTY read.
+func BenchmarkRingWriteSmall(b *testing.B) {
+	var r Ring
+	chunk := []byte("\x1b[2K\r\u280b Thinking")
+	b.SetBytes(int64(len(chunk)))
+	for i := 0; i < b.N; i++ {
+		r.Write(chunk)
+	}
+}
+
+// BenchmarkRingNew is a session's first screens of output, growth included.
+func BenchmarkRingNew(b *testing.B) {
+	chunk := bytes.Repeat([]byte("output "), 4096/7)
+	b.SetBytes(int64(16 * len(chunk)))
+	for i := 0; i < b.N; i++ {
+		var r Ring
+		for j := 0; j < 16; j++ {
+			r.Write(chunk)
+		}
+	}
+}
+
+func BenchmarkRingBytes(b *testing.B) {
+	var r Ring
+	r.Write(bytes.Repeat([]byte("output "), DefaultRingSize/7+100))
+	b.SetBytes(int64(r.Len()))
+	for i := 0; i < b.N; i++ {
+		r.Bytes()
+	}
+}
@@ -0,0 +1,75 @@
+package termsnap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hinshun/vt10x"
+)
+
+func TestText(t *testing.T) {
+	vt := vt10x.New(vt10x.WithSize(10, 3))
+	vt.Write([]byte("$ ls\r\na.go  "))
+	if got := Text(vt); got != "$ ls\na.go\n\n" {
+		t.Errorf("Text = %q", got)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	data := []byte(strings.Repeat("snapshot ", 100))
+	z, err := Compress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(z))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); !bytes.Equal(got, data) {
+		t.Errorf("round trip: %q", got)
+	}
+}
+
+// FuzzANSI checks that a snapshot redraws the screen it was taken from:
+// replaying ANSI(vt) into a fresh terminal of the same size gives the same
+// characters, palette colors and cursor.
+func FuzzANSI(f *testing.F) {
+	f.Add([]byte("$ make test\r\n\x1b[32mok\x1b[0m  pkg\r\n"))
+	f.Add([]byte("\x1b[2J\x1b[5;3H\x1b[41;97mbox\x1b[m"))
+	f.Add([]byte("line1\nline2\nline3\nline4\nline5\nline6\nline7\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		src := vt10x.New(vt10x.WithSize(20, 6))
+		src.Write(data)
+		dst := vt10x.New(vt10x.WithSize(20, 6))
+		dst.Write(ANSI(src))
+
+		if Text(dst) != Text(src) {
+			t.Fatalf("text:\n%q\nwant\n%q", Text(dst), Text(src))
+		}
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 20; x++ {
+				s, d := src.Cell(x, y), dst.Cell(x, y)
+				if s.FG < 256 && s.FG != d.FG || s.BG < 256 && s.BG != d.BG {
+					t.Fatalf("cell %d,%d colors %d/%d, want %d/%d", x, y, d.FG, d.BG, s.FG, s.BG)
+				}
+			}
+		}
+		if sc, dc := src.Cursor(), dst.Cursor(); sc.X != dc.X || sc.Y != dc.Y {
+			t.Fatalf("cursor %d,%d, want %d,%d", dc.X, dc.Y, sc.X, sc.Y)
+		}
+	})
+}
+
+func BenchmarkANSI(b *testing.B) {
+	vt := vt10x.New(vt10x.WithSize(200, 50))
+	for i := 0; i < 60; i++ {
+		vt.Write([]byte("\x1b[32mPASS\x1b[0m github.com/example/pkg/sub 0.42s \x1b[41m!\x1b[0m\r\n"))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ANSI(vt)
+	}
+}
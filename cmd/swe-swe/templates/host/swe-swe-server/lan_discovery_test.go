@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestLANDiscoveryInstance(t *testing.T) {
+	lan := []net.IP{net.IPv4(192, 168, 1, 20).To4()}
+	for _, tc := range []struct {
+		listen string
+		addrs  []net.IP
+		why    string // substring of the reason, "" when advertised
+		ip     string
+	}{
+		{":1977", lan, "", "192.168.1.20"},
+		{"0.0.0.0:1977", lan, "", "192.168.1.20"},
+		{"10.0.0.3:1977", lan, "", "10.0.0.3"},
+		{"127.0.0.1:1977", lan, "loopback", ""},
+		{"[::1]:1977", lan, "loopback", ""},
+		{":0", lan, "no fixed port", ""},
+		{":1977", nil, "no LAN-facing", ""},
+	} {
+		in, why := lanDiscoveryInstance(tc.listen, "", tc.addrs)
+		if tc.why != "" {
+			if !strings.Contains(why, tc.why) {
+				t.Errorf("%s: why = %q, want %q", tc.listen, why, tc.why)
+			}
+			continue
+		}
+		if why != "" || in.Port != 1977 || len(in.Addrs) != 1 || in.Addrs[0].String() != tc.ip {
+			t.Errorf("%s: %+v, %q", tc.listen, in, why)
+		}
+		if !strings.HasPrefix(in.Name, "swe-swe on ") || in.TextValue("version") != Version {
+			t.Errorf("%s: name %q text %v", tc.listen, in.Name, in.Text)
+		}
+	}
+	if in, _ := lanDiscoveryInstance(":1977", "Team box", lan); in.Name != "Team box" {
+		t.Errorf("SWE_MDNS_NAME: %q", in.Name)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureLogs routes slog and the log package into a JSON buffer for the
+// duration of the test, restoring the previous defaults afterwards.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	prevSlog, prevFlags, prevOut, prevLevel := slog.Default(), log.Flags(), log.Writer(), logLevel.Level()
+	t.Cleanup(func() {
+		slog.SetDefault(prevSlog)
+		log.SetFlags(prevFlags)
+		log.SetOutput(prevOut)
+		logLevel.Set(prevLevel)
+	})
+	var buf bytes.Buffer
+	log.SetFlags(log.Lshortfile)
+	slog.SetDefault(slog.New(newLogHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	return &buf
+}
+
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var recs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		recs = append(recs, m)
+	}
+	return recs
+}
+
+func TestLogHandlerEnrichesBridgedPrintf(t *testing.T) {
+	buf := captureLogs(t)
+	log.Printf("Session 0f8fad5b-d9cb-469f-a165-70867728950e: sending snapshot (remote=10.0.0.7:5123)")
+	log.Printf("Warning: failed to symlink")
+	log.Printf("Failed to start md-serve: boom")
+
+	recs := logRecords(t, buf)
+	if len(recs) != 3 {
+		t.Fatalf("got %d records: %s", len(recs), buf)
+	}
+	first := recs[0]
+	if first["session"] != "0f8fad5b-d9cb-469f-a165-70867728950e" || first["remote"] != "10.0.0.7:5123" || first["level"] != "INFO" {
+		t.Errorf("first record = %v", first)
+	}
+	if first["subsystem"] != "logging_test" {
+		t.Errorf("subsystem = %v, want logging_test (caller file)", first["subsystem"])
+	}
+	if recs[1]["level"] != "WARN" || recs[2]["level"] != "ERROR" {
+		t.Errorf("levels = %v, %v; want WARN, ERROR", recs[1]["level"], recs[2]["level"])
+	}
+}
+
+func TestLogHandlerKeepsExplicitAttrsAndFilters(t *testing.T) {
+	buf := captureLogs(t)
+	sess := &Session{UUID: "sess-1"}
+	sess.logger().Info("about 0f8fad5b-d9cb-469f-a165-70867728950e", "subsystem", "ws")
+
+	logLevel.Set(slog.LevelWarn)
+	log.Printf("routine chatter")
+	log.Printf("Failed to do the thing")
+
+	recs := logRecords(t, buf)
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2: %s", len(recs), buf)
+	}
+	if recs[0]["session"] != "sess-1" || recs[0]["subsystem"] != "ws" {
+		t.Errorf("explicit attrs overridden: %v", recs[0])
+	}
+	if recs[1]["msg"] != "Failed to do the thing" {
+		t.Errorf("WARN level should keep promoted errors, got %v", recs[1])
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "server.log")
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf.Close()
+
+	for name, want := range map[string]string{
+		"server.log":   "dddddddd\n",
+		"server.log.1": "cccccccc\n",
+		"server.log.2": "bbbbbbbb\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("only 2 backups should be kept")
+	}
+}
+
+func TestHandleLogLevelAPI(t *testing.T) {
+	captureLogs(t)
+
+	rr := httptest.NewRecorder()
+	handleLogLevelAPI(rr, httptest.NewRequest(http.MethodPost, "/api/log-level", strings.NewReader(`{"level":"debug"}`)))
+	if rr.Code != http.StatusOK || logLevel.Level() != slog.LevelDebug {
+		t.Fatalf("POST debug: status %d, level %v", rr.Code, logLevel.Level())
+	}
+
+	rr = httptest.NewRecorder()
+	handleLogLevelAPI(rr, httptest.NewRequest(http.MethodGet, "/api/log-level", nil))
+	if !strings.Contains(rr.Body.String(), `"DEBUG"`) {
+		t.Errorf("GET body = %s", rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	handleLogLevelAPI(rr, httptest.NewRequest(http.MethodPost, "/api/log-level", strings.NewReader(`{"level":"loud"}`)))
+	if rr.Code != http.StatusBadRequest || logLevel.Level() != slog.LevelDebug {
+		t.Errorf("invalid level: status %d, level %v", rr.Code, logLevel.Level())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOutputResumeTokens(t *testing.T) {
+	var s outputResumeState
+	now := time.Now()
+	tok := s.issue(now)
+	if s.take(tok, now) {
+		t.Error("took a token whose connection is still open")
+	}
+	s.closed(tok, now)
+	if !s.take(tok, now.Add(time.Minute)) {
+		t.Error("could not take a closed token")
+	}
+	if s.take(tok, now.Add(time.Minute)) {
+		t.Error("took a token twice")
+	}
+
+	old := s.issue(now)
+	s.closed(old, now)
+	if s.take(old, now.Add(outputResumeWindow+time.Second)) {
+		t.Error("took a token past the window")
+	}
+	if s.take("nope", now) {
+		t.Error("took an unknown token")
+	}
+}
+
+func TestStartOutputResume(t *testing.T) {
+	sess := &Session{}
+	sess.writeToRing([]byte("before "))
+
+	type result struct {
+		resumed bool
+		token   string
+	}
+	results := make(chan result, 1)
+	var resume, offset string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer raw.Close()
+		resumed, token := sess.startOutputResume(NewSafeConn(raw), resume, offset)
+		results <- result{resumed, token}
+		raw.ReadMessage() // until the client closes
+		sess.outputResume.closed(token, time.Now())
+	}))
+	defer srv.Close()
+
+	// connect returns the output_resume frame and any output after it.
+	connect := func() (map[string]any, string) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]any
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		<-results
+		var out string
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			out += string(data)
+		}
+		conn.Close()
+		time.Sleep(50 * time.Millisecond) // let the server record the close
+		return msg, out
+	}
+
+	first, _ := connect()
+	if first["resumed"] != false || first["offset"] != float64(7) {
+		t.Fatalf("first connect = %v", first)
+	}
+
+	sess.writeToRing([]byte("missed"))
+	resume, offset = first["token"].(string), "7"
+	second, out := connect()
+	if second["resumed"] != true || second["offset"] != float64(7) || out != "missed" {
+		t.Fatalf("resume = %v, output %q", second, out)
+	}
+
+	// The first token is spent.
+	third, _ := connect()
+	if third["resumed"] != false || third["offset"] != float64(13) {
+		t.Errorf("reused token = %v", third)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryOutputSink keeps what it is sent.
+type memoryOutputSink struct {
+	mu     sync.Mutex
+	lines  []string
+	closed bool
+}
+
+func (m *memoryOutputSink) send(src outputSource, lines []outputLine) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range lines {
+		m.lines = append(m.lines, src.Session+" "+l.Text)
+	}
+	return nil
+}
+
+func (m *memoryOutputSink) close() error {
+	m.closed = true
+	return nil
+}
+
+func newTestTee(sinks ...outputSink) *outputTee {
+	t := &outputTee{
+		src:   outputSource{Session: "sink-test", Assistant: "claude", Repo: "workspace"},
+		sinks: sinks,
+		names: make([]string, len(sinks)),
+		queue: make(chan outputLine, outputSinkQueue),
+		done:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func TestOutputTeeFraming(t *testing.T) {
+	sink := &memoryOutputSink{}
+	tee := newTestTee(sink)
+	for _, chunk := range []string{
+		"\x1b[1mBuild\x1b[0", "m started\r\n",
+		"progress 10%\rprogress 90%\rprogress 100%\r\n",
+		"\x1b]0;window title\x07\x1b(Bdone   \r\n\r\n",
+		"\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\ text\n",
+		"no newline",
+	} {
+		tee.write([]byte(chunk))
+	}
+	tee.close()
+	tee.write([]byte("after close\n"))
+
+	want := []string{"sink-test Build started", "sink-test progress 100%", "sink-test done", "sink-test link text", "sink-test no newline"}
+	if !reflect.DeepEqual(sink.lines, want) || !sink.closed {
+		t.Errorf("lines = %q, closed %v", sink.lines, sink.closed)
+	}
+
+	var nilTee *outputTee
+	nilTee.write([]byte("x\n"))
+	nilTee.close()
+}
+
+func TestStartOutputSinks(t *testing.T) {
+	workDir := t.TempDir()
+	os.MkdirAll(filepath.Join(workDir, ".swe-swe"), 0755)
+	os.WriteFile(filepath.Join(workDir, ".swe-swe", outputSinksFile), []byte(`[
+		{"type": "file", "path": "logs"},
+		{"type": "kafka"}
+	]`), 0644)
+
+	s := &Session{UUID: "sink-test", Assistant: "claude", WorkDir: workDir}
+	s.startOutputSinks()
+	if s.sinks == nil || len(s.sinks.sinks) != 1 {
+		t.Fatalf("sinks = %+v", s.sinks)
+	}
+	s.sinks.write([]byte("$ go test ./...\r\nok\r\n"))
+	s.sinks.close()
+
+	data, err := os.ReadFile(filepath.Join(workDir, "logs", "session-sink-test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " $ go test ./...") || !strings.HasSuffix(lines[1], " ok") {
+		t.Errorf("file sink wrote %q", data)
+	}
+
+	sub := &Session{UUID: "sub", ParentUUID: "sink-test", WorkDir: workDir}
+	sub.startOutputSinks()
+	if sub.sinks != nil {
+		t.Error("shell sub-session is teed")
+	}
+}
+
+func TestHTTPOutputSinks(t *testing.T) {
+	var mu sync.Mutex
+	bodies := map[string]string{}
+	auth := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies[r.URL.Path], auth[r.URL.Path] = string(body), r.Header.Get("Authorization")
+		mu.Unlock()
+		if r.URL.Path == "/logs/_bulk" {
+			w.Write([]byte(`{"errors":false}`))
+		}
+	}))
+	defer srv.Close()
+	lookup := envLookup([]string{"ES_API_KEY=sekret"})
+
+	loki, err := newOutputSink(outputSinkConfig{Type: "loki", URL: srv.URL + "/loki/api/v1/push", Labels: map[string]string{"team": "web"}}, "", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := newOutputSink(outputSinkConfig{Type: "elasticsearch", URL: srv.URL + "/", Index: "logs", Headers: map[string]string{"Authorization": "ApiKey ${ES_API_KEY}"}}, "", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := outputSource{Session: "sink-test", Assistant: "codex", Repo: "api"}
+	lines := []outputLine{{Time: time.Unix(1760000000, 5), Text: "hello"}, {Time: time.Unix(1760000001, 0), Text: "world"}}
+	for _, sink := range []outputSink{loki, es} {
+		if err := sink.send(src, lines); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(bodies["/loki/api/v1/push"]), &push); err != nil {
+		t.Fatal(err)
+	}
+	if len(push.Streams) != 1 || push.Streams[0].Stream["session"] != "sink-test" || push.Streams[0].Stream["team"] != "web" ||
+		!reflect.DeepEqual(push.Streams[0].Values[0], [2]string{"1760000000000000005", "hello"}) {
+		t.Errorf("loki push = %s", bodies["/loki/api/v1/push"])
+	}
+
+	bulk := strings.Split(strings.TrimSpace(bodies["/logs/_bulk"]), "\n")
+	if len(bulk) != 4 || !strings.Contains(bulk[1], `"message":"hello"`) || !strings.Contains(bulk[1], `"session":"sink-test"`) {
+		t.Errorf("bulk body = %q", bulk)
+	}
+	if auth["/logs/_bulk"] != "ApiKey sekret" {
+		t.Errorf("Authorization = %q", auth["/logs/_bulk"])
+	}
+
+	if _, err := newOutputSink(outputSinkConfig{Type: "elasticsearch", URL: srv.URL}, "", lookup); err == nil {
+		t.Error("elasticsearch without an index accepted")
+	}
+}
+
+func TestSyslogOutputSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	sink, err := newOutputSink(outputSinkConfig{Type: "syslog", Address: "udp://" + conn.LocalAddr().String(), Tag: "agents"}, "", os.Getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.close()
+	if err := sink.send(outputSource{Session: "sink-test", Assistant: "claude", Repo: "workspace"}, []outputLine{{Time: time.Now(), Text: "compiling"}}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := string(buf[:n]); !strings.Contains(msg, "agents") || !strings.HasSuffix(strings.TrimSpace(msg), "session=sink-test assistant=claude repo=workspace compiling") {
+		t.Errorf("syslog message %q", msg)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setPathPolicyRoots points the policy roots at a temp tree:
+// base/{workspace,worktrees,repos,recordings} plus base/outside.
+func setPathPolicyRoots(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	prevWs, prevWt, prevRepos, prevRec := workspaceDir, worktreeDir, reposDir, recordingsDir
+	t.Cleanup(func() { workspaceDir, worktreeDir, reposDir, recordingsDir = prevWs, prevWt, prevRepos, prevRec })
+	workspaceDir = filepath.Join(base, "workspace")
+	worktreeDir = filepath.Join(base, "worktrees")
+	reposDir = filepath.Join(base, "repos")
+	recordingsDir = filepath.Join(base, "recordings")
+	for _, d := range []string{workspaceDir, worktreeDir, filepath.Join(reposDir, "r", "workspace"), recordingsDir, filepath.Join(base, "outside")} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base
+}
+
+func TestPathPolicyLexical(t *testing.T) {
+	base := setPathPolicyRoots(t)
+	cases := []struct {
+		policy pathPolicy
+		path   string
+		want   string // "" = rejected
+	}{
+		{policyRepoPath, workspaceDir, workspaceDir},
+		{policyRepoPath, workspaceDir + "/", workspaceDir},
+		{policyRepoPath, workspaceDir + "/sub", ""}, // workspace itself only
+		{policyRepoPath, reposDir + "/r/workspace", reposDir + "/r/workspace"},
+		{policyRepoPath, reposDir, ""}, // below only
+		{policyRepoPath, reposDir + "/../outside", ""},
+		{policyRepoPath, reposDir + "/r/../../outside", ""},
+		{policyRepoPath, reposDir + "evil/x", ""}, // prefix, not a child
+		{policyRepoPath, "repos/r", ""},           // relative
+		{policyRepoPath, "", ""},
+		{policyClonedRepo, workspaceDir, ""},
+		{policyClonedRepo, reposDir + "//r/./workspace", reposDir + "/r/workspace"},
+		{policyWorkDir, workspaceDir + "/sub/dir", workspaceDir + "/sub/dir"},
+		{policyWorkDir, worktreeDir + "/b", worktreeDir + "/b"},
+		{policyWorkDir, worktreeDir, ""},
+		{policyWorkDir, base + "/outside", ""},
+		{policyWorktree, worktreeDir + "/fix-bug", worktreeDir + "/fix-bug"},
+		{policyWorktree, worktreeDir + "/../../etc/passwd", ""},
+		{policyRecording, recordingsDir + "/session-x.log", recordingsDir + "/session-x.log"},
+		{policyRecording, recordingsDir + "/../workspace/session-x.log", ""},
+	}
+	for _, c := range cases {
+		got, err := c.policy.Resolve(c.path)
+		if c.want == "" {
+			if err == nil || !errors.Is(err, errPathNotAllowed) {
+				t.Errorf("%s.Resolve(%q) = %q, %v; want errPathNotAllowed", c.policy.name, c.path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%s.Resolve(%q) = %q, %v; want %q", c.policy.name, c.path, got, err, c.want)
+		}
+	}
+}
+
+func TestPathPolicySymlinks(t *testing.T) {
+	base := setPathPolicyRoots(t)
+	outside := filepath.Join(base, "outside")
+	os.WriteFile(filepath.Join(outside, "secret.log"), []byte("x"), 0o644)
+
+	// A link inside an allowed root that points outside it.
+	os.Symlink(outside, filepath.Join(reposDir, "escape"))
+	os.Symlink(filepath.Join(outside, "secret.log"), filepath.Join(recordingsDir, "session-evil.log"))
+	// A link that stays inside the allowed roots.
+	os.Symlink(filepath.Join(reposDir, "r"), filepath.Join(reposDir, "alias"))
+	// A dangling link.
+	os.Symlink(filepath.Join(base, "nowhere"), filepath.Join(worktreeDir, "dangling"))
+
+	for _, c := range []struct {
+		policy pathPolicy
+		path   string
+		ok     bool
+	}{
+		{policyClonedRepo, reposDir + "/escape", false},
+		{policyClonedRepo, reposDir + "/escape/not-yet-created", false},
+		{policyRecording, recordingsDir + "/session-evil.log", false},
+		{policyClonedRepo, reposDir + "/alias/workspace", true},
+		{policyClonedRepo, reposDir + "/new-clone/workspace", true}, // does not exist yet
+		{policyWorkDir, worktreeDir + "/dangling/x", false},
+	} {
+		if got := c.policy.Allowed(c.path); got != c.ok {
+			t.Errorf("%s.Allowed(%q) = %v, want %v", c.policy.name, c.path, got, c.ok)
+		}
+	}
+
+	// Roots that are themselves symlinks (e.g. /workspace -> /home/u/src)
+	// still admit their own children.
+	realWs := filepath.Join(base, "real-workspace")
+	os.MkdirAll(filepath.Join(realWs, "sub"), 0o755)
+	linkWs := filepath.Join(base, "ws-link")
+	os.Symlink(realWs, linkWs)
+	workspaceDir = linkWs
+	if !policyWorkDir.Allowed(linkWs + "/sub") {
+		t.Error("child of a symlinked root rejected")
+	}
+}
+
+func TestRecordingLookupsRejectSymlinkEscape(t *testing.T) {
+	base := setPathPolicyRoots(t)
+	secret := filepath.Join(base, "outside", "secret.log")
+	os.WriteFile(secret, []byte("x"), 0o644)
+	const id = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	os.Symlink(secret, filepath.Join(recordingsDir, "session-"+id+".log"))
+	os.Symlink(secret, filepath.Join(recordingsDir, "session-"+id+"-1.events.jsonl"))
+
+	if got := resolveLogPath("session-" + id); got != "" {
+		t.Errorf("resolveLogPath followed a symlink out: %q", got)
+	}
+	if got := findChatEventsFile(id); got != "" {
+		t.Errorf("findChatEventsFile followed a symlink out: %q", got)
+	}
+}
+
+func TestRepoBranchesAPIRejectsTraversal(t *testing.T) {
+	setPathPolicyRoots(t)
+	// Used to pass the prefix check and only be cleaned afterwards.
+	for _, p := range []string{reposDir + "/../outside", "/etc", reposDir} {
+		rr := httptest.NewRecorder()
+		handleRepoBranchesAPI(rr, httptest.NewRequest(http.MethodGet, "/api/repo/branches?path="+p, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("path=%s: status %d, want 400", p, rr.Code)
+		}
+	}
+}
+
+func TestGetOrCreateSessionRejectsRepoPathOutsideRoots(t *testing.T) {
+	base := setPathPolicyRoots(t)
+	_, _, err := getOrCreateSession(SessionParams{UUID: "policy-test", Assistant: "claude", RepoPath: base + "/outside"}, true)
+	if !errors.Is(err, errPathNotAllowed) {
+		t.Errorf("err = %v, want errPathNotAllowed", err)
+	}
+}
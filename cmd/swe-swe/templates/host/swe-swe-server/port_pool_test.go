@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withPortPools loads the pools from env (pool env var -> spec) with a fresh
+// recordings dir and no reservations, restoring everything afterwards.
+func withPortPools(t *testing.T, env map[string]string) {
+	t.Helper()
+	withTempRecordingsDir(t)
+	type saved struct {
+		ports      []int
+		start, end int
+	}
+	var pools []saved
+	for _, p := range allPortPools() {
+		pools = append(pools, saved{p.ports, *p.start, *p.end})
+		t.Setenv(p.env, env[p.env])
+	}
+	portAlloc.held, portAlloc.remembered = map[string]portReservation{}, nil
+	t.Cleanup(func() {
+		for i, p := range allPortPools() {
+			p.ports, *p.start, *p.end = pools[i].ports, pools[i].start, pools[i].end
+		}
+		portAlloc.held, portAlloc.remembered = map[string]portReservation{}, nil
+	})
+	if err := loadPortPools(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// smallPools is a Docker-style mapping where no pool sits at the default
+// offsets and some are not contiguous.
+var smallPools = map[string]string{
+	"SWE_PREVIEW_PORTS":    "13000,13005",
+	"SWE_AGENT_CHAT_PORTS": "24000-24001",
+	"SWE_PUBLIC_PORTS":     "5100,5102,5104",
+	"SWE_CDP_PORTS":        "16000-16001",
+	"SWE_VNC_PORTS":        "17000-17001",
+	"SWE_FILES_PORTS":      "19000-19003",
+}
+
+func TestParsePortSpec(t *testing.T) {
+	got, err := parsePortSpec(" 3010, 3000-3002 ,3001,3100 ")
+	if err != nil || !reflect.DeepEqual(got, []int{3000, 3001, 3002, 3010, 3100}) {
+		t.Errorf("got %v, %v", got, err)
+	}
+	for _, bad := range []string{"", "3000-", "abc", "3019-3000", "0-10", "65535-65536", "3000,,3001"} {
+		if _, err := parsePortSpec(bad); err == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+}
+
+func TestLoadPortPools(t *testing.T) {
+	withPortPools(t, smallPools)
+	if previewPortStart != 13000 || previewPortEnd != 13005 || len(previewPool.list()) != 2 {
+		t.Errorf("preview pool = %v (%d-%d)", previewPool.list(), previewPortStart, previewPortEnd)
+	}
+	if portPoolCapacity() != 2 {
+		t.Errorf("capacity = %d, want the smallest pool's 2", portPoolCapacity())
+	}
+	if displayNumberFromPreview(13005) != 2 {
+		t.Errorf("display for the second preview port = %d, want 2", displayNumberFromPreview(13005))
+	}
+
+	t.Setenv("SWE_FILES_PORTS", "13005-13006")
+	if err := loadPortPools(); err == nil || !strings.Contains(err.Error(), "13005") {
+		t.Errorf("overlapping pools: err = %v", err)
+	}
+	t.Setenv("SWE_FILES_PORTS", "19000-")
+	if err := loadPortPools(); err == nil || !strings.Contains(err.Error(), "SWE_FILES_PORTS") {
+		t.Errorf("malformed pool: err = %v", err)
+	}
+}
+
+func TestReserveSessionPorts(t *testing.T) {
+	withPortPools(t, smallPools)
+
+	a, err := reserveSessionPorts("session-a")
+	want := portAssignment{Preview: 13000, AgentChat: 24000, Public: 5100, CDP: 16000, VNC: 17000, Files: 19000}
+	if err != nil || a != want {
+		t.Fatalf("first = %+v, %v; want %+v", a, err, want)
+	}
+	if again, _ := reserveSessionPorts("session-a"); again != a {
+		t.Errorf("reserving again = %+v, want the same %+v", again, a)
+	}
+	b, _ := reserveSessionPorts("session-b")
+	if b.Preview != 13005 || b.Public != 5102 || b.Files != 19001 {
+		t.Errorf("second = %+v", b)
+	}
+	if _, err := reserveSessionPorts("session-c"); err == nil || !strings.Contains(err.Error(), "preview pool") {
+		t.Errorf("exhausted pool: err = %v", err)
+	}
+
+	// A released session's ports go to a new session only when nothing
+	// unremembered is free, and come back to the same UUID.
+	releaseSessionPorts("session-a")
+	c, err := reserveSessionPorts("session-c")
+	if err != nil || c.Preview != 13000 || c.Public != 5104 || c.Files != 19002 {
+		t.Errorf("after release = %+v, %v", c, err)
+	}
+	releaseSessionPorts("session-c")
+	if again, _ := reserveSessionPorts("session-a"); again != a {
+		t.Errorf("returning session = %+v, want its old %+v", again, a)
+	}
+
+	// Remembered assignments survive a restart.
+	releaseSessionPorts("session-a")
+	if _, err := os.Stat(filepath.Join(recordingsDir, "ports.json")); err != nil {
+		t.Fatal(err)
+	}
+	portAlloc.held, portAlloc.remembered = map[string]portReservation{}, nil
+	if again, _ := reserveSessionPorts("session-a"); again != a {
+		t.Errorf("after restart = %+v, want %+v", again, a)
+	}
+}
+
+func TestReclaimLeakedPorts(t *testing.T) {
+	withPortPools(t, nil)
+	registerTestSession(t, "live-session", &Session{})
+	reserveSessionPorts("live-session")
+	reserveSessionPorts("gone-session")
+	reserveSessionPorts("just-reserved")
+	for _, id := range []string{"live-session", "gone-session"} {
+		r := portAlloc.held[id]
+		r.since = time.Now().Add(-2 * portLeakGrace)
+		portAlloc.held[id] = r
+	}
+
+	if leaked := reclaimLeakedPorts(); !reflect.DeepEqual(leaked, []string{"gone-session"}) {
+		t.Errorf("leaked = %v, want only the session with no live entry", leaked)
+	}
+	if _, held := portAlloc.held["gone-session"]; held {
+		t.Error("leaked reservation still held")
+	}
+	if _, held := portAlloc.held["just-reserved"]; !held {
+		t.Error("a reservation inside the grace period was reclaimed")
+	}
+}
+
+func TestGetOrCreateSessionReleasesPortsOnFailure(t *testing.T) {
+	withPortPools(t, smallPools)
+	saved := availableAssistants
+	availableAssistants = []AssistantConfig{{Name: "Broken", Binary: "broken", ShellCmd: "true"}}
+	t.Cleanup(func() { availableAssistants = saved })
+	t.Setenv("SWE_SESSION_BACKEND", "no-such-backend")
+
+	// An invalid backend fails after the ports are reserved.
+	_, _, err := getOrCreateSession(SessionParams{UUID: "fails", Assistant: "broken", WorkDir: t.TempDir()}, true)
+	if err == nil || !strings.Contains(err.Error(), "SWE_SESSION_BACKEND") {
+		t.Fatalf("err = %v, want the session backend error", err)
+	}
+	if _, held := portAlloc.held["fails"]; held {
+		t.Error("failed creation kept its port reservation")
+	}
+}
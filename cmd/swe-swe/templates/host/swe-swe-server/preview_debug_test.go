@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	agentproxy "github.com/choonkeat/agent-reverse-proxy"
+)
+
+// withPreviewDebug registers a debug buffer on a fresh hub for one test.
+func withPreviewDebug(t *testing.T, sessionUUID string) *agentproxy.DebugHub {
+	t.Helper()
+	hub := agentproxy.NewDebugHub()
+	registerPreviewDebug(sessionUUID, hub)
+	t.Cleanup(func() { unregisterPreviewDebug(sessionUUID) })
+	return hub
+}
+
+// waitPreviewDebug waits for the buffer to reach n messages.
+func waitPreviewDebug(t *testing.T, sessionUUID string, n int) []previewDebugMessage {
+	t.Helper()
+	l, ok := loadPreviewDebug(sessionUUID)
+	if !ok {
+		t.Fatalf("no debug buffer for %s", sessionUUID)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		msgs, _, _ := l.since(0)
+		if len(msgs) >= n || time.Now().After(deadline) {
+			return msgs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPreviewDebugTagsSession(t *testing.T) {
+	hubA := withPreviewDebug(t, "debug-a")
+	hubB := withPreviewDebug(t, "debug-b")
+	hubA.BroadcastFromIframe([]byte(`{"t":"console","level":"log","args":["from a"]}`))
+	hubB.BroadcastFromIframe([]byte(`{"t":"urlchange","url":"/b"}`))
+	hubB.BroadcastFromIframe([]byte(`not json`))
+
+	a := waitPreviewDebug(t, "debug-a", 1)
+	if len(a) != 1 || a[0].SessionUUID != "debug-a" || a[0].Type != "console" || a[0].Seq != 1 {
+		t.Fatalf("session a = %+v", a)
+	}
+	b := waitPreviewDebug(t, "debug-b", 2)
+	if len(b) != 2 || b[0].SessionUUID != "debug-b" || b[0].Type != "urlchange" {
+		t.Fatalf("session b = %+v", b)
+	}
+	if string(b[1].Message) != `"not json"` || b[1].Type != "" {
+		t.Errorf("non-JSON message = %s, type %q", b[1].Message, b[1].Type)
+	}
+
+	// Unregistering stops the subscription.
+	unregisterPreviewDebug("debug-a")
+	if _, ok := loadPreviewDebug("debug-a"); ok {
+		t.Error("buffer still registered after unregister")
+	}
+}
+
+func TestPreviewDebugLogRing(t *testing.T) {
+	l := &previewDebugLog{sessionUUID: "ring", wake: make(chan struct{})}
+	for i := 1; i <= previewDebugLimit+5; i++ {
+		l.add([]byte(fmt.Sprintf(`{"t":"console","n":%d}`, i)))
+	}
+	msgs, next, _ := l.since(0)
+	if len(msgs) != previewDebugLimit || msgs[0].Seq != 6 || next != previewDebugLimit+5 {
+		t.Fatalf("got %d msgs, first seq %d, next %d", len(msgs), msgs[0].Seq, next)
+	}
+	if msgs, _, _ := l.since(next - 2); len(msgs) != 2 || msgs[0].Seq != next-1 {
+		t.Errorf("since(next-2) = %+v", msgs)
+	}
+}
+
+func TestPreviewDebugLogWait(t *testing.T) {
+	l := &previewDebugLog{sessionUUID: "wait", wake: make(chan struct{})}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		l.add([]byte(`{"t":"error"}`))
+	}()
+	msgs, cursor := l.wait(context.Background(), 0, 5*time.Second)
+	if len(msgs) != 1 || cursor != 1 {
+		t.Fatalf("wait = %+v, %d", msgs, cursor)
+	}
+	if msgs, _ := l.wait(context.Background(), cursor, 10*time.Millisecond); len(msgs) != 0 {
+		t.Errorf("timed-out wait returned %+v", msgs)
+	}
+}
+
+func TestPreviewDebugAPI(t *testing.T) {
+	hub := withPreviewDebug(t, "debug-api")
+	hub.BroadcastFromIframe([]byte(`{"t":"console"}`))
+	hub.BroadcastFromIframe([]byte(`{"t":"error"}`))
+	waitPreviewDebug(t, "debug-api", 2)
+
+	rr := httptest.NewRecorder()
+	handlePreviewDebugAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/debug-api/preview-debug?since=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	var got struct {
+		SessionUUID string                `json:"sessionUUID"`
+		Messages    []previewDebugMessage `json:"messages"`
+		Next        uint64                `json:"next"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SessionUUID != "debug-api" || len(got.Messages) != 1 || got.Messages[0].Type != "error" || got.Next != 2 {
+		t.Errorf("response = %+v", got)
+	}
+
+	for url, want := range map[string]int{
+		"/api/session/missing/preview-debug":           http.StatusNotFound,
+		"/api/session/debug-api/preview-debug?since=x": http.StatusBadRequest,
+	} {
+		rr := httptest.NewRecorder()
+		handlePreviewDebugAPI(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		if rr.Code != want {
+			t.Errorf("%s: status %d, want %d", url, rr.Code, want)
+		}
+	}
+}
+
+func TestHandlePreviewMCP(t *testing.T) {
+	mcpFor := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, name) })
+	}
+	registerTestSession(t, "preview-mcp-a", &Session{UUID: "preview-mcp-a", PreviewMCP: mcpFor("a")})
+	registerTestSession(t, "preview-mcp-b", &Session{UUID: "preview-mcp-b", PreviewMCP: mcpFor("b")})
+	key := issueSessionKey("preview-mcp-a")
+	t.Cleanup(func() { clearSessionKey("preview-mcp-a") })
+
+	for _, c := range []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"?key=" + key, http.StatusOK, "a"},
+		{"?key=" + key + "&session=preview-mcp-b", http.StatusOK, "b"},
+		{"?key=" + key + "&session=gone", http.StatusNotFound, ""},
+		{"?key=bogus", http.StatusUnauthorized, ""},
+		{"", http.StatusUnauthorized, ""},
+	} {
+		rr := httptest.NewRecorder()
+		handlePreviewMCP(rr, httptest.NewRequest(http.MethodPost, "/mcp/preview"+c.query, nil))
+		if rr.Code != c.code || (c.body != "" && rr.Body.String() != c.body) {
+			t.Errorf("%q: %d %q, want %d %q", c.query, rr.Code, rr.Body.String(), c.code, c.body)
+		}
+	}
+
+	// authMiddleware leaves /mcp/preview to its key check.
+	handler := authMiddleware(http.HandlerFunc(handlePreviewMCP), "preview-mcp-secret")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/mcp/preview?key="+key, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "a" {
+		t.Errorf("through authMiddleware: %d %q", rr.Code, rr.Body.String())
+	}
+}
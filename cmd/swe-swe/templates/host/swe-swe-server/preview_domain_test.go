@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withPreviewDomain sets SWE_PREVIEW_DOMAIN for one test.
+func withPreviewDomain(t *testing.T, domain string) {
+	t.Helper()
+	t.Setenv("SWE_PREVIEW_DOMAIN", domain)
+	t.Cleanup(func() { previewDomain = "" })
+	if err := loadPreviewDomain(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPreviewDomain(t *testing.T) {
+	withPreviewDomain(t, " .Preview.Example.com. ")
+	if previewDomain != "preview.example.com" {
+		t.Errorf("previewDomain = %q", previewDomain)
+	}
+	for _, bad := range []string{"localhost", "preview.example.com:1977", "https://preview.example.com"} {
+		t.Setenv("SWE_PREVIEW_DOMAIN", bad)
+		if err := loadPreviewDomain(); err == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+}
+
+func TestPreviewDomainSession(t *testing.T) {
+	if _, ok := previewDomainSession("abc.preview.example.com"); ok {
+		t.Error("matched with SWE_PREVIEW_DOMAIN unset")
+	}
+	withPreviewDomain(t, "preview.example.com")
+	cases := []struct {
+		host, uuid string
+		ok         bool
+	}{
+		{"abc-123.preview.example.com", "abc-123", true},
+		{"ABC-123.Preview.Example.com:1977", "abc-123", true},
+		{"preview.example.com", "", false},
+		{"x.abc-123.preview.example.com", "", false},
+		{"abc-123.preview.example.com.evil.test", "", false},
+		{"example.com", "", false},
+	}
+	for _, c := range cases {
+		if uuid, ok := previewDomainSession(c.host); uuid != c.uuid || ok != c.ok {
+			t.Errorf("%q = %q, %v; want %q, %v", c.host, uuid, ok, c.uuid, c.ok)
+		}
+	}
+}
+
+func TestPreviewDomainCookieDomain(t *testing.T) {
+	withPreviewDomain(t, "preview.example.com")
+	for host, want := range map[string]string{
+		"example.com:1977":             "example.com",
+		"abc.preview.example.com:1977": "example.com",
+		"localhost:1977":               "",
+	} {
+		if got := sessionCookieDomain(host); got != want {
+			t.Errorf("sessionCookieDomain(%q) = %q, want %q", host, got, want)
+		}
+	}
+	withPreviewDomain(t, "preview.localhost")
+	if got := previewDomainCookieDomain("abc.preview.localhost"); got != "" {
+		t.Errorf("single-label parent: got %q, want host-only", got)
+	}
+}
+
+func TestPreviewDomainRouter(t *testing.T) {
+	withPreviewDomain(t, "preview.example.com")
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "app "+r.URL.Path)
+	})
+	registerTestSession(t, "route-test", &Session{UUID: "route-test", PreviewHostProxy: app})
+	server := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "server "+r.URL.Path)
+	})
+	router := previewDomainRouter(server)
+
+	for _, c := range []struct{ url, want string }{
+		{"http://route-test.preview.example.com/api/items", "app /api/items"},
+		{"http://route-test.preview.example.com/swe-swe-auth/login", "server /swe-swe-auth/login"},
+		{"http://example.com/api/items", "server /api/items"},
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, c.url, nil))
+		if rr.Body.String() != c.want {
+			t.Errorf("%s: got %q, want %q", c.url, rr.Body.String(), c.want)
+		}
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://gone.preview.example.com/", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d, want 404", rr.Code)
+	}
+}
+
+func TestAuthMiddlewarePreviewDomain(t *testing.T) {
+	withPreviewDomain(t, "preview.example.com")
+	const secret = "preview-domain-secret"
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := authMiddleware(inner, secret)
+
+	// Paths exempt on the main host are the app's on a preview host.
+	for _, path := range []string{"/mcp", "/ssl/ca.crt", "/api/autocomplete/x"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://s1.preview.example.com"+path, nil))
+		if rr.Code != http.StatusFound {
+			t.Errorf("%s without a cookie: status %d, want a login redirect", path, rr.Code)
+		}
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://s1.preview.example.com/swe-swe-auth/login", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("login form: status %d", rr.Code)
+	}
+
+	// A guest reaches only their own session's subdomain, at any path.
+	registerTestSession(t, "s1", &Session{UUID: "s1"})
+	guest := &http.Cookie{Name: authCookieName, Value: authSignScopedCookie(secret, "s1")}
+	for host, want := range map[string]int{"s1.preview.example.com": http.StatusOK, "s2.preview.example.com": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		req.AddCookie(guest)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("guest on %s: status %d, want %d", host, rr.Code, want)
+		}
+	}
+}
+
+func TestBuildStatusPayloadPreviewDomain(t *testing.T) {
+	s := &Session{UUID: "status-test"}
+	if _, ok := s.buildStatusPayload(0, 24, 80)["previewDomainHost"]; ok {
+		t.Error("previewDomainHost sent with SWE_PREVIEW_DOMAIN unset")
+	}
+	withPreviewDomain(t, "preview.example.com")
+	if got := s.buildStatusPayload(0, 24, 80)["previewDomainHost"]; got != "status-test.preview.example.com" {
+		t.Errorf("previewDomainHost = %v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hinshun/vt10x"
+)
+
+func TestQueuePromptRejects(t *testing.T) {
+	s := &Session{UUID: "prompt-queue-test", ending: true}
+	for _, text := range []string{"", " \r\n", strings.Repeat("x", promptQueueMaxText+1)} {
+		if _, err := s.QueuePrompt(text); err == nil {
+			t.Errorf("QueuePrompt(%.10q) accepted", text)
+		}
+	}
+	for i := 0; i < promptQueueMax; i++ {
+		if _, err := s.QueuePrompt("next"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.QueuePrompt("one too many"); err == nil {
+		t.Error("full queue accepted a prompt")
+	}
+	if n := s.buildStatusPayload(0, 24, 80)["queuedPrompts"]; n != promptQueueMax {
+		t.Errorf("status queuedPrompts = %v", n)
+	}
+	s.ClearPrompts()
+	if _, ok := s.buildStatusPayload(0, 24, 80)["queuedPrompts"]; ok || s.queuedPrompts() != 0 {
+		t.Error("prompts left after ClearPrompts")
+	}
+}
+
+func TestQueuedPromptsWaitForQuiet(t *testing.T) {
+	saved := promptQuietPeriod
+	promptQuietPeriod = 300 * time.Millisecond
+	t.Cleanup(func() { promptQuietPeriod = saved })
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := &Session{UUID: "prompt-queue-test", PTY: w, vt: vt10x.New(vt10x.WithSize(40, 5))}
+
+	// The agent streams for a second before it is back at its prompt.
+	busy := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			ptyOutput(s, ".")
+			time.Sleep(100 * time.Millisecond)
+		}
+		close(busy)
+	}()
+	start := time.Now()
+	if _, err := s.QueuePrompt("add tests\n"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.QueuePrompt("then\nrun them"); err != nil || n != 2 {
+		t.Fatalf("second prompt: %d, %v", n, err)
+	}
+
+	buf := make([]byte, 64)
+	n, _ := io.ReadAtLeast(r, buf, len("add tests"))
+	select {
+	case <-busy:
+	default:
+		t.Errorf("first prompt typed after %v, while the agent was busy", time.Since(start))
+	}
+	got := string(buf[:n])
+	for !strings.HasSuffix(got, "\x1b[201~\r") {
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += string(buf[:n])
+	}
+	if want := "add tests\r\x1b[200~then\nrun them\x1b[201~\r"; got != want {
+		t.Errorf("typed %q, want %q", got, want)
+	}
+	if s.queuedPrompts() != 0 {
+		t.Errorf("%d prompts still queued", s.queuedPrompts())
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	agentproxy "github.com/choonkeat/agent-reverse-proxy"
+)
+
+// withProxyMode sets SWE_PROXY_MODE for one test.
+func withProxyMode(t *testing.T, mode string) {
+	t.Helper()
+	t.Setenv("SWE_PROXY_MODE", mode)
+	t.Cleanup(func() { proxyMode = proxyModePorts })
+	if err := loadProxyMode(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadProxyMode(t *testing.T) {
+	withProxyMode(t, "")
+	if pathProxyMode() {
+		t.Error("default: want ports mode")
+	}
+	withProxyMode(t, " Path ")
+	if !pathProxyMode() {
+		t.Error("SWE_PROXY_MODE=Path: want path mode")
+	}
+	t.Setenv("SWE_PROXY_MODE", "single")
+	if err := loadProxyMode(); err == nil {
+		t.Error("unknown mode: want an error")
+	}
+}
+
+func TestProxyRouteSessionUUID(t *testing.T) {
+	cases := []struct {
+		path, uuid string
+		ok         bool
+	}{
+		{"/proxy/abc/preview/", "abc", true},
+		{"/proxy/abc/agentchat/x.js", "abc", true},
+		{"/proxy/abc", "", false},
+		{"/proxy//preview/", "", false},
+		{"/preview/abc/", "abc", true},
+		{"/preview/abc", "abc", true}, // the session mux redirects to the slash form
+		{"/preview/", "", false},
+		{"/session/abc", "", false},
+	}
+	for _, c := range cases {
+		if uuid, ok := proxyRouteSessionUUID(c.path); uuid != c.uuid || ok != c.ok {
+			t.Errorf("%q = %q, %v; want %q, %v", c.path, uuid, ok, c.uuid, c.ok)
+		}
+	}
+}
+
+func TestPreviewPathRoute(t *testing.T) {
+	var gotHost, gotPath string
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotPath = r.Host, r.URL.Path
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello from the app")
+	}))
+	defer app.Close()
+	target, _ := url.Parse(app.URL)
+
+	proxy, err := agentproxy.New(agentproxy.Config{BasePath: previewPathBase("route-test"), Target: target, ToolPrefix: "preview", Hub: agentproxy.NewDebugHub()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(previewPathBase("route-test")+"/", proxy)
+	registerTestSession(t, "route-test", &Session{UUID: "route-test", SessionMux: mux})
+
+	rr := httptest.NewRecorder()
+	handleProxyRoute(rr, httptest.NewRequest(http.MethodGet, "http://swe.example/preview/route-test/api/items?x=1", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello from the app" {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if gotPath != "/api/items" || gotHost != target.Host {
+		t.Errorf("upstream saw %s%s, want %s/api/items", gotHost, gotPath, target.Host)
+	}
+
+	rr = httptest.NewRecorder()
+	handleProxyRoute(rr, httptest.NewRequest(http.MethodGet, "/preview/no-such-session/", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d, want 404", rr.Code)
+	}
+}
+
+func TestBuildStatusPayloadPathProxyMode(t *testing.T) {
+	s := &Session{UUID: "status-test", SessionMode: "chat", PreviewPort: 3000, AgentChatPort: 4000, VNCPort: 7000, FilesPort: 9000}
+	proxyKeys := []string{"previewProxyPort", "agentChatProxyPort", "vncProxyPort", "filesProxyPort"}
+
+	withProxyMode(t, "ports")
+	payload := s.buildStatusPayload(0, 24, 80)
+	for _, k := range proxyKeys {
+		if _, ok := payload[k]; !ok {
+			t.Errorf("ports mode: %s missing", k)
+		}
+	}
+
+	withProxyMode(t, "path")
+	payload = s.buildStatusPayload(0, 24, 80)
+	for _, k := range proxyKeys {
+		if v, ok := payload[k]; ok {
+			t.Errorf("path mode: %s = %v, want omitted", k, v)
+		}
+	}
+	if payload["previewPort"] != 3000 {
+		t.Errorf("previewPort = %v, want 3000", payload["previewPort"])
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/creack/pty"
+)
+
+func TestPtyEchoOff(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+
+	setLflag := func(on, off uint32) {
+		t.Helper()
+		var tio syscall.Termios
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&tio))); errno != 0 {
+			t.Fatal(errno)
+		}
+		tio.Lflag = tio.Lflag&^off | on
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&tio))); errno != 0 {
+			t.Fatal(errno)
+		}
+	}
+
+	setLflag(syscall.ECHO|syscall.ICANON, 0)
+	if ptyEchoOff(ptmx) {
+		t.Error("echoing terminal reported as no-echo")
+	}
+	setLflag(syscall.ICANON, syscall.ECHO) // getpass
+	if !ptyEchoOff(ptmx) {
+		t.Error("password prompt not detected")
+	}
+	setLflag(0, syscall.ECHO|syscall.ICANON) // raw mode, e.g. an agent's TUI
+	if ptyEchoOff(ptmx) {
+		t.Error("raw mode reported as no-echo")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	got, err := parseRateLimits("repo=10/m:5, exec=off, recording=2/s", defaultRateLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := got["repo"]; l.Rate != 10.0/60 || l.Burst != 5 {
+		t.Errorf("repo = %+v", l)
+	}
+	if l := got["recording"]; l.Rate != 2 || l.Burst != 2 {
+		t.Errorf("recording = %+v (burst defaults to count)", l)
+	}
+	if _, ok := got["exec"]; ok {
+		t.Error("exec=off should remove the class")
+	}
+	if got["session-end"] != defaultRateLimits["session-end"] {
+		t.Error("unnamed classes keep their defaults")
+	}
+	if _, ok := defaultRateLimits["exec"]; !ok {
+		t.Error("parse must not mutate the defaults")
+	}
+
+	if off, err := parseRateLimits("off", defaultRateLimits); err != nil || len(off) != 0 {
+		t.Errorf("off = %v, %v", off, err)
+	}
+	for _, bad := range []string{"repo", "repo=10", "repo=10/d", "repo=0/m", "repo=5/m:x", "clone=5/m"} {
+		if _, err := parseRateLimits(bad, defaultRateLimits); err == nil {
+			t.Errorf("%q should fail", bad)
+		}
+	}
+}
+
+func TestRateClass(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/repo/prepare":           "repo",
+		"/api/recording/abc/download": "recording-download",
+		"/api/session/abc/export":     "recording-download",
+		"/api/recording/list":         "recording",
+		"/api/session/abc/end":        "session-end",
+		"/api/session/new":            "session-create",
+		"/api/fork/abc":               "session-create",
+		"/api/exec":                   "exec",
+		"/api/session/abc/events":     "",
+		"/session/abc":                "",
+		"/ws/abc":                     "",
+	} {
+		if got := rateClass(path); got != want {
+			t.Errorf("rateClass(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	rl := newRateLimiter()
+	now := time.Unix(1000, 0)
+	rl.now = func() time.Time { return now }
+	l := rateLimit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := rl.allow("repo", "a", l); !ok {
+			t.Fatalf("request %d within burst rejected", i)
+		}
+	}
+	ok, wait, first := rl.allow("repo", "a", l)
+	if ok || wait != time.Second || !first {
+		t.Errorf("third: ok=%v wait=%v first=%v", ok, wait, first)
+	}
+	if _, _, first := rl.allow("repo", "a", l); first {
+		t.Error("second rejection should not be first")
+	}
+	if ok, _, _ := rl.allow("repo", "b", l); !ok {
+		t.Error("other client has its own bucket")
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	if ok, _, _ := rl.allow("repo", "a", l); !ok {
+		t.Error("should refill after a second")
+	}
+	now = now.Add(time.Hour)
+	rl.cleanup()
+	if len(rl.buckets) != 0 {
+		t.Errorf("cleanup kept %d full buckets", len(rl.buckets))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	prev := rateLimits
+	t.Cleanup(func() { rateLimits = prev; apiRateLimiter = newRateLimiter() })
+	rateLimits = map[string]rateLimit{"repo": {Rate: 1.0 / 60, Burst: 1}}
+	apiRateLimiter = newRateLimiter()
+
+	h := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(path, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = remote
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := do("/api/repo/prepare", "10.0.0.1:1"); rr.Code != http.StatusOK {
+		t.Fatalf("first: %d", rr.Code)
+	}
+	rr := do("/api/repo/prepare", "10.0.0.1:2")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("second: %d Retry-After=%q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := do("/api/repo/prepare", "10.0.0.2:1"); rr.Code != http.StatusOK {
+		t.Errorf("other IP: %d", rr.Code)
+	}
+	if rr := do("/api/exec", "10.0.0.1:3"); rr.Code != http.StatusOK {
+		t.Errorf("class without a limit: %d", rr.Code)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOutputOffsetsAndLines(t *testing.T) {
+	timing := "H 0.000000 START_TIME 2026-01-01\nO 0.100000 6\nI 1.000000 1\nO 0.500000 8\nO 2.000000 4\n"
+	marks := []RecordingAnnotation{{TimestampSeconds: 0}, {TimestampSeconds: 1.2}, {TimestampSeconds: 1.7}, {TimestampSeconds: 99}}
+	offsets := outputOffsetsAt(strings.NewReader(timing), marks)
+	if want := []int{0, 6, 14, 18}; !equalInts(offsets, want) {
+		t.Fatalf("offsets = %v, want %v", offsets, want)
+	}
+
+	// Classic format: every line is output.
+	if got := outputOffsetsAt(strings.NewReader("0.5 3\n0.5 4\n"), []RecordingAnnotation{{TimestampSeconds: 0.7}}); got[0] != 3 {
+		t.Errorf("classic offset = %d, want 3", got[0])
+	}
+
+	log := "Script started on 2026-01-01\n$ ls\na\nb\nc\n"
+	// The header line is 29 bytes; "$ ls\n" ends at 34, "a\n" at 36.
+	if got, _ := linesAtOffsets(strings.NewReader(log), []int{29, 35, 36, 1000}); !equalInts(got, []int{0, 1, 2, 4}) {
+		t.Errorf("lines = %v, want [0 1 2 4]", got)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRecordingAnnotationsAPI(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	writeTestRecordingLog(t)
+	writeMetadataFile(t, testShareRecording, RecordingMetadata{UUID: testShareRecording, Name: "run"})
+	base := "/api/recording/" + testShareRecording + "/annotations"
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleRecordingAPI(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	for _, body := range []string{`{"text":"x"}`, `{"timestampSeconds":-1,"text":"x"}`, `{"timestampSeconds":1,"text":"  "}`, `nope`} {
+		if rr := call(http.MethodPost, base, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rr.Code)
+		}
+	}
+	var late, early RecordingAnnotation
+	for _, c := range []struct {
+		body string
+		into *RecordingAnnotation
+	}{{`{"timestampSeconds":90,"text":"tests pass"}`, &late}, {`{"timestampSeconds":12.5,"text":"bug reproduced"}`, &early}} {
+		rr := call(http.MethodPost, base, c.body)
+		if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), c.into) != nil {
+			t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	// Stored in metadata.json, in timeline order, without losing other fields.
+	data, _ := os.ReadFile(filepath.Join(dir, "session-"+testShareRecording+".metadata.json"))
+	var meta RecordingMetadata
+	if err := json.Unmarshal(data, &meta); err != nil || meta.Name != "run" || len(meta.Annotations) != 2 || meta.Annotations[0].ID != early.ID {
+		t.Fatalf("metadata = %+v (%v)", meta, err)
+	}
+
+	rr := call(http.MethodGet, base, "")
+	var list struct {
+		Annotations []RecordingAnnotation `json:"annotations"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Annotations) != 2 || list.Annotations[1].Text != "tests pass" {
+		t.Fatalf("list: %d %s", rr.Code, rr.Body.String())
+	}
+
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		if rr := call(http.MethodDelete, base+"/"+late.ID, ""); rr.Code != want {
+			t.Errorf("delete: status %d, want %d", rr.Code, want)
+		}
+	}
+	if rr := call(http.MethodPost, "/api/recording/11111111-2222-3333-4444-555555555555/annotations", `{"timestampSeconds":1,"text":"x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown recording: status %d", rr.Code)
+	}
+}
+
+func TestAnnotationsShowInPlaybackTOC(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	prefix := filepath.Join(dir, "session-"+testShareRecording)
+	os.WriteFile(prefix+".log", []byte("$ make\nbuilding\nFAIL\n"), 0644)
+	os.WriteFile(prefix+".timing", []byte("O 0.1 7\nO 1.0 9\nO 1.0 5\n"), 0644)
+	writeMetadataFile(t, testShareRecording, RecordingMetadata{UUID: testShareRecording})
+	if _, err := addRecordingAnnotation(testShareRecording, 1.5, "it broke", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handleRecordingPage(rr, httptest.NewRequest(http.MethodGet, "/recording/"+testShareRecording, nil), testShareRecording)
+	if !strings.Contains(rr.Body.String(), "Bookmark: it broke") {
+		t.Errorf("playback page has no bookmark entry:\n%s", rr.Body.String())
+	}
+	opts := recordingPlaybackOptions(httptest.NewRequest(http.MethodGet, "/", nil), testShareRecording, prefix+".log")
+	if len(opts.TOC) != 1 || opts.TOC[0].Line != 2 {
+		t.Errorf("TOC = %+v, want the bookmark on line 2", opts.TOC)
+	}
+}
+
+func TestBookmarkMessageOnLiveSession(t *testing.T) {
+	withTempRecordingsDir(t)
+	sess := &Session{
+		UUID:            "live-bookmark",
+		RecordingPrefix: "session-" + testShareRecording,
+		Metadata:        &RecordingMetadata{UUID: testShareRecording, StartedAt: time.Now().Add(-30 * time.Second)},
+	}
+	registerTestSession(t, sess.UUID, sess)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		handleBookmarkMessage(sess, NewSafeConn(conn), "repro here", "alice")
+	}))
+	defer server.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	var reply struct {
+		Type       string              `json:"type"`
+		Annotation RecordingAnnotation `json:"annotation"`
+	}
+	if err := ws.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "bookmark_added" || reply.Annotation.Author != "alice" || reply.Annotation.TimestampSeconds < 29 {
+		t.Fatalf("reply = %+v", reply)
+	}
+
+	// The live session's metadata holds it, and saveMetadata wrote it out.
+	if got, _ := loadRecordingAnnotations(testShareRecording); len(got) != 1 || got[0].Text != "repro here" {
+		t.Errorf("live annotations = %+v", got)
+	}
+	data, _ := os.ReadFile(filepath.Join(recordingsDir, "session-"+testShareRecording+".metadata.json"))
+	if !strings.Contains(string(data), "repro here") {
+		t.Errorf("metadata.json = %s", data)
+	}
+}
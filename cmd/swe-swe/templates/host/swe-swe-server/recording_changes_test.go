@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitTestRepo creates a repo with one commit and returns it; run runs git in it.
+func gitTestRepo(t *testing.T) (dir string, run func(args ...string)) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir = t.TempDir()
+	run = func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com", "-c", "commit.gpgsign=false"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("hello\n"), 0644)
+	run("add", ".")
+	run("commit", "-q", "-m", "init")
+	return dir, run
+}
+
+func TestCaptureRecordingChanges(t *testing.T) {
+	dir, run := gitTestRepo(t)
+	start := gitHeadCommit(dir)
+	if start == "" {
+		t.Fatal("no HEAD")
+	}
+
+	// Committed during the session, an uncommitted edit, and a new file.
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("hello\nworld\n"), 0644)
+	run("commit", "-q", "-am", "docs")
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("todo\n"), 0644)
+
+	c := captureRecordingChanges(dir, start)
+	if c == nil {
+		t.Fatal("no snapshot")
+	}
+	if c.Base != start || c.Head == start || c.Commits != 1 {
+		t.Errorf("base/head/commits = %s %s %d", c.Base, c.Head, c.Commits)
+	}
+	got := map[string]RecordingFileChange{}
+	for _, f := range c.Files {
+		got[f.Path] = f
+	}
+	if f := got["README.md"]; f.Status != "C" || f.Insertions != 1 {
+		t.Errorf("README.md = %+v", f)
+	}
+	if f := got["main.go"]; f.Status != "M" || f.Insertions != 2 {
+		t.Errorf("main.go = %+v", f)
+	}
+	if f := got["notes.txt"]; f.Status != "??" {
+		t.Errorf("notes.txt = %+v", f)
+	}
+	if c.Insertions != 3 || c.Deletions != 0 || !strings.Contains(c.DiffStat, "2 files changed") {
+		t.Errorf("totals +%d -%d, stat %q", c.Insertions, c.Deletions, c.DiffStat)
+	}
+
+	if c := captureRecordingChanges(t.TempDir(), ""); c != nil {
+		t.Errorf("non-repo snapshot = %+v", c)
+	}
+}
+
+func TestRecordingChangesOnEnd(t *testing.T) {
+	withTempRecordingsDir(t)
+	dir, _ := gitTestRepo(t)
+	os.WriteFile(filepath.Join(dir, "new.go"), []byte("package main\n"), 0644)
+
+	now := time.Now()
+	sess := &Session{
+		WorkDir:         dir,
+		RecordingPrefix: "session-" + testShareRecording,
+		Metadata:        &RecordingMetadata{UUID: testShareRecording, StartCommit: gitHeadCommit(dir), StartedAt: now},
+	}
+	if err := sess.saveMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if sess.Metadata.Changes != nil {
+		t.Error("changes captured before the session ended")
+	}
+	sess.Metadata.EndedAt = &now
+	if err := sess.saveMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The worktree can go away now; the recording keeps the snapshot.
+	os.RemoveAll(dir)
+	rr := httptest.NewRecorder()
+	handleRecordingAPI(rr, httptest.NewRequest(http.MethodGet, "/api/recording/"+testShareRecording, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET details: %d %s", rr.Code, rr.Body.String())
+	}
+	var details recordingDetails
+	if err := json.Unmarshal(rr.Body.Bytes(), &details); err != nil {
+		t.Fatal(err)
+	}
+	if details.Changes == nil || len(details.Changes.Files) != 1 || details.Changes.Files[0].Path != "new.go" {
+		t.Errorf("details.changes = %+v", details.Changes)
+	}
+
+	rr = httptest.NewRecorder()
+	handleRecordingAPI(rr, httptest.NewRequest(http.MethodGet, "/api/recording/"+strings.Repeat("f", 36), nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown recording: %d", rr.Code)
+	}
+}
+
+func TestRecordingChangesPanel(t *testing.T) {
+	if got := recordingChangesPanel(nil); got != "" {
+		t.Errorf("nil = %q", got)
+	}
+	if got := recordingChangesPanel(&RecordingChanges{Files: []RecordingFileChange{}}); strings.Contains(got, "<details") || !strings.Contains(got, `id="recording-changes"`) {
+		t.Errorf("no files = %q", got)
+	}
+	got := recordingChangesPanel(&RecordingChanges{
+		Files:      []RecordingFileChange{{Path: "a.go", Status: "M", Insertions: 2}, {Path: "</script><b>.txt", Status: "??"}},
+		Insertions: 2,
+		Commits:    3,
+		DiffStat:   " a.go | 2 ++\n 1 file changed, 2 insertions(+)\n",
+	})
+	if !strings.Contains(got, "<summary>2 files changed, +2 -0, 3 commits</summary>") {
+		t.Errorf("summary missing: %s", got)
+	}
+	if strings.Count(got, "</script>") != 1 || strings.Contains(got, "<b>") {
+		t.Errorf("path not escaped: %s", got)
+	}
+}
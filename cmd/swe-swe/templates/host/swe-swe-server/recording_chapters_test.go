@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindChapters(t *testing.T) {
+	paste := "\x1b[200~fix flaky\rtest in parser\x1b[201~\r"
+	input := "Script started on 2026-01-02 14:00:00+00:00\n" + "add tests\r" + "y\r" + paste + "ab\x7fc\x1b\r\r"
+	timing := strings.Join([]string{
+		"H 0.000000 START_TIME 2026-01-02 14:00:00+00:00",
+		"O 0.1 10",
+		"I 1.0 10", // "add tests\r": a prompt
+		"O 0.1 20",
+		"I 5.0 2", // "y\r": a menu answer
+		"O 0.1 5",
+		"O 90.0 8", // agent wakes up on its own after 90s
+		fmt.Sprintf("I 1.0 %d", len(paste)),
+		"O 30.0 4",
+		"I 1.0 7", // "ab<DEL>c", Alt+Enter, Enter
+	}, "\n")
+
+	got := findChapters(strings.NewReader(timing), stripInputHeader([]byte(input)))
+	want := []chapter{
+		{offset: 10, at: 1.1, prompt: "add tests"},
+		{offset: 35, at: 96.3, idle: 90},
+		{offset: 43, at: 97.3, prompt: "fix flaky"},
+		{offset: 47, at: 128.3, prompt: "ac"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("chapters = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.offset != w.offset || g.prompt != w.prompt || g.idle != w.idle || fmt.Sprintf("%.1f", g.at) != fmt.Sprintf("%.1f", w.at) {
+			t.Errorf("chapter %d = %+v, want %+v", i, g, w)
+		}
+	}
+
+	// Without an input file, idle gaps still make chapters.
+	if got := findChapters(strings.NewReader(timing), nil); len(got) != 1 || got[0].prompt != "" {
+		t.Errorf("no input: chapters = %+v", got)
+	}
+}
+
+func TestPromptTextAndVisibleText(t *testing.T) {
+	for keys, want := range map[string]string{
+		"hello\r":               "hello",
+		"hello\x1b[D\r":         "hello",
+		"wrong\x15right\r":      "right",
+		"caf\xc3\xa9\x7f\x7f\r": "ca",
+		"\x1b[A\r":              "",
+		"\x1b]0;title\x07ok\r":  "ok",
+	} {
+		if got := promptText([]byte(keys)); got != want {
+			t.Errorf("promptText(%q) = %q, want %q", keys, got, want)
+		}
+	}
+	if got := visibleText("\x1b[1;32m  Running\x1b[0m   tests\t.\r"); got != "Running tests ." {
+		t.Errorf("visibleText = %q", got)
+	}
+	long := strings.Repeat("x", chapterMaxLabelText+5)
+	if got := truncateLabel(long); len(got) != chapterMaxLabelText || !strings.HasSuffix(got, "...") {
+		t.Errorf("truncateLabel = %q", got)
+	}
+}
+
+func TestChapterTOCOnPlaybackPage(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	prefix := filepath.Join(dir, "session-"+testShareRecording)
+	os.WriteFile(prefix+".log", []byte("hello\r\nfix the bug\r\nworking...\r\n\r\nReading output\r\n"), 0644)
+	os.WriteFile(prefix+".input", []byte("fix the bug\r"), 0644)
+	os.WriteFile(prefix+".timing", []byte("O 0.1 7\nI 1.0 12\nO 0.1 13\nO 0.5 12\nO 120.0 2\nO 0.1 16\n"), 0644)
+	startedAt := time.Date(2026, 1, 2, 14, 0, 0, 0, time.Local)
+	writeMetadataFile(t, testShareRecording, RecordingMetadata{UUID: testShareRecording, StartedAt: startedAt})
+
+	opts := recordingPlaybackOptions(httptest.NewRequest(http.MethodGet, "/", nil), testShareRecording, prefix+".log")
+	want := []struct {
+		label string
+		line  int
+	}{
+		{"Prompt 1 (14:00) \u2014 'fix the bug'", 1},
+		{"Resumed after 2m idle (14:02) \u2014 'Reading output'", 3},
+	}
+	if len(opts.TOC) != len(want) {
+		t.Fatalf("TOC = %+v", opts.TOC)
+	}
+	for i, w := range want {
+		if opts.TOC[i].Label != w.label || opts.TOC[i].Line != w.line {
+			t.Errorf("TOC[%d] = %+v, want %q on line %d", i, opts.TOC[i], w.label, w.line)
+		}
+	}
+
+	// Without a start time, chapters show their offset into the recording.
+	if got := chapterTOC(testShareRecording, prefix+".log", time.Time{}); len(got) != 2 || !strings.Contains(got[0].Label, "(+1s)") {
+		t.Errorf("TOC without start time = %+v", got)
+	}
+}
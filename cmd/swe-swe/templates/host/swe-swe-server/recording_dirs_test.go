@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordingsDirFor(t *testing.T) {
+	setPathPolicyRoots(t)
+	repoRecs := filepath.Join(reposDir, "r", ".swe-swe", "recordings")
+	for workDir, want := range map[string]string{
+		workspaceDir:                                       recordingsDir,
+		filepath.Join(worktreeDir, "fix-1"):                recordingsDir,
+		filepath.Join(reposDir, "r", "workspace"):          repoRecs,
+		filepath.Join(reposDir, "r", "worktrees", "fix-1"): repoRecs,
+		filepath.Join(reposDir, "..", "r"):                 recordingsDir,
+		reposDir:                                           recordingsDir,
+	} {
+		if got := recordingsDirFor(workDir); got != want {
+			t.Errorf("%s: got %s, want %s", workDir, got, want)
+		}
+	}
+}
+
+func TestRecordingDirIndex(t *testing.T) {
+	setPathPolicyRoots(t)
+	const repoRec = "11111111-1111-1111-1111-111111111111"
+	const wsRec = "22222222-2222-2222-2222-222222222222"
+	repoDir, err := registerRecordingDir(repoRec, filepath.Join(reposDir, "r", "workspace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registerRecordingDir(wsRec, workspaceDir); err != nil {
+		t.Fatal(err)
+	}
+	if got := recordingPath("session-" + repoRec + "-abc.events.jsonl"); got != repoDir+"/session-"+repoRec+"-abc.events.jsonl" {
+		t.Errorf("child path: %s", got)
+	}
+	if got := recordingDir(wsRec); got != recordingsDir {
+		t.Errorf("workspace recording: %s", got)
+	}
+	if got := recordingPath("ports.json"); got != recordingsDir+"/ports.json" {
+		t.Errorf("server file: %s", got)
+	}
+	if got := recordingDirs(); !reflect.DeepEqual(got, []string{recordingsDir, repoDir}) {
+		t.Errorf("dirs: %v", got)
+	}
+
+	for _, rec := range []string{repoRec, wsRec} {
+		if err := os.WriteFile(recordingPath("session-"+rec+".log"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := readRecordingEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"recording-dirs.json", "session-" + wsRec + ".log", "session-" + repoRec + ".log"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("entries: %v, want %v", names, want)
+	}
+
+	// Deleting the repo drops its entries the next time the index loads.
+	if err := os.RemoveAll(filepath.Join(reposDir, "r")); err != nil {
+		t.Fatal(err)
+	}
+	recordingIndex.mu.Lock()
+	recordingIndex.dirs = nil
+	recordingIndex.mu.Unlock()
+	if got := recordingDir(repoRec); got != recordingsDir {
+		t.Errorf("after repo deletion: %s", got)
+	}
+	if got := recordingDirs(); !reflect.DeepEqual(got, []string{recordingsDir}) {
+		t.Errorf("dirs after repo deletion: %v", got)
+	}
+}
+
+func TestForgetRecordingDir(t *testing.T) {
+	setPathPolicyRoots(t)
+	const rec = "33333333-3333-3333-3333-333333333333"
+	repoDir, err := registerRecordingDir(rec, filepath.Join(reposDir, "r", "workspace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteRecordingFiles(rec)
+	if got := recordingDir(rec); got != recordingsDir {
+		t.Errorf("still indexed in %s (repo dir %s)", got, repoDir)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicEmbedPath(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/oembed":                                 true,
+		embedRecordingPrefix + "u.id.sig":         true,
+		embedRecordingPrefix + testShareRecording: false,
+		embedRecordingPrefix + "a.b.c/../x":       false,
+		"/recording/" + testShareRecording:        false,
+		"/oembed/x":                               false,
+	} {
+		if got := publicEmbedPath(path); got != want {
+			t.Errorf("publicEmbedPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestHandleEmbedRecording(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	withTempRecordingsDir(t)
+	writeTestRecordingLog(t)
+	s, _ := createRecordingShare(testShareRecording, "", 0, false)
+	token := recordingShareToken("master", testShareRecording, s)
+
+	for id, dataURL := range map[string]string{
+		token:              recordingSharePrefix + token + "/session.log",
+		testShareRecording: "/recording/" + testShareRecording + "/session.log",
+	} {
+		rr := httptest.NewRecorder()
+		handleEmbedRecording(rr, httptest.NewRequest(http.MethodGet, embedRecordingPrefix+id, nil))
+		body := rr.Body.String()
+		if rr.Code != http.StatusOK || !strings.Contains(body, dataURL) || !strings.Contains(body, "fitEmbed") {
+			t.Fatalf("%s: status %d\n%s", id, rr.Code, body)
+		}
+		if got := rr.Header().Get("Content-Security-Policy"); got != "frame-ancestors *" {
+			t.Errorf("%s: CSP %q", id, got)
+		}
+		if rr.Header().Get("X-Frame-Options") != "" {
+			t.Errorf("%s: embed sets X-Frame-Options", id)
+		}
+	}
+
+	// The full share page is not frameable and points consumers at oEmbed.
+	rr := httptest.NewRecorder()
+	recordingShareHandler("master")(rr, httptest.NewRequest(http.MethodGet, recordingSharePrefix+token, nil))
+	if rr.Header().Get("X-Frame-Options") != "DENY" || !strings.Contains(rr.Body.String(), `type="application/json+oembed"`) {
+		t.Errorf("share page: XFO %q\n%s", rr.Header().Get("X-Frame-Options"), rr.Body.String())
+	}
+
+	revokeRecordingShare(testShareRecording, s.ID)
+	rr = httptest.NewRecorder()
+	handleEmbedRecording(rr, httptest.NewRequest(http.MethodGet, embedRecordingPrefix+token, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("revoked embed: status %d", rr.Code)
+	}
+}
+
+func TestHandleOEmbed(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	withTempRecordingsDir(t)
+	writeTestRecordingLog(t)
+	writeMetadataFile(t, testShareRecording, RecordingMetadata{UUID: testShareRecording, Name: "fix <flaky> test"})
+	s, _ := createRecordingShare(testShareRecording, "", time.Hour, false)
+	token := recordingShareToken("master", testShareRecording, s)
+	oembed := func(target string, extra string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/oembed?url="+url.QueryEscape(target)+extra, nil)
+		req.Host = "example.com"
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handleOEmbed(rr, req)
+		return rr
+	}
+
+	rr := oembed("https://example.com"+recordingSharePrefix+token, "&maxwidth=400", nil)
+	var got oembedResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if got.Type != "rich" || got.Version != "1.0" || got.Width != 400 || got.Height != 225 || got.Title != "fix <flaky> test" {
+		t.Errorf("response = %+v", got)
+	}
+	if !strings.Contains(got.HTML, `src="http://example.com`+embedRecordingPrefix+token+`"`) || !strings.Contains(got.HTML, "fix &lt;flaky&gt; test") {
+		t.Errorf("html = %s", got.HTML)
+	}
+	if got.CacheAge <= 0 || got.CacheAge > 3600 {
+		t.Errorf("cache_age = %d, want the link's remaining life", got.CacheAge)
+	}
+
+	// A bare recording URL needs the owner's login.
+	plain := "https://example.com/recording/" + testShareRecording
+	if rr := oembed(plain, "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("anonymous plain URL: status %d", rr.Code)
+	}
+	owner := &http.Cookie{Name: authCookieName, Value: authSignCookie("master")}
+	if rr := oembed(plain, "", owner); rr.Code != http.StatusOK {
+		t.Errorf("owner plain URL: status %d", rr.Code)
+	}
+
+	if rr := oembed("https://example.com/", "", owner); rr.Code != http.StatusNotFound {
+		t.Errorf("non-recording URL: status %d", rr.Code)
+	}
+	if rr := oembed("https://example.com"+recordingSharePrefix+token, "&format=xml", nil); rr.Code != http.StatusNotImplemented {
+		t.Errorf("xml: status %d", rr.Code)
+	}
+}
+
+func TestAuthLetsPublicEmbedsThrough(t *testing.T) {
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "master")
+	for path, want := range map[string]int{
+		embedRecordingPrefix + "u.id.sig":         http.StatusOK,
+		"/oembed":                                 http.StatusOK,
+		embedRecordingPrefix + testShareRecording: http.StatusFound,
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != want {
+			t.Errorf("middleware %s: status %d, want %d", path, rr.Code, want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/swe-swe-auth/verify", nil)
+	req.Header.Set("X-Forwarded-Uri", "/oembed?url=x")
+	rr := httptest.NewRecorder()
+	authVerifyHandler("master")(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("forward auth /oembed: status %d", rr.Code)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecordingIntegrity(t *testing.T) {
+	withTempRecordingsDir(t)
+	prefix := "session-" + testShareRecording
+	recorder, err := startSessionRecorder(prefix, "claude", 24, 80, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Output([]byte("hello\r\n"))
+	recorder.Input([]byte("y\r"))
+
+	now := time.Now()
+	sess := &Session{
+		WorkDir:         t.TempDir(),
+		RecordingPrefix: prefix,
+		Metadata:        &RecordingMetadata{UUID: testShareRecording, StartedAt: now, EndedAt: &now},
+	}
+	sess.recorder.Store(recorder)
+	if err := sess.saveMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if sess.Metadata.Integrity != nil {
+		t.Fatal("hashed before the recorder closed")
+	}
+	recorder.Close(0)
+	if err := sess.saveMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	in := sess.Metadata.Integrity
+	if in == nil || in.Algorithm != "sha256" || len(in.Log) != 64 || len(in.Timing) != 64 || len(in.Input) != 64 {
+		t.Fatalf("integrity = %+v", in)
+	}
+
+	verify := func() recordingVerification {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handleRecordingAPI(rr, httptest.NewRequest(http.MethodGet, "/api/recording/"+testShareRecording+"/verify", nil))
+		var v recordingVerification
+		if err := json.Unmarshal(rr.Body.Bytes(), &v); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("verify: %d %s", rr.Code, rr.Body.String())
+		}
+		return v
+	}
+	if v := verify(); v.Status != "ok" || len(v.Files) != 3 {
+		t.Errorf("fresh: %+v", v)
+	}
+
+	// Compressing the log keeps its content, and its hash.
+	logPath := recordingPath(prefix + ".log")
+	if err := compressFileGzip(logPath, logPath+".gz"); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(logPath)
+	if v := verify(); v.Status != "ok" {
+		t.Errorf("compressed: %+v", v)
+	}
+
+	f, _ := os.OpenFile(recordingPath(prefix+".timing"), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("O 0.1 5\n")
+	f.Close()
+	os.Remove(recordingPath(prefix + ".input"))
+	v := verify()
+	if v.Status != "mismatch" {
+		t.Fatalf("tampered: %+v", v)
+	}
+	for _, c := range v.Files {
+		switch c.File {
+		case "log":
+			if !c.OK {
+				t.Errorf("log: %+v", c)
+			}
+		case "timing":
+			if c.OK || c.Actual == "" || c.Actual == c.SHA256 {
+				t.Errorf("timing: %+v", c)
+			}
+		case "input":
+			if c.OK || c.Error == "" {
+				t.Errorf("input: %+v", c)
+			}
+		}
+	}
+
+	if v := verifyRecording(prefix, nil); v.Status != "unhashed" {
+		t.Errorf("no hashes: %+v", v)
+	}
+}
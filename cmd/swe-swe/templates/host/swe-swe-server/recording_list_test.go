@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEndedRecordingsPage(t *testing.T) {
+	h := newTestHelper(t)
+	base := time.Now().Add(-time.Hour)
+	uuidOf := func(i int) string { return fmt.Sprintf("%08d-bbbb-cccc-dddd-eeeeeeeeeeee", i) }
+	for i := 0; i < 25; i++ {
+		ended := base.Add(time.Duration(i) * time.Minute)
+		h.createRecordingFiles(uuidOf(i), recordingOpts{metadata: &RecordingMetadata{UUID: uuidOf(i), Name: fmt.Sprint("rec ", i), Agent: "Claude", EndedAt: &ended}})
+	}
+	h.createMockSession("live-session", uuidOf(24), false)
+
+	page, n, total := endedRecordingsPage(2, 10)
+	if total != 24 || n != 2 || len(page) != 10 || page[0].UUID != uuidOf(13) || page[9].UUID != uuidOf(4) {
+		t.Fatalf("page 2: n=%d total=%d first=%s", n, total, page[0].UUID)
+	}
+	if page[0].EndedAgo == "" || page[0].ExpiresIn == "" || page[0].RestartUUID == "" {
+		t.Errorf("per-request fields missing: %+v", page[0])
+	}
+	if page, n, _ := endedRecordingsPage(9, 10); n != 3 || len(page) != 4 || page[3].UUID != uuidOf(0) {
+		t.Errorf("past the last page: n=%d len=%d", n, len(page))
+	}
+
+	// An unchanged recording is not read again: same size and mtime, so
+	// the index keeps the old name.
+	metaPath := filepath.Join(h.recordingDir, "session-"+uuidOf(23)+".metadata.json")
+	info, _ := os.Stat(metaPath)
+	data, _ := os.ReadFile(metaPath)
+	var meta RecordingMetadata
+	json.Unmarshal(data, &meta)
+	meta.Name = "rec 99"
+	data, _ = json.MarshalIndent(meta, "", "  ")
+	os.WriteFile(metaPath, data, 0644)
+	os.Chtimes(metaPath, info.ModTime(), info.ModTime())
+	if page, _, _ := endedRecordingsPage(1, 1); page[0].Name != "rec 23" {
+		t.Errorf("unchanged files re-read: %q", page[0].Name)
+	}
+	// Once its mtime moves it is.
+	os.Chtimes(metaPath, info.ModTime().Add(time.Second), info.ModTime().Add(time.Second))
+	if page, _, _ := endedRecordingsPage(1, 1); page[0].Name != "rec 99" {
+		t.Errorf("changed metadata not re-read: %q", page[0].Name)
+	}
+
+	os.Remove(filepath.Join(h.recordingDir, "session-"+uuidOf(23)+".log"))
+	if page, _, total := endedRecordingsPage(1, 1); total != 23 || page[0].UUID != uuidOf(22) {
+		t.Errorf("deleted recording still listed: total=%d first=%s", total, page[0].UUID)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRecordingMetadata(t *testing.T) {
+	meta, err := parseRecordingMetadata([]byte(`{"uuid":"u","agent":"Claude"}`))
+	if err != nil || meta.UUID != "u" {
+		t.Errorf("clean: %+v, %v", meta, err)
+	}
+	meta, err = parseRecordingMetadata([]byte(`{"uuid":"u","agent":"Claude"}` + "\n" + `nt":"Codex"}`))
+	if !errors.Is(err, errMetadataRepaired) || meta.Agent != "Claude" {
+		t.Errorf("trailing bytes: %+v, %v", meta, err)
+	}
+	meta, err = parseRecordingMetadata([]byte(`{"uuid":"u","max_cols":"wide","agent":"Claude"}`))
+	if !errors.Is(err, errMetadataRepaired) || meta.Agent != "Claude" || meta.MaxCols != 0 {
+		t.Errorf("wrong type: %+v, %v", meta, err)
+	}
+	if _, err := parseRecordingMetadata([]byte(`{"uuid":`)); err == nil || errors.Is(err, errMetadataRepaired) {
+		t.Errorf("truncated: %v", err)
+	}
+}
+
+func TestMigrateRecordingMetadata(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	const (
+		oldRec      = "11111111-1111-1111-1111-111111111111"
+		currentRec  = "22222222-2222-2222-2222-222222222222"
+		damagedRec  = "33333333-3333-3333-3333-333333333333"
+		newerRec    = "44444444-4444-4444-4444-444444444444"
+		corruptRec  = "55555555-5555-5555-5555-555555555555"
+		currentJSON = `{"schema_version":2,"uuid":"` + currentRec + `","agent":"Claude","command":null}`
+	)
+	files := map[string]string{
+		"session-" + oldRec + ".metadata.json":     `{"uuid":"` + oldRec + `","agent":"Claude","started_at":"2026-01-01T00:00:00Z","ended_at":"2026-01-01T01:00:00Z"}`,
+		"session-" + oldRec + ".log":               "Script started on 2026-01-01\nhello world\nsecond line\n",
+		"session-" + currentRec + ".metadata.json": currentJSON,
+		"session-" + damagedRec + ".metadata.json": `{"schema_version":2,"uuid":"` + damagedRec + `","agent":"Codex"}` + `x"}`,
+		"session-" + newerRec + ".metadata.json":   `{"schema_version":99,"uuid":"` + newerRec + `"}`,
+		"session-" + corruptRec + ".metadata.json": `{"uuid":`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := migrateRecordingMetadata()
+	want := recordingMigrationStats{Scanned: 5, Current: 1, Upgraded: 1, Repaired: 1, Newer: 1, Failed: 1}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	read := func(rec string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, "session-"+rec+".metadata.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	readMeta := func(rec string) RecordingMetadata {
+		t.Helper()
+		var meta RecordingMetadata
+		if err := json.Unmarshal([]byte(read(rec)), &meta); err != nil {
+			t.Errorf("%s: %v", rec, err)
+		}
+		return meta
+	}
+	if meta := readMeta(oldRec); meta.SchemaVersion != recordingSchemaVersion || meta.AgentBinary != "claude" || meta.PlaybackCols == 0 || meta.PlaybackRows == 0 {
+		t.Errorf("old recording not upgraded: %+v", meta)
+	}
+	if data := read(currentRec); data != currentJSON {
+		t.Errorf("current recording rewritten: %s", data)
+	}
+	if meta := readMeta(damagedRec); meta.Agent != "Codex" {
+		t.Errorf("damaged recording not repaired: %+v", meta)
+	}
+	if meta := readMeta(newerRec); meta.SchemaVersion != 99 {
+		t.Errorf("newer recording touched: %+v", meta)
+	}
+	if data := read(corruptRec); data != `{"uuid":` {
+		t.Errorf("corrupt recording touched: %s", data)
+	}
+
+	if again := migrateRecordingMetadata(); again.Upgraded != 0 || again.Repaired != 0 {
+		t.Errorf("second pass: %+v", again)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const replayTestUUID = "0d9c2f1e-7a3b-4c5d-8e6f-9a0b1c2d3e4f"
+
+// recordReplayInput writes a recording whose input is "ls\r" two seconds in
+// and "exit\r" a minute after that.
+func recordReplayInput(t *testing.T) {
+	t.Helper()
+	now, advance := fakeClock()
+	r, err := startSessionRecorderAt("session-"+replayTestUUID, "claude", 24, 80, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Second)
+	r.Output([]byte("> "))
+	advance(time.Second)
+	r.Input([]byte("ls\r"))
+	advance(30 * time.Second)
+	r.Output([]byte("a b c\r\n> "))
+	advance(30 * time.Second)
+	r.Input([]byte("exit\r"))
+	r.Close(0)
+}
+
+func TestLoadReplayInput(t *testing.T) {
+	withTempRecordingsDir(t)
+	if _, err := loadReplayInput(replayTestUUID); err != errRecordingNotFound {
+		t.Errorf("no recording: %v", err)
+	}
+	recordReplayInput(t)
+
+	steps, err := loadReplayInput(replayTestUUID)
+	if err != nil || len(steps) != 2 {
+		t.Fatalf("steps = %+v, %v", steps, err)
+	}
+	if steps[0].delay != 2*time.Second || string(steps[0].data) != "ls\r" {
+		t.Errorf("first = %v %q", steps[0].delay, steps[0].data)
+	}
+	if steps[1].delay != time.Minute || string(steps[1].data) != "exit\r" {
+		t.Errorf("second = %v %q", steps[1].delay, steps[1].data)
+	}
+}
+
+func TestReplayInputTiming(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := &Session{UUID: "replay-test", PTY: w}
+	steps := []replayStep{{delay: 2 * time.Second, data: []byte("ls\r")}, {delay: time.Hour, data: []byte("exit\r")}}
+
+	start := time.Now()
+	go func() {
+		// 2s/10 for the first step; the hour is cut to 5s, then /10.
+		s.replayInput(steps, 10, 5*time.Second)
+		w.Close()
+	}()
+	got, _ := io.ReadAll(r)
+	elapsed := time.Since(start)
+	if string(got) != "ls\rexit\r" {
+		t.Errorf("typed %q", got)
+	}
+	if elapsed < 700*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("took %v, want about 700ms", elapsed)
+	}
+}
+
+func TestReplayBranchStartsAtCommit(t *testing.T) {
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "one")
+	first := git("rev-parse", "HEAD")
+	git("commit", "-q", "--allow-empty", "-m", "two")
+
+	branch, err := replayBranch(repo, replayTestUUID, first)
+	if err != nil || branch != "replay-0d9c2f1e-1" || git("rev-parse", branch) != first {
+		t.Errorf("branch %q, %v", branch, err)
+	}
+	if branch, err := replayBranch(repo, replayTestUUID, ""); err != nil || branch != "replay-0d9c2f1e-2" || git("rev-parse", branch) != git("rev-parse", "HEAD") {
+		t.Errorf("second branch %q, %v", branch, err)
+	}
+	if _, err := replayBranch(repo, replayTestUUID, "0123456789abcdef0123456789abcdef01234567"); err == nil {
+		t.Error("unknown commit accepted")
+	}
+}
+
+func TestHandleRecordingReplayRefusals(t *testing.T) {
+	withTempRecordingsDir(t)
+	withSessionLimits(t, "", "claude=1")
+	replay := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleRecordingAPI(rr, httptest.NewRequest(http.MethodPost, "/api/recording/"+replayTestUUID+"/replay", strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := replay(""); rr.Code != http.StatusNotFound {
+		t.Errorf("no recording: status %d", rr.Code)
+	}
+	writeMetadataFile(t, replayTestUUID, RecordingMetadata{UUID: replayTestUUID, Agent: "Claude", AgentBinary: "claude", WorkDir: filepath.Join(t.TempDir(), "gone")})
+	if rr := replay(""); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "no input") {
+		t.Errorf("no input: status %d %s", rr.Code, rr.Body.String())
+	}
+	recordReplayInput(t)
+	for _, body := range []string{`{"speed": -1}`, `{"speed": 1000}`, `{"maxGap": -5}`, `{`} {
+		if rr := replay(body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", body, rr.Code)
+		}
+	}
+	if rr := replay(""); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "is gone") {
+		t.Errorf("missing repo: status %d %s", rr.Code, rr.Body.String())
+	}
+
+	writeMetadataFile(t, replayTestUUID, RecordingMetadata{UUID: replayTestUUID, Agent: "Claude", AgentBinary: "claude", WorkDir: t.TempDir()})
+	registerTestSession(t, "replay-running", &Session{Assistant: "claude"})
+	if rr := replay(`{"speed": 4}`); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("over the limit: status %d %s", rr.Code, rr.Body.String())
+	}
+
+	writeMetadataFile(t, replayTestUUID, RecordingMetadata{UUID: replayTestUUID, Agent: "Nope", AgentBinary: "nope"})
+	if rr := replay(""); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "not available") {
+		t.Errorf("unknown agent: status %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandleRecordingReplayRejectsScopedGuest(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	req := httptest.NewRequest(http.MethodPost, "/api/recording/"+replayTestUUID+"/replay", nil)
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignScopedCookie("master", "some-sess")})
+	rr := httptest.NewRecorder()
+	handleRecordingReplay(rr, req, replayTestUUID)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("scoped guest status = %d, want 403", rr.Code)
+	}
+}
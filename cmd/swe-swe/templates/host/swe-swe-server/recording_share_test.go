@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testShareRecording = "0b4c6f3e-5b0e-4c7a-9d36-6a1f4c2e8d10"
+
+func writeTestRecordingLog(t *testing.T) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(recordingsDir, "session-"+testShareRecording+".log"), []byte("hello from the run\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateRecordingShareValidatesAndPersists(t *testing.T) {
+	withTempRecordingsDir(t)
+	for _, ttl := range []time.Duration{-time.Second, recordingShareMaxTTL + time.Second} {
+		if _, err := createRecordingShare(testShareRecording, "", ttl, false); err == nil {
+			t.Errorf("ttl %s should fail", ttl)
+		}
+	}
+	if _, err := createRecordingShare(testShareRecording, strings.Repeat("x", recordingShareMaxLabel+1), 0, false); err == nil {
+		t.Error("overlong label should fail")
+	}
+
+	forever, err := createRecordingShare(testShareRecording, "issue #12", 0, true)
+	if err != nil || forever.ExpiresAt != nil {
+		t.Fatalf("no-expiry share = %+v, %v", forever, err)
+	}
+	for i := 1; i < recordingShareMaxPerRecording; i++ {
+		if _, err := createRecordingShare(testShareRecording, "", time.Hour, false); err != nil {
+			t.Fatalf("share %d: %v", i, err)
+		}
+	}
+	if _, err := createRecordingShare(testShareRecording, "", time.Hour, false); err == nil {
+		t.Error("share past the per-recording limit should fail")
+	}
+
+	shares, err := loadRecordingShares(testShareRecording)
+	if err != nil || len(shares) != recordingShareMaxPerRecording || shares[0].ID != forever.ID || !shares[0].AllowDownload {
+		t.Fatalf("reloaded %d shares, %v", len(shares), err)
+	}
+
+	// Deleting the recording takes its shares with it.
+	deleteRecordingFiles(testShareRecording)
+	if _, err := os.Stat(recordingSharesPath(testShareRecording)); !os.IsNotExist(err) {
+		t.Errorf("shares file survived delete: %v", err)
+	}
+}
+
+func TestRecordingShareTokenLifecycle(t *testing.T) {
+	const secret = "master"
+	withTempRecordingsDir(t)
+	s, err := createRecordingShare(testShareRecording, "", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := recordingShareToken(secret, testShareRecording, s)
+
+	if id, got, ok := verifyRecordingShareToken(secret, token); !ok || id != testShareRecording || got.ID != s.ID {
+		t.Fatalf("valid token rejected")
+	}
+	flipped := s
+	flipped.AllowDownload = true
+	for name, bad := range map[string]string{
+		"other secret":     recordingShareToken("other", testShareRecording, s),
+		"download flipped": recordingShareToken(secret, testShareRecording, flipped),
+		"tampered":         tamperToken(token),
+		"no signature":     testShareRecording + "." + s.ID,
+		"path traversal":   "../x." + s.ID + "." + strings.Repeat("0", 64),
+	} {
+		if _, _, ok := verifyRecordingShareToken(secret, bad); ok {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	if ok, err := revokeRecordingShare(testShareRecording, s.ID); !ok || err != nil {
+		t.Fatalf("revoke = %v, %v", ok, err)
+	}
+	if _, _, ok := verifyRecordingShareToken(secret, token); ok {
+		t.Error("token still valid after revoke")
+	}
+	if _, err := os.Stat(recordingSharesPath(testShareRecording)); !os.IsNotExist(err) {
+		t.Error("empty shares file not removed")
+	}
+
+	// Expired shares stop validating.
+	old, _ := createRecordingShare(testShareRecording, "", time.Hour, false)
+	past := time.Now().Add(-time.Second)
+	old.ExpiresAt = &past
+	if err := saveRecordingShares(testShareRecording, []recordingShare{old}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := verifyRecordingShareToken(secret, recordingShareToken(secret, testShareRecording, old)); ok {
+		t.Error("expired share validated")
+	}
+}
+
+func TestRecordingShareHandler(t *testing.T) {
+	const secret = "master"
+	withTempRecordingsDir(t)
+	writeTestRecordingLog(t)
+	viewOnly, _ := createRecordingShare(testShareRecording, "", 0, false)
+	withDownload, _ := createRecordingShare(testShareRecording, "", 0, true)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "127.0.0.1:7001"
+		rr := httptest.NewRecorder()
+		recordingShareHandler(secret)(rr, req)
+		return rr
+	}
+
+	token := recordingShareToken(secret, testShareRecording, viewOnly)
+	rr := get(recordingSharePrefix + token)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), token+"/session.log") || strings.Contains(rr.Body.String(), "/download") {
+		t.Fatalf("page: status %d\n%s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("X-Robots-Tag") == "" || rr.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("headers = %v", rr.Header())
+	}
+	if rr := get(recordingSharePrefix + token + "/session.log"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "hello from the run") {
+		t.Errorf("log: status %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := get(recordingSharePrefix + token + "/download"); rr.Code != http.StatusForbidden {
+		t.Errorf("download disabled: status %d", rr.Code)
+	}
+
+	token = recordingShareToken(secret, testShareRecording, withDownload)
+	if rr := get(recordingSharePrefix + token); !strings.Contains(rr.Body.String(), `href="`+token+`/download"`) {
+		t.Errorf("page has no download link:\n%s", rr.Body.String())
+	}
+	rr = get(recordingSharePrefix + token + "/download")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("download: status %d, disposition %q", rr.Code, rr.Header().Get("Content-Disposition"))
+	}
+
+	for _, path := range []string{recordingSharePrefix + "nope", recordingSharePrefix + token + "/metadata.json"} {
+		if rr := get(path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, rr.Code)
+		}
+	}
+}
+
+func TestRecordingShareAPIs(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	withTempRecordingsDir(t)
+	writeTestRecordingLog(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/recording/"+testShareRecording+"/share", strings.NewReader(`{"expiresIn":600,"label":"bug report"}`))
+	req.Host = "example.com"
+	rr := httptest.NewRecorder()
+	handleRecordingAPI(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var created recordingShareView
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Label != "bug report" || created.AllowDownload || created.ExpiresAt == nil ||
+		!strings.HasPrefix(created.URL, "http://example.com"+recordingSharePrefix+testShareRecording+".") {
+		t.Errorf("created = %+v", created)
+	}
+
+	rr = httptest.NewRecorder()
+	handleRecordingAPI(rr, httptest.NewRequest(http.MethodPost, "/api/recording/"+testShareRecording+"/share", strings.NewReader(`{"expiresIn":-1}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("bad expiry: status %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handleRecordingAPI(rr, httptest.NewRequest(http.MethodPost, "/api/recording/11111111-2222-3333-4444-555555555555/share", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown recording: status %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleRecordingAPI(rr, httptest.NewRequest(http.MethodGet, "/api/recording/"+testShareRecording+"/shares", nil))
+	var list struct {
+		Shares []recordingShareView `json:"shares"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Shares) != 1 || list.Shares[0].ID != created.ID || list.Shares[0].URL == "" {
+		t.Fatalf("list: %d %s", rr.Code, rr.Body.String())
+	}
+
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		handleRecordingAPI(rr, httptest.NewRequest(http.MethodDelete, "/api/recording/"+testShareRecording+"/shares/"+created.ID, nil))
+		if rr.Code != want {
+			t.Errorf("revoke: status %d, want %d", rr.Code, want)
+		}
+	}
+}
+
+func TestRecordingShareNeedsEmbeddedAuth(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "")
+	withTempRecordingsDir(t)
+	writeTestRecordingLog(t)
+	rr := httptest.NewRecorder()
+	handleRecordingAPI(rr, httptest.NewRequest(http.MethodPost, "/api/recording/"+testShareRecording+"/share", nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("status %d, want 409", rr.Code)
+	}
+}
+
+func TestAuthMiddlewareLetsRecordingSharesThrough(t *testing.T) {
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "master")
+	for path, want := range map[string]int{
+		recordingSharePrefix + "anything":  http.StatusOK,
+		"/recording/" + testShareRecording: http.StatusFound,
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rr.Code, want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildRecordingsFeed(t *testing.T) {
+	ended := time.Date(2026, 10, 12, 10, 5, 0, 0, time.UTC)
+	feed := buildRecordingsFeed([]RecordingInfo{
+		{UUID: testShareRecording, UUIDShort: "0b4c6f3e", Name: "fix flaky test", Agent: "Claude", StartedAt: ended.Add(-65 * time.Minute), EndedAt: ended, SummaryLine: "Tests pass now"},
+		{UUID: "u2", UUIDShort: "u2", Agent: "Codex", EndedAt: ended.Add(-time.Hour)},
+	}, "https://example.com")
+
+	if feed.Updated != "2026-10-12T10:05:00Z" || feed.ID != "https://example.com"+recordingsFeedPath || len(feed.Entries) != 2 {
+		t.Fatalf("feed = %+v", feed)
+	}
+	e := feed.Entries[0]
+	if e.Title != "fix flaky test" || e.Link.Href != "https://example.com/recording/"+testShareRecording || e.Author.Name != "Claude" {
+		t.Errorf("entry = %+v", e)
+	}
+	if e.Summary != "Claude in workspace, 1h 5m: Tests pass now" {
+		t.Errorf("summary = %q", e.Summary)
+	}
+	if e := feed.Entries[1]; e.Title != "session-u2" || e.Summary != "Codex in workspace" {
+		t.Errorf("untitled entry = %+v", e)
+	}
+
+	empty := buildRecordingsFeed(nil, "https://example.com")
+	if empty.Updated == "" || len(empty.Entries) != 0 {
+		t.Errorf("empty feed = %+v", empty)
+	}
+}
+
+func TestHandleRecordingsFeed(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	withTempRecordingsDir(t)
+	writeTestRecordingLog(t)
+	ended := time.Now().Add(-time.Minute)
+	writeMetadataFile(t, testShareRecording, RecordingMetadata{UUID: testShareRecording, Name: "fix <flaky> test", Agent: "Claude", StartedAt: ended.Add(-time.Hour), EndedAt: &ended})
+
+	get := func(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, recordingsFeedPath+query, nil)
+		req.Host = "example.com"
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handleRecordingsFeed(rr, req)
+		return rr
+	}
+
+	for name, rr := range map[string]*httptest.ResponseRecorder{
+		"key":    get("?key="+feedKey("master"), nil),
+		"cookie": get("", &http.Cookie{Name: authCookieName, Value: authSignCookie("master")}),
+	} {
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+			t.Fatalf("%s: status %d %q", name, rr.Code, rr.Header().Get("Content-Type"))
+		}
+		var feed atomFeed
+		if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil || len(feed.Entries) != 1 || feed.Entries[0].Title != "fix <flaky> test" {
+			t.Errorf("%s: feed = %+v, %v\n%s", name, feed, err, rr.Body.String())
+		}
+		if !strings.Contains(rr.Body.String(), "fix &lt;flaky&gt; test") {
+			t.Errorf("%s: title not escaped:\n%s", name, rr.Body.String())
+		}
+	}
+
+	for _, query := range []string{"", "?key=nope", "?key=" + feedKey("other")} {
+		if rr := get(query, nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%q: status %d, want 401", query, rr.Code)
+		}
+	}
+}
+
+func TestFeedURL(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "example.com"
+	t.Setenv("SWE_SWE_PASSWORD", "")
+	if got := feedURL(req, recordingsFeedPath); got != "http://example.com"+recordingsFeedPath {
+		t.Errorf("no password: %q", got)
+	}
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	if got := feedURL(req, recordingsFeedPath); got != "http://example.com"+recordingsFeedPath+"?key="+feedKey("master") {
+		t.Errorf("password: %q", got)
+	}
+}
+
+func TestAuthLetsFeedsThrough(t *testing.T) {
+	for _, path := range []string{recordingsFeedPath, sessionCalendarPath} {
+		rr := httptest.NewRecorder()
+		authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "master").
+			ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("middleware %s: status %d", path, rr.Code)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/swe-swe-auth/verify", nil)
+		req.Header.Set("X-Forwarded-Uri", path+"?key=x")
+		rr = httptest.NewRecorder()
+		authVerifyHandler("master")(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("forward auth %s: status %d", path, rr.Code)
+		}
+	}
+}
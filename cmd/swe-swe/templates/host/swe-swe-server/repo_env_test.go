@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateEnvContent(t *testing.T) {
+	ok := "# comment\n\nPATH=/usr/local/go/bin:$PATH\nMSG=\"hello world\"\nQ='a b'\nEMPTY=\nTOKEN=${secret:API}\n"
+	if errs := validateEnvContent(ok); len(errs) != 0 {
+		t.Errorf("valid content: %+v", errs)
+	}
+	for line, want := range map[string]string{
+		"JUST_A_KEY":      "not KEY=value",
+		"export FOO=bar":  "export",
+		"FOO =bar":        "spaces around",
+		"FOO= bar":        "spaces around",
+		"1FOO=bar":        "invalid key",
+		"FOO-BAR=x":       "invalid key",
+		"MSG=\"hello":     "same line",
+		"MSG='":           "same line",
+		"MSG=hello world": "quote",
+		"FOO=a\rb":        "carriage return",
+		"FOO=\x00":        "NUL",
+	} {
+		errs := validateEnvContent("A=1\n" + line + "\n")
+		if len(errs) != 1 || errs[0].Line != 2 || !strings.Contains(errs[0].Error, want) {
+			t.Errorf("%q: %+v, want line 2 %q", line, errs, want)
+		}
+	}
+	if errs := validateEnvContent(strings.Repeat("A=1\n", envFileMaxSize/4+1)); len(errs) != 1 || errs[0].Line != 0 {
+		t.Errorf("oversize: %+v", errs)
+	}
+	if got := normalizeEnvContent("A=1\r\nB=2"); got != "A=1\nB=2\n" {
+		t.Errorf("normalize = %q", got)
+	}
+}
+
+func TestRepoEnvAPI(t *testing.T) {
+	setPathPolicyRoots(t)
+	repo := filepath.Join(reposDir, "r", "workspace")
+	type envResponse struct {
+		Path, Content, Version string
+		Exists                 bool
+	}
+	call := func(method, path, body string) (*httptest.ResponseRecorder, envResponse) {
+		rr := httptest.NewRecorder()
+		handleRepoEnvAPI(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp envResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+	query := "/api/repo/env?path=" + repo
+
+	rr, got := call(http.MethodGet, query, "")
+	if rr.Code != http.StatusOK || got.Exists || got.Version != "" || got.Path != repo {
+		t.Fatalf("GET missing: %d %s", rr.Code, rr.Body)
+	}
+
+	events := subscribeAdminEvents()
+	defer unsubscribeAdminEvents(events)
+	rr, got = call(http.MethodPut, query, `{"content": "A=1\r\nB=2", "version": ""}`)
+	if rr.Code != http.StatusOK || !got.Exists || got.Content != "A=1\nB=2\n" {
+		t.Fatalf("PUT new: %d %s", rr.Code, rr.Body)
+	}
+	if data, err := os.ReadFile(filepath.Join(repo, ".swe-swe", "env")); err != nil || string(data) != "A=1\nB=2\n" {
+		t.Errorf("file = %q, %v", data, err)
+	}
+	select {
+	case data := <-events:
+		var ev adminEvent
+		if err := json.Unmarshal(data, &ev); err != nil || ev.Type != "env_changed" || ev.Repo != repo || strings.Join(ev.Keys, ",") != "A,B" {
+			t.Errorf("admin event = %s", data)
+		}
+	default:
+		t.Error("no env_changed admin event")
+	}
+	version := got.Version
+
+	// A stale version is refused; the current one is not.
+	if rr, _ := call(http.MethodPut, query, `{"content": "A=2\n", "version": ""}`); rr.Code != http.StatusConflict {
+		t.Errorf("stale PUT: %d %s", rr.Code, rr.Body)
+	}
+	if rr, got = call(http.MethodPut, query, `{"content": "A=2\n", "version": "`+version+`"}`); rr.Code != http.StatusOK || got.Version == version {
+		t.Errorf("PUT: %d %s", rr.Code, rr.Body)
+	}
+	if added, changed, removed := envKeyChanges("A=1\nB=2\n", "A=2\nC=3\n"); strings.Join(added, ",") != "C" || strings.Join(changed, ",") != "A" || strings.Join(removed, ",") != "B" {
+		t.Errorf("changes = %v %v %v", added, changed, removed)
+	}
+
+	// Invalid content is reported per line and not written.
+	rr, _ = call(http.MethodPut, query, `{"content": "A=1\nexport B=2\nC=\"x\n"}`)
+	var bad struct{ Errors []envLineError }
+	if json.Unmarshal(rr.Body.Bytes(), &bad); rr.Code != http.StatusUnprocessableEntity || len(bad.Errors) != 2 || bad.Errors[0].Line != 2 || bad.Errors[1].Line != 3 {
+		t.Errorf("invalid PUT: %d %s", rr.Code, rr.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo, ".swe-swe", "env")); string(data) != "A=2\n" {
+		t.Errorf("file after invalid PUT = %q", data)
+	}
+
+	// Paths outside the repos are refused.
+	if rr, _ := call(http.MethodGet, "/api/repo/env?path=/etc", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("GET /etc: %d", rr.Code)
+	}
+	if rr, _ := call(http.MethodPost, query, "{}"); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: %d", rr.Code)
+	}
+}
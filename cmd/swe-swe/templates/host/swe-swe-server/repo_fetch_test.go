@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRepoFetchInterval(t *testing.T) {
+	t.Cleanup(func() { repoFetchInterval = 0 })
+	for v, want := range map[string]time.Duration{"": 0, "off": 0, "0": 0, " 15m ": 15 * time.Minute} {
+		if err := loadRepoFetchInterval(v); err != nil || repoFetchInterval != want {
+			t.Errorf("%q: %s, %v", v, repoFetchInterval, err)
+		}
+	}
+	for _, v := range []string{"30s", "soon"} {
+		if err := loadRepoFetchInterval(v); err == nil {
+			t.Errorf("%q: want an error", v)
+		}
+	}
+}
+
+func TestFetchAllRepos(t *testing.T) {
+	setPathPolicyRoots(t)
+	t.Cleanup(func() { repoFetchState.results = map[string]repoFetchResult{} })
+	origin, run := gitTestRepo(t)
+	run("branch", "-M", "main")
+	run("branch", "stale")
+	if out, err := exec.Command("git", "clone", "-q", origin, workspaceDir).CombinedOutput(); err != nil {
+		t.Fatalf("clone: %v\n%s", err, out)
+	}
+	// A repo whose origin is gone fails without stopping the others.
+	broken := filepath.Join(reposDir, "r", "workspace")
+	if out, err := exec.Command("git", "clone", "-q", origin, broken).CombinedOutput(); err != nil {
+		t.Fatalf("clone: %v\n%s", err, out)
+	}
+	if out, err := exec.Command("git", "-C", broken, "remote", "set-url", "origin", filepath.Join(t.TempDir(), "gone")).CombinedOutput(); err != nil {
+		t.Fatalf("set-url: %v\n%s", err, out)
+	}
+	hookOut := filepath.Join(t.TempDir(), "hook.txt")
+	hookDir := filepath.Join(workspaceDir, ".swe-swe", "hooks")
+	if err := os.MkdirAll(hookDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	hook := "#!/bin/sh\necho \"$SWE_HOOK $SWE_BRANCH $SWE_BRANCH_COMMITS\" >> " + hookOut + "\n"
+	if err := os.WriteFile(filepath.Join(hookDir, hookBranchMoved), []byte(hook), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// main moves by two commits, stale does not, and a new branch appears.
+	run("commit", "-q", "--allow-empty", "-m", "two")
+	run("commit", "-q", "--allow-empty", "-m", "three")
+	run("branch", "brand-new")
+
+	events := subscribeAdminEvents()
+	defer unsubscribeAdminEvents(events)
+	results := fetchAllRepos()
+	if len(results) != 2 {
+		t.Fatalf("results = %+v", results)
+	}
+	ws, r := results[0], results[1]
+	if ws.Error != "" || len(ws.Moved) != 1 || ws.Moved[0].Branch != "main" || ws.Moved[0].Commits != 2 {
+		t.Errorf("workspace = %+v", ws)
+	}
+	if r.Repo != broken || r.Error == "" || len(r.Moved) != 0 {
+		t.Errorf("broken repo = %+v", r)
+	}
+	select {
+	case data := <-events:
+		var ev adminEvent
+		if err := json.Unmarshal(data, &ev); err != nil || ev.Type != "branch_moved" || ev.Repo != workspaceDir || ev.Branch != "main" || ev.Commits != 2 {
+			t.Errorf("admin event = %s", data)
+		}
+	default:
+		t.Error("no branch_moved admin event")
+	}
+	if data, err := os.ReadFile(hookOut); err != nil || strings.TrimSpace(string(data)) != "on-branch-moved main 2" {
+		t.Errorf("hook output = %q, %v", data, err)
+	}
+
+	// Nothing moved since: no moves the second time.
+	if again := fetchAllRepos(); len(again[0].Moved) != 0 {
+		t.Errorf("second fetch = %+v", again[0])
+	}
+
+	rr := httptest.NewRecorder()
+	handleRepoFetchAPI(rr, httptest.NewRequest(http.MethodGet, "/api/repos/fetch", nil))
+	var body struct {
+		Interval string
+		Repos    []repoFetchResult
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Interval != "off" || len(body.Repos) != 2 {
+		t.Errorf("status %d: %s", rr.Code, rr.Body.String())
+	}
+}
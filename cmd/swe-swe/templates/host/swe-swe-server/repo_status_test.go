@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoStatuses(t *testing.T) {
+	setPathPolicyRoots(t)
+	t.Cleanup(func() { repoStatusCache.entries = map[string]repoStatus{} })
+	origin, run := gitTestRepo(t)
+	run("branch", "-M", "main")
+	gitAt := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=t", "-c", "user.email=t@example.com", "-c", "commit.gpgsign=false"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	clone := filepath.Join(reposDir, "r", "workspace")
+	gitAt(origin, "clone", "-q", origin, workspaceDir)
+	gitAt(origin, "clone", "-q", origin, clone)
+
+	// origin moves on by two commits; only the workspace fetches them.
+	run("commit", "-q", "--allow-empty", "-m", "two")
+	run("commit", "-q", "--allow-empty", "-m", "three")
+	gitAt(workspaceDir, "fetch", "-q")
+	gitAt(workspaceDir, "commit", "-q", "--allow-empty", "-m", "local")
+	if err := os.WriteFile(filepath.Join(workspaceDir, "README.md"), []byte("edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := repoStatuses()
+	if len(got) != 2 {
+		t.Fatalf("statuses = %+v", got)
+	}
+	ws, r := got[0], got[1]
+	if ws.Name != "workspace" || ws.Branch != "main" || ws.Upstream != "origin/main" || ws.Ahead != 1 || ws.Behind != 2 || ws.Dirty != 1 || ws.LastFetch == nil || ws.Error != "" {
+		t.Errorf("workspace = %+v", ws)
+	}
+	if r.Name != "r" || r.Path != clone || r.Behind != 0 || r.Dirty != 0 || r.Warning() != "" {
+		t.Errorf("clone = %+v", r)
+	}
+	if w := ws.Warning(); w != "workspace is 2 commits behind origin/main (fetched just now)" {
+		t.Errorf("warning = %q", w)
+	}
+
+	// Cached until repoStatusTTL passes.
+	gitAt(workspaceDir, "pull", "-q", "--no-rebase", "--no-edit")
+	if again := repoStatuses(); again[0].Behind != 2 {
+		t.Errorf("not cached: %+v", again[0])
+	}
+	repoStatusCache.mu.Lock()
+	for path, s := range repoStatusCache.entries {
+		s.CheckedAt = s.CheckedAt.Add(-repoStatusTTL)
+		repoStatusCache.entries[path] = s
+	}
+	repoStatusCache.mu.Unlock()
+	if again := repoStatuses(); again[0].Behind != 0 || again[0].Ahead != 2 {
+		t.Errorf("after TTL: %+v", again[0])
+	}
+	if w := repoStatusWarnings(); len(w) != 0 {
+		t.Errorf("warnings = %q", w)
+	}
+}
+
+func TestRepoStatusNoUpstream(t *testing.T) {
+	setPathPolicyRoots(t)
+	t.Cleanup(func() { repoStatusCache.entries = map[string]repoStatus{} })
+	dir, _ := gitTestRepo(t)
+	s := computeRepoStatus(dir)
+	if s.Branch == "" || s.Upstream != "" || s.Behind != 0 || s.LastFetch != nil || s.Error != "" {
+		t.Errorf("status = %+v", s)
+	}
+	if s := computeRepoStatus(t.TempDir()); s.Error == "" {
+		t.Errorf("not a repo: %+v", s)
+	}
+
+	rr := httptest.NewRecorder()
+	handleRepoStatusAPI(rr, httptest.NewRequest(http.MethodGet, "/api/repos/status", nil))
+	var body struct{ Repos []repoStatus }
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusOK || body.Repos == nil || len(body.Repos) != 0 {
+		t.Errorf("status %d: %s", rr.Code, rr.Body.String())
+	}
+}
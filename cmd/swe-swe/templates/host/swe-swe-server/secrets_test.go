@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// resetSecrets forgets what secretState loaded and gave out, and loads the
+// (test) recordings dir's store.
+func resetSecrets(t *testing.T) {
+	t.Helper()
+	forget := func() {
+		secretState.mu.Lock()
+		secretState.dir, secretState.stored, secretState.values = "", nil, nil
+		secretState.given, secretState.masks, secretState.dirty = map[string]map[string]bool{}, nil, true
+		secretState.mu.Unlock()
+	}
+	forget()
+	t.Cleanup(forget)
+	loadSecrets(recordingsDir)
+}
+
+func TestSecretsStoreAndExpand(t *testing.T) {
+	h := newTestHelper(t)
+	defer h.cleanup()
+	resetSecrets(t)
+	t.Setenv("SWE_SECRETS_KEY", "")
+	t.Setenv("SWE_SECRET_FROM_ENV", "env-value-42")
+	dir := t.TempDir()
+	t.Setenv("SWE_SECRETS_DIR", dir)
+	if err := os.WriteFile(filepath.Join(dir, "FROM_FILE"), []byte("file-value-7\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storeSecret("API_KEY", "sk-live-123456"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(secretsKeyPath()); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("secrets.key: %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(secretsPath()); !strings.Contains(string(data), "API_KEY") || strings.Contains(string(data), "sk-live") {
+		t.Errorf("secrets.json = %s", data)
+	}
+
+	// Nothing is masked before it is stored or handed out.
+	if got := maskSecrets("env-value-42"); got != "env-value-42" {
+		t.Errorf("masked before use: %q", got)
+	}
+	got := parseEnvLines("A=${secret:API_KEY}\nB=x${secret:MISSING}y\nC=${secret:FROM_ENV}\nD=${secret:FROM_FILE}\nE=$A", "sid-1", nil, nil, nil)
+	want := []string{"A=sk-live-123456", "B=xy", "C=env-value-42", "D=file-value-7", "E=sk-live-123456"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expanded = %q", got)
+	}
+	if got := maskSecrets("key sk-live-123456, env env-value-42, short ab"); got != "key **************, env ************, short ab" {
+		t.Errorf("masked = %q", got)
+	}
+
+	infos := secretInfos()
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name+":"+info.Source)
+	}
+	if strings.Join(names, " ") != "API_KEY:store FROM_ENV:env FROM_FILE:file" {
+		t.Errorf("infos = %q", names)
+	}
+
+	// The store is read once: masking keeps working from memory.
+	loadSecrets(recordingsDir)
+	if got := maskSecrets("sk-live-123456"); got != "**************" {
+		t.Errorf("masked after reload = %q", got)
+	}
+	// A value that is not stored is masked only while its session lives.
+	forgetSessionSecrets("sid-1")
+	if got := maskSecrets("env-value-42"); got != "env-value-42" {
+		t.Errorf("masked after the session ended: %q", got)
+	}
+
+	// Another key cannot read the store.
+	t.Setenv("SWE_SECRETS_KEY", "a different key")
+	if _, _, err := lookupSecret("API_KEY"); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+	if infos := secretInfos(); infos[0].Error == "" {
+		t.Errorf("infos = %+v", infos)
+	}
+}
+
+func TestSecretRedactorAcrossWrites(t *testing.T) {
+	h := newTestHelper(t)
+	defer h.cleanup()
+	resetSecrets(t)
+	t.Setenv("SWE_SECRETS_KEY", "test key")
+	if err := storeSecret("TOKEN", "hunter2-token"); err != nil {
+		t.Fatal(err)
+	}
+	now, _ := fakeClock()
+	r, err := startSessionRecorderAt("session-secret", "claude", 24, 80, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Output([]byte("export T=hunter2"))
+	r.Output([]byte("-token; echo hunter2-token\r\n"))
+	r.Close(0)
+
+	logData, timing, _ := readRecording(t, "session-secret")
+	if strings.Contains(logData, "hunter2") || !strings.Contains(logData, "export T=*************; echo *************\r\n") {
+		t.Errorf("log = %q", logData)
+	}
+	if !strings.Contains(timing, " 16\n") || !strings.Contains(timing, " 28\n") {
+		t.Errorf("timing = %q", timing)
+	}
+}
+
+func TestSecretsAPI(t *testing.T) {
+	h := newTestHelper(t)
+	defer h.cleanup()
+	resetSecrets(t)
+	t.Setenv("SWE_SECRETS_KEY", "test key")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleSecretsAPI(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	if rec := do(http.MethodPut, "/api/secrets/DB_PASSWORD", `{"value": "correct horse"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT = %d %s", rec.Code, rec.Body)
+	}
+	parseEnvLines("DB=${secret:DB_PASSWORD}", "sid-db", nil, nil, nil)
+	rec := do(http.MethodGet, "/api/secrets", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"DB_PASSWORD","source":"store"`) || strings.Contains(rec.Body.String(), "horse") {
+		t.Errorf("GET = %d %s", rec.Code, rec.Body)
+	}
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPut, "/api/secrets/not-a-name", `{"value": "x"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/secrets/X", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/secrets", "", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/secrets/DB_PASSWORD", "", http.StatusNoContent},
+		{http.MethodDelete, "/api/secrets/DB_PASSWORD", "", http.StatusNotFound},
+	} {
+		if rec := do(tc.method, tc.path, tc.body); rec.Code != tc.want {
+			t.Errorf("%s %s = %d %s, want %d", tc.method, tc.path, rec.Code, rec.Body, tc.want)
+		}
+	}
+	// A deleted secret stays masked while a session that has it lives.
+	if got := maskSecrets("correct horse"); got != "*************" {
+		t.Errorf("masked after delete = %q", got)
+	}
+	forgetSessionSecrets("sid-db")
+	if got := maskSecrets("correct horse"); got != "correct horse" {
+		t.Errorf("masked after delete and session end = %q", got)
+	}
+	if !adminOnlyPath("/api/secrets") || !adminOnlyPath("/api/secrets/DB_PASSWORD") {
+		t.Error("secrets API not admin-only")
+	}
+}
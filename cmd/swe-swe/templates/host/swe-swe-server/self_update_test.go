@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeReleases serves a GitHub release tagged tag whose assets are files.
+func fakeReleases(t *testing.T, tag string, files map[string][]byte) {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/repos/"+selfUpdateRepo+"/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		rel := releaseInfo{Tag: tag, HTMLURL: "https://example.test/" + tag}
+		for name := range files {
+			rel.Assets = append(rel.Assets, releaseAsset{Name: name, URL: srv.URL + "/download/" + name})
+		}
+		json.NewEncoder(w).Encode(rel)
+	})
+	mux.HandleFunc("/download/", func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[strings.TrimPrefix(r.URL.Path, "/download/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	})
+	saved := githubAPIURL
+	githubAPIURL = srv.URL
+	t.Cleanup(func() { githubAPIURL = saved })
+}
+
+func sha256Line(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name)
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		cmp  int
+		ok   bool
+	}{
+		{"v1.2.3", "v1.2.4", -1, true},
+		{"1.10.0", "v1.9", 1, true},
+		{"v2.0", "2.0.0", 0, true},
+		{"v2.1.0-rc1", "v2.1.0", 0, true},
+		{"dev", "v1.0.0", 0, false},
+		{"v1.x", "v1.0", 0, false},
+	} {
+		if cmp, ok := compareVersions(tc.a, tc.b); cmp != tc.cmp || ok != tc.ok {
+			t.Errorf("compareVersions(%q, %q) = %d, %v; want %d, %v", tc.a, tc.b, cmp, ok, tc.cmp, tc.ok)
+		}
+	}
+}
+
+func TestDownloadVerified(t *testing.T) {
+	name := serverAssetName(runtime.GOOS, runtime.GOARCH)
+	bin := []byte("new server binary")
+	sums := []byte(sha256Line("other", []byte("x")) + sha256Line(name, bin))
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	sig := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, sums)))
+	fakeReleases(t, "v9.0.0", map[string][]byte{
+		name:                         bin,
+		selfUpdateChecksums:          sums,
+		selfUpdateChecksums + ".sig": sig,
+	})
+	ctx := context.Background()
+	rel, err := fetchRelease(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := downloadVerified(ctx, rel, name, nil); err != nil || string(got) != string(bin) {
+		t.Errorf("unsigned: %q, %v", got, err)
+	}
+	if got, err := downloadVerified(ctx, rel, name, pub); err != nil || string(got) != string(bin) {
+		t.Errorf("signed: %q, %v", got, err)
+	}
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := downloadVerified(ctx, rel, name, other); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("wrong key: %v", err)
+	}
+	if _, err := downloadVerified(ctx, rel, "swe-swe-server.plan9-mips", nil); err == nil {
+		t.Error("missing asset downloaded")
+	}
+}
+
+func TestDownloadVerifiedChecksum(t *testing.T) {
+	name := serverAssetName(runtime.GOOS, runtime.GOARCH)
+	fakeReleases(t, "v9.0.0", map[string][]byte{
+		name:                []byte("tampered"),
+		selfUpdateChecksums: []byte(sha256Line(name, []byte("original"))),
+	})
+	rel, err := fetchRelease(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := downloadVerified(context.Background(), rel, name, nil); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("tampered binary: %v", err)
+	}
+	rel.Assets = []releaseAsset{{Name: name, URL: rel.asset(name).URL}}
+	if _, err := downloadVerified(context.Background(), rel, name, nil); err == nil || !strings.Contains(err.Error(), "unverified") {
+		t.Errorf("no checksums: %v", err)
+	}
+}
+
+func TestReplaceExecutable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "swe-swe-server")
+	if err := os.WriteFile(path, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceExecutable(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	info, _ := os.Stat(path)
+	if string(got) != "new" || info.Mode().Perm() != 0o755 {
+		t.Errorf("got %q mode %v", got, info.Mode())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temp file left behind: %d entries", len(entries))
+	}
+}
+
+func TestVersionCheckAPI(t *testing.T) {
+	fakeReleases(t, "v9.0.0", map[string][]byte{serverAssetName(runtime.GOOS, runtime.GOARCH): nil})
+	savedVersion := Version
+	Version = "v1.0.0"
+	t.Cleanup(func() {
+		Version = savedVersion
+		versionCheckRelease = nil
+	})
+
+	rr := httptest.NewRecorder()
+	handleVersionCheckAPI(rr, httptest.NewRequest(http.MethodGet, "/api/version/check?refresh=1", nil))
+	var vc versionCheck
+	if err := json.Unmarshal(rr.Body.Bytes(), &vc); err != nil {
+		t.Fatalf("%d %s", rr.Code, rr.Body)
+	}
+	if vc.Latest != "v9.0.0" || !vc.UpdateAvailable || !vc.AssetFound {
+		t.Errorf("got %+v", vc)
+	}
+
+	rr = httptest.NewRecorder()
+	handleVersionCheckAPI(rr, httptest.NewRequest(http.MethodPost, "/api/version/check", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: %d", rr.Code)
+	}
+	if !adminOnlyPath("/api/version/check") {
+		t.Error("version check not admin-only")
+	}
+}
+
+func TestRestartDrainRefusesNewSessions(t *testing.T) {
+	restartDraining.Store(true)
+	t.Cleanup(func() { restartDraining.Store(false) })
+	registerTestSession(t, "drain-open", &Session{Assistant: "claude"})
+
+	if _, _, err := getOrCreateSession(SessionParams{UUID: "drain-new", Assistant: "claude"}, true); !errors.Is(err, errServerRestarting) {
+		t.Errorf("new session while draining: err = %v", err)
+	}
+	if sess, created, err := getOrCreateSession(SessionParams{UUID: "drain-open"}, false); err != nil || created || sess == nil {
+		t.Errorf("open session while draining = %v, %v, %v", sess, created, err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeriveShellUUID(t *testing.T) {
+	// Values from static/modules/uuid.js.
+	for parent, want := range map[string]string{
+		"3f2a9c1e-8b7d-4e6f-a5c4-1d2e3f4a5b6c": "ffe3156a-d792-4c0f-b7c9-68f423045c19",
+		"00000000-0000-0000-0000-000000000000": "4bff524b-4399-4711-9d15-6357ca6ef71d",
+	} {
+		if got := deriveShellUUID(parent); got != want {
+			t.Errorf("deriveShellUUID(%s) = %s, want %s", parent, got, want)
+		}
+	}
+}
+
+// newActionsTestSession is a claude session in a worktree with a running
+// process, a test command and one project slash command.
+func newActionsTestSession(t *testing.T) *Session {
+	t.Helper()
+	workDir := t.TempDir()
+	os.MkdirAll(filepath.Join(workDir, ".claude", "commands"), 0755)
+	os.WriteFile(filepath.Join(workDir, ".claude", "commands", "review.md"), []byte("---\ndescription: Review the diff\n---\nReview it."), 0644)
+
+	cmd := exec.Command("sleep", "60")
+	cmd.Env = []string{"SWE_TEST_CMD=go test ./..."}
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill(); cmd.Wait() })
+
+	return &Session{
+		UUID:       "3f2a9c1e-8b7d-4e6f-a5c4-1d2e3f4a5b6c",
+		Assistant:  "claude",
+		WorkDir:    workDir,
+		BranchName: "fix-login",
+		Cmd:        cmd,
+		AssistantConfig: AssistantConfig{
+			ShellRestartCmd: "claude --continue",
+			YoloRestartCmd:  "claude --continue --dangerously-skip-permissions",
+		},
+	}
+}
+
+func TestSessionActions(t *testing.T) {
+	sess := newActionsTestSession(t)
+	sess.yoloMode = true
+
+	var got []string
+	for _, a := range sessionActions(sess, "en", "", "") {
+		if !a.Enabled {
+			t.Errorf("%s disabled: %s", a.ID, a.Reason)
+		}
+		got = append(got, a.ID+" "+a.Label)
+	}
+	want := []string{
+		"restart_agent Restart agent",
+		"toggle_yolo Turn YOLO mode off",
+		"open_shell Open terminal",
+		"publish_worktree Publish fix-login",
+		"run_tests Run tests",
+		"macro /review",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("actions = %q", got)
+	}
+
+	sess.tests.latest = &testResult{Status: "running"}
+	sess.ParentUUID = "parent"
+	sess.BranchName = ""
+	for _, a := range sessionActions(sess, "es", "", "not allowed for role ci") {
+		switch a.ID {
+		case "open_shell", "publish_worktree":
+			t.Errorf("%s listed for a shell session outside a worktree", a.ID)
+		case "toggle_yolo", "run_tests":
+			if a.Enabled {
+				t.Errorf("%s enabled", a.ID)
+			}
+		case "restart_agent":
+			if a.Label != "Reiniciar el agente" {
+				t.Errorf("label %q", a.Label)
+			}
+		}
+	}
+}
+
+func TestSessionActionsAPIViewer(t *testing.T) {
+	withAuthUsers(t, "master", "carol:viewer:pw-c", "")
+	sess := newActionsTestSession(t)
+	registerTestSession(t, sess.UUID, sess)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/session/"+sess.UUID+"/actions", nil)
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignUserCookie("master", "carol")})
+	rr := httptest.NewRecorder()
+	handleSessionActionsAPI(rr, req)
+	var resp struct {
+		Actions []sessionAction `json:"actions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Actions) != 6 {
+		t.Fatalf("actions = %s", rr.Body.String())
+	}
+	for _, a := range resp.Actions {
+		if a.Enabled || a.Reason != "not allowed for role viewer" {
+			t.Errorf("%s: enabled %v, reason %q", a.ID, a.Enabled, a.Reason)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handleSessionActionsAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/missing/actions", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("missing session: status %d", rr.Code)
+	}
+}
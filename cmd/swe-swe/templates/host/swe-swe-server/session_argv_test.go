@@ -81,24 +81,19 @@ func TestSessionPageQueryEncodeExtraArgs(t *testing.T) {
 	}
 }
 
-// TestAgentArgvThroughWrapWithScript covers the full chain that actually
-// reaches the kernel: buildAgentArgv -> wrapWithScript. The unit test for
-// buildAgentArgv alone is misleading because wrapWithScript immediately
-// re-flattens the slice into a single string fed to bash -c "script ... -c ...",
-// so the slice discipline buildAgentArgv enforces is lost.
-func TestAgentArgvThroughWrapWithScript(t *testing.T) {
-	// Override recordingsDir so the test does not depend on /workspace.
-	old := recordingsDir
-	recordingsDir = "/tmp"
-	defer func() { recordingsDir = old }()
-
+// TestAgentArgvThroughWrapWithShell covers the full chain that actually
+// reaches the kernel: buildAgentArgv -> wrapWithShell. The unit test for
+// buildAgentArgv alone is misleading because wrapWithShell immediately
+// re-flattens the slice into a single string fed to bash -c, so the slice
+// discipline buildAgentArgv enforces is lost.
+func TestAgentArgvThroughWrapWithShell(t *testing.T) {
 	cn, ca := buildAgentArgv("claude --dangerously-skip-permissions", "--channels server:agent-chat")
-	cn, ca = wrapWithScript(cn, ca, "session-test")
+	cn, ca = wrapWithShell(cn, ca)
 	if cn != "bash" {
 		t.Fatalf("wrapper cmd: got %q, want bash", cn)
 	}
 	if len(ca) != 2 || ca[0] != "-c" {
-		t.Fatalf("wrapper args shape: got %#v, want [-c <script>]", ca)
+		t.Fatalf("wrapper args shape: got %#v, want [-c <command line>]", ca)
 	}
 	want := `claude --dangerously-skip-permissions --channels server:agent-chat`
 	if !strings.Contains(ca[1], want) {
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPromptSessionName(t *testing.T) {
+	for prompt, want := range map[string]string{
+		"Fix the login redirect, it loops on Safari!":                             "Fix the login redirect it loops on Safari",
+		"add tests for parser.go":                                                 "add tests for parser.go",
+		"R\u00e9sum\u00e9 upload: support PDFs (and DOCX?)":                       "Resume upload support PDFs and DOCX",
+		"please refactor the session manager so that each session owns its ports": "please refactor the session manager so that each",
+		"...":                               "",
+		"?! x":                              "",
+		"\u4fee\u590d":                      "",
+		"see https://example.com/issues/42": "see https example.com/issues/42",
+	} {
+		if got := promptSessionName(prompt); got != want {
+			t.Errorf("promptSessionName(%q) = %q, want %q", prompt, got, want)
+		}
+		if got := promptSessionName(prompt); len(got) > autoNameMaxLen {
+			t.Errorf("promptSessionName(%q) is %d bytes", prompt, len(got))
+		}
+	}
+}
+
+// autoNameTest is a namer over a fake session name; renamed receives each
+// rename.
+func autoNameTest(t *testing.T, derived, name string) (*sessionAutoNamer, chan string) {
+	t.Helper()
+	t.Setenv("SWE_SESSION_AUTONAME", "1")
+	renamed := make(chan string, 1)
+	a := newSessionAutoNamer(derived, func() string { return name }, func(n string) error {
+		name = n
+		renamed <- n
+		return nil
+	})
+	return a, renamed
+}
+
+func TestSessionAutoNamer(t *testing.T) {
+	a, renamed := autoNameTest(t, "owner/repo@main", "owner/repo@main")
+	a.noteInput([]byte("y\r"), false) // answering a menu is not a prompt
+	a.noteInput([]byte("fix teh"), false)
+	a.noteInput([]byte("\x7f\x7fhe flaky test\r"), false)
+	select {
+	case got := <-renamed:
+		if got != "fix the flaky test" {
+			t.Fatalf("renamed to %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never renamed")
+	}
+
+	// Only the first prompt names the session.
+	a.noteInput([]byte("now something else\r"), false)
+	select {
+	case got := <-renamed:
+		t.Fatalf("renamed again to %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !a.named("fix the flaky test") || a.named("owner/repo@main") {
+		t.Error("named() does not match the name given")
+	}
+}
+
+func TestSessionAutoNamerKeepsNames(t *testing.T) {
+	// A name the user gave is kept.
+	a, renamed := autoNameTest(t, "owner/repo@main", "Login work")
+	a.noteInput([]byte("fix the login\r"), false)
+	// A password typed with echo off is not a prompt; the next one is.
+	b, renamedB := autoNameTest(t, "", "")
+	b.noteInput([]byte("hunter2\r"), true)
+	b.noteInput([]byte("deploy it\r"), false)
+
+	select {
+	case got := <-renamedB:
+		if got != "deploy it" {
+			t.Errorf("renamed to %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never renamed")
+	}
+	select {
+	case got := <-renamed:
+		t.Errorf("user's name replaced by %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	t.Setenv("SWE_SESSION_AUTONAME", "")
+	if newSessionAutoNamer("", nil, nil) != nil {
+		t.Error("namer without SWE_SESSION_AUTONAME=1")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseK8sVolumes(t *testing.T) {
+	got, err := parseK8sVolumes("repos:/repos, worktrees:/worktrees")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []k8sVolume{{"repos", "/repos"}, {"worktrees", "/worktrees"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	for _, bad := range []string{"repos", "repos:relative", ":/x"} {
+		if _, err := parseK8sVolumes(bad); err == nil {
+			t.Errorf("parseK8sVolumes(%q) should fail", bad)
+		}
+	}
+}
+
+func TestLoadK8sPodConfigRequiresImage(t *testing.T) {
+	env := map[string]string{"SWE_K8S_NAMESPACE": "dev"}
+	if _, err := loadK8sPodConfig(func(k string) string { return env[k] }); err == nil || !strings.Contains(err.Error(), "SWE_K8S_IMAGE") {
+		t.Errorf("missing image: err = %v", err)
+	}
+	env["SWE_K8S_IMAGE"] = "ghcr.io/acme/dev:1"
+	env["SWE_K8S_NAMESPACE"] = "bad ns"
+	if _, err := loadK8sPodConfig(func(k string) string { return env[k] }); err == nil {
+		t.Error("namespace with a space should fail")
+	}
+}
+
+func TestK8sPodName(t *testing.T) {
+	if got := k8sPodName("0F8E-ab12"); got != "swe-swe-0f8e-ab12" {
+		t.Errorf("k8sPodName = %q", got)
+	}
+	if got := k8sPodName(strings.Repeat("a", 80)); len(got) > 63 {
+		t.Errorf("k8sPodName too long: %d", len(got))
+	}
+}
+
+func TestK8sPodManifest(t *testing.T) {
+	cfg := k8sPodConfig{Namespace: "dev", Image: "img:1", CPU: "2", Memory: "4Gi", Volumes: []k8sVolume{{"repos", "/repos"}}}
+	env := []string{"PATH=/host/bin", "SESSION_UUID=u1", "PORT=3000", "PORT=3001"}
+	data, err := json.Marshal(k8sPodManifest(cfg, "swe-swe-u1", "u1", "/repos/app", env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pod struct {
+		Metadata struct {
+			Name      string            `json:"name"`
+			Namespace string            `json:"namespace"`
+			Labels    map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Spec struct {
+			RestartPolicy string `json:"restartPolicy"`
+			Containers    []struct {
+				Image        string              `json:"image"`
+				WorkingDir   string              `json:"workingDir"`
+				Env          []map[string]string `json:"env"`
+				Resources    map[string]map[string]string
+				VolumeMounts []map[string]string `json:"volumeMounts"`
+			} `json:"containers"`
+			Volumes []map[string]any `json:"volumes"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Metadata.Name != "swe-swe-u1" || pod.Metadata.Namespace != "dev" || pod.Metadata.Labels["swe-swe/session"] != "u1" {
+		t.Errorf("metadata = %+v", pod.Metadata)
+	}
+	c := pod.Spec.Containers[0]
+	if c.Image != "img:1" || c.WorkingDir != "/repos/app" || pod.Spec.RestartPolicy != "Never" {
+		t.Errorf("container = %+v", c)
+	}
+	wantEnv := []map[string]string{{"name": "SESSION_UUID", "value": "u1"}, {"name": "PORT", "value": "3001"}}
+	if !reflect.DeepEqual(c.Env, wantEnv) {
+		t.Errorf("env = %v, want %v (PATH dropped, last PORT wins)", c.Env, wantEnv)
+	}
+	if c.Resources["limits"]["memory"] != "4Gi" || c.Resources["requests"]["cpu"] != "2" {
+		t.Errorf("resources = %v", c.Resources)
+	}
+	if len(c.VolumeMounts) != 1 || c.VolumeMounts[0]["mountPath"] != "/repos" || len(pod.Spec.Volumes) != 1 {
+		t.Errorf("volumes = %v / %v", c.VolumeMounts, pod.Spec.Volumes)
+	}
+}
+
+func TestK8sBackendCommand(t *testing.T) {
+	b := &k8sBackend{namespace: "dev", pod: "swe-swe-u1"}
+	name, args := b.Command("claude", []string{"-c"}, nil)
+	want := []string{"-n", "dev", "exec", "-it", "swe-swe-u1", "-c", "session", "--", "claude", "-c"}
+	if name != "kubectl" || !reflect.DeepEqual(args, want) {
+		t.Errorf("Command = %s %q, want kubectl %q", name, args, want)
+	}
+	b.namespace = ""
+	if _, args := b.Command("claude", nil, nil); args[0] != "exec" {
+		t.Errorf("no namespace: args = %q", args)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewSSHBackend(t *testing.T) {
+	env := []string{"SWE_SSH_HOST=build1", "SWE_SSH_USER=ci", "SWE_SSH_PORT=2222", "SWE_SSH_KEY=/keys/id_ed25519", "PORT=3001", "AGENT_CHAT_PORT=4001"}
+	b, err := newSSHBackend(backendParams{SessionUUID: "u1", WorkDir: "/repos/app", Env: env})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.dest != "ci@build1" || b.workDir != "/repos/app" || !reflect.DeepEqual(b.ports, []string{"3001", "4001"}) {
+		t.Errorf("backend = %+v", b)
+	}
+	opts := strings.Join(b.opts, " ")
+	for _, want := range []string{"BatchMode=yes", "-p 2222", "-i /keys/id_ed25519", "ControlPath="} {
+		if !strings.Contains(opts, want) {
+			t.Errorf("opts %q missing %q", opts, want)
+		}
+	}
+	master := strings.Join(b.masterArgs(), " ")
+	if !strings.Contains(master, "-L 127.0.0.1:3001:127.0.0.1:3001") || !strings.HasSuffix(master, "-N -o ControlMaster=yes -o ExitOnForwardFailure=yes -L 127.0.0.1:3001:127.0.0.1:3001 -L 127.0.0.1:4001:127.0.0.1:4001 ci@build1") {
+		t.Errorf("masterArgs = %s", master)
+	}
+
+	for _, bad := range [][]string{
+		{},
+		{"SWE_SSH_HOST=-oProxyCommand=x"},
+		{"SWE_SSH_HOST=build1", "SWE_SSH_PORT=22x"},
+		{"SWE_SSH_HOST=build1", "SWE_SSH_DIR=/my repo"},
+	} {
+		if _, err := newSSHBackend(backendParams{WorkDir: "/w", Env: append([]string{"SWE_SSH_HOST="}, bad...)}); err == nil {
+			t.Errorf("newSSHBackend(%q) should fail", bad)
+		}
+	}
+}
+
+// TestSSHRemoteCommandSurvivesLocalShell checks the remote command reaches ssh
+// as one argument, unchanged, through the bash -c that wrapWithShell runs it in.
+func TestSSHRemoteCommandSurvivesLocalShell(t *testing.T) {
+	b := &sshBackend{dest: "build1", workDir: "/repos/app"}
+	remote := b.remoteCommand("u1", "claude", []string{"--append-system-prompt", "'it''s fine'"})
+	if want := "cd '/repos/app' && . ~/.swe-swe/session-env/u1 && exec claude --append-system-prompt 'it''s fine'"; remote != want {
+		t.Fatalf("remoteCommand = %q\nwant %q", remote, want)
+	}
+	fullCmd := "printf %s " + shellQuote(remote)
+	out, err := exec.Command("bash", "-c", fullCmd).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != remote {
+		t.Errorf("after local shells: %q\nwant %q", out, remote)
+	}
+}
+
+func TestSSHEnvScript(t *testing.T) {
+	env := []string{"PATH=/host/bin", "SESSION_UUID=u1", "TOKEN=a'b $c\nd", "TOKEN=x'y"}
+	script := sshEnvScript(env)
+	if strings.Contains(script, "PATH=") {
+		t.Errorf("host-only PATH forwarded: %q", script)
+	}
+	out, err := exec.Command("sh", "-c", script+`printf '%s|%s' "$SESSION_UUID" "$TOKEN"`).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "u1|x'y" {
+		t.Errorf("sourced env = %q", out)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveSessionBackend(t *testing.T) {
+	for _, spec := range []string{"", "host", " host "} {
+		b, err := resolveSessionBackend(backendParams{WorkDir: "/workspace", Env: []string{"SWE_SESSION_BACKEND=" + spec}})
+		if err != nil {
+			t.Fatalf("resolveSessionBackend(%q): %v", spec, err)
+		}
+		if _, ok := b.(hostBackend); !ok {
+			t.Errorf("resolveSessionBackend(%q) = %T, want hostBackend", spec, b)
+		}
+	}
+
+	b, err := resolveSessionBackend(backendParams{WorkDir: "/workspace/app", Env: []string{"SWE_SESSION_BACKEND=docker:dev"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (containerBackend{kind: "docker", container: "dev", workDir: "/workspace/app"}); b != want {
+		t.Errorf("docker:dev = %+v, want %+v", b, want)
+	}
+	b, err = resolveSessionBackend(backendParams{WorkDir: "/workspace/app", Env: []string{"SWE_SESSION_BACKEND=docker:dev:/src"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb := b.(containerBackend); cb.workDir != "/src" || b.Name() != "docker:dev" {
+		t.Errorf("docker:dev:/src = %+v (%s)", cb, b.Name())
+	}
+
+	for _, bad := range []string{"docker:", "podman:dev", "docker:dev:/my src", "docker:a;b"} {
+		if _, err := resolveSessionBackend(backendParams{WorkDir: "/workspace", Env: []string{"SWE_SESSION_BACKEND=" + bad}}); err == nil {
+			t.Errorf("resolveSessionBackend(%q) should fail", bad)
+		}
+	}
+}
+
+func TestContainerBackendCommand(t *testing.T) {
+	b := containerBackend{kind: "devcontainer", container: "abc123", workDir: "/workspaces/app", user: "node"}
+	env := []string{
+		"TERM=xterm-256color", "PORT=3000", "PATH=/host/bin", "HOME=/root",
+		"GIT_CONFIG_GLOBAL=/host/gitconfig", "SESSION_UUID=u1", "PORT=3001",
+		"BASH_FUNC_x%%=() { :; }",
+	}
+	name, args := b.Command("claude", []string{"--resume", "x"}, env)
+	want := []string{
+		"exec", "-it", "-u", "node", "-w", "/workspaces/app",
+		"-e", "TERM", "-e", "PORT", "-e", "SESSION_UUID",
+		"abc123", "claude", "--resume", "x",
+	}
+	if name != "docker" || !reflect.DeepEqual(args, want) {
+		t.Errorf("Command = %s %q\nwant docker %q", name, args, want)
+	}
+	// Values are never on the command line.
+	if joined := strings.Join(args, " "); strings.Contains(joined, "3000") || strings.Contains(joined, "xterm") {
+		t.Errorf("env values leaked into argv: %s", joined)
+	}
+}
+
+func TestHostBackendCommandUnchanged(t *testing.T) {
+	name, args := hostBackend{}.Command("claude", []string{"-p"}, nil)
+	if name != "claude" || !reflect.DeepEqual(args, []string{"-p"}) {
+		t.Errorf("hostBackend.Command = %s %q", name, args)
+	}
+}
+
+func TestParseDevcontainerUp(t *testing.T) {
+	out := []byte(`[2 ms] @devcontainers/cli 0.60.0. Node.js v20.
+[1200 ms] Start: Run: docker start abc
+{"outcome":"success","containerId":"abc123","remoteUser":"node","remoteWorkspaceFolder":"/workspaces/app"}
+`)
+	b, err := parseDevcontainerUp(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (containerBackend{kind: "devcontainer", container: "abc123", workDir: "/workspaces/app", user: "node"}); b != want {
+		t.Errorf("got %+v, want %+v", b, want)
+	}
+
+	if _, err := parseDevcontainerUp([]byte(`{"outcome":"error","message":"build failed"}`)); err == nil || !strings.Contains(err.Error(), "build failed") {
+		t.Errorf("error outcome: err = %v", err)
+	}
+	if _, err := parseDevcontainerUp([]byte("no json here\n")); err == nil {
+		t.Error("output without a result should fail")
+	}
+}
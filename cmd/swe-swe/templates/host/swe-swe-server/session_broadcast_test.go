@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hinshun/vt10x"
+)
+
+func broadcastAPI(t *testing.T, method, uuid string) (int, map[string]any) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handleSessionBroadcastAPI(rr, httptest.NewRequest(method, "/api/session/"+uuid+"/broadcast", nil))
+	var resp map[string]any
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code, resp
+}
+
+func TestHandleSessionBroadcastAPI(t *testing.T) {
+	sess := &Session{Assistant: "claude", vt: vt10x.New(vt10x.WithSize(40, 5))}
+	registerTestSession(t, "bc-api", sess)
+
+	if code, resp := broadcastAPI(t, http.MethodGet, "bc-api"); code != http.StatusOK || resp["enabled"] != false || resp["url"] != nil {
+		t.Errorf("GET before start: %d %v", code, resp)
+	}
+	_, started := broadcastAPI(t, http.MethodPost, "bc-api")
+	url, _ := started["url"].(string)
+	if started["enabled"] != true || !strings.Contains(url, broadcastWatchPrefix) {
+		t.Fatalf("POST: %v", started)
+	}
+	if _, again := broadcastAPI(t, http.MethodPost, "bc-api"); again["url"] != url {
+		t.Errorf("second POST changed the link: %v -> %v", url, again["url"])
+	}
+	token := url[strings.LastIndex(url, "/")+1:]
+	if broadcastSession(token) != sess {
+		t.Error("token does not find the session")
+	}
+	if _, stopped := broadcastAPI(t, http.MethodDelete, "bc-api"); stopped["enabled"] != false || broadcastSession(token) != nil {
+		t.Errorf("DELETE: %v", stopped)
+	}
+	if code, _ := broadcastAPI(t, http.MethodPost, "missing"); code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d", code)
+	}
+	if code, _ := broadcastAPI(t, http.MethodPut, "bc-api"); code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: status %d", code)
+	}
+}
+
+func TestHandleSessionBroadcastAPIRejectsScopedGuest(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	registerTestSession(t, "bc-guest", &Session{Assistant: "claude"})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/session/bc-guest/broadcast", nil)
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignScopedCookie("master", "bc-guest")})
+	rr := httptest.NewRecorder()
+	handleSessionBroadcastAPI(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("scoped guest status = %d, want 403", rr.Code)
+	}
+}
+
+// ptyOutput does what the PTY reader does with output.
+func ptyOutput(s *Session, data string) {
+	s.vtMu.Lock()
+	defer s.vtMu.Unlock()
+	s.vt.Write([]byte(data))
+	s.writeToRing([]byte(data))
+}
+
+// readSSE returns the next event's name and data.
+func readSSE(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestBroadcastWatchStream(t *testing.T) {
+	sess := &Session{Assistant: "claude", Name: "demo", vt: vt10x.New(vt10x.WithSize(40, 5))}
+	registerTestSession(t, "bc-watch", sess)
+	ptyOutput(sess, "before")
+	token := sess.startBroadcast()
+
+	srv := httptest.NewServer(http.HandlerFunc(handleBroadcastWatch))
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL + broadcastWatchPrefix + "nope/events"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("bad token: %v %v", resp, err)
+	}
+
+	tmpl, err := parsePageTemplate("watch", "watch.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcastWatchTemplate = tmpl
+	page := httptest.NewRecorder()
+	handleBroadcastWatch(page, httptest.NewRequest(http.MethodGet, broadcastWatchPrefix+token, nil))
+	if body := page.Body.String(); page.Code != http.StatusOK || !strings.Contains(body, `"/watch/`+token+`/events"`) || !strings.Contains(body, "demo") {
+		t.Errorf("watch page: status %d:\n%s", page.Code, body)
+	}
+
+	resp, err := http.Get(srv.URL + broadcastWatchPrefix + token + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	name, data := readSSE(t, r)
+	var reset struct {
+		Cols, Rows int
+		Screen     []byte
+	}
+	if err := json.Unmarshal([]byte(data), &reset); name != "reset" || err != nil || reset.Cols != 40 || reset.Rows != 5 || !strings.Contains(string(reset.Screen), "before") {
+		t.Fatalf("first event %s %s (%v)", name, data, err)
+	}
+	if _, viewers := sess.broadcastStatus(); viewers != 1 {
+		t.Errorf("viewers = %d", viewers)
+	}
+
+	// Two writes inside one interval arrive as one event.
+	ptyOutput(sess, " one")
+	ptyOutput(sess, " two")
+	name, data = readSSE(t, r)
+	if out, _ := base64.StdEncoding.DecodeString(data); name != "output" || string(out) != " one two" {
+		t.Errorf("output event %s %q", name, out)
+	}
+
+	sess.stopBroadcast()
+	if name, _ := readSSE(t, r); name != "end" {
+		t.Errorf("after stop: %s", name)
+	}
+}
+
+func TestBroadcastDropsSlowViewer(t *testing.T) {
+	sess := &Session{Assistant: "claude", vt: vt10x.New(vt10x.WithSize(40, 5))}
+	token := sess.startBroadcast()
+	slow, _ := sess.addBroadcastViewer(token)
+	if slow == nil {
+		t.Fatal("viewer not added")
+	}
+	if v, _ := sess.addBroadcastViewer("wrong"); v != nil {
+		t.Error("wrong token accepted")
+	}
+
+	sess.live.mu.Lock()
+	for i := 0; i <= broadcastViewerQueue; i++ {
+		sess.live.sendLocked(sseEvent("output", "eA=="))
+	}
+	sess.live.mu.Unlock()
+	if _, viewers := sess.broadcastStatus(); viewers != 0 {
+		t.Errorf("slow viewer kept: %d viewers", viewers)
+	}
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-slow.ch:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("slow viewer's channel not closed")
+		}
+	}
+}
+
+func TestAuthLetsBroadcastViewersThrough(t *testing.T) {
+	for _, path := range []string{broadcastWatchPrefix + "abc", broadcastWatchPrefix + "abc/events", "/xterm.js"} {
+		rr := httptest.NewRecorder()
+		authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "master").
+			ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: status %d", path, rr.Code)
+		}
+	}
+	if broadcastPath("/api/session/x/broadcast") || broadcastPath("/terminal-ui.js") {
+		t.Error("owner paths exempted")
+	}
+}
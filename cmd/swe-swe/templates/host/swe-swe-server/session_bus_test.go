@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestSessionInboxRingAndCursor(t *testing.T) {
+	in := newSessionInbox()
+	for i := 1; i <= sessionInboxLimit+5; i++ {
+		in.add(busMessage{ID: uint64(i), Text: "m"})
+	}
+	msgs, cursor, _ := in.since(0)
+	if len(msgs) != sessionInboxLimit || msgs[0].ID != 6 || cursor != sessionInboxLimit+5 {
+		t.Fatalf("since(0): %d msgs, first %d, cursor %d", len(msgs), msgs[0].ID, cursor)
+	}
+	if msgs, cursor, _ := in.since(cursor); len(msgs) != 0 || cursor != sessionInboxLimit+5 {
+		t.Errorf("since(cursor) = %d msgs, cursor %d", len(msgs), cursor)
+	}
+}
+
+func TestSessionInboxWaitWakesOnMessage(t *testing.T) {
+	in := newSessionInbox()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		in.add(busMessage{ID: 7, Text: "hi"})
+	}()
+	start := time.Now()
+	msgs, cursor := in.wait(context.Background(), 0, 5*time.Second)
+	if len(msgs) != 1 || cursor != 7 || time.Since(start) > 2*time.Second {
+		t.Errorf("wait = %v, %d after %s", msgs, cursor, time.Since(start))
+	}
+	if msgs, _ := in.wait(context.Background(), 7, 0); len(msgs) != 0 {
+		t.Errorf("wait(0) with nothing new = %v", msgs)
+	}
+}
+
+// addBusSession registers a live session with an inbox for the test.
+func addBusSession(t *testing.T, uuid, name string) {
+	t.Helper()
+	sessionsMu.Lock()
+	sessions[uuid] = &Session{UUID: uuid, Name: name}
+	sessionsMu.Unlock()
+	registerSessionInbox(uuid)
+	t.Cleanup(func() {
+		sessionsMu.Lock()
+		delete(sessions, uuid)
+		sessionsMu.Unlock()
+		unregisterSessionInbox(uuid)
+	})
+}
+
+func TestSendSessionMessageValidates(t *testing.T) {
+	addBusSession(t, "bus-a", "coding")
+	for name, m := range map[string]busMessage{
+		"self":     {From: "bus-a", To: "bus-a", Text: "x"},
+		"no text":  {From: "bus-a", To: "bus-b", Text: " "},
+		"unknown":  {From: "bus-a", To: "bus-missing", Text: "x"},
+		"too long": {From: "bus-a", To: "bus-b", Text: strings.Repeat("x", busMaxText+1)},
+	} {
+		if _, err := sendSessionMessage(m); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+// TestSessionBusToolsEndToEnd sends a message from one session's MCP key and
+// reads it with the other's, through mcpAuthMiddleware and the real SDK.
+func TestSessionBusToolsEndToEnd(t *testing.T) {
+	addBusSession(t, "bus-review", "review")
+	addBusSession(t, "bus-coding", "coding")
+	reviewKey, codingKey := issueSessionKey("bus-review"), issueSessionKey("bus-coding")
+	defer clearSessionKey("bus-review")
+	defer clearSessionKey("bus-coding")
+
+	srv := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0"}, nil)
+	registerSessionBusTools(srv)
+	handler := mcp.NewStreamableHTTPHandler(func(r *http.Request) *mcp.Server { return srv }, &mcp.StreamableHTTPOptions{Stateless: true})
+	ts := httptest.NewServer(mcpAuthMiddleware(handler))
+	defer ts.Close()
+
+	call := func(key, tool string, args map[string]any) string {
+		t.Helper()
+		ctx := context.Background()
+		client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "0"}, nil)
+		cs, err := client.Connect(ctx, &mcp.StreamableClientTransport{Endpoint: ts.URL + "/?key=" + key}, nil)
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer cs.Close()
+		res, err := cs.CallTool(ctx, &mcp.CallToolParams{Name: tool, Arguments: args})
+		if err != nil {
+			t.Fatalf("%s: %v", tool, err)
+		}
+		var text string
+		for _, c := range res.Content {
+			if tc, ok := c.(*mcp.TextContent); ok {
+				text += tc.Text
+			}
+		}
+		if res.IsError {
+			t.Fatalf("%s returned error: %s", tool, text)
+		}
+		return text
+	}
+
+	sentJSON := call(reviewKey, "send_to_session", map[string]any{
+		"uuid": "bus-coding", "kind": "question", "text": "Why is retry capped at 3?", "data": map[string]any{"file": "client.go"},
+	})
+	var sent busMessage
+	if err := json.Unmarshal([]byte(sentJSON), &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.From != "bus-review" || sent.FromName != "review" || sent.ToName != "coding" || sent.ID == 0 {
+		t.Errorf("sent = %+v", sent)
+	}
+
+	var got struct {
+		Messages []busMessage `json:"messages"`
+		Cursor   uint64       `json:"cursor"`
+	}
+	if err := json.Unmarshal([]byte(call(codingKey, "subscribe", map[string]any{"wait_seconds": 0})), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Text != "Why is retry capped at 3?" || string(got.Messages[0].Data) != `{"file":"client.go"}` || got.Cursor != sent.ID {
+		t.Fatalf("subscribe = %+v", got)
+	}
+	// The sender's inbox did not get its own message.
+	if out := call(reviewKey, "subscribe", map[string]any{"wait_seconds": 0}); !strings.Contains(out, `"messages":[]`) {
+		t.Errorf("sender inbox = %s", out)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildSessionCalendar(t *testing.T) {
+	start := time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)
+	entries := []usageEntry{
+		{UUID: usageCodexRun, Agent: "Codex", Repo: "workspace", StartedAt: start.Add(24 * time.Hour), EndedAt: start.Add(25 * time.Hour)},
+		{UUID: usageClaudeRun, Agent: "Claude", Repo: "api", StartedAt: start, EndedAt: start.Add(125 * time.Minute), Name: "fix login; then, deploy", Summary: "Claude: done"},
+	}
+	now := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	cal := buildSessionCalendar(entries, "", "swe.example.com", now)
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"UID:" + usageClaudeRun + "@swe.example.com\r\nDTSTAMP:20261016T080000Z\r\nDTSTART:20261012T090000Z\r\nDTEND:20261012T110500Z\r\n",
+		`SUMMARY:Claude: fix login\; then\, deploy` + "\r\n",
+		`DESCRIPTION:Claude session in api` + "\\, 2h 5m\\nClaude: done\r\n",
+		"CATEGORIES:Claude,api\r\n",
+		"SUMMARY:Codex\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("calendar missing %q:\n%s", want, cal)
+		}
+	}
+	if strings.Index(cal, usageClaudeRun) > strings.Index(cal, usageCodexRun) {
+		t.Errorf("events not in start order:\n%s", cal)
+	}
+
+	api := buildSessionCalendar(entries, "api", "swe.example.com", now)
+	if strings.Count(api, "BEGIN:VEVENT") != 1 || strings.Contains(api, usageCodexRun) || !strings.Contains(api, "X-WR-CALNAME:swe-swe sessions (api)") {
+		t.Errorf("repo=api calendar:\n%s", api)
+	}
+}
+
+func TestICalLineFolds(t *testing.T) {
+	var b strings.Builder
+	icalLine(&b, "SUMMARY:"+strings.Repeat("é", 80))
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if !strings.HasPrefix(line, "SUMMARY:") && !strings.HasPrefix(line, " ") {
+			t.Errorf("continuation without a leading space: %q", line)
+		}
+	}
+	unfolded := strings.ReplaceAll(b.String(), "\r\n ", "")
+	if unfolded != "SUMMARY:"+strings.Repeat("é", 80)+"\r\n" {
+		t.Errorf("unfolded = %q", unfolded)
+	}
+}
+
+func TestHandleSessionCalendar(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	withTempRecordingsDir(t)
+	writeUsageRecordings(t)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleSessionCalendar(rr, httptest.NewRequest(http.MethodGet, sessionCalendarPath+query, nil))
+		return rr
+	}
+	if rr := get(""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("no key: status %d", rr.Code)
+	}
+
+	// The request brings the ledger up to date, and the ledger keeps a
+	// session after its recording is deleted.
+	rr := get("?repo=api&key=" + feedKey("master"))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/calendar; charset=utf-8" {
+		t.Fatalf("status %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if body := rr.Body.String(); strings.Count(body, "BEGIN:VEVENT") != 1 || !strings.Contains(body, usageClaudeRun) {
+		t.Errorf("repo=api:\n%s", body)
+	}
+	deleteRecordingFiles(usageClaudeRun)
+	if body := get("?key=" + feedKey("master")).Body.String(); strings.Count(body, "BEGIN:VEVENT") != 2 || !strings.Contains(body, usageClaudeRun) {
+		t.Errorf("after deleting a recording:\n%s", body)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hinshun/vt10x"
+)
+
+func historyTexts(rows []historyRow) []string {
+	var texts []string
+	for _, r := range rows {
+		texts = append(texts, r.text)
+	}
+	return texts
+}
+
+func TestTerminalHistory(t *testing.T) {
+	for name, tc := range map[string]struct {
+		output string
+		want   []string
+	}{
+		"scrolled lines": {"l1\r\nl2\r\nl3\r\nl4\r\n", []string{"l1", "l2", "l3", "l4", ""}},
+		"long line":      {"abcdefghijklmnopqrstuvwxyz\r\nx\r\n", []string{"abcdefghij", "klmnopqrst", "uvwxyz", "x", ""}},
+		"alt screen":     {"a\r\n\x1b[?1049hv1\r\nv2\r\nv3\r\nv4\x1b[?1049lb\r\nc\r\n", []string{"a", "b", "c", ""}},
+		"cleared":        {"l1\r\nl2\r\nl3\r\nl4\r\n\x1b[3Jl5\r\n", []string{"l3", "l4", "l5", ""}},
+		"scroll region":  {"top\r\n\x1b[2;3r\x1b[2Hr1\r\nr2\r\nr3\r\n\x1b[r", []string{"top", "r3", ""}},
+		"charset":        {"\x1b(Bq1\r\nq2\r\nq3\r\nq4", []string{"q1", "q2", "q3", "q4"}},
+	} {
+		got := historyTexts(terminalHistory([]byte(tc.output), 10, 3))
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%s: rows = %q, want %q", name, got, tc.want)
+		}
+	}
+
+	rows := terminalHistory([]byte("abcdefghijklmnopqrstuvwxyz\r\nx\r\n"), 10, 3)
+	if text, lines := historyText(rows, 0, 3); text != "abcdefghijklmnopqrstuvwxyz\nx\n" || lines != 2 {
+		t.Errorf("joined = %q, %d lines", text, lines)
+	}
+	if text, _ := historyText(rows, 1, 1); text != "klmnopqrst\n" {
+		t.Errorf("one wrapped row = %q", text)
+	}
+}
+
+func TestSessionCopyRows(t *testing.T) {
+	setPathPolicyRoots(t)
+	const id = "copy-rows-sess"
+	var out strings.Builder
+	for i := 0; i < 50; i++ {
+		out.WriteString("line " + strings.Repeat("x", i) + "\r\n")
+	}
+	log := "Script started on 2026-01-01 00:00:00+00:00 [COMMAND=\"sh\"]\n" + out.String()
+	if err := os.WriteFile(filepath.Join(recordingsDir, "rec-copy.log"), []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sess := &Session{UUID: id, RecordingPrefix: "rec-copy", vt: vt10x.New(vt10x.WithSize(20, 5))}
+
+	reply, err := sess.copyRows(-7, -2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last two lines wrap onto three rows each; -1 is the empty prompt row.
+	if reply["type"] != "copy_ready" || reply["rows"] != 6 || reply["lines"] != 2 {
+		t.Fatalf("reply = %v", reply)
+	}
+	url := reply["url"].(string)
+	rr := httptest.NewRecorder()
+	handleSessionCopyAPI(rr, httptest.NewRequest(http.MethodGet, url, nil))
+	want := "line " + strings.Repeat("x", 48) + "\nline " + strings.Repeat("x", 49) + "\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != want {
+		t.Errorf("download: %d %q", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	handleSessionCopyAPI(rr, httptest.NewRequest(http.MethodGet, url, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("second download: %d", rr.Code)
+	}
+
+	if _, err := sess.copyRows(5, 2); err == nil {
+		t.Error("empty range: want an error")
+	}
+}
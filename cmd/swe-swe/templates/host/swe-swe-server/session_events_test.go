@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionEventLogRingAndSince(t *testing.T) {
+	var l sessionEventLog
+	for i := 0; i < sessionEventLimit+10; i++ {
+		l.add(sessionEvent{Message: fmt.Sprint(i)})
+	}
+	events, next := l.since(0)
+	if len(events) != sessionEventLimit || next != sessionEventLimit+10 {
+		t.Fatalf("len=%d next=%d", len(events), next)
+	}
+	if events[0].Seq != 11 || events[0].Message != "10" || events[len(events)-1].Seq != next {
+		t.Errorf("oldest=%+v newest=%+v", events[0], events[len(events)-1])
+	}
+	newer, _ := l.since(next - 2)
+	if len(newer) != 2 || newer[0].Seq != next-1 {
+		t.Errorf("since(next-2) = %+v", newer)
+	}
+}
+
+func TestLogHandlerBuffersSessionEvents(t *testing.T) {
+	captureLogs(t)
+	const id = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	registerSessionEvents(id)
+	t.Cleanup(func() { unregisterSessionEvents(id) })
+
+	// Buffered even though stdout is at ERROR.
+	logLevel.Set(slog.LevelError)
+	sess := &Session{UUID: id}
+	sess.logger().Warn("broadcast write error", "error", "broken pipe")
+	log.Printf("Session %s: resized PTY to 80x24 (remote=10.0.0.7:5123)", id)
+	log.Printf("unrelated line")
+
+	v, _ := sessionEventLogs.Load(id)
+	events, _ := v.(*sessionEventLog).since(0)
+	if len(events) != 2 {
+		t.Fatalf("got %d events: %+v", len(events), events)
+	}
+	if e := events[0]; e.Level != "WARN" || e.Message != "broadcast write error" || e.Attrs["error"] != "broken pipe" {
+		t.Errorf("first event = %+v", e)
+	}
+	if e := events[1]; e.Attrs["remote"] != "10.0.0.7:5123" || e.Subsystem != "session_events_test" {
+		t.Errorf("bridged event = %+v", e)
+	}
+}
+
+func TestHandleSessionEventsAPI(t *testing.T) {
+	const id = "events-api-sess"
+	registerSessionEvents(id)
+	t.Cleanup(func() { unregisterSessionEvents(id) })
+	recordSessionEvent(id, sessionEvent{Level: "INFO", Message: "one"})
+	recordSessionEvent(id, sessionEvent{Level: "ERROR", Message: "two"})
+
+	rr := httptest.NewRecorder()
+	handleSessionEventsAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/"+id+"/events?since=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d", rr.Code)
+	}
+	var resp struct {
+		Events []sessionEvent `json:"events"`
+		Next   uint64         `json:"next"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Events) != 1 || resp.Events[0].Message != "two" || resp.Next != 2 {
+		t.Errorf("resp = %+v", resp)
+	}
+
+	for path, want := range map[string]int{
+		"/api/session/nope/events":               http.StatusNotFound,
+		"/api/session/" + id + "/events?since=x": http.StatusBadRequest,
+	} {
+		rr := httptest.NewRecorder()
+		handleSessionEventsAPI(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rr.Code, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionExportAPI(t *testing.T) {
+	setPathPolicyRoots(t)
+	const recUUID = "0b7f3c1e-2a4d-4e6f-8a9b-1c2d3e4f5a6b"
+	prefix := "session-" + recUUID
+	files := map[string]string{
+		prefix + ".log":           "Script started on 2026-01-01 00:00:00+00:00 [COMMAND=\"sh\"]\n$ make test\r\nok\r\n",
+		prefix + ".metadata.json": `{"uuid":"` + recUUID + `","name":"fix-login","agent":"claude","owner":"alice","visitors":[{"ip":"1.2.3.4","name":"bob"},{"ip":"1.2.3.4","name":"bob"}]}`,
+		prefix + "-c1.events.jsonl": `{"type":"userMessage","text":"why </script> <b>?","ts":"10:00"}` + "\n" +
+			`{"type":"agentMessage","text":"done"}` + "\n" + `{"type":"userMessagesConsumed"}` + "\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(recordingsDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions["export-sess"] = &Session{UUID: "export-sess", RecordingUUID: recUUID, RecordingPrefix: prefix}
+	defer delete(sessions, "export-sess")
+
+	rr := httptest.NewRecorder()
+	handleSessionExportAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/export-sess/export", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("export: %d %s", rr.Code, rr.Body)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="session-export-`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	page := rr.Body.String()
+	for _, want := range []string{
+		"<title>fix-login</title>",
+		`<td>Owner</td><td>alice</td>`,
+		`<td>Visitors</td><td>bob</td>`,
+		`<div class="bubble user"><time>10:00</time>why &lt;/script&gt; &lt;b&gt;?</div>`,
+		`<div class="bubble agent">done</div>`,
+		`id="session-export-metadata"`,
+		`"text":"why \u003c/script> \u003cb>?"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page lacks %q", want)
+		}
+	}
+	if strings.Contains(page, "cdn.jsdelivr.net") {
+		t.Error("page still loads xterm.js from the CDN")
+	}
+	if n := strings.Count(page, "</script>"); n != strings.Count(page, "<script") {
+		t.Errorf("%d </script> for %d <script", n, strings.Count(page, "<script"))
+	}
+
+	rr = httptest.NewRecorder()
+	handleSessionExportAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/nope/export", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown session: %d", rr.Code)
+	}
+}
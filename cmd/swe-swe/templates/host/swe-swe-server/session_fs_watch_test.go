@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMergeFSOp(t *testing.T) {
+	for _, tc := range []struct{ prev, op, want string }{
+		{"", "write", "write"},
+		{"create", "write", "create"},
+		{"create", "remove", ""},
+		{"create", "rename", ""},
+		{"remove", "create", "write"},
+		{"rename", "create", "write"},
+		{"write", "remove", "remove"},
+		{"write", "write", "write"},
+	} {
+		if got := mergeFSOp(tc.prev, tc.op); got != tc.want {
+			t.Errorf("mergeFSOp(%q, %q) = %q, want %q", tc.prev, tc.op, got, tc.want)
+		}
+	}
+}
+
+// waitFSFrame returns the next frame from ch, or fails after a few seconds.
+func waitFSFrame(t *testing.T, ch <-chan fsChangesFrame) fsChangesFrame {
+	t.Helper()
+	select {
+	case f := <-ch:
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("no files_changed frame")
+	}
+	return fsChangesFrame{}
+}
+
+func TestSessionFSWatcher(t *testing.T) {
+	dir, _ := gitTestRepo(t)
+	os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\nnode_modules/\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "node_modules", "left-pad"), 0755)
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+
+	frames := make(chan fsChangesFrame, 10)
+	fw := newSessionFSWatcher(dir, func(f fsChangesFrame) { frames <- f })
+	if fw == nil {
+		t.Fatal("no watcher")
+	}
+	defer fw.Close()
+	if fw.replay() != nil {
+		t.Error("replay before any change")
+	}
+
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "app.go"), []byte("package src\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "build.log"), []byte("noise\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "node_modules", "left-pad", "index.js"), []byte("//\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "tmp.txt"), []byte("x"), 0644)
+	os.Remove(filepath.Join(dir, "tmp.txt"))
+	os.Remove(filepath.Join(dir, "README.md"))
+
+	f := waitFSFrame(t, frames)
+	got := map[string]string{}
+	for _, c := range f.Files {
+		got[c.Path] = c.Op
+	}
+	want := map[string]string{"main.go": "write", "src/app.go": "create", "README.md": "remove"}
+	for p, op := range want {
+		if got[p] != op {
+			t.Errorf("%s: op %q, want %q (frame %+v)", p, got[p], op, f)
+		}
+	}
+	for _, p := range []string{"build.log", "node_modules/left-pad/index.js", "tmp.txt"} {
+		if _, ok := got[p]; ok {
+			t.Errorf("%s reported: %+v", p, f)
+		}
+	}
+	if f.Type != "files_changed" || f.Seq != 1 {
+		t.Errorf("frame header = %q seq %d", f.Type, f.Seq)
+	}
+
+	// A directory created after the watch started is watched too.
+	os.MkdirAll(filepath.Join(dir, "pkg", "util"), 0755)
+	time.Sleep(200 * time.Millisecond)
+	os.WriteFile(filepath.Join(dir, "pkg", "util", "util.go"), []byte("package util\n"), 0644)
+	found := false
+	for deadline := time.Now().Add(5 * time.Second); !found && time.Now().Before(deadline); {
+		for _, c := range waitFSFrame(t, frames).Files {
+			found = found || c.Path == "pkg/util/util.go"
+		}
+	}
+	if !found {
+		t.Error("no change reported for a file in a new directory")
+	}
+
+	r := fw.replay()
+	if r == nil || !r.Replay || len(r.Files) < 3 {
+		t.Errorf("replay = %+v", r)
+	}
+}
+
+func TestSessionFSWatcherOff(t *testing.T) {
+	old := fsWatchEnabled
+	defer func() { fsWatchEnabled = old }()
+	t.Setenv("SWE_FS_WATCH", "off")
+	if err := loadFSWatch(); err != nil {
+		t.Fatal(err)
+	}
+	if fw := newSessionFSWatcher(t.TempDir(), func(fsChangesFrame) {}); fw != nil {
+		fw.Close()
+		t.Error("watcher with SWE_FS_WATCH=off")
+	}
+	t.Setenv("SWE_FS_WATCH", "sometimes")
+	if err := loadFSWatch(); err == nil {
+		t.Error("no error for SWE_FS_WATCH=sometimes")
+	}
+}
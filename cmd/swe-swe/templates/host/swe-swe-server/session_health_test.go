@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessState(t *testing.T) {
+	if got := processState(os.Getpid()); got != "running" {
+		t.Errorf("self = %q", got)
+	}
+	if got := processState(0); got != "gone" {
+		t.Errorf("no pid = %q", got)
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Not waited for, the exited child stays a zombie.
+	var got string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if got = processState(cmd.Process.Pid); got == "zombie" {
+			break
+		}
+	}
+	if got != "zombie" {
+		t.Errorf("exited child = %q", got)
+	}
+	cmd.Wait()
+	if got := processState(cmd.Process.Pid); got != "gone" {
+		t.Errorf("reaped child = %q", got)
+	}
+}
+
+func TestHealthSamplerCPUPercent(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs /proc")
+	}
+	var hs healthSampler
+	start := time.Now()
+	if p := hs.cpuPercent(os.Getpid(), start); p != nil {
+		t.Errorf("first sample = %v, want nil", *p)
+	}
+	for time.Since(start) < 300*time.Millisecond {
+	}
+	p := hs.cpuPercent(os.Getpid(), start.Add(healthCPUMinSample))
+	if p == nil || *p <= 0 {
+		t.Fatalf("busy loop = %v", p)
+	}
+	if again := hs.cpuPercent(os.Getpid(), start.Add(healthCPUMinSample+time.Second)); again != p {
+		t.Error("check within healthCPUMinSample resampled")
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+	s := &Session{UUID: "health-test", Cmd: cmd, WorkDir: t.TempDir()}
+	now := time.Now()
+	s.lastOutput = now.Add(-5 * time.Minute)
+	s.agentState.Store(agentStateWaitingApproval)
+	s.ring.Write([]byte("$ make test\r\n"))
+
+	h := s.checkHealth(now)
+	if h.Process != "running" || h.LastOutputAge != 300 || !h.PendingApproval || h.DiskFreePercent == nil {
+		t.Errorf("health = %+v", h)
+	}
+	if sb := h.Scrollback; sb.Held != 13 || sb.Written != 13 || sb.Allocated >= sb.Capacity {
+		t.Errorf("scrollback = %+v", sb)
+	}
+	if h.QuietFor() != "5m" || !strings.Contains(h.ProblemText(), "waiting for approval") {
+		t.Errorf("quiet %q, problems %q", h.QuietFor(), h.ProblemText())
+	}
+
+	registerTestSession(t, "health-test", s)
+	rr := httptest.NewRecorder()
+	handleLiveSessionsAPI(rr, httptest.NewRequest(http.MethodGet, "/api/sessions/live", nil))
+	var body struct {
+		Sessions []struct {
+			UUID   string        `json:"uuid"`
+			Health sessionHealth `json:"health"`
+		} `json:"sessions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Sessions) != 1 || body.Sessions[0].Health.Process != "running" || len(body.Sessions[0].Health.Problems) != 1 {
+		t.Errorf("live sessions = %s", rr.Body.String())
+	}
+}
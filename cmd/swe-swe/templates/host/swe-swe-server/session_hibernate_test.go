@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestLoadHibernateAfter(t *testing.T) {
+	for v, want := range map[string]time.Duration{"": 0, "off": 0, "0": 0, "2h": 2 * time.Hour, " 90m ": 90 * time.Minute} {
+		t.Setenv("SWE_HIBERNATE_AFTER", v)
+		if err := loadHibernateAfter(); err != nil || hibernateAfter != want {
+			t.Errorf("%q: %v, %v", v, hibernateAfter, err)
+		}
+	}
+	for _, v := range []string{"30s", "soon", "-1h"} {
+		t.Setenv("SWE_HIBERNATE_AFTER", v)
+		if err := loadHibernateAfter(); err == nil {
+			t.Errorf("%q: want an error", v)
+		}
+	}
+	hibernateAfter = 0
+}
+
+func TestHibernationDue(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 30")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	now := time.Now()
+	idle := func() *Session {
+		s := &Session{UUID: "idle", Cmd: cmd, lastActive: now.Add(-2 * time.Hour), lastOutput: now.Add(-3 * time.Hour)}
+		s.AssistantConfig.ShellRestartCmd = "claude --continue"
+		s.agentState.Store(agentStateIdle)
+		return s
+	}
+	if !idle().hibernationDue(now, time.Hour) {
+		t.Fatal("idle session: want due")
+	}
+	for name, change := range map[string]func(*Session){
+		"viewer":        func(s *Session) { s.wsClients = map[*SafeConn]bool{nil: true} },
+		"recent input":  func(s *Session) { s.lastActive = now.Add(-time.Minute) },
+		"recent output": func(s *Session) { s.lastOutput = now.Add(-time.Minute) },
+		"approval":      func(s *Session) { s.agentState.Store(agentStateWaitingApproval) },
+		"no restart":    func(s *Session) { s.AssistantConfig.ShellRestartCmd = "" },
+		"terminal tab":  func(s *Session) { s.ParentUUID = "parent" },
+		"ending":        func(s *Session) { s.ending = true },
+		"hibernated":    func(s *Session) { s.hibernation.asleep.Store(true) },
+	} {
+		s := idle()
+		change(s)
+		if s.hibernationDue(now, time.Hour) {
+			t.Errorf("%s: want not due", name)
+		}
+	}
+}
+
+// A hibernated session's agent has exited, but the session is not over.
+func TestHibernatedSessionNotReapable(t *testing.T) {
+	s := &Session{UUID: "asleep", Cmd: finishedCmd(t)}
+	s.hibernation.asleep.Store(true)
+	if s.reapable() || s.exited() {
+		t.Error("hibernated session must be neither reapable nor exited")
+	}
+	s.hibernation.asleep.Store(false)
+	if !s.reapable() || !s.exited() {
+		t.Error("session woken (or failed to wake) with no agent must be reapable")
+	}
+}
+
+func TestWakeHibernatedSession(t *testing.T) {
+	s := &Session{UUID: "wake"}
+	if s.wake() != nil || s.awaitAwake() != nil {
+		t.Fatal("awake session: want nothing to wait for")
+	}
+
+	h := &s.hibernation
+	h.wake, h.awake = make(chan struct{}), make(chan struct{})
+	h.asleep.Store(true)
+	awake := s.wake()
+	if awake == nil || s.wake() != awake {
+		t.Fatal("hibernated session: want the awake channel, twice")
+	}
+	select {
+	case <-h.wake:
+	default:
+		t.Fatal("wake did not wake the PTY reader")
+	}
+
+	failed := errors.New("no agent")
+	h.err = failed
+	close(h.awake)
+	if err := s.awaitAwake(); err != failed {
+		t.Errorf("awaitAwake = %v, want %v", err, failed)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeHook writes an executable hook script under dir/sub.
+func writeHook(t *testing.T, dir, sub, name, body string, mode os.FileMode) string {
+	t.Helper()
+	hookDir := filepath.Join(dir, sub)
+	if err := os.MkdirAll(hookDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(hookDir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), mode); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseTimeoutSetting(t *testing.T) {
+	for in, want := range map[string]time.Duration{"90": 90 * time.Second, "2m": 2 * time.Minute, "1m30s": 90 * time.Second} {
+		if got, err := parseTimeoutSetting("SWE_HOOK_TIMEOUT", in); err != nil || got != want {
+			t.Errorf("parseTimeoutSetting(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"0", "-5s", "soon"} {
+		if _, err := parseTimeoutSetting("SWE_HOOK_TIMEOUT", bad); err == nil {
+			t.Errorf("parseTimeoutSetting(%q) should fail", bad)
+		}
+	}
+}
+
+func TestFindSessionHook(t *testing.T) {
+	dir := t.TempDir()
+	if path, err := findSessionHook(dir, hookSessionEnd); path != "" || err != nil {
+		t.Fatalf("no hook: got %q, %v", path, err)
+	}
+
+	legacy := writeHook(t, dir, "swe-swe/hooks", hookSessionEnd, "", 0o755)
+	if path, err := findSessionHook(dir, hookSessionEnd); path != legacy || err != nil {
+		t.Fatalf("legacy dir: got %q, %v; want %q", path, err, legacy)
+	}
+	preferred := writeHook(t, dir, ".swe-swe/hooks", hookSessionEnd, "", 0o755)
+	if path, err := findSessionHook(dir, hookSessionEnd); path != preferred || err != nil {
+		t.Fatalf(".swe-swe/hooks should win: got %q, %v; want %q", path, err, preferred)
+	}
+
+	writeHook(t, dir, ".swe-swe/hooks", hookSessionStart, "", 0o644)
+	if path, err := findSessionHook(dir, hookSessionStart); path != "" || err == nil {
+		t.Fatalf("non-executable hook: got %q, %v; want an error", path, err)
+	}
+}
+
+func TestRunHookOutputAndExitCode(t *testing.T) {
+	dir := t.TempDir()
+	path := writeHook(t, dir, "hooks", "h", "pwd\necho \"hook=$SWE_HOOK\" >&2\nexit 3\n", 0o755)
+	var out bytes.Buffer
+	res, err := runHook(path, dir, append(os.Environ(), "SWE_HOOK=h"), &out, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 3 || res.TimedOut {
+		t.Errorf("result = %+v; want exit 3, not timed out", res)
+	}
+	realDir, _ := filepath.EvalSymlinks(dir)
+	if got := out.String(); !strings.Contains(got, realDir) || !strings.Contains(got, "hook=h") {
+		t.Errorf("output = %q; want cwd %q and hook=h", got, realDir)
+	}
+}
+
+func TestRunHookTimeoutKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	// The backgrounded sleep holds stdout open; only a process-group kill
+	// lets Wait return promptly.
+	path := writeHook(t, dir, "hooks", "h", "sleep 30 &\nsleep 30\n", 0o755)
+	start := time.Now()
+	res, err := runHook(path, dir, os.Environ(), &bytes.Buffer{}, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.TimedOut || res.ExitCode != -1 {
+		t.Errorf("result = %+v; want timed out with exit -1", res)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("runHook took %s after a 200ms timeout", elapsed)
+	}
+}
+
+func TestCappedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &cappedWriter{w: &buf, n: 5}
+	for _, s := range []string{"abc", "def", "ghi"} {
+		if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if buf.String() != "abcde" || !w.truncated {
+		t.Errorf("got %q truncated=%v; want \"abcde\" truncated", buf.String(), w.truncated)
+	}
+}
+
+func TestSessionEndHookRunsOnceIntoSidecar(t *testing.T) {
+	workDir := t.TempDir()
+	oldRec := recordingsDir
+	recordingsDir = t.TempDir()
+	t.Cleanup(func() { recordingsDir = oldRec })
+
+	writeHook(t, workDir, ".swe-swe/hooks", hookSessionEnd, "echo \"ended $SWE_SESSION_EXIT_CODE\"\n", 0o755)
+	s := &Session{UUID: "hook-test", WorkDir: workDir, RecordingUUID: "rec-hook-test"}
+	s.runSessionEndHook(7, false)
+	s.runSessionEndHook(0, false) // second call (e.g. Close after exit) is a no-op
+
+	sidecar := sessionHooksPath("rec-hook-test")
+	var data []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		data, _ = os.ReadFile(sidecar)
+		if strings.Contains(string(data), "hook exited") {
+			break
+		}
+	}
+	got := string(data)
+	if !strings.Contains(got, "ended 7\n") || !strings.Contains(got, "[on-session-end hook exited 0") {
+		t.Fatalf("sidecar = %q; want hook output and summary", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if data, _ := os.ReadFile(sidecar); strings.Count(string(data), "=== ") != 1 {
+		t.Errorf("hook ran more than once:\n%s", data)
+	}
+}
+
+func TestSessionHooksSkipShellAndUntrustedSessions(t *testing.T) {
+	workDir := t.TempDir()
+	oldRec := recordingsDir
+	recordingsDir = t.TempDir()
+	t.Cleanup(func() { recordingsDir = oldRec })
+
+	writeHook(t, workDir, ".swe-swe/hooks", hookSessionStart, "echo ran\n", 0o755)
+	writeHook(t, workDir, ".swe-swe/hooks", hookSessionEnd, "echo ran\n", 0o755)
+	for _, s := range []*Session{
+		{UUID: "child", ParentUUID: "parent", WorkDir: workDir, RecordingUUID: "rec-child"},
+		{UUID: "pr", untrustedRepo: true, WorkDir: workDir, RecordingUUID: "rec-pr"},
+	} {
+		s.runSessionStartHook()
+		s.runSessionEndHook(0, false)
+		time.Sleep(200 * time.Millisecond)
+		if _, err := os.Stat(sessionHooksPath(s.RecordingUUID)); !os.IsNotExist(err) {
+			t.Errorf("session %s ran a hook (sidecar stat err = %v)", s.UUID, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// withSessionLimits sets the limits and assistants for one test.
+func withSessionLimits(t *testing.T, max, perAssistant string) {
+	t.Helper()
+	savedAssistants := availableAssistants
+	availableAssistants = []AssistantConfig{{Name: "Claude", Binary: "claude"}, {Name: "Codex", Binary: "codex"}}
+	t.Setenv("SWE_MAX_SESSIONS", max)
+	t.Setenv("SWE_MAX_SESSIONS_PER_ASSISTANT", perAssistant)
+	if err := loadSessionLimits(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		availableAssistants = savedAssistants
+		maxSessions, maxSessionsPerAssistant = 0, nil
+	})
+}
+
+func TestLoadSessionLimits(t *testing.T) {
+	t.Cleanup(func() { maxSessions, maxSessionsPerAssistant = 0, nil })
+	t.Setenv("SWE_MAX_SESSIONS", "")
+	t.Setenv("SWE_MAX_SESSIONS_PER_ASSISTANT", " claude=5, 2 ")
+	if err := loadSessionLimits(); err != nil {
+		t.Fatal(err)
+	}
+	if assistantSessionLimit("claude") != 5 || assistantSessionLimit("codex") != 2 {
+		t.Errorf("per-assistant limits = %v", maxSessionsPerAssistant)
+	}
+	if got, ports := globalSessionLimit(), previewPortEnd-previewPortStart+1; got != ports {
+		t.Errorf("global limit = %d, want the %d preview ports", got, ports)
+	}
+
+	// SWE_MAX_SESSIONS can lower the cap but not raise it past the ports.
+	t.Setenv("SWE_MAX_SESSIONS", "1000")
+	loadSessionLimits()
+	if globalSessionLimit() != previewPortEnd-previewPortStart+1 {
+		t.Errorf("global limit = %d, want capped at the preview ports", globalSessionLimit())
+	}
+
+	for _, bad := range [][2]string{{"0", ""}, {"many", ""}, {"", "claude="}, {"", "=3"}, {"", "claude=1,claude=2"}, {"", "claude=-1"}} {
+		t.Setenv("SWE_MAX_SESSIONS", bad[0])
+		t.Setenv("SWE_MAX_SESSIONS_PER_ASSISTANT", bad[1])
+		if err := loadSessionLimits(); err == nil {
+			t.Errorf("%q / %q: want an error", bad[0], bad[1])
+		}
+	}
+}
+
+func TestCheckSessionLimit(t *testing.T) {
+	withSessionLimits(t, "3", "claude=2")
+	registerTestSession(t, "limit-claude-1", &Session{Assistant: "claude"})
+	registerTestSession(t, "limit-claude-2", &Session{Assistant: "claude"})
+	// Terminal tabs and exited sessions don't count.
+	registerTestSession(t, "limit-claude-1-tab", &Session{Assistant: "claude", ParentUUID: "limit-claude-1"})
+	exited := exec.Command("true")
+	exited.Run()
+	registerTestSession(t, "limit-exited", &Session{Assistant: "codex", Cmd: exited})
+
+	var limitErr *sessionLimitError
+	err := checkSessionLimit("claude")
+	if !errors.As(err, &limitErr) || *limitErr != (sessionLimitError{Scope: "assistant", Assistant: "claude", Limit: 2, Active: 2}) {
+		t.Fatalf("claude: err = %v", err)
+	}
+	if want := "2 of 2 Claude sessions are already running. End one to start another."; err.Error() != want {
+		t.Errorf("message = %q, want %q", err.Error(), want)
+	}
+	if err := checkSessionLimit("codex"); err != nil {
+		t.Errorf("codex: %v", err)
+	}
+
+	registerTestSession(t, "limit-codex-1", &Session{Assistant: "codex"})
+	if err := checkSessionLimit("codex"); !errors.As(err, &limitErr) || limitErr.Scope != "global" || limitErr.Active != 3 {
+		t.Errorf("codex at the global cap: err = %v", err)
+	}
+
+	// getOrCreateSession refuses before creating anything.
+	_, _, err = getOrCreateSession(SessionParams{UUID: "limit-new", Assistant: "codex"}, true)
+	if !errors.As(err, &limitErr) {
+		t.Errorf("getOrCreateSession: err = %v, want a session limit error", err)
+	}
+}
+
+func TestNewSessionAPIRefusedAtLimit(t *testing.T) {
+	withSessionLimits(t, "", "claude=1")
+	registerTestSession(t, "limit-claude-1", &Session{Assistant: "claude"})
+
+	form := url.Values{"assistant": {"claude"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/session/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handleNewSessionAPI(rr, req)
+
+	var body struct {
+		Error, Message, Scope, Assistant string
+		Limit, Active                    int
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if body.Error != "session_limit" || body.Scope != "assistant" || body.Assistant != "claude" || body.Limit != 1 || body.Active != 1 || body.Message == "" {
+		t.Errorf("body = %+v", body)
+	}
+}
+
+func TestLiveSessionsAPIReportsCapacity(t *testing.T) {
+	withSessionLimits(t, "4", "claude=1")
+	registerTestSession(t, "limit-claude-1", &Session{Assistant: "claude"})
+	registerTestSession(t, "limit-codex-1", &Session{Assistant: "codex"})
+
+	rr := httptest.NewRecorder()
+	handleLiveSessionsAPI(rr, httptest.NewRequest(http.MethodGet, "/api/sessions/live", nil))
+	var body struct {
+		Capacity struct {
+			Global     sessionCapacity            `json:"global"`
+			Assistants map[string]sessionCapacity `json:"assistants"`
+		} `json:"capacity"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	c := body.Capacity
+	if c.Global != (sessionCapacity{Limit: 4, Active: 2, Remaining: 2}) {
+		t.Errorf("global = %+v", c.Global)
+	}
+	if c.Assistants["claude"] != (sessionCapacity{Limit: 1, Active: 1, Remaining: 0}) {
+		t.Errorf("claude = %+v", c.Assistants["claude"])
+	}
+	// No per-assistant cap: bounded by the global remaining.
+	if c.Assistants["codex"] != (sessionCapacity{Active: 1, Remaining: 2}) {
+		t.Errorf("codex = %+v", c.Assistants["codex"])
+	}
+}
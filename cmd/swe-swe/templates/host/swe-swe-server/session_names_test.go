@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withSessionNamePolicy sets SWE_SESSION_NAMES for one test.
+func withSessionNamePolicy(t *testing.T, policy string) {
+	t.Helper()
+	t.Setenv("SWE_SESSION_NAMES", policy)
+	if err := loadSessionNamePolicy(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessionNamePolicy = sessionNamesAllow })
+}
+
+func TestLoadSessionNamePolicy(t *testing.T) {
+	withSessionNamePolicy(t, " Suffix ")
+	if sessionNamePolicy != sessionNamesSuffix {
+		t.Errorf("policy = %q", sessionNamePolicy)
+	}
+	t.Setenv("SWE_SESSION_NAMES", "unique")
+	if err := loadSessionNamePolicy(); err == nil {
+		t.Error("unique: want an error")
+	}
+}
+
+func TestResolveSessionName(t *testing.T) {
+	setPathPolicyRoots(t)
+	registerTestSession(t, "names-1", &Session{Assistant: "claude", Name: "Fix login"})
+	registerTestSession(t, "names-2", &Session{Assistant: "claude", Name: "Fix login-2"})
+	registerTestSession(t, "names-1-tab", &Session{Assistant: "shell", Name: "Tab", ParentUUID: "names-1"})
+	// A worktree already on the next candidate's branch is skipped too.
+	if err := os.MkdirAll(filepath.Join(worktreeDir, "fix-login-3"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	withSessionNamePolicy(t, "")
+	if got, err := resolveSessionName("fix login", ""); err != nil || got != "fix login" {
+		t.Errorf("allow: %q, %v", got, err)
+	}
+
+	withSessionNamePolicy(t, "suffix")
+	for name, want := range map[string]string{
+		"FIX LOGIN":   "FIX LOGIN-4",  // same name ignoring case
+		"fix-login!":  "fix-login!-4", // same branch
+		"Fix login-2": "Fix login-4",  // counted up, not "-2-2"
+		"Tab":         "Tab",          // terminal tabs don't hold names
+		"Other":       "Other",
+		"":            "",
+	} {
+		if got, err := resolveSessionName(name, ""); err != nil || got != want {
+			t.Errorf("suffix %q: %q, %v; want %q", name, got, err, want)
+		}
+	}
+	// Renaming a session to its own name is no collision.
+	if got, err := resolveSessionName("Fix login", "names-1"); err != nil || got != "Fix login" {
+		t.Errorf("self: %q, %v", got, err)
+	}
+
+	withSessionNamePolicy(t, "reject")
+	_, err := resolveSessionName("Fix login", "")
+	var nameErr *sessionNameError
+	if !errors.As(err, &nameErr) || *nameErr != (sessionNameError{Name: "Fix login", Session: "names-1", Suggestion: "Fix login-4"}) {
+		t.Fatalf("reject: %v", err)
+	}
+	if e := newWSError(err); e.Code != wsErrNameTaken || e.Retryable || e.Conflict != nameErr {
+		t.Errorf("ws error = %+v", e)
+	}
+
+	// getOrCreateSession refuses before creating anything.
+	withSessionLimits(t, "", "")
+	_, _, err = getOrCreateSession(SessionParams{UUID: "names-new", Assistant: "claude", Name: "fix-login"}, true)
+	if !errors.As(err, &nameErr) {
+		t.Errorf("getOrCreateSession: err = %v, want a session name error", err)
+	}
+}
+
+func TestNewSessionAPIRefusesTakenName(t *testing.T) {
+	withSessionLimits(t, "", "")
+	withSessionNamePolicy(t, "reject")
+	registerTestSession(t, "names-1", &Session{Assistant: "claude", Name: "Fix login"})
+
+	form := url.Values{"assistant": {"claude"}, "name": {"Fix login"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/session/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handleNewSessionAPI(rr, req)
+
+	var body struct{ Error, Message, Name, Session, Suggestion string }
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusConflict {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if body.Error != "name_taken" || body.Session != "names-1" || body.Suggestion == "" || body.Message == "" {
+		t.Errorf("body = %+v", body)
+	}
+
+	rr = httptest.NewRecorder()
+	handleSessionNameCheckAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/name-check?name=fix+login", nil))
+	var check struct {
+		Policy, Session, Suggestion string
+		Taken, Allowed              bool
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &check); err != nil {
+		t.Fatal(err)
+	}
+	if check.Policy != "reject" || !check.Taken || check.Allowed || check.Session != "names-1" || check.Suggestion == "" {
+		t.Errorf("name-check = %+v", check)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionPreviewAppAPI(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	sess := &Session{UUID: "preview-app", WorkDir: t.TempDir(), PreviewPort: ln.Addr().(*net.TCPAddr).Port, wsClients: map[*SafeConn]bool{}}
+	registerTestSession(t, sess.UUID, sess)
+	defer sess.stopPreviewApp()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleSessionPreviewAppAPI(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	get := func() previewAppStatus {
+		var st previewAppStatus
+		json.Unmarshal(do(http.MethodGet, "/api/session/preview-app/preview-app").Body.Bytes(), &st)
+		return st
+	}
+	if st := get(); st.Status != "stopped" || !st.Listening {
+		t.Errorf("before start = %+v", st)
+	}
+	if _, ok := sess.buildStatusPayload(0, 24, 80)["previewApp"]; ok {
+		t.Error("status payload has previewApp before any start")
+	}
+	t.Setenv("SWE_PREVIEW_CMD", "")
+	if rec := do(http.MethodPost, "/api/session/preview-app/preview-app/start"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("start without SWE_PREVIEW_CMD = %d", rec.Code)
+	}
+
+	t.Setenv("SWE_PREVIEW_CMD", "echo serving on $PORT; sleep 30")
+	rec := do(http.MethodPost, "/api/session/preview-app/preview-app/start")
+	var started previewAppStatus
+	json.Unmarshal(rec.Body.Bytes(), &started)
+	if rec.Code != http.StatusAccepted || started.Status != "running" || started.PID == 0 {
+		t.Fatalf("start = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/api/session/preview-app/preview-app/start"); rec.Code != http.StatusConflict {
+		t.Errorf("second start = %d", rec.Code)
+	}
+	var st previewAppStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if st = get(); st.Log != "" {
+			break
+		}
+	}
+	if want := "serving on " + itoa(sess.PreviewPort); st.Status != "running" || strings.TrimSpace(st.Log) != want {
+		t.Errorf("running = %+v, want log %q", st, want)
+	}
+	if a, ok := sess.buildStatusPayload(0, 24, 80)["previewApp"].(previewAppStatus); !ok || a.Status != "running" || a.Log != "" {
+		t.Errorf("status payload previewApp = %+v", a)
+	}
+
+	begin := time.Now()
+	if rec := do(http.MethodPost, "/api/session/preview-app/preview-app/stop"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"stopped"`) {
+		t.Errorf("stop = %d %s", rec.Code, rec.Body)
+	}
+	if time.Since(begin) >= previewAppStopGrace {
+		t.Error("SIGTERM did not stop the app")
+	}
+	if rec := do(http.MethodGet, "/api/session/preview-app/preview-app/logs"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown path = %d", rec.Code)
+	}
+}
+
+func TestPreviewAppRestartsUntilCrashed(t *testing.T) {
+	defer func(d time.Duration) { previewAppRestartDelay = d }(previewAppRestartDelay)
+	previewAppRestartDelay = 10 * time.Millisecond
+	sess := &Session{UUID: "preview-app-crash", WorkDir: t.TempDir(), wsClients: map[*SafeConn]bool{}}
+	t.Setenv("SWE_PREVIEW_CMD", "echo boom; exit 3")
+	if _, err := sess.startPreviewApp(); err != nil {
+		t.Fatal(err)
+	}
+	var st previewAppStatus
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if st = sess.previewAppSnapshot(true); st.Status == "crashed" {
+			break
+		}
+	}
+	if st.Status != "crashed" || st.Restarts != previewAppMaxRestarts || st.ExitCode == nil || *st.ExitCode != 3 || st.Error == "" {
+		t.Errorf("status = %+v", st)
+	}
+	if n := strings.Count(st.Log, "boom"); n != previewAppMaxRestarts+1 {
+		t.Errorf("ran %d times, log %q", n, st.Log)
+	}
+
+	// A crashed app can be started again.
+	t.Setenv("SWE_PREVIEW_CMD", "sleep 30")
+	if _, err := sess.startPreviewApp(); err != nil {
+		t.Errorf("start after crash: %v", err)
+	}
+	sess.stopPreviewApp()
+	if st := sess.previewAppSnapshot(false); st.Status != "stopped" || st.PID != 0 {
+		t.Errorf("after stop = %+v", st)
+	}
+}
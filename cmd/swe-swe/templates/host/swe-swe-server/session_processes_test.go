@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionProcessesAPI(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 31 & sleep 32; wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	sess := &Session{UUID: "processes", Cmd: cmd, wsClients: map[*SafeConn]bool{}}
+	registerTestSession(t, sess.UUID, sess)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleSessionProcessesAPI(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	var children []*processInfo
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		var resp struct{ Processes []*processInfo }
+		rec := do(http.MethodGet, "/api/session/processes/processes", "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK || len(resp.Processes) != 1 {
+			t.Fatalf("processes = %d %s", rec.Code, rec.Body)
+		}
+		root := resp.Processes[0]
+		if root.PID != cmd.Process.Pid || !strings.HasPrefix(root.Command, "sh -c") || root.RSS == 0 {
+			t.Fatalf("root = %+v", root)
+		}
+		if children = root.Children; len(children) == 2 {
+			break
+		}
+	}
+	if len(children) != 2 || children[0].Command != "sleep 31" || children[1].Command != "sleep 32" || children[0].PPID != cmd.Process.Pid {
+		t.Fatalf("children = %+v", children)
+	}
+
+	for body, want := range map[string]int{
+		`{"pid": ` + itoa(cmd.Process.Pid) + `}`:                   http.StatusForbidden,  // the root
+		`{"pid": 1}`:                                               http.StatusForbidden,  // not the session's
+		`{"pid": ` + itoa(os.Getpid()) + `}`:                       http.StatusForbidden,  // the server
+		`{"pid": ` + itoa(children[0].PID) + `, "signal": "BOOM"}`: http.StatusBadRequest, // no such signal
+		`not json`: http.StatusBadRequest,
+	} {
+		if rec := do(http.MethodPost, "/api/session/processes/signal", body); rec.Code != want {
+			t.Errorf("%s: %d %s, want %d", body, rec.Code, rec.Body, want)
+		}
+	}
+	rec := do(http.MethodPost, "/api/session/processes/signal", `{"pid": `+itoa(children[0].PID)+`, "signal": "sigkill"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"KILL"`) {
+		t.Fatalf("signal = %d %s", rec.Code, rec.Body)
+	}
+	// Only that process went; the rest of the session carries on.
+	var left []*processInfo
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if tree := processTree(sess.processRoots(), 0); len(tree) == 1 {
+			if left = tree[0].Children; len(left) == 1 {
+				break
+			}
+		}
+	}
+	if len(left) != 1 || left[0].Command != "sleep 32" {
+		t.Errorf("after kill = %+v", left)
+	}
+	if rec := do(http.MethodGet, "/api/session/processes/signal", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET signal = %d", rec.Code)
+	}
+}
+
+func TestParseSignalName(t *testing.T) {
+	for in, want := range map[string]string{"": "TERM", "kill": "KILL", " SIGINT ": "INT", "Hup": "HUP"} {
+		if name, _, err := parseSignalName(in); err != nil || name != want {
+			t.Errorf("%q = %q, %v", in, name, err)
+		}
+	}
+	if _, _, err := parseSignalName("SEGV"); err == nil {
+		t.Error("SEGV accepted")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a clock that starts at 2026-01-02 14:00:00 UTC and an
+// advance func to move it.
+func fakeClock() (func() time.Time, func(time.Duration)) {
+	t := time.Date(2026, 1, 2, 14, 0, 0, 0, time.UTC)
+	return func() time.Time { return t }, func(d time.Duration) { t = t.Add(d) }
+}
+
+func readRecording(t *testing.T, prefix string) (logData, timing, input string) {
+	t.Helper()
+	read := func(ext string) string {
+		b, err := os.ReadFile(recordingsDir + "/" + prefix + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	return read(".log"), read(".timing"), read(".input")
+}
+
+func TestSessionRecorderWritesScriptFormat(t *testing.T) {
+	h := newTestHelper(t)
+	defer h.cleanup()
+	now, advance := fakeClock()
+
+	r, err := startSessionRecorderAt("session-rec", "claude --foo", 24, 80, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	advance(500 * time.Millisecond)
+	r.Output([]byte("hello\r\n"))
+	advance(time.Second)
+	r.Input([]byte("add tests\r"))
+	r.Resize(40, 120)
+	advance(time.Second)
+	r.Output([]byte("ok"))
+	r.Close(3)
+	r.Output([]byte("after close"))
+
+	logData, timing, input := readRecording(t, "session-rec")
+	wantHeader := `Script started on 2026-01-02 14:00:00+00:00 [COMMAND="claude --foo" TERM="xterm-256color" COLUMNS="80" LINES="24"]` + "\n"
+	wantFooter := "\nScript done on 2026-01-02 14:00:02+00:00 [COMMAND_EXIT_CODE=\"3\"]\n"
+	if logData != wantHeader+"hello\r\nok"+wantFooter {
+		t.Errorf("log = %q", logData)
+	}
+	if input != wantHeader+"add tests\r"+wantFooter {
+		t.Errorf("input = %q", input)
+	}
+	for _, want := range []string{
+		"H 0.000000 START_TIME 2026-01-02 14:00:00+00:00\n",
+		"H 0.000000 COMMAND claude --foo\n",
+		"O 0.500000 7\nI 1.000000 10\nS 0.000000 SIGWINCH ROWS=40 COLS=120\nO 1.000000 2\n",
+		"H 0.000000 DURATION 2.500000\nH 0.000000 EXIT_CODE 3\n",
+	} {
+		if !strings.Contains(timing, want) {
+			t.Errorf("timing missing %q:\n%s", want, timing)
+		}
+	}
+
+	// The chapter finder reads it like a script(1) recording.
+	chapters := findChapters(strings.NewReader(timing), stripInputHeader([]byte(input)))
+	if len(chapters) != 1 || chapters[0].prompt != "add tests" || chapters[0].offset != 7 {
+		t.Errorf("chapters = %+v", chapters)
+	}
+}
+
+func TestSessionRecorderPauseResume(t *testing.T) {
+	h := newTestHelper(t)
+	defer h.cleanup()
+	now, advance := fakeClock()
+
+	r, err := startSessionRecorderAt("session-pause", "bash", 24, 80, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	advance(12*time.Minute + 31*time.Second)
+	r.Output([]byte("before"))
+	if !r.Pause() || r.Pause() || !r.Paused() {
+		t.Fatal("Pause: want true once, then false")
+	}
+	r.Input([]byte("hunter2\r"))
+	r.Output([]byte("secret"))
+	r.Resize(30, 100)
+	advance(31 * time.Second)
+	if !r.Resume() || r.Resume() || r.Paused() {
+		t.Fatal("Resume: want true once, then false")
+	}
+	r.Output([]byte("after"))
+	r.Close(0)
+
+	logData, timing, input := readRecording(t, "session-pause")
+	marker := "\r\n[recording paused 00:12:31–00:13:02]\r\n"
+	if !strings.Contains(logData, "before"+marker+"after") {
+		t.Errorf("log = %q", logData)
+	}
+	if strings.Contains(logData, "secret") || strings.Contains(input, "hunter2") || strings.Contains(timing, "SIGWINCH") {
+		t.Errorf("paused traffic was recorded:\nlog %q\ninput %q\ntiming %s", logData, input, timing)
+	}
+	if want := fmt.Sprintf("O 751.000000 6\nO 31.000000 %d\nO 0.000000 5\n", len(marker)); !strings.Contains(timing, want) {
+		t.Errorf("timing missing %q:\n%s", want, timing)
+	}
+}
+
+func TestSessionRecorderClosesOpenPause(t *testing.T) {
+	h := newTestHelper(t)
+	defer h.cleanup()
+	now, advance := fakeClock()
+
+	r, err := startSessionRecorderAt("session-open", "bash", 24, 80, true, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Output([]byte("secret"))
+	advance(time.Hour + 2*time.Second)
+	r.Close(0)
+
+	logData, _, _ := readRecording(t, "session-open")
+	if strings.Contains(logData, "secret") || !strings.Contains(logData, "[recording paused 00:00:00–01:00:02]") {
+		t.Errorf("log = %q", logData)
+	}
+}
+
+func TestSessionRecordingAPI(t *testing.T) {
+	h := newTestHelper(t)
+	defer h.cleanup()
+
+	r, err := startSessionRecorder("session-api", "bash", 24, 80, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(0)
+	sess := &Session{UUID: "rec-api", wsClients: map[*SafeConn]bool{}}
+	sess.recorder.Store(r)
+	sessionsMu.Lock()
+	sessions[sess.UUID] = sess
+	sessionsMu.Unlock()
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		paused       bool
+	}{
+		{http.MethodPost, "/api/session/rec-api/recording/pause", http.StatusOK, true},
+		{http.MethodPost, "/api/session/rec-api/recording/pause", http.StatusOK, true},
+		{http.MethodPost, "/api/session/rec-api/recording/resume", http.StatusOK, false},
+		{http.MethodGet, "/api/session/rec-api/recording/pause", http.StatusMethodNotAllowed, false},
+		{http.MethodPost, "/api/session/rec-api/recording/stop", http.StatusNotFound, false},
+		{http.MethodPost, "/api/session/nope/recording/pause", http.StatusNotFound, false},
+	} {
+		w := httptest.NewRecorder()
+		handleSessionRecordingAPI(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, w.Code, tc.code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var resp struct{ Paused bool }
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Paused != tc.paused || sess.RecordingPaused() != tc.paused {
+			t.Errorf("%s %s: paused = %v, want %v", tc.method, tc.path, resp.Paused, tc.paused)
+		}
+	}
+}
@@ -19,6 +19,7 @@ func TestScopedRequestAllowed(t *testing.T) {
 		{"/ws/sess-1", true},
 		{"/proxy/sess-1/preview/", true},
 		{"/proxy/sess-1/agentchat/foo", true},
+		{"/preview/sess-1/app.js", true},
 		{"/api/session/sess-1/end", true},
 		{"/api/session/sess-1/vnc-ready", true},
 		{"/api/session/sess-1/files-ready", true},
@@ -26,21 +27,42 @@ func TestScopedRequestAllowed(t *testing.T) {
 		{"/session/sess-2", false},
 		{"/ws/sess-2", false},
 		{"/proxy/sess-2/preview/", false},
+		{"/preview/sess-2/", false},
 		{"/api/session/sess-2/end", false},
 		// Spawn / fork / enumerate: denied.
 		{"/api/session/new", false},
+		{"/api/session/name-check", false},
+		{"/new", false},
 		{"/api/fork/sess-1", false},
 		{"/api/worktrees", false},
 		{"/api/worktree/check", false},
+		{"/api/branch/preview", false},
 		{"/api/repos", false},
+		{"/api/repos/status", false},
+		{"/api/repos/fetch", false},
 		{"/api/repo/prepare", false},
 		{"/api/repo/branches", false},
+		{"/api/repo/template-diff", false},
+		{"/api/templates/update", false},
 		// Server shutdown: never.
 		{"/api/server/shutdown", false},
+		// Exec API, server config, log level, usage reports, data APIs: never.
+		{"/api/exec", false},
+		{"/api/config", false},
+		{"/api/log-level", false},
+		{"/api/reports/usage", false},
+		{"/api/data/purge", false},
+		{"/api/data/export", false},
+		{"/api/data/import", false},
+		{"/api/backups", false},
+		{"/ws/admin", false},
 		// Recordings: never.
 		{"/recording/anything", false},
 		{"/recording/sess-1", false}, // even a same-name recording UUID is out
 		{"/api/recording/foo", false},
+		{"/embed/recording/0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", false}, // bare UUID
+		{"/embed/recording/sess-1", false},
+		{"/embed/recording/rec-uuid.share-id.sig", true}, // share token: public anyway
 		// UUID-less assets/plumbing: allowed.
 		{"/static/app.js", true},
 		{"/terminal-ui.js", true},
@@ -192,6 +214,7 @@ func TestScopedVerifyAllowed(t *testing.T) {
 		// Other session / recordings / spawn / repos -> denied.
 		{"/session/sess-2", false},
 		{"/recording/x", false},
+		{"/embed/recording/0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", false},
 		{"/api/session/new", false},
 		{"/api/repos", false},
 	}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hinshun/vt10x"
+)
+
+func TestHandleSessionScreenAPI(t *testing.T) {
+	const id = "screen-api-sess"
+	vt := vt10x.New(vt10x.WithSize(20, 4))
+	vt.Write([]byte("$ ls\r\n\x1b[4;34mERR\x1b[0m ok\r\n"))
+	sessionsMu.Lock()
+	sessions[id] = &Session{UUID: id, vt: vt}
+	sessionsMu.Unlock()
+	defer func() {
+		sessionsMu.Lock()
+		delete(sessions, id)
+		sessionsMu.Unlock()
+	}()
+
+	rr := httptest.NewRecorder()
+	handleSessionScreenAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/"+id+"/screen", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d", rr.Code)
+	}
+	var scr sessionScreen
+	if err := json.Unmarshal(rr.Body.Bytes(), &scr); err != nil {
+		t.Fatal(err)
+	}
+	if scr.Cols != 20 || scr.Rows != 4 || len(scr.Lines) != 4 {
+		t.Fatalf("size %dx%d, %d lines", scr.Cols, scr.Rows, len(scr.Lines))
+	}
+	if scr.Text != "$ ls\nERR ok" {
+		t.Errorf("text = %q", scr.Text)
+	}
+	if scr.Cursor.X != 0 || scr.Cursor.Y != 2 {
+		t.Errorf("cursor = %+v", scr.Cursor)
+	}
+	spans := scr.Lines[1].Spans
+	if len(spans) != 2 || spans[0].Text != "ERR" || !spans[0].Underline || spans[0].FG != float64(4) || spans[0].BG != nil {
+		t.Fatalf("spans = %+v", spans)
+	}
+	if s := spans[1]; s.Underline || s.FG != nil || len(s.Text) != 17 {
+		t.Errorf("second span = %+v", s)
+	}
+
+	rr = httptest.NewRecorder()
+	handleSessionScreenAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/"+id+"/screen?format=text", nil))
+	if got := rr.Body.String(); rr.Code != http.StatusOK || got != "$ ls\nERR ok\n" {
+		t.Errorf("text format: %d %q", rr.Code, got)
+	}
+
+	for path, want := range map[string]int{
+		"/api/session/nope/screen":                http.StatusNotFound,
+		"/api/session/" + id + "/screen?format=x": http.StatusBadRequest,
+	} {
+		rr := httptest.NewRecorder()
+		handleSessionScreenAPI(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rr.Code, want)
+		}
+	}
+}
+
+func TestScreenColor(t *testing.T) {
+	if c := screenColor(vt10x.DefaultFG, vt10x.DefaultFG); c != nil {
+		t.Errorf("default = %v", c)
+	}
+	if c := screenColor(200, vt10x.DefaultFG); c != 200 {
+		t.Errorf("palette = %v", c)
+	}
+	if c := screenColor(0x12ab34, vt10x.DefaultBG); c != "#12ab34" {
+		t.Errorf("rgb = %v", c)
+	}
+}
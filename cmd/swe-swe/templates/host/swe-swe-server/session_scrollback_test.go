@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionScrollbackAPI(t *testing.T) {
+	withTempRecordingsDir(t)
+	rec, err := startSessionRecorder("session-scroll", "claude", 24, 80, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Output([]byte("0123456789"))
+	rec.Output([]byte("abcdef"))
+	registerTestSession(t, "scroll", &Session{RecordingPrefix: "session-scroll"})
+
+	get := func(query string) (*httptest.ResponseRecorder, string) {
+		rr := httptest.NewRecorder()
+		handleSessionScrollbackAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/scroll/scrollback?"+query, nil))
+		return rr, rr.Header().Get("X-Scrollback-From") + " " + rr.Header().Get("X-Scrollback-Next") + " " + rr.Header().Get("X-Scrollback-Size")
+	}
+
+	for _, tc := range []struct{ query, body, pos string }{
+		{"", "0123456789abcdef", "0 16 16"},
+		{"from=4&limit=3", "456", "4 7 16"},
+		{"from=-6", "abcdef", "10 16 16"},
+		{"from=-100&limit=2", "01", "0 2 16"},
+		{"from=99", "", "16 16 16"},
+	} {
+		rr, pos := get(tc.query)
+		if rr.Code != http.StatusOK || rr.Body.String() != tc.body || pos != tc.pos {
+			t.Errorf("%q: %d %q at %s", tc.query, rr.Code, rr.Body.String(), pos)
+		}
+	}
+
+	// The footer is not output.
+	rec.Close(0)
+	if rr, pos := get("from=-3"); rr.Body.String() != "def" || pos != "13 16 16" {
+		t.Errorf("closed log: %q at %s", rr.Body.String(), pos)
+	}
+
+	for _, query := range []string{"from=x", "limit=0"} {
+		if rr, _ := get(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d", query, rr.Code)
+		}
+	}
+	rr := httptest.NewRecorder()
+	handleSessionScrollbackAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/missing/scrollback", nil))
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "not found") {
+		t.Errorf("missing session: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSetupMode(t *testing.T) {
+	for in, want := range map[string]string{"": "auto", "auto": "auto", " Skip ": "skip", "force": "force"} {
+		if got, err := normalizeSetupMode(in); err != nil || got != want {
+			t.Errorf("normalizeSetupMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := normalizeSetupMode("always"); err == nil {
+		t.Error("normalizeSetupMode(always) should fail")
+	}
+}
+
+func TestSetupTaskPrefixModes(t *testing.T) {
+	dir := t.TempDir()
+	if got := setupTaskPrefix("s", dir, ""); got != "" {
+		t.Fatalf("no setup hook: prefix = %q, want empty", got)
+	}
+	writeHook(t, dir, ".swe-swe/hooks", setupHookName, "true\n", 0o755)
+	if got := setupTaskPrefix("s", dir, ""); got == "" {
+		t.Fatal("hook without marker: want a prefix")
+	}
+	if got := setupTaskPrefix("s", dir, "skip"); got != "" {
+		t.Errorf("skip: prefix = %q, want empty", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, setupMarker), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := setupTaskPrefix("s", dir, "auto"); got != "" {
+		t.Errorf("marker present: prefix = %q, want empty", got)
+	}
+	if got := setupTaskPrefix("s", dir, "force"); got == "" {
+		t.Error("force with marker present: want a prefix")
+	}
+}
+
+// runSetupPrefix runs prefix followed by "echo agent" the way
+// wrapWithShell's bash -c would, returning the combined output.
+func runSetupPrefix(t *testing.T, dir, prefix string) string {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", prefix+"echo agent")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sh -c: %v\n%s", err, out)
+	}
+	return string(out)
+}
+
+func TestSetupTaskCommandWritesMarkerOnSuccess(t *testing.T) {
+	dir := t.TempDir()
+	hook := writeHook(t, dir, ".swe-swe/hooks", setupHookName, "echo \"installing ($SWE_HOOK)\"\n", 0o755)
+	marker := filepath.Join(dir, setupMarker)
+	_, lookErr := exec.LookPath("timeout")
+	prefix, err := setupTaskCommand(hook, marker, time.Minute, lookErr == nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(prefix, "$`\"\\") {
+		t.Errorf("prefix must reach the shell unexpanded: %q", prefix)
+	}
+	out := runSetupPrefix(t, dir, prefix)
+	if !strings.Contains(out, "installing (setup)") || !strings.Contains(out, "Setup task done") || !strings.HasSuffix(out, "agent\n") {
+		t.Errorf("output = %q", out)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("marker not written: %v", err)
+	}
+}
+
+func TestSetupTaskCommandFailureLeavesNoMarker(t *testing.T) {
+	dir := t.TempDir()
+	hook := writeHook(t, dir, ".swe-swe/hooks", setupHookName, "exit 1\n", 0o755)
+	marker := filepath.Join(dir, setupMarker)
+	prefix, err := setupTaskCommand(hook, marker, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := runSetupPrefix(t, dir, prefix)
+	if !strings.Contains(out, "failed or timed out") || !strings.HasSuffix(out, "agent\n") {
+		t.Errorf("output = %q; want failure note, then the agent", out)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("marker written after a failed setup (stat err = %v)", err)
+	}
+}
+
+func TestSetupTaskCommandTimeout(t *testing.T) {
+	if _, err := exec.LookPath("timeout"); err != nil {
+		t.Skip("no coreutils timeout")
+	}
+	dir := t.TempDir()
+	hook := writeHook(t, dir, ".swe-swe/hooks", setupHookName, "sleep 30\n", 0o755)
+	prefix, err := setupTaskCommand(hook, filepath.Join(dir, setupMarker), time.Second, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	out := runSetupPrefix(t, dir, prefix)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("setup ran %s past a 1s timeout", elapsed)
+	}
+	if !strings.Contains(out, "failed or timed out") {
+		t.Errorf("output = %q", out)
+	}
+}
+
+func TestSetupShellQuoteRejectsExpansions(t *testing.T) {
+	for _, p := range []string{"/w/it's", "/w/$HOME", "/w/`id`", "/w/a\"b", "/w/a\nb"} {
+		if _, err := setupShellQuote(p); err == nil {
+			t.Errorf("setupShellQuote(%q) should fail", p)
+		}
+	}
+	if got, err := setupShellQuote("/workspace/my repo/.swe-swe/hooks/setup"); err != nil || got != fmt.Sprintf("'%s'", "/workspace/my repo/.swe-swe/hooks/setup") {
+		t.Errorf("setupShellQuote = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCreateShareLinkValidates(t *testing.T) {
+	sess := &Session{}
+	for _, tc := range []struct {
+		scope string
+		ttl   time.Duration
+	}{
+		{"admin", time.Hour},
+		{shareScopeView, 0},
+		{shareScopeView, shareLinkMaxTTL + time.Second},
+	} {
+		if _, err := createShareLink(sess, tc.scope, "", tc.ttl); err == nil {
+			t.Errorf("createShareLink(%q, %s) should fail", tc.scope, tc.ttl)
+		}
+	}
+	if _, err := createShareLink(sess, shareScopeView, strings.Repeat("x", shareLinkMaxLabel+1), time.Hour); err == nil {
+		t.Error("overlong label should fail")
+	}
+	for i := 0; i < shareLinkMaxPerSession; i++ {
+		if _, err := createShareLink(sess, shareScopeControl, "", time.Hour); err != nil {
+			t.Fatalf("link %d: %v", i, err)
+		}
+	}
+	if _, err := createShareLink(sess, shareScopeControl, "", time.Hour); err == nil {
+		t.Error("link past the per-session limit should fail")
+	}
+}
+
+func TestShareLinkTokenAndCookieLifecycle(t *testing.T) {
+	const secret = "master"
+	sess := &Session{}
+	registerTestSession(t, "link-sess", sess)
+	l, err := createShareLink(sess, shareScopeView, "bob", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := shareLinkToken(secret, "link-sess", l)
+
+	if got, ok := verifyShareLinkToken(secret, "link-sess", token); !ok || got != l {
+		t.Fatalf("valid token rejected")
+	}
+	for name, bad := range map[string][2]string{
+		"other secret":  {"link-sess", shareLinkToken("other", "link-sess", l)},
+		"other session": {"ghost", token},
+		"tampered":      {"link-sess", tamperToken(token)},
+		"no signature":  {"link-sess", l.ID},
+	} {
+		if _, ok := verifyShareLinkToken(secret, bad[0], bad[1]); ok {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	cookie := authSignShareCookie(secret, "link-sess", l.ID)
+	scope, shareID, ok := authVerifyCookieShare(cookie, secret)
+	if !ok || scope != "link-sess" || shareID != l.ID {
+		t.Fatalf("share cookie = %q %q %v", scope, shareID, ok)
+	}
+	if scope, ok := authVerifyCookieScoped(cookie, secret); !ok || scope != "link-sess" {
+		t.Errorf("authVerifyCookieScoped = %q %v", scope, ok)
+	}
+
+	if !revokeShareLink(sess, l.ID) || revokeShareLink(sess, l.ID) {
+		t.Error("revoke should succeed once")
+	}
+	if _, ok := authVerifyCookieScoped(cookie, secret); ok {
+		t.Error("cookie still valid after revoke")
+	}
+	if _, ok := verifyShareLinkToken(secret, "link-sess", token); ok {
+		t.Error("token still valid after revoke")
+	}
+	select {
+	case <-l.done:
+	default:
+		t.Error("revoke did not signal connected guests")
+	}
+
+	// Expired links stop validating and are pruned from the list.
+	old, _ := createShareLink(sess, shareScopeControl, "", time.Hour)
+	sess.mu.Lock()
+	old.ExpiresAt = time.Now().Add(-time.Second)
+	sess.mu.Unlock()
+	if _, ok := authVerifyCookieScoped(authSignShareCookie(secret, "link-sess", old.ID), secret); ok {
+		t.Error("cookie for an expired share validated")
+	}
+	if got := listShareLinks(sess); len(got) != 0 {
+		t.Errorf("expired link listed: %+v", got)
+	}
+}
+
+func TestAuthShareHandler(t *testing.T) {
+	const secret = "master"
+	sess := &Session{Assistant: "claude"}
+	registerTestSession(t, "open-sess", sess)
+	l, _ := createShareLink(sess, shareScopeControl, "", time.Hour)
+
+	q := url.Values{"session": {"open-sess"}, "token": {shareLinkToken(secret, "open-sess", l)}}
+	req := httptest.NewRequest(http.MethodGet, "/swe-swe-auth/share?"+q.Encode(), nil)
+	req.RemoteAddr = "127.0.0.1:6001"
+	rr := httptest.NewRecorder()
+	authShareHandler(secret)(rr, req)
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/session/open-sess?assistant=claude" {
+		t.Fatalf("status %d, location %q", rr.Code, rr.Header().Get("Location"))
+	}
+	var cookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == authCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.MaxAge > 3601 || cookie.MaxAge < 3590 {
+		t.Fatalf("cookie = %+v, want MaxAge ~1h", cookie)
+	}
+	if _, id, ok := authVerifyCookieShare(cookie.Value, secret); !ok || id != l.ID {
+		t.Errorf("issued cookie share = %q %v", id, ok)
+	}
+
+	q.Set("token", l.ID+".bad")
+	req = httptest.NewRequest(http.MethodGet, "/swe-swe-auth/share?"+q.Encode(), nil)
+	req.RemoteAddr = "127.0.0.1:6001"
+	rr = httptest.NewRecorder()
+	authShareHandler(secret)(rr, req)
+	if rr.Code != http.StatusForbidden || len(rr.Result().Cookies()) != 0 {
+		t.Errorf("bad token: status %d, cookies %v", rr.Code, rr.Result().Cookies())
+	}
+}
+
+func TestHandleSessionShareLinkAPIs(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	sess := &Session{Assistant: "claude"}
+	registerTestSession(t, "mgmt-sess", sess)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/session/mgmt-sess/share", strings.NewReader(`{"scope":"view","expiresIn":600,"label":"review"}`))
+	req.Host = "example.com"
+	rr := httptest.NewRecorder()
+	handleSessionShareAPI(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var created shareLinkView
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Scope != shareScopeView || created.Label != "review" || !strings.HasPrefix(created.URL, "http://example.com/swe-swe-auth/share?session=mgmt-sess&token=") {
+		t.Errorf("created = %+v", created)
+	}
+	if d := time.Until(created.ExpiresAt); d < 590*time.Second || d > 600*time.Second {
+		t.Errorf("expires in %s, want ~10m", d)
+	}
+
+	rr = httptest.NewRecorder()
+	handleSessionShareAPI(rr, httptest.NewRequest(http.MethodPost, "/api/session/mgmt-sess/share", strings.NewReader(`{"scope":"root"}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("bad scope: status %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleSessionSharesAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/mgmt-sess/shares", nil))
+	var list struct {
+		Shares []shareLinkView `json:"shares"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Shares) != 1 || list.Shares[0].ID != created.ID || list.Shares[0].URL == "" {
+		t.Fatalf("list: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A guest cannot see or revoke shares, even of their own session.
+	guest := httptest.NewRequest(http.MethodDelete, "/api/session/mgmt-sess/shares/"+created.ID, nil)
+	guest.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignScopedCookie("master", "mgmt-sess")})
+	rr = httptest.NewRecorder()
+	handleSessionSharesAPI(rr, guest)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("guest revoke: status %d", rr.Code)
+	}
+
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		handleSessionSharesAPI(rr, httptest.NewRequest(http.MethodDelete, "/api/session/mgmt-sess/shares/"+created.ID, nil))
+		if rr.Code != want {
+			t.Errorf("revoke: status %d, want %d", rr.Code, want)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handleSessionSharesAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/ghost/shares", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d", rr.Code)
+	}
+}
+
+func TestShareLinkNeedsEmbeddedAuth(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "")
+	sess := &Session{}
+	registerTestSession(t, "noauth-sess", sess)
+	rr := httptest.NewRecorder()
+	handleSessionShareAPI(rr, httptest.NewRequest(http.MethodPost, "/api/session/noauth-sess/share", strings.NewReader(`{"scope":"view"}`)))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("status %d, want 409", rr.Code)
+	}
+}
+
+func TestShareViewOnlyPolicy(t *testing.T) {
+	view := &shareLink{Scope: shareScopeView}
+	control := &shareLink{Scope: shareScopeControl}
+	for _, tc := range []struct {
+		l         *shareLink
+		method    string
+		forwarded bool
+		want      bool
+	}{
+		{nil, http.MethodPost, true, true},
+		{control, http.MethodPost, true, true},
+		{view, http.MethodGet, false, true},
+		{view, http.MethodHead, false, true},
+		{view, http.MethodGet, true, false},
+		{view, http.MethodPost, false, false},
+		{view, http.MethodDelete, false, false},
+	} {
+		if got := shareViewOnlyAllowed(tc.l, tc.method, tc.forwarded); got != tc.want {
+			t.Errorf("scope=%v %s forwarded=%v: got %v", tc.l, tc.method, tc.forwarded, got)
+		}
+	}
+
+	if !viewOnlyMessageAllowed(websocket.TextMessage, []byte(`{"type":"ping"}`)) {
+		t.Error("ping should pass")
+	}
+	for _, msg := range []string{`{"type":"chat","text":"hi"}`, `{"type":"toggle_yolo"}`, `not json`} {
+		if viewOnlyMessageAllowed(websocket.TextMessage, []byte(msg)) {
+			t.Errorf("%s should be dropped", msg)
+		}
+	}
+	if viewOnlyMessageAllowed(websocket.BinaryMessage, []byte("ls\r")) {
+		t.Error("terminal input should be dropped")
+	}
+}
+
+func TestAuthMiddlewareViewOnlyShare(t *testing.T) {
+	t.Setenv("SWE_SWE_PASSWORD", "master")
+	sess := &Session{}
+	registerTestSession(t, "mw-sess", sess)
+	l, _ := createShareLink(sess, shareScopeView, "", time.Hour)
+	cookie := &http.Cookie{Name: authCookieName, Value: authSignShareCookie("master", "mw-sess", l.ID)}
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "master")
+
+	for method, want := range map[string]int{http.MethodGet: http.StatusOK, http.MethodPost: http.StatusForbidden} {
+		req := httptest.NewRequest(method, "/api/session/mw-sess/screen", nil)
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("%s: status %d, want %d", method, rr.Code, want)
+		}
+	}
+
+	revokeShareLink(sess, l.ID)
+	req := httptest.NewRequest(http.MethodGet, "/api/session/mw-sess/screen", nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Errorf("after revoke: status %d, want redirect to login", rr.Code)
+	}
+}
+
+// tamperToken changes the last signature character, whatever it was.
+func tamperToken(token string) string {
+	last := "0"
+	if strings.HasSuffix(token, "0") {
+		last = "1"
+	}
+	return token[:len(token)-1] + last
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseSizeMode(t *testing.T) {
+	for v, want := range map[string]string{"": sizeModeFollow, "follow": sizeModeFollow, "independent": sizeModeIndependent} {
+		if got, ok := parseSizeMode(v); !ok || got != want {
+			t.Errorf("parseSizeMode(%q) = %q, %v", v, got, ok)
+		}
+	}
+	if _, ok := parseSizeMode("scaled"); ok {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func TestIndependentClientsDoNotSizePTY(t *testing.T) {
+	desktop, phone := &SafeConn{}, &SafeConn{}
+	sess := &Session{
+		UUID:          "size-mode",
+		wsClients:     map[*SafeConn]bool{}, // broadcasts go nowhere
+		wsClientSizes: map[*SafeConn]TermSize{desktop: {Rows: 50, Cols: 200}, phone: {Rows: 20, Cols: 40}},
+	}
+	if rows, cols := sess.calculateMinSize(); rows != 20 || cols != 40 {
+		t.Fatalf("following phone: %dx%d, want 40x20", cols, rows)
+	}
+
+	sess.SetClientSizeMode(phone, sizeModeIndependent)
+	sess.mu.RLock()
+	rows, cols := sess.calculateMinSize()
+	viewers := sess.buildStatusPayload(2, rows, cols)["independentViewers"]
+	sess.mu.RUnlock()
+	if rows != 50 || cols != 200 {
+		t.Errorf("independent phone: %dx%d, want 200x50", cols, rows)
+	}
+	if viewers != 1 {
+		t.Errorf("independentViewers = %v, want 1", viewers)
+	}
+
+	// With nobody following, everyone sizes the PTY again.
+	sess.SetClientSizeMode(desktop, sizeModeIndependent)
+	sess.mu.RLock()
+	rows, cols = sess.calculateMinSize()
+	sess.mu.RUnlock()
+	if rows != 20 || cols != 40 {
+		t.Errorf("all independent: %dx%d, want 40x20", cols, rows)
+	}
+
+	sess.SetClientSizeMode(desktop, sizeModeFollow)
+	sess.RemoveClient(phone)
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	if len(sess.sizeIndependent) != 0 {
+		t.Errorf("%d independent clients left", len(sess.sizeIndependent))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestValidatePinnedSize(t *testing.T) {
+	for _, ok := range [][2]int{{0, 0}, {200, 50}, {pinnedSizeMinCols, pinnedSizeMinRows}, {pinnedSizeMaxCols, pinnedSizeMaxRows}} {
+		if err := validatePinnedSize(ok[0], ok[1]); err != nil {
+			t.Errorf("%dx%d: %v", ok[0], ok[1], err)
+		}
+	}
+	for _, bad := range [][2]int{{200, 0}, {0, 50}, {10, 50}, {200, 1}, {5000, 50}, {-1, -1}} {
+		if err := validatePinnedSize(bad[0], bad[1]); err == nil {
+			t.Errorf("%dx%d accepted", bad[0], bad[1])
+		}
+	}
+}
+
+func TestPinnedSizeOverridesClients(t *testing.T) {
+	phone := &SafeConn{}
+	sess := &Session{
+		UUID:          "size-pin",
+		wsClients:     map[*SafeConn]bool{}, // broadcasts go nowhere
+		wsClientSizes: map[*SafeConn]TermSize{phone: {Rows: 20, Cols: 40}},
+	}
+	if err := sess.SetPinnedSize(200, 50); err != nil {
+		t.Fatal(err)
+	}
+	sess.mu.RLock()
+	rows, cols := sess.calculateMinSize()
+	pinned := sess.buildStatusPayload(1, rows, cols)["pinnedSize"]
+	sess.mu.RUnlock()
+	if rows != 50 || cols != 200 {
+		t.Errorf("pinned: %dx%d, want 200x50", cols, rows)
+	}
+	if p, ok := pinned.(map[string]uint16); !ok || p["cols"] != 200 || p["rows"] != 50 {
+		t.Errorf("status pinnedSize = %v", pinned)
+	}
+
+	// The pin outlives the clients.
+	sess.RemoveClient(phone)
+	sess.mu.RLock()
+	rows, cols = sess.calculateMinSize()
+	sess.mu.RUnlock()
+	if rows != 50 || cols != 200 {
+		t.Errorf("pinned without clients: %dx%d, want 200x50", cols, rows)
+	}
+
+	if err := sess.SetPinnedSize(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	if rows, cols := sess.calculateMinSize(); rows != 24 || cols != 80 {
+		t.Errorf("unpinned: %dx%d, want the 80x24 default", cols, rows)
+	}
+	if _, ok := sess.buildStatusPayload(0, 24, 80)["pinnedSize"]; ok {
+		t.Error("status reports a pin after unpinning")
+	}
+}
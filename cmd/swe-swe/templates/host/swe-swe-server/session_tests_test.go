@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTestOutput(t *testing.T) {
+	for name, tc := range map[string]struct {
+		out  string
+		want testCounts
+	}{
+		"go -v": {
+			out:  "=== RUN   TestA\n--- PASS: TestA (0.00s)\n=== RUN   TestB\n    --- PASS: TestB/sub (0.00s)\n--- FAIL: TestB (0.01s)\n--- SKIP: TestC (0.00s)\nFAIL\nFAIL\texample.com/x\t0.02s\n",
+			want: testCounts{Format: "go", Passed: 2, Failed: 1, Skipped: 1},
+		},
+		"go packages": {
+			out:  "ok  \texample.com/a\t0.01s\n?   \texample.com/b\t[no test files]\n--- FAIL\nFAIL\texample.com/c\t0.30s\nok  \texample.com/d\t(cached)\n",
+			want: testCounts{Format: "go", Passed: 2, Failed: 1},
+		},
+		"jest": {
+			out:  "PASS src/a.test.js\nFAIL src/b.test.js\nTest Suites: 1 failed, 1 passed, 2 total\nTests:       1 failed, 2 skipped, 10 passed, 13 total\nSnapshots:   0 total\n",
+			want: testCounts{Format: "jest", Passed: 10, Failed: 1, Skipped: 2},
+		},
+		"pytest": {
+			out:  "tests/test_a.py ..F.s\n=========== short test summary info ===========\nFAILED tests/test_a.py::test_x\n====== 1 failed, 3 passed, 1 skipped, 1 error in 0.12s ======\n",
+			want: testCounts{Format: "pytest", Passed: 3, Failed: 2, Skipped: 1},
+		},
+	} {
+		got, ok := parseTestOutput(tc.out)
+		if !ok || got != tc.want {
+			t.Errorf("%s: got %+v, %v; want %+v", name, got, ok, tc.want)
+		}
+	}
+	if c, ok := parseTestOutput("all good\n"); ok {
+		t.Errorf("unknown output parsed as %+v", c)
+	}
+}
+
+func TestRunTestCommand(t *testing.T) {
+	dir := t.TempDir()
+	res := runTestCommand("echo '--- PASS: TestA (0.00s)'; echo '--- PASS: TestB (0.00s)'", dir, nil, time.Now())
+	if res.Status != "passed" || res.Passed != 2 || res.Format != "go" || res.FinishedAt == nil {
+		t.Errorf("passing run = %+v", res)
+	}
+	res = runTestCommand("echo 'Tests: 1 failed, 1 passed, 2 total'; exit 1", dir, nil, time.Now())
+	if res.Status != "failed" || res.Failed != 1 || res.ExitCode != 1 {
+		t.Errorf("failing run = %+v", res)
+	}
+	res = runTestCommand("exit 3", dir, nil, time.Now())
+	if res.Status != "failed" || res.ExitCode != 3 || res.Format != "" {
+		t.Errorf("unparsed failing run = %+v", res)
+	}
+
+	old := testTimeout
+	testTimeout = 100 * time.Millisecond
+	defer func() { testTimeout = old }()
+	res = runTestCommand("sleep 5", dir, nil, time.Now())
+	if res.Status != "error" || !res.TimedOut {
+		t.Errorf("timed-out run = %+v", res)
+	}
+}
+
+func TestSessionTestsAPI(t *testing.T) {
+	sess := &Session{UUID: "tests-api", WorkDir: t.TempDir(), wsClients: map[*SafeConn]bool{}}
+	sessionsMu.Lock()
+	sessions[sess.UUID] = sess
+	sessionsMu.Unlock()
+	defer func() {
+		sessionsMu.Lock()
+		delete(sessions, sess.UUID)
+		sessionsMu.Unlock()
+	}()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleSessionTestsAPI(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	if rec := do(http.MethodGet, "/api/session/tests-api/tests"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "null" {
+		t.Errorf("GET before any run = %d %s", rec.Code, rec.Body)
+	}
+	t.Setenv("SWE_TEST_CMD", "")
+	if rec := do(http.MethodPost, "/api/session/tests-api/tests/run"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("run without SWE_TEST_CMD = %d", rec.Code)
+	}
+
+	t.Setenv("SWE_TEST_CMD", "sleep 0.3; echo 'ok  \texample.com/a\t0.01s'")
+	if rec := do(http.MethodPost, "/api/session/tests-api/tests/run"); rec.Code != http.StatusAccepted {
+		t.Fatalf("run = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/api/session/tests-api/tests/run"); rec.Code != http.StatusConflict {
+		t.Errorf("second run while running = %d", rec.Code)
+	}
+	var got testResult
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		json.Unmarshal(do(http.MethodGet, "/api/session/tests-api/tests").Body.Bytes(), &got)
+		if got.Status != "running" {
+			break
+		}
+	}
+	if got.Status != "passed" || got.Passed != 1 || !strings.Contains(got.Output, "example.com/a") {
+		t.Errorf("latest result = %+v", got)
+	}
+	if st, ok := sess.buildStatusPayload(0, 24, 80)["tests"].(*testResult); !ok || st.Status != "passed" || st.Output != "" {
+		t.Errorf("status payload tests = %+v", st)
+	}
+}
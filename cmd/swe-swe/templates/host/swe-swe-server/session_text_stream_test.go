@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hinshun/vt10x"
+)
+
+func TestParseTextStreamMode(t *testing.T) {
+	for v, want := range map[string][2]bool{
+		"": {false, false}, "0": {false, false}, "1": {true, false}, "true": {true, false}, "only": {true, true},
+	} {
+		if on, only := parseTextStreamMode(v); on != want[0] || only != want[1] {
+			t.Errorf("%q = %v, %v", v, on, only)
+		}
+	}
+}
+
+func TestTextDelta(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		prev, cur []string
+		want      []string
+	}{
+		{"unchanged repaint", []string{"a", "b", ""}, []string{"a", "b", ""}, nil},
+		{"appended", []string{"$ ls", "", ""}, []string{"$ ls", "x.go", "$"}, []string{"x.go", "$"}},
+		{"scrolled", []string{"1", "2", "3"}, []string{"2", "3", "4"}, []string{"4"}},
+		{"scrolled two", []string{"1", "2", "3", "4"}, []string{"3", "4", "5", "6"}, []string{"5", "6"}},
+		{"edited prompt", []string{"out", "> hel"}, []string{"out", "> hello"}, []string{"> hello"}},
+		{"cleared", []string{"a", "b"}, []string{"", ""}, nil},
+		{"new screen", []string{"a", "b"}, []string{"c", "d"}, []string{"c", "d"}},
+	} {
+		if got := textDelta(tc.prev, tc.cur); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// textStreamSession is a session with an emulator and no process, wired to a
+// WebSocket server that subscribes each connection with ?text=.
+func textStreamSession(t *testing.T) (*Session, *httptest.Server) {
+	t.Helper()
+	sess := &Session{
+		UUID:          "text-stream",
+		wsClients:     make(map[*SafeConn]bool),
+		wsClientSizes: make(map[*SafeConn]TermSize),
+		vt:            vt10x.New(vt10x.WithSize(40, 5)),
+	}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := NewSafeConn(ws)
+		sess.mu.Lock()
+		sess.wsClients[conn] = true
+		sess.mu.Unlock()
+		_, only := parseTextStreamMode(r.URL.Query().Get("text"))
+		sess.addTextClient(conn, only)
+		defer sess.removeTextClient(conn)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return sess, server
+}
+
+func dialTextStream(t *testing.T, server *httptest.Server, mode string) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?text="+mode, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// readTextFrame returns the next text frame, and how many binary frames
+// came before it.
+func readTextFrame(t *testing.T, ws *websocket.Conn) (textStreamFrame, int) {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	binary := 0
+	for {
+		typ, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ == websocket.BinaryMessage {
+			binary++
+			continue
+		}
+		var frame textStreamFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatal(err)
+		}
+		return frame, binary
+	}
+}
+
+func writeScreen(sess *Session, data string) {
+	sess.vtMu.Lock()
+	sess.vt.Write([]byte(data))
+	sess.vtMu.Unlock()
+	sess.Broadcast([]byte(data))
+}
+
+func TestTextStream(t *testing.T) {
+	sess, server := textStreamSession(t)
+	sess.vt.Write([]byte("\x1b[1;32m$\x1b[0m make\r\n\r\n\r\nok"))
+
+	both := dialTextStream(t, server, "1")
+	frame, _ := readTextFrame(t, both)
+	if !frame.Reset || !reflect.DeepEqual(frame.Lines, []string{"$ make", "", "ok"}) {
+		t.Fatalf("reset frame = %+v", frame)
+	}
+	only := dialTextStream(t, server, "only")
+	if frame, _ := readTextFrame(t, only); !frame.Reset || len(frame.Lines) != 3 {
+		t.Fatalf("text-only reset frame = %+v", frame)
+	}
+
+	// A repaint of the same text (cursor home, redraw) sends nothing; the
+	// next real change is the only line in the following frame.
+	writeScreen(sess, "\x1b[H\x1b[1;32m$\x1b[0m make")
+	time.Sleep(2 * textStreamInterval)
+	writeScreen(sess, "\r\n\x1b[31mPASS\x1b[0m")
+
+	frame, binary := readTextFrame(t, both)
+	if !reflect.DeepEqual(frame.Lines, []string{"PASS"}) || frame.Reset {
+		t.Errorf("delta frame = %+v", frame)
+	}
+	if binary != 2 {
+		t.Errorf("?text=1 got %d binary frames, want 2", binary)
+	}
+	frame, binary = readTextFrame(t, only)
+	if !reflect.DeepEqual(frame.Lines, []string{"PASS"}) {
+		t.Errorf("text-only delta frame = %+v", frame)
+	}
+	if binary != 0 {
+		t.Errorf("?text=only got %d binary frames", binary)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/choonkeat/swe-swe/internal/termsnap"
+)
+
+func TestNormalizeTheme(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"light", "light", true},
+		{"dark", "dark", true},
+		{" Dark ", "dark", true},
+		{"system", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, ok := normalizeTheme(c.in)
+		if got != c.want || ok != c.ok {
+			t.Errorf("normalizeTheme(%q) = %q, %v; want %q, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestBuildSessionEnvColorFGBGFollowsTheme(t *testing.T) {
+	for theme, want := range map[string]string{"light": "COLORFGBG=0;15", "dark": "COLORFGBG=15;0", "": "COLORFGBG=15;0"} {
+		env := buildSessionEnv(SessionEnvParams{Theme: theme})
+		found := false
+		for _, kv := range env {
+			if strings.HasPrefix(kv, "COLORFGBG=") {
+				if kv != want {
+					t.Errorf("theme %q: got %s, want %s", theme, kv, want)
+				}
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("theme %q: COLORFGBG not set", theme)
+		}
+	}
+}
+
+func TestSessionSetThemeWritesOSC11(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	sess := &Session{UUID: "theme-sess", Theme: "dark", PTY: w}
+
+	// Nothing asked for the background yet, so nothing is typed.
+	if changed, err := sess.SetTheme("light"); err != nil || !changed {
+		t.Fatalf("SetTheme(light) = %v, %v; want true, nil", changed, err)
+	}
+	sess.bgQueryPending.Store(true) // as the PTY reader does for ESC ] 11 ; ?
+
+	changed, err := sess.SetTheme("dark")
+	if err != nil || !changed {
+		t.Fatalf("SetTheme(dark) = %v, %v; want true, nil", changed, err)
+	}
+	if sess.Theme != "dark" {
+		t.Errorf("Theme = %q, want dark", sess.Theme)
+	}
+
+	// A repeat switch is a no-op and must not write another report.
+	if changed, err := sess.SetTheme("dark"); err != nil || changed {
+		t.Errorf("repeat SetTheme(dark) = %v, %v; want false, nil", changed, err)
+	}
+	// The query was answered; the next switch waits for another.
+	if changed, err := sess.SetTheme("light"); err != nil || !changed {
+		t.Errorf("SetTheme(light) = %v, %v; want true, nil", changed, err)
+	}
+	w.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := string(termsnap.BackgroundReport("dark")); string(got) != want {
+		t.Errorf("PTY got %q, want %q", got, want)
+	}
+}
+
+func TestSessionSetThemeRejectsUnknown(t *testing.T) {
+	sess := &Session{Theme: "dark"}
+	if _, err := sess.SetTheme("sepia"); err == nil {
+		t.Error("SetTheme(sepia) should fail")
+	}
+	if sess.Theme != "dark" {
+		t.Errorf("Theme changed to %q on invalid input", sess.Theme)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hinshun/vt10x"
+)
+
+func TestXterm256Color(t *testing.T) {
+	for i, want := range map[int]string{1: "#cd0000", 16: "#000000", 21: "#0000ff", 196: "#ff0000", 231: "#ffffff", 232: "#080808", 255: "#eeeeee"} {
+		if got := xterm256Color(i); got != want {
+			t.Errorf("xterm256Color(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestRenderThumbnailSVG(t *testing.T) {
+	vt := vt10x.New(vt10x.WithSize(10, 2))
+	vt.Write([]byte("a<b\x1b[32;41mok\x1b[0m"))
+	svg := string(renderThumbnailSVG(readScreen(vt)))
+
+	if err := xml.Unmarshal([]byte(svg), new(struct{})); err != nil {
+		t.Fatalf("not well-formed: %v\n%s", err, svg)
+	}
+	for _, want := range []string{
+		`viewBox="0 0 60 24"`,
+		`a&lt;b`,
+		`<tspan fill="#00cd00">ok</tspan>`,
+		`<rect x="18" y="0" width="12" height="12" fill="#cd0000"/>`,
+		`textLength="60"`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("missing %q in\n%s", want, svg)
+		}
+	}
+	// The blank second row draws nothing.
+	if n := strings.Count(svg, "<text "); n != 1 {
+		t.Errorf("%d <text> rows, want 1", n)
+	}
+}
+
+func TestSessionThumbnailAPIAndLiveHash(t *testing.T) {
+	const id = "thumb-api-sess"
+	vt := vt10x.New(vt10x.WithSize(20, 4))
+	vt.Write([]byte("$ make"))
+	sessionsMu.Lock()
+	sessions[id] = &Session{UUID: id, vt: vt}
+	sessionsMu.Unlock()
+	defer func() {
+		sessionsMu.Lock()
+		delete(sessions, id)
+		sessionsMu.Unlock()
+		refreshThumbnails()
+	}()
+
+	// Rendered on demand before the refresher has run.
+	rr := httptest.NewRecorder()
+	handleSessionThumbnailAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/"+id+"/thumbnail", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(rr.Body.String(), "$ make") {
+		t.Fatalf("status %d, type %q, body %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	refreshThumbnails()
+	hash := sessionThumbHash(id)
+	if hash == "" || rr.Header().Get("ETag") != `"`+hash+`"` {
+		t.Fatalf("hash %q, etag %q", hash, rr.Header().Get("ETag"))
+	}
+
+	rr = httptest.NewRecorder()
+	handleLiveSessionsAPI(rr, httptest.NewRequest(http.MethodGet, "/api/sessions/live", nil))
+	var body struct {
+		Sessions []struct {
+			UUID  string `json:"uuid"`
+			Thumb string `json:"thumb"`
+		} `json:"sessions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Sessions) != 1 || body.Sessions[0].Thumb != hash {
+		t.Errorf("live sessions = %+v, want thumb %s", body.Sessions, hash)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/session/"+id+"/thumbnail?h="+hash, nil)
+	req.Header.Set("If-None-Match", `"`+hash+`"`)
+	rr = httptest.NewRecorder()
+	handleSessionThumbnailAPI(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("conditional GET: status %d, want 304", rr.Code)
+	}
+
+	// New output, new hash.
+	vt.Write([]byte(" test"))
+	refreshThumbnails()
+	if h := sessionThumbHash(id); h == hash || h == "" {
+		t.Errorf("hash after output = %q (was %q)", h, hash)
+	}
+
+	rr = httptest.NewRecorder()
+	handleSessionThumbnailAPI(rr, httptest.NewRequest(http.MethodGet, "/api/session/nope/thumbnail", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d", rr.Code)
+	}
+}
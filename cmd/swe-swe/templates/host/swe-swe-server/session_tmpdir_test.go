@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionTmpDirEnv(t *testing.T) {
+	orig := sessionTmpRoot
+	sessionTmpRoot = t.TempDir()
+	defer func() { sessionTmpRoot = orig }()
+	origGit := sessionGitconfigDir
+	sessionGitconfigDir = t.TempDir()
+	defer func() { sessionGitconfigDir = origGit }()
+	t.Setenv("TMPDIR", "/var/tmp/server")
+
+	sid := "6f1c2a9e-4b7d-4e1a-9c3f-2d8e5b7a1c04"
+	env := buildSessionEnv(SessionEnvParams{SID: sid})
+	want := filepath.Join(sessionTmpRoot, sid)
+	if v, _ := envValue(env, "TMPDIR"); v != want {
+		t.Fatalf("TMPDIR = %q, want %q", v, want)
+	}
+	if fi, err := os.Stat(want); err != nil || !fi.IsDir() {
+		t.Fatalf("temp dir not created: %v", err)
+	}
+
+	// A read-only directory inside does not stop the cleanup.
+	ro := filepath.Join(want, "gomod", "pkg@v1")
+	os.MkdirAll(ro, 0755)
+	os.WriteFile(filepath.Join(ro, "go.mod"), []byte("module pkg\n"), 0444)
+	os.Chmod(ro, 0555)
+	removeSessionTmpDir(sid, "")
+	if _, err := os.Stat(want); !os.IsNotExist(err) {
+		t.Errorf("temp dir survived removal: %v", err)
+	}
+
+	t.Setenv("SWE_SESSION_TMPDIR", "off")
+	env = buildSessionEnv(SessionEnvParams{SID: sid})
+	if v, _ := envValue(env, "TMPDIR"); v != "/var/tmp/server" {
+		t.Errorf("off: TMPDIR = %q, want the server's", v)
+	}
+}
+
+func TestSessionTmpDirInWorkDir(t *testing.T) {
+	t.Setenv("SWE_SESSION_TMPDIR", "workdir")
+	workDir := t.TempDir()
+	sid := "6f1c2a9e-4b7d-4e1a-9c3f-2d8e5b7a1c04"
+	dir, err := ensureSessionTmpDir(sid, workDir)
+	if err != nil || dir != filepath.Join(workDir, ".swe-swe", "tmp", sid) {
+		t.Fatalf("ensureSessionTmpDir = %q, %v", dir, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(workDir, ".swe-swe", "tmp", ".gitignore")); err != nil || string(b) != "*\n" {
+		t.Errorf(".gitignore = %q, %v", b, err)
+	}
+	removeSessionTmpDir(sid, workDir)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("temp dir survived removal: %v", err)
+	}
+}
+
+func TestSweepSessionTmpDirs(t *testing.T) {
+	orig := sessionTmpRoot
+	sessionTmpRoot = t.TempDir()
+	defer func() { sessionTmpRoot = orig }()
+	workDir := t.TempDir()
+
+	live := "11111111-1111-4111-8111-111111111111"
+	gone := "22222222-2222-4222-8222-222222222222"
+	fresh := "33333333-3333-4333-8333-333333333333"
+	inWorkDir := filepath.Join(workDir, ".swe-swe", "tmp", gone)
+	for _, dir := range []string{
+		filepath.Join(sessionTmpRoot, live),
+		filepath.Join(sessionTmpRoot, gone),
+		filepath.Join(sessionTmpRoot, fresh),
+		filepath.Join(sessionTmpRoot, "not-a-session"),
+		inWorkDir,
+	} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().Add(sessionTmpGrace + time.Minute)
+	os.Chtimes(filepath.Join(sessionTmpRoot, fresh), now, now)
+
+	sweepSessionTmpDirs(map[string]bool{live: true}, []string{workDir}, now)
+	for dir, kept := range map[string]bool{
+		filepath.Join(sessionTmpRoot, live):            true,
+		filepath.Join(sessionTmpRoot, gone):            false,
+		filepath.Join(sessionTmpRoot, fresh):           true,
+		filepath.Join(sessionTmpRoot, "not-a-session"): true,
+		inWorkDir: false,
+	} {
+		if _, err := os.Stat(dir); (err == nil) != kept {
+			t.Errorf("%s: kept = %v, want %v", dir, err == nil, kept)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const exportTestUUID = "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"
+
+// exportEntries lists an export's entry names and contents.
+func exportEntries(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	entries := map[string]string{}
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && hdr.Name != exportManifestName {
+			t.Errorf("first entry %q", hdr.Name)
+		}
+		body, _ := io.ReadAll(tr)
+		entries[hdr.Name] = string(body)
+	}
+}
+
+func writeExportTestFiles(t *testing.T, dir string) {
+	t.Helper()
+	for name, content := range map[string]string{
+		"session-" + exportTestUUID + ".metadata.json": `{"uuid":"` + exportTestUUID + `","name":"fix login"}`,
+		"session-" + exportTestUUID + ".shares.json":   `[]`,
+		"session-" + exportTestUUID + ".log":           "$ make test\r\n",
+		"ports.json":                                   `{}`,
+		"usage.jsonl":                                  `{"uuid":"` + exportTestUUID + `","agent":"claude"}` + "\n",
+		"ports.json.tmp":                               "half written",
+		secretsFileName:                                `{}`,
+		secretsKeyFileName:                             "key",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStateExportAPI(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	writeExportTestFiles(t, dir)
+
+	rr := httptest.NewRecorder()
+	handleDataExportAPI(rr, httptest.NewRequest(http.MethodGet, "/api/data/export", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("export: %d %s", rr.Code, rr.Header())
+	}
+	entries := exportEntries(t, rr.Body.Bytes())
+	var manifest exportManifest
+	if err := json.Unmarshal([]byte(entries[exportManifestName]), &manifest); err != nil || manifest.Format != exportFormat || !manifest.Logs {
+		t.Errorf("manifest = %+v, %v", manifest, err)
+	}
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := []string{
+		"recordings/ports.json",
+		"recordings/session-" + exportTestUUID + ".log",
+		"recordings/session-" + exportTestUUID + ".metadata.json",
+		"recordings/session-" + exportTestUUID + ".shares.json",
+		"recordings/usage.jsonl",
+		exportManifestName,
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+
+	rr = httptest.NewRecorder()
+	handleDataExportAPI(rr, httptest.NewRequest(http.MethodGet, "/api/data/export?logs=0", nil))
+	if _, ok := exportEntries(t, rr.Body.Bytes())["recordings/session-"+exportTestUUID+".log"]; ok {
+		t.Error("logs=0 exported the terminal log")
+	}
+}
+
+func TestStateImportAPI(t *testing.T) {
+	writeExportTestFiles(t, withTempRecordingsDir(t))
+	var export bytes.Buffer
+	if err := writeStateExport(&export, true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new server that already has its own usage entry and ports.json.
+	dir := withTempRecordingsDir(t)
+	os.WriteFile(filepath.Join(dir, "usage.jsonl"), []byte(`{"uuid":"other","agent":"codex"}`+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "ports.json"), []byte(`{"kept":{}}`), 0644)
+	importOnce := func(query string) importReport {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handleDataImportAPI(rr, httptest.NewRequest(http.MethodPost, "/api/data/import"+query, bytes.NewReader(export.Bytes())))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("import: %d %s", rr.Code, rr.Body.String())
+		}
+		var report importReport
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	report := importOnce("")
+	if len(report.Imported) != 3 || !reflect.DeepEqual(report.Skipped, []string{"ports.json"}) || report.UsageEntries != 1 {
+		t.Errorf("first import = %+v", report)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "session-"+exportTestUUID+".metadata.json")); !strings.Contains(string(data), "fix login") {
+		t.Errorf("metadata = %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "ports.json")); string(data) != `{"kept":{}}` {
+		t.Errorf("existing ports.json overwritten: %q", data)
+	}
+	if ledger, _ := readUsageLedger(); len(ledger) != 2 {
+		t.Errorf("ledger = %+v", ledger)
+	}
+
+	report = importOnce("?overwrite=1")
+	if len(report.Imported) != 4 || len(report.Skipped) != 0 || report.UsageEntries != 0 {
+		t.Errorf("overwrite import = %+v", report)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "ports.json")); string(data) != `{}` {
+		t.Errorf("ports.json = %q", data)
+	}
+}
+
+func TestStateImportSkipsLiveAndOutsideFiles(t *testing.T) {
+	dir := withTempRecordingsDir(t)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range []struct{ name, body string }{
+		{exportManifestName, `{"format":1}`},
+		{"recordings/../escaped", "x"},
+		{"notes.txt", "x"},
+		{"recordings/session-" + exportTestUUID + ".log", "imported over a live log"},
+		{"recordings/" + secretsKeyFileName, "someone else's key"},
+	} {
+		tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body))})
+		tw.Write([]byte(e.body))
+	}
+	tw.Close()
+	gz.Close()
+
+	registerTestSession(t, "live", &Session{UUID: "live", RecordingUUID: exportTestUUID, Cmd: &exec.Cmd{}})
+	report, err := importState(&buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Imported) != 0 || len(report.Skipped) != 4 {
+		t.Errorf("report = %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(dir, secretsKeyFileName)); err == nil {
+		t.Error("imported a secrets key")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escaped")); err == nil {
+		t.Error("wrote outside the recordings dir")
+	}
+
+	if _, err := importState(strings.NewReader("not gzip"), false); err == nil {
+		t.Error("imported a non-export")
+	}
+}
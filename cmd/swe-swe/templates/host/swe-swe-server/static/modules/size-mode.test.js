@@ -0,0 +1,64 @@
+/**
+ * Unit tests for size-mode.js
+ * Run with: node --test size-mode.test.js
+ */
+
+import { test } from 'node:test';
+import assert from 'node:assert';
+import {
+    SIZE_MODE_FOLLOW,
+    SIZE_MODE_INDEPENDENT,
+    INDEPENDENT_MIN_SCALE,
+    normalizeSizeMode,
+    independentViewScale,
+    parsePinnedSize,
+    formatPinnedSize
+} from './size-mode.js';
+
+test('normalizeSizeMode defaults to follow', () => {
+    assert.strictEqual(normalizeSizeMode('independent'), SIZE_MODE_INDEPENDENT);
+    assert.strictEqual(normalizeSizeMode('follow'), SIZE_MODE_FOLLOW);
+    assert.strictEqual(normalizeSizeMode(null), SIZE_MODE_FOLLOW);
+    assert.strictEqual(normalizeSizeMode('scaled'), SIZE_MODE_FOLLOW);
+});
+
+test('independentViewScale shrinks a wide terminal to fit', () => {
+    assert.strictEqual(independentViewScale(600, 800), 0.75);
+});
+
+test('independentViewScale never enlarges', () => {
+    assert.strictEqual(independentViewScale(1200, 800), 1);
+});
+
+test('independentViewScale stops at minScale and crops past it', () => {
+    assert.strictEqual(independentViewScale(300, 1600), INDEPENDENT_MIN_SCALE);
+    assert.strictEqual(independentViewScale(300, 1600, 0.1), 0.1875);
+});
+
+test('independentViewScale ignores unmeasured sizes', () => {
+    assert.strictEqual(independentViewScale(0, 800), 1);
+    assert.strictEqual(independentViewScale(600, 0), 1);
+    assert.strictEqual(independentViewScale(NaN, 800), 1);
+});
+
+test('parsePinnedSize reads COLSxROWS', () => {
+    assert.deepStrictEqual(parsePinnedSize('200x50'), { cols: 200, rows: 50 });
+    assert.deepStrictEqual(parsePinnedSize(' 120 X 40 '), { cols: 120, rows: 40 });
+});
+
+test('parsePinnedSize treats empty as unpin', () => {
+    assert.deepStrictEqual(parsePinnedSize(''), { cols: 0, rows: 0 });
+    assert.deepStrictEqual(parsePinnedSize(null), { cols: 0, rows: 0 });
+});
+
+test('parsePinnedSize rejects anything else', () => {
+    for (const v of ['200', '200x', 'x50', '200x50x2', '-1x5', 'wide']) {
+        assert.strictEqual(parsePinnedSize(v), null, v);
+    }
+});
+
+test('formatPinnedSize round-trips through parsePinnedSize', () => {
+    assert.strictEqual(formatPinnedSize({ cols: 200, rows: 50 }), '200x50');
+    assert.deepStrictEqual(parsePinnedSize(formatPinnedSize({ cols: 200, rows: 50 })), { cols: 200, rows: 50 });
+    assert.strictEqual(formatPinnedSize(null), '');
+});
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// withStaticAssets loads fsys as the served asset table for one test.
+func withStaticAssets(t *testing.T, fsys fs.FS) map[string]*staticAsset {
+	t.Helper()
+	assets, err := loadStaticAssets(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := staticAssets
+	staticAssets = assets
+	t.Cleanup(func() { staticAssets = old })
+	return assets
+}
+
+func serveAsset(assets map[string]*staticAsset, url string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	if !serveStaticAsset(assets, rr, req) {
+		rr.Code = http.StatusNotFound
+	}
+	return rr
+}
+
+func TestLoadStaticAssetsEmbedded(t *testing.T) {
+	content, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets := withStaticAssets(t, content)
+	ui, ok := assets["/terminal-ui.js"]
+	if !ok {
+		t.Fatal("terminal-ui.js missing from the asset table")
+	}
+	if bytes.Contains(ui.body, []byte("{{TERMINAL_FONT_SIZE}}")) {
+		t.Error("terminal-ui.js placeholders not substituted at load")
+	}
+	if ui.gzip == nil || len(ui.gzip) >= len(ui.body) {
+		t.Error("terminal-ui.js has no useful gzip variant")
+	}
+	if got := assetURL("/xterm.js"); got != "/xterm.js?v="+assets["/xterm.js"].hash {
+		t.Errorf("assetURL = %q", got)
+	}
+	if got := assetURL("/no-such.js"); got != "/no-such.js" {
+		t.Errorf("assetURL(unknown) = %q", got)
+	}
+	if _, ok := assets["/modules/util.js"]; !ok {
+		t.Error("nested module missing from the asset table")
+	}
+}
+
+func TestServeStaticAssetCaching(t *testing.T) {
+	js := strings.Repeat("console.log('hello');\n", 50)
+	assets := withStaticAssets(t, fstest.MapFS{"app.js": {Data: []byte(js)}})
+	hash := assets["/app.js"].hash
+
+	rr := serveAsset(assets, "/app.js", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != js {
+		t.Fatalf("plain: %d %q", rr.Code, rr.Body.String())
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("unhashed Cache-Control = %q", cc)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/javascript") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	etag := rr.Header().Get("ETag")
+	if etag != `"`+hash+`"` {
+		t.Errorf("ETag = %q", etag)
+	}
+
+	rr = serveAsset(assets, "/app.js?v="+hash, nil)
+	if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("hashed Cache-Control = %q", cc)
+	}
+	rr = serveAsset(assets, "/app.js?v=stale", nil)
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("stale-hash Cache-Control = %q", cc)
+	}
+
+	rr = serveAsset(assets, "/app.js", map[string]string{"If-None-Match": etag})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d, want 304", rr.Code)
+	}
+	if rr := serveAsset(assets, "/missing.js", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown path served")
+	}
+}
+
+func TestServeStaticAssetEncoding(t *testing.T) {
+	js := strings.Repeat("export const x = 1;\n", 50)
+	assets := withStaticAssets(t, fstest.MapFS{
+		"app.js":        {Data: []byte(js)},
+		"pre.js":        {Data: []byte(js)},
+		"pre.js.br":     {Data: []byte("brotli bytes")},
+		"logo.png":      {Data: []byte("\x89PNG\r\n\x1a\n")},
+		"orphan.css.gz": {Data: []byte("ignored")},
+	})
+
+	rr := serveAsset(assets, "/app.js", map[string]string{"Accept-Encoding": "br, gzip"})
+	if rr.Header().Get("Content-Encoding") != "gzip" || rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("gzip: headers %v", rr.Header())
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != js {
+		t.Error("gzip body does not decode to the asset")
+	}
+	if !strings.HasSuffix(rr.Header().Get("ETag"), `-gz"`) {
+		t.Errorf("gzip ETag = %q", rr.Header().Get("ETag"))
+	}
+
+	if rr := serveAsset(assets, "/app.js", map[string]string{"Accept-Encoding": "gzip;q=0"}); rr.Header().Get("Content-Encoding") != "" {
+		t.Error("gzip;q=0 still got gzip")
+	}
+	rr = serveAsset(assets, "/pre.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	if rr.Header().Get("Content-Encoding") != "br" || rr.Body.String() != "brotli bytes" {
+		t.Errorf("br: %v %q", rr.Header(), rr.Body.String())
+	}
+	if rr := serveAsset(assets, "/logo.png", map[string]string{"Accept-Encoding": "gzip"}); rr.Header().Get("Content-Encoding") != "" || rr.Header().Get("Vary") != "" {
+		t.Errorf("png: %v", rr.Header())
+	}
+	if _, ok := assets["/orphan.css.gz"]; ok {
+		t.Error("a .gz file was served as an asset of its own")
+	}
+}
+
+func TestPageTemplatesUseHashedAssets(t *testing.T) {
+	content, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets := withStaticAssets(t, content)
+	src, err := pageTemplatesFS.ReadFile("page-templates/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := template.New("index").Funcs(pageTemplateFuncs).Parse(string(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, struct{ UUID, UUIDShort, Assistant, AssistantName, Version, LocalUserName, LocalUserEmail, WhereKey, InitSHA, LocalGPGOverrides, LocalRemoteHost string }{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `src="/terminal-ui.js?v=` + assets["/terminal-ui.js"].hash + `"`
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("rendered index.html lacks %s", want)
+	}
+}
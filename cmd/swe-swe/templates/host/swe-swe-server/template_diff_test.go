@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n15\n16"
+	want := `--- a/f
++++ b/f
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -11,5 +11,5 @@
+ 11
+ 12
+ 13
+-14
+ 15
++16
+\ No newline at end of file
+`
+	if got := unifiedDiff("a/f", "b/f", []byte(a), []byte(b)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("a/f", "b/f", []byte(a), []byte(a)); got != "" {
+		t.Errorf("equal files: %q", got)
+	}
+	if got := unifiedDiff("/dev/null", "b/f", nil, []byte("x\n")); got != "--- /dev/null\n+++ b/f\n@@ -0,0 +1 @@\n+x\n" {
+		t.Errorf("new file: %q", got)
+	}
+}
+
+func TestDiffContainerTemplates(t *testing.T) {
+	repo := t.TempDir()
+	if err := setupSweSweFiles(repo); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := diffContainerTemplates(repo)
+	if err != nil || len(changes) == 0 {
+		t.Fatalf("diffContainerTemplates = %v, %v", changes, err)
+	}
+	for _, c := range changes {
+		if c.Action != templateUnchanged || !c.Baseline || c.Diff != "" {
+			t.Errorf("fresh repo: %+v", c)
+		}
+	}
+
+	// Nothing is written, whatever the diff says.
+	const agents = ".swe-swe/docs/AGENTS.md"
+	os.Remove(filepath.Join(repo, agents))
+	before := snapshotTree(t, repo)
+	changes, _ = diffContainerTemplates(repo)
+	if c := findTemplateChange(changes, agents); c.Action != templateAdd || !strings.HasPrefix(c.Diff, "--- /dev/null\n") {
+		t.Errorf("removed file: %+v", c)
+	}
+	if after := snapshotTree(t, repo); after != before {
+		t.Error("diffContainerTemplates wrote to the repo")
+	}
+}
+
+func TestClassifyTemplateChange(t *testing.T) {
+	base, tmpl, edited := []byte("a\nb\n"), []byte("a\nB\n"), []byte("a\nb\nmine\n")
+	for _, tc := range []struct {
+		name        string
+		current     []byte
+		hasCurrent  bool
+		baseline    []byte
+		hasBaseline bool
+		want        string
+	}{
+		{"missing", nil, false, base, true, templateAdd},
+		{"same as template", tmpl, true, base, true, templateUnchanged},
+		{"untouched since baseline", base, true, base, true, templateUpdate},
+		{"template did not move", edited, true, tmpl, true, templateKeep},
+		{"no baseline", edited, true, nil, false, templateAsk},
+		{"both moved", edited, true, base, true, templateMerge},
+	} {
+		c := classifyTemplateChange("f", tmpl, tc.current, tc.hasCurrent, tc.baseline, tc.hasBaseline)
+		if c.Action != tc.want {
+			t.Errorf("%s: action %q, want %q", tc.name, c.Action, tc.want)
+		}
+		if wantDiff := tc.want != templateUnchanged && tc.want != templateKeep; (c.Diff != "") != wantDiff {
+			t.Errorf("%s: diff %q", tc.name, c.Diff)
+		}
+		if (c.LocalDiff != "") != (tc.want == templateMerge) {
+			t.Errorf("%s: localDiff %q", tc.name, c.LocalDiff)
+		}
+	}
+}
+
+func findTemplateChange(changes []templateChange, path string) templateChange {
+	for _, c := range changes {
+		if c.Path == path {
+			return c
+		}
+	}
+	return templateChange{}
+}
+
+// snapshotTree lists every file under dir with its content.
+func snapshotTree(t *testing.T, dir string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			data, _ := os.ReadFile(path)
+			buf.WriteString(path + "\n")
+			buf.Write(data)
+		}
+		return nil
+	})
+	return buf.String()
+}
+
+func TestRepoTemplateDiffDeniedToGuest(t *testing.T) {
+	const secret = "master"
+	handler := authMiddleware(http.HandlerFunc(handleRepoTemplateDiffAPI), secret)
+	req := httptest.NewRequest(http.MethodGet, "/api/repo/template-diff?path=/repos/other/workspace", nil)
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignScopedCookie(secret, "guest-sess")})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("scoped guest status = %d, want 403", rr.Code)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeLines(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	for _, tc := range []struct {
+		name, ours, theirs, want string
+		conflicts                int
+	}{
+		{"ours only", "a\nB\nc\nd\ne\n", base, "a\nB\nc\nd\ne\n", 0},
+		{"theirs only", base, "a\nb\nc\nd\nE\n", "a\nb\nc\nd\nE\n", 0},
+		{"both, apart", "a\nB\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "a\nB\nc\nd\nE\n", 0},
+		{"both, same", "a\nX\nc\nd\ne\n", "a\nX\nc\nd\ne\n", "a\nX\nc\nd\ne\n", 0},
+		{"both insert at end", base + "mine\n", base + "new\n", base + "<<<<<<< ours\nmine\n=======\nnew\n>>>>>>> theirs\n", 1},
+		{"both, same lines", "a\nmine\nc\nd\ne\n", "a\nnew\nc\nd\ne", "a\n<<<<<<< ours\nmine\n=======\nnew\n>>>>>>> theirs\nc\nd\ne", 1},
+		{"no newline at end", "a\nb\nc\nd\nmine", "a\nb\nc\nd\nnew", "a\nb\nc\nd\n<<<<<<< ours\nmine\n=======\nnew\n>>>>>>> theirs\n", 1},
+	} {
+		got, n := mergeLines([]byte(base), []byte(tc.ours), []byte(tc.theirs), "ours", "theirs")
+		if string(got) != tc.want || n != tc.conflicts {
+			t.Errorf("%s: got %q (%d conflicts), want %q (%d)", tc.name, got, n, tc.want, tc.conflicts)
+		}
+	}
+}
+
+func TestApplyTemplateUpdate(t *testing.T) {
+	repo := t.TempDir()
+	if err := setupSweSweFiles(repo); err != nil {
+		t.Fatal(err)
+	}
+	const agents = ".swe-swe/docs/AGENTS.md"
+	tmpl, err := containerTemplatesFS.ReadFile("container-templates/" + agents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(repo, agents)
+	basePath := filepath.Join(repo, ".swe-swe", "baseline", agents)
+
+	// An older template as the baseline, and a user edit at the other end.
+	lines := strings.SplitAfter(string(tmpl), "\n")
+	old := strings.Join(lines[1:], "")
+	os.WriteFile(basePath, []byte(old), 0644)
+	os.WriteFile(path, []byte(old+"my notes\n"), 0644)
+
+	before := snapshotTree(t, repo)
+	results, err := applyTemplateUpdate(repo, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := findTemplateResult(results, agents); r.Action != templateMerge || r.Result != "merged" {
+		t.Errorf("dry run: %+v", r)
+	}
+	if snapshotTree(t, repo) != before {
+		t.Error("dry run wrote to the repo")
+	}
+
+	if _, err := applyTemplateUpdate(repo, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(tmpl)+"my notes\n" {
+		t.Errorf("merged = %q", got)
+	}
+	if got, _ := os.ReadFile(basePath); string(got) != string(tmpl) {
+		t.Error("baseline not refreshed")
+	}
+
+	// The same lines changed on both sides.
+	os.WriteFile(basePath, []byte(old+"v1\n"), 0644)
+	os.WriteFile(path, []byte(old+"mine\n"), 0644)
+	results, _ = applyTemplateUpdate(repo, nil, false)
+	if r := findTemplateResult(results, agents); r.Result != "conflict" || r.Conflicts != 1 {
+		t.Errorf("conflict: %+v", r)
+	}
+	if got, _ := os.ReadFile(path); !strings.Contains(string(got), "<<<<<<< current\n") || !strings.Contains(string(got), ">>>>>>> swe-swe "+Version+"\n") {
+		t.Errorf("no conflict markers in %q", got)
+	}
+
+	// Without a baseline the repo's copy is left alone unless replaced.
+	os.Remove(basePath)
+	os.WriteFile(path, []byte("mine\n"), 0644)
+	results, _ = applyTemplateUpdate(repo, nil, false)
+	if r := findTemplateResult(results, agents); r.Result != "skipped" {
+		t.Errorf("ask: %+v", r)
+	}
+	if _, err := os.Stat(basePath); err == nil {
+		t.Error("skipped file got a baseline")
+	}
+	results, _ = applyTemplateUpdate(repo, []string{agents}, false)
+	if r := findTemplateResult(results, agents); r.Result != "replaced" {
+		t.Errorf("replace: %+v", r)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(tmpl) {
+		t.Errorf("replaced = %q", got)
+	}
+}
+
+func TestTemplatesUpdateDeniedToGuest(t *testing.T) {
+	const secret = "master"
+	handler := authMiddleware(http.HandlerFunc(handleTemplatesUpdateAPI), secret)
+	body := `{"path": "/repos/other/workspace", "replace": [".swe-swe/docs/AGENTS.md"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/templates/update", strings.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: authSignScopedCookie(secret, "guest-sess")})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("scoped guest status = %d, want 403", rr.Code)
+	}
+}
+
+func findTemplateResult(results []templateResult, path string) templateResult {
+	for _, r := range results {
+		if r.Path == path {
+			return r
+		}
+	}
+	return templateResult{}
+}
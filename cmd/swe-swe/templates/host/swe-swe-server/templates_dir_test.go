@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// withTemplatesDirFiles creates an override directory holding files (path ->
+// content) and points -templates-dir at it for one test.
+func withTemplatesDirFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { templatesDir = "" })
+	if err := loadTemplatesDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadTemplatesDir(t *testing.T) {
+	t.Cleanup(func() { templatesDir = "" })
+	if err := loadTemplatesDir(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing directory: want an error")
+	}
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o644)
+	if err := loadTemplatesDir(file); err == nil {
+		t.Error("regular file: want an error")
+	}
+	dir := t.TempDir()
+	t.Setenv("SWE_TEMPLATES_DIR", dir)
+	if err := loadTemplatesDir(""); err != nil || templatesDir != dir {
+		t.Errorf("from env: templatesDir = %q, %v", templatesDir, err)
+	}
+}
+
+func TestOverlayFS(t *testing.T) {
+	lower := fstest.MapFS{
+		"app.js":           {Data: []byte("embedded app")},
+		"styles/theme.css": {Data: []byte("embedded theme")},
+		"styles/other.css": {Data: []byte("embedded other")},
+		"modules/util.js":  {Data: []byte("embedded util")},
+	}
+	upper := fstest.MapFS{
+		"styles/theme.css": {Data: []byte("custom theme")},
+		"logo.svg":         {Data: []byte("<svg/>")},
+	}
+	o := overlayFS{upper: upper, lower: lower}
+	for name, want := range map[string]string{
+		"app.js":           "embedded app",
+		"styles/theme.css": "custom theme",
+		"styles/other.css": "embedded other",
+		"logo.svg":         "<svg/>",
+	} {
+		got, err := fs.ReadFile(o, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := fs.ReadFile(o, "nope.js"); err == nil {
+		t.Error("missing file: want an error")
+	}
+
+	var walked []string
+	fs.WalkDir(o, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			walked = append(walked, name)
+		}
+		return err
+	})
+	if got := strings.Join(walked, ","); got != "app.js,logo.svg,modules/util.js,styles/other.css,styles/theme.css" {
+		t.Errorf("walk = %s", got)
+	}
+}
+
+func TestParsePageTemplateOverride(t *testing.T) {
+	withTemplatesDirFiles(t, map[string]string{
+		"page-templates/index.html":     `<title>Acme {{.Version}}</title>`,
+		"page-templates/selection.html": `{{if}}broken`,
+	})
+	render := func(name, file string) string {
+		tmpl, err := parsePageTemplate(name, file)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		var sb strings.Builder
+		tmpl.Execute(&sb, map[string]string{"Version": "1.2"})
+		return sb.String()
+	}
+	if got := render("index", "index.html"); got != "<title>Acme 1.2</title>" {
+		t.Errorf("override = %q", got)
+	}
+	// A broken override falls back to the embedded page.
+	if _, err := parsePageTemplate("selection", "selection.html"); err != nil {
+		t.Errorf("broken override: %v, want the embedded page", err)
+	}
+	// No override: embedded.
+	tmpl, err := parsePageTemplate("fork-confirm", "fork-confirm.html")
+	if err != nil || tmpl == nil {
+		t.Errorf("fork-confirm: %v", err)
+	}
+}
+
+func TestStaticAssetsWithTemplatesDir(t *testing.T) {
+	withTemplatesDirFiles(t, map[string]string{"static/styles/theme.css": ":root { --brand: #c00; }"})
+	embedded, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets := withStaticAssets(t, withTemplatesDir(embedded, "static"))
+	if got := string(assets["/styles/theme.css"].body); got != ":root { --brand: #c00; }" {
+		t.Errorf("theme.css = %q", got)
+	}
+	if _, ok := assets["/terminal-ui.js"]; !ok {
+		t.Error("embedded files missing next to the override")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTraceConfig(t *testing.T) {
+	env := func(kv map[string]string) func(string) string {
+		return func(k string) string { return kv[k] }
+	}
+	cases := []struct {
+		name     string
+		env      map[string]string
+		ok       bool
+		wantErr  bool
+		endpoint string
+	}{
+		{"unset", nil, false, false, ""},
+		{"base endpoint", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel:4318/"}, true, false, "http://otel:4318/v1/traces"},
+		{"traces endpoint wins", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://a:4318", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "https://b/otlp"}, true, false, "https://b/otlp"},
+		{"sdk disabled", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel:4318", "OTEL_SDK_DISABLED": "TRUE"}, false, false, ""},
+		{"exporter none", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel:4318", "OTEL_TRACES_EXPORTER": "none"}, false, false, ""},
+		{"grpc refused", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel:4317", "OTEL_EXPORTER_OTLP_PROTOCOL": "grpc"}, false, true, ""},
+		{"bad endpoint", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "otel:4318"}, false, true, ""},
+	}
+	for _, c := range cases {
+		cfg, ok, err := loadTraceConfig(env(c.env))
+		if ok != c.ok || (err != nil) != c.wantErr || cfg.Endpoint != c.endpoint && c.ok {
+			t.Errorf("%s: ok=%v err=%v endpoint=%q", c.name, ok, err, cfg.Endpoint)
+		}
+	}
+
+	cfg, _, _ := loadTraceConfig(env(map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel:4318",
+		"OTEL_EXPORTER_OTLP_HEADERS":  "x-api-key=a%20b, bogus",
+		"OTEL_RESOURCE_ATTRIBUTES":    "deployment.environment=prod",
+	}))
+	if cfg.Headers["x-api-key"] != "a b" || len(cfg.Headers) != 1 {
+		t.Errorf("headers = %v", cfg.Headers)
+	}
+	if cfg.Resource["service.name"] != "swe-swe-server" || cfg.Resource["deployment.environment"] != "prod" {
+		t.Errorf("resource = %v", cfg.Resource)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	if _, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"); !ok {
+		t.Error("valid traceparent rejected")
+	}
+	for _, h := range []string{"", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-xyz-00f067aa0ba902b7-01"} {
+		if _, ok := parseTraceparent(h); ok {
+			t.Errorf("%q accepted", h)
+		}
+	}
+}
+
+func TestSpansAreNoopsWhenTracingOff(t *testing.T) {
+	ctx, sp := startSpan(nil, "x", "k", "v")
+	if sp != nil || ctx == nil {
+		t.Fatalf("span=%v ctx=%v", sp, ctx)
+	}
+	sp.SetAttrs("a", 1)
+	sp.End(errors.New("ignored"))
+}
+
+func TestTraceExportEndToEnd(t *testing.T) {
+	got := make(chan []byte, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("x-api-key") != "k" {
+			t.Errorf("export request %s %v", r.URL.Path, r.Header)
+		}
+		b, _ := io.ReadAll(r.Body)
+		got <- b
+	}))
+	defer collector.Close()
+
+	prev := tracer
+	t.Cleanup(func() { tracer = prev })
+	tracer = newTraceExporter(traceConfig{
+		Endpoint: collector.URL + "/v1/traces",
+		Headers:  map[string]string{"x-api-key": "k"},
+		Resource: map[string]string{"service.name": "test"},
+	})
+	go tracer.run()
+
+	// An upstream traceparent is continued and the proxied handler sees a
+	// traceparent naming the proxy span.
+	var seen string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("traceparent")
+		_, child := startSpan(r.Context(), "inner", "n", 3)
+		child.End(errors.New("boom"))
+		w.WriteHeader(http.StatusTeapot)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/proxy/u/preview/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	traceHandler(httptest.NewRecorder(), req, "proxy.request", upstream)
+	if !strings.HasPrefix(seen, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || strings.Contains(seen, "00f067aa0ba902b7") {
+		t.Errorf("propagated traceparent = %q", seen)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdownTracing(ctx)
+
+	var payload struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	select {
+	case b := <-got:
+		if err := json.Unmarshal(b, &payload); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no export")
+	}
+	spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	inner, outer := spans[0], spans[1]
+	if outer.Name != "proxy.request" || outer.Kind != spanKindServer || outer.ParentSpanID != "00f067aa0ba902b7" || outer.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("outer = %+v", outer)
+	}
+	if inner.ParentSpanID != outer.SpanID || inner.Status.Code != spanStatusError || inner.Status.Message != "boom" {
+		t.Errorf("inner = %+v", inner)
+	}
+	var status string
+	for _, a := range outer.Attributes {
+		if a.Key == "http.response.status_code" && a.Value.IntValue != nil {
+			status = *a.Value.IntValue
+		}
+	}
+	if status != "418" {
+		t.Errorf("status attr = %q", status)
+	}
+}
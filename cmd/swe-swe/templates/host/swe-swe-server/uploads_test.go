@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\shot.png`, "shot.png"},
+		{"..", ""},
+		{"..hidden", ""},
+		{"  ", ""},
+		{"\u622a\u56fe.png", "\u622a\u56fe.png"},
+		// NFD "e" + combining acute composes to the single NFC rune.
+		{"caf\u0065\u0301.txt", "caf\u00e9.txt"},
+		{"bad\x00name\x1b[31m.txt", "badname[31m.txt"},
+		// Right-to-left override used to disguise "exe" as "gpj".
+		{"photo\u202egpj.exe", "photogpj.exe"},
+	}
+	for _, c := range cases {
+		if got := sanitizeFilename(c.in); got != c.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestWriteUniqueUploadSuffixesCollisions(t *testing.T) {
+	dir := t.TempDir()
+	var names []string
+	for i := 0; i < 3; i++ {
+		path, err := writeUniqueUpload(dir, "\u622a\u56fe.png", []byte{byte(i)})
+		if err != nil {
+			t.Fatalf("upload %d: %v", i, err)
+		}
+		names = append(names, filepath.Base(path))
+	}
+	want := []string{"\u622a\u56fe.png", "\u622a\u56fe-1.png", "\u622a\u56fe-2.png"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("upload %d stored as %q, want %q", i, names[i], want[i])
+		}
+		data, err := os.ReadFile(filepath.Join(dir, want[i]))
+		if err != nil || len(data) != 1 || data[0] != byte(i) {
+			t.Errorf("%s content = %v, %v; want [%d]", want[i], data, err, i)
+		}
+	}
+}
+
+func TestWriteUniqueUploadDotfileAndLongName(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := writeUniqueUpload(dir, ".env", nil); err != nil {
+		t.Fatal(err)
+	}
+	path, err := writeUniqueUpload(dir, ".env", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filepath.Base(path); got != ".env-1" {
+		t.Errorf("second .env stored as %q, want .env-1", got)
+	}
+
+}
+
+func TestWriteUniqueUploadLongNames(t *testing.T) {
+	cases := []struct {
+		name, in, suffix string
+	}{
+		{"long stem", strings.Repeat("\u5b57", 200) + ".png", ".png"}, // 600 bytes of stem
+		{"long extension", "x." + strings.Repeat("a", 300), "aaa"},
+		{"long multibyte extension", "x." + strings.Repeat("\u5b57", 100), "\u5b57"},
+		{"long dotfile", "." + strings.Repeat("a", 300), "aaa"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, err := writeUniqueUpload(t.TempDir(), c.in, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			base := filepath.Base(path)
+			if len(base) > maxUploadNameBytes || !strings.HasSuffix(base, c.suffix) || !utf8.ValidString(base) {
+				t.Errorf("stored as %q (%d bytes)", base, len(base))
+			}
+		})
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	cases := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"abc", 5, "abc"},
+		{"abc", 2, "ab"},
+		{"\u5b57\u5b57", 4, "\u5b57"},
+		{"abc", 0, ""},
+		{"abc", -61, ""},
+	}
+	for _, c := range cases {
+		if got := truncateUTF8(c.in, c.n); got != c.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", c.in, c.n, got, c.want)
+		}
+	}
+}
+
+func TestPastedImageName(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	cases := []struct {
+		contentType string
+		data        []byte
+		want        string
+	}{
+		{"image/png", png, "paste-20260102-150405.png"},
+		{"IMAGE/JPEG; charset=binary", png, "paste-20260102-150405.jpg"},
+		// Missing type: sniffed from the bytes.
+		{"", png, "paste-20260102-150405.png"},
+	}
+	for _, c := range cases {
+		got, err := pastedImageName(c.contentType, c.data, now)
+		if err != nil || got != c.want {
+			t.Errorf("pastedImageName(%q) = %q, %v; want %q", c.contentType, got, err, c.want)
+		}
+	}
+
+	if _, err := pastedImageName("text/html", []byte("<html></html>"), now); err == nil {
+		t.Error("non-image paste should be rejected")
+	}
+	if _, err := pastedImageName("image/png", nil, now); err == nil {
+		t.Error("empty paste should be rejected")
+	}
+}
+
+func TestSaveSessionUploadUsesWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	sess := &Session{WorkDir: dir}
+	path, err := saveSessionUpload(sess, "paste-20260102-150405.png", []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, ".swe-swe", "uploads", "paste-20260102-150405.png"); path != want {
+		t.Errorf("path = %q, want %q", path, want)
+	}
+}
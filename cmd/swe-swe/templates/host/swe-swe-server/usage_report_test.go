@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	usageClaudeRun = "11111111-1111-4111-8111-111111111111"
+	usageCodexRun  = "22222222-2222-4222-8222-222222222222"
+	usageLiveRun   = "33333333-3333-4333-8333-333333333333"
+)
+
+// writeUsageRecordings writes two ended sessions (Monday 2026-10-12 and the
+// Sunday after), a still-running one, and a child terminal recording.
+func writeUsageRecordings(t *testing.T) {
+	t.Helper()
+	at := func(day, hour int) time.Time { return time.Date(2026, 10, day, hour, 0, 0, 0, time.Local) }
+	ended := func(tm time.Time) *time.Time { return &tm }
+	writeMetadataFile(t, usageClaudeRun, RecordingMetadata{Agent: "Claude", WorkDir: "/repos/api/worktrees/fix-login", StartedAt: at(12, 9), EndedAt: ended(at(12, 11))})
+	writeMetadataFile(t, usageCodexRun, RecordingMetadata{Agent: "Codex", WorkDir: "/workspace", StartedAt: at(18, 22), EndedAt: ended(at(19, 1))})
+	writeMetadataFile(t, usageLiveRun, RecordingMetadata{Agent: "Claude", StartedAt: at(13, 9)})
+	writeMetadataFile(t, usageClaudeRun+"-"+usageLiveRun, RecordingMetadata{Agent: "Terminal", StartedAt: at(12, 9), EndedAt: ended(at(12, 10))})
+}
+
+func TestCollectUsageOutlivesRecordings(t *testing.T) {
+	withTempRecordingsDir(t)
+	writeUsageRecordings(t)
+
+	for i := 0; i < 2; i++ {
+		if err := collectUsage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ledger, err := readUsageLedger()
+	if err != nil || len(ledger) != 2 {
+		t.Fatalf("ledger = %+v, %v; want the two ended root sessions once each", ledger, err)
+	}
+	if ledger[0].UUID != usageClaudeRun || ledger[0].Repo != "api" || ledger[1].Repo != "workspace" {
+		t.Errorf("ledger = %+v", ledger)
+	}
+
+	deleteRecordingFiles(usageClaudeRun)
+	collectUsage()
+	if ledger, _ := readUsageLedger(); len(ledger) != 2 {
+		t.Errorf("deleting a recording dropped its usage: %+v", ledger)
+	}
+}
+
+func TestUsagePeriodBounds(t *testing.T) {
+	sunday := time.Date(2026, 10, 18, 23, 30, 0, 0, time.Local)
+	from, to, err := usagePeriodBounds("week", sunday)
+	if err != nil || from != time.Date(2026, 10, 12, 0, 0, 0, 0, time.Local) || to != time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local) {
+		t.Errorf("week = %v..%v, %v", from, to, err)
+	}
+	from, to, _ = usagePeriodBounds("day", sunday)
+	if from != time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local) || to.Sub(from) != 24*time.Hour {
+		t.Errorf("day = %v..%v", from, to)
+	}
+	if _, _, err := usagePeriodBounds("month", sunday); err == nil {
+		t.Error("month should fail")
+	}
+}
+
+func TestUsageReportAPI(t *testing.T) {
+	withTempRecordingsDir(t)
+	writeUsageRecordings(t)
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleUsageReportAPI(rr, httptest.NewRequest(http.MethodGet, "/api/reports/usage?"+query, nil))
+		return rr
+	}
+
+	rr := get("period=week&date=2026-10-14")
+	var report usageReport
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	if report.Sessions != 2 || report.DurationSeconds != 5*3600 {
+		t.Errorf("totals = %d sessions, %ds", report.Sessions, report.DurationSeconds)
+	}
+	if len(report.ByAssistant) != 2 || report.ByAssistant[0] != (usageGroup{Name: "Codex", Sessions: 1, DurationSeconds: 3 * 3600}) {
+		t.Errorf("byAssistant = %+v", report.ByAssistant)
+	}
+	if len(report.ByRepo) != 2 || report.ByRepo[1].Name != "api" {
+		t.Errorf("byRepo = %+v", report.ByRepo)
+	}
+
+	// A day report counts only the sessions started that day.
+	json.Unmarshal(get("period=day&date=2026-10-12").Body.Bytes(), &report)
+	if report.Sessions != 1 || report.ByAssistant[0].Name != "Claude" {
+		t.Errorf("day report = %+v", report)
+	}
+
+	rr = get("period=week&date=2026-10-14&format=markdown")
+	if body := rr.Body.String(); !strings.Contains(body, "# swe-swe usage: week of 2026-10-12") || !strings.Contains(body, "| Codex | 1 | 3h |") {
+		t.Errorf("markdown:\n%s", body)
+	}
+
+	for _, q := range []string{"period=month", "date=14-10-2026", "format=xml"} {
+		if rr := get(q); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", q, rr.Code)
+		}
+	}
+}
+
+func TestWriteScheduledUsageReport(t *testing.T) {
+	withTempRecordingsDir(t)
+	writeUsageRecordings(t)
+	collectUsage()
+	oldWorkspace, oldPeriod := workspaceDir, usageReportPeriod
+	workspaceDir, usageReportPeriod = t.TempDir(), "week"
+	t.Cleanup(func() { workspaceDir, usageReportPeriod = oldWorkspace, oldPeriod })
+
+	// During the following week, last week's report is written once.
+	now := time.Date(2026, 10, 21, 8, 0, 0, 0, time.Local)
+	if err := writeScheduledUsageReport(now); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(workspaceDir, ".swe-swe", "reports", "usage-week-2026-10-12.md")
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "2 sessions, 5h total") {
+		t.Fatalf("report = %q, %v", data, err)
+	}
+	os.WriteFile(path, []byte("edited"), 0644)
+	writeScheduledUsageReport(now)
+	if data, _ := os.ReadFile(path); string(data) != "edited" {
+		t.Error("existing report was rewritten")
+	}
+}
+
+func TestLoadUsageReport(t *testing.T) {
+	t.Cleanup(func() { usageReportPeriod = "" })
+	for v, want := range map[string]string{"": "", "daily": "day", "Weekly": "week", "off": ""} {
+		t.Setenv("SWE_USAGE_REPORT", v)
+		if err := loadUsageReport(); err != nil || usageReportPeriod != want {
+			t.Errorf("%q: period %q, %v", v, usageReportPeriod, err)
+		}
+	}
+	t.Setenv("SWE_USAGE_REPORT", "monthly")
+	if err := loadUsageReport(); err == nil {
+		t.Error("monthly should fail")
+	}
+}
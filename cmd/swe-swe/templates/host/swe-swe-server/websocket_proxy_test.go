@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -173,3 +174,146 @@ func TestWebSocketProxyBackendDown(t *testing.T) {
 		t.Errorf("Expected 502 Bad Gateway, got %d", resp.StatusCode)
 	}
 }
+
+// echoWSBackend upgrades (offering the "chat" subprotocol) and echoes frames.
+func echoWSBackend(t *testing.T) http.Handler {
+	wsUpgrader := websocket.Upgrader{Subprotocols: []string{"chat"}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("backend upgrade error: %v", err)
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// TestWebSocketProxyRelayTLS relays to a wss backend with a self-signed
+// certificate and negotiates the client's subprotocol with it.
+func TestWebSocketProxyRelayTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(echoWSBackend(t))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	proxy := httptest.NewServer(agentChatProxyHandler(backendURL))
+	defer proxy.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"chat"}}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial through proxy to wss backend: %v (resp=%v)", err, resp)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != "chat" {
+		t.Errorf("subprotocol = %q, want chat", conn.Subprotocol())
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("over tls"))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "over tls" {
+		t.Errorf("echo = %q, %v", msg, err)
+	}
+
+	// With verification on, the self-signed backend is refused.
+	strict := wsRelayConfig{HandshakeTimeout: 5 * time.Second, IdleTimeout: time.Minute}
+	strictProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		strict.relay(w, r, backendURL)
+	}))
+	defer strictProxy.Close()
+	_, resp, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(strictProxy.URL, "http")+"/ws", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("verified TLS to self-signed backend: err=%v resp=%v, want 502", err, resp)
+	}
+}
+
+// TestWebSocketProxyBackendRefusesUpgrade passes a backend's non-101 answer
+// through instead of relaying or reporting it as unreachable.
+func TestWebSocketProxyBackendRefusesUpgrade(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusForbidden)
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	proxy := httptest.NewServer(agentChatProxyHandler(backendURL))
+	defer proxy.Close()
+
+	before := wsRelayDialFailures.Value()
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/", nil)
+	if err == nil {
+		t.Fatal("expected the upgrade to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("resp = %v, want 403", resp)
+	}
+	if got := wsRelayDialFailures.Value() - before; got != 1 {
+		t.Errorf("dialFailures grew by %d, want 1", got)
+	}
+}
+
+// TestWebSocketProxyIdleTimeout ends a relay whose backend stops answering
+// pings, and keeps the active-connection count accurate.
+func TestWebSocketProxyIdleTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	defer close(hung)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-hung // never reads, so never answers a ping
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	cfg := wsRelayConfig{HandshakeTimeout: 5 * time.Second, IdleTimeout: 150 * time.Millisecond}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.relay(w, r, backendURL)
+	}))
+	defer proxy.Close()
+
+	activeBefore, idleBefore := wsRelayActive.Value(), wsRelayIdleTimeouts.Value()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The client answers pings (while reading), the backend does not.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("expected the relay to close the connection")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for wsRelayActive.Value() != activeBefore && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := wsRelayActive.Value(); got != activeBefore {
+		t.Errorf("active = %d after close, want %d", got, activeBefore)
+	}
+	if got := wsRelayIdleTimeouts.Value() - idleBefore; got != 1 {
+		t.Errorf("idleTimeouts grew by %d, want 1", got)
+	}
+}
+
+func TestWSRelayBackendURL(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ws?x=1", nil)
+	for target, want := range map[string]string{
+		"http://localhost:4000":      "ws://localhost:4000/ws?x=1",
+		"https://example.test/chat/": "wss://example.test/chat/ws?x=1",
+		"wss://example.test":         "wss://example.test/ws?x=1",
+	} {
+		u, _ := url.Parse(target)
+		if got, err := wsRelayBackendURL(u, r); err != nil || got != want {
+			t.Errorf("%s: %q, %v; want %q", target, got, err, want)
+		}
+	}
+	u, _ := url.Parse("ftp://example.test")
+	if _, err := wsRelayBackendURL(u, r); err == nil {
+		t.Error("ftp target: want an error")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWorktreeConflicts(t *testing.T) {
+	dir, run := gitTestRepo(t)
+	wtRoot := filepath.Join(t.TempDir(), "worktrees")
+	a, b, c := filepath.Join(wtRoot, "fix-a"), filepath.Join(wtRoot, "fix-b"), filepath.Join(wtRoot, "docs")
+	run("worktree", "add", "-q", "-b", "fix-a", a)
+	run("worktree", "add", "-q", "-b", "fix-b", b)
+	run("worktree", "add", "-q", "-b", "docs", c)
+
+	// a commits a change to main.go; b edits it uncommitted and adds new.go,
+	// which a also creates; c only touches README.md.
+	os.WriteFile(filepath.Join(a, "main.go"), []byte("package main\n\n// a\n"), 0644)
+	gitInWorkDir(a, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-qam", "a")
+	os.WriteFile(filepath.Join(a, "new.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(b, "main.go"), []byte("package main\n\n// b\n"), 0644)
+	os.WriteFile(filepath.Join(b, "new.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(c, "README.md"), []byte("docs\n"), 0644)
+
+	for _, s := range []*Session{
+		{UUID: "wt-a", Name: "A", BranchName: "fix-a", WorkDir: a, wsClients: map[*SafeConn]bool{}},
+		{UUID: "wt-b", Name: "B", BranchName: "fix-b", WorkDir: b, wsClients: map[*SafeConn]bool{}},
+		{UUID: "wt-c", Name: "C", BranchName: "docs", WorkDir: c, wsClients: map[*SafeConn]bool{}},
+		{UUID: "main", WorkDir: dir, wsClients: map[*SafeConn]bool{}},
+	} {
+		sessionsMu.Lock()
+		sessions[s.UUID] = s
+		sessionsMu.Unlock()
+		defer func(uuid string) {
+			sessionsMu.Lock()
+			delete(sessions, uuid)
+			sessionsMu.Unlock()
+		}(s.UUID)
+	}
+
+	rec := httptest.NewRecorder()
+	handleWorktreeConflictsAPI(rec, httptest.NewRequest("GET", "/api/worktrees/conflicts?refresh=1", nil))
+	var resp struct {
+		Conflicts []worktreeConflict `json:"conflicts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %s: %v", rec.Body, err)
+	}
+	if len(resp.Conflicts) != 1 {
+		t.Fatalf("conflicts = %+v", resp.Conflicts)
+	}
+	got := resp.Conflicts[0]
+	if !reflect.DeepEqual(got.Sessions, []string{"wt-a", "wt-b"}) || !reflect.DeepEqual(got.Files, []string{"main.go", "new.go"}) {
+		t.Errorf("conflict = %+v", got)
+	}
+
+	overlaps := sessionWorktreeConflicts("wt-b")
+	if len(overlaps) != 1 || overlaps[0].SessionUUID != "wt-a" || overlaps[0].Branch != "fix-a" {
+		t.Errorf("wt-b overlaps = %+v", overlaps)
+	}
+	if sessionWorktreeConflicts("wt-c") != nil {
+		t.Errorf("wt-c overlaps = %+v", sessionWorktreeConflicts("wt-c"))
+	}
+	if _, ok := sessions["wt-a"].buildStatusPayload(0, 24, 80)["worktreeConflicts"]; !ok {
+		t.Error("status payload has no worktreeConflicts")
+	}
+
+	// Once b reverts, the overlap clears.
+	gitInWorkDir(b, "checkout", "--", "main.go")
+	os.Remove(filepath.Join(b, "new.go"))
+	analyzeWorktreeConflicts()
+	if o := sessionWorktreeConflicts("wt-a"); o != nil {
+		t.Errorf("wt-a overlaps after revert = %+v", o)
+	}
+}
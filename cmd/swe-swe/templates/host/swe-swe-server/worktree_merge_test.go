@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mergeTestWorktree creates a worktree for branch under the policy roots
+// with one commit changing main.go to content, and returns the main checkout
+// and the worktree.
+func mergeTestWorktree(t *testing.T, branch, content string) (repo, worktree string) {
+	t.Helper()
+	setPathPolicyRoots(t)
+	repo, run := gitTestRepo(t)
+	t.Setenv("GIT_AUTHOR_NAME", "t")
+	t.Setenv("GIT_AUTHOR_EMAIL", "t@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "t")
+	t.Setenv("GIT_COMMITTER_EMAIL", "t@example.com")
+	worktree = filepath.Join(worktreeDir, worktreeDirName(branch))
+	run("worktree", "add", "-q", "-b", branch, worktree)
+	os.WriteFile(filepath.Join(worktree, "main.go"), []byte(content), 0o644)
+	if err := gitMergeStep(worktree, "commit", "-qam", "change main.go"); err != nil {
+		t.Fatal(err)
+	}
+	return repo, worktree
+}
+
+func postMerge(t *testing.T, branch, body string) (int, mergeResult, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleWorktreeMergeAPI(rec, httptest.NewRequest(http.MethodPost, "/api/worktree/"+branch+"/merge", strings.NewReader(body)))
+	var res mergeResult
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	return rec.Code, res, rec.Body.String()
+}
+
+func TestWorktreeMergeStrategies(t *testing.T) {
+	for _, strategy := range []string{"merge", "squash", "rebase"} {
+		t.Run(strategy, func(t *testing.T) {
+			repo, wt := mergeTestWorktree(t, "fix/login", "package main\n\n// fixed\n")
+			// Move the target on, so merge and rebase cannot just fast-forward.
+			os.WriteFile(filepath.Join(repo, "README.md"), []byte("moved on\n"), 0o644)
+			gitMergeStep(repo, "commit", "-qam", "readme")
+
+			code, res, body := postMerge(t, "fix/login", `{"strategy": "`+strategy+`", "deleteAfter": true}`)
+			if code != http.StatusOK || !res.Merged || !res.Deleted || res.TargetBranch == "" || res.Commit != gitHeadCommit(repo) {
+				t.Fatalf("%d %s", code, body)
+			}
+			if got, _ := os.ReadFile(filepath.Join(repo, "main.go")); string(got) != "package main\n\n// fixed\n" {
+				t.Errorf("main.go after merge = %q", got)
+			}
+			if _, err := os.Stat(wt); !os.IsNotExist(err) {
+				t.Error("worktree not removed")
+			}
+			if _, err := gitInWorkDir(repo, "rev-parse", "--verify", "-q", "refs/heads/fix/login"); err == nil {
+				t.Error("branch not deleted")
+			}
+		})
+	}
+}
+
+func TestWorktreeMergeConflict(t *testing.T) {
+	repo, wt := mergeTestWorktree(t, "feat/x", "package main\n\n// ours\n")
+	os.WriteFile(filepath.Join(repo, "main.go"), []byte("package main\n\n// theirs\n"), 0o644)
+	gitMergeStep(repo, "commit", "-qam", "conflicting change")
+	head := gitHeadCommit(repo)
+
+	for _, strategy := range []string{"merge", "squash", "rebase"} {
+		code, res, body := postMerge(t, "feat/x", `{"strategy": "`+strategy+`", "deleteAfter": true}`)
+		if code != http.StatusConflict || res.Merged || len(res.Conflicts) != 1 || res.Conflicts[0] != (mergeConflictFile{"main.go", "both modified"}) {
+			t.Errorf("%s: %d %s", strategy, code, body)
+		}
+		// Both checkouts are left as they were.
+		if dirty, _ := hasTrackedChanges(repo); dirty || gitHeadCommit(repo) != head {
+			t.Errorf("%s: main checkout changed", strategy)
+		}
+		if dirty, _ := hasTrackedChanges(wt); dirty {
+			t.Errorf("%s: worktree left dirty", strategy)
+		}
+	}
+	if _, err := os.Stat(wt); err != nil {
+		t.Error("worktree removed after a failed merge")
+	}
+}
+
+func TestWorktreeMergeRefusals(t *testing.T) {
+	repo, wt := mergeTestWorktree(t, "wip", "package main\n\n// wip\n")
+	if code, _, _ := postMerge(t, "wip", `{"strategy": "octopus"}`); code != http.StatusBadRequest {
+		t.Errorf("bad strategy = %d", code)
+	}
+	if code, _, _ := postMerge(t, "nope", ""); code != http.StatusConflict {
+		t.Errorf("missing worktree = %d", code)
+	}
+	os.WriteFile(filepath.Join(wt, "main.go"), []byte("uncommitted\n"), 0o644)
+	if code, _, body := postMerge(t, "wip", ""); code != http.StatusConflict || !strings.Contains(body, "uncommitted") {
+		t.Errorf("dirty worktree = %d %s", code, body)
+	}
+	gitMergeStep(wt, "checkout", "--", "main.go")
+
+	// A live session or an untracked file keeps the worktree, with a warning.
+	os.WriteFile(filepath.Join(wt, "notes.txt"), []byte("keep me\n"), 0o644)
+	code, res, body := postMerge(t, "wip", `{"deleteAfter": true}`)
+	if code != http.StatusOK || !res.Merged || res.Deleted || len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "notes.txt") {
+		t.Errorf("untracked file = %d %s", code, body)
+	}
+	if _, err := os.Stat(filepath.Join(wt, "notes.txt")); err != nil {
+		t.Error("untracked file lost")
+	}
+
+	// Already merged: nothing to do.
+	os.Remove(filepath.Join(wt, "notes.txt"))
+	if code, res, body := postMerge(t, "wip", ""); code != http.StatusOK || !res.UpToDate || res.Commit != gitHeadCommit(repo) {
+		t.Errorf("already merged = %d %s", code, body)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// publishTestWorktree creates a worktree of a repo with a bare origin under
+// the policy roots and returns it and the origin.
+func publishTestWorktree(t *testing.T, branch string) (worktree, origin string) {
+	t.Helper()
+	base := setPathPolicyRoots(t)
+	_, run := gitTestRepo(t)
+	origin = filepath.Join(base, "origin.git")
+	run("init", "-q", "--bare", origin)
+	run("remote", "add", "origin", origin)
+	worktree = filepath.Join(worktreeDir, worktreeDirName(branch))
+	run("worktree", "add", "-q", "-b", branch, worktree)
+	return worktree, origin
+}
+
+// publishEvents POSTs to the publish API and returns the NDJSON lines.
+func publishEvents(t *testing.T, branch, body string) (int, []map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/worktree/"+branch+"/publish", strings.NewReader(body))
+	handleWorktreePublishAPI(rec, req)
+	var events []map[string]any
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		var ev map[string]any
+		if json.Unmarshal(sc.Bytes(), &ev) == nil {
+			events = append(events, ev)
+		}
+	}
+	return rec.Code, events
+}
+
+func remoteHasBranch(origin, branch string) bool {
+	return exec.Command("git", "--git-dir", origin, "rev-parse", "--verify", "-q", "refs/heads/"+branch).Run() == nil
+}
+
+func TestWorktreePublishRefusesOnFailedCheck(t *testing.T) {
+	wt, origin := publishTestWorktree(t, "fix/login")
+	os.MkdirAll(filepath.Join(wt, ".swe-swe"), 0o755)
+	os.WriteFile(filepath.Join(wt, ".swe-swe", "env"), []byte("SWE_PUBLISH_BUILD=echo building\nSWE_PUBLISH_LINT=echo 'lint: bad' && exit 2\n"), 0o644)
+
+	code, events := publishEvents(t, "fix/login", "")
+	if code != http.StatusOK || len(events) == 0 {
+		t.Fatalf("status %d, events %v", code, events)
+	}
+	var checks []string
+	for _, ev := range events {
+		if ev["type"] == "check_result" {
+			checks = append(checks, ev["name"].(string)+"="+map[bool]string{true: "ok", false: "fail"}[ev["passed"].(bool)])
+		}
+	}
+	if strings.Join(checks, ",") != "build=ok,lint=fail" {
+		t.Errorf("checks = %v", checks)
+	}
+	done := events[len(events)-1]
+	if done["type"] != "done" || done["pushed"] != false || done["refused"] != true {
+		t.Errorf("done = %v", done)
+	}
+	if remoteHasBranch(origin, "fix/login") {
+		t.Error("branch pushed despite a failed check")
+	}
+
+	// force pushes anyway, with a warning.
+	_, events = publishEvents(t, "fix/login", `{"force": true}`)
+	done = events[len(events)-1]
+	if done["pushed"] != true || len(done["warnings"].([]any)) != 1 {
+		t.Errorf("forced done = %v", done)
+	}
+	if !remoteHasBranch(origin, "fix/login") {
+		t.Error("forced publish did not push")
+	}
+}
+
+func TestWorktreePublishPushesWhenChecksPass(t *testing.T) {
+	wt, origin := publishTestWorktree(t, "feature")
+	os.MkdirAll(filepath.Join(wt, ".swe-swe"), 0o755)
+	os.WriteFile(filepath.Join(wt, ".swe-swe", "env"), []byte("SWE_TEST_CMD=true\n"), 0o644)
+
+	_, events := publishEvents(t, "feature", "")
+	var names []string
+	for _, ev := range events {
+		if ev["type"] == "check" {
+			names = append(names, ev["name"].(string))
+		}
+	}
+	if strings.Join(names, ",") != "test" {
+		t.Errorf("checks run = %v (SWE_TEST_CMD is the test check)", names)
+	}
+	if done := events[len(events)-1]; done["pushed"] != true {
+		t.Errorf("done = %v", done)
+	}
+	if !remoteHasBranch(origin, "feature") {
+		t.Error("branch not pushed")
+	}
+}
+
+func TestWorktreePublishBadRequests(t *testing.T) {
+	publishTestWorktree(t, "feature")
+	for _, tc := range []struct {
+		branch, body string
+		want         int
+	}{
+		{"..bad", "", http.StatusBadRequest},
+		{"missing", "", http.StatusConflict},
+		{"other", `{"path": "` + filepath.Join(worktreeDir, "feature") + `"}`, http.StatusConflict},
+		{"feature", `{"path": "/etc"}`, http.StatusConflict},
+	} {
+		if code, _ := publishEvents(t, tc.branch, tc.body); code != tc.want {
+			t.Errorf("%s %s: status %d, want %d", tc.branch, tc.body, code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNewWSError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		code      string
+		retryable bool
+	}{
+		{fmt.Errorf("%w: nope", errUnknownAssistant), wsErrAssistantUnknown, false},
+		{fmt.Errorf("%w in the preview pool 3000-3019 (20 in use)", errPortsExhausted), wsErrPortExhausted, true},
+		{&sessionLimitError{Scope: "global", Limit: 2, Active: 2}, wsErrSessionLimit, true},
+		{fmt.Errorf("%w: largest session uses 2 GB", errInsufficientMemory), wsErrMemoryLow, true},
+		{errServerRestarting, wsErrRestarting, true},
+		{fmt.Errorf("worktree for branch %q: %w", "main",
+			fmt.Errorf("failed to create worktree: %w", fmt.Errorf("%w: %w", errWorktreeConflict, errors.New("exit status 128")))),
+			wsErrWorktreeConflict, false},
+		{errors.New("path outside the workspace"), wsErrSessionFailed, false},
+	} {
+		e := newWSError(tc.err)
+		if e.Type != "session_error" || e.Code != tc.code || e.Retryable != tc.retryable || e.Message != tc.err.Error() {
+			t.Errorf("%v: %+v", tc.err, e)
+		}
+		if tc.code == wsErrSessionLimit && e.Limit == nil {
+			t.Errorf("session_limit without limit")
+		}
+	}
+
+	if !worktreeConflictOutput("fatal: 'main' is already checked out at '/workspace'") ||
+		worktreeConflictOutput("fatal: invalid reference: nope") {
+		t.Error("worktreeConflictOutput")
+	}
+}
+
+func TestWSAuthRequired(t *testing.T) {
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("reached the handler without a cookie")
+	}), "master")
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/abc?assistant=claude", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var e wsError
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != wsErrAuthRequired || e.Login != "/swe-swe-auth/login?redirect=%2Fsession%2Fabc%3Fassistant%3Dclaude" {
+		t.Errorf("error = %+v", e)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, 4002) {
+		t.Errorf("close = %v", err)
+	}
+
+	// Anything else still redirects.
+	resp, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}).Get(srv.URL + "/ws/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("plain GET: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWSHeartbeatDropsSilentClients(t *testing.T) {
+	oldPing, oldWait := wsPingInterval, wsPongWait
+	wsPingInterval, wsPongWait = 30*time.Millisecond, 150*time.Millisecond
+	defer func() { wsPingInterval, wsPongWait = oldPing, oldWait }()
+
+	sess := &Session{
+		UUID:          "heartbeat",
+		wsClients:     make(map[*SafeConn]bool),
+		wsClientSizes: make(map[*SafeConn]TermSize),
+	}
+	upgrader := websocket.Upgrader{}
+	// Handlers read the intervals; restore them only after every handler
+	// has returned.
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := NewSafeConn(ws)
+		defer conn.Close()
+		sess.AddClient(conn)
+		defer sess.RemoveClient(conn)
+		defer startWSHeartbeat(conn)()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			conn.extendReadDeadline()
+		}
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// alive reads, so it answers pings with pongs; silent never reads.
+	alive, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alive.Close()
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	silent, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	clients := func() int {
+		sess.mu.RLock()
+		defer sess.mu.RUnlock()
+		return len(sess.wsClients)
+	}
+	for deadline := time.Now().Add(time.Second); clients() != 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+	}
+	if n := clients(); n != 2 {
+		t.Fatalf("%d clients connected, want 2", n)
+	}
+	for deadline := time.Now().Add(2 * time.Second); clients() != 1 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+	}
+	if n := clients(); n != 1 {
+		t.Fatalf("%d clients after the pong deadline, want 1", n)
+	}
+	// The live client outlasts several deadlines.
+	time.Sleep(3 * wsPongWait)
+	if n := clients(); n != 1 {
+		t.Errorf("%d clients, want the live one to stay", n)
+	}
+}
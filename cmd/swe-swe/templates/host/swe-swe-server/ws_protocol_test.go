@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/choonkeat/swe-swe/internal/wsproto"
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckWSProtocol(t *testing.T) {
+	for proto, ok := range map[string]bool{
+		"":  true,
+		"1": true,
+		"0": false,
+		"2": false,
+		"x": false,
+	} {
+		if wsErr := checkWSProtocol(proto); (wsErr == nil) != ok {
+			t.Errorf("proto %q: %+v", proto, wsErr)
+		} else if wsErr != nil && wsErr.Code != wsErrProtocolMismatch {
+			t.Errorf("proto %q: code %s", proto, wsErr.Code)
+		}
+	}
+}
+
+func TestWSProtocolHandshake(t *testing.T) {
+	withTempRecordingsDir(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, strings.TrimPrefix(r.URL.Path, "/ws/"))
+	}))
+	defer srv.Close()
+
+	// first dials the session WebSocket and returns the first two frames' types.
+	first := func(query string) []string {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/no-such-session?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var types []string
+		for len(types) < 2 {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var msg struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Version int    `json:"version"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				break
+			}
+			if msg.Type == "protocol" && msg.Version != wsproto.Version {
+				t.Errorf("hello version %d", msg.Version)
+			}
+			types = append(types, strings.TrimSuffix(msg.Type+" "+msg.Code, " "))
+		}
+		return types
+	}
+
+	if got := first("assistant=claude&proto=1"); strings.Join(got, ",") != "protocol,session_gone" {
+		t.Errorf("proto=1: %q", got)
+	}
+	if got := first("assistant=claude"); strings.Join(got, ",") != "protocol,session_gone" {
+		t.Errorf("no proto: %q", got)
+	}
+	if got := first("assistant=claude&proto=99"); strings.Join(got, ",") != "session_error protocol_mismatch" {
+		t.Errorf("proto=99: %q", got)
+	}
+}
@@ -0,0 +1,63 @@
+// ws_heartbeat.go -- detect dead terminal WebSocket connections.
+//
+// A browser that vanishes without a close frame (sleep, network drop, crash)
+// leaves a half-open TCP connection that would otherwise sit in wsClients
+// forever, inflating the viewer count and pinning the PTY to its stale size.
+// Each connection is pinged every wsPingInterval; every pong or message moves
+// its read deadline to wsPongWait from now. A connection silent past that
+// fails its read, which ends handleWebSocket and removes the client
+// (RemoveClient recalculates the PTY size from the clients left). Writes
+// have a deadline too, so a dead peer cannot stall broadcasts.
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// wsPingInterval is how often each terminal WebSocket is pinged.
+	wsPingInterval = 30 * time.Second
+	// wsPongWait is how long a connection may stay silent (no pong, no
+	// message) before it is considered dead: two missed pings plus slack.
+	wsPongWait = 2*wsPingInterval + 15*time.Second
+	// wsWriteTimeout bounds a single write to a client.
+	wsWriteTimeout = 30 * time.Second
+)
+
+// extendReadDeadline pushes the read deadline wsPongWait into the future.
+func (sc *SafeConn) extendReadDeadline() {
+	sc.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+}
+
+// startWSHeartbeat arms conn's read deadline, extends it on every pong, and
+// pings conn every wsPingInterval until the returned stop is called. Call it
+// just before the read loop: pongs are only processed while reading.
+func startWSHeartbeat(conn *SafeConn) (stop func()) {
+	conn.extendReadDeadline()
+	conn.conn.SetPongHandler(func(string) error {
+		conn.extendReadDeadline()
+		return nil
+	})
+	done := make(chan struct{})
+	go func() {
+		defer recoverGoroutine("WebSocket heartbeat")
+		t := time.NewTicker(wsPingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				// WriteControl may run concurrently with the other writers.
+				if err := conn.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
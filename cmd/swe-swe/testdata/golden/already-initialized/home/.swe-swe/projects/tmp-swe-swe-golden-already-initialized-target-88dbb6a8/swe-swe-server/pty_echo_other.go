@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// ptyEchoOff is only implemented on Linux. Elsewhere password input is still
+// masked when the output before it looks like a password prompt
+// (input_redact.go).
+func ptyEchoOff(f *os.File) bool {
+	return false
+}
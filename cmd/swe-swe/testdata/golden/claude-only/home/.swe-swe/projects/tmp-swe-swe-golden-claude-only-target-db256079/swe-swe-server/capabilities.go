@@ -0,0 +1,127 @@
+// capabilities.go -- GET /api/capabilities: what this server lets the
+// caller do.
+//
+// Much of the UI only works on some deployments or for some users: the
+// Files tab needs the per-port proxies (proxy_mode.go), worktrees need git,
+// shutting the server down needs the admin permission (auth_roles.go). The
+// page used to find out by trying and getting a 403 or 404. It can ask
+// first instead:
+//
+//	{"version": "2.14.0", "auth": {"mode": "users", "user": "bob", "role": "developer",
+//	 "permissions": ["input", "yolo"]}, "preview": {"proxyMode": "ports", "proxyPorts": true},
+//	 "recordings": {"enabled": true}, "worktrees": {"available": true},
+//	 "yolo": {"allowed": true}, "uploads": {"allowed": true, "maxBytes": 0},
+//	 "agentView": true, "filesTab": true}
+//
+// Permissions are the caller's, so two users get different answers. It
+// reports what the server would allow, not what a particular session
+// supports: whether a session's assistant has a YOLO mode is in that
+// session's status message (yoloSupported). uploads.maxBytes is 0 because
+// the server sets no size limit on uploads; a proxy in front of it may.
+// Shared-session guests are confined to their session and get 403 here.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// serverCapabilities is the /api/capabilities response.
+type serverCapabilities struct {
+	Version string `json:"version"`
+	Auth    struct {
+		Mode              string   `json:"mode"` // none, password or users
+		User              string   `json:"user,omitempty"`
+		Role              string   `json:"role"`
+		Permissions       []string `json:"permissions"`
+		TailscaleIdentity bool     `json:"tailscaleIdentity,omitempty"`
+	} `json:"auth"`
+	Preview struct {
+		ProxyMode   string `json:"proxyMode"`
+		ProxyPorts  bool   `json:"proxyPorts"` // per-session proxy listeners
+		Domain      string `json:"domain,omitempty"`
+		VhostSuffix string `json:"vhostSuffix,omitempty"`
+	} `json:"preview"`
+	Recordings struct {
+		Enabled bool `json:"enabled"`
+	} `json:"recordings"`
+	Worktrees struct {
+		Available bool   `json:"available"`
+		Reason    string `json:"reason,omitempty"`
+	} `json:"worktrees"`
+	Yolo struct {
+		Allowed bool `json:"allowed"`
+	} `json:"yolo"`
+	Uploads struct {
+		Allowed  bool  `json:"allowed"`
+		MaxBytes int64 `json:"maxBytes"` // 0: no limit
+	} `json:"uploads"`
+	AgentView bool `json:"agentView"`
+	FilesTab  bool `json:"filesTab"`
+}
+
+// capabilitiesFor builds the capabilities r's caller has.
+func capabilitiesFor(r *http.Request) serverCapabilities {
+	var c serverCapabilities
+	c.Version = Version
+
+	id := requestIdentity(r)
+	switch {
+	case os.Getenv("SWE_SWE_PASSWORD") == "":
+		c.Auth.Mode = "none"
+	case len(authUsers) > 0:
+		c.Auth.Mode = "users"
+		c.Auth.User = id.User
+	default:
+		c.Auth.Mode = "password"
+	}
+	c.Auth.Role = id.Role
+	c.Auth.Permissions = []string{}
+	for _, perm := range allPerms {
+		if id.can(perm) {
+			c.Auth.Permissions = append(c.Auth.Permissions, perm)
+		}
+	}
+	c.Auth.TailscaleIdentity = os.Getenv("SWE_TAILSCALE_IDENTITY") == "1"
+
+	c.Preview.ProxyMode = proxyMode
+	c.Preview.ProxyPorts = !pathProxyMode()
+	c.Preview.Domain = previewDomain
+	c.Preview.VhostSuffix = previewVhostSuffix()
+
+	c.Recordings.Enabled = dirWritable(recordingsDir)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Worktrees.Reason = "git is not installed"
+	} else {
+		c.Worktrees.Available = true
+	}
+
+	c.Yolo.Allowed = id.can(permYolo)
+	c.Uploads.Allowed = id.can(permInput)
+
+	// The Files tab and Agent View have no path-based route (proxy_mode.go).
+	c.AgentView = agentViewAvailable() && !pathProxyMode()
+	c.FilesTab = !pathProxyMode()
+	return c
+}
+
+// dirWritable reports whether dir is a directory this process can create
+// files in.
+func dirWritable(dir string) bool {
+	fi, err := os.Stat(dir)
+	return err == nil && fi.IsDir() && syscall.Access(dir, 2 /* W_OK */) == nil
+}
+
+func handleCapabilitiesAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(capabilitiesFor(r))
+}
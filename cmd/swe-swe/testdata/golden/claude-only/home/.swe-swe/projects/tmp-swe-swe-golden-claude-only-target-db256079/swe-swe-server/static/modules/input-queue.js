@@ -0,0 +1,130 @@
+/**
+ * Pure sequenced-input queue state functions.
+ * Each input frame gets a sequence number and stays pending until the server
+ * acknowledges it, so frames lost with a dropped connection can be resent
+ * after a reconnect (see input_resume.go for the server side).
+ * @module input-queue
+ */
+
+import { encodeSequencedInput } from './messages.js';
+
+/**
+ * Most pending bytes kept when persisting the queue (sessionStorage quota).
+ */
+export const INPUT_QUEUE_PERSIST_MAX = 1024 * 1024;
+
+/**
+ * Create an empty queue for a stream id.
+ * @param {string} id - Input stream id (sent as ?input= on connect)
+ * @returns {{id: string, nextSeq: number, pending: Array<{seq: number, frame: Uint8Array}>}} Initial state
+ */
+export function createInputQueue(id) {
+    return { id, nextSeq: 1, pending: [] };
+}
+
+/**
+ * Add a frame to the queue.
+ * @param {{id: string, nextSeq: number, pending: Array}} state - Current state
+ * @param {Uint8Array} frame - Input frame to send
+ * @returns {{state: object, message: Uint8Array}} New state and the message to send
+ */
+export function pushInput(state, frame) {
+    const seq = state.nextSeq;
+    return {
+        state: { ...state, nextSeq: seq + 1, pending: [...state.pending, { seq, frame }] },
+        message: encodeSequencedInput(seq, frame)
+    };
+}
+
+/**
+ * Drop frames the server has acknowledged.
+ * @param {{id: string, nextSeq: number, pending: Array}} state - Current state
+ * @param {number} seq - Highest acknowledged sequence number
+ * @returns {{id: string, nextSeq: number, pending: Array}} New state
+ */
+export function ackInput(state, seq) {
+    if (state.pending.length === 0 || state.pending[0].seq > seq) {
+        return state;
+    }
+    return { ...state, pending: state.pending.filter(p => p.seq > seq) };
+}
+
+/**
+ * Apply the server's input_resume position after (re)connecting: drop what
+ * it already applied and return the rest to resend, in order.
+ * @param {{id: string, nextSeq: number, pending: Array}} state - Current state
+ * @param {number} seq - Last sequence number the server applied
+ * @returns {{state: object, messages: Uint8Array[]}} New state and messages to resend
+ */
+export function resumeInput(state, seq) {
+    const acked = ackInput(state, seq);
+    return {
+        state: { ...acked, nextSeq: Math.max(acked.nextSeq, seq + 1) },
+        messages: acked.pending.map(p => encodeSequencedInput(p.seq, p.frame))
+    };
+}
+
+/**
+ * Count the bytes of pending frames.
+ * @param {{pending: Array}} state - Current state
+ * @returns {number} Pending bytes
+ */
+export function pendingBytes(state) {
+    return state.pending.reduce((n, p) => n + p.frame.length, 0);
+}
+
+function toBase64(bytes) {
+    let s = '';
+    for (let i = 0; i < bytes.length; i += 0x8000) {
+        s += String.fromCharCode.apply(null, bytes.subarray(i, i + 0x8000));
+    }
+    return btoa(s);
+}
+
+function fromBase64(str) {
+    const s = atob(str);
+    const bytes = new Uint8Array(s.length);
+    for (let i = 0; i < s.length; i++) {
+        bytes[i] = s.charCodeAt(i);
+    }
+    return bytes;
+}
+
+/**
+ * Serialize the queue for sessionStorage. Pending frames beyond maxBytes
+ * (oldest first) are left out.
+ * @param {{id: string, nextSeq: number, pending: Array}} state - Current state
+ * @param {number} maxBytes - Most pending bytes to keep
+ * @returns {string} JSON string
+ */
+export function serializeInputQueue(state, maxBytes = INPUT_QUEUE_PERSIST_MAX) {
+    const pending = [];
+    let bytes = 0;
+    for (const p of state.pending) {
+        bytes += p.frame.length;
+        if (bytes > maxBytes) break;
+        pending.push({ seq: p.seq, frame: toBase64(p.frame) });
+    }
+    return JSON.stringify({ id: state.id, nextSeq: state.nextSeq, pending });
+}
+
+/**
+ * Restore a queue saved by serializeInputQueue.
+ * @param {string|null} str - JSON string
+ * @returns {{id: string, nextSeq: number, pending: Array}|null} State, or null if invalid
+ */
+export function deserializeInputQueue(str) {
+    try {
+        const v = JSON.parse(str);
+        if (!v || typeof v.id !== 'string' || !v.id || !Number.isInteger(v.nextSeq) || !Array.isArray(v.pending)) {
+            return null;
+        }
+        return {
+            id: v.id,
+            nextSeq: v.nextSeq,
+            pending: v.pending.map(p => ({ seq: p.seq, frame: fromBase64(p.frame) }))
+        };
+    } catch (e) {
+        return null;
+    }
+}
@@ -0,0 +1,245 @@
+// agent_chat_sidecar.go -- health of a chat session's agent-chat server, and
+// optionally running it.
+//
+// Every chat session is allocated an AGENT_CHAT_PORT that the Agent Chat tab
+// is proxied to, but by default nothing in swe-swe-server listens there: the
+// agent's MCP client launches agent-chat, which binds the port. When it never
+// does (the MCP server failed to start, or the assistant has no MCP config),
+// the tab used to spin until its probe gave up. agentChatSidecar watches the
+// port for each chat session and reports "listening", "starting" or
+// "no-listener" in the session status frame, so the page can say what is
+// wrong.
+//
+// With SWE_AGENT_CHAT_CMD set, swe-swe-server also owns the server: it starts
+// that command (via sh -c, in the session's working directory and env, so
+// $AGENT_CHAT_PORT is available) when the chat session starts, restarts it
+// when it exits or stops listening, and kills its process group when the
+// session ends. It then outlives agent restarts. Use it for assistants that
+// do not launch agent-chat themselves; an agent that also launches one would
+// find the port taken. MCP-less mode already launches agent-chat in its
+// fleet (mcp_less.go), so the command is not run there.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	agentChatListening  = "listening"
+	agentChatStarting   = "starting"
+	agentChatNoListener = "no-listener"
+)
+
+var (
+	// agentChatCommand is SWE_AGENT_CHAT_CMD; "" leaves agent-chat to the agent.
+	agentChatCommand string
+
+	// agentChatCheckInterval is how often the port is probed.
+	agentChatCheckInterval = 3 * time.Second
+	// agentChatStartGrace is how long a managed server may take to listen
+	// before it is restarted.
+	agentChatStartGrace = 60 * time.Second
+	// agentChatMaxBackoff caps the delay between restarts of a server that
+	// keeps exiting.
+	agentChatMaxBackoff = 30 * time.Second
+)
+
+// loadAgentChatCommand reads SWE_AGENT_CHAT_CMD.
+func loadAgentChatCommand() {
+	agentChatCommand = strings.TrimSpace(os.Getenv("SWE_AGENT_CHAT_CMD"))
+	if agentChatCommand != "" {
+		log.Printf("Agent chat server managed by swe-swe-server: %s", agentChatCommand)
+	}
+}
+
+// agentChatSidecar tracks one chat session's agent-chat server.
+type agentChatSidecar struct {
+	sessionUUID string
+	port        int
+	command     string // "" = only watch the port
+	dir         string
+	env         []string
+
+	mu    sync.Mutex
+	state string
+}
+
+func newAgentChatSidecar(sessionUUID string, port int, command, dir string, env []string) *agentChatSidecar {
+	state := agentChatNoListener
+	if command != "" {
+		state = agentChatStarting
+	}
+	return &agentChatSidecar{sessionUUID: sessionUUID, port: port, command: command, dir: dir, env: env, state: state}
+}
+
+// Status returns the last observed state.
+func (a *agentChatSidecar) Status() string {
+	if a == nil {
+		return ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// setState records state and reports whether it changed.
+func (a *agentChatSidecar) setState(state string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.state == state {
+		return false
+	}
+	a.state = state
+	return true
+}
+
+// listening reports whether something accepts connections on the port.
+func (a *agentChatSidecar) listening() bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", a.port), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Start watches the port (and runs the managed server) until ctx is done,
+// calling onChange after every state change.
+func (a *agentChatSidecar) Start(ctx context.Context, onChange func()) {
+	go func() {
+		defer recoverGoroutine(fmt.Sprintf("agent-chat sidecar for session %s", a.sessionUUID))
+		if a.command == "" {
+			a.watch(ctx, onChange)
+		} else {
+			a.supervise(ctx, onChange)
+		}
+	}()
+}
+
+// watch only reports whether the port has a listener.
+func (a *agentChatSidecar) watch(ctx context.Context, onChange func()) {
+	ticker := time.NewTicker(agentChatCheckInterval)
+	defer ticker.Stop()
+	for {
+		state := agentChatNoListener
+		if a.listening() {
+			state = agentChatListening
+		}
+		if a.setState(state) {
+			onChange()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// supervise runs the managed server, restarting it with backoff when it
+// exits and killing it when it has not listened for agentChatStartGrace.
+func (a *agentChatSidecar) supervise(ctx context.Context, onChange func()) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		a.runOnce(ctx, onChange)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > agentChatStartGrace {
+			backoff = time.Second
+		}
+		if a.setState(agentChatNoListener) {
+			onChange()
+		}
+		log.Printf("Session %s: restarting agent chat server in %s", a.sessionUUID, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > agentChatMaxBackoff {
+			backoff = agentChatMaxBackoff
+		}
+	}
+}
+
+// runOnce starts the server and returns when it exits, when it has not
+// listened in time (it is killed), or when ctx is done (it is killed).
+func (a *agentChatSidecar) runOnce(ctx context.Context, onChange func()) {
+	cmd := exec.Command("sh", "-c", a.command)
+	cmd.Dir = a.dir
+	cmd.Env = a.env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// A stdio MCP server exits on EOF; hold its stdin open while it runs.
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		log.Printf("Session %s: agent chat server: %v", a.sessionUUID, err)
+		return
+	}
+	defer stdinW.Close()
+	cmd.Stdin = stdinR
+	if a.setState(agentChatStarting) {
+		onChange()
+	}
+	err = cmd.Start()
+	stdinR.Close()
+	if err != nil {
+		log.Printf("Session %s: agent chat server failed to start: %v", a.sessionUUID, err)
+		return
+	}
+	pid := cmd.Process.Pid
+	trackPid(pid)
+	log.Printf("Session %s: started agent chat server (pid %d) on port %d", a.sessionUUID, pid, a.port)
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	kill := func(why string) {
+		log.Printf("Session %s: killing agent chat server (pid %d): %s", a.sessionUUID, pid, why)
+		syscall.Kill(-pid, syscall.SIGKILL)
+		<-done
+		untrackPid(pid)
+	}
+
+	lastUp := time.Now()
+	ticker := time.NewTicker(agentChatCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			kill("session ended")
+			return
+		case err := <-done:
+			untrackPid(pid)
+			log.Printf("Session %s: agent chat server (pid %d) exited: %v", a.sessionUUID, pid, err)
+			return
+		case <-ticker.C:
+		}
+		if a.listening() {
+			lastUp = time.Now()
+			if a.setState(agentChatListening) {
+				onChange()
+			}
+			continue
+		}
+		// Lost (or never had) the listener: it gets agentChatStartGrace from
+		// the last time it was listening.
+		if a.setState(agentChatStarting) {
+			onChange()
+		}
+		if time.Since(lastUp) > agentChatStartGrace {
+			kill(fmt.Sprintf("not listening on port %d after %s", a.port, agentChatStartGrace))
+			return
+		}
+	}
+}
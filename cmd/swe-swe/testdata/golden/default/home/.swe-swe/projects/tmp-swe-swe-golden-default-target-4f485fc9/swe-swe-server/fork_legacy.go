@@ -70,7 +70,7 @@ type hydratedForkSource struct {
 // metadata file is missing, malformed, or if no chat event log can be
 // located.
 func loadEndedForkSource(sourceUUID string) (*hydratedForkSource, error) {
-	metaPath := filepath.Join(recordingsDir, fmt.Sprintf("session-%s.metadata.json", sourceUUID))
+	metaPath := filepath.Join(recordingDir(sourceUUID), fmt.Sprintf("session-%s.metadata.json", sourceUUID))
 	data, err := os.ReadFile(metaPath)
 	if err != nil {
 		return nil, fmt.Errorf("read metadata %s: %w", metaPath, err)
@@ -114,7 +114,8 @@ func loadEndedForkSource(sourceUUID string) (*hydratedForkSource, error) {
 // to keep this resilient to older recordings that didn't track it.
 func findChatLogPathForSession(sourceUUID string) (string, error) {
 	prefix := fmt.Sprintf("session-%s-", sourceUUID)
-	entries, err := os.ReadDir(recordingsDir)
+	dir := recordingDir(sourceUUID)
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return "", fmt.Errorf("scan recordings dir: %w", err)
 	}
@@ -124,10 +125,10 @@ func findChatLogPathForSession(sourceUUID string) (string, error) {
 		}
 		name := e.Name()
 		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".events.jsonl") {
-			return filepath.Join(recordingsDir, name), nil
+			return filepath.Join(dir, name), nil
 		}
 	}
-	return "", fmt.Errorf("no chat events log for session %s in %s", sourceUUID, recordingsDir)
+	return "", fmt.Errorf("no chat events log for session %s in %s", sourceUUID, dir)
 }
 
 // fingerprintClaudeSessionByEvents recovers a Claude session id by matching
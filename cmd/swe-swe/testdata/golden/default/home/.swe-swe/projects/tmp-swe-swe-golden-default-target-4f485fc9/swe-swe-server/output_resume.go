@@ -0,0 +1,131 @@
+// output_resume.go -- catching a reconnecting client up on just the output it
+// missed.
+//
+// A client that reconnects normally gets the whole scrollback (ring buffer)
+// and a screen snapshot written on top of what it already shows, which loses
+// its scroll position -- every time a laptop sleeps. Instead, each connection
+// is given a resume token and the output offset it starts at:
+//
+//	{"type": "output_resume", "token": "9f2c...", "offset": 183422, "resumed": false}
+//
+// The offset counts every byte of terminal output the session ever produced
+// (Session.ring.Total); the client adds the length of each plain binary output
+// frame it receives. On reconnect it opens the WebSocket with
+// ?resume={token}&offset={n}. If the token is this session's, its connection
+// closed less than outputResumeWindow ago, and the ring buffer still holds
+// everything after n, the server answers with a new token, "resumed": true
+// and offset n, then sends only the bytes after n as plain binary frames --
+// no scrollback, no snapshot. Otherwise it falls back to the full replay, with
+// "resumed": false. A token is good for one resume; tokens live in memory, so
+// a server restart means a full replay.
+package main
+
+import (
+	crypto_rand "crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// outputResumeWindow is how long a resume token outlives its connection.
+const outputResumeWindow = 30 * time.Minute
+
+// outputResumeMaxTokens caps the resume tokens remembered per session.
+const outputResumeMaxTokens = 64
+
+// outputResumeToken is one connection's claim to resume.
+type outputResumeToken struct {
+	connected bool
+	closedAt  time.Time
+}
+
+// outputResumeState holds a session's resume tokens. The zero value is ready
+// to use.
+type outputResumeState struct {
+	mu     sync.Mutex
+	tokens map[string]*outputResumeToken
+}
+
+// issue returns a new token for a connection that is open now. Tokens past
+// the window are forgotten first.
+func (s *outputResumeState) issue(now time.Time) string {
+	b := make([]byte, 16)
+	crypto_rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = map[string]*outputResumeToken{}
+	}
+	oldest := ""
+	for k, t := range s.tokens {
+		if t.connected {
+			continue
+		}
+		if now.Sub(t.closedAt) > outputResumeWindow {
+			delete(s.tokens, k)
+		} else if oldest == "" || t.closedAt.Before(s.tokens[oldest].closedAt) {
+			oldest = k
+		}
+	}
+	if len(s.tokens) >= outputResumeMaxTokens {
+		delete(s.tokens, oldest)
+	}
+	s.tokens[token] = &outputResumeToken{connected: true}
+	return token
+}
+
+// take consumes token, reporting whether it may resume: known, its
+// connection closed, and within the window.
+func (s *outputResumeState) take(token string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tokens[token]
+	if t == nil || t.connected {
+		return false
+	}
+	delete(s.tokens, token)
+	return now.Sub(t.closedAt) <= outputResumeWindow
+}
+
+// closed records that token's connection closed.
+func (s *outputResumeState) closed(token string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.tokens[token]; t != nil {
+		t.connected, t.closedAt = false, now
+	}
+}
+
+// startOutputResume gives conn its resume token, resuming from the client's
+// ?resume and ?offset when it can. It reports whether it resumed -- the
+// missed output is then already sent and the full replay must be skipped --
+// and returns the token to close when conn ends.
+func (s *Session) startOutputResume(conn *SafeConn, resumeToken, offsetParam string) (resumed bool, token string) {
+	now := time.Now()
+	var missed []byte
+	s.vtMu.Lock()
+	offset := s.ring.Total()
+	if resumeToken != "" {
+		if n, err := strconv.ParseInt(offsetParam, 10, 64); err == nil && s.outputResume.take(resumeToken, now) {
+			missed, resumed = s.ring.Since(n)
+			if resumed {
+				offset = n
+			}
+		}
+	}
+	s.vtMu.Unlock()
+
+	token = s.outputResume.issue(now)
+	conn.WriteJSON(map[string]any{"type": "output_resume", "token": token, "offset": offset, "resumed": resumed})
+	for len(missed) > 0 {
+		n := min(len(missed), DefaultChunkSize)
+		conn.WriteMessage(websocket.BinaryMessage, missed[:n])
+		missed = missed[n:]
+	}
+	return resumed, token
+}
@@ -0,0 +1,164 @@
+// pathpolicy.go -- one place that decides whether a request-supplied path is
+// allowed.
+//
+// Handlers used to validate paths ad hoc: a strings.HasPrefix against
+// reposDir here, a filepath.Clean there (sometimes after the prefix check,
+// so "/repos/../etc" slipped through), nothing at all before ServeFile.
+// Every handler that takes a filesystem path from a request now goes through
+// a pathPolicy, which:
+//
+//  1. requires an absolute path and cleans it ("..", "//", trailing "/");
+//  2. checks the cleaned path against the policy's allowlisted roots, each of
+//     which admits the root itself, paths below it, or both;
+//  3. resolves symlinks (for the longest prefix that exists) and checks the
+//     result against the symlink-resolved roots, so a link planted inside an
+//     allowed root cannot point the server outside it.
+//
+// Resolve returns the cleaned path, not the symlink-resolved one: callers
+// compare it against workspaceDir and friends, which are configured paths.
+//
+// Roots are read at call time because workspaceDir, reposDir etc. are only
+// final after flag parsing.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errPathNotAllowed is wrapped by every pathPolicy rejection.
+var errPathNotAllowed = errors.New("path not allowed")
+
+// allowedRoot is one allowlisted directory.
+type allowedRoot struct {
+	Dir   string
+	Self  bool // Dir itself is allowed
+	Below bool // paths strictly below Dir are allowed
+}
+
+// pathPolicy is a named set of allowed roots.
+type pathPolicy struct {
+	name  string // for error messages, e.g. "repository path"
+	roots func() []allowedRoot
+}
+
+var (
+	// policyRepoPath: a repository a session or branch listing can be based
+	// on -- the workspace itself or a clone under the repos directory.
+	policyRepoPath = pathPolicy{"repository path", func() []allowedRoot {
+		return []allowedRoot{{Dir: workspaceDir, Self: true}, {Dir: reposDir, Below: true}}
+	}}
+	// policyClonedRepo: an existing clone under the repos directory.
+	policyClonedRepo = pathPolicy{"repository path", func() []allowedRoot {
+		return []allowedRoot{{Dir: reposDir, Below: true}}
+	}}
+	// policyWorkDir: anywhere a command may run -- the workspace and below,
+	// worktrees, and repos.
+	policyWorkDir = pathPolicy{"working directory", func() []allowedRoot {
+		return []allowedRoot{
+			{Dir: workspaceDir, Self: true, Below: true},
+			{Dir: worktreeDir, Below: true},
+			{Dir: reposDir, Below: true},
+		}
+	}}
+	// policyWorktree: a worktree of the default workspace.
+	policyWorktree = pathPolicy{"worktree path", func() []allowedRoot {
+		return []allowedRoot{{Dir: worktreeDir, Below: true}}
+	}}
+	// policyRecording: a file in a recordings directory -- the workspace's
+	// or a repo's (recording_dirs.go).
+	policyRecording = pathPolicy{"recording path", func() []allowedRoot {
+		var roots []allowedRoot
+		for _, dir := range recordingDirs() {
+			roots = append(roots, allowedRoot{Dir: dir, Below: true})
+		}
+		return roots
+	}}
+)
+
+// Resolve validates p against the policy and returns it cleaned. The error
+// wraps errPathNotAllowed and names the policy, not the roots.
+func (pp pathPolicy) Resolve(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("%w: empty %s", errPathNotAllowed, pp.name)
+	}
+	if !filepath.IsAbs(p) {
+		return "", fmt.Errorf("%w: %s must be absolute: %q", errPathNotAllowed, pp.name, p)
+	}
+	clean := filepath.Clean(p)
+	roots := pp.roots()
+	if !underRoots(clean, roots) {
+		return "", fmt.Errorf("%w: %s %q is outside the allowed directories", errPathNotAllowed, pp.name, p)
+	}
+
+	resolved := resolveExistingPrefix(clean)
+	resolvedRoots := make([]allowedRoot, len(roots))
+	for i, r := range roots {
+		r.Dir = resolveExistingPrefix(filepath.Clean(r.Dir))
+		resolvedRoots[i] = r
+	}
+	if !underRoots(resolved, resolvedRoots) {
+		return "", fmt.Errorf("%w: %s %q resolves outside the allowed directories", errPathNotAllowed, pp.name, p)
+	}
+	return clean, nil
+}
+
+// Allowed reports whether Resolve would accept p.
+func (pp pathPolicy) Allowed(p string) bool {
+	_, err := pp.Resolve(p)
+	return err == nil
+}
+
+// underRoots reports whether clean is admitted by any root.
+func underRoots(clean string, roots []allowedRoot) bool {
+	for _, r := range roots {
+		if r.Dir == "" {
+			continue
+		}
+		dir := filepath.Clean(r.Dir)
+		if r.Self && clean == dir {
+			return true
+		}
+		if r.Below && clean != dir && isPathBelow(clean, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPathBelow reports whether clean is strictly inside dir (both cleaned).
+func isPathBelow(clean, dir string) bool {
+	if dir == "/" {
+		return clean != "/"
+	}
+	return strings.HasPrefix(clean, dir+string(filepath.Separator))
+}
+
+// resolveExistingPrefix evaluates symlinks in the longest prefix of p that
+// exists and re-appends the rest, so not-yet-created paths (a clone target,
+// a worktree about to be added) can still be checked.
+func resolveExistingPrefix(p string) string {
+	var rest []string
+	cur := p
+	for {
+		if resolved, err := filepath.EvalSymlinks(cur); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		} else if !os.IsNotExist(err) {
+			// Permission errors and loops: judge the path as written.
+			return p
+		} else if _, lerr := os.Lstat(cur); lerr == nil {
+			// A dangling symlink: its target is unknown, so nothing below it
+			// can be admitted.
+			return ""
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return p
+		}
+		rest = append([]string{filepath.Base(cur)}, rest...)
+		cur = parent
+	}
+}
@@ -0,0 +1,315 @@
+// session_recorder.go -- the server-side PTY recorder, with pause/resume.
+//
+// Every byte the server reads from a session's PTY and every byte it writes
+// to it passes through a sessionRecorder, which writes the same three files
+// util-linux `script -T -I -O` used to:
+//
+//	{prefix}.log     output, between "Script started on" / "Script done on" lines
+//	{prefix}.input   input, with the same header
+//	{prefix}.timing  advanced timing: "O 0.1 12", "I 0.2 1", "S 0.3 SIGWINCH ..."
+//
+// so playback, chapters and annotations read a recording unchanged -- on
+// every platform, where BSD/macOS script could only record untimed output.
+// Passwords are masked in the .input file (input_redact.go), secrets in the
+// .log file (secrets.go).
+//
+// Recording can be paused, e.g. while pasting a credential:
+//
+//	{"type": "pause_recording"}    {"type": "resume_recording"}
+//	POST /api/session/{uuid}/recording/pause
+//	POST /api/session/{uuid}/recording/resume
+//
+// While paused nothing is written to any of the files. Resuming writes a
+// visible "[recording paused 00:12:31–00:13:02]" line into the output (offsets
+// from the start of the recording), so a viewer sees where the gap is. The
+// status message reports recordingPaused; a pause survives the agent being
+// restarted (YOLO toggle) and ends with the session.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// scriptTimeLayout is util-linux script's timestamp format in its headers.
+	scriptTimeLayout = "2006-01-02 15:04:05-07:00"
+	// recordingTerm is the TERM buildSessionEnv gives every session.
+	recordingTerm = "xterm-256color"
+)
+
+// sessionRecorder writes one process's PTY traffic to its recording files.
+// Safe for concurrent use.
+type sessionRecorder struct {
+	mu       sync.Mutex
+	log      *os.File
+	timing   *os.File
+	input    *os.File
+	start    time.Time // recording start; marker offsets count from here
+	last     time.Time // previous timing entry; delays count from here
+	pausedAt time.Time // zero while recording
+	closed   bool
+	redact   inputRedactor
+	secrets  secretRedactor
+	now      func() time.Time
+}
+
+// startSessionRecorder creates (truncating, as script did) the recording
+// files for prefix and writes their headers. A recorder started paused
+// records nothing until Resume.
+func startSessionRecorder(prefix, command string, rows, cols uint16, paused bool) (*sessionRecorder, error) {
+	return startSessionRecorderAt(prefix, command, rows, cols, paused, time.Now)
+}
+
+func startSessionRecorderAt(prefix, command string, rows, cols uint16, paused bool, now func() time.Time) (*sessionRecorder, error) {
+	base := recordingPath(prefix)
+	var files [3]*os.File
+	for i, ext := range []string{".log", ".timing", ".input"} {
+		f, err := os.OpenFile(base+ext, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			for _, opened := range files[:i] {
+				opened.Close()
+			}
+			return nil, err
+		}
+		files[i] = f
+	}
+	t := now()
+	r := &sessionRecorder{log: files[0], timing: files[1], input: files[2], start: t, last: t, now: now}
+	if paused {
+		r.pausedAt = t
+	}
+
+	header := fmt.Sprintf("Script started on %s [COMMAND=%q TERM=%q COLUMNS=\"%d\" LINES=\"%d\"]\n",
+		t.Format(scriptTimeLayout), maskSecrets(command), recordingTerm, cols, rows)
+	r.log.WriteString(header)
+	r.input.WriteString(header)
+	for _, h := range [][2]string{
+		{"START_TIME", t.Format(scriptTimeLayout)},
+		{"TERM", recordingTerm},
+		{"COLUMNS", fmt.Sprint(cols)},
+		{"LINES", fmt.Sprint(rows)},
+		{"COMMAND", command},
+		{"TIMING_LOG", base + ".timing"},
+		{"OUTPUT_LOG", base + ".log"},
+		{"INPUT_LOG", base + ".input"},
+	} {
+		fmt.Fprintf(r.timing, "H 0.000000 %s %s\n", h[0], h[1])
+	}
+	return r, nil
+}
+
+// delay returns the seconds since the previous timing entry and moves the
+// mark. Call with r.mu held.
+func (r *sessionRecorder) delay() float64 {
+	t := r.now()
+	d := t.Sub(r.last).Seconds()
+	r.last = t
+	return d
+}
+
+// Output records bytes read from the PTY.
+func (r *sessionRecorder) Output(p []byte) {
+	r.record('O', p, false)
+}
+
+// Input records bytes written to the PTY, masked if they answer a password
+// prompt.
+func (r *sessionRecorder) Input(p []byte) {
+	r.record('I', p, false)
+}
+
+// InputNoEcho records bytes written to the PTY while it was not echoing
+// them, masked.
+func (r *sessionRecorder) InputNoEcho(p []byte) {
+	r.record('I', p, true)
+}
+
+func (r *sessionRecorder) record(typ byte, p []byte, noEcho bool) {
+	if r == nil || len(p) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || !r.pausedAt.IsZero() {
+		return
+	}
+	fmt.Fprintf(r.timing, "%c %.6f %d\n", typ, r.delay(), len(p))
+	if typ == 'I' {
+		r.input.Write(r.redact.input(p, noEcho))
+	} else {
+		r.redact.output(p)
+		masked, tail := r.secrets.output(p)
+		if tail != nil {
+			// A secret began in the previous write: mask that end too.
+			if pos, err := r.log.Seek(0, io.SeekCurrent); err == nil {
+				r.log.WriteAt(tail, pos-int64(len(tail)))
+			}
+		}
+		r.log.Write(masked)
+	}
+}
+
+// Resize records a terminal size change, as script logged SIGWINCH.
+func (r *sessionRecorder) Resize(rows, cols uint16) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || !r.pausedAt.IsZero() {
+		return
+	}
+	fmt.Fprintf(r.timing, "S %.6f SIGWINCH ROWS=%d COLS=%d\n", r.delay(), rows, cols)
+}
+
+// Pause stops recording; false if it already was paused.
+func (r *sessionRecorder) Pause() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || !r.pausedAt.IsZero() {
+		return false
+	}
+	r.pausedAt = r.now()
+	return true
+}
+
+// Resume restarts recording and writes the pause marker into the output;
+// false if it was not paused.
+func (r *sessionRecorder) Resume() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.pausedAt.IsZero() {
+		return false
+	}
+	r.writeMarker(r.now())
+	r.pausedAt = time.Time{}
+	return true
+}
+
+// writeMarker writes the "[recording paused from–to]" line for the pause
+// ending at end. Call with r.mu held.
+func (r *sessionRecorder) writeMarker(end time.Time) {
+	marker := fmt.Sprintf("\r\n[recording paused %s–%s]\r\n",
+		formatRecordingOffset(r.pausedAt.Sub(r.start)), formatRecordingOffset(end.Sub(r.start)))
+	fmt.Fprintf(r.timing, "O %.6f %d\n", r.delay(), len(marker))
+	r.log.WriteString(marker)
+	r.secrets.tail = nil // no secret spans the marker
+}
+
+// Paused reports whether recording is paused.
+func (r *sessionRecorder) Paused() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.pausedAt.IsZero()
+}
+
+// Closed reports whether the recording files are complete: Close has run, or
+// there is no recorder.
+func (r *sessionRecorder) Closed() bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// Close writes the trailers, closing an open pause with its marker, and
+// closes the files. Later calls do nothing.
+func (r *sessionRecorder) Close(exitCode int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	t := r.now()
+	if !r.pausedAt.IsZero() {
+		r.writeMarker(t)
+	}
+	footer := fmt.Sprintf("\nScript done on %s [COMMAND_EXIT_CODE=\"%d\"]\n", t.Format(scriptTimeLayout), exitCode)
+	r.log.WriteString(footer)
+	r.input.WriteString(footer)
+	fmt.Fprintf(r.timing, "H 0.000000 DURATION %.6f\n", t.Sub(r.start).Seconds())
+	fmt.Fprintf(r.timing, "H 0.000000 EXIT_CODE %d\n", exitCode)
+	r.timing.WriteString(inputRedactedHeader)
+	for _, f := range []*os.File{r.log, r.timing, r.input} {
+		if err := f.Close(); err != nil {
+			log.Printf("Recorder: close %s: %v", f.Name(), err)
+		}
+	}
+}
+
+// formatRecordingOffset renders d as HH:MM:SS.
+func formatRecordingOffset(d time.Duration) string {
+	s := int(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// SetRecordingPaused pauses or resumes the session's recording and
+// broadcasts the new status; false if nothing changed.
+func (s *Session) SetRecordingPaused(paused bool) bool {
+	rec := s.recorder.Load()
+	if rec == nil {
+		return false
+	}
+	var changed bool
+	if paused {
+		changed = rec.Pause()
+	} else {
+		changed = rec.Resume()
+	}
+	if changed {
+		if paused {
+			log.Printf("Session %s: recording paused", s.UUID)
+		} else {
+			log.Printf("Session %s: recording resumed", s.UUID)
+		}
+		go s.BroadcastStatus()
+	}
+	return changed
+}
+
+// RecordingPaused reports whether the session's recording is paused.
+func (s *Session) RecordingPaused() bool {
+	return s.recorder.Load().Paused()
+}
+
+// handleSessionRecordingAPI serves POST /api/session/{uuid}/recording/pause
+// and /recording/resume, answering with the resulting {"paused": bool}.
+func handleSessionRecordingAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/session/")
+	sessionUUID, action, _ := strings.Cut(rest, "/recording/")
+	if action != "pause" && action != "resume" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil || sess.recorder.Load() == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	sess.SetRecordingPaused(action == "pause")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"paused": sess.RecordingPaused()})
+}
@@ -0,0 +1,237 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// DNS record types and classes this package reads and writes.
+const (
+	typeA   uint16 = 1
+	typePTR uint16 = 12
+	typeTXT uint16 = 16
+	typeSRV uint16 = 33
+	typeANY uint16 = 255
+
+	classIN uint16 = 1
+	// topBit is the cache-flush bit on a record's class and the
+	// unicast-response (QU) bit on a question's (RFC 6762 sections 10.2, 5.4).
+	topBit uint16 = 0x8000
+)
+
+var errMalformed = errors.New("mdns: malformed message")
+
+type question struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+// record is one resource record. Only the fields for its type are set.
+type record struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+
+	target string   // PTR: the instance; SRV: the host
+	port   uint16   // SRV
+	txt    []string // TXT
+	a      net.IP   // A
+}
+
+type message struct {
+	id        uint16
+	response  bool
+	questions []question
+	records   []record // answers, then authority and additional
+	answers   int      // how many of records are answers (encoding only)
+}
+
+// encode packs m without name compression, which every reader accepts.
+func (m *message) encode() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.id)
+	if m.response {
+		binary.BigEndian.PutUint16(b[2:], 0x8400) // QR, AA
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(m.answers))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.records)-m.answers))
+	var err error
+	for _, q := range m.questions {
+		if b, err = appendName(b, q.name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.qtype)
+		b = binary.BigEndian.AppendUint16(b, q.qclass)
+	}
+	for _, r := range m.records {
+		if b, err = appendName(b, r.name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, r.rtype)
+		b = binary.BigEndian.AppendUint16(b, r.class)
+		b = binary.BigEndian.AppendUint32(b, r.ttl)
+		lenAt := len(b)
+		b = append(b, 0, 0)
+		switch r.rtype {
+		case typePTR:
+			b, err = appendName(b, r.target)
+		case typeSRV:
+			b = append(b, 0, 0, 0, 0) // priority, weight
+			b = binary.BigEndian.AppendUint16(b, r.port)
+			b, err = appendName(b, r.target)
+		case typeTXT:
+			if len(r.txt) == 0 {
+				b = append(b, 0)
+			}
+			for _, s := range r.txt {
+				if len(s) > 255 {
+					return nil, errors.New("mdns: TXT string over 255 bytes")
+				}
+				b = append(append(b, byte(len(s))), s...)
+			}
+		case typeA:
+			b = append(b, r.a.To4()...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint16(b[lenAt:], uint16(len(b)-lenAt-2))
+	}
+	return b, nil
+}
+
+// appendName appends a dotted name ("laptop.local.") as labels.
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errors.New("mdns: bad label in " + name)
+		}
+		b = append(append(b, byte(len(label))), label...)
+	}
+	return append(b, 0), nil
+}
+
+// parse unpacks a message, following name compression pointers. Records of
+// other types are kept with only their header fields.
+func parse(b []byte) (*message, error) {
+	if len(b) < 12 {
+		return nil, errMalformed
+	}
+	m := &message{
+		id:       binary.BigEndian.Uint16(b[0:]),
+		response: b[2]&0x80 != 0,
+	}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	rr := int(binary.BigEndian.Uint16(b[6:])) + int(binary.BigEndian.Uint16(b[8:])) + int(binary.BigEndian.Uint16(b[10:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, n, err := readName(b, off)
+		if err != nil || n+4 > len(b) {
+			return nil, errMalformed
+		}
+		m.questions = append(m.questions, question{
+			name:   name,
+			qtype:  binary.BigEndian.Uint16(b[n:]),
+			qclass: binary.BigEndian.Uint16(b[n+2:]),
+		})
+		off = n + 4
+	}
+	for i := 0; i < rr; i++ {
+		name, n, err := readName(b, off)
+		if err != nil || n+10 > len(b) {
+			return nil, errMalformed
+		}
+		r := record{
+			name:  name,
+			rtype: binary.BigEndian.Uint16(b[n:]),
+			class: binary.BigEndian.Uint16(b[n+2:]),
+			ttl:   binary.BigEndian.Uint32(b[n+4:]),
+		}
+		start := n + 10
+		end := start + int(binary.BigEndian.Uint16(b[n+8:]))
+		if end > len(b) {
+			return nil, errMalformed
+		}
+		rdata := b[start:end]
+		switch r.rtype {
+		case typePTR:
+			if r.target, _, err = readName(b, start); err != nil {
+				return nil, errMalformed
+			}
+		case typeSRV:
+			if len(rdata) < 7 {
+				return nil, errMalformed
+			}
+			r.port = binary.BigEndian.Uint16(rdata[4:])
+			if r.target, _, err = readName(b, start+6); err != nil {
+				return nil, errMalformed
+			}
+		case typeTXT:
+			for t := rdata; len(t) > 0; {
+				l := int(t[0])
+				if 1+l > len(t) {
+					return nil, errMalformed
+				}
+				if l > 0 {
+					r.txt = append(r.txt, string(t[1:1+l]))
+				}
+				t = t[1+l:]
+			}
+		case typeA:
+			if len(rdata) != 4 {
+				return nil, errMalformed
+			}
+			r.a = net.IPv4(rdata[0], rdata[1], rdata[2], rdata[3])
+		}
+		m.records = append(m.records, r)
+		off = end
+	}
+	return m, nil
+}
+
+// readName reads the name at off and returns it dotted, with the offset just
+// past it in the original (uncompressed) position.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errMalformed
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			// Pointers must go backwards, which also rules out loops.
+			ptr := int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+			if ptr >= off || jumps > 32 {
+				return "", 0, errMalformed
+			}
+			off = ptr
+			jumps++
+		case l&0xc0 != 0:
+			return "", 0, errMalformed
+		default:
+			if off+1+l > len(b) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(b[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
@@ -74,7 +74,7 @@ func mcpLessProxySpecs(sessionMode string) []proxySpec {
 		},
 		proxySpec{
 			Name: "swe-swe-preview",
-			Argv: shExec("swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/proxy/$SESSION_UUID/preview/mcp?key=$MCP_AUTH_KEY"),
+			Argv: shExec("swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp/preview?key=$MCP_AUTH_KEY"),
 		},
 		proxySpec{
 			Name: "swe-swe-whiteboard",
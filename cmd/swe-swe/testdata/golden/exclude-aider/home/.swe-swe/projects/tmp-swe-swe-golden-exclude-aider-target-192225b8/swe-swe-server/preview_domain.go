@@ -0,0 +1,115 @@
+// preview_domain.go -- per-session preview subdomains.
+//
+// Path-based preview (/preview/{uuid}/, proxy_mode.go) serves the app under
+// a prefix. agent-reverse-proxy rewrites what it can, but an app that builds
+// absolute URLs ("/api/items", "/assets/app.js" from a bundler, a router's
+// pushState) escapes the prefix and breaks. Per-port preview avoids that, but
+// needs a published port per session.
+//
+// SWE_PREVIEW_DOMAIN=preview.example.com gives each session its own origin
+// on the main server port instead: {sessionUUID}.preview.example.com. The
+// main handler (previewDomainRouter, in front of every other route) matches
+// the Host, finds the session and hands the request to its root-mounted
+// agentproxy instance. That instance rewrites the upstream Host to
+// localhost:{previewPort}, relays WebSockets and injects the debug script,
+// so the Preview tab works exactly as with per-port preview.
+//
+// Every path on a preview host is the app's except /swe-swe-auth/, so the
+// login form works there. The auth cookie is scoped to the preview domain's
+// parent (example.com) so one login covers the UI and every session's
+// subdomain; the UI must be served from that parent or a name under it.
+// A shared-session guest may open only their own session's subdomain.
+//
+// DNS (*.preview.example.com) and a certificate covering the wildcard are
+// the deployment's job. `swe-swe init --ssl=selfsign@HOST` certificates
+// include *.preview.HOST; Let's Encrypt wildcards need a DNS challenge,
+// which swe-swe does not configure.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// previewDomain is SWE_PREVIEW_DOMAIN ("" = off), lowercased, no dots at
+// either end.
+var previewDomain string
+
+// loadPreviewDomain applies SWE_PREVIEW_DOMAIN.
+func loadPreviewDomain() error {
+	previewDomain = ""
+	d := strings.ToLower(strings.Trim(strings.TrimSpace(os.Getenv("SWE_PREVIEW_DOMAIN")), "."))
+	if d == "" {
+		return nil
+	}
+	if strings.ContainsAny(d, ":/ ") || strings.Count(d, ".") < 1 {
+		return fmt.Errorf("SWE_PREVIEW_DOMAIN=%q: want a domain name like preview.example.com", d)
+	}
+	previewDomain = d
+	log.Printf("Preview subdomains from SWE_PREVIEW_DOMAIN: {session}.%s", d)
+	return nil
+}
+
+// previewDomainSession returns the session UUID a request Host addresses
+// under SWE_PREVIEW_DOMAIN.
+func previewDomainSession(requestHost string) (string, bool) {
+	if previewDomain == "" {
+		return "", false
+	}
+	host := requestHost
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+previewDomain)
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return "", false
+	}
+	return label, true
+}
+
+// previewDomainHost is the browser-facing preview host for a session, for
+// the status message.
+func previewDomainHost(sessionUUID string) string {
+	if previewDomain == "" {
+		return ""
+	}
+	return sessionUUID + "." + previewDomain
+}
+
+// previewDomainCookieDomain is the auth cookie Domain when requestHost is
+// the preview domain's parent or a name under it, else "".
+func previewDomainCookieDomain(requestHost string) string {
+	if previewDomain == "" {
+		return ""
+	}
+	_, parent, _ := strings.Cut(previewDomain, ".")
+	if !strings.Contains(parent, ".") {
+		// A bare TLD (or "localhost"): browsers refuse the Domain.
+		return ""
+	}
+	return previewCookieReachFrom(requestHost, []string{parent})
+}
+
+// previewDomainRouter sends requests for a session's preview subdomain to
+// its root-mounted preview proxy, and everything else to next.
+func previewDomainRouter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionUUID, ok := previewDomainSession(r.Host)
+		if !ok || strings.HasPrefix(r.URL.Path, "/swe-swe-auth/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sessionsMu.RLock()
+		sess, ok := sessions[sessionUUID]
+		sessionsMu.RUnlock()
+		if !ok || sess.PreviewHostProxy == nil {
+			http.Error(w, "No such session", http.StatusNotFound)
+			return
+		}
+		traceHandler(w, r, "proxy.request", sess.PreviewHostProxy, "session.uuid", sessionUUID)
+	})
+}
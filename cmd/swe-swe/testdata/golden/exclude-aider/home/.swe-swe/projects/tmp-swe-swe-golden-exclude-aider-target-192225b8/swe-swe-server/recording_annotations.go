@@ -0,0 +1,407 @@
+// recording_annotations.go -- bookmarks on a recording's timeline.
+//
+// An annotation is a note pinned to a moment of a recording ("this is where
+// the bug reproduced"). Annotations live in the recording's metadata.json and
+// show up as chapter markers in the playback page's table of contents, mixed
+// in with the automatic chapters (recording_chapters.go).
+//
+//	GET    /api/recording/{uuid}/annotations       -> {"annotations": [...]}
+//	POST   /api/recording/{uuid}/annotations       {"timestampSeconds": 42.5, "text": "..."}
+//	DELETE /api/recording/{uuid}/annotations/{id}
+//
+// While the session is still running, its terminal can bookmark "now" with
+// the WebSocket control message {"type": "bookmark", "text": "..."}. A live
+// session owns its metadata (saveMetadata rewrites the file from memory), so
+// annotations for a live recording go through Session.Metadata; those for an
+// ended one edit the file.
+//
+// The player scrolls by output line, not time, so a timestamp is placed by
+// replaying the .timing file to the output byte written at that moment and
+// counting lines up to it -- the same mapping the automatic chapters use.
+// Recordings without a timing file (macOS) keep their annotations but show
+// no markers.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	crypto_rand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	recordtui "github.com/choonkeat/record-tui/playback"
+)
+
+const (
+	annotationMaxText         = 200
+	annotationMaxPerRecording = 500
+)
+
+// RecordingAnnotation is one bookmark on a recording's timeline.
+type RecordingAnnotation struct {
+	ID               string    `json:"id"`
+	TimestampSeconds float64   `json:"timestampSeconds"` // since the recording started
+	Text             string    `json:"text"`
+	Author           string    `json:"author,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+var (
+	errRecordingNotFound  = errors.New("recording not found")
+	errAnnotationNotFound = errors.New("annotation not found")
+)
+
+// annotationInputError is a bad request, as opposed to a storage failure.
+type annotationInputError string
+
+func (e annotationInputError) Error() string { return string(e) }
+
+// annotationsMu serializes read-modify-write of ended recordings' metadata.
+var annotationsMu sync.Mutex
+
+// liveRecordingSession returns the running session recording into
+// session-{recordingUUID}, if any.
+func liveRecordingSession(recordingUUID string) *Session {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, sess := range sessions {
+		if sess.RecordingPrefix == "session-"+recordingUUID && sess.Metadata != nil {
+			return sess
+		}
+	}
+	return nil
+}
+
+// updateAnnotations applies fn to a recording's annotations and persists the
+// result, through the live session when there is one.
+func updateAnnotations(recordingUUID string, fn func([]RecordingAnnotation) ([]RecordingAnnotation, error)) error {
+	if sess := liveRecordingSession(recordingUUID); sess != nil {
+		sess.mu.Lock()
+		updated, err := fn(sess.Metadata.Annotations)
+		if err == nil {
+			sess.Metadata.Annotations = updated
+		}
+		sess.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return sess.saveMetadata()
+	}
+
+	annotationsMu.Lock()
+	defer annotationsMu.Unlock()
+	metadataPath := recordingDir(recordingUUID) + "/session-" + recordingUUID + ".metadata.json"
+	data, err := os.ReadFile(metadataPath)
+	if os.IsNotExist(err) {
+		return errRecordingNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var meta RecordingMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	if meta.Annotations, err = fn(meta.Annotations); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := metadataPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, metadataPath)
+}
+
+// addRecordingAnnotation pins text at ts seconds into the recording.
+func addRecordingAnnotation(recordingUUID string, ts float64, text, author string) (RecordingAnnotation, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return RecordingAnnotation{}, annotationInputError("text is required")
+	case len(text) > annotationMaxText:
+		return RecordingAnnotation{}, annotationInputError(fmt.Sprintf("text too long (max %d characters)", annotationMaxText))
+	case ts < 0:
+		return RecordingAnnotation{}, annotationInputError("timestampSeconds must not be negative")
+	}
+	b := make([]byte, 6)
+	if _, err := crypto_rand.Read(b); err != nil {
+		return RecordingAnnotation{}, err
+	}
+	a := RecordingAnnotation{ID: hex.EncodeToString(b), TimestampSeconds: ts, Text: text, Author: author, CreatedAt: time.Now()}
+	err := updateAnnotations(recordingUUID, func(list []RecordingAnnotation) ([]RecordingAnnotation, error) {
+		if len(list) >= annotationMaxPerRecording {
+			return nil, annotationInputError(fmt.Sprintf("too many annotations (max %d)", annotationMaxPerRecording))
+		}
+		list = append(append([]RecordingAnnotation(nil), list...), a)
+		sort.SliceStable(list, func(i, j int) bool { return list[i].TimestampSeconds < list[j].TimestampSeconds })
+		return list, nil
+	})
+	return a, err
+}
+
+// deleteRecordingAnnotation removes one annotation by ID.
+func deleteRecordingAnnotation(recordingUUID, id string) error {
+	return updateAnnotations(recordingUUID, func(list []RecordingAnnotation) ([]RecordingAnnotation, error) {
+		for i, a := range list {
+			if a.ID == id {
+				return append(append([]RecordingAnnotation(nil), list[:i]...), list[i+1:]...), nil
+			}
+		}
+		return nil, errAnnotationNotFound
+	})
+}
+
+// loadRecordingAnnotations returns a recording's annotations, live or ended.
+func loadRecordingAnnotations(recordingUUID string) ([]RecordingAnnotation, error) {
+	if sess := liveRecordingSession(recordingUUID); sess != nil {
+		sess.mu.RLock()
+		defer sess.mu.RUnlock()
+		return append([]RecordingAnnotation(nil), sess.Metadata.Annotations...), nil
+	}
+	data, err := os.ReadFile(recordingDir(recordingUUID) + "/session-" + recordingUUID + ".metadata.json")
+	if os.IsNotExist(err) {
+		return nil, errRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta RecordingMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta.Annotations, nil
+}
+
+// handleRecordingAnnotationsAPI handles /api/recording/{uuid}/annotations[/{id}].
+func handleRecordingAnnotationsAPI(w http.ResponseWriter, r *http.Request, recordingUUID, annotationID string) {
+	switch {
+	case r.Method == http.MethodGet && annotationID == "":
+		list, err := loadRecordingAnnotations(recordingUUID)
+		if err != nil {
+			writeAnnotationError(w, recordingUUID, err)
+			return
+		}
+		if list == nil {
+			list = []RecordingAnnotation{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"annotations": list})
+	case r.Method == http.MethodPost && annotationID == "":
+		var req struct {
+			TimestampSeconds *float64 `json:"timestampSeconds"`
+			Text             string   `json:"text"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.TimestampSeconds == nil {
+			http.Error(w, "timestampSeconds is required", http.StatusBadRequest)
+			return
+		}
+		a, err := addRecordingAnnotation(recordingUUID, *req.TimestampSeconds, req.Text, "")
+		if err != nil {
+			writeAnnotationError(w, recordingUUID, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	case r.Method == http.MethodDelete && annotationID != "":
+		if err := deleteRecordingAnnotation(recordingUUID, annotationID); err != nil {
+			writeAnnotationError(w, recordingUUID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeAnnotationError(w http.ResponseWriter, recordingUUID string, err error) {
+	var input annotationInputError
+	switch {
+	case errors.Is(err, errRecordingNotFound):
+		http.Error(w, "Recording not found", http.StatusNotFound)
+	case errors.Is(err, errAnnotationNotFound):
+		http.Error(w, "Annotation not found", http.StatusNotFound)
+	case errors.As(err, &input):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Recording %s: annotations: %v", recordingUUID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// handleBookmarkMessage handles the session WebSocket's {"type": "bookmark"}:
+// an annotation at the current moment of the session's recording.
+func handleBookmarkMessage(sess *Session, conn *SafeConn, text, author string) {
+	sess.mu.RLock()
+	var startedAt time.Time
+	if sess.Metadata != nil {
+		startedAt = sess.Metadata.StartedAt
+	}
+	recordingUUID := strings.TrimPrefix(sess.RecordingPrefix, "session-")
+	sess.mu.RUnlock()
+
+	reply := map[string]any{"type": "bookmark_added"}
+	if startedAt.IsZero() {
+		reply = map[string]any{"type": "bookmark_error", "message": "this session is not being recorded"}
+	} else if a, err := addRecordingAnnotation(recordingUUID, time.Since(startedAt).Seconds(), text, author); err != nil {
+		reply = map[string]any{"type": "bookmark_error", "message": err.Error()}
+	} else {
+		reply["annotation"] = a
+		log.Printf("Session %s: bookmark at %.1fs: %s", sess.UUID, a.TimestampSeconds, a.Text)
+	}
+	if err := conn.WriteJSON(reply); err != nil {
+		log.Printf("Failed to send bookmark reply: %v", err)
+	}
+}
+
+// annotationTOC places annotations on output lines for the player's TOC, or
+// returns nil when the recording has no timing file to place them with.
+func annotationTOC(recordingUUID, logPath string, annotations []RecordingAnnotation) []recordtui.TOCEntry {
+	if len(annotations) == 0 {
+		return nil
+	}
+	timingFile, err := os.Open(recordingDir(recordingUUID) + "/session-" + recordingUUID + ".timing")
+	if err != nil {
+		return nil
+	}
+	defer timingFile.Close()
+
+	sorted := append([]RecordingAnnotation(nil), annotations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].TimestampSeconds < sorted[j].TimestampSeconds })
+	offsets := outputOffsetsAt(timingFile, sorted)
+
+	logReader, err := openLogReader(logPath)
+	if err != nil {
+		return nil
+	}
+	defer logReader.Close()
+	lines, _ := linesAtOffsets(logReader, offsets)
+
+	entries := make([]recordtui.TOCEntry, len(sorted))
+	for i, a := range sorted {
+		entries[i] = recordtui.TOCEntry{Label: "Bookmark: " + a.Text, Line: lines[i]}
+	}
+	return entries
+}
+
+// outputOffsetsAt replays a script(1) timing file and returns, for each
+// annotation (sorted by time), how many output bytes had been written by
+// then. Both the advanced ("O 0.5 12") and classic ("0.5 12") formats count.
+func outputOffsetsAt(timing io.Reader, sorted []RecordingAnnotation) []int {
+	offsets := make([]int, len(sorted))
+	var elapsed float64
+	offset, next := 0, 0
+	scanner := bufio.NewScanner(timing)
+	for scanner.Scan() && next < len(sorted) {
+		typ, delay, n, ok := parseTimingLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		elapsed += delay
+		for next < len(sorted) && sorted[next].TimestampSeconds < elapsed {
+			offsets[next] = offset
+			next++
+		}
+		if typ == 'O' {
+			offset += n
+		}
+	}
+	for ; next < len(sorted); next++ {
+		offsets[next] = offset
+	}
+	return offsets
+}
+
+// linesAtOffsets maps ascending output byte offsets to 0-indexed lines of the
+// log as the player shows it: script(1)'s header lines at the top are
+// skipped, as in record-tui's toc.FromCommands. It also returns the first
+// visible text written from each offset on, looking a few lines ahead past
+// blank output ("" when there is none).
+func linesAtOffsets(r io.Reader, offsets []int) ([]int, []string) {
+	const lookahead = 20
+	lines := make([]int, len(offsets))
+	texts := make([]string, len(offsets))
+	var waiting []int // offsets whose text is still to come
+	waited := 0
+	bytePos, lineCount, next := 0, 0, 0
+	inHeader := true
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(scanRawLines)
+	for (next < len(offsets) || len(waiting) > 0) && scanner.Scan() {
+		line := scanner.Text()
+		if inHeader && (strings.HasPrefix(line, "Script started on") || strings.HasPrefix(line, "Command:")) {
+			bytePos += len(line) + 1
+			continue
+		}
+		inHeader = false
+		if len(waiting) > 0 {
+			if text := visibleText(line); text != "" {
+				for _, i := range waiting {
+					texts[i] = text
+				}
+				waiting = nil
+			} else if waited++; waited >= lookahead {
+				waiting = nil
+			}
+		}
+		lineEnd := bytePos + len(line) + 1
+		for next < len(offsets) && offsets[next] < lineEnd {
+			lines[next] = lineCount
+			if text := visibleText(line[max(0, min(offsets[next]-bytePos, len(line))):]); text != "" {
+				texts[next] = text
+			} else {
+				if len(waiting) == 0 {
+					waited = 0
+				}
+				waiting = append(waiting, next)
+			}
+			next++
+		}
+		lineCount++
+		bytePos = lineEnd
+	}
+	for ; next < len(offsets); next++ {
+		lines[next] = lineCount
+	}
+	return lines, texts
+}
+
+// scanRawLines splits on "\n" only, so byte counts stay exact for CRLF
+// output (bufio.ScanLines would also drop the "\r").
+func scanRawLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// mergeTOC interleaves annotation markers with command entries by line.
+func mergeTOC(commands, annotations []recordtui.TOCEntry) []recordtui.TOCEntry {
+	if len(annotations) == 0 {
+		return commands
+	}
+	merged := append(append([]recordtui.TOCEntry(nil), commands...), annotations...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Line < merged[j].Line })
+	return merged
+}
@@ -0,0 +1,164 @@
+// recording_migrate.go -- schema versions for recording metadata files.
+//
+// RecordingMetadata gains fields over time (KeptAt, PlaybackCols, WorkDir,
+// ...) and files written before a field existed simply lack it, so every
+// reader had to guess what an empty field meant. Metadata files now carry a
+// schema_version, and at startup migrateRecordingMetadata upgrades every
+// older file in the recordings dirs (recording_dirs.go) to
+// recordingSchemaVersion by running the migrations it is missing, in order:
+//
+//	1  agent_binary, from the agent's display name (restart links)
+//	2  playback_cols/playback_rows, from the terminal log of an ended
+//	   recording (playback sizing without scanning the log every time)
+//
+// Files are read with parseRecordingMetadata, which tolerates what older
+// servers could leave behind: trailing bytes after the JSON object (a racing
+// rewrite) and a field of the wrong type (it is dropped). Such files are
+// rewritten cleanly and counted as repaired. A file written by a newer
+// server is left alone. Files that do not parse at all are left for
+// quarantineCorruptMetadata.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// recordingSchemaVersion is the schema_version of metadata this server
+// writes: len(recordingMigrations).
+const recordingSchemaVersion = 2
+
+// recordingMigrations[i] upgrades metadata from version i to i+1. prefix is
+// the recording's file prefix ("session-{uuid}" or
+// "session-{parent}-{child}").
+var recordingMigrations = []func(meta *RecordingMetadata, prefix string){
+	// 0 -> 1: the binary name used to restart the session.
+	func(meta *RecordingMetadata, prefix string) {
+		if meta.AgentBinary == "" && meta.RecordingType != "chat" && meta.RecordingType != "terminal" {
+			meta.AgentBinary = strings.ToLower(meta.Agent)
+		}
+	},
+	// 1 -> 2: content-based playback dimensions of an ended recording.
+	func(meta *RecordingMetadata, prefix string) {
+		if meta.EndedAt == nil || meta.PlaybackCols != 0 {
+			return
+		}
+		if logPath := resolveLogPath(prefix); logPath != "" {
+			dims := calculateTerminalDimensions(logPath)
+			meta.PlaybackCols, meta.PlaybackRows = dims.Cols, dims.Rows
+		}
+	},
+}
+
+// recordingMigrationStats reports what a migrateRecordingMetadata pass did.
+type recordingMigrationStats struct {
+	Scanned  int `json:"scanned"`
+	Current  int `json:"current"`
+	Upgraded int `json:"upgraded"`
+	Repaired int `json:"repaired"`
+	Newer    int `json:"newer"`
+	Failed   int `json:"failed"`
+}
+
+// errMetadataRepaired is returned by parseRecordingMetadata along with the
+// metadata it could recover from a damaged file.
+var errMetadataRepaired = errors.New("metadata repaired")
+
+// parseRecordingMetadata decodes a metadata file. Trailing bytes after the
+// object and fields of the wrong type are tolerated: the metadata is
+// returned with errMetadataRepaired. Any other error means nothing usable
+// was read.
+func parseRecordingMetadata(data []byte) (RecordingMetadata, error) {
+	var meta RecordingMetadata
+	dec := json.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&meta)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		return meta, fmt.Errorf("%w: %v", errMetadataRepaired, err)
+	case err != nil:
+		return RecordingMetadata{}, err
+	}
+	if rest := bytes.TrimSpace(data[dec.InputOffset():]); len(rest) > 0 {
+		return meta, fmt.Errorf("%w: %d trailing bytes", errMetadataRepaired, len(rest))
+	}
+	return meta, nil
+}
+
+// migrateRecordingMetadata upgrades every metadata file in the recordings
+// dirs to recordingSchemaVersion. It runs once at startup, before any
+// session can write metadata.
+func migrateRecordingMetadata() recordingMigrationStats {
+	var stats recordingMigrationStats
+	entries, err := readRecordingEntries()
+	if err != nil {
+		return stats
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, ok := strings.CutSuffix(name, ".metadata.json")
+		if entry.IsDir() || !ok || !strings.HasPrefix(prefix, "session-") {
+			continue
+		}
+		path := recordingPath(name)
+		data, err := os.ReadFile(path)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		stats.Scanned++
+		meta, err := parseRecordingMetadata(data)
+		repaired := errors.Is(err, errMetadataRepaired)
+		if err != nil && !repaired {
+			stats.Failed++
+			continue
+		}
+		if meta.SchemaVersion > recordingSchemaVersion {
+			stats.Newer++
+			continue
+		}
+		if meta.SchemaVersion == recordingSchemaVersion && !repaired {
+			stats.Current++
+			continue
+		}
+		from := meta.SchemaVersion
+		for _, migrate := range recordingMigrations[from:] {
+			migrate(&meta, prefix)
+		}
+		meta.SchemaVersion = recordingSchemaVersion
+		if err := writeRecordingMetadata(path, &meta); err != nil {
+			log.Printf("migrateRecordingMetadata: %s: %v", name, err)
+			stats.Failed++
+			continue
+		}
+		if repaired {
+			log.Printf("migrateRecordingMetadata: repaired %s: %v", name, err)
+			stats.Repaired++
+		}
+		if from < recordingSchemaVersion {
+			stats.Upgraded++
+		}
+	}
+	if stats.Upgraded > 0 || stats.Repaired > 0 || stats.Newer > 0 || stats.Failed > 0 {
+		log.Printf("migrateRecordingMetadata: scanned=%d current=%d upgraded=%d repaired=%d newer=%d failed=%d (schema v%d)",
+			stats.Scanned, stats.Current, stats.Upgraded, stats.Repaired, stats.Newer, stats.Failed, recordingSchemaVersion)
+	}
+	return stats
+}
+
+// writeRecordingMetadata atomically replaces the metadata file at path.
+func writeRecordingMetadata(path string, meta *RecordingMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
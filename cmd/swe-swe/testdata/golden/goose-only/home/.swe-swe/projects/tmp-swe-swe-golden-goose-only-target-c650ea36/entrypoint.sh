@@ -39,7 +39,7 @@ extensions:
     cmd: sh
     args:
       - "-c"
-      - "exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/proxy/$SESSION_UUID/preview/mcp?key=$MCP_AUTH_KEY"
+      - "exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp/preview?key=$MCP_AUTH_KEY"
   swe-swe-whiteboard:
     type: stdio
     cmd: swe-npx
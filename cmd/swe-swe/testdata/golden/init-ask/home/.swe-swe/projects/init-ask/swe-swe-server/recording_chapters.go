@@ -0,0 +1,283 @@
+// recording_chapters.go -- automatic chapters for the playback TOC.
+//
+// A recording is split where a new piece of work starts:
+//
+//   - a prompt: the user pressed Enter in the terminal after typing at least
+//     two characters (so "y" or "1" answering a menu is not a chapter), other
+//     than a masked password (input_redact.go).
+//     Enter inside a bracketed paste is part of the prompt, not a submit.
+//     The chapter is labelled with the first line of what was typed:
+//     Prompt 3 (14:02) -- 'add tests for parser'.
+//   - an idle gap: the agent went quiet for chapterIdleGap and then started
+//     writing again without any keyboard input, e.g. woken by a chat message.
+//     The chapter is labelled with the first line of transcript after it:
+//     Resumed after 12m idle (14:30) -- 'Reading the new test output'.
+//
+// Both come from replaying the .timing file against the .input file, the
+// same data record-tui's BuildTOC reads, and are placed on output lines with
+// linesAtOffsets. Times are wall-clock when the recording's start time is
+// known, else offsets from the start.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	recordtui "github.com/choonkeat/record-tui/playback"
+)
+
+const (
+	chapterIdleGap      = 60 * time.Second
+	chapterMaxLabelText = 60
+)
+
+// chapter is a TOC entry found on the timeline, before it is placed on a line.
+type chapter struct {
+	offset int     // output bytes written when it starts
+	at     float64 // seconds since the recording started
+	prompt string  // first line of the submitted prompt; "" for an idle gap
+	idle   float64 // seconds of silence before an idle-gap chapter
+}
+
+// chapterTOC builds the prompt and idle-gap chapters for a recording, or nil
+// when it has no timing file.
+func chapterTOC(recordingUUID, logPath string, startedAt time.Time) []recordtui.TOCEntry {
+	timingFile, err := os.Open(recordingDir(recordingUUID) + "/session-" + recordingUUID + ".timing")
+	if err != nil {
+		return nil
+	}
+	defer timingFile.Close()
+	// A missing input file (input recording off) still leaves idle gaps.
+	input, _ := os.ReadFile(recordingDir(recordingUUID) + "/session-" + recordingUUID + ".input")
+	chapters := findChapters(timingFile, stripInputHeader(input))
+	if len(chapters) == 0 {
+		return nil
+	}
+
+	logReader, err := openLogReader(logPath)
+	if err != nil {
+		return nil
+	}
+	defer logReader.Close()
+	offsets := make([]int, len(chapters))
+	for i, c := range chapters {
+		offsets[i] = c.offset
+	}
+	lines, texts := linesAtOffsets(logReader, offsets)
+
+	entries := make([]recordtui.TOCEntry, len(chapters))
+	prompts := 0
+	for i, c := range chapters {
+		clock := chapterClock(startedAt, c.at)
+		var label string
+		if c.prompt != "" {
+			prompts++
+			label = fmt.Sprintf("Prompt %d (%s) \u2014 '%s'", prompts, clock, truncateLabel(c.prompt))
+		} else {
+			idle := time.Duration(c.idle * float64(time.Second)).Round(time.Minute)
+			label = fmt.Sprintf("Resumed after %s idle (%s)", strings.TrimSuffix(idle.String(), "0s"), clock)
+			if texts[i] != "" {
+				label += " \u2014 '" + truncateLabel(texts[i]) + "'"
+			}
+		}
+		entries[i] = recordtui.TOCEntry{Label: label, Line: lines[i]}
+	}
+	return entries
+}
+
+// findChapters replays a timing file against the recorded input and returns
+// the prompt submissions and idle gaps in timeline order.
+func findChapters(timing io.Reader, input []byte) []chapter {
+	var chapters []chapter
+	var elapsed, pendingAt float64
+	offset, inputPos, pendingOffset := 0, 0, 0
+	var pending []byte
+	lastWasOutput := false
+
+	scanner := bufio.NewScanner(timing)
+	for scanner.Scan() {
+		typ, delay, n, ok := parseTimingLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		elapsed += delay
+		switch typ {
+		case 'O':
+			if lastWasOutput && delay >= chapterIdleGap.Seconds() {
+				chapters = append(chapters, chapter{offset: offset, at: elapsed, idle: delay})
+			}
+			offset += n
+			lastWasOutput = true
+		case 'I':
+			end := min(inputPos+n, len(input))
+			for _, b := range input[min(inputPos, end):end] {
+				if len(pending) == 0 {
+					pendingAt, pendingOffset = elapsed, offset
+				}
+				pending = append(pending, b)
+				if !submitsPrompt(pending) {
+					continue
+				}
+				if text := promptText(pending); utf8.RuneCountInString(text) >= 2 && !redactedText(text) {
+					chapters = append(chapters, chapter{offset: pendingOffset, at: pendingAt, prompt: text})
+				}
+				pending = pending[:0]
+			}
+			inputPos = end
+			lastWasOutput = false
+		}
+	}
+	return chapters
+}
+
+// parseTimingLine reads one script(1) timing line: advanced ("O 0.5 12",
+// "I 0.1 1") or classic ("0.5 12", always output). Header and signal lines
+// come back with their delay and no byte count.
+func parseTimingLine(line string) (typ byte, delay float64, n int, ok bool) {
+	fields := strings.Fields(line)
+	typ = 'O'
+	if len(fields) > 0 && len(fields[0]) == 1 && (fields[0][0] < '0' || fields[0][0] > '9') {
+		typ = fields[0][0]
+		fields = fields[1:]
+	}
+	if len(fields) < 1 {
+		return 0, 0, 0, false
+	}
+	delay, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	if typ == 'O' || typ == 'I' {
+		if len(fields) < 2 {
+			return 0, 0, 0, false
+		}
+		if n, err = strconv.Atoi(fields[1]); err != nil {
+			return 0, 0, 0, false
+		}
+	}
+	return typ, delay, n, true
+}
+
+// stripInputHeader drops script(1)'s "Script started on" / "Command:" lines
+// from the top of an input file; the timing file does not count them.
+func stripInputHeader(input []byte) []byte {
+	for i := 0; i < 5; i++ {
+		line, rest, found := bytes.Cut(input, []byte("\n"))
+		if !found || !(bytes.HasPrefix(line, []byte("Script started on")) || bytes.HasPrefix(line, []byte("Command:"))) {
+			break
+		}
+		input = rest
+	}
+	return input
+}
+
+// submitsPrompt reports whether the keystrokes so far end with an Enter that
+// submits: not Alt+Enter, and not a newline inside a bracketed paste.
+func submitsPrompt(keys []byte) bool {
+	last := keys[len(keys)-1]
+	if last != '\r' && last != '\n' {
+		return false
+	}
+	if len(keys) >= 2 && keys[len(keys)-2] == 0x1b {
+		return false
+	}
+	return bytes.LastIndex(keys, []byte("\x1b[200~")) <= bytes.LastIndex(keys, []byte("\x1b[201~"))
+}
+
+// promptText replays keystrokes as a simple line editor (backspace, Ctrl+U,
+// escape sequences dropped) and returns the first non-blank line typed.
+func promptText(keys []byte) string {
+	var buf []byte
+	for i := 0; i < len(keys); i++ {
+		switch b := keys[i]; {
+		case b == 0x1b:
+			i = escapeEnd(keys, i)
+		case b == 0x7f || b == 0x08:
+			_, size := utf8.DecodeLastRune(buf)
+			buf = buf[:len(buf)-size]
+		case b == 0x15:
+			buf = buf[:0]
+		case b == '\r' || b == '\n':
+			buf = append(buf, '\n')
+		case b == '\t':
+			buf = append(buf, ' ')
+		case b >= 0x20:
+			buf = append(buf, b)
+		}
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(string(buf)), "\n")
+	return strings.TrimSpace(first)
+}
+
+// escapeEnd returns the index of the last byte of the escape sequence that
+// starts at s[i] (an ESC): CSI, a string (OSC, DCS...), SS3, a charset
+// designation, or a two-byte sequence.
+func escapeEnd(s []byte, i int) int {
+	if i+1 >= len(s) {
+		return i
+	}
+	switch s[i+1] {
+	case '[':
+		j := i + 2
+		for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+			j++
+		}
+		return min(j, len(s)-1)
+	case ']', 'P', '_', '^', 'X':
+		for j := i + 2; j < len(s); j++ {
+			if s[j] == 0x07 {
+				return j
+			}
+			if s[j] == 0x1b && j+1 < len(s) && s[j+1] == '\\' {
+				return j + 1
+			}
+		}
+		return len(s) - 1
+	case 'O', '(', ')', '*', '+', '#', '%':
+		return min(i+2, len(s)-1)
+	}
+	return i + 1
+}
+
+// visibleText is a line of terminal output with escape sequences and control
+// characters removed and runs of whitespace collapsed.
+func visibleText(line string) string {
+	s := []byte(line)
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == 0x1b:
+			i = escapeEnd(s, i)
+		case b == '\t':
+			buf = append(buf, ' ')
+		case b >= 0x20 && b != 0x7f:
+			buf = append(buf, b)
+		}
+	}
+	return strings.Join(strings.Fields(string(buf)), " ")
+}
+
+// truncateLabel shortens text for a TOC label.
+func truncateLabel(text string) string {
+	if utf8.RuneCountInString(text) <= chapterMaxLabelText {
+		return text
+	}
+	return string([]rune(text)[:chapterMaxLabelText-3]) + "..."
+}
+
+// chapterClock is the wall-clock time of a chapter, or its offset from the
+// start when the recording's start time is unknown.
+func chapterClock(startedAt time.Time, at float64) string {
+	d := time.Duration(at * float64(time.Second))
+	if startedAt.IsZero() {
+		return "+" + d.Round(time.Second).String()
+	}
+	return startedAt.Add(d).Local().Format("15:04")
+}
@@ -0,0 +1,191 @@
+// session_limits.go -- caps on concurrently running sessions.
+//
+// Every top-level session takes a port from each port pool, so the smallest
+// pool (20 ports by default) is a hard ceiling; past it creation failed with
+// "no free port in the preview pool". The limits here turn that into a clear,
+// configurable rule:
+//
+//   - SWE_MAX_SESSIONS caps running sessions overall. It defaults to (and
+//     can only lower) the port pool capacity (port_pool.go).
+//   - SWE_MAX_SESSIONS_PER_ASSISTANT caps them per assistant binary:
+//     "claude=5,codex=3", optionally with a bare number for every other
+//     assistant ("2,claude=5"). Unset means no per-assistant cap.
+//
+// Child sessions (terminal tabs) share their parent's ports and are not
+// counted. getOrCreateSession enforces the limits under sessionsMu; the
+// new-session and fork handlers check up front so the user gets the answer
+// before any work is done. A refusal is a *sessionLimitError, which the HTTP
+// API returns as 429 JSON and the WebSocket as session_error. GET
+// /api/sessions/live reports the remaining capacity.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxSessions is SWE_MAX_SESSIONS (0 = the port pool capacity).
+var maxSessions int
+
+// maxSessionsPerAssistant is SWE_MAX_SESSIONS_PER_ASSISTANT by assistant
+// binary; "*" holds the default for assistants not listed.
+var maxSessionsPerAssistant map[string]int
+
+// loadSessionLimits applies SWE_MAX_SESSIONS and SWE_MAX_SESSIONS_PER_ASSISTANT.
+func loadSessionLimits() error {
+	maxSessions, maxSessionsPerAssistant = 0, nil
+	if v := strings.TrimSpace(os.Getenv("SWE_MAX_SESSIONS")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("SWE_MAX_SESSIONS=%q: want a positive number", v)
+		}
+		maxSessions = n
+	}
+	v := strings.TrimSpace(os.Getenv("SWE_MAX_SESSIONS_PER_ASSISTANT"))
+	if v == "" {
+		return nil
+	}
+	limits := map[string]int{}
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		name, num, found := strings.Cut(part, "=")
+		if !found {
+			name, num = "*", part
+		}
+		name = strings.TrimSpace(name)
+		n, err := strconv.Atoi(strings.TrimSpace(num))
+		if name == "" || err != nil || n < 1 {
+			return fmt.Errorf("SWE_MAX_SESSIONS_PER_ASSISTANT: bad entry %q (want assistant=N or N)", part)
+		}
+		if _, dup := limits[name]; dup {
+			return fmt.Errorf("SWE_MAX_SESSIONS_PER_ASSISTANT: %q given twice", name)
+		}
+		limits[name] = n
+	}
+	maxSessionsPerAssistant = limits
+	return nil
+}
+
+// globalSessionLimit is the effective overall cap.
+func globalSessionLimit() int {
+	ports := portPoolCapacity()
+	if maxSessions > 0 && maxSessions < ports {
+		return maxSessions
+	}
+	return ports
+}
+
+// assistantSessionLimit is the cap for one assistant binary, 0 for none.
+func assistantSessionLimit(assistant string) int {
+	if n, ok := maxSessionsPerAssistant[assistant]; ok {
+		return n
+	}
+	return maxSessionsPerAssistant["*"]
+}
+
+// sessionLimitError is a refused session creation.
+type sessionLimitError struct {
+	Scope     string `json:"scope"` // "global" or "assistant"
+	Assistant string `json:"assistant,omitempty"`
+	Limit     int    `json:"limit"`
+	Active    int    `json:"active"`
+}
+
+func (e *sessionLimitError) Error() string {
+	if e.Scope == "assistant" {
+		return fmt.Sprintf("%d of %d %s sessions are already running. End one to start another.",
+			e.Active, e.Limit, assistantDisplayName(e.Assistant))
+	}
+	return fmt.Sprintf("%d of %d sessions are already running. End one to start another.", e.Active, e.Limit)
+}
+
+// assistantDisplayName is the assistant's name as the homepage shows it.
+func assistantDisplayName(binary string) string {
+	for _, a := range installedAssistants() {
+		if a.Binary == binary {
+			return a.Name
+		}
+	}
+	return binary
+}
+
+// runningSessionCounts counts running top-level sessions, overall and by
+// assistant binary. A session still tearing down holds its ports and counts.
+// Must be called while holding sessionsMu.
+func runningSessionCounts() (int, map[string]int) {
+	total, byAssistant := 0, map[string]int{}
+	for _, sess := range sessions {
+		if sess.ParentUUID != "" || sess.reapable() {
+			continue
+		}
+		total++
+		byAssistant[sess.Assistant]++
+	}
+	return total, byAssistant
+}
+
+// checkSessionLimitLocked returns a *sessionLimitError if one more assistant
+// session would exceed a limit. Must be called while holding sessionsMu.
+func checkSessionLimitLocked(assistant string) error {
+	total, byAssistant := runningSessionCounts()
+	if limit := assistantSessionLimit(assistant); limit > 0 && byAssistant[assistant] >= limit {
+		return &sessionLimitError{Scope: "assistant", Assistant: assistant, Limit: limit, Active: byAssistant[assistant]}
+	}
+	if limit := globalSessionLimit(); total >= limit {
+		return &sessionLimitError{Scope: "global", Limit: limit, Active: total}
+	}
+	return nil
+}
+
+// checkSessionLimit is checkSessionLimitLocked for callers not holding sessionsMu.
+func checkSessionLimit(assistant string) error {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	return checkSessionLimitLocked(assistant)
+}
+
+// writeSessionLimitError answers an API request refused by a session limit.
+func writeSessionLimitError(w http.ResponseWriter, err *sessionLimitError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+		*sessionLimitError
+	}{"session_limit", err.Error(), err})
+}
+
+// sessionCapacity is how many more sessions may start.
+type sessionCapacity struct {
+	Limit     int `json:"limit,omitempty"` // 0 = no limit
+	Active    int `json:"active"`
+	Remaining int `json:"remaining"` // -1 = no limit
+}
+
+func newSessionCapacity(limit, active int) sessionCapacity {
+	if limit <= 0 {
+		return sessionCapacity{Active: active, Remaining: -1}
+	}
+	return sessionCapacity{Limit: limit, Active: active, Remaining: max(limit-active, 0)}
+}
+
+// sessionCapacityReport is the overall and per-assistant capacity, for
+// /api/sessions/live. An assistant's remaining count is also bounded by the
+// overall one. Must be called while holding sessionsMu.
+func sessionCapacityReport() map[string]any {
+	total, byAssistant := runningSessionCounts()
+	global := newSessionCapacity(globalSessionLimit(), total)
+	assistants := map[string]sessionCapacity{}
+	for _, a := range installedAssistants() {
+		c := newSessionCapacity(assistantSessionLimit(a.Binary), byAssistant[a.Binary])
+		if c.Remaining < 0 || c.Remaining > global.Remaining {
+			c.Remaining = global.Remaining
+		}
+		assistants[a.Binary] = c
+	}
+	return map[string]any{"global": global, "assistants": assistants}
+}
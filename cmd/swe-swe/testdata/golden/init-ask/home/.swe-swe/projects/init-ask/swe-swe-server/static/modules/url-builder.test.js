@@ -5,7 +5,7 @@
 
 import { test } from 'node:test';
 import assert from 'node:assert';
-import { getBaseUrl, buildShellUrl, buildSessionPageUrl, buildPreviewUrl, buildProxyUrl, buildAgentChatUrl, buildPortBasedPreviewUrl, buildPortBasedAgentChatUrl, buildPortBasedFilesUrl, buildPortBasedProxyUrl, buildSubdomainPreviewUrl, buildSubdomainAgentChatUrl, buildSubdomainFilesUrl, buildSubdomainProxyUrl, accessedViaTunnel, themeCookieDomain, getDebugQueryString } from './url-builder.js';
+import { getBaseUrl, buildShellUrl, buildSessionPageUrl, buildPreviewUrl, buildProxyUrl, buildAgentChatUrl, buildPortBasedPreviewUrl, buildPortBasedAgentChatUrl, buildPortBasedFilesUrl, buildPortBasedProxyUrl, buildSubdomainPreviewUrl, buildPreviewDomainUrl, buildSubdomainAgentChatUrl, buildSubdomainFilesUrl, buildSubdomainProxyUrl, accessedViaTunnel, themeCookieDomain, getDebugQueryString } from './url-builder.js';
 
 // getBaseUrl tests
 test('getBaseUrl with port returns protocol://hostname:port', () => {
@@ -156,7 +156,7 @@ test('buildSessionPageUrl encodes special characters', () => {
 test('buildPreviewUrl returns path-based URL with sessionUUID', () => {
     assert.strictEqual(
         buildPreviewUrl('http://localhost:1977', 'abc-123'),
-        'http://localhost:1977/proxy/abc-123/preview'
+        'http://localhost:1977/preview/abc-123'
     );
 });
 
@@ -177,7 +177,7 @@ test('buildPreviewUrl returns null when sessionUUID is empty', () => {
 test('buildPreviewUrl handles https base URL', () => {
     assert.strictEqual(
         buildPreviewUrl('https://example.com', 'uuid-456'),
-        'https://example.com/proxy/uuid-456/preview'
+        'https://example.com/preview/uuid-456'
     );
 });
 
@@ -185,42 +185,42 @@ test('buildPreviewUrl handles https base URL', () => {
 test('buildProxyUrl with no targetURL returns base with slash', () => {
     assert.strictEqual(
         buildProxyUrl('http://localhost:1977', 'abc-123', null),
-        'http://localhost:1977/proxy/abc-123/preview/'
+        'http://localhost:1977/preview/abc-123/'
     );
 });
 
 test('buildProxyUrl with empty targetURL returns base with slash', () => {
     assert.strictEqual(
         buildProxyUrl('http://localhost:1977', 'abc-123', ''),
-        'http://localhost:1977/proxy/abc-123/preview/'
+        'http://localhost:1977/preview/abc-123/'
     );
 });
 
 test('buildProxyUrl extracts path from full URL', () => {
     assert.strictEqual(
         buildProxyUrl('http://localhost:1977', 'abc-123', 'http://localhost:3000/api/health'),
-        'http://localhost:1977/proxy/abc-123/preview/api/health'
+        'http://localhost:1977/preview/abc-123/api/health'
     );
 });
 
 test('buildProxyUrl preserves query string and hash from target', () => {
     assert.strictEqual(
         buildProxyUrl('http://localhost:1977', 'abc-123', 'http://localhost:3000/page?q=1#section'),
-        'http://localhost:1977/proxy/abc-123/preview/page?q=1#section'
+        'http://localhost:1977/preview/abc-123/page?q=1#section'
     );
 });
 
 test('buildProxyUrl handles bare path starting with slash', () => {
     assert.strictEqual(
         buildProxyUrl('http://localhost:1977', 'abc-123', '/some/path'),
-        'http://localhost:1977/proxy/abc-123/preview/some/path'
+        'http://localhost:1977/preview/abc-123/some/path'
     );
 });
 
 test('buildProxyUrl handles bare path without leading slash', () => {
     assert.strictEqual(
         buildProxyUrl('http://localhost:1977', 'abc-123', 'some/path'),
-        'http://localhost:1977/proxy/abc-123/preview/some/path'
+        'http://localhost:1977/preview/abc-123/some/path'
     );
 });
 
@@ -376,6 +376,25 @@ test('buildPortBasedProxyUrl returns null when port is null', () => {
     );
 });
 
+// buildPreviewDomainUrl tests (SWE_PREVIEW_DOMAIN)
+test('buildPreviewDomainUrl keeps the page port', () => {
+    assert.strictEqual(
+        buildPreviewDomainUrl({ protocol: 'https:', port: '1977' }, 'abc-123.preview.example.com'),
+        'https://abc-123.preview.example.com:1977'
+    );
+});
+
+test('buildPreviewDomainUrl omits a default port', () => {
+    assert.strictEqual(
+        buildPreviewDomainUrl({ protocol: 'https:', port: '' }, 'abc-123.preview.example.com'),
+        'https://abc-123.preview.example.com'
+    );
+});
+
+test('buildPreviewDomainUrl returns null when not configured', () => {
+    assert.strictEqual(buildPreviewDomainUrl({ protocol: 'https:', port: '' }, null), null);
+});
+
 // buildSubdomainPreviewUrl tests (tunnel mode)
 test('buildSubdomainPreviewUrl returns protocol://port.publicHostname', () => {
     assert.strictEqual(
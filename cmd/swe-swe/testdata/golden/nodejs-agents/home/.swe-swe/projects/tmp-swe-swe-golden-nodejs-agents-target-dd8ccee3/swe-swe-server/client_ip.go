@@ -0,0 +1,180 @@
+// client_ip.go -- who actually connected: the client's address behind
+// trusted proxies, and its Tailscale identity.
+//
+// Behind Traefik (the compose stack), a PaaS router or `tailscale serve`, a
+// request's peer address is the proxy's, so visitor records and logs would
+// all name the proxy. SWE_TRUSTED_PROXIES lists the proxies whose forwarding
+// headers are believed: comma-separated IPs and CIDRs, and "private" for
+// loopback and the private ranges (10/8, 172.16/12, 192.168/16, fc00::/7)
+// that Docker networks use. For a request from a trusted proxy the client is
+// the right-most X-Forwarded-For hop that is not itself a trusted proxy --
+// hops left of it were written by the client and prove nothing -- or
+// X-Real-IP when there is no X-Forwarded-For. A request from any other peer
+// is attributed to the peer, whatever its headers say.
+//
+// SWE_TRUST_FORWARDED_FOR=true, the older switch, trusts every peer, so the
+// client is the first X-Forwarded-For hop.
+//
+// With SWE_TAILSCALE_IDENTITY=1 the Tailscale-User-Login and
+// Tailscale-User-Name headers that `tailscale serve` adds are read from
+// trusted proxies (serve proxies from loopback, so "127.0.0.1" or "private"
+// must be listed): visitor records keep the tailnet login and logs show it
+// next to the address. Tailscale or WireGuard peers that connect to the
+// server directly need none of this: their peer address is already their
+// tailnet IP.
+//
+// clientIP keys the login and API rate limiters and is the address in visitor
+// records; clientLabel is the remote in request logs.
+package main
+
+import (
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// privateProxyNets is what "private" stands for in SWE_TRUSTED_PROXIES.
+var privateProxyNets = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+// parseTrustedProxies parses a SWE_TRUSTED_PROXIES value, returning the
+// entries it could not read separately.
+func parseTrustedProxies(spec string) (nets []*net.IPNet, bad []string) {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case strings.EqualFold(entry, "private"):
+			for _, cidr := range privateProxyNets {
+				_, n, _ := net.ParseCIDR(cidr)
+				nets = append(nets, n)
+			}
+			continue
+		case !strings.Contains(entry, "/"):
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		default:
+			if _, n, err := net.ParseCIDR(entry); err == nil {
+				nets = append(nets, n)
+				continue
+			}
+		}
+		bad = append(bad, entry)
+	}
+	return nets, bad
+}
+
+var trustedProxyCache struct {
+	sync.Mutex
+	loaded bool
+	spec   string
+	nets   []*net.IPNet
+}
+
+// trustedProxyNets returns the parsed SWE_TRUSTED_PROXIES, re-read when it
+// changes and logging any entry it ignores.
+func trustedProxyNets() []*net.IPNet {
+	spec := os.Getenv("SWE_TRUSTED_PROXIES")
+	c := &trustedProxyCache
+	c.Lock()
+	defer c.Unlock()
+	if !c.loaded || spec != c.spec {
+		nets, bad := parseTrustedProxies(spec)
+		for _, b := range bad {
+			log.Printf("SWE_TRUSTED_PROXIES: ignoring %q: not an IP, CIDR or \"private\"", b)
+		}
+		c.loaded, c.spec, c.nets = true, spec, nets
+	}
+	return c.nets
+}
+
+// trustedProxy reports whether the forwarding headers of a request from ip
+// are believed.
+func trustedProxy(ip string) bool {
+	if os.Getenv("SWE_TRUST_FORWARDED_FOR") == "true" {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trustedProxyNets() {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP is the host part of the request's transport peer address.
+func peerIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// clientIP returns the address of the client that made r: the peer, or when
+// the peer is a trusted proxy, the client it forwarded for.
+func clientIP(r *http.Request) string {
+	ip := peerIP(r)
+	if !trustedProxy(ip) {
+		return ip
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			ip = hop
+			if !trustedProxy(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(real) != nil {
+		return real
+	}
+	return ip
+}
+
+// tailnetUser returns the Tailscale login and display name `tailscale serve`
+// forwarded with r, when SWE_TAILSCALE_IDENTITY=1 and it came through a
+// trusted proxy.
+func tailnetUser(r *http.Request) (login, name string) {
+	if os.Getenv("SWE_TAILSCALE_IDENTITY") != "1" || !trustedProxy(peerIP(r)) {
+		return "", ""
+	}
+	login = strings.TrimSpace(r.Header.Get("Tailscale-User-Login"))
+	if login == "" {
+		return "", ""
+	}
+	// Serve encodes non-ASCII names as RFC 2047 words.
+	name = r.Header.Get("Tailscale-User-Name")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	return login, strings.TrimSpace(name)
+}
+
+// clientLabel names the client for logs: its address, and its tailnet login
+// when there is one.
+func clientLabel(r *http.Request) string {
+	if login, _ := tailnetUser(r); login != "" {
+		return login + " (" + clientIP(r) + ")"
+	}
+	return clientIP(r)
+}
@@ -10,6 +10,8 @@ require github.com/gorilla/websocket v1.5.3
 
 require github.com/creack/pty v1.1.24
 
+require github.com/fsnotify/fsnotify v1.9.0
+
 require github.com/choonkeat/agent-reverse-proxy v0.2.12
 
 require (
@@ -27,4 +29,5 @@ require (
 	github.com/google/jsonschema-go v0.3.0 // indirect
 	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
 	golang.org/x/oauth2 v0.30.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 )
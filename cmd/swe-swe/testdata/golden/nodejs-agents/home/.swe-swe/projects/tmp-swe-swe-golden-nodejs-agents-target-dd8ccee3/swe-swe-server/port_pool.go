@@ -0,0 +1,365 @@
+// port_pool.go -- per-session port assignment from configurable pools.
+//
+// Every top-level session needs one port from each of six pools: preview,
+// agent chat, public, CDP, VNC and files. These used to be derived from the
+// preview port by fixed offsets (+1000, +2000, ...), which silently ignored
+// SWE_AGENT_CHAT_PORTS and friends and broke as soon as a Docker mapping
+// published anything but the default 3000/4000/5000/... bands. Each pool is
+// now an arbitrary list of ports ("3000-3009,3100,3200-3204", from the
+// SWE_*_PORTS variables) and is allocated independently.
+//
+// Assignments are explicit reservations: reserveSessionPorts takes them when
+// getOrCreateSession creates a session and releaseSessionPorts gives them back
+// when the session leaves the sessions map. sessionReaper runs
+// reclaimLeakedPorts so a reservation whose session vanished without a
+// release is logged and returned to the pool rather than lost for good.
+//
+// Assignments are also remembered per session UUID in
+// .swe-swe/recordings/ports.json, so a session recreated under the same UUID
+// (after a restart, or when it is resumed) gets the same ports back when they
+// are free, and bookmarked preview URLs keep working. Fresh sessions prefer
+// ports no other remembered session has used.
+//
+// The *PortStart/*PortEnd variables remain as each pool's lowest and highest
+// port for code that only needs the band (the Agent View tunnel exclusions,
+// the internal CDP/VNC ports one band-width above the public ones).
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// portLeakGrace is how long a reservation may outlive its session before
+	// reclaimLeakedPorts treats it as leaked.
+	portLeakGrace = time.Minute
+	// portMemoryTTL is how long an ended session's ports are remembered.
+	portMemoryTTL = 30 * 24 * time.Hour
+)
+
+// portPool is one kind of per-session port.
+type portPool struct {
+	name       string
+	env        string
+	start, end *int  // kept at the pool's lowest and highest port
+	ports      []int // sorted; nil means *start..*end
+}
+
+var (
+	previewPool   = &portPool{name: "preview", env: "SWE_PREVIEW_PORTS", start: &previewPortStart, end: &previewPortEnd}
+	agentChatPool = &portPool{name: "agent chat", env: "SWE_AGENT_CHAT_PORTS", start: &agentChatPortStart, end: &agentChatPortEnd}
+	publicPool    = &portPool{name: "public", env: "SWE_PUBLIC_PORTS", start: &publicPortStart, end: &publicPortEnd}
+	cdpPool       = &portPool{name: "CDP", env: "SWE_CDP_PORTS", start: &cdpPortStart, end: &cdpPortEnd}
+	vncPool       = &portPool{name: "VNC", env: "SWE_VNC_PORTS", start: &vncPortStart, end: &vncPortEnd}
+	filesPool     = &portPool{name: "files", env: "SWE_FILES_PORTS", start: &filesPortStart, end: &filesPortEnd}
+)
+
+// allPortPools lists the pools in portAssignment field order.
+func allPortPools() []*portPool {
+	return []*portPool{previewPool, agentChatPool, publicPool, cdpPool, vncPool, filesPool}
+}
+
+// list returns the pool's ports in ascending order.
+func (p *portPool) list() []int {
+	if p.ports != nil {
+		return p.ports
+	}
+	var ports []int
+	for port := *p.start; port <= *p.end; port++ {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+func (p *portPool) set(ports []int) {
+	p.ports = ports
+	*p.start, *p.end = ports[0], ports[len(ports)-1]
+}
+
+// index is port's position in the pool, or -1.
+func (p *portPool) index(port int) int {
+	ports := p.list()
+	if i := sort.SearchInts(ports, port); i < len(ports) && ports[i] == port {
+		return i
+	}
+	return -1
+}
+
+// parsePortSpec parses "3000-3019" or a comma-separated mix of ports and
+// ranges ("3000-3004,3010,3100-3104") into sorted, unique ports.
+func parsePortSpec(spec string) ([]int, error) {
+	seen := map[int]bool{}
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		if !isRange {
+			hi = lo
+		}
+		start, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		end, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("bad port or range %q", part)
+		}
+		for port := start; port <= end; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// loadPortPools applies the SWE_*_PORTS variables. A pool left unset keeps
+// its default band. No port may be in two pools.
+func loadPortPools() error {
+	owner := map[int]string{}
+	for _, p := range allPortPools() {
+		if spec := strings.TrimSpace(os.Getenv(p.env)); spec != "" {
+			ports, err := parsePortSpec(spec)
+			if err != nil {
+				return fmt.Errorf("%s=%q: %v", p.env, spec, err)
+			}
+			p.set(ports)
+		}
+		for _, port := range p.list() {
+			if other, dup := owner[port]; dup {
+				return fmt.Errorf("port %d is in both the %s and %s pools", port, other, p.name)
+			}
+			owner[port] = p.name
+		}
+	}
+	return nil
+}
+
+// portPoolCapacity is how many sessions the pools can serve at once: the size
+// of the smallest pool.
+func portPoolCapacity() int {
+	capacity := -1
+	for _, p := range allPortPools() {
+		if n := len(p.list()); capacity < 0 || n < capacity {
+			capacity = n
+		}
+	}
+	return capacity
+}
+
+// portAssignment is one session's assignment, one port per pool.
+type portAssignment struct {
+	Preview   int `json:"preview"`
+	AgentChat int `json:"agentChat"`
+	Public    int `json:"public"`
+	CDP       int `json:"cdp"`
+	VNC       int `json:"vnc"`
+	Files     int `json:"files"`
+}
+
+// fields returns pointers to the ports in allPortPools order.
+func (pa *portAssignment) fields() []*int {
+	return []*int{&pa.Preview, &pa.AgentChat, &pa.Public, &pa.CDP, &pa.VNC, &pa.Files}
+}
+
+// cdpListenPort is the port the session's local CDP proxy listens on. See
+// remoteCDPProxyOffset: with a remote Agent View it must never share a number
+// with the backend's published CDP range.
+func (pa portAssignment) cdpListenPort() int {
+	if agentViewRemote() {
+		return pa.CDP + remoteCDPProxyOffset
+	}
+	return pa.CDP
+}
+
+type portReservation struct {
+	portAssignment
+	since time.Time
+}
+
+type rememberedPorts struct {
+	Ports    portAssignment `json:"ports"`
+	LastUsed time.Time      `json:"lastUsed"`
+}
+
+// portAlloc holds the live reservations and the remembered assignments.
+// Lock order: sessionsMu before portAlloc.mu.
+var portAlloc = struct {
+	mu         sync.Mutex
+	held       map[string]portReservation
+	remembered map[string]rememberedPorts
+	loadedFrom string // ports.json path remembered was read from
+}{held: map[string]portReservation{}}
+
+func portMemoryPath() string { return filepath.Join(recordingsDir, "ports.json") }
+
+// loadRememberedPortsLocked reads ports.json the first time it is needed.
+// Must be called while holding portAlloc.mu.
+func loadRememberedPortsLocked() {
+	path := portMemoryPath()
+	if portAlloc.remembered != nil && portAlloc.loadedFrom == path {
+		return
+	}
+	portAlloc.remembered, portAlloc.loadedFrom = map[string]rememberedPorts{}, path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &portAlloc.remembered); err != nil {
+		log.Printf("Warning: ignoring unreadable %s: %v", path, err)
+		portAlloc.remembered = map[string]rememberedPorts{}
+	}
+}
+
+// saveRememberedPortsLocked writes ports.json, dropping assignments not used
+// for portMemoryTTL. Must be called while holding portAlloc.mu.
+func saveRememberedPortsLocked(now time.Time) {
+	for id, r := range portAlloc.remembered {
+		if _, held := portAlloc.held[id]; !held && now.Sub(r.LastUsed) > portMemoryTTL {
+			delete(portAlloc.remembered, id)
+		}
+	}
+	data, err := json.MarshalIndent(portAlloc.remembered, "", "  ")
+	if err == nil {
+		err = atomicWriteFile(portAlloc.loadedFrom, data, 0644)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save session port assignments: %v", err)
+	}
+}
+
+// reserveSessionPorts reserves one port from each pool for sessionUUID,
+// reusing the UUID's remembered ports when they are all still free. Calling
+// it again for a UUID that holds a reservation returns that reservation.
+func reserveSessionPorts(sessionUUID string) (portAssignment, error) {
+	portAlloc.mu.Lock()
+	defer portAlloc.mu.Unlock()
+	if r, ok := portAlloc.held[sessionUUID]; ok {
+		return r.portAssignment, nil
+	}
+	loadRememberedPortsLocked()
+
+	pools := allPortPools()
+	inUse := make([]map[int]bool, len(pools))
+	claimed := make([]map[int]bool, len(pools)) // remembered by other sessions
+	for i := range pools {
+		inUse[i], claimed[i] = map[int]bool{}, map[int]bool{}
+	}
+	for _, r := range portAlloc.held {
+		for i, port := range r.fields() {
+			inUse[i][*port] = true
+		}
+	}
+	for id, r := range portAlloc.remembered {
+		if id != sessionUUID {
+			for i, port := range r.Ports.fields() {
+				claimed[i][*port] = true
+			}
+		}
+	}
+
+	var ports portAssignment
+	if r, ok := portAlloc.remembered[sessionUUID]; ok {
+		ports = r.Ports
+		for i, port := range ports.fields() {
+			if pools[i].index(*port) < 0 || inUse[i][*port] {
+				ports = portAssignment{}
+				break
+			}
+		}
+	}
+	if ports.Preview == 0 {
+		for i, field := range ports.fields() {
+			*field = pickFreePort(pools[i].list(), inUse[i], claimed[i])
+			if *field == 0 {
+				return portAssignment{}, fmt.Errorf("%w in the %s pool %s (%d in use)",
+					errPortsExhausted, pools[i].name, describePortPool(pools[i]), len(inUse[i]))
+			}
+		}
+	}
+
+	now := time.Now()
+	portAlloc.held[sessionUUID] = portReservation{portAssignment: ports, since: now}
+	portAlloc.remembered[sessionUUID] = rememberedPorts{Ports: ports, LastUsed: now}
+	saveRememberedPortsLocked(now)
+	return ports, nil
+}
+
+// pickFreePort returns the lowest free port no other session remembers, else
+// the lowest free port, else 0.
+func pickFreePort(ports []int, inUse, claimed map[int]bool) int {
+	fallback := 0
+	for _, port := range ports {
+		if inUse[port] {
+			continue
+		}
+		if !claimed[port] {
+			return port
+		}
+		if fallback == 0 {
+			fallback = port
+		}
+	}
+	return fallback
+}
+
+func describePortPool(p *portPool) string {
+	ports := p.list()
+	if len(ports) == 0 {
+		return "(empty)"
+	}
+	if ports[len(ports)-1]-ports[0] == len(ports)-1 {
+		return fmt.Sprintf("%d-%d", ports[0], ports[len(ports)-1])
+	}
+	return fmt.Sprintf("of %d ports", len(ports))
+}
+
+// releaseSessionPorts returns sessionUUID's ports to the pools. The
+// assignment stays remembered for the next session with that UUID. Releasing
+// a UUID with no reservation (a child session) does nothing.
+func releaseSessionPorts(sessionUUID string) {
+	portAlloc.mu.Lock()
+	defer portAlloc.mu.Unlock()
+	if _, ok := portAlloc.held[sessionUUID]; !ok {
+		return
+	}
+	delete(portAlloc.held, sessionUUID)
+	now := time.Now()
+	if r, ok := portAlloc.remembered[sessionUUID]; ok {
+		r.LastUsed = now
+		portAlloc.remembered[sessionUUID] = r
+	}
+	saveRememberedPortsLocked(now)
+}
+
+// reclaimLeakedPorts releases reservations held for longer than
+// portLeakGrace by sessions no longer in the sessions map, logging each: a
+// leak means some path removed a session without releasing its ports.
+// Returns the UUIDs it reclaimed.
+func reclaimLeakedPorts() []string {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	portAlloc.mu.Lock()
+	var leaked []string
+	now := time.Now()
+	for id, r := range portAlloc.held {
+		if _, live := sessions[id]; live || now.Sub(r.since) < portLeakGrace {
+			continue
+		}
+		log.Printf("Port leak: session %s left ports %+v reserved without a live session; releasing", id, r.portAssignment)
+		leaked = append(leaked, id)
+	}
+	portAlloc.mu.Unlock()
+	for _, id := range leaked {
+		releaseSessionPorts(id)
+	}
+	return leaked
+}
@@ -9,8 +9,13 @@ import {
     OPCODE_RESIZE,
     OPCODE_FILE_UPLOAD,
     OPCODE_CHUNK,
+    OPCODE_IMAGE_PASTE,
+    OPCODE_SEQUENCED_INPUT,
+    PROTOCOL_VERSION,
     encodeResize,
     encodeFileUpload,
+    encodeImagePaste,
+    encodeSequencedInput,
     isChunkMessage,
     decodeChunkHeader,
     parseServerMessage
@@ -29,6 +34,14 @@ test('OPCODE_CHUNK is 0x02', () => {
     assert.strictEqual(OPCODE_CHUNK, 0x02);
 });
 
+test('OPCODE_IMAGE_PASTE is 0x03', () => {
+    assert.strictEqual(OPCODE_IMAGE_PASTE, 0x03);
+});
+
+test('PROTOCOL_VERSION is a positive integer', () => {
+    assert.ok(Number.isInteger(PROTOCOL_VERSION) && PROTOCOL_VERSION > 0);
+});
+
 // encodeResize tests
 test('encodeResize returns 5-byte message', () => {
     const msg = encodeResize(24, 80);
@@ -246,3 +259,32 @@ test('parseServerMessage handles unicode', () => {
     const result = parseServerMessage('{"name":"日本語"}');
     assert.deepStrictEqual(result, { name: '日本語' });
 });
+
+// encodeImagePaste tests
+test('encodeImagePaste lays out opcode, type length, type, data', () => {
+    const data = new Uint8Array([0x89, 0x50, 0x4e, 0x47]);
+    const msg = encodeImagePaste('image/png', data);
+    assert.strictEqual(msg[0], OPCODE_IMAGE_PASTE);
+    assert.strictEqual(msg[1], 9);
+    assert.strictEqual(new TextDecoder().decode(msg.slice(2, 11)), 'image/png');
+    assert.deepStrictEqual(Array.from(msg.slice(11)), [0x89, 0x50, 0x4e, 0x47]);
+});
+
+test('encodeImagePaste allows an empty content type', () => {
+    const msg = encodeImagePaste('', new Uint8Array([1]));
+    assert.strictEqual(msg[1], 0);
+    assert.strictEqual(msg.length, 3);
+});
+
+// encodeSequencedInput tests
+test('encodeSequencedInput lays out opcode, big-endian seq, frame', () => {
+    const msg = encodeSequencedInput(0x01020304, new Uint8Array([0x6c, 0x73]));
+    assert.strictEqual(msg[0], OPCODE_SEQUENCED_INPUT);
+    assert.deepStrictEqual(Array.from(msg.slice(1, 5)), [1, 2, 3, 4]);
+    assert.deepStrictEqual(Array.from(msg.slice(5)), [0x6c, 0x73]);
+});
+
+test('encodeSequencedInput handles seq above 2^31', () => {
+    const msg = encodeSequencedInput(0xFFFFFFFE, new Uint8Array(0));
+    assert.deepStrictEqual(Array.from(msg), [0x04, 0xFF, 0xFF, 0xFF, 0xFE]);
+});
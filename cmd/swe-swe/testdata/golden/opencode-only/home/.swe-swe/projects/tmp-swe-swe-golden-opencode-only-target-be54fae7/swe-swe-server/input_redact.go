@@ -0,0 +1,220 @@
+// input_redact.go -- keep passwords out of recordings.
+//
+// A recording's .input file holds every byte typed into the session, so a
+// password typed at a sudo, ssh or `gh auth login` prompt would be stored in
+// the clear. Input is masked -- each byte but Enter replaced with
+// inputRedactMask, so the .timing byte counts still line up -- when either
+//
+//   - the terminal is not echoing: the PTY has ECHO off and ICANON on, which
+//     is what getpass(3) sets (ptyEchoOff; Linux only). Full-screen programs,
+//     the agents included, turn ICANON off too and are not masked; or
+//   - the output just before it ends in a password prompt
+//     (passwordPromptRe), which also catches a remote prompt over ssh, where
+//     the local terminal is in raw mode.
+//
+// Masking lasts until Enter. The chapter TOC skips masked prompts.
+//
+// The recorder masks live. Recordings made before this are masked once, by
+// re-running the same rules over their .timing, .log and .input files after
+// the session ends (redactEndedRecordingInputs). Either way the .timing file
+// then carries an "H 0.000000 INPUT_REDACTED 1" line.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	inputRedactMask = '*'
+	// inputRedactedHeader marks a recording whose input has been masked.
+	inputRedactedHeader = "H 0.000000 INPUT_REDACTED 1\n"
+	// passwordPromptTail is how much recent output is kept to look for a
+	// prompt.
+	passwordPromptTail = 256
+)
+
+// passwordPromptRe matches the last line of output at a password prompt:
+// "[sudo] password for alice: ", "Enter passphrase for key '...': ",
+// "? Paste your authentication token: ".
+var passwordPromptRe = regexp.MustCompile(`(?i)\b(password|passphrase|passcode|pin|token|secret)\b[^:\n]{0,80}:\s*$`)
+
+// inputRedactor decides, byte by byte, which input to mask.
+type inputRedactor struct {
+	tail   []byte // recent output
+	secret bool   // masking until Enter
+}
+
+// output notes bytes the session wrote.
+func (x *inputRedactor) output(p []byte) {
+	x.tail = append(x.tail, p...)
+	if len(x.tail) > passwordPromptTail {
+		x.tail = append(x.tail[:0], x.tail[len(x.tail)-passwordPromptTail:]...)
+	}
+}
+
+// input returns p as it should be recorded. noEcho is set when the terminal
+// was not echoing p.
+func (x *inputRedactor) input(p []byte, noEcho bool) []byte {
+	if atPasswordPrompt(x.tail) {
+		x.secret = true
+	}
+	if !x.secret && !noEcho {
+		return p
+	}
+	masked := bytes.Clone(p)
+	for i, b := range masked {
+		if b == '\r' || b == '\n' {
+			x.secret = false
+			x.tail = x.tail[:0]
+			continue
+		}
+		if x.secret || noEcho {
+			masked[i] = inputRedactMask
+		}
+	}
+	return masked
+}
+
+// atPasswordPrompt reports whether output ends with a password prompt.
+func atPasswordPrompt(output []byte) bool {
+	clean := ansiEscapeRe.ReplaceAll(output, nil)
+	if i := bytes.LastIndexAny(clean, "\r\n"); i >= 0 {
+		clean = clean[i+1:]
+	}
+	return passwordPromptRe.Match(clean)
+}
+
+// redactedText reports whether a prompt's text is all mask.
+func redactedText(s string) bool {
+	return s != "" && strings.Trim(s, string(inputRedactMask)) == ""
+}
+
+// redactEndedRecordingInputs masks the input of ended recordings that were
+// made before the recorder masked it live.
+func redactEndedRecordingInputs(entries []os.DirEntry, activeRecordings map[string]bool) {
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "session-") || !strings.HasSuffix(name, ".input") {
+			continue
+		}
+		prefix := strings.TrimSuffix(name, ".input")
+		parentUUID, _, ok := parseRecordingFilename(strings.TrimPrefix(prefix, "session-"))
+		if !ok || activeRecordings[parentUUID] {
+			continue
+		}
+		if err := redactRecordingInput(prefix); err != nil {
+			log.Printf("Failed to redact input of %s: %v", prefix, err)
+		}
+	}
+}
+
+// redactRecordingInput masks {prefix}.input by replaying its .timing against
+// its .log, unless it is marked as done.
+func redactRecordingInput(prefix string) error {
+	base := recordingPath(prefix)
+	timingPath := base + ".timing"
+	if done, err := inputRedacted(timingPath); err != nil || done {
+		return err
+	}
+	logPath := resolveLogPath(prefix)
+	if logPath == "" {
+		return nil
+	}
+	input, err := os.ReadFile(base + ".input")
+	if err != nil {
+		return err
+	}
+	timing, err := os.Open(timingPath)
+	if err != nil {
+		return err
+	}
+	defer timing.Close()
+	logReader, err := openLogReader(logPath)
+	if err != nil {
+		return err
+	}
+	defer logReader.Close()
+
+	// Both files start with the "Script started on" header, which the
+	// timing file does not count.
+	out := bufio.NewReader(logReader)
+	out.ReadString('\n')
+	body := stripInputHeader(input)
+	headerLen := len(input) - len(body)
+	redacted := bytes.Clone(input)
+
+	var x inputRedactor
+	changed := false
+	pos := 0
+	scanner := bufio.NewScanner(timing)
+	for scanner.Scan() {
+		typ, _, n, ok := parseTimingLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch typ {
+		case 'O':
+			chunk := make([]byte, n)
+			got, _ := io.ReadFull(out, chunk)
+			x.output(chunk[:got])
+		case 'I':
+			end := min(pos+n, len(body))
+			masked := x.input(body[pos:end], false)
+			if !bytes.Equal(masked, body[pos:end]) {
+				copy(redacted[headerLen+pos:], masked)
+				changed = true
+			}
+			pos = end
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if changed {
+		tmp := base + ".input.tmp"
+		if err := os.WriteFile(tmp, redacted, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, base+".input"); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		log.Printf("Redacted password input in %s", prefix)
+	}
+	f, err := os.OpenFile(timingPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(inputRedactedHeader)
+	return err
+}
+
+// inputRedacted reports whether the timing file's last lines mark its input
+// as masked.
+func inputRedacted(timingPath string) (bool, error) {
+	f, err := os.Open(timingPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil // nothing to pair the input with
+		}
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	tail := make([]byte, min(info.Size(), 256))
+	if _, err := f.ReadAt(tail, info.Size()-int64(len(tail))); err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.Contains(tail, []byte(strings.TrimSuffix(inputRedactedHeader, "\n"))), nil
+}
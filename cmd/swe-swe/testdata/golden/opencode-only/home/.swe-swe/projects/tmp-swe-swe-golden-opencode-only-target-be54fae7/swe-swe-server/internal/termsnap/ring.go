@@ -0,0 +1,120 @@
+// Package termsnap is the terminal side of a session's output pipeline,
+// kept apart from Session and its locks so it can be fuzzed and benchmarked:
+//
+//   - Ring, the scrollback a joining or resuming browser is replayed from;
+//   - ANSI and Text, which render the emulator's screen (the snapshot a
+//     joining browser draws), and Compress, which gzips either for sending;
+//   - Tracked, Copy and Cache, which let the snapshot be rendered from a
+//     copy outside the lock and shared by browsers joining at once;
+//   - DSRWatcher and the replies the server answers terminal queries with.
+//
+// Only Cache locks: the session calls the rest under its vtMu. The chunk frames
+// the compressed payloads are split into are wsproto.Chunks.
+package termsnap
+
+// DefaultRingSize is the capacity of a zero Ring.
+const DefaultRingSize = 512 * 1024
+
+// ringMinAlloc is the first allocation of a ring that grows: most sessions
+// never write anywhere near the capacity. A variable for the tests.
+var ringMinAlloc = 4 * 1024
+
+// Ring keeps the last bytes of a stream, and counts every byte written so a
+// reader can ask for what came after an offset it saw. The zero value holds
+// DefaultRingSize bytes. Its storage grows with what is written, doubling up
+// to the capacity, and only wraps around once it is that large.
+type Ring struct {
+	size  int    // capacity; 0 means DefaultRingSize
+	buf   []byte // while shorter than the capacity, what it holds is buf[:n]
+	head  int    // where the next byte goes
+	n     int    // bytes held, up to len(buf)
+	total int64  // bytes ever written
+}
+
+// NewRing returns a Ring holding the last size bytes.
+func NewRing(size int) *Ring {
+	return &Ring{size: size}
+}
+
+// Cap is how many bytes the ring holds at most.
+func (r *Ring) Cap() int {
+	if r.size > 0 {
+		return r.size
+	}
+	return DefaultRingSize
+}
+
+// Write appends p, dropping the oldest bytes once the ring is full. It never
+// fails. Each write is at most two copies, whatever its length.
+func (r *Ring) Write(p []byte) (int, error) {
+	r.total += int64(len(p))
+	written := len(p)
+	size := r.Cap()
+	if len(r.buf) < size && r.n+len(p) > len(r.buf) {
+		r.grow(min(size, max(2*len(r.buf), r.n+len(p), ringMinAlloc)))
+	}
+	if len(p) >= size {
+		// Only the last size bytes survive.
+		copy(r.buf, p[len(p)-size:])
+		r.head, r.n = 0, size
+		return written, nil
+	}
+	k := copy(r.buf[r.head:], p)
+	copy(r.buf, p[k:])
+	r.head = (r.head + len(p)) % len(r.buf)
+	r.n = min(r.n+len(p), len(r.buf))
+	return written, nil
+}
+
+// grow moves the ring's bytes into a buffer of size bytes. The ring has not
+// wrapped, so they are buf[:n].
+func (r *Ring) grow(size int) {
+	buf := make([]byte, size)
+	copy(buf, r.buf[:r.n])
+	r.buf, r.head = buf, r.n
+}
+
+// Len is how many bytes the ring holds.
+func (r *Ring) Len() int { return r.n }
+
+// Total is how many bytes were ever written: the offset of the next byte.
+func (r *Ring) Total() int64 { return r.total }
+
+// Bytes returns a copy of what the ring holds, oldest first, or nil when it
+// is empty.
+func (r *Ring) Bytes() []byte {
+	if r.n == 0 {
+		return nil
+	}
+	out := make([]byte, r.n)
+	if r.n < len(r.buf) {
+		copy(out, r.buf[:r.n])
+	} else {
+		k := copy(out, r.buf[r.head:])
+		copy(out[k:], r.buf[:r.head])
+	}
+	return out
+}
+
+// Since returns what was written after offset, and false when the ring no
+// longer holds all of it (or offset is past Total).
+func (r *Ring) Since(offset int64) ([]byte, bool) {
+	if offset < r.total-int64(r.n) || offset > r.total {
+		return nil, false
+	}
+	b := r.Bytes()
+	return b[len(b)-int(r.total-offset):], true
+}
+
+// RingStats describes a ring's memory use.
+type RingStats struct {
+	Held      int   // bytes held
+	Allocated int   // bytes of storage allocated
+	Capacity  int   // most bytes it will hold
+	Written   int64 // bytes ever written
+}
+
+// Stats reports the ring's use.
+func (r *Ring) Stats() RingStats {
+	return RingStats{Held: r.n, Allocated: len(r.buf), Capacity: r.Cap(), Written: r.total}
+}
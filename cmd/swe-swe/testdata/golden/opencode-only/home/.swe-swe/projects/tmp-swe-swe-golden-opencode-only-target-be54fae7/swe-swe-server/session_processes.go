@@ -0,0 +1,278 @@
+// session_processes.go -- show a session's process tree and signal one
+// process in it.
+//
+// A hung `npm install` the agent started used to leave two choices: ask the
+// agent (which is waiting on it) or end the session. GET
+// /api/session/{uuid}/processes lists what the session runs, read from /proc:
+// the agent's process and, when one runs, the preview app
+// (session_preview_app.go), each with its descendants:
+//
+//	{"processes": [{"pid": 812, "ppid": 1, "cmd": "claude", "state": "S",
+//	  "cpu": 0.5, "rss": 183500800, "children": [{"pid": 990, "ppid": 812,
+//	  "cmd": "npm install", "state": "R", "cpu": 98.7, "rss": 402653184}]}]}
+//
+// cpu is the percentage of one core used over a short sample
+// (processCPUSample); rss is in bytes. POST /api/session/{uuid}/signal with
+// {"pid": 990, "signal": "TERM"} signals one descendant. Only processes in
+// the session's tree qualify, and not the tree's roots: ending the agent is
+// what restart and end are for, and the preview app has its own stop. The
+// signal is one of signalNames, TERM when left out. For a session on a
+// remote backend the tree is that of the local client (docker exec, kubectl,
+// ssh) that runs it.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processCPUSample is how long CPU time is measured for the listing.
+const processCPUSample = 200 * time.Millisecond
+
+// clockTicks is USER_HZ, the unit of /proc/{pid}/stat's CPU times; it is 100
+// on every Linux we run on.
+const clockTicks = 100
+
+// signalNames are the signals POST .../signal sends, by name.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"STOP": syscall.SIGSTOP,
+	"CONT": syscall.SIGCONT,
+}
+
+// processInfo is one process in a session's tree.
+type processInfo struct {
+	PID      int            `json:"pid"`
+	PPID     int            `json:"ppid"`
+	Command  string         `json:"cmd"`
+	State    string         `json:"state"` // as in ps: R, S, D, Z, T...
+	CPU      float64        `json:"cpu"`   // percent of one core
+	RSS      int64          `json:"rss"`   // bytes
+	Children []*processInfo `json:"children,omitempty"`
+}
+
+// procStat is what /proc/{pid}/stat says about a process.
+type procStat struct {
+	comm     string
+	state    string
+	ppid     int
+	ticks    int64 // user + system CPU time
+	rssPages int64
+}
+
+// readProcStat parses /proc/{pid}/stat.
+func readProcStat(pid int) (procStat, bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, false
+	}
+	// pid (comm) state ppid ...; comm can hold spaces and ')', so split at
+	// the last ')'.
+	s := string(data)
+	lp, rp := strings.Index(s, "("), strings.LastIndex(s, ")")
+	if lp < 0 || rp < lp || rp+2 >= len(s) {
+		return procStat{}, false
+	}
+	fields := strings.Fields(s[rp+2:])
+	if len(fields) < 22 {
+		return procStat{}, false
+	}
+	st := procStat{comm: s[lp+1 : rp], state: fields[0]}
+	st.ppid, _ = strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	st.ticks = utime + stime
+	st.rssPages, _ = strconv.ParseInt(fields[21], 10, 64)
+	return st, true
+}
+
+// processCommand is the process's command line, or its comm when it has
+// none (kernel threads, zombies).
+func processCommand(pid int, comm string) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if cmd := strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " ")); err == nil && cmd != "" {
+		return cmd
+	}
+	return comm
+}
+
+// processRoots returns the PIDs the session's tree hangs from: the agent's
+// process while it runs, then the preview app's.
+func (s *Session) processRoots() []int {
+	var roots []int
+	s.mu.RLock()
+	if s.Cmd != nil && s.Cmd.Process != nil && s.Cmd.ProcessState == nil {
+		roots = append(roots, s.Cmd.Process.Pid)
+	}
+	s.mu.RUnlock()
+	if pid := s.previewAppSnapshot(false).PID; pid != 0 {
+		roots = append(roots, pid)
+	}
+	return roots
+}
+
+// processTree reads the trees under roots, sampling CPU use over sample.
+func processTree(roots []int, sample time.Duration) []*processInfo {
+	nodes := map[int]*processInfo{}
+	ticks := map[int]int64{}
+	for _, root := range roots {
+		for _, pid := range append([]int{root}, collectDescendantPIDs(root)...) {
+			st, ok := readProcStat(pid)
+			if !ok {
+				continue
+			}
+			nodes[pid] = &processInfo{PID: pid, PPID: st.ppid, Command: processCommand(pid, st.comm), State: st.state,
+				RSS: st.rssPages * int64(os.Getpagesize())}
+			ticks[pid] = st.ticks
+		}
+	}
+	if sample > 0 && len(nodes) > 0 {
+		time.Sleep(sample)
+		for pid, p := range nodes {
+			if st, ok := readProcStat(pid); ok {
+				used := float64(st.ticks-ticks[pid]) / clockTicks / sample.Seconds() * 100
+				p.CPU = math.Round(used*10) / 10
+				p.State = st.state
+			}
+		}
+	}
+
+	var tree []*processInfo
+	for _, root := range roots {
+		if p := nodes[root]; p != nil {
+			tree = append(tree, p)
+		}
+	}
+	for pid, p := range nodes {
+		if parent := nodes[p.PPID]; parent != nil && !containsInt(roots, pid) {
+			parent.Children = append(parent.Children, p)
+		}
+	}
+	for _, p := range nodes {
+		sort.Slice(p.Children, func(i, j int) bool { return p.Children[i].PID < p.Children[j].PID })
+	}
+	return tree
+}
+
+// containsInt reports whether v is in list.
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSignalName returns the name and signal for "TERM", "SIGTERM" or
+// "term"; "" is TERM.
+func parseSignalName(name string) (string, syscall.Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	if name == "" {
+		name = "TERM"
+	}
+	if sig, ok := signalNames[name]; ok {
+		return name, sig, nil
+	}
+	return "", 0, fmt.Errorf("unknown signal %q", name)
+}
+
+var (
+	errNotSessionProcess = errors.New("not a process of this session")
+	errSessionRootPID    = errors.New("the session's own process; restart or end the session, or stop the preview app, instead")
+)
+
+// signalProcess sends sig to pid after checking that pid is a descendant of
+// one of the session's roots.
+func (s *Session) signalProcess(pid int, sig syscall.Signal) error {
+	if pid <= 1 || pid == os.Getpid() {
+		return errNotSessionProcess
+	}
+	roots := s.processRoots()
+	if containsInt(roots, pid) {
+		return errSessionRootPID
+	}
+	found := false
+	for _, root := range roots {
+		if containsInt(collectDescendantPIDs(root), pid) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errNotSessionProcess
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// handleSessionProcessesAPI handles GET /api/session/{uuid}/processes and
+// POST /api/session/{uuid}/signal.
+func handleSessionProcessesAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/session/")
+	sessionUUID, sub, _ := strings.Cut(rest, "/")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case sub == "processes" && r.Method == http.MethodGet:
+		tree := processTree(sess.processRoots(), processCPUSample)
+		if tree == nil {
+			tree = []*processInfo{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"processes": tree})
+	case sub == "signal" && r.Method == http.MethodPost:
+		var req struct {
+			PID    int    `json:"pid"`
+			Signal string `json:"signal"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		name, sig, err := parseSignalName(req.Signal)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = sess.signalProcess(req.PID, sig)
+		switch {
+		case errors.Is(err, errNotSessionProcess), errors.Is(err, errSessionRootPID):
+			http.Error(w, fmt.Sprintf("pid %d: %v", req.PID, err), http.StatusForbidden)
+			return
+		case errors.Is(err, syscall.ESRCH):
+			http.Error(w, fmt.Sprintf("pid %d has exited", req.PID), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Session %s: sent SIG%s to pid %d (requested by %q)", sess.UUID, name, req.PID, requestIdentity(r).User)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"pid": req.PID, "signal": name})
+	case sub == "processes" || sub == "signal":
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
@@ -31,6 +31,7 @@
     var whereCombo = document.getElementById('where-combo');
     var branchCombo = document.getElementById('branch-combo');
     var extraArgsInput = document.getElementById('new-session-extra-args');
+    var setupSelect = document.getElementById('new-session-setup');
 
     // Derive a short "org/repo" label from a git remote URL for the Where
     // dropdown's primary line (the full URL rides along as the detail line).
@@ -84,6 +85,8 @@
         sessionColor: '',
         whereKey: '',
         extraArgs: '',
+        // Repo setup task mode: 'auto' | 'force' | 'skip' (session_setup.go).
+        setup: 'auto',
         // init_sha of the selected repo (from /api/repo/branches). Used to
         // locate this repo's env-vars blob in localStorage so it can ride the
         // creation POST and reach the new session's process before it spawns.
@@ -188,6 +191,7 @@
         branchInput.value = '';
         branchInput.disabled = true;
         branchList.innerHTML = '';
+        previewBranchName('');
         setBranchPlaceholder(DEFAULT_BRANCH_PLACEHOLDER);
         if (branchCombo) {
             branchCombo.value = '';
@@ -221,9 +225,11 @@
         dialogState.sessionColor = '';
         dialogState.whereKey = '';
         dialogState.extraArgs = '';
+        dialogState.setup = 'auto';
         dialogState.initSha = '';
         dialogState.prefillName = '';
         if (extraArgsInput) extraArgsInput.value = '';
+        if (setupSelect) setupSelect.value = 'auto';
 
         // Reset color picker
         if (window.sweSweTheme) {
@@ -474,6 +480,7 @@
             branchInput.value = prefill.branch;
             if (branchCombo) branchCombo.value = prefill.branch;
             dialogState.selectedBranch = prefill.branch;
+            previewBranchName(prefill.branch);
         } else if (prefill.branchHint) {
             // Plain shared-checkout recording: no worktree branch, so the field
             // stays blank (reproducing the shared checkout). Surface the branch
@@ -861,6 +868,64 @@
         dialogState.selectedBranch = branchInput.value.trim();
     });
 
+    // Live branch preview (GET /api/branch/preview, branch_preview.go): what
+    // the typed text becomes as a branch, where the session would run, and
+    // what is already there. Debounced; an answer for older text is dropped.
+    var branchPreview = document.getElementById('branch-preview');
+    var branchPreviewTimer = null;
+    var branchPreviewText = '';
+    function previewBranchName(text) {
+        text = (text || '').trim();
+        branchPreviewText = text;
+        clearTimeout(branchPreviewTimer);
+        if (!branchPreview) return;
+        if (!text) {
+            branchPreview.textContent = '';
+            branchPreview.style.display = 'none';
+            return;
+        }
+        branchPreviewTimer = setTimeout(function() {
+            var q = 'name=' + encodeURIComponent(text);
+            if (dialogState.repoPath) q += '&pwd=' + encodeURIComponent(dialogState.repoPath);
+            fetch('/api/branch/preview?' + q)
+                .then(function(resp) { return resp.ok ? resp.json() : null; })
+                .catch(function() { return null; })
+                .then(function(preview) {
+                    if (text !== branchPreviewText) return;
+                    var msg = branchPreviewMessage(preview);
+                    branchPreview.textContent = msg;
+                    branchPreview.style.display = msg ? 'block' : 'none';
+                });
+        }, 250);
+    }
+
+    function branchPreviewMessage(p) {
+        if (!p) return '';
+        if (!p.valid) return 'Not a usable branch name: ' + p.error;
+        var msg = '';
+        if (p.changed) {
+            msg = 'Branch: ' + p.branch + (p.changes && p.changes.length ? ' (' + p.changes.join(', ') + ')' : '') + '. ';
+        }
+        switch (p.conflict) {
+            case 'current': return msg + 'Already checked out; the session runs in ' + p.work_dir + '.';
+            case 'worktree': return msg + 'Reuses the existing worktree at ' + p.work_dir + '.';
+            case 'local': return msg + 'Existing branch, checked out at ' + p.work_dir + '.';
+            case 'remote': return msg + 'Tracks origin/' + p.branch + ', checked out at ' + p.work_dir + '.';
+        }
+        return msg + 'New branch, checked out at ' + p.work_dir + '.';
+    }
+
+    branchInput.addEventListener('input', function() {
+        previewBranchName(branchInput.value);
+    });
+    // Typing in the combo happens in its shadow DOM; the input event is
+    // composed, so it reaches the host with the real input first in its path.
+    if (branchCombo) {
+        branchCombo.addEventListener('input', function(e) {
+            previewBranchName(e.composedPath()[0].value);
+        });
+    }
+
     // Focusing the branch combo used to collapse every field below it (Agent,
     // Extra CLI flags, env hint, Start buttons) so the user could not press
     // Start while the free-entry combo still held uncommitted text. That cure
@@ -898,6 +963,12 @@
         dialogState.extraArgs = extraArgsInput.value;
     });
 
+    if (setupSelect) {
+        setupSelect.addEventListener('change', function() {
+            dialogState.setup = setupSelect.value;
+        });
+    }
+
     agentsContainer.addEventListener('click', function(e) {
         selectAgent(e.target.closest('.dialog__agent'));
     });
@@ -932,6 +1003,7 @@
         }
         if (dialogState.debug) p.set('debug', '1');
         if (dialogState.extraArgs) p.set('extra_args', dialogState.extraArgs);
+        if (dialogState.setup && dialogState.setup !== 'auto') p.set('setup', dialogState.setup);
 
         // color is CSS-only (not read by server), append after canonical params
         if (dialogState.sessionColor) {
@@ -961,6 +1033,84 @@
             branchCombo.commit();
         }
         if (!dialogState.selectedAgent) { showError('Please select an agent'); return; }
+        // Check session limits first: the POST is a navigation, so a refusal
+        // there would replace the dialog with a bare error. If the check itself
+        // fails, submit anyway -- the server enforces the limits regardless.
+        fetch('/api/sessions/live')
+            .then(function(resp) { return resp.ok ? resp.json() : null; })
+            .catch(function() { return null; })
+            .then(function(data) {
+                var refusal = sessionLimitMessage(data && data.capacity, dialogState.selectedAgent);
+                if (refusal) { showError(refusal); return; }
+                return sessionNameRefusal(buildSessionParams(sessionMode).get('name')).then(function(refusal) {
+                    if (refusal) { showError(refusal); return; }
+                    return agentLoggedOutMessage(dialogState.selectedAgent).then(function(loggedOut) {
+                        if (!loggedOut) { submitSession(sessionMode, false); return; }
+                        markAgentLoggedOut(dialogState.selectedAgent, true);
+                        if (confirm(loggedOut + '\n\nStart anyway?')) submitSession(sessionMode, true);
+                    });
+                });
+            });
+    }
+
+    // Why the session name may not be used, or '' when it may. Only refuses
+    // under SWE_SESSION_NAMES=reject; "suffix" renames on the server.
+    // Mirrors sessionNameError's wording in session_names.go.
+    function sessionNameRefusal(name) {
+        if (!name) return Promise.resolve('');
+        return fetch('/api/session/name-check?name=' + encodeURIComponent(name))
+            .then(function(resp) { return resp.ok ? resp.json() : null; })
+            .catch(function() { return null; })
+            .then(function(check) {
+                if (!check || check.allowed !== false) return '';
+                return 'A session named "' + check.name + '" is already running. Pick another name' +
+                    (check.suggestion ? ', such as "' + check.suggestion + '".' : '.');
+            });
+    }
+
+    // Why the agent may open on its login screen, or '' when it looks
+    // logged in. The server runs the check afresh (assistant_auth.go), so
+    // an agent logged in since the page loaded is not flagged. The POST is
+    // refused without auth_ack, which submitSession sets once the user
+    // chooses to start anyway.
+    function agentLoggedOutMessage(agent) {
+        return fetch('/api/session/auth-check?assistant=' + encodeURIComponent(agent))
+            .then(function(resp) { return resp.ok ? resp.json() : null; })
+            .catch(function() { return null; })
+            .then(function(check) {
+                if (check) markAgentLoggedOut(agent, check.auth === 'logged_out');
+                return check && check.auth === 'logged_out' ? check.message : '';
+            });
+    }
+
+    function markAgentLoggedOut(agent, loggedOut) {
+        var label = agentsContainer.querySelector('[data-agent="' + agent + '"]');
+        if (label) label.classList.toggle('dialog__agent--logged-out', loggedOut);
+    }
+
+    // The reason no session of this agent may start, or '' when one may.
+    // Mirrors sessionLimitError's wording in session_limits.go.
+    function sessionLimitMessage(capacity, agent) {
+        if (!capacity) return '';
+        var mine = capacity.assistants && capacity.assistants[agent];
+        if (mine && mine.limit && mine.remaining === 0 && mine.active >= mine.limit) {
+            return mine.active + ' of ' + mine.limit + ' ' + agentDisplayName(agent) +
+                ' sessions are already running. End one to start another.';
+        }
+        var all = capacity.global;
+        if (all && all.remaining === 0) {
+            return all.active + ' of ' + all.limit + ' sessions are already running. End one to start another.';
+        }
+        return '';
+    }
+
+    function agentDisplayName(agent) {
+        var name = agentsContainer.querySelector('[data-agent="' + agent + '"] .dialog__agent-name');
+        return name ? name.textContent : agent;
+    }
+
+    // authAck starts the session even though its agent is not logged in.
+    function submitSession(sessionMode, authAck) {
         // Record this repo as most-recently-used so it sorts to the top of the
         // Where dropdown next time (per-device recency). repoPath is the
         // resolved local path, matching the dynamic option's value.
@@ -969,6 +1119,7 @@
         var form = document.createElement('form');
         form.method = 'POST';
         form.action = '/api/session/new';
+        if (authAck) params.set('auth_ack', '1');
         params.forEach(function(value, key) {
             var input = document.createElement('input');
             input.type = 'hidden';
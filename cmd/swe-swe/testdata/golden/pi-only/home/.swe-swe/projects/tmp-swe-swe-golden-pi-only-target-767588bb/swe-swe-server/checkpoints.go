@@ -0,0 +1,442 @@
+// checkpoints.go -- automatic git checkpoints of a session's working tree, so
+// a destructive agent action (rm -rf, git reset --hard, a bad rewrite) can
+// be rolled back.
+//
+// SWE_CHECKPOINTS (config key session.checkpoints) turns it on, as a
+// comma-separated list:
+//
+//	prompt    checkpoint whenever a prompt is submitted to the agent (Enter
+//	          after typed text in the terminal, or send_session_input ending
+//	          in a newline) -- the state just before the agent acts on it
+//	5m        checkpoint every 5 minutes (any Go duration, at least 1m)
+//	always    also checkpoint sessions that are not in YOLO mode
+//
+// e.g. SWE_CHECKPOINTS=prompt,10m. Without "always" only sessions in YOLO
+// mode (at the time of the checkpoint) are checkpointed. Shell sub-sessions
+// and working directories outside git are never checkpointed.
+//
+// A checkpoint is a commit of the whole working tree -- tracked changes and
+// untracked files, minus what .gitignore excludes -- built in a private
+// index and stored as refs/swe-swe/checkpoints/{session}/{time}. It moves no
+// branch and touches neither HEAD nor the user's index, so the agent does
+// not see it in `git status` or `git log`. Its parent is the HEAD of the
+// moment, which also keeps commits the agent later discards reachable. A
+// checkpoint identical to the previous one is skipped; the newest
+// checkpointMaxPerSession are kept.
+//
+//	GET  /api/session/{uuid}/checkpoints               -> {"checkpoints": [...]}, newest first
+//	POST /api/session/{uuid}/checkpoints               checkpoint now
+//	POST /api/session/{uuid}/checkpoints/{id}/restore  roll the working tree back
+//
+// Restore first checkpoints the current state (reason "before restore", so a
+// restore can itself be undone), then makes the working tree match the
+// checkpoint: changed and deleted files come back, files created since are
+// removed. HEAD, branches and the staging area are left alone; the response
+// carries the checkpoint's head commit for a `git reset` if wanted.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpointMaxPerSession is how many checkpoints a session keeps.
+const checkpointMaxPerSession = 100
+
+// checkpointGitTimeout bounds each git command (`git add -A` of a large tree
+// is the slow one).
+const checkpointGitTimeout = 2 * time.Minute
+
+// checkpointRefPrefix is where checkpoint refs live, one namespace per session.
+const checkpointRefPrefix = "refs/swe-swe/checkpoints/"
+
+// checkpointConfig is the parsed SWE_CHECKPOINTS.
+type checkpointConfig struct {
+	OnPrompt bool
+	Interval time.Duration
+	Always   bool // not only in YOLO mode
+}
+
+func (c checkpointConfig) enabled() bool { return c.OnPrompt || c.Interval > 0 }
+
+// checkpointSettings is SWE_CHECKPOINTS; zero means off.
+var checkpointSettings checkpointConfig
+
+// loadCheckpoints applies SWE_CHECKPOINTS.
+func loadCheckpoints() error {
+	checkpointSettings = checkpointConfig{}
+	v := strings.TrimSpace(os.Getenv("SWE_CHECKPOINTS"))
+	if v == "" {
+		return nil
+	}
+	cfg, err := parseCheckpointConfig(v)
+	if err != nil {
+		return err
+	}
+	checkpointSettings = cfg
+	log.Printf("Checkpoints from SWE_CHECKPOINTS: %s", v)
+	return nil
+}
+
+func parseCheckpointConfig(v string) (checkpointConfig, error) {
+	var cfg checkpointConfig
+	for _, part := range strings.Split(v, ",") {
+		switch part = strings.TrimSpace(part); part {
+		case "":
+		case "prompt":
+			cfg.OnPrompt = true
+		case "always":
+			cfg.Always = true
+		default:
+			d, err := time.ParseDuration(part)
+			if err != nil || d < time.Minute {
+				return cfg, fmt.Errorf("SWE_CHECKPOINTS=%q: %q is not prompt, always, or a duration of at least 1m", v, part)
+			}
+			cfg.Interval = d
+		}
+	}
+	if !cfg.enabled() {
+		return cfg, fmt.Errorf("SWE_CHECKPOINTS=%q: want prompt and/or an interval", v)
+	}
+	return cfg, nil
+}
+
+// checkpoint is one entry of GET /api/session/{uuid}/checkpoints.
+type checkpoint struct {
+	ID        string    `json:"id"` // abbreviated commit
+	Commit    string    `json:"commit"`
+	Head      string    `json:"head,omitempty"` // HEAD when taken
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+	Ref       string    `json:"ref"`
+}
+
+// sessionCheckpointer checkpoints one session's working directory.
+type sessionCheckpointer struct {
+	sessionUUID string
+	workDir     string
+	cfg         checkpointConfig
+	yolo        func() bool // the session's YOLO mode
+
+	mu       sync.Mutex // serializes git work on the checkpoint refs
+	lastTree string
+	keys     []byte // terminal input since the last Enter, for prompt detection
+	keysMu   sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newSessionCheckpointer returns the checkpointer for a session, or nil when
+// checkpoints are off or workDir is not a git work tree.
+func newSessionCheckpointer(sessionUUID, workDir string, yolo func() bool) *sessionCheckpointer {
+	cfg := checkpointSettings
+	if !cfg.enabled() || workDir == "" {
+		return nil
+	}
+	if out, err := gitInWorkDir(workDir, "rev-parse", "--is-inside-work-tree"); err != nil || strings.TrimSpace(out) != "true" {
+		return nil
+	}
+	c := &sessionCheckpointer{sessionUUID: sessionUUID, workDir: workDir, cfg: cfg, yolo: yolo, stop: make(chan struct{})}
+	if cfg.Interval > 0 {
+		go c.tick()
+	}
+	return c
+}
+
+func (c *sessionCheckpointer) tick() {
+	t := time.NewTicker(c.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			c.auto("interval")
+		}
+	}
+}
+
+// Close stops the interval timer. Checkpoints already taken are kept.
+func (c *sessionCheckpointer) Close() {
+	if c == nil {
+		return
+	}
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// noteInput watches terminal input for a submitted prompt.
+func (c *sessionCheckpointer) noteInput(data []byte) {
+	if c == nil || !c.cfg.OnPrompt || len(data) == 0 {
+		return
+	}
+	c.keysMu.Lock()
+	c.keys = append(c.keys, data...)
+	if len(c.keys) > 8<<10 {
+		c.keys = c.keys[len(c.keys)-8<<10:]
+	}
+	if !submitsPrompt(c.keys) {
+		c.keysMu.Unlock()
+		return
+	}
+	text := promptText(c.keys)
+	c.keys = c.keys[:0]
+	c.keysMu.Unlock()
+	if len([]rune(text)) >= 2 {
+		go c.auto("prompt: " + truncateLabel(text))
+	}
+}
+
+// auto takes a scheduled checkpoint, subject to the YOLO rule.
+func (c *sessionCheckpointer) auto(reason string) {
+	if !c.cfg.Always && !c.yolo() {
+		return
+	}
+	if _, err := c.take(reason, false); err != nil {
+		log.Printf("Session %s: checkpoint failed: %v", c.sessionUUID, err)
+	}
+}
+
+// git runs git in the working directory with extra environment.
+func (c *sessionCheckpointer) git(env []string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointGitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "core.quotePath=false", "-c", "commit.gpgSign=false"}, args...)...)
+	cmd.Dir = c.workDir
+	cmd.Env = append(os.Environ(), env...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// snapshotTree writes the whole working tree to a tree object through a
+// private index seeded from the real one (so unchanged files are not
+// re-hashed). Call with mu held.
+func (c *sessionCheckpointer) snapshotTree() (string, error) {
+	tmp, err := privateIndexPath()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+	if realIndex, err := c.git(nil, "rev-parse", "--git-path", "index"); err == nil {
+		if !filepath.IsAbs(realIndex) {
+			realIndex = filepath.Join(c.workDir, realIndex)
+		}
+		copyFileIfExists(realIndex, tmp)
+	}
+	env := []string{"GIT_INDEX_FILE=" + tmp}
+	if _, err := c.git(env, "add", "-A"); err != nil {
+		return "", err
+	}
+	return c.git(env, "write-tree")
+}
+
+// privateIndexPath returns an unused path for a throwaway index. The file
+// must not exist: git rejects an empty index file.
+func privateIndexPath() (string, error) {
+	f, err := os.CreateTemp("", "swe-swe-checkpoint-*.index")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), os.Remove(f.Name())
+}
+
+func copyFileIfExists(src, dst string) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	io.Copy(out, in)
+}
+
+// take records a checkpoint. Unless force is set, one identical to the
+// previous checkpoint is skipped (nil, nil).
+func (c *sessionCheckpointer) take(reason string, force bool) (*checkpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.takeLocked(reason, force)
+}
+
+func (c *sessionCheckpointer) takeLocked(reason string, force bool) (*checkpoint, error) {
+	tree, err := c.snapshotTree()
+	if err != nil {
+		return nil, err
+	}
+	if tree == c.lastTree && !force {
+		return nil, nil
+	}
+	head := gitHeadCommit(c.workDir)
+	args := []string{"commit-tree", tree, "-m", "swe-swe checkpoint: " + reason}
+	if head != "" {
+		args = append(args, "-p", head)
+	}
+	commit, err := c.git([]string{
+		"GIT_AUTHOR_NAME=swe-swe", "GIT_AUTHOR_EMAIL=swe-swe@localhost",
+		"GIT_COMMITTER_NAME=swe-swe", "GIT_COMMITTER_EMAIL=swe-swe@localhost",
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ref := checkpointRefPrefix + c.sessionUUID + "/" + now.UTC().Format("20060102T150405.000000000Z")
+	if _, err := c.git(nil, "update-ref", ref, commit); err != nil {
+		return nil, err
+	}
+	c.lastTree = tree
+	c.prune()
+	log.Printf("Session %s: checkpoint %s (%s)", c.sessionUUID, commit[:12], reason)
+	return &checkpoint{ID: commit[:12], Commit: commit, Head: head, Reason: reason, CreatedAt: now, Ref: ref}, nil
+}
+
+// list returns the session's checkpoints, newest first.
+func (c *sessionCheckpointer) list() ([]checkpoint, error) {
+	out, err := c.git(nil, "for-each-ref", "--sort=-refname",
+		"--format=%(refname)%00%(objectname)%00%(parent)%00%(committerdate:unix)%00%(contents:subject)",
+		checkpointRefPrefix+c.sessionUUID+"/")
+	if err != nil {
+		return nil, err
+	}
+	list := []checkpoint{}
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "\x00")
+		if len(f) != 5 || len(f[1]) < 12 {
+			continue
+		}
+		secs, _ := strconv.ParseInt(f[3], 10, 64)
+		list = append(list, checkpoint{
+			ID:        f[1][:12],
+			Commit:    f[1],
+			Head:      f[2],
+			Reason:    strings.TrimPrefix(f[4], "swe-swe checkpoint: "),
+			CreatedAt: time.Unix(secs, 0),
+			Ref:       f[0],
+		})
+	}
+	return list, nil
+}
+
+// prune drops checkpoints past checkpointMaxPerSession. Call with mu held.
+func (c *sessionCheckpointer) prune() {
+	list, err := c.list()
+	if err != nil || len(list) <= checkpointMaxPerSession {
+		return
+	}
+	for _, cp := range list[checkpointMaxPerSession:] {
+		c.git(nil, "update-ref", "-d", cp.Ref)
+	}
+}
+
+var errCheckpointNotFound = errors.New("checkpoint not found")
+
+// restore makes the working tree match checkpoint id, after checkpointing
+// the current state. It returns the restored checkpoint and the safety one.
+func (c *sessionCheckpointer) restore(id string) (restored, before *checkpoint, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list, err := c.list()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range list {
+		if id != "" && strings.HasPrefix(list[i].Commit, id) {
+			restored = &list[i]
+			break
+		}
+	}
+	if restored == nil {
+		return nil, nil, errCheckpointNotFound
+	}
+	if before, err = c.takeLocked("before restore", true); err != nil {
+		return nil, nil, err
+	}
+
+	tmp, err := privateIndexPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tmp)
+	env := []string{"GIT_INDEX_FILE=" + tmp}
+	// Index = the state just saved, then a two-tree switch to the checkpoint:
+	// updates changed files, restores deleted ones, removes new ones.
+	if _, err := c.git(env, "read-tree", before.Commit+"^{tree}"); err != nil {
+		return nil, nil, err
+	}
+	if _, err := c.git(env, "read-tree", "--reset", "-u", before.Commit+"^{tree}", restored.Commit+"^{tree}"); err != nil {
+		return nil, nil, err
+	}
+	c.lastTree = ""
+	log.Printf("Session %s: restored checkpoint %s (%s)", c.sessionUUID, restored.ID, restored.Reason)
+	return restored, before, nil
+}
+
+// handleSessionCheckpointsAPI handles /api/session/{uuid}/checkpoints[/{id}/restore].
+func handleSessionCheckpointsAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/session/")
+	sessionUUID, sub, _ := strings.Cut(rest, "/checkpoints")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	c := sess.Checkpoints
+	if c == nil {
+		http.Error(w, "Checkpoints are off for this session (SWE_CHECKPOINTS, git working directory)", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		list, err := c.list()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"sessionUUID": sessionUUID, "checkpoints": list})
+	case sub == "" && r.Method == http.MethodPost:
+		cp, err := c.take("manual", true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(cp)
+	case strings.HasSuffix(sub, "/restore") && r.Method == http.MethodPost:
+		id := strings.TrimSuffix(strings.TrimPrefix(sub, "/"), "/restore")
+		restored, before, err := c.restore(id)
+		if errors.Is(err, errCheckpointNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"restored": restored, "before": before})
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,208 @@
+// recording_dirs.go -- where each recording lives: next to the repo its
+// session worked in.
+//
+// Sessions on the default workspace record into recordingsDir
+// ({workspace}/.swe-swe/recordings). A session on an external repo -- its
+// /repos/{name}/workspace clone or one of its /repos/{name}/worktrees --
+// records into that repo's own directory instead:
+//
+//	/repos/{name}/.swe-swe/recordings/session-{uuid}.log
+//
+// so the recordings sit next to the code they touched, and deleting
+// /repos/{name} deletes them too. The directory is outside the clone, so it
+// never shows up in git status.
+//
+// Lookups by recording UUID go through a global index in recordingsDir,
+// recording-dirs.json, mapping each recording kept elsewhere to its
+// directory:
+//
+//	{"9f2c...": "/repos/acme/.swe-swe/recordings"}
+//
+// A recording not in the index is in recordingsDir, which keeps every
+// recording made before the index existed where it was. Entries whose
+// directory is gone (the repo was deleted) are dropped when the index is
+// loaded. Server-wide files (ports.json, usage.jsonl) stay in recordingsDir.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// recordingIndex maps recording UUIDs to the directory they are kept in, for
+// recordings not in recordingsDir. It is loaded on first use, and again when
+// recordingsDir changes (tests).
+var recordingIndex struct {
+	mu       sync.Mutex
+	loadedOf string
+	dirs     map[string]string
+}
+
+func recordingIndexPath() string { return filepath.Join(recordingsDir, "recording-dirs.json") }
+
+// loadRecordingIndexLocked reads the index if it is not loaded for the
+// current recordingsDir, dropping entries whose directory no longer exists.
+// Must be called with recordingIndex.mu held.
+func loadRecordingIndexLocked() {
+	if recordingIndex.dirs != nil && recordingIndex.loadedOf == recordingsDir {
+		return
+	}
+	recordingIndex.loadedOf = recordingsDir
+	recordingIndex.dirs = map[string]string{}
+	data, err := os.ReadFile(recordingIndexPath())
+	if err != nil {
+		return
+	}
+	var dirs map[string]string
+	if err := json.Unmarshal(data, &dirs); err != nil {
+		log.Printf("Recording index: %v", err)
+		return
+	}
+	pruned := false
+	for recUUID, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			pruned = true
+			continue
+		}
+		recordingIndex.dirs[recUUID] = dir
+	}
+	if pruned {
+		saveRecordingIndexLocked()
+	}
+}
+
+// saveRecordingIndexLocked writes the index. Must be called with
+// recordingIndex.mu held.
+func saveRecordingIndexLocked() {
+	data, err := json.MarshalIndent(recordingIndex.dirs, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := ensureRecordingsDir(); err != nil {
+		log.Printf("Recording index: %v", err)
+		return
+	}
+	tmp := recordingIndexPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Recording index: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, recordingIndexPath()); err != nil {
+		log.Printf("Recording index: %v", err)
+	}
+}
+
+// recordingsDirFor returns the directory a session working in workDir
+// records into: its repo's .swe-swe/recordings for a path under
+// reposDir/{name}/, recordingsDir otherwise.
+func recordingsDirFor(workDir string) string {
+	rel, ok := strings.CutPrefix(filepath.Clean(workDir), reposDir+"/")
+	if !ok {
+		return recordingsDir
+	}
+	name, _, _ := strings.Cut(rel, "/")
+	if name == "" || name == "." || name == ".." {
+		return recordingsDir
+	}
+	return filepath.Join(reposDir, name, ".swe-swe", "recordings")
+}
+
+// registerRecordingDir creates the directory recording recUUID of a session
+// working in workDir goes into, indexes it, and returns it.
+func registerRecordingDir(recUUID, workDir string) (string, error) {
+	dir := recordingsDirFor(workDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	recordingIndex.mu.Lock()
+	defer recordingIndex.mu.Unlock()
+	loadRecordingIndexLocked()
+	if dir == recordingsDir {
+		if _, ok := recordingIndex.dirs[recUUID]; ok {
+			delete(recordingIndex.dirs, recUUID)
+			saveRecordingIndexLocked()
+		}
+		return dir, nil
+	}
+	if recordingIndex.dirs[recUUID] != dir {
+		recordingIndex.dirs[recUUID] = dir
+		saveRecordingIndexLocked()
+	}
+	return dir, nil
+}
+
+// forgetRecordingDir drops recUUID from the index once its files are gone.
+func forgetRecordingDir(recUUID string) {
+	recordingIndex.mu.Lock()
+	defer recordingIndex.mu.Unlock()
+	loadRecordingIndexLocked()
+	if _, ok := recordingIndex.dirs[recUUID]; ok {
+		delete(recordingIndex.dirs, recUUID)
+		saveRecordingIndexLocked()
+	}
+}
+
+// recordingDir returns the directory recording recUUID is kept in. Child
+// recordings are kept with their parent, so pass the parent's UUID for
+// those.
+func recordingDir(recUUID string) string {
+	recordingIndex.mu.Lock()
+	defer recordingIndex.mu.Unlock()
+	loadRecordingIndexLocked()
+	if dir, ok := recordingIndex.dirs[recUUID]; ok {
+		return dir
+	}
+	return recordingsDir
+}
+
+// recordingPath returns the path of a recording file or prefix by name, e.g.
+// "session-{uuid}.log" or "session-{parent}-{child}": in its (parent)
+// recording's directory. Other names are in recordingsDir.
+func recordingPath(name string) string {
+	rest, ok := strings.CutPrefix(name, "session-")
+	if !ok || len(rest) < 36 {
+		return recordingsDir + "/" + name
+	}
+	return recordingDir(rest[:36]) + "/" + name
+}
+
+// recordingDirs returns recordingsDir followed by every other directory
+// holding recordings, sorted.
+func recordingDirs() []string {
+	recordingIndex.mu.Lock()
+	defer recordingIndex.mu.Unlock()
+	loadRecordingIndexLocked()
+	seen := map[string]bool{recordingsDir: true}
+	var others []string
+	for _, dir := range recordingIndex.dirs {
+		if !seen[dir] {
+			seen[dir] = true
+			others = append(others, dir)
+		}
+	}
+	sort.Strings(others)
+	return append([]string{recordingsDir}, others...)
+}
+
+// readRecordingEntries lists the files of every recordings directory, like
+// os.ReadDir. Names are unique across directories, so callers resolve them
+// with recordingPath. Only a missing recordingsDir is an error.
+func readRecordingEntries() ([]os.DirEntry, error) {
+	var all []os.DirEntry
+	for i, dir := range recordingDirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			continue
+		}
+		all = append(all, entries...)
+	}
+	return all, nil
+}
@@ -0,0 +1,394 @@
+// recording_list.go -- the index behind the homepage's recordings list.
+//
+// Listing recordings used to read and parse every recording's metadata.json,
+// and read the chat or terminal log of each one without a cached summary, on
+// every homepage request -- to show ten of them. With thousands of kept
+// recordings, across every recordings directory (recording_dirs.go), that is
+// most of the page's time.
+//
+// recordingList keeps one entry per root recording, newest first, built from
+// its metadata. A refresh still lists the directories and stats their files,
+// but an entry is only rebuilt when the name, size or mtime of one of its
+// files changed; the other recordings are not read again. Pages are cut from
+// the index (endedRecordingsPage), and only the page's entries get the
+// per-request fields -- how long ago, time to expiry, a fresh restart UUID --
+// and, when the metadata has none, a summary line read from the logs, which
+// is then kept with the entry. recordingListRefresher refreshes the index in
+// the background every recordingListRefreshInterval, so a burst of new
+// recordings is parsed before the next page view rather than during it.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// recordingListRefreshInterval is how often the index is refreshed in the
+// background.
+const recordingListRefreshInterval = time.Minute
+
+// recordingListEntry is one root recording in the index.
+type recordingListEntry struct {
+	sig  uint64        // of its files' names, sizes and mtimes
+	info RecordingInfo // without the per-request fields
+	// summaryLoaded is set once info.SummaryLine was looked up in the logs.
+	summaryLoaded bool
+}
+
+// recordingList is the index of ended recordings. It is rebuilt from scratch
+// when recordingsDir changes (tests).
+var recordingList struct {
+	mu       sync.Mutex
+	loadedOf string
+	entries  map[string]*recordingListEntry // by recording UUID
+	sorted   []*recordingListEntry          // newest EndedAt first
+}
+
+// recordingFiles is what a directory listing says about one recording: the
+// files of the recording and of its children.
+type recordingFiles struct {
+	log           os.DirEntry // the root recording's .log or .log.gz
+	chatUUID      string
+	terminalUUIDs []string
+	size          int64
+	sig           uint64
+}
+
+// scanRecordingFiles groups the recording files in entries by their root
+// recording's UUID.
+func scanRecordingFiles(entries []os.DirEntry) map[string]*recordingFiles {
+	byUUID := make(map[string]*recordingFiles)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		rest, ok := strings.CutPrefix(name, "session-")
+		if !ok {
+			continue
+		}
+
+		// Determine file type from extension
+		var stem string
+		var fileType string // "log", "events", "metadata", "timing", "input"
+		switch {
+		case strings.HasSuffix(rest, ".events.jsonl"):
+			stem = strings.TrimSuffix(rest, ".events.jsonl")
+			fileType = "events"
+		case strings.HasSuffix(rest, ".metadata.json"):
+			stem = strings.TrimSuffix(rest, ".metadata.json")
+			fileType = "metadata"
+		case strings.HasSuffix(rest, ".log.gz"):
+			stem = strings.TrimSuffix(rest, ".log.gz")
+			fileType = "log"
+		case strings.HasSuffix(rest, ".log"):
+			stem = strings.TrimSuffix(rest, ".log")
+			fileType = "log"
+		case strings.HasSuffix(rest, ".log.pipe"):
+			continue // skip FIFO pipes used during recording
+		case strings.HasSuffix(rest, ".timing"):
+			stem = strings.TrimSuffix(rest, ".timing")
+			fileType = "timing"
+		case strings.HasSuffix(rest, ".input"):
+			stem = strings.TrimSuffix(rest, ".input")
+			fileType = "input"
+		default:
+			continue
+		}
+
+		parentUUID, childUUID, ok := parseRecordingFilename(stem)
+		if !ok {
+			continue
+		}
+		f := byUUID[parentUUID]
+		if f == nil {
+			f = &recordingFiles{}
+			byUUID[parentUUID] = f
+		}
+		var size int64
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		if fi, err := entry.Info(); err == nil {
+			size = fi.Size()
+			fmt.Fprintf(h, "\x00%d\x00%d", size, fi.ModTime().UnixNano())
+		}
+		// Summed, so the signature does not depend on listing order.
+		f.sig += h.Sum64()
+		f.size += size
+
+		switch {
+		case childUUID == "" && fileType == "log":
+			f.log = entry
+		case childUUID != "" && fileType == "events" && size > 0:
+			f.chatUUID = childUUID
+		case childUUID != "" && fileType == "log":
+			f.terminalUUIDs = append(f.terminalUUIDs, childUUID)
+		}
+	}
+	return byUUID
+}
+
+// buildRecordingInfo reads recording ruuid's metadata into the index's
+// RecordingInfo.
+func buildRecordingInfo(ruuid string, f *recordingFiles) RecordingInfo {
+	uuidShort := ruuid
+	if len(ruuid) >= 8 {
+		uuidShort = ruuid[:8]
+	}
+	info := RecordingInfo{
+		UUID:      ruuid,
+		UUIDShort: uuidShort,
+		SizeHuman: formatSizeHuman(f.size),
+	}
+	if f.chatUUID != "" {
+		info.HasChat = true
+		info.ChatUUID = f.chatUUID
+	}
+	if len(f.terminalUUIDs) > 0 {
+		info.HasTerminal = true
+		info.TerminalUUIDs = f.terminalUUIDs
+	}
+
+	// Load metadata if exists
+	metadataPath := recordingDir(ruuid) + "/session-" + ruuid + ".metadata.json"
+	metaParsed := false
+	if metaData, err := os.ReadFile(metadataPath); err == nil {
+		var meta RecordingMetadata
+		if uerr := json.Unmarshal(metaData, &meta); uerr == nil {
+			metaParsed = true
+			info.Name = meta.Name
+			info.Agent = meta.Agent
+			info.AgentBadgeClass = agentBadgeClass(meta.Agent)
+			info.KeptAt = meta.KeptAt
+			info.IsKept = meta.KeptAt != nil
+			info.StartedAt = meta.StartedAt
+			if meta.EndedAt != nil {
+				info.EndedAt = *meta.EndedAt
+			} else {
+				info.EndedAt = meta.StartedAt
+			}
+			// Build restart query from metadata
+			binary := meta.AgentBinary
+			if binary == "" {
+				// Old recordings: fall back to lowercased display name
+				binary = strings.ToLower(meta.Agent)
+			}
+			info.Query = SessionPageQuery{
+				Assistant:      binary,
+				SessionMode:    meta.SessionMode,
+				Name:           meta.Name,
+				BranchName:     meta.BranchName,
+				CheckoutBranch: meta.CheckoutBranch,
+				WorkDir:        meta.WorkDir,
+				ExtraArgs:      meta.ExtraArgs,
+			}
+			// Forkable via /api/fork only for chat-mode claude/codex
+			// recordings that have a chat event log to prepopulate.
+			info.CanResume = info.HasChat && meta.SessionMode == "chat" && (binary == "claude" || binary == "codex")
+			// Prefer the cached summary if it was extracted at end-of-session.
+			// This avoids decompressing the entire .log.gz on every homepage render.
+			info.SummaryLine = meta.SummaryLine
+		} else {
+			log.Printf("loadEndedRecordings: corrupt metadata for %s: %v (falling back to mtime)", uuidShort, uerr)
+		}
+	}
+	if !metaParsed {
+		// No metadata file, or it failed to parse. Fall back to log file
+		// mtime so the recording still appears on the homepage instead of
+		// silently vanishing. Default the agent to "claude" so the
+		// recording lands in a visible bucket -- homepage rendering only
+		// queries availableAssistants by binary name, and an empty agent
+		// would otherwise be bucketed under "" and never shown.
+		if fileInfo, err := f.log.Info(); err == nil {
+			info.EndedAt = fileInfo.ModTime()
+		}
+		info.Agent = "Claude"
+		info.AgentBadgeClass = agentBadgeClass("Claude")
+	}
+	if info.AgentBadgeClass == "" {
+		info.AgentBadgeClass = agentBadgeClass(info.Agent)
+	}
+	return info
+}
+
+// refreshRecordingList brings the index up to date with the recordings
+// directories, rebuilding the entries whose files changed.
+func refreshRecordingList() {
+	entries, err := readRecordingEntries()
+
+	recordingList.mu.Lock()
+	defer recordingList.mu.Unlock()
+	if recordingList.entries == nil || recordingList.loadedOf != recordingsDir {
+		recordingList.loadedOf = recordingsDir
+		recordingList.entries = make(map[string]*recordingListEntry)
+		recordingList.sorted = nil
+	}
+	var files map[string]*recordingFiles
+	if err == nil {
+		files = scanRecordingFiles(entries)
+	}
+
+	changed := false
+	for ruuid, f := range files {
+		if f.log == nil {
+			continue // only recordings with a terminal log are listed
+		}
+		if e := recordingList.entries[ruuid]; e != nil && e.sig == f.sig {
+			continue
+		}
+		recordingList.entries[ruuid] = &recordingListEntry{sig: f.sig, info: buildRecordingInfo(ruuid, f)}
+		changed = true
+	}
+	for ruuid := range recordingList.entries {
+		if f := files[ruuid]; f == nil || f.log == nil {
+			delete(recordingList.entries, ruuid)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	sorted := make([]*recordingListEntry, 0, len(recordingList.entries))
+	for _, e := range recordingList.entries {
+		sorted = append(sorted, e)
+	}
+	// Sort by most recent first (newest EndedAt timestamp first)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].info.EndedAt.After(sorted[j].info.EndedAt)
+	})
+	recordingList.sorted = sorted
+}
+
+// recordingListRefresher refreshes the index in the background.
+func recordingListRefresher() {
+	defer recoverGoroutine("recording list refresher")
+	ticker := time.NewTicker(recordingListRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		refreshRecordingList()
+	}
+}
+
+// activeRecordingUUIDs returns the recordings of sessions whose process is
+// still running.
+func activeRecordingUUIDs() map[string]bool {
+	active := make(map[string]bool)
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, sess := range sessions {
+		if sess.RecordingUUID != "" && sess.Cmd != nil && !sess.exited() {
+			active[sess.RecordingUUID] = true
+		}
+	}
+	return active
+}
+
+// endedRecordingsPage returns page (1-based, perPage to a page) of the ended
+// recordings, newest first, with the page number it returned -- a page past
+// the last is the last -- and how many ended recordings there are. perPage 0
+// returns them all.
+func endedRecordingsPage(page, perPage int) (recordings []RecordingInfo, pageNum, total int) {
+	refreshRecordingList()
+	active := activeRecordingUUIDs()
+
+	recordingList.mu.Lock()
+	defer recordingList.mu.Unlock()
+	ended := make([]*recordingListEntry, 0, len(recordingList.sorted))
+	for _, e := range recordingList.sorted {
+		if !active[e.info.UUID] {
+			ended = append(ended, e)
+		}
+	}
+	total = len(ended)
+	if total == 0 {
+		return nil, page, 0
+	}
+	if perPage > 0 {
+		if pages := (total + perPage - 1) / perPage; page > pages {
+			page = pages
+		}
+		if page < 1 {
+			page = 1
+		}
+		start := (page - 1) * perPage
+		ended = ended[start:min(start+perPage, total)]
+	}
+	recordings = make([]RecordingInfo, 0, len(ended))
+	for _, e := range ended {
+		recordings = append(recordings, e.requestInfo())
+	}
+	return recordings, page, total
+}
+
+// requestInfo is e's RecordingInfo with the fields that depend on when it is
+// shown, and its summary line. Called with recordingList.mu held.
+func (e *recordingListEntry) requestInfo() RecordingInfo {
+	if !e.summaryLoaded {
+		e.summaryLoaded = true
+		e.info.SummaryLine = recordingSummaryLine(e.info)
+	}
+	info := e.info
+	info.RestartUUID = uuid.New().String()
+	if !info.EndedAt.IsZero() {
+		info.EndedAgo = formatTimeAgo(info.EndedAt)
+	}
+	// Calculate ExpiresIn for non-kept recordings based on EndedAt
+	if !info.IsKept {
+		info.ExpiresIn = recordingExpiresIn(info.EndedAt)
+	}
+	return info
+}
+
+// recordingSummaryLine is info's summary line: the one cached in its
+// metadata, else one from its chat events, else from its terminal log.
+func recordingSummaryLine(info RecordingInfo) string {
+	if info.SummaryLine != "" {
+		return info.SummaryLine
+	}
+	if info.HasChat {
+		if summaryLine, _ := getSessionSummaryFromChat(info.UUID); summaryLine != "" {
+			return summaryLine
+		}
+	}
+	// Only attempt the log-tail fallback for plain .log files; .log.gz
+	// would require full-file decompression (gzip is not seekable) and
+	// would block the homepage for minutes on a busy host. The compression
+	// worker now caches summary_line into metadata.json before gzipping,
+	// so the .log.gz path should normally never be hit here.
+	logPath := resolveLogPath("session-" + info.UUID)
+	if logPath != "" && !strings.HasSuffix(logPath, ".log.gz") {
+		return getSessionSummaryFromLog(info.UUID)
+	}
+	return ""
+}
+
+// recordingExpiresIn is how long until a recording that ended at endedAt is
+// auto-deleted: "3d4h", "5h12m", "40m", "<1m" or "soon".
+func recordingExpiresIn(endedAt time.Time) string {
+	remaining := recentRecordingMaxAge - time.Since(endedAt)
+	if remaining <= 0 {
+		return "soon"
+	}
+	days := int(remaining.Hours()) / 24
+	hours := int(remaining.Hours()) % 24
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh", days, hours)
+	}
+	if hours > 0 {
+		mins := int(remaining.Minutes()) % 60
+		return fmt.Sprintf("%dh%dm", hours, mins)
+	}
+	if mins := int(remaining.Minutes()); mins >= 1 {
+		return fmt.Sprintf("%dm", mins)
+	}
+	return "<1m"
+}
@@ -0,0 +1,265 @@
+// repo_env.go -- read and edit a repo's .swe-swe/env over HTTP, for users
+// who do not open a terminal.
+//
+//	GET /api/repo/env?path=/repos/x/workspace
+//	    -> {"path": "...", "content": "...", "exists": true, "version": "<sha256>"}
+//	PUT /api/repo/env?path=/repos/x/workspace  {"content": "...", "version": "<sha256>"}
+//	    -> the same, after the write
+//
+// path is a repository as in /api/repo/branches (the workspace when left
+// out). The content is checked before it is written, so a typo cannot leave
+// a file that the agent's parser and the login shell's bash read differently
+// (see ".swe-swe/env" in docs/configuration.md): every line that is not
+// blank or a #-comment must be KEY=value with a shell-style KEY, no export
+// prefix, no spaces around =, and a value with spaces quoted and closed on
+// its own line. CRLF line ends become LF; a lone CR or a NUL is refused, as
+// is content over envFileMaxSize.
+// Problems come back as 422 with {"errors": [{"line": 3, "error": "..."}]}.
+//
+// version, when given, must be that of the file as it is now (409
+// otherwise), so two editors do not overwrite each other; "" stands for a
+// file that does not exist yet. A write is logged with who made it and which
+// keys it added, changed and removed -- never the values -- and announced on
+// /ws/admin as env_changed. Sessions read the file when they start, so ones
+// already running keep their environment.
+//
+// Reading needs the input permission as well, since the file usually holds
+// API keys; shared-session guests get neither.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// envFileMaxSize bounds the .swe-swe/env content the API writes.
+const envFileMaxSize = 64 << 10
+
+// envKeyRE is a key both parsers accept.
+var envKeyRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// repoEnvMu serializes writes, so a version check and its write are one step.
+var repoEnvMu sync.Mutex
+
+// envLineError is one problem found in submitted .swe-swe/env content.
+type envLineError struct {
+	Line  int    `json:"line,omitempty"` // 1-based; 0 for the content as a whole
+	Error string `json:"error"`
+}
+
+// normalizeEnvContent turns CRLF line ends into LF and ends the content with
+// a newline.
+func normalizeEnvContent(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content
+}
+
+// validateEnvContent checks normalized .swe-swe/env content.
+func validateEnvContent(content string) []envLineError {
+	if len(content) > envFileMaxSize {
+		return []envLineError{{Error: fmt.Sprintf("larger than %d bytes", envFileMaxSize)}}
+	}
+	var errs []envLineError
+	for i, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		if msg := validateEnvLine(line); msg != "" {
+			errs = append(errs, envLineError{Line: i + 1, Error: msg})
+		}
+	}
+	return errs
+}
+
+// validateEnvLine returns what is wrong with one line, or "".
+func validateEnvLine(line string) string {
+	if strings.ContainsAny(line, "\r\x00") {
+		return "contains a carriage return or NUL byte"
+	}
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return ""
+	}
+	key, value, ok := strings.Cut(trimmed, "=")
+	if !ok {
+		return "not KEY=value"
+	}
+	if rest, ok := strings.CutPrefix(key, "export "); ok {
+		return fmt.Sprintf("drop the export prefix: %s=...", strings.TrimSpace(rest))
+	}
+	if key != strings.TrimSpace(key) || value != strings.TrimLeft(value, " \t") {
+		return "no spaces around ="
+	}
+	if !envKeyRE.MatchString(key) {
+		return fmt.Sprintf("invalid key %q: use letters, digits and _, not starting with a digit", key)
+	}
+	if q := value[:min(len(value), 1)]; q == `"` || q == "'" {
+		if len(value) < 2 || !strings.HasSuffix(value, q) {
+			return "quoted value must end on the same line"
+		}
+	} else if strings.ContainsAny(value, " \t") {
+		return "quote a value with spaces: KEY=\"a b\""
+	}
+	return ""
+}
+
+// envKeys returns the KEY=value pairs of content, the last one winning.
+func envKeys(content string) map[string]string {
+	keys := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			keys[k] = v
+		}
+	}
+	return keys
+}
+
+// envKeyChanges lists the keys after adds, changes and removes from before.
+func envKeyChanges(before, after string) (added, changed, removed []string) {
+	old, cur := envKeys(before), envKeys(after)
+	for k, v := range cur {
+		if ov, ok := old[k]; !ok {
+			added = append(added, k)
+		} else if ov != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range old {
+		if _, ok := cur[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
+
+// envVersion is the version of content; "" for a file that does not exist.
+func envVersion(content string, exists bool) string {
+	if !exists {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// readRepoEnv returns a repo's .swe-swe/env and whether it exists.
+func readRepoEnv(repoPath string) (string, bool, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, ".swe-swe", "env"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	return string(data), err == nil, err
+}
+
+// handleRepoEnvAPI handles GET and PUT /api/repo/env?path=...
+func handleRepoEnvAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := requestIdentity(r)
+	if !id.can(permInput) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	repoPath := r.URL.Query().Get("path")
+	if repoPath == "" {
+		repoPath = workspaceDir
+	}
+	repoPath, err := policyRepoPath.Resolve(repoPath)
+	if err != nil {
+		http.Error(w, "Invalid repository path", http.StatusBadRequest)
+		return
+	}
+	if fi, err := os.Stat(repoPath); err != nil || !fi.IsDir() {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	respond := func(content string, exists bool) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"path":    repoPath,
+			"content": content,
+			"exists":  exists,
+			"version": envVersion(content, exists),
+		})
+	}
+
+	if r.Method == http.MethodGet {
+		content, exists, err := readRepoEnv(repoPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respond(content, exists)
+		return
+	}
+
+	var req struct {
+		Content string  `json:"content"`
+		Version *string `json:"version"`
+	}
+	body := http.MaxBytesReader(w, r.Body, 2*envFileMaxSize)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	content := normalizeEnvContent(req.Content)
+	if errs := validateEnvContent(content); len(errs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{"errors": errs})
+		return
+	}
+
+	repoEnvMu.Lock()
+	defer repoEnvMu.Unlock()
+	before, exists, err := readRepoEnv(repoPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Version != nil && *req.Version != envVersion(before, exists) {
+		http.Error(w, ".swe-swe/env changed since it was read; reload it", http.StatusConflict)
+		return
+	}
+	dir := filepath.Join(repoPath, ".swe-swe")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := atomicWriteFile(filepath.Join(dir, "env"), []byte(content), 0o600); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	added, changed, removed := envKeyChanges(before, content)
+	log.Printf("Repo env %s: written by %q (added %v, changed %v, removed %v)", repoPath, id.User, added, changed, removed)
+	keys := append(append(append([]string{}, added...), changed...), removed...)
+	sort.Strings(keys)
+	publishAdminEvent(adminEvent{Type: "env_changed", Repo: repoPath, User: id.User, Keys: keys})
+	respond(content, true)
+}
@@ -0,0 +1,488 @@
+// secrets.go -- named secrets for session environments, kept out of output.
+//
+// A repo's .swe-swe/env (or the Settings panel's env vars) refers to a secret
+// by name instead of holding its value:
+//
+//	STRIPE_KEY=${secret:STRIPE_TEST_KEY}
+//
+// and the value is filled in when the session's environment is built. A
+// secret's value comes from, in order:
+//
+//  1. the server's store: PUT /api/secrets/{name} with {"value": "..."},
+//     kept AES-GCM encrypted in secrets.json in the recordings directory.
+//     The key is SWE_SECRETS_KEY (any string; hashed to 32 bytes), or a
+//     random one generated into secrets.key beside the store on first use;
+//  2. the server's environment, as SWE_SECRET_{name};
+//  3. a file named {name} in SWE_SECRETS_DIR (e.g. /run/secrets), when set.
+//
+// A reference to a secret that is not set expands to "" and is logged.
+// GET /api/secrets lists the names and where each comes from, never a value;
+// DELETE /api/secrets/{name} removes a stored one. All three are admin-only.
+//
+// Every stored secret, and every other secret while a session that was given
+// it is alive, is masked -- each byte replaced with secretMask, so lengths and
+// the recording's .timing byte counts still line up -- in the recording's
+// output (and so in scrollback and playback), the screen API, test output and
+// the preview app's log. Values shorter than secretMinMaskLen are not masked;
+// they would match too much.
+//
+// The store is read once, by loadSecrets at startup, and its values are
+// decrypted then and when stored, so masking output never touches the disk.
+// Neither secrets.json nor secrets.key is exported (state_export.go).
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// secretMask replaces each byte of a secret in output.
+	secretMask = '*'
+	// secretMinMaskLen is the shortest value that is masked.
+	secretMinMaskLen = 4
+	// secretMaxLen bounds a stored value.
+	secretMaxLen = 64 << 10
+)
+
+// secretNameRE is what a secret may be called: an env var name.
+var secretNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// storedSecret is one encrypted entry in secrets.json.
+type storedSecret struct {
+	Nonce     string    `json:"nonce"` // base64
+	Value     string    `json:"value"` // base64 AES-GCM ciphertext, the name as additional data
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// secretInfo is what GET /api/secrets says about a secret.
+type secretInfo struct {
+	Name      string     `json:"name"`
+	Source    string     `json:"source"` // "store", "env" or "file"
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+var secretState = struct {
+	mu     sync.Mutex
+	dir    string                     // holds secrets.json and secrets.key; set by loadSecrets
+	stored map[string]storedSecret    // secrets.json
+	values map[string]string          // stored values that decrypt, by name
+	given  map[string]map[string]bool // session UUID -> values it was given
+	masks  [][]byte                   // maskedSecretValues' result
+	dirty  bool                       // masks needs rebuilding
+}{given: map[string]map[string]bool{}}
+
+const (
+	secretsFileName    = "secrets.json"
+	secretsKeyFileName = "secrets.key"
+)
+
+func secretsPath() string    { return filepath.Join(secretsDir(), secretsFileName) }
+func secretsKeyPath() string { return filepath.Join(secretsDir(), secretsKeyFileName) }
+
+// secretsDir is the directory loadSecrets read the store from.
+func secretsDir() string {
+	secretState.mu.Lock()
+	defer secretState.mu.Unlock()
+	return secretState.dir
+}
+
+// loadSecrets reads the store in dir (the recordings dir), replacing what
+// was loaded before. Values given to live sessions are kept.
+func loadSecrets(dir string) {
+	stored := map[string]storedSecret{}
+	path := filepath.Join(dir, secretsFileName)
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &stored); err != nil {
+			log.Printf("Warning: ignoring unreadable %s: %v", path, err)
+			stored = map[string]storedSecret{}
+		}
+	}
+	values := map[string]string{}
+	for name, s := range stored {
+		if v, err := decryptSecret(dir, name, s); err == nil {
+			values[name] = v
+		} else {
+			log.Printf("Secrets: %s: %v", name, err)
+		}
+	}
+	secretState.mu.Lock()
+	defer secretState.mu.Unlock()
+	secretState.dir, secretState.stored, secretState.values = dir, stored, values
+	secretState.dirty = true
+}
+
+// secretsCipher returns the AES-GCM cipher of the store in dir. create makes
+// secrets.key when there is neither SWE_SECRETS_KEY nor a key file yet.
+func secretsCipher(dir string, create bool) (cipher.AEAD, error) {
+	var key []byte
+	if v := os.Getenv("SWE_SECRETS_KEY"); v != "" {
+		sum := sha256.Sum256([]byte(v))
+		key = sum[:]
+	} else {
+		keyPath := filepath.Join(dir, secretsKeyFileName)
+		data, err := os.ReadFile(keyPath)
+		switch {
+		case err == nil:
+			if key, err = hex.DecodeString(strings.TrimSpace(string(data))); err != nil || len(key) != 32 {
+				return nil, fmt.Errorf("%s: not a 32-byte hex key", keyPath)
+			}
+		case errors.Is(err, os.ErrNotExist) && create:
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, err
+			}
+			if err := atomicWriteFile(keyPath, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+				return nil, err
+			}
+			log.Printf("Secrets: generated %s (set SWE_SECRETS_KEY to keep the key elsewhere)", keyPath)
+		default:
+			return nil, fmt.Errorf("no key: set SWE_SECRETS_KEY")
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptSecret opens an entry of the store in dir.
+func decryptSecret(dir, name string, s storedSecret) (string, error) {
+	aead, err := secretsCipher(dir, false)
+	if err != nil {
+		return "", err
+	}
+	nonce, err1 := base64.StdEncoding.DecodeString(s.Nonce)
+	sealed, err2 := base64.StdEncoding.DecodeString(s.Value)
+	if err1 != nil || err2 != nil || len(nonce) != aead.NonceSize() {
+		return "", errors.New("corrupt entry")
+	}
+	plain, err := aead.Open(nil, nonce, sealed, []byte(name))
+	if err != nil {
+		return "", errors.New("cannot decrypt (was SWE_SECRETS_KEY changed?)")
+	}
+	return string(plain), nil
+}
+
+// errSecretsNotLoaded is returned by changes to the store before
+// loadSecrets.
+var errSecretsNotLoaded = errors.New("the secrets store is not loaded")
+
+// storeSecret encrypts value into secrets.json under name.
+func storeSecret(name, value string) error {
+	dir := secretsDir()
+	if dir == "" {
+		return errSecretsNotLoaded
+	}
+	aead, err := secretsCipher(dir, true)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(nil, nonce, []byte(value), []byte(name))
+
+	secretState.mu.Lock()
+	defer secretState.mu.Unlock()
+	secretState.stored[name] = storedSecret{
+		Nonce:     base64.StdEncoding.EncodeToString(nonce),
+		Value:     base64.StdEncoding.EncodeToString(sealed),
+		UpdatedAt: time.Now().UTC(),
+	}
+	secretState.values[name], secretState.dirty = value, true
+	return saveSecretsLocked()
+}
+
+// deleteSecret removes name from the store; false if it was not there. Its
+// value stays masked while a session that was given it is alive.
+func deleteSecret(name string) (bool, error) {
+	secretState.mu.Lock()
+	defer secretState.mu.Unlock()
+	if _, ok := secretState.stored[name]; !ok {
+		return false, nil
+	}
+	delete(secretState.stored, name)
+	delete(secretState.values, name)
+	secretState.dirty = true
+	return true, saveSecretsLocked()
+}
+
+// saveSecretsLocked writes secrets.json. Call with secretState.mu held.
+func saveSecretsLocked() error {
+	if secretState.dir == "" {
+		return errSecretsNotLoaded
+	}
+	data, err := json.MarshalIndent(secretState.stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(secretState.dir, 0o755); err != nil {
+		return err
+	}
+	return atomicWriteFile(filepath.Join(secretState.dir, secretsFileName), data, 0o600)
+}
+
+// lookupSecret returns name's value and where it came from.
+func lookupSecret(name string) (value, source string, err error) {
+	if !secretNameRE.MatchString(name) {
+		return "", "", fmt.Errorf("invalid secret name %q", name)
+	}
+	secretState.mu.Lock()
+	dir := secretState.dir
+	s, ok := secretState.stored[name]
+	secretState.mu.Unlock()
+	if ok {
+		v, err := decryptSecret(dir, name, s)
+		return v, "store", err
+	}
+	if v, ok := os.LookupEnv("SWE_SECRET_" + name); ok {
+		return v, "env", nil
+	}
+	if dir := os.Getenv("SWE_SECRETS_DIR"); dir != "" {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			return strings.TrimRight(string(data), "\r\n"), "file", nil
+		}
+	}
+	return "", "", nil
+}
+
+// expandSecretRef is parseEnvLines' expansion of ${secret:NAME}: the value,
+// or "" when the secret is not set. A value given to session sid is masked
+// until forgetSessionSecrets(sid); with no sid it is only read.
+func expandSecretRef(sid, name string) string {
+	v, source, err := lookupSecret(name)
+	if err != nil {
+		log.Printf("Secrets: ${secret:%s}: %v", name, err)
+		return ""
+	}
+	if source == "" {
+		log.Printf("Secrets: ${secret:%s} is not set", name)
+		return ""
+	}
+	if sid != "" {
+		secretState.mu.Lock()
+		if secretState.given[sid] == nil {
+			secretState.given[sid] = map[string]bool{}
+		}
+		if !secretState.given[sid][v] {
+			secretState.given[sid][v], secretState.dirty = true, true
+		}
+		secretState.mu.Unlock()
+	}
+	return v
+}
+
+// forgetSessionSecrets stops masking what session sid was given, unless it
+// is stored or another live session has it too. Called when the session
+// ends.
+func forgetSessionSecrets(sid string) {
+	secretState.mu.Lock()
+	defer secretState.mu.Unlock()
+	if _, ok := secretState.given[sid]; ok {
+		delete(secretState.given, sid)
+		secretState.dirty = true
+	}
+}
+
+// maskedSecretValues returns the values to mask, longest first so a secret
+// containing another is masked whole. It works from memory only.
+func maskedSecretValues() [][]byte {
+	secretState.mu.Lock()
+	defer secretState.mu.Unlock()
+	if !secretState.dirty {
+		return secretState.masks
+	}
+	seen := map[string]bool{}
+	var values [][]byte
+	add := func(v string) {
+		if len(v) >= secretMinMaskLen && !seen[v] {
+			seen[v] = true
+			values = append(values, []byte(v))
+		}
+	}
+	for _, v := range secretState.values {
+		add(v)
+	}
+	for _, given := range secretState.given {
+		for v := range given {
+			add(v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	secretState.masks, secretState.dirty = values, false
+	return values
+}
+
+// maskSecretBytes masks every secret in p, in place.
+func maskSecretBytes(p []byte, values [][]byte) {
+	for _, v := range values {
+		for i := 0; ; {
+			j := bytes.Index(p[i:], v)
+			if j < 0 {
+				break
+			}
+			for k := i + j; k < i+j+len(v); k++ {
+				p[k] = secretMask
+			}
+			i += j + len(v)
+		}
+	}
+}
+
+// maskSecrets returns s with every secret masked.
+func maskSecrets(s string) string {
+	values := maskedSecretValues()
+	if len(values) == 0 || s == "" {
+		return s
+	}
+	p := []byte(s)
+	maskSecretBytes(p, values)
+	return string(p)
+}
+
+// secretRedactor masks secrets in a stream of output chunks. A secret split
+// across chunks is found by searching the end of the previous chunk too; the
+// caller rewrites those bytes where it already wrote them.
+type secretRedactor struct {
+	tail []byte // end of the output so far, as written
+}
+
+// output returns p masked, and the masked form of the previous tail when a
+// secret straddled it (nil otherwise).
+func (x *secretRedactor) output(p []byte) (masked, tail []byte) {
+	values := maskedSecretValues()
+	if len(values) == 0 {
+		x.tail = nil
+		return p, nil
+	}
+	buf := append(append([]byte{}, x.tail...), p...)
+	maskSecretBytes(buf, values)
+	n := len(x.tail)
+	if !bytes.Equal(buf[:n], x.tail) {
+		tail = buf[:n]
+	}
+	masked = buf[n:]
+	keep := len(values[0]) - 1
+	if keep > len(buf) {
+		keep = len(buf)
+	}
+	x.tail = append(x.tail[:0], buf[len(buf)-keep:]...)
+	return masked, tail
+}
+
+// secretInfos lists every secret known to the server, without values.
+func secretInfos() []secretInfo {
+	infos := map[string]secretInfo{}
+	secretState.mu.Lock()
+	dir := secretState.dir
+	stored := make(map[string]storedSecret, len(secretState.stored))
+	for name, s := range secretState.stored {
+		stored[name] = s
+	}
+	secretState.mu.Unlock()
+	if dir := os.Getenv("SWE_SECRETS_DIR"); dir != "" {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if !e.IsDir() && secretNameRE.MatchString(e.Name()) {
+				infos[e.Name()] = secretInfo{Name: e.Name(), Source: "file"}
+			}
+		}
+	}
+	for _, kv := range os.Environ() {
+		if k, _, _ := strings.Cut(kv, "="); strings.HasPrefix(k, "SWE_SECRET_") && secretNameRE.MatchString(k[len("SWE_SECRET_"):]) {
+			name := k[len("SWE_SECRET_"):]
+			infos[name] = secretInfo{Name: name, Source: "env"}
+		}
+	}
+	for name, s := range stored {
+		info := secretInfo{Name: name, Source: "store", UpdatedAt: &s.UpdatedAt}
+		if _, err := decryptSecret(dir, name, s); err != nil {
+			info.Error = err.Error()
+		}
+		infos[name] = info
+	}
+	list := make([]secretInfo, 0, len(infos))
+	for _, info := range infos {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// handleSecretsAPI serves GET /api/secrets, PUT and DELETE
+// /api/secrets/{name}.
+func handleSecretsAPI(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/secrets"), "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"secrets": secretInfos()})
+		return
+	}
+	if !secretNameRE.MatchString(name) {
+		http.Error(w, "Invalid secret name (want letters, digits and _)", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var body struct {
+			Value *string `json:"value"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, secretMaxLen+1024)).Decode(&body); err != nil || body.Value == nil {
+			http.Error(w, `Invalid JSON body (want {"value": "..."})`, http.StatusBadRequest)
+			return
+		}
+		if len(*body.Value) > secretMaxLen {
+			http.Error(w, "Secret too long", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := storeSecret(name, *body.Value); err != nil {
+			log.Printf("Secrets: store %s: %v", name, err)
+			http.Error(w, "Failed to store secret: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Secrets: %s stored (requested by %q)", name, requestIdentity(r).User)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		found, err := deleteSecret(name)
+		if err != nil {
+			http.Error(w, "Failed to delete secret: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Secret not in the store", http.StatusNotFound)
+			return
+		}
+		log.Printf("Secrets: %s deleted (requested by %q)", name, requestIdentity(r).User)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,171 @@
+// session_screen.go -- GET /api/session/{uuid}/screen: the current terminal
+// screen as text.
+//
+// Every session keeps a vt10x emulator in step with its PTY (it is what
+// GenerateSnapshot renders for joining browsers). Bots, tests and homepage
+// thumbnails that only want to know what is on screen used to have to attach
+// a WebSocket and decode the chunked gzip snapshot; this endpoint reads the
+// same grid and returns it as plain text (?format=text) or as JSON rows, each
+// with its text and runs ("spans") of identically styled cells.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hinshun/vt10x"
+)
+
+// Glyph.Mode bits, as defined (unexported) in vt10x's state.go.
+const (
+	vtAttrReverse = 1 << iota
+	vtAttrUnderline
+	vtAttrBold
+	vtAttrGfx
+	vtAttrItalic
+	vtAttrBlink
+	vtAttrWrap // the row goes on in the next one (session_copy.go)
+)
+
+// screenSpan is a run of cells with the same attributes. FG/BG are a palette
+// index (0-255) or "#rrggbb", omitted for the terminal default.
+type screenSpan struct {
+	Text      string `json:"text"`
+	FG        any    `json:"fg,omitempty"`
+	BG        any    `json:"bg,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+	Reverse   bool   `json:"reverse,omitempty"`
+	Blink     bool   `json:"blink,omitempty"`
+}
+
+type screenRow struct {
+	Text  string       `json:"text"` // trailing blanks trimmed
+	Spans []screenSpan `json:"spans"`
+}
+
+type screenCursor struct {
+	X       int  `json:"x"`
+	Y       int  `json:"y"`
+	Visible bool `json:"visible"`
+}
+
+// sessionScreen is the JSON form of the screen.
+type sessionScreen struct {
+	Cols   int          `json:"cols"`
+	Rows   int          `json:"rows"`
+	Title  string       `json:"title,omitempty"`
+	Cursor screenCursor `json:"cursor"`
+	Text   string       `json:"text"` // rows joined with "\n", trailing blank rows trimmed
+	Lines  []screenRow  `json:"lines"`
+}
+
+// screenColor renders a vt10x color for screenSpan, or nil for a default.
+func screenColor(c, def vt10x.Color) any {
+	switch {
+	case c == def || c >= vt10x.DefaultFG:
+		return nil
+	case c < 256:
+		return int(c)
+	}
+	return fmt.Sprintf("#%06x", uint32(c))
+}
+
+// readScreen captures the emulator's grid. Call with vtMu held.
+func readScreen(vt vt10x.Terminal) sessionScreen {
+	cols, rows := vt.Size()
+	cur := vt.Cursor()
+	scr := sessionScreen{
+		Cols:   cols,
+		Rows:   rows,
+		Title:  vt.Title(),
+		Cursor: screenCursor{X: cur.X, Y: cur.Y, Visible: vt.CursorVisible()},
+		Lines:  make([]screenRow, 0, rows),
+	}
+	texts := make([]string, 0, rows)
+	for y := 0; y < rows; y++ {
+		var line strings.Builder
+		var spans []screenSpan
+		var span *screenSpan
+		var lastFG, lastBG vt10x.Color
+		var lastMode int16
+		for x := 0; x < cols; x++ {
+			g := vt.Cell(x, y)
+			ch := g.Char
+			if ch == 0 {
+				ch = ' '
+			}
+			line.WriteRune(ch)
+			if span == nil || g.FG != lastFG || g.BG != lastBG || g.Mode != lastMode {
+				spans = append(spans, screenSpan{
+					FG:        screenColor(g.FG, vt10x.DefaultFG),
+					BG:        screenColor(g.BG, vt10x.DefaultBG),
+					Bold:      g.Mode&vtAttrBold != 0,
+					Italic:    g.Mode&vtAttrItalic != 0,
+					Underline: g.Mode&vtAttrUnderline != 0,
+					Reverse:   g.Mode&vtAttrReverse != 0,
+					Blink:     g.Mode&vtAttrBlink != 0,
+				})
+				span = &spans[len(spans)-1]
+				lastFG, lastBG, lastMode = g.FG, g.BG, g.Mode
+			}
+			span.Text += string(ch)
+		}
+		text := strings.TrimRight(line.String(), " ")
+		scr.Lines = append(scr.Lines, screenRow{Text: text, Spans: spans})
+		texts = append(texts, text)
+	}
+	for len(texts) > 0 && texts[len(texts)-1] == "" {
+		texts = texts[:len(texts)-1]
+	}
+	scr.Text = strings.Join(texts, "\n")
+	return scr
+}
+
+// handleSessionScreenAPI handles GET /api/session/{uuid}/screen[?format=text]:
+//
+//	{"cols": 80, "rows": 24, "title": "...", "cursor": {"x": 0, "y": 3, "visible": true},
+//	 "text": "...", "lines": [{"text": "$ ls", "spans": [{"text": "$ ls   ...", "fg": 2, "bold": true}, ...]}, ...]}
+//
+// format=text returns just the "text" field as text/plain.
+func handleSessionScreenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "text" {
+		http.Error(w, "Invalid format (want json or text)", http.StatusBadRequest)
+		return
+	}
+	sessionUUID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/screen")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil || sess.vt == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	sess.vtMu.Lock()
+	scr := readScreen(sess.vt)
+	sess.vtMu.Unlock()
+	scr.Text = maskSecrets(scr.Text) // secrets.go
+	for i := range scr.Lines {
+		scr.Lines[i].Text = maskSecrets(scr.Lines[i].Text)
+		for j := range scr.Lines[i].Spans {
+			scr.Lines[i].Spans[j].Text = maskSecrets(scr.Lines[i].Spans[j].Text)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, scr.Text)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scr)
+}
@@ -0,0 +1,175 @@
+// static_assets.go -- cache-aware serving of the embedded static files.
+//
+// Static files used to go straight from embed.FS through http.FileServer with
+// no validators (embed.FS has no modification times), so browsers re-fetched
+// every script on every page load, and /terminal-ui.js was read and
+// re-templated per request.
+//
+// loadStaticAssets now reads static/ once at startup into an in-memory table:
+//
+//   - terminal-ui.js gets its {{...}} defaults substituted here, once.
+//   - Each file gets a content hash (sha256, first 12 hex digits) and an
+//     ETag derived from it, so revalidation is a 304.
+//   - Compressible files (js, css, html, svg, json, md) get a gzip variant,
+//     kept when it is smaller. A "name.gz" or "name.br" file next to an
+//     asset in static/ is used as its precompressed gzip or brotli variant
+//     instead; the build does not produce .br files today, so brotli is only
+//     served when one is embedded. The variant is picked from
+//     Accept-Encoding, with Vary: Accept-Encoding.
+//
+// Pages reference assets through the "asset" template func,
+// {{asset "/xterm.js"}} -> "/xterm.js?v=3f2a1b4c5d6e". A request whose ?v
+// matches the current hash is cacheable for a year (immutable); anything
+// else is "no-cache", i.e. revalidated by ETag. ES module imports inside the
+// scripts (./modules/*.js) carry no hash and are revalidated the same way.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staticAsset is one embedded file, ready to serve.
+type staticAsset struct {
+	body        []byte
+	gzip        []byte // nil when not worth it
+	br          []byte // only from an embedded name.br
+	hash        string
+	contentType string
+}
+
+// staticAssets maps URL path ("/xterm.js", "/modules/util.js") to its asset.
+// Set once by loadStaticAssets before the server starts.
+var staticAssets map[string]*staticAsset
+
+// pageTemplateFuncs are the funcs every page template is parsed with.
+var pageTemplateFuncs = map[string]any{"asset": assetURL}
+
+// staticAssetTransforms rewrite an asset's content once at load time.
+var staticAssetTransforms = map[string]func(string) string{
+	// Replace template variables with defaults for dev mode; `swe-swe init`
+	// normally substitutes these before the server is built.
+	"terminal-ui.js": func(s string) string {
+		s = strings.ReplaceAll(s, "{{TERMINAL_FONT_SIZE}}", "14")
+		return strings.ReplaceAll(s, "{{TERMINAL_FONT_FAMILY}}", "Monaco, Menlo, Consolas, monospace")
+	},
+}
+
+// compressibleExt lists the extensions worth gzipping.
+var compressibleExt = map[string]bool{
+	".js": true, ".mjs": true, ".css": true, ".html": true, ".svg": true, ".json": true, ".md": true, ".txt": true,
+}
+
+// loadStaticAssets reads every file under fsys into a new asset table.
+func loadStaticAssets(fsys fs.FS) (map[string]*staticAsset, error) {
+	assets := map[string]*staticAsset{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := path.Ext(name)
+		if ext == ".gz" || ext == ".br" {
+			return nil // picked up as a variant of the file it compresses
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		if transform, ok := staticAssetTransforms[name]; ok {
+			body = []byte(transform(string(body)))
+		}
+		sum := sha256.Sum256(body)
+		a := &staticAsset{body: body, hash: hex.EncodeToString(sum[:])[:12], contentType: mime.TypeByExtension(ext)}
+		if a.contentType == "" {
+			a.contentType = http.DetectContentType(body)
+		}
+		if _, transformed := staticAssetTransforms[name]; !transformed {
+			// A precompressed sibling only matches the untransformed file.
+			a.gzip, _ = fs.ReadFile(fsys, name+".gz")
+			a.br, _ = fs.ReadFile(fsys, name+".br")
+		}
+		if a.gzip == nil && compressibleExt[ext] {
+			var buf bytes.Buffer
+			zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			zw.Write(body)
+			zw.Close()
+			if buf.Len() < len(body) {
+				a.gzip = buf.Bytes()
+			}
+		}
+		assets["/"+name] = a
+		return nil
+	})
+	return assets, err
+}
+
+// assetURL is the cache-busting URL for an embedded asset, or urlPath
+// unchanged when there is no such asset.
+func assetURL(urlPath string) string {
+	if a, ok := staticAssets[urlPath]; ok {
+		return urlPath + "?v=" + a.hash
+	}
+	return urlPath
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding
+// (a q=0 entry refuses it).
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			q, err := strconv.ParseFloat(v, 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// serveStaticAsset serves r from assets and reports whether it did; paths
+// not in the table are left to the caller.
+func serveStaticAsset(assets map[string]*staticAsset, w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	a, ok := assets[r.URL.Path]
+	if !ok {
+		return false
+	}
+	body, etag := a.body, `"`+a.hash+`"`
+	ae := r.Header.Get("Accept-Encoding")
+	switch {
+	case a.br != nil && acceptsEncoding(ae, "br"):
+		body, etag = a.br, `"`+a.hash+`-br"`
+		w.Header().Set("Content-Encoding", "br")
+	case a.gzip != nil && acceptsEncoding(ae, "gzip"):
+		body, etag = a.gzip, `"`+a.hash+`-gz"`
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	h := w.Header()
+	if a.gzip != nil || a.br != nil {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	h.Set("Content-Type", a.contentType)
+	h.Set("ETag", etag)
+	if r.URL.Query().Get("v") == a.hash {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	// ServeContent answers If-None-Match with 304, and handles HEAD and Range.
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	return true
+}
@@ -0,0 +1,386 @@
+// usage_report.go -- session usage ledger and daily/weekly reports.
+//
+// Recording metadata is the only record of which agent ran where and for how
+// long, and unkept recordings are deleted recentRecordingMaxAge after they
+// end. usageAggregator therefore copies every ended agent session into an
+// append-only ledger (recordings/usage.jsonl) on startup and hourly, so
+// reports can look back further than the recordings do:
+//
+//	GET /api/reports/usage?period=day|week[&date=YYYY-MM-DD][&format=markdown]
+//
+// returns session counts and durations per assistant and per repo for the day,
+// or the Monday-to-Sunday week, containing date (default today, in the
+// server's time zone). A session counts in the period it started in.
+//
+// With SWE_USAGE_REPORT=daily or weekly the aggregator also writes each
+// completed period's report as Markdown to .swe-swe/reports/ in the
+// workspace, once, so a team can review agent time in the repo.
+//
+// swe-swe does not see the agents' token usage or spend, so the report covers
+// time, not cost.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usageAggregateInterval = time.Hour
+
+// usageEntry is one ended agent session in the ledger. Name and Summary
+// keep the session's title for the session calendar (session_calendar.go)
+// after its recording is gone.
+type usageEntry struct {
+	UUID      string    `json:"uuid"`
+	Agent     string    `json:"agent"`
+	Repo      string    `json:"repo"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+	Name      string    `json:"name,omitempty"`
+	Summary   string    `json:"summary,omitempty"`
+}
+
+// usageGroup is the usage of one assistant or one repo within a report.
+type usageGroup struct {
+	Name            string `json:"name"`
+	Sessions        int    `json:"sessions"`
+	DurationSeconds int64  `json:"durationSeconds"`
+}
+
+// usageReport is the response of GET /api/reports/usage.
+type usageReport struct {
+	Period          string       `json:"period"`
+	From            time.Time    `json:"from"`
+	To              time.Time    `json:"to"` // exclusive
+	Sessions        int          `json:"sessions"`
+	DurationSeconds int64        `json:"durationSeconds"`
+	ByAssistant     []usageGroup `json:"byAssistant"`
+	ByRepo          []usageGroup `json:"byRepo"`
+}
+
+// usageMu serializes ledger appends.
+var usageMu sync.Mutex
+
+// usageReportPeriod is the period SWE_USAGE_REPORT writes Markdown reports
+// for ("day" or "week"), or "" when off. Set by loadUsageReport.
+var usageReportPeriod string
+
+// loadUsageReport applies SWE_USAGE_REPORT: "daily", "weekly", or empty (off).
+func loadUsageReport() error {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("SWE_USAGE_REPORT"))); v {
+	case "", "off":
+		usageReportPeriod = ""
+	case "daily":
+		usageReportPeriod = "day"
+	case "weekly":
+		usageReportPeriod = "week"
+	default:
+		return fmt.Errorf("SWE_USAGE_REPORT=%q: want daily, weekly or off", v)
+	}
+	return nil
+}
+
+func usageLedgerPath() string { return filepath.Join(recordingsDir, "usage.jsonl") }
+
+// usageAggregator keeps the ledger current and writes scheduled reports.
+func usageAggregator() {
+	defer recoverGoroutine("usage aggregator")
+	ticker := time.NewTicker(usageAggregateInterval)
+	defer ticker.Stop()
+	for {
+		if err := collectUsage(); err != nil {
+			log.Printf("Usage: %v", err)
+		} else if usageReportPeriod != "" {
+			if err := writeScheduledUsageReport(time.Now()); err != nil {
+				log.Printf("Usage report: %v", err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// readUsageLedger returns every ledger entry. A missing ledger is empty; a
+// malformed line is skipped.
+func readUsageLedger() ([]usageEntry, error) {
+	f, err := os.Open(usageLedgerPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []usageEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e usageEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && e.UUID != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// collectUsage appends ended agent sessions that are not in the ledger yet.
+func collectUsage() error {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	ledger, err := readUsageLedger()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(ledger))
+	for _, e := range ledger {
+		seen[e.UUID] = true
+	}
+
+	dirEntries, err := readRecordingEntries()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var added []usageEntry
+	for _, entry := range dirEntries {
+		stem, ok := strings.CutSuffix(strings.TrimPrefix(entry.Name(), "session-"), ".metadata.json")
+		if !ok || entry.IsDir() || !strings.HasPrefix(entry.Name(), "session-") {
+			continue
+		}
+		// Terminal and chat child recordings belong to their parent session.
+		parentUUID, childUUID, ok := parseRecordingFilename(stem)
+		if !ok || childUUID != "" || seen[parentUUID] {
+			continue
+		}
+		data, err := os.ReadFile(recordingPath(entry.Name()))
+		if err != nil {
+			continue
+		}
+		var meta RecordingMetadata
+		if json.Unmarshal(data, &meta) != nil || meta.EndedAt == nil || meta.StartedAt.IsZero() {
+			continue
+		}
+		summary := meta.SummaryLine
+		if summary == "" {
+			summary, _ = getSessionSummaryFromChat(parentUUID)
+		}
+		added = append(added, usageEntry{
+			UUID:      parentUUID,
+			Agent:     meta.Agent,
+			Repo:      usageRepo(meta.WorkDir),
+			StartedAt: meta.StartedAt,
+			EndedAt:   *meta.EndedAt,
+			Name:      meta.Name,
+			Summary:   summary,
+		})
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].EndedAt.Before(added[j].EndedAt) })
+
+	f, err := os.OpenFile(usageLedgerPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range added {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// usageRepo names the repo a session worked in: the /repos/{name} checkout
+// (its worktrees included), or "workspace" for the default workspace.
+func usageRepo(workDir string) string {
+	root := SessionPageQuery{WorkDir: workDir}.RepoRoot()
+	switch {
+	case root == "":
+		return "workspace"
+	case strings.HasPrefix(root, reposDir+"/"):
+		name, _, _ := strings.Cut(strings.TrimPrefix(root, reposDir+"/"), "/")
+		return name
+	}
+	return filepath.Base(root)
+}
+
+// usagePeriodBounds returns the day, or Monday-to-Sunday week, containing t.
+func usagePeriodBounds(period string, t time.Time) (from, to time.Time, err error) {
+	from = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case "day":
+		return from, from.AddDate(0, 0, 1), nil
+	case "week":
+		from = from.AddDate(0, 0, -((int(from.Weekday()) + 6) % 7))
+		return from, from.AddDate(0, 0, 7), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("period must be day or week")
+}
+
+// buildUsageReport totals the ledger entries that started in [from, to).
+func buildUsageReport(period string, from, to time.Time, entries []usageEntry) usageReport {
+	report := usageReport{Period: period, From: from, To: to}
+	byAssistant := map[string]*usageGroup{}
+	byRepo := map[string]*usageGroup{}
+	add := func(groups map[string]*usageGroup, name string, secs int64) {
+		g := groups[name]
+		if g == nil {
+			g = &usageGroup{Name: name}
+			groups[name] = g
+		}
+		g.Sessions++
+		g.DurationSeconds += secs
+	}
+	for _, e := range entries {
+		if e.StartedAt.Before(from) || !e.StartedAt.Before(to) {
+			continue
+		}
+		secs := int64(max(e.EndedAt.Sub(e.StartedAt), 0) / time.Second)
+		report.Sessions++
+		report.DurationSeconds += secs
+		add(byAssistant, e.Agent, secs)
+		add(byRepo, e.Repo, secs)
+	}
+	report.ByAssistant = sortedUsageGroups(byAssistant)
+	report.ByRepo = sortedUsageGroups(byRepo)
+	return report
+}
+
+// sortedUsageGroups orders groups by time spent, then name.
+func sortedUsageGroups(groups map[string]*usageGroup) []usageGroup {
+	list := make([]usageGroup, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, *g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].DurationSeconds != list[j].DurationSeconds {
+			return list[i].DurationSeconds > list[j].DurationSeconds
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// usageMarkdown renders a report for people.
+func usageMarkdown(r usageReport) string {
+	var b strings.Builder
+	title := "day " + r.From.Format("2006-01-02")
+	if r.Period == "week" {
+		title = "week of " + r.From.Format("2006-01-02")
+	}
+	fmt.Fprintf(&b, "# swe-swe usage: %s\n\n", title)
+	fmt.Fprintf(&b, "%s to %s: %d sessions, %s total.\n", r.From.Format("2006-01-02"), r.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		r.Sessions, formatDuration(time.Duration(r.DurationSeconds)*time.Second))
+	for _, section := range []struct {
+		heading, column string
+		groups          []usageGroup
+	}{{"By assistant", "Assistant", r.ByAssistant}, {"By repo", "Repo", r.ByRepo}} {
+		if len(section.groups) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n| %s | Sessions | Time |\n|---|---:|---:|\n", section.heading, section.column)
+		for _, g := range section.groups {
+			name := g.Name
+			if name == "" {
+				name = "(unknown)"
+			}
+			fmt.Fprintf(&b, "| %s | %d | %s |\n", strings.ReplaceAll(name, "|", "\\|"), g.Sessions, formatDuration(time.Duration(g.DurationSeconds)*time.Second))
+		}
+	}
+	b.WriteString("\nEnded agent sessions, counted in the period they started. Agent token spend is not tracked.\n")
+	return b.String()
+}
+
+// writeScheduledUsageReport writes the report for the last completed period
+// before now, unless it is already there.
+func writeScheduledUsageReport(now time.Time) error {
+	current, _, err := usagePeriodBounds(usageReportPeriod, now)
+	if err != nil {
+		return err
+	}
+	from, to, _ := usagePeriodBounds(usageReportPeriod, current.AddDate(0, 0, -1))
+	dir := filepath.Join(workspaceDir, ".swe-swe", "reports")
+	path := filepath.Join(dir, fmt.Sprintf("usage-%s-%s.md", usageReportPeriod, from.Format("2006-01-02")))
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	entries, err := readUsageLedger()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	report := buildUsageReport(usageReportPeriod, from, to, entries)
+	if err := os.WriteFile(path, []byte(usageMarkdown(report)), 0644); err != nil {
+		return err
+	}
+	log.Printf("Usage report written: %s (%d sessions)", path, report.Sessions)
+	return nil
+}
+
+// handleUsageReportAPI serves GET /api/reports/usage.
+func handleUsageReportAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	period := q.Get("period")
+	if period == "" {
+		period = "week"
+	}
+	day := time.Now()
+	if v := q.Get("date"); v != "" {
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	from, to, err := usagePeriodBounds(period, day)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Pick up sessions that ended since the last aggregation.
+	if err := collectUsage(); err != nil {
+		log.Printf("Usage: %v", err)
+	}
+	entries, err := readUsageLedger()
+	if err != nil {
+		http.Error(w, "Failed to read usage", http.StatusInternalServerError)
+		return
+	}
+	report := buildUsageReport(period, from, to, entries)
+
+	switch q.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	case "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(usageMarkdown(report)))
+	default:
+		http.Error(w, "format must be json or markdown", http.StatusBadRequest)
+	}
+}
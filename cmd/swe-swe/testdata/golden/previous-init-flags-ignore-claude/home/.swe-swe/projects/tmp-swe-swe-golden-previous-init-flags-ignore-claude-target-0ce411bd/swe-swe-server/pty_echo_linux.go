@@ -0,0 +1,30 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ptyEchoOff reports whether the terminal behind the PTY master f is reading
+// a line without echoing it, as getpass(3) does: ECHO off, ICANON on. On Linux
+// the master reports its slave's termios.
+func ptyEchoOff(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var t syscall.Termios
+	var errno syscall.Errno
+	if err := raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	}); err != nil || errno != 0 {
+		return false
+	}
+	return t.Lflag&syscall.ECHO == 0 && t.Lflag&syscall.ICANON != 0
+}
@@ -0,0 +1,252 @@
+// admin_events.go -- one WebSocket with the events of every session.
+//
+// A dashboard or bot that wants to follow every session would otherwise open
+// each session's WebSocket, taking a viewer slot and a PTY-size vote in each.
+// /ws/admin streams JSON text frames about all of them instead. The first is
+// the current state:
+//
+//	{"type": "sync", "time": "...", "sessions": [<session>, ...]}
+//
+// and then, as they happen:
+//
+//	session_created   {"session": "<uuid>", "info": <session>}
+//	status            {"session": "<uuid>", "info": <session>} -- name,
+//	                  viewers or agent state changed
+//	agent_state       {"session": "<uuid>", "state": "busy|idle|waiting_approval"}
+//	attention         {"session": "<uuid>", "reason": "waiting_approval"} or
+//	                  {"session": "<uuid>", "reason": "exited", "exitCode": 1}
+//	session_exited    {"session": "<uuid>", "exitCode": 0}
+//	session_ended     {"session": "<uuid>"} -- removed from the server
+//	recording_kept    {"recording": "<recording uuid>"}
+//	branch_moved      {"repo": "/workspace", "branch": "main", "from": "<sha>",
+//	                  "to": "<sha>", "commits": 3} -- a background fetch
+//	                  moved one of origin's branches (repo_fetch.go)
+//	env_changed       {"repo": "/workspace", "user": "ana", "keys": ["API_URL"]}
+//	                  -- a repo's .swe-swe/env was written (repo_env.go)
+//	hibernation       {"session": "<uuid>", "state": "hibernated|resuming|
+//	                  awake|failed"} -- an idle session's agent was stopped,
+//	                  or is started again (session_hibernate.go)
+//	assistant_changed {"assistant": "claude", "reason": "missing|installed|
+//	                  version", "from": "<version>", "to": "<version>"} --
+//	                  an assistant's binary was removed, installed or
+//	                  upgraded (assistant_health.go)
+//
+// Every event has "type" and "time". <session> is adminSessionInfo. Events
+// published while the sync is being built may repeat what it says.
+//
+// Like broadcast viewers (session_broadcast.go), a subscriber never slows a
+// session down: events are encoded once and queued per subscriber, and one
+// that falls adminEventQueue events behind is disconnected, to reconnect into
+// a fresh sync. Clients' messages are read only to keep the connection alive.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	adminEventsPath = "/ws/admin"
+	// adminEventQueue is how many events a subscriber may fall behind.
+	adminEventQueue = 256
+)
+
+// adminEvent is one event of /ws/admin.
+type adminEvent struct {
+	Type      string             `json:"type"`
+	Time      time.Time          `json:"time"`
+	Session   string             `json:"session,omitempty"`
+	Info      *adminSessionInfo  `json:"info,omitempty"`
+	Sessions  []adminSessionInfo `json:"sessions,omitempty"`
+	State     string             `json:"state,omitempty"`
+	Reason    string             `json:"reason,omitempty"`
+	ExitCode  *int               `json:"exitCode,omitempty"`
+	Recording string             `json:"recording,omitempty"`
+	// branch_moved (repo_fetch.go)
+	Repo    string `json:"repo,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Commits int    `json:"commits,omitempty"`
+	// env_changed (repo_env.go)
+	User string   `json:"user,omitempty"`
+	Keys []string `json:"keys,omitempty"`
+	// assistant_changed (assistant_health.go); reuses Reason, From and To
+	Assistant string `json:"assistant,omitempty"`
+}
+
+// adminSessionInfo is a session as /ws/admin describes it.
+type adminSessionInfo struct {
+	UUID          string    `json:"uuid"`
+	Name          string    `json:"name,omitempty"`
+	Assistant     string    `json:"assistant"`
+	WorkDir       string    `json:"workDir,omitempty"`
+	ParentUUID    string    `json:"parentUUID,omitempty"`
+	RecordingUUID string    `json:"recordingUUID,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Viewers       int       `json:"viewers"`
+	AgentState    string    `json:"agentState,omitempty"`
+	Ending        bool      `json:"ending,omitempty"`
+	Exited        bool      `json:"exited,omitempty"`
+	Hibernated    bool      `json:"hibernated,omitempty"`
+}
+
+// adminHub holds the /ws/admin subscribers.
+var adminHub = struct {
+	mu   sync.Mutex
+	subs map[chan []byte]bool
+}{subs: map[chan []byte]bool{}}
+
+// publishAdminEvent sends ev to every subscriber. It never blocks.
+func publishAdminEvent(ev adminEvent) {
+	adminHub.mu.Lock()
+	defer adminHub.mu.Unlock()
+	if len(adminHub.subs) == 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Admin events: %v", err)
+		return
+	}
+	for ch := range adminHub.subs {
+		select {
+		case ch <- data:
+		default:
+			// Too far behind: drop it, and it reconnects into a sync.
+			delete(adminHub.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribeAdminEvents adds a subscriber. The channel is closed when the
+// subscriber is dropped or unsubscribed.
+func subscribeAdminEvents() chan []byte {
+	ch := make(chan []byte, adminEventQueue)
+	adminHub.mu.Lock()
+	adminHub.subs[ch] = true
+	adminHub.mu.Unlock()
+	return ch
+}
+
+func unsubscribeAdminEvents(ch chan []byte) {
+	adminHub.mu.Lock()
+	defer adminHub.mu.Unlock()
+	if adminHub.subs[ch] {
+		delete(adminHub.subs, ch)
+		close(ch)
+	}
+}
+
+// adminInfoLocked describes the session. Must hold s.mu (read or write).
+func (s *Session) adminInfoLocked() adminSessionInfo {
+	return adminSessionInfo{
+		UUID:          s.UUID,
+		Name:          s.Name,
+		Assistant:     s.Assistant,
+		WorkDir:       s.WorkDir,
+		ParentUUID:    s.ParentUUID,
+		RecordingUUID: s.RecordingUUID,
+		CreatedAt:     s.CreatedAt,
+		Viewers:       len(s.wsClients),
+		AgentState:    s.AgentState(),
+		Ending:        s.ending,
+		Exited:        s.exited(),
+		Hibernated:    s.hibernated(),
+	}
+}
+
+// adminInfo describes the session.
+func (s *Session) adminInfo() adminSessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.adminInfoLocked()
+}
+
+// publishAdminStatusLocked sends a status event when the session's
+// description changed since the last one. Must hold s.mu.
+func (s *Session) publishAdminStatusLocked() {
+	info := s.adminInfoLocked()
+	key, _ := json.Marshal(info)
+	if prev, _ := s.adminStatus.Swap(string(key)).(string); prev == string(key) {
+		return
+	}
+	publishAdminEvent(adminEvent{Type: "status", Session: s.UUID, Info: &info})
+}
+
+// publishAdminExit sends session_exited, and attention for a failure.
+func publishAdminExit(uuid string, exitCode int) {
+	publishAdminEvent(adminEvent{Type: "session_exited", Session: uuid, ExitCode: &exitCode})
+	if exitCode != 0 {
+		publishAdminEvent(adminEvent{Type: "attention", Session: uuid, Reason: "exited", ExitCode: &exitCode})
+	}
+}
+
+// adminSync is the sync event: every session now.
+func adminSync() adminEvent {
+	sessionsMu.RLock()
+	live := make([]*Session, 0, len(sessions))
+	for _, sess := range sessions {
+		live = append(live, sess)
+	}
+	sessionsMu.RUnlock()
+	infos := make([]adminSessionInfo, 0, len(live))
+	for _, sess := range live {
+		infos = append(infos, sess.adminInfo())
+	}
+	return adminEvent{Type: "sync", Time: time.Now(), Sessions: infos}
+}
+
+// handleAdminEvents serves /ws/admin.
+func handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Admin events: upgrade: %v", err)
+		return
+	}
+	conn := NewSafeConn(ws)
+	defer conn.Close()
+
+	// Subscribe before the sync, so nothing falls between the two.
+	ch := subscribeAdminEvents()
+	defer unsubscribeAdminEvents(ch)
+	if err := conn.WriteJSON(adminSync()); err != nil {
+		return
+	}
+
+	readDone := make(chan struct{})
+	go func() {
+		defer recoverGoroutine("admin events reader")
+		defer close(readDone)
+		defer startWSHeartbeat(conn)()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case data, ok := <-ch:
+			if !ok {
+				conn.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "fell behind; reconnect"), time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-readDone:
+			return
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// assistant_health.go -- re-detects the installed assistants while the
+// server runs, and records each one's version.
+//
+// detectAvailableAssistants looks the binaries up once, at startup; an
+// assistant installed, upgraded or removed afterwards (by an agent, or by
+// someone in a terminal tab) went unnoticed until a restart. Every
+// SWE_ASSISTANT_CHECK_INTERVAL (10m by default, "off" to check only at
+// startup) each homepage assistant's binary is looked up again and asked for
+// `<binary> --version`:
+//
+//   - availableAssistants follows what is installed, so the homepage offers
+//     a newly installed assistant and stops offering a removed one (running
+//     sessions keep their agent);
+//   - a change is logged and published on /ws/admin as assistant_changed,
+//     with reason "missing", "installed" or "version";
+//   - the versions (and auth checks, assistant_auth.go) are listed in GET
+//     /api/sessions/live ("assistants"), and a new session's recording
+//     metadata keeps its agent's version (agent_version).
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAssistantCheckInterval applies when SWE_ASSISTANT_CHECK_INTERVAL
+	// is unset.
+	defaultAssistantCheckInterval = 10 * time.Minute
+	// assistantVersionTimeout bounds one `<binary> --version`.
+	assistantVersionTimeout = 10 * time.Second
+	// assistantVersionMax caps the version string kept.
+	assistantVersionMax = 120
+)
+
+// assistantCheckInterval is SWE_ASSISTANT_CHECK_INTERVAL; 0 is off.
+var assistantCheckInterval = defaultAssistantCheckInterval
+
+// loadAssistantCheckInterval applies SWE_ASSISTANT_CHECK_INTERVAL: a Go
+// duration of at least a minute, "off"/"0" for no re-detection, or empty for
+// the default.
+func loadAssistantCheckInterval() error {
+	v := strings.TrimSpace(os.Getenv("SWE_ASSISTANT_CHECK_INTERVAL"))
+	switch strings.ToLower(v) {
+	case "":
+		assistantCheckInterval = defaultAssistantCheckInterval
+		return nil
+	case "0", "off":
+		assistantCheckInterval = 0
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Minute {
+		return fmt.Errorf("SWE_ASSISTANT_CHECK_INTERVAL=%q: want a duration of at least 1m, or off", v)
+	}
+	assistantCheckInterval = d
+	return nil
+}
+
+// availableAssistantsMu guards availableAssistants, which re-detection
+// replaces while the server runs.
+var availableAssistantsMu sync.RWMutex
+
+// installedAssistants returns the assistants sessions can be started with.
+// The slice is replaced, never changed in place, so callers may keep it.
+func installedAssistants() []AssistantConfig {
+	availableAssistantsMu.RLock()
+	defer availableAssistantsMu.RUnlock()
+	return availableAssistants
+}
+
+func setAvailableAssistants(list []AssistantConfig) {
+	availableAssistantsMu.Lock()
+	availableAssistants = list
+	availableAssistantsMu.Unlock()
+}
+
+// assistantStatus is what the last check found of one homepage assistant.
+type assistantStatus struct {
+	Binary        string    `json:"binary"`
+	Name          string    `json:"name"`
+	Installed     bool      `json:"installed"`
+	Path          string    `json:"path,omitempty"`
+	Version       string    `json:"version,omitempty"` // first line of --version; empty if it failed
+	CheckedAt     time.Time `json:"checkedAt"`
+	assistantAuth           // assistant_auth.go
+}
+
+// assistantHealth holds the last check's findings, by binary.
+var assistantHealth = struct {
+	mu       sync.Mutex
+	statuses map[string]assistantStatus
+}{statuses: map[string]assistantStatus{}}
+
+// assistantChecker checks the assistants at startup and then every
+// assistantCheckInterval.
+func assistantChecker() {
+	defer recoverGoroutine("assistant checker")
+	checkAssistants()
+	if assistantCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(assistantCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkAssistants()
+	}
+}
+
+// checkAssistants looks up every homepage assistant and records what it
+// found.
+func checkAssistants() {
+	var found []assistantStatus
+	for _, cfg := range assistantConfigs {
+		if !cfg.Homepage {
+			continue
+		}
+		st := assistantStatus{Binary: cfg.Binary, Name: cfg.Name, CheckedAt: time.Now()}
+		if path, err := exec.LookPath(cfg.Binary); err == nil {
+			st.Installed, st.Path = true, path
+			st.Version = assistantVersion(path)
+			st.assistantAuth = checkAuth(cfg)
+		}
+		found = append(found, st)
+	}
+	recordAssistantStatuses(found)
+}
+
+// recordAssistantStatuses stores a check's findings, reports what changed
+// since the last check, and updates availableAssistants to match.
+func recordAssistantStatuses(found []assistantStatus) {
+	installed := map[string]bool{}
+	assistantHealth.mu.Lock()
+	for _, st := range found {
+		installed[st.Binary] = st.Installed
+		prev, seen := assistantHealth.statuses[st.Binary]
+		assistantHealth.statuses[st.Binary] = st
+		if !seen {
+			continue
+		}
+		ev := adminEvent{Type: "assistant_changed", Assistant: st.Binary, From: prev.Version, To: st.Version}
+		switch {
+		case prev.Installed && !st.Installed:
+			ev.Reason = "missing"
+			log.Printf("WARNING: assistant %s is no longer installed (was %s)", st.Binary, prev.Path)
+		case !prev.Installed && st.Installed:
+			ev.Reason = "installed"
+			log.Printf("Assistant %s is now installed: %s %s", st.Binary, st.Path, st.Version)
+		case st.Installed && prev.Version != "" && st.Version != "" && prev.Version != st.Version:
+			// A failed --version is not a change: the next check says more.
+			ev.Reason = "version"
+			log.Printf("WARNING: assistant %s changed version: %s -> %s", st.Binary, prev.Version, st.Version)
+		default:
+			if st.Version == "" && st.Installed {
+				// Keep the version last seen until it can be read again.
+				st.Version = prev.Version
+				assistantHealth.statuses[st.Binary] = st
+			}
+			continue
+		}
+		publishAdminEvent(ev)
+	}
+	assistantHealth.mu.Unlock()
+
+	setAvailableAssistants(detectAssistants(func(binary string) bool { return installed[binary] }))
+}
+
+// assistantStatuses lists the last check's findings, in assistantConfigs
+// order; empty before the first check ends.
+func assistantStatuses() []assistantStatus {
+	assistantHealth.mu.Lock()
+	defer assistantHealth.mu.Unlock()
+	list := []assistantStatus{}
+	for _, cfg := range assistantConfigs {
+		if st, ok := assistantHealth.statuses[cfg.Binary]; ok {
+			list = append(list, st)
+		}
+	}
+	return list
+}
+
+// installedAssistantVersion is the version the last check read of binary,
+// or "" when unknown.
+func installedAssistantVersion(binary string) string {
+	assistantHealth.mu.Lock()
+	defer assistantHealth.mu.Unlock()
+	return assistantHealth.statuses[binary].Version
+}
+
+// assistantVersion runs `<path> --version` and returns the first non-empty
+// line it prints, or "" if it fails.
+func assistantVersion(path string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), assistantVersionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, "--version")
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return firstVersionLine(out)
+}
+
+// firstVersionLine is the first non-empty line of out, capped at
+// assistantVersionMax bytes.
+func firstVersionLine(out []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			return truncateUTF8(line, assistantVersionMax)
+		}
+	}
+	return ""
+}
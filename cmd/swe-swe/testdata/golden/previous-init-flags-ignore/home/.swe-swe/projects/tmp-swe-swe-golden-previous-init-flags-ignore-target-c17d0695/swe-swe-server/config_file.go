@@ -0,0 +1,359 @@
+// config_file.go -- optional JSON config file (-config / SWE_CONFIG).
+//
+// swe-swe-server is configured by flags and SWE_* env vars spread across
+// main(), auth.go, browser_backend*.go, listen.go and exec_api.go. Rather than
+// threading a config struct through all of them, the config file is applied
+// as one more layer underneath the existing resolution: each file key maps to
+// the env var (or, for flag-only settings, the flag) that already controls it,
+// and is applied only when neither the flag nor the env var was given. The
+// effective precedence is therefore flag -> env -> config file -> default, and
+// every existing reader keeps working unchanged.
+//
+// The file is JSON, grouped by section:
+//
+//	{
+//	  "listen":    {"bind": "127.0.0.1:1977"},
+//	  "ports":     {"preview": "3000-3019", "public": "5000-5019"},
+//	  "assistant": {"shell": "claude", "shellRestart": "claude --continue"},
+//	  "auth":      {"password": "...", "trustForwardedFor": true},
+//	  "exec":      {"allow": ["make test", "git log"]}
+//	}
+//
+// Unknown keys are a startup error, so a typo never silently falls back to a
+// default. GET /api/config reports the effective values and where each came
+// from, with secrets masked.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// configSetting maps one config-file key to the env var or flag it feeds.
+type configSetting struct {
+	Key    string // dotted config-file key, e.g. "ports.preview"
+	Env    string // env var the value is applied through ("" for flag-only)
+	Flag   string // flag that overrides it ("" when env-only)
+	Secret bool   // masked by /api/config
+	True   string // env value a JSON true becomes (default "true")
+}
+
+// configSettings lists every key the config file accepts.
+var configSettings = []configSetting{
+	{Key: "listen.bind", Env: "SWE_BIND", Flag: "bind"},
+	{Key: "listen.port", Env: "SWE_PORT"},
+
+	{Key: "paths.workspace", Env: "SWE_WORKSPACE_DIR", Flag: "workspace"},
+	{Key: "paths.worktrees", Env: "SWE_WORKTREES_DIR", Flag: "worktrees"},
+	{Key: "paths.repos", Env: "SWE_REPOS_DIR", Flag: "repos"},
+	{Key: "paths.sweHome", Env: "SWE_HOME_DIR", Flag: "swe-home"},
+	{Key: "paths.templates", Env: "SWE_TEMPLATES_DIR", Flag: "templates-dir"},
+
+	{Key: "ports.preview", Env: "SWE_PREVIEW_PORTS"},
+	{Key: "ports.agentChat", Env: "SWE_AGENT_CHAT_PORTS"},
+	{Key: "ports.public", Env: "SWE_PUBLIC_PORTS"},
+	{Key: "ports.cdp", Env: "SWE_CDP_PORTS"},
+	{Key: "ports.vnc", Env: "SWE_VNC_PORTS"},
+	{Key: "ports.files", Env: "SWE_FILES_PORTS"},
+	{Key: "ports.proxyOffset", Env: "SWE_PROXY_PORT_OFFSET"},
+
+	{Key: "assistant.shell", Flag: "shell"},
+	{Key: "assistant.shellRestart", Flag: "shell-restart"},
+	{Key: "assistant.workingDirectory", Flag: "working-directory"},
+	{Key: "assistant.checkInterval", Env: "SWE_ASSISTANT_CHECK_INTERVAL"},
+
+	{Key: "auth.password", Env: "SWE_SWE_PASSWORD", Secret: true},
+	{Key: "auth.cookieSecure", Env: "SWE_COOKIE_SECURE"},
+	{Key: "auth.trustForwardedFor", Env: "SWE_TRUST_FORWARDED_FOR"},
+	{Key: "auth.trustedProxies", Env: "SWE_TRUSTED_PROXIES"},
+	{Key: "auth.tailscaleIdentity", Env: "SWE_TAILSCALE_IDENTITY", True: "1"},
+	{Key: "auth.users", Env: "SWE_AUTH_USERS", Secret: true},
+	{Key: "auth.roles", Env: "SWE_AUTH_ROLES"},
+	{Key: "tls.certPath", Env: "TLS_CERT_PATH"},
+
+	{Key: "preview.vhostSuffix", Env: "SWE_PREVIEW_VHOST_SUFFIX"},
+	{Key: "preview.reachDomain", Env: "SWE_PREVIEW_REACH_DOMAIN"},
+	{Key: "preview.proxyMode", Env: "SWE_PROXY_MODE"},
+	{Key: "preview.domain", Env: "SWE_PREVIEW_DOMAIN"},
+
+	{Key: "agentView.backend", Env: "SWE_AGENT_VIEW", Flag: "agent-view"},
+	{Key: "agentView.tunnel", Env: "SWE_AGENT_VIEW_TUNNEL", Flag: "agent-view-tunnel", True: "1"},
+	{Key: "agentView.browserBackendToken", Env: "SWE_BROWSER_BACKEND_TOKEN", Secret: true},
+	{Key: "agentView.browserBackendHost", Env: "SWE_BROWSER_BACKEND_HOST", Flag: "browser-backend-host"},
+
+	{Key: "tunnel.serverURL", Env: "SWE_TUNNEL_SERVER_URL", Flag: "tunnel-server-url"},
+	{Key: "tunnel.unique", Env: "SWE_TUNNEL_UNIQUE", Flag: "tunnel-unique"},
+	{Key: "tunnel.bin", Env: "SWE_TUNNEL_BIN", Flag: "tunnel-bin"},
+	{Key: "tunnel.clientCert", Env: "SWE_TUNNEL_CLIENT_CERT", Flag: "tunnel-client-cert"},
+
+	{Key: "landing.url", Env: "SWE_LANDING_URL"},
+	{Key: "landing.disable", Env: "SWE_LANDING_DISABLE", True: "1"},
+
+	{Key: "mdns.enable", Env: "SWE_MDNS", True: "1"},
+	{Key: "mdns.name", Env: "SWE_MDNS_NAME"},
+
+	{Key: "exec.allow", Env: "SWE_EXEC_ALLOW"},
+
+	{Key: "rateLimit.limits", Env: "SWE_RATE_LIMITS"},
+
+	{Key: "usage.report", Env: "SWE_USAGE_REPORT"},
+
+	{Key: "backup.schedule", Env: "SWE_BACKUP_SCHEDULE"},
+	{Key: "backup.destination", Env: "SWE_BACKUP_DEST"},
+	{Key: "backup.keep", Env: "SWE_BACKUP_KEEP"},
+
+	{Key: "session.max", Env: "SWE_MAX_SESSIONS"},
+	{Key: "session.maxPerAssistant", Env: "SWE_MAX_SESSIONS_PER_ASSISTANT"},
+	{Key: "session.names", Env: "SWE_SESSION_NAMES"},
+	{Key: "session.autoName", Env: "SWE_SESSION_AUTONAME", True: "1"},
+	{Key: "session.tmpDir", Env: "SWE_SESSION_TMPDIR"},
+	{Key: "session.checkpoints", Env: "SWE_CHECKPOINTS"},
+	{Key: "session.fsWatch", Env: "SWE_FS_WATCH"},
+	{Key: "session.hibernateAfter", Env: "SWE_HIBERNATE_AFTER"},
+	{Key: "repos.fetchInterval", Env: "SWE_FETCH_INTERVAL", Flag: "fetch-interval"},
+
+	{Key: "hooks.timeout", Env: "SWE_HOOK_TIMEOUT"},
+	{Key: "hooks.setupTimeout", Env: "SWE_SETUP_TIMEOUT"},
+	{Key: "tests.command", Env: "SWE_TEST_CMD"},
+	{Key: "tests.timeout", Env: "SWE_TEST_TIMEOUT"},
+	{Key: "preview.command", Env: "SWE_PREVIEW_CMD"},
+	{Key: "secrets.key", Env: "SWE_SECRETS_KEY", Secret: true},
+	{Key: "secrets.dir", Env: "SWE_SECRETS_DIR"},
+
+	{Key: "github.webhookSecret", Env: "SWE_GITHUB_WEBHOOK_SECRET", Secret: true},
+	{Key: "github.webhookActions", Env: "SWE_GITHUB_WEBHOOK_ACTIONS"},
+	{Key: "github.webhookAnyAuthor", Env: "SWE_GITHUB_WEBHOOK_ANY_AUTHOR", True: "1"},
+	{Key: "github.reviewAssistant", Env: "SWE_GITHUB_REVIEW_ASSISTANT"},
+	{Key: "github.reviewPrompt", Env: "SWE_GITHUB_REVIEW_PROMPT"},
+	{Key: "github.appID", Env: "SWE_GITHUB_APP_ID"},
+	{Key: "github.appKeyFile", Env: "SWE_GITHUB_APP_KEY_FILE"},
+
+	{Key: "session.backend", Env: "SWE_SESSION_BACKEND"},
+	{Key: "agentChat.command", Env: "SWE_AGENT_CHAT_CMD"},
+	{Key: "session.k8s.image", Env: "SWE_K8S_IMAGE"},
+	{Key: "session.k8s.namespace", Env: "SWE_K8S_NAMESPACE"},
+	{Key: "session.k8s.volumes", Env: "SWE_K8S_VOLUMES"},
+	{Key: "session.k8s.cpu", Env: "SWE_K8S_CPU"},
+	{Key: "session.k8s.memory", Env: "SWE_K8S_MEMORY"},
+	{Key: "session.ssh.host", Env: "SWE_SSH_HOST"},
+	{Key: "session.ssh.user", Env: "SWE_SSH_USER"},
+	{Key: "session.ssh.port", Env: "SWE_SSH_PORT"},
+	{Key: "session.ssh.key", Env: "SWE_SSH_KEY"},
+	{Key: "session.ssh.dir", Env: "SWE_SSH_DIR"},
+
+	{Key: "log.format", Env: "SWE_LOG_FORMAT", Flag: "log-format"},
+	{Key: "log.level", Env: "SWE_LOG_LEVEL", Flag: "log-level"},
+	{Key: "log.file", Env: "SWE_LOG_FILE", Flag: "log-file"},
+	{Key: "log.fileMaxMB", Flag: "log-file-max-mb"},
+	{Key: "log.fileBackups", Flag: "log-file-backups"},
+}
+
+// Where an effective setting came from, as reported by /api/config.
+const (
+	configSourceFlag    = "flag"
+	configSourceEnv     = "env"
+	configSourceFile    = "config"
+	configSourceDefault = "default"
+)
+
+// serverConfigPath is the config file in effect ("" when none), and
+// serverConfigSources records the source of each setting at startup.
+var (
+	serverConfigPath    string
+	serverConfigSources = map[string]string{}
+)
+
+// flattenConfig turns the nested config object into dotted keys
+// ({"ports": {"preview": ...}} -> "ports.preview").
+func flattenConfig(prefix string, in map[string]interface{}, out map[string]interface{}) {
+	for k, v := range in {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if obj, ok := v.(map[string]interface{}); ok {
+			flattenConfig(key, obj, out)
+			continue
+		}
+		out[key] = v
+	}
+}
+
+// configValueString converts a decoded JSON leaf into the string the env var
+// or flag expects: numbers verbatim, booleans via the setting's True value
+// (or "false"), string arrays comma-joined.
+func configValueString(s configSetting, v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	case bool:
+		if !x {
+			return "false", nil
+		}
+		if s.True != "" {
+			return s.True, nil
+		}
+		return "true", nil
+	case []interface{}:
+		parts := make([]string, 0, len(x))
+		for _, e := range x {
+			str, ok := e.(string)
+			if !ok {
+				return "", fmt.Errorf("%s: list entries must be strings", s.Key)
+			}
+			parts = append(parts, str)
+		}
+		return strings.Join(parts, ","), nil
+	}
+	return "", fmt.Errorf("%s: unsupported value %v", s.Key, v)
+}
+
+// parseConfigFile decodes data into setting key -> string value, rejecting
+// unknown keys.
+func parseConfigFile(data []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	flat := map[string]interface{}{}
+	flattenConfig("", raw, flat)
+
+	known := map[string]configSetting{}
+	for _, s := range configSettings {
+		known[s.Key] = s
+	}
+	var unknown []string
+	values := map[string]string{}
+	for key, v := range flat {
+		s, ok := known[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		if v == nil {
+			continue
+		}
+		str, err := configValueString(s, v)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = str
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+// applyServerConfig loads the config file at path (if any) and applies each
+// value whose flag was not passed and whose env var is unset or empty (compose
+// passes most SWE_* vars through as "" when the host leaves them unset). It
+// must run right after fs.Parse, before anything reads the env. Returns the
+// source of every setting.
+func applyServerConfig(fs *flag.FlagSet, path string) (map[string]string, error) {
+	values := map[string]string{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if values, err = parseConfigFile(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	passed := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+
+	sources := map[string]string{}
+	for _, s := range configSettings {
+		envSet := s.Env != "" && os.Getenv(s.Env) != ""
+		v, inFile := values[s.Key]
+		switch {
+		case s.Flag != "" && passed[s.Flag]:
+			sources[s.Key] = configSourceFlag
+		case envSet:
+			sources[s.Key] = configSourceEnv
+		case inFile:
+			sources[s.Key] = configSourceFile
+			var err error
+			if s.Env != "" {
+				err = os.Setenv(s.Env, v)
+			} else {
+				err = fs.Set(s.Flag, v)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", s.Key, err)
+			}
+		default:
+			sources[s.Key] = configSourceDefault
+		}
+	}
+	return sources, nil
+}
+
+// effectiveConfigValue returns the value a setting currently resolves to:
+// the flag's value when the flag wins or is the only channel, else the env.
+func effectiveConfigValue(fs *flag.FlagSet, s configSetting, source string) string {
+	if s.Flag != "" && (source == configSourceFlag || s.Env == "") {
+		if f := fs.Lookup(s.Flag); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	}
+	return os.Getenv(s.Env)
+}
+
+// configEntry is one setting in the /api/config response.
+type configEntry struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Env    string `json:"env,omitempty"`
+	Flag   string `json:"flag,omitempty"`
+}
+
+// buildConfigReport assembles the /api/config payload. Secrets that are set
+// are replaced by "********"; an unset secret stays empty so "is a password
+// configured?" is still answerable.
+func buildConfigReport(fs *flag.FlagSet, path string, sources map[string]string) map[string]interface{} {
+	settings := map[string]configEntry{}
+	for _, s := range configSettings {
+		source := sources[s.Key]
+		if source == "" {
+			source = configSourceDefault
+		}
+		v := effectiveConfigValue(fs, s, source)
+		if s.Secret && v != "" {
+			v = "********"
+		}
+		settings[s.Key] = configEntry{Value: v, Source: source, Env: s.Env, Flag: s.Flag}
+	}
+	return map[string]interface{}{
+		"configFile": path,
+		"settings":   settings,
+	}
+}
+
+// handleConfigAPI handles GET /api/config: the effective server configuration
+// (read-only, secrets masked). Behind the auth cookie and denied to
+// shared-session guests.
+func handleConfigAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(buildConfigReport(flag.CommandLine, serverConfigPath, serverConfigSources))
+}
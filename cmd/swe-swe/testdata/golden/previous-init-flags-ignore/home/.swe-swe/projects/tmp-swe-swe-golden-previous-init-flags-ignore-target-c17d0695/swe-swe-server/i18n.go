@@ -0,0 +1,266 @@
+// i18n.go -- message catalogs for server-rendered strings.
+//
+// The server writes a few strings of its own: terminal notices such as
+// "[Process exited (code 0)]", the agent chat "waiting" page, and the App
+// Preview page shown while nothing listens on the preview port. They were
+// English-only. Each locale now has a catalog, locales/<tag>.json, embedded
+// in the binary and mapping a message key to a fmt format:
+//
+//	{"terminal.processExited": "[Process exited (code %d)]", ...}
+//
+// The language comes from the swe-swe-lang cookie when it names a catalog,
+// else from Accept-Language (highest q first; "es-MX" falls back to "es",
+// "zh" to "zh-CN"), else English. A key missing from a catalog falls back to
+// en.json, so a partial translation never shows a blank.
+//
+// Terminal notices are written into the session's shared screen, so they use
+// the language of the browser that created the session. The App Preview page
+// is rendered by agent-reverse-proxy; localizePreviewErrorPage rewrites its
+// English text on the way out, leaving every other response untouched.
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// defaultLocale is the catalog every other one falls back to.
+const defaultLocale = "en"
+
+// localeCookie overrides Accept-Language when set to a catalog name.
+const localeCookie = "swe-swe-lang"
+
+// catalogs maps a locale tag ("en", "zh-CN") to its messages.
+var catalogs = mustLoadCatalogs(localesFS)
+
+// catalogTags maps a lowercased tag to its catalog name, for matching
+// request tags case-insensitively.
+var catalogTags = func() map[string]string {
+	tags := map[string]string{}
+	for name := range catalogs {
+		tags[strings.ToLower(name)] = name
+	}
+	return tags
+}()
+
+func mustLoadCatalogs(fsys fs.FS) map[string]map[string]string {
+	files, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		panic(err)
+	}
+	out := map[string]map[string]string{}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			panic(err)
+		}
+		var msgs map[string]string
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			panic(fmt.Sprintf("%s: %v", file, err))
+		}
+		out[strings.TrimSuffix(path.Base(file), ".json")] = msgs
+	}
+	return out
+}
+
+// tr returns message key in locale, formatted with args. Missing keys fall
+// back to English, then to the key itself.
+func tr(locale, key string, args ...any) string {
+	msg, ok := catalogs[locale][key]
+	if !ok {
+		if msg, ok = catalogs[defaultLocale][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// trHTML is tr for HTML output: the message is escaped, args are inserted
+// as-is (callers pass markup or already-escaped text).
+func trHTML(locale, key string, args ...any) string {
+	return fmt.Sprintf(html.EscapeString(tr(locale, key)), args...)
+}
+
+// matchLocale returns the catalog for a language tag: an exact match, else
+// the first catalog sharing its primary language, else "".
+func matchLocale(tag string) string {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if name, ok := catalogTags[tag]; ok {
+		return name
+	}
+	base, _, _ := strings.Cut(tag, "-")
+	var names []string
+	for lower, name := range catalogTags {
+		if b, _, _ := strings.Cut(lower, "-"); b == base {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// requestLocale picks the catalog for r (see the file comment).
+func requestLocale(r *http.Request) string {
+	if c, err := r.Cookie(localeCookie); err == nil {
+		if name := matchLocale(c.Value); name != "" {
+			return name
+		}
+	}
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var prefs []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if tag != "" && tag != "*" && q > 0 {
+			prefs = append(prefs, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		if name := matchLocale(p.tag); name != "" {
+			return name
+		}
+	}
+	return defaultLocale
+}
+
+// previewErrorPageMarker identifies agent-reverse-proxy's "app not running"
+// page, so an app's own 502 page is passed through untouched.
+const previewErrorPageMarker = `<span id="status-text">Listening for app...</span>`
+
+// previewErrorPageMax bounds how much of a 502 HTML response is held back to
+// look for the marker; the proxy's page is well under it.
+const previewErrorPageMax = 64 << 10
+
+var (
+	previewStartAppRe = regexp.MustCompile(`Start a hot-reload web app on (<span class="port">[^<]*</span>)`)
+	previewSPANoteRe  = regexp.MustCompile(`<div class="note">([^<]*) unreachable\. We can only use path-based proxy, so SPAs \(React, Vue, etc\.\) must use hash-based routing \(e\.g\. /#/dashboard\)</div>`)
+)
+
+// localizePreviewPage rewrites the English text of the App Preview page.
+func localizePreviewPage(page, locale string) string {
+	page = strings.Replace(page, `<html data-theme=`, `<html lang="`+html.EscapeString(locale)+`" data-theme=`, 1)
+	page = strings.Replace(page, `<title>App Preview</title>`, `<title>`+trHTML(locale, "preview.title")+`</title>`, 1)
+	page = strings.Replace(page, `<h1>App Preview</h1>`, `<h1>`+trHTML(locale, "preview.title")+`</h1>`, 1)
+	page = strings.Replace(page, `>Tell your agent:</div>`, `>`+trHTML(locale, "preview.tellAgent")+`</div>`, 1)
+	page = strings.Replace(page, previewErrorPageMarker, `<span id="status-text">`+trHTML(locale, "preview.listening")+`</span>`, 1)
+	page = previewStartAppRe.ReplaceAllStringFunc(page, func(m string) string {
+		return trHTML(locale, "preview.startApp", previewStartAppRe.FindStringSubmatch(m)[1])
+	})
+	return previewSPANoteRe.ReplaceAllStringFunc(page, func(m string) string {
+		return `<div class="note">` + trHTML(locale, "preview.spaNote", previewSPANoteRe.FindStringSubmatch(m)[1]) + `</div>`
+	})
+}
+
+// localizePreviewErrorPage wraps a preview proxy so its "app not running"
+// page is served in the request's language. English requests and WebSocket
+// upgrades go straight through.
+func localizePreviewErrorPage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := requestLocale(r)
+		if locale == defaultLocale || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		lw := &previewPageLocalizer{ResponseWriter: w, locale: locale}
+		next.ServeHTTP(lw, r)
+		lw.finish()
+	})
+}
+
+// previewPageLocalizer holds back a 502 text/html response until it is
+// complete, then rewrites it if it is the proxy's own page. Anything else,
+// or a 502 body larger than previewErrorPageMax, is written through as-is.
+type previewPageLocalizer struct {
+	http.ResponseWriter
+	locale      string
+	wroteHeader bool
+	holding     bool
+	status      int
+	buf         bytes.Buffer
+}
+
+func (lw *previewPageLocalizer) WriteHeader(code int) {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+	if code == http.StatusBadGateway && strings.HasPrefix(lw.Header().Get("Content-Type"), "text/html") && lw.Header().Get("Content-Encoding") == "" {
+		lw.holding, lw.status = true, code
+		return
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *previewPageLocalizer) Write(p []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if !lw.holding {
+		return lw.ResponseWriter.Write(p)
+	}
+	lw.buf.Write(p)
+	if lw.buf.Len() > previewErrorPageMax {
+		lw.release(lw.buf.Bytes())
+	}
+	return len(p), nil
+}
+
+func (lw *previewPageLocalizer) Flush() {
+	if lw.holding {
+		return
+	}
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (lw *previewPageLocalizer) Unwrap() http.ResponseWriter { return lw.ResponseWriter }
+
+// release sends the held status and body and stops holding.
+func (lw *previewPageLocalizer) release(body []byte) {
+	lw.holding = false
+	lw.ResponseWriter.WriteHeader(lw.status)
+	lw.ResponseWriter.Write(body)
+	lw.buf.Reset()
+}
+
+// finish writes a held response, localized when it is the proxy's page.
+func (lw *previewPageLocalizer) finish() {
+	if !lw.holding {
+		return
+	}
+	page := lw.buf.String()
+	if strings.Contains(page, previewErrorPageMarker) {
+		page = localizePreviewPage(page, lw.locale)
+		lw.Header().Del("Content-Length")
+	}
+	lw.release([]byte(page))
+}
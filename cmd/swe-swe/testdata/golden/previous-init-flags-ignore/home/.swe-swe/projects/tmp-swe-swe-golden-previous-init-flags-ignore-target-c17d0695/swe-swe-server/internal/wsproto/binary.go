@@ -0,0 +1,190 @@
+package wsproto
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Binary frame opcodes: the first byte of a binary frame. A client frame
+// that matches none of them is terminal input, as are the server's plain
+// binary frames (terminal output). OpPaste and OpSequenced are Ctrl-C and
+// Ctrl-D when typed, so the server reads OpSequenced only from a client that
+// connected with ?input=, and OpPaste only inside a sequenced frame.
+const (
+	OpResize    byte = 0x00 // client: [0x00, rows(2), cols(2)]
+	OpUpload    byte = 0x01 // client: [0x01, name_len(2), name, data]
+	OpChunk     byte = 0x02 // server: [0x02, index, total, data]
+	OpPaste     byte = 0x03 // client, sequenced only: [0x03, type_len(1), content_type, data]
+	OpSequenced byte = 0x04 // client, with ?input= only: [0x04, seq(4), frame]
+)
+
+// MaxChunks is the most chunks one payload is split into; the chunk header
+// counts them in a byte.
+const MaxChunks = 255
+
+var (
+	// ErrMalformed is returned for a frame whose opcode is known but whose
+	// header does not fit in it.
+	ErrMalformed = errors.New("wsproto: malformed frame")
+	// ErrTooLong is returned when an upload name or paste content type is
+	// too long for its length field.
+	ErrTooLong = errors.New("wsproto: field too long for frame")
+)
+
+// Resize encodes a terminal resize frame.
+func Resize(rows, cols uint16) []byte {
+	return []byte{OpResize, byte(rows >> 8), byte(rows), byte(cols >> 8), byte(cols)}
+}
+
+// ParseResize decodes a terminal resize frame. ok is false when b is not
+// one; shorter frames starting with 0x00 are terminal input (Ctrl-@).
+func ParseResize(b []byte) (rows, cols uint16, ok bool) {
+	if len(b) < 5 || b[0] != OpResize {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint16(b[1:3]), binary.BigEndian.Uint16(b[3:5]), true
+}
+
+// Upload is a file the client sends into the session's uploads.
+type Upload struct {
+	Name string
+	Data []byte
+}
+
+// Encode encodes u as an upload frame.
+func (u Upload) Encode() ([]byte, error) {
+	if len(u.Name) > 0xFFFF {
+		return nil, ErrTooLong
+	}
+	b := make([]byte, 3, 3+len(u.Name)+len(u.Data))
+	b[0] = OpUpload
+	binary.BigEndian.PutUint16(b[1:3], uint16(len(u.Name)))
+	return append(append(b, u.Name...), u.Data...), nil
+}
+
+// ParseUpload decodes an upload frame. ok is false when b is not one, and
+// err is ErrMalformed when it is but its name runs past the end.
+func ParseUpload(b []byte) (u Upload, ok bool, err error) {
+	if len(b) < 3 || b[0] != OpUpload {
+		return Upload{}, false, nil
+	}
+	n := int(binary.BigEndian.Uint16(b[1:3]))
+	if len(b) < 3+n {
+		return Upload{}, true, ErrMalformed
+	}
+	return Upload{Name: string(b[3 : 3+n]), Data: b[3+n:]}, true, nil
+}
+
+// Paste is an image pasted from the clipboard, which carries no filename.
+// Its frame is sent wrapped in Sequenced; a bare one is Ctrl-C.
+type Paste struct {
+	ContentType string
+	Data        []byte
+}
+
+// Encode encodes p as a paste frame.
+func (p Paste) Encode() ([]byte, error) {
+	if len(p.ContentType) > 0xFF {
+		return nil, ErrTooLong
+	}
+	b := make([]byte, 0, 2+len(p.ContentType)+len(p.Data))
+	b = append(b, OpPaste, byte(len(p.ContentType)))
+	return append(append(b, p.ContentType...), p.Data...), nil
+}
+
+// ParsePaste decodes a paste frame. ok is false when b is not one, and err
+// is ErrMalformed when it is but its content type runs past the end.
+func ParsePaste(b []byte) (p Paste, ok bool, err error) {
+	if len(b) < 2 || b[0] != OpPaste {
+		return Paste{}, false, nil
+	}
+	n := int(b[1])
+	if len(b) < 2+n {
+		return Paste{}, true, ErrMalformed
+	}
+	return Paste{ContentType: string(b[2 : 2+n]), Data: b[2+n:]}, true, nil
+}
+
+// Sequenced wraps an input frame -- keystrokes, an upload or a paste -- with
+// its sequence number, for clients connected with ?input={id}.
+func Sequenced(seq uint32, frame []byte) []byte {
+	b := make([]byte, 5, 5+len(frame))
+	b[0] = OpSequenced
+	binary.BigEndian.PutUint32(b[1:5], seq)
+	return append(b, frame...)
+}
+
+// ParseSequenced splits a sequenced input frame into its seq and the wrapped
+// frame. ok is false when b is not one.
+func ParseSequenced(b []byte) (seq uint32, frame []byte, ok bool) {
+	if len(b) < 5 || b[0] != OpSequenced {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint32(b[1:5]), b[5:], true
+}
+
+// Chunks splits data into chunk frames of at most size bytes of payload
+// each. When that would take more than MaxChunks, the chunks grow to fit.
+// Empty data is one empty chunk.
+func Chunks(data []byte, size int) [][]byte {
+	if size < 1 {
+		size = 1
+	}
+	if (len(data)+size-1)/size > MaxChunks {
+		size = (len(data) + MaxChunks - 1) / MaxChunks
+	}
+	total := (len(data) + size - 1) / size
+	if total == 0 {
+		total = 1
+	}
+	chunks := make([][]byte, total)
+	for i := range chunks {
+		start := min(i*size, len(data))
+		end := min(start+size, len(data))
+		chunk := make([]byte, 3+end-start)
+		chunk[0], chunk[1], chunk[2] = OpChunk, byte(i), byte(total)
+		copy(chunk[3:], data[start:end])
+		chunks[i] = chunk
+	}
+	return chunks
+}
+
+// ParseChunk decodes a chunk frame. ok is false when b is not one.
+func ParseChunk(b []byte) (index, total int, payload []byte, ok bool) {
+	if len(b) < 3 || b[0] != OpChunk || b[2] == 0 || b[1] >= b[2] {
+		return 0, 0, nil, false
+	}
+	return int(b[1]), int(b[2]), b[3:], true
+}
+
+// Assembler joins the chunk frames of one payload back together. The zero
+// value is ready to use; it starts over when a chunk's total differs from
+// the payload in progress.
+type Assembler struct {
+	parts [][]byte
+	got   int
+}
+
+// Add takes the next chunk frame and returns the whole payload once its last
+// chunk is in.
+func (a *Assembler) Add(frame []byte) (payload []byte, done bool, err error) {
+	index, total, part, ok := ParseChunk(frame)
+	if !ok {
+		return nil, false, ErrMalformed
+	}
+	if len(a.parts) != total {
+		a.parts, a.got = make([][]byte, total), 0
+	}
+	if a.parts[index] == nil {
+		a.got++
+	}
+	a.parts[index] = append([]byte{}, part...)
+	if a.got < total {
+		return nil, false, nil
+	}
+	for _, p := range a.parts {
+		payload = append(payload, p...)
+	}
+	a.parts, a.got = nil, 0
+	return payload, true, nil
+}
@@ -0,0 +1,240 @@
+// recording_replay.go -- reproduce an agent run by typing its recorded input
+// into a new session.
+//
+//	POST /api/recording/{uuid}/replay  {"speed": 4, "maxGap": 30}
+//
+// The new session runs the recording's assistant, with its extra args and
+// mode, in a fresh worktree (branch replay-<recording>-<n>) started at the
+// commit the recorded session started on. The recorded keystrokes are then
+// written to it at the times the .timing file gives, divided by speed
+// (default 1, at most replayMaxSpeed). A pause longer than maxGap seconds is
+// cut to maxGap before speed applies; 0 keeps every pause. The agent's output
+// is neither waited for nor compared: a faster agent gets its input late, a
+// slower one early, and watching the two side by side is the point.
+//
+// Only what went through the PTY is replayed -- keystrokes, pastes and
+// send_session_input. Agent chat messages are not, nor is anything typed while
+// the recording was paused.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// replayMaxSpeed bounds the speed-up; past it keystrokes arrive as one burst.
+const replayMaxSpeed = 100
+
+// replayStep is one recorded write to the PTY.
+type replayStep struct {
+	delay time.Duration // since the previous step, or the recording's start
+	data  []byte
+}
+
+// loadReplayInput reads a recording's input writes, in order, with the time
+// before each. errRecordingNotFound when it has no input recording.
+func loadReplayInput(recordingUUID string) ([]replayStep, error) {
+	base := recordingDir(recordingUUID) + "/session-" + recordingUUID
+	timing, err := os.Open(base + ".timing")
+	if os.IsNotExist(err) {
+		return nil, errRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer timing.Close()
+	input, err := os.ReadFile(base + ".input")
+	if os.IsNotExist(err) {
+		return nil, errRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return replaySteps(timing, stripInputHeader(input)), nil
+}
+
+// replaySteps pairs the timing file's input entries with the input bytes.
+// Output entries only add to the delay before the next input.
+func replaySteps(timing io.Reader, input []byte) []replayStep {
+	var steps []replayStep
+	var elapsed float64
+	pos := 0
+	scanner := bufio.NewScanner(timing)
+	for scanner.Scan() {
+		typ, delay, n, ok := parseTimingLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		elapsed += delay
+		if typ != 'I' || pos >= len(input) {
+			continue
+		}
+		end := min(pos+n, len(input))
+		steps = append(steps, replayStep{
+			delay: time.Duration(elapsed * float64(time.Second)),
+			data:  input[pos:end],
+		})
+		pos, elapsed = end, 0
+	}
+	return steps
+}
+
+// replayAssistant is the availableAssistants binary a recording ran, or ""
+// when this server does not have it.
+func replayAssistant(meta *RecordingMetadata) string {
+	for _, a := range installedAssistants() {
+		if (meta.AgentBinary != "" && a.Binary == meta.AgentBinary) || (meta.AgentBinary == "" && a.Name == meta.Agent) {
+			return a.Binary
+		}
+	}
+	return ""
+}
+
+// replayBranch creates the replay's branch in repoPath at startCommit (HEAD
+// when empty) and returns its name.
+func replayBranch(repoPath, recordingUUID, startCommit string) (string, error) {
+	if startCommit == "" {
+		startCommit = "HEAD"
+	}
+	short := recordingUUID[:8]
+	for i := 1; i <= 100; i++ {
+		branch := fmt.Sprintf("replay-%s-%d", short, i)
+		if exec.Command("git", "-C", repoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch).Run() == nil {
+			continue
+		}
+		if out, err := exec.Command("git", "-C", repoPath, "branch", branch, startCommit).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("cannot branch from %s: %s", startCommit, string(out))
+		}
+		return branch, nil
+	}
+	return "", fmt.Errorf("too many replays of %s", short)
+}
+
+// replayInput writes the recorded input to the session, stopping early if
+// the session ends.
+func (s *Session) replayInput(steps []replayStep, speed float64, maxGap time.Duration) {
+	defer recoverGoroutine("replay for session " + s.UUID)
+	for _, step := range steps {
+		delay := step.delay
+		if maxGap > 0 && delay > maxGap {
+			delay = maxGap
+		}
+		time.Sleep(time.Duration(float64(delay) / speed))
+		if s.isEnding() {
+			log.Printf("Session %s: replay stopped, session ended", s.UUID)
+			return
+		}
+		if err := s.WriteInput(step.data); err != nil {
+			log.Printf("Session %s: replay: %v", s.UUID, err)
+			return
+		}
+		s.Checkpoints.noteInput(step.data)
+	}
+	log.Printf("Session %s: replayed %d inputs", s.UUID, len(steps))
+}
+
+// handleRecordingReplay handles POST /api/recording/{uuid}/replay.
+func handleRecordingReplay(w http.ResponseWriter, r *http.Request, recordingUUID string) {
+	if requestCookieScope(r) != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Speed  float64 `json:"speed"`
+		MaxGap float64 `json:"maxGap"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Speed == 0 {
+		req.Speed = 1
+	}
+	if req.Speed < 0 || req.Speed > replayMaxSpeed || req.MaxGap < 0 {
+		http.Error(w, fmt.Sprintf("speed must be between 0 and %d, maxGap at least 0", replayMaxSpeed), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := readRecordingMetadata(recordingUUID)
+	if errors.Is(err, errRecordingNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if meta.RecordingType != "" && meta.RecordingType != "agent" {
+		http.Error(w, "Only agent recordings can be replayed", http.StatusConflict)
+		return
+	}
+	assistant := replayAssistant(meta)
+	if assistant == "" {
+		http.Error(w, fmt.Sprintf("%s is not available on this server", meta.Agent), http.StatusConflict)
+		return
+	}
+	steps, err := loadReplayInput(recordingUUID)
+	if errors.Is(err, errRecordingNotFound) {
+		http.Error(w, "This recording has no input to replay", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	repoPath := SessionPageQuery{WorkDir: meta.WorkDir}.RepoRoot()
+	if repoPath == "" {
+		repoPath = workspaceDir
+	}
+	if _, err := os.Stat(repoPath); err != nil {
+		http.Error(w, fmt.Sprintf("The recording's repo %s is gone", repoPath), http.StatusConflict)
+		return
+	}
+	if err := checkSessionLimit(assistant); err != nil {
+		writeSessionLimitError(w, err.(*sessionLimitError))
+		return
+	}
+	branch, err := replayBranch(repoPath, recordingUUID, meta.StartCommit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	sess, _, err := getOrCreateSession(SessionParams{
+		UUID:        uuid.New().String(),
+		Assistant:   assistant,
+		Name:        "Replay: " + firstNonEmpty(meta.Name, recordingUUID[:8]),
+		Branch:      branch,
+		RepoPath:    repoPath,
+		SessionMode: meta.SessionMode,
+		ExtraArgs:   meta.ExtraArgs,
+	}, true)
+	if err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sess.startPTYReader()
+	go sess.replayInput(steps, req.Speed, time.Duration(req.MaxGap*float64(time.Second)))
+	log.Printf("Recording %s: replaying %d inputs into session %s (speed %g)", recordingUUID, len(steps), sess.UUID, req.Speed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"sessionUUID":   sess.UUID,
+		"recordingUUID": sess.RecordingUUID,
+		"branch":        branch,
+		"inputs":        len(steps),
+		"url":           "/session/" + sess.UUID + "?assistant=" + url.QueryEscape(assistant),
+	})
+}
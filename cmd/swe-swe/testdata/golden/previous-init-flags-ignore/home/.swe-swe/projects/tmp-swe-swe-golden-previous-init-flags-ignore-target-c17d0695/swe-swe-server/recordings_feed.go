@@ -0,0 +1,181 @@
+// recordings_feed.go -- Atom feed of finished recordings.
+//
+// GET /feeds/recordings.atom lists the most recently ended recordings --
+// name (or summary), agent, repo, duration -- each linking to its playback
+// page, so a team can follow what the agents have been doing from a feed
+// reader or a Slack RSS app.
+//
+// Feed readers carry no login cookie, so besides the owner's cookie the
+// feeds (this one and the session calendar, session_calendar.go) accept
+// ?key=KEY, an HMAC of a fixed string keyed by SWE_SWE_PASSWORD. The homepage
+// advertises the keyed URL with a <link rel="alternate"> and a "Feed" link on
+// the recordings list. The key only opens the feeds: the entry links still
+// go through the normal login. Changing SWE_SWE_PASSWORD changes the key.
+//
+// Like the public embeds, the feeds skip the login in both deployments
+// (authMiddleware and authVerifyHandler, via feedPath) and check access
+// themselves.
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	recordingsFeedPath = "/feeds/recordings.atom"
+	// recordingsFeedMaxEntries bounds the feed to the newest recordings.
+	recordingsFeedMaxEntries = 50
+)
+
+// feedPath reports whether path is one of the feeds, which check access
+// themselves.
+func feedPath(path string) bool {
+	return path == recordingsFeedPath || path == sessionCalendarPath
+}
+
+// feedKey is the ?key= that opens the feeds without a cookie.
+func feedKey(secret string) string {
+	return authComputeHMAC("recordings-feed", secret)
+}
+
+// feedURL is the URL to subscribe to the feed at path, keyed when there is a
+// password.
+func feedURL(r *http.Request, path string) string {
+	u := requestBaseURL(r) + path
+	if secret := os.Getenv("SWE_SWE_PASSWORD"); secret != "" {
+		u += "?key=" + url.QueryEscape(feedKey(secret))
+	}
+	return u
+}
+
+// feedAuthorized reports whether r may read a feed: an owner login, or the
+// feed key.
+func feedAuthorized(r *http.Request) bool {
+	if requestIsOwner(r) {
+		return true
+	}
+	key := r.URL.Query().Get("key")
+	return key != "" && hmac.Equal([]byte(key), []byte(feedKey(os.Getenv("SWE_SWE_PASSWORD"))))
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Link     atomLink     `xml:"link"`
+	Author   atomAuthor   `xml:"author"`
+	Category atomCategory `xml:"category"`
+	Summary  string       `xml:"summary"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// buildRecordingsFeed renders recordings (newest first) as an Atom feed
+// whose links point at baseURL.
+func buildRecordingsFeed(recordings []RecordingInfo, baseURL string) atomFeed {
+	feed := atomFeed{
+		ID:     baseURL + recordingsFeedPath,
+		Title:  "swe-swe recordings",
+		Links:  []atomLink{{Rel: "alternate", Href: baseURL + "/"}},
+		Author: atomAuthor{Name: "swe-swe"},
+	}
+	// An empty feed still needs an <updated>.
+	updated := time.Unix(0, 0).UTC()
+	if len(recordings) > recordingsFeedMaxEntries {
+		recordings = recordings[:recordingsFeedMaxEntries]
+	}
+	for _, rec := range recordings {
+		if rec.EndedAt.After(updated) {
+			updated = rec.EndedAt
+		}
+		title := rec.Name
+		if title == "" {
+			title = rec.SummaryLine
+		}
+		if title == "" {
+			title = "session-" + rec.UUIDShort
+		}
+		summary := rec.Agent
+		if repo := usageRepo(rec.Query.WorkDir); repo != "" {
+			summary += " in " + repo
+		}
+		if !rec.StartedAt.IsZero() && rec.EndedAt.After(rec.StartedAt) {
+			summary += ", " + formatFeedDuration(rec.EndedAt.Sub(rec.StartedAt))
+		}
+		if rec.SummaryLine != "" && rec.SummaryLine != title {
+			summary += ": " + rec.SummaryLine
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:       "urn:uuid:" + rec.UUID,
+			Title:    title,
+			Updated:  rec.EndedAt.UTC().Format(time.RFC3339),
+			Link:     atomLink{Rel: "alternate", Href: baseURL + "/recording/" + rec.UUID},
+			Author:   atomAuthor{Name: rec.Agent},
+			Category: atomCategory{Term: rec.Agent},
+			Summary:  summary,
+		})
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	return feed
+}
+
+// formatFeedDuration renders d as "1h 5m", "12m" or "40s".
+func formatFeedDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+}
+
+// handleRecordingsFeed serves GET /feeds/recordings.atom[?key=KEY].
+func handleRecordingsFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !feedAuthorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	recordings, _, _ := endedRecordingsPage(1, recordingsFeedMaxEntries)
+	data, err := xml.MarshalIndent(buildRecordingsFeed(recordings, requestBaseURL(r)), "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to render feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
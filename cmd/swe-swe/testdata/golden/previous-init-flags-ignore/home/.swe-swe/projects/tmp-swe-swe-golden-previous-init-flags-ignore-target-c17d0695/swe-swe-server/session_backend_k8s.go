@@ -0,0 +1,294 @@
+// session_backend_k8s.go -- the "k8s" session backend: one pod per session.
+//
+// For a team running swe-swe centrally, SWE_SESSION_BACKEND=k8s schedules each
+// session as a pod and runs the agent in it with `kubectl exec -it`, which
+// goes through the Kubernetes exec API and forwards terminal resizes. As with
+// the docker backend, swe-swe-server keeps the PTY and the recording; the
+// WebSocket, recording and preview-proxy front end does not know the
+// difference.
+//
+// The pod is created from SWE_K8S_* settings (read from the session env, so a
+// repo can override them in .swe-swe/env):
+//
+//	SWE_K8S_IMAGE      container image (required)
+//	SWE_K8S_NAMESPACE  namespace (default: kubectl's current one)
+//	SWE_K8S_VOLUMES    PVCs to mount, "claim:/mount[,claim:/mount]"; mount
+//	                   the repo clones at the same paths as on the server,
+//	                   since the pod starts in the session's working directory
+//	SWE_K8S_CPU        CPU request and limit, e.g. "2"
+//	SWE_K8S_MEMORY     memory request and limit, e.g. "4Gi"
+//
+// The session environment goes into the pod spec (kubectl exec cannot set
+// env), so anyone who can read pods in the namespace can read it.
+//
+// The app preview and agent chat ports are forwarded back to 127.0.0.1 on the
+// server with `kubectl port-forward`, restarted if it drops, so the
+// per-session proxies reach the pod exactly as they reach a local process.
+// Release deletes the pod.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// k8sPodReadyTimeout bounds scheduling, image pull and start.
+	k8sPodReadyTimeout = 5 * time.Minute
+	// k8sContainerName is the session container inside the pod.
+	k8sContainerName = "session"
+)
+
+// k8sBackend is a running session pod.
+type k8sBackend struct {
+	namespace string // "" = kubectl's current namespace
+	pod       string
+
+	mu      sync.Mutex
+	stopped bool
+	stopPF  chan struct{}
+}
+
+func (b *k8sBackend) Name() string { return "k8s:" + b.pod }
+
+// kubectlArgs prefixes args with the namespace flag when one is set.
+func (b *k8sBackend) kubectlArgs(args ...string) []string {
+	if b.namespace == "" {
+		return args
+	}
+	return append([]string{"-n", b.namespace}, args...)
+}
+
+func (b *k8sBackend) Command(cmdName string, cmdArgs []string, env []string) (string, []string) {
+	args := b.kubectlArgs("exec", "-it", b.pod, "-c", k8sContainerName, "--", cmdName)
+	return "kubectl", append(args, cmdArgs...)
+}
+
+func (b *k8sBackend) Cleanup(sessionUUID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerCleanupTimeout)
+	defer cancel()
+	args := b.kubectlArgs("exec", b.pod, "-c", k8sContainerName, "--", "sh", "-c", killSessionScript, "sh", sessionUUID)
+	if out, err := exec.CommandContext(ctx, "kubectl", args...).CombinedOutput(); err != nil {
+		log.Printf("Session %s: cleanup in pod %s failed: %v %s", sessionUUID, b.pod, err, strings.TrimSpace(string(out)))
+	}
+}
+
+// Release stops the port-forward and deletes the pod.
+func (b *k8sBackend) Release(sessionUUID string) {
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		return
+	}
+	b.stopped = true
+	close(b.stopPF)
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), containerCleanupTimeout)
+	defer cancel()
+	args := b.kubectlArgs("delete", "pod", b.pod, "--wait=false", "--ignore-not-found")
+	if out, err := exec.CommandContext(ctx, "kubectl", args...).CombinedOutput(); err != nil {
+		log.Printf("Session %s: deleting pod %s failed: %v %s", sessionUUID, b.pod, err, strings.TrimSpace(string(out)))
+		return
+	}
+	log.Printf("Session %s: deleted pod %s", sessionUUID, b.pod)
+}
+
+// k8sPodConfig is the SWE_K8S_* settings for one session.
+type k8sPodConfig struct {
+	Namespace string
+	Image     string
+	CPU       string
+	Memory    string
+	Volumes   []k8sVolume
+}
+
+type k8sVolume struct {
+	Claim     string
+	MountPath string
+}
+
+// parseK8sVolumes parses "claim:/mount[,claim:/mount]".
+func parseK8sVolumes(s string) ([]k8sVolume, error) {
+	var vols []k8sVolume
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		claim, mount, ok := strings.Cut(item, ":")
+		if !ok || claim == "" || !strings.HasPrefix(mount, "/") {
+			return nil, fmt.Errorf("invalid SWE_K8S_VOLUMES entry %q (want claim:/mount/path)", item)
+		}
+		vols = append(vols, k8sVolume{Claim: claim, MountPath: mount})
+	}
+	return vols, nil
+}
+
+func loadK8sPodConfig(lookup func(string) string) (k8sPodConfig, error) {
+	cfg := k8sPodConfig{
+		Namespace: strings.TrimSpace(lookup("SWE_K8S_NAMESPACE")),
+		Image:     strings.TrimSpace(lookup("SWE_K8S_IMAGE")),
+		CPU:       strings.TrimSpace(lookup("SWE_K8S_CPU")),
+		Memory:    strings.TrimSpace(lookup("SWE_K8S_MEMORY")),
+	}
+	if cfg.Image == "" {
+		return cfg, fmt.Errorf("k8s backend needs SWE_K8S_IMAGE")
+	}
+	if cfg.Namespace != "" && !backendWordRe.MatchString(cfg.Namespace) {
+		return cfg, fmt.Errorf("invalid SWE_K8S_NAMESPACE %q", cfg.Namespace)
+	}
+	vols, err := parseK8sVolumes(lookup("SWE_K8S_VOLUMES"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Volumes = vols
+	return cfg, nil
+}
+
+var k8sNameUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// k8sPodName derives a DNS-1123 pod name from the session UUID.
+func k8sPodName(sessionUUID string) string {
+	name := "swe-swe-" + k8sNameUnsafe.ReplaceAllString(strings.ToLower(sessionUUID), "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// k8sPodManifest builds the pod: one container that idles until the agent is
+// exec'd into it, starting in workDir, with the forwarded session env.
+func k8sPodManifest(cfg k8sPodConfig, pod, sessionUUID, workDir string, env []string) map[string]any {
+	lookup := envLookup(env)
+	var podEnv []map[string]string
+	for _, name := range forwardedEnvNames(env) {
+		podEnv = append(podEnv, map[string]string{"name": name, "value": lookup(name)})
+	}
+	container := map[string]any{
+		"name":       k8sContainerName,
+		"image":      cfg.Image,
+		"command":    []string{"sleep", "infinity"},
+		"workingDir": workDir,
+		"env":        podEnv,
+	}
+	if cfg.CPU != "" || cfg.Memory != "" {
+		res := map[string]string{}
+		if cfg.CPU != "" {
+			res["cpu"] = cfg.CPU
+		}
+		if cfg.Memory != "" {
+			res["memory"] = cfg.Memory
+		}
+		container["resources"] = map[string]any{"requests": res, "limits": res}
+	}
+	spec := map[string]any{
+		"restartPolicy": "Never",
+		"containers":    []any{container},
+	}
+	if len(cfg.Volumes) > 0 {
+		var vols, mounts []map[string]any
+		for i, v := range cfg.Volumes {
+			name := fmt.Sprintf("vol%d", i)
+			vols = append(vols, map[string]any{"name": name, "persistentVolumeClaim": map[string]string{"claimName": v.Claim}})
+			mounts = append(mounts, map[string]any{"name": name, "mountPath": v.MountPath})
+		}
+		spec["volumes"] = vols
+		container["volumeMounts"] = mounts
+	}
+	meta := map[string]any{
+		"name": pod,
+		"labels": map[string]string{
+			"app.kubernetes.io/managed-by": "swe-swe",
+			"swe-swe/session":              k8sNameUnsafe.ReplaceAllString(strings.ToLower(sessionUUID), "-"),
+		},
+	}
+	if cfg.Namespace != "" {
+		meta["namespace"] = cfg.Namespace
+	}
+	return map[string]any{"apiVersion": "v1", "kind": "Pod", "metadata": meta, "spec": spec}
+}
+
+// startK8sSessionPod creates the session's pod, waits for it to be ready, and
+// starts forwarding the preview and agent chat ports.
+func startK8sSessionPod(p backendParams) (sessionBackend, error) {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return nil, fmt.Errorf("k8s backend needs kubectl: %w", err)
+	}
+	lookup := envLookup(p.Env)
+	cfg, err := loadK8sPodConfig(lookup)
+	if err != nil {
+		return nil, err
+	}
+	b := &k8sBackend{namespace: cfg.Namespace, pod: k8sPodName(p.SessionUUID), stopPF: make(chan struct{})}
+	manifest, err := json.Marshal(k8sPodManifest(cfg, b.pod, p.SessionUUID, p.WorkDir, p.Env))
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	create := exec.Command("kubectl", b.kubectlArgs("create", "-f", "-")...)
+	create.Stdin = bytes.NewReader(manifest)
+	if out, err := create.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("kubectl create pod %s: %v: %s", b.pod, err, strings.TrimSpace(string(out)))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k8sPodReadyTimeout+10*time.Second)
+	defer cancel()
+	wait := exec.CommandContext(ctx, "kubectl", b.kubectlArgs("wait", "--for=condition=Ready", "pod/"+b.pod,
+		fmt.Sprintf("--timeout=%ds", int(k8sPodReadyTimeout.Seconds())))...)
+	if out, err := wait.CombinedOutput(); err != nil {
+		b.Release(p.SessionUUID)
+		return nil, fmt.Errorf("pod %s not ready: %v: %s", b.pod, err, strings.TrimSpace(string(out)))
+	}
+	log.Printf("Session %s: pod %s ready (%s)", p.SessionUUID, b.pod, time.Since(start).Round(time.Millisecond))
+
+	var ports []string
+	for _, key := range []string{"PORT", "AGENT_CHAT_PORT"} {
+		if v := lookup(key); v != "" && v != "0" {
+			ports = append(ports, v)
+		}
+	}
+	if len(ports) > 0 {
+		go func() {
+			defer recoverGoroutine(fmt.Sprintf("port-forward for session %s", p.SessionUUID))
+			b.portForward(p.SessionUUID, ports)
+		}()
+	}
+	return b, nil
+}
+
+// portForward keeps `kubectl port-forward` running for ports until Release.
+func (b *k8sBackend) portForward(sessionUUID string, ports []string) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		cmd := exec.CommandContext(ctx, "kubectl", b.kubectlArgs(append([]string{"port-forward", "pod/" + b.pod}, ports...)...)...)
+		done := make(chan error, 1)
+		if err := cmd.Start(); err != nil {
+			done <- err
+		} else {
+			go func() { done <- cmd.Wait() }()
+		}
+		select {
+		case <-b.stopPF:
+			cancel()
+			<-done
+			return
+		case err := <-done:
+			cancel()
+			log.Printf("Session %s: port-forward to pod %s exited (%v); restarting", sessionUUID, b.pod, err)
+		}
+		select {
+		case <-b.stopPF:
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
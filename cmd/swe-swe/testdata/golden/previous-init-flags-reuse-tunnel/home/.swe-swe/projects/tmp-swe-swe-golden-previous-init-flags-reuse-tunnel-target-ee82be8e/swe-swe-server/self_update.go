@@ -0,0 +1,497 @@
+// self_update.go -- keep a container-less swe-swe-server current from GitHub
+// releases.
+//
+//	swe-swe-server self-update [-check] [-version TAG] [-force] [-restart PID]
+//
+// looks up the latest release (or TAG) of choonkeat/swe-swe, downloads the
+// swe-swe-server.<os>-<arch> asset for this platform, checks it against the
+// release's checksums.txt (sha256sum format) and replaces the running
+// executable: the new binary is written next to the old one and renamed over
+// it, so a failed download never leaves a half-written server behind. -check
+// only reports whether an update is available.
+//
+// With SWE_UPDATE_PUBKEY set (a base64 ed25519 public key) checksums.txt must
+// also carry a valid checksums.txt.sig from that key, so a compromised
+// download host alone cannot ship a binary.
+//
+// -restart PID sends SIGUSR2 to the server already running as PID. On SIGUSR2
+// a server drains: it refuses new sessions, waits up to SWE_RESTART_DRAIN
+// (default 10m) for its sessions to end on their own, then shuts down as for
+// SIGTERM and re-execs its executable -- now the new binary -- with the same
+// arguments.
+//
+// `make dist-server` builds the release assets: the swe-swe-server.<os>-<arch>
+// binaries and checksums.txt, signed as checksums.txt.sig when
+// SWE_UPDATE_SIGNING_KEY names the private key.
+//
+//	GET /api/version/check
+//
+// reports the running version against the latest release, for the homepage.
+// The lookup is cached for an hour to stay clear of GitHub's rate limit.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// selfUpdateRepo is the GitHub repository whose releases carry the server.
+const selfUpdateRepo = "choonkeat/swe-swe"
+
+// selfUpdateChecksums and its .sig are the release assets that vouch for the
+// binaries.
+const selfUpdateChecksums = "checksums.txt"
+
+// versionCheckTTL is how long /api/version/check reuses a release lookup.
+const versionCheckTTL = time.Hour
+
+// errNoUpdate means the running server is already at the release.
+var errNoUpdate = errors.New("already up to date")
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type releaseInfo struct {
+	Tag     string         `json:"tag_name"`
+	HTMLURL string         `json:"html_url"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+func (rel *releaseInfo) asset(name string) *releaseAsset {
+	for i := range rel.Assets {
+		if rel.Assets[i].Name == name {
+			return &rel.Assets[i]
+		}
+	}
+	return nil
+}
+
+// serverAssetName is the release asset built for goos/goarch, named like the
+// CLI's dist binaries (swe-swe.linux-amd64).
+func serverAssetName(goos, goarch string) string {
+	return fmt.Sprintf("swe-swe-server.%s-%s", goos, goarch)
+}
+
+// fetchRelease looks up tag, or the latest release when tag is empty.
+func fetchRelease(ctx context.Context, tag string) (*releaseInfo, error) {
+	path := "/repos/" + selfUpdateRepo + "/releases/latest"
+	if tag != "" {
+		path = "/repos/" + selfUpdateRepo + "/releases/tags/" + tag
+	}
+	body, err := updateGet(ctx, githubAPIURL+path, 1<<20)
+	if err != nil {
+		return nil, fmt.Errorf("release lookup: %w", err)
+	}
+	var rel releaseInfo
+	if err := json.Unmarshal(body, &rel); err != nil {
+		return nil, fmt.Errorf("release lookup: %w", err)
+	}
+	if rel.Tag == "" {
+		return nil, errors.New("release lookup: no tag_name in response")
+	}
+	return &rel, nil
+}
+
+// updateGet fetches url, refusing bodies over limit bytes.
+func updateGet(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "swe-swe-server/"+Version)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("GET %s: response over %d bytes", url, limit)
+	}
+	return body, nil
+}
+
+// downloadVerified downloads the asset named name from rel and returns it once
+// its sha256 matches checksums.txt -- and, with pubkey set, checksums.txt
+// matches its signature.
+func downloadVerified(ctx context.Context, rel *releaseInfo, name string, pubkey ed25519.PublicKey) ([]byte, error) {
+	bin := rel.asset(name)
+	if bin == nil {
+		return nil, fmt.Errorf("release %s has no %s asset for this platform", rel.Tag, name)
+	}
+	sums := rel.asset(selfUpdateChecksums)
+	if sums == nil {
+		return nil, fmt.Errorf("release %s has no %s; refusing an unverified binary", rel.Tag, selfUpdateChecksums)
+	}
+	sumsData, err := updateGet(ctx, sums.URL, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	if pubkey != nil {
+		sig := rel.asset(selfUpdateChecksums + ".sig")
+		if sig == nil {
+			return nil, fmt.Errorf("release %s has no %s.sig and SWE_UPDATE_PUBKEY is set", rel.Tag, selfUpdateChecksums)
+		}
+		sigData, err := updateGet(ctx, sig.URL, 4096)
+		if err != nil {
+			return nil, err
+		}
+		if !verifyChecksumsSignature(pubkey, sumsData, sigData) {
+			return nil, fmt.Errorf("release %s: %s signature does not verify", rel.Tag, selfUpdateChecksums)
+		}
+	}
+	want, ok := checksumFor(sumsData, name)
+	if !ok {
+		return nil, fmt.Errorf("release %s: %s lists no checksum for %s", rel.Tag, selfUpdateChecksums, name)
+	}
+	data, err := updateGet(ctx, bin.URL, 512<<20)
+	if err != nil {
+		return nil, err
+	}
+	if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != want {
+		return nil, fmt.Errorf("release %s: %s checksum mismatch", rel.Tag, name)
+	}
+	return data, nil
+}
+
+// checksumFor finds name's hex sha256 in sha256sum output ("<hex>  <name>",
+// or "<hex> *<name>" for binary mode).
+func checksumFor(sums []byte, name string) (string, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// verifyChecksumsSignature accepts sig raw or base64-encoded.
+func verifyChecksumsSignature(pubkey ed25519.PublicKey, sums, sig []byte) bool {
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return false
+		}
+		sig = decoded
+	}
+	return ed25519.Verify(pubkey, sums, sig)
+}
+
+// updatePublicKey parses SWE_UPDATE_PUBKEY; nil when unset.
+func updatePublicKey() (ed25519.PublicKey, error) {
+	v := strings.TrimSpace(os.Getenv("SWE_UPDATE_PUBKEY"))
+	if v == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("SWE_UPDATE_PUBKEY is not a base64 ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// replaceExecutable atomically swaps the file at path for data, keeping its
+// mode. The temp file lives in the same directory so the rename never
+// crosses filesystems.
+func replaceExecutable(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// compareVersions compares dotted release versions ("v1.2.3", "1.10"),
+// ignoring a leading v and any -suffix. ok is false when either is not a
+// release version, e.g. a "dev" build.
+func compareVersions(a, b string) (cmp int, ok bool) {
+	pa, okA := parseVersion(a)
+	pb, okB := parseVersion(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// versionCheck is the /api/version/check response.
+type versionCheck struct {
+	Current         string `json:"current"`
+	Commit          string `json:"commit"`
+	Latest          string `json:"latest"`
+	UpdateAvailable bool   `json:"update_available"`
+	// Comparable is false for builds without a release version (dev), which
+	// never report an update.
+	Comparable bool   `json:"comparable"`
+	ReleaseURL string `json:"release_url,omitempty"`
+	Asset      string `json:"asset"`
+	AssetFound bool   `json:"asset_found"`
+}
+
+func newVersionCheck(rel *releaseInfo) versionCheck {
+	name := serverAssetName(runtime.GOOS, runtime.GOARCH)
+	vc := versionCheck{
+		Current:    Version,
+		Commit:     GitCommit,
+		Latest:     rel.Tag,
+		ReleaseURL: rel.HTMLURL,
+		Asset:      name,
+		AssetFound: rel.asset(name) != nil,
+	}
+	if c, ok := compareVersions(Version, rel.Tag); ok {
+		vc.Comparable = true
+		vc.UpdateAvailable = c < 0
+	}
+	return vc
+}
+
+var (
+	versionCheckMu      sync.Mutex
+	versionCheckRelease *releaseInfo
+	versionCheckAt      time.Time
+)
+
+// handleVersionCheckAPI handles GET /api/version/check. Admin-only
+// (adminOnlyPath): it makes an outbound request on the caller's behalf.
+func handleVersionCheckAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	versionCheckMu.Lock()
+	rel := versionCheckRelease
+	if rel == nil || time.Since(versionCheckAt) > versionCheckTTL || r.URL.Query().Get("refresh") == "1" {
+		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+		fresh, err := fetchRelease(ctx, "")
+		cancel()
+		if err != nil {
+			versionCheckMu.Unlock()
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		rel, versionCheckRelease, versionCheckAt = fresh, fresh, time.Now()
+	}
+	versionCheckMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newVersionCheck(rel))
+}
+
+// runSelfUpdate is the self-update mode; args follow "self-update". It
+// returns the process exit code.
+func runSelfUpdate(args []string) int {
+	fs := flag.NewFlagSet("self-update", flag.ContinueOnError)
+	check := fs.Bool("check", false, "Only report whether an update is available.")
+	tag := fs.String("version", "", "Install this release tag instead of the latest.")
+	force := fs.Bool("force", false, "Install even if not newer than the running version (or it is a dev build).")
+	restartPID := fs.Int("restart", 0, "After installing, ask the server running as this pid to drain and restart.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	err := selfUpdate(ctx, *tag, *check, *force)
+	switch {
+	case errors.Is(err, errNoUpdate):
+		fmt.Println(err)
+		return 0
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "self-update: %v\n", err)
+		return 1
+	}
+	if *restartPID > 0 && !*check {
+		if err := syscall.Kill(*restartPID, syscall.SIGUSR2); err != nil {
+			fmt.Fprintf(os.Stderr, "self-update: restart pid %d: %v\n", *restartPID, err)
+			return 1
+		}
+		fmt.Printf("Asked pid %d to drain its sessions and restart.\n", *restartPID)
+	}
+	return 0
+}
+
+func selfUpdate(ctx context.Context, tag string, check, force bool) error {
+	pubkey, err := updatePublicKey()
+	if err != nil {
+		return err
+	}
+	rel, err := fetchRelease(ctx, tag)
+	if err != nil {
+		return err
+	}
+	vc := newVersionCheck(rel)
+	if check {
+		fmt.Printf("current %s (%s), latest %s\n", vc.Current, vc.Commit, vc.Latest)
+		switch {
+		case !vc.AssetFound:
+			fmt.Printf("release %s has no %s asset\n", vc.Latest, vc.Asset)
+		case vc.UpdateAvailable:
+			fmt.Println("update available")
+		case !vc.Comparable:
+			fmt.Println("not a release build; use -force to install the release")
+		default:
+			fmt.Println("up to date")
+		}
+		return nil
+	}
+	if !force && !vc.UpdateAvailable {
+		if !vc.Comparable {
+			return fmt.Errorf("running %s is not a release build; use -force to install %s", Version, rel.Tag)
+		}
+		return fmt.Errorf("%w (%s)", errNoUpdate, Version)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	data, err := downloadVerified(ctx, rel, vc.Asset, pubkey)
+	if err != nil {
+		return err
+	}
+	if err := replaceExecutable(exe, data); err != nil {
+		return fmt.Errorf("replace %s: %w", exe, err)
+	}
+	fmt.Printf("Updated %s: %s -> %s\n", exe, Version, rel.Tag)
+	return nil
+}
+
+// restartAfterShutdown makes main re-exec the executable once the graceful
+// shutdown finishes.
+var restartAfterShutdown atomic.Bool
+
+// restartDraining is set once SIGUSR2 arrives; getOrCreateSession then
+// refuses new sessions so the drain can finish.
+var restartDraining atomic.Bool
+
+// restartDrainTimeout reads SWE_RESTART_DRAIN.
+func restartDrainTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("SWE_RESTART_DRAIN")); err == nil && d >= 0 {
+		return d
+	}
+	return 10 * time.Minute
+}
+
+// restartOnSignal drains and restarts the server on SIGUSR2, which
+// `self-update -restart` sends once the new binary is in place.
+func restartOnSignal() {
+	defer recoverGoroutine("restart signal")
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	<-sig
+	restartDraining.Store(true)
+	timeout := restartDrainTimeout()
+	log.Printf("Restart requested (SIGUSR2): waiting up to %s for sessions to end", timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		sessionsMu.RLock()
+		n := len(sessions)
+		sessionsMu.RUnlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.Printf("Restart: %d session(s) still open after %s; closing them", n, timeout)
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	restartAfterShutdown.Store(true)
+	select {
+	case serverShutdownRequests <- "restart requested (SIGUSR2)":
+	default: // a shutdown is already in flight; it will restart on exit
+	}
+}
+
+// reexecSelf replaces the process with a fresh run of the executable.
+func reexecSelf() {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Restart: %v", err)
+	}
+	log.Printf("Restart: exec %s", exe)
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		log.Fatalf("Restart: exec %s: %v", exe, err)
+	}
+}
@@ -0,0 +1,441 @@
+// exec_api.go -- POST /api/exec: one-shot commands outside the agent PTY.
+//
+// The UI uses this to run quick commands ("make test", "git log") in a
+// session's working directory without typing into -- and polluting -- the
+// agent's terminal. Commands are argv, never a shell string: there is no
+// expansion, piping, or redirection, argv must start with an allowlisted
+// prefix (execAllowlist), and flags that would run another program or write
+// an arbitrary file are refused (execDeniedFlags). Combined stdout+stderr is
+// streamed back as NDJSON while the command runs.
+//
+// The allowlist keeps this endpoint to the handful of read-mostly commands the
+// UI offers; it is not a sandbox. The caller is already authenticated and can
+// type anything into the session terminal. Shared-session guests are denied
+// outright (scopedPathAllowed).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// execDefaultTimeout applies when the request omits timeout.
+	execDefaultTimeout = 60 * time.Second
+	// execMaxTimeout caps a requested timeout so a forgotten watch-mode
+	// command cannot hold a process and a connection open indefinitely.
+	execMaxTimeout = 10 * time.Minute
+	// execMaxOutputBytes caps the streamed output; the command keeps running
+	// to completion, later output is dropped and the exit line says so.
+	execMaxOutputBytes = 1 << 20
+)
+
+// defaultExecAllowlist is used when SWE_EXEC_ALLOW is unset or empty. Each
+// entry is an argv prefix matched word-for-word, so "git log" admits
+// ["git", "log", "--oneline"] but not ["git", "-c", "x=y", "log"].
+var defaultExecAllowlist = []string{
+	"git status",
+	"git log",
+	"git diff",
+	"git show",
+	"git branch",
+	"make test",
+	"go test",
+	"go vet",
+	"npm test",
+	"ls",
+}
+
+// execAllowlist holds the parsed argv prefixes /api/exec accepts. Set from
+// SWE_EXEC_ALLOW (comma-separated, e.g. "make test,git log") by
+// loadExecAllowlist.
+var execAllowlist = parseExecAllowlist(strings.Join(defaultExecAllowlist, ","))
+
+// loadExecAllowlist applies SWE_EXEC_ALLOW. Empty keeps the default list (so
+// docker-compose can pass the variable through unconditionally); "none"
+// disables the endpoint.
+func loadExecAllowlist() {
+	switch v := strings.TrimSpace(os.Getenv("SWE_EXEC_ALLOW")); v {
+	case "":
+	case "none":
+		execAllowlist = nil
+		log.Printf("Exec API disabled (SWE_EXEC_ALLOW=none)")
+	default:
+		execAllowlist = parseExecAllowlist(v)
+		log.Printf("Exec API allowlist from SWE_EXEC_ALLOW: %d entries", len(execAllowlist))
+	}
+}
+
+// parseExecAllowlist splits a comma-separated allowlist into argv prefixes.
+// Blank entries are skipped.
+func parseExecAllowlist(s string) [][]string {
+	var out [][]string
+	for _, entry := range strings.Split(s, ",") {
+		if fields := strings.Fields(entry); len(fields) > 0 {
+			out = append(out, fields)
+		}
+	}
+	return out
+}
+
+// execAllowed reports whether argv starts with one of the allowlist prefixes.
+func execAllowed(allow [][]string, argv []string) bool {
+	for _, prefix := range allow {
+		if len(argv) < len(prefix) {
+			continue
+		}
+		match := true
+		for i, word := range prefix {
+			if argv[i] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// execDeniedFlags are flags, by command, that turn an allowlisted command
+// into running another program ("go test -exec=sh") or writing an arbitrary
+// file ("git log --output=PATH"). They are refused anywhere in argv whatever
+// the allowlist says. Names are matched without their leading dashes, alone
+// or before "=VALUE"; a one-letter name also matches its attached-value form
+// ("-ofile", "make -fFILE").
+var execDeniedFlags = map[string][]string{
+	"go": {
+		"exec", "toolexec", "vettool", "o", "outputdir", "overlay", "modfile", "pkgdir",
+		"coverprofile", "cpuprofile", "memprofile", "blockprofile", "mutexprofile", "trace",
+	},
+	"git":  {"output", "ext-diff", "exec-path", "upload-pack", "open-files-in-pager"},
+	"make": {"f", "file", "makefile", "C", "directory", "E", "eval", "I", "include-dir"},
+	"npm":  {"script-shell", "prefix", "userconfig", "globalconfig", "node-options"},
+}
+
+// execDeniedArg returns the first argument of argv that is one of
+// execDeniedFlags for argv[0], or "" when there is none.
+func execDeniedArg(argv []string) string {
+	denied := execDeniedFlags[argv[0]]
+	for _, arg := range argv[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		for _, flag := range denied {
+			if name == flag || len(flag) == 1 && !strings.HasPrefix(arg, "--") && strings.HasPrefix(name, flag) {
+				return arg
+			}
+		}
+	}
+	return ""
+}
+
+// splitExecArgs splits a command line into argv, honouring single and double
+// quotes and backslash escapes the way a shell would for plain words. Nothing
+// is expanded: $VAR, globs, |, > and ; are ordinary characters.
+func splitExecArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		cur     strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// execRequest is the POST /api/exec body. Cmd is either a command line string
+// (split by splitExecArgs) or a JSON array of argv. SessionUUID takes
+// precedence over WorkDir. Timeout is in seconds.
+type execRequest struct {
+	Cmd         json.RawMessage `json:"cmd"`
+	WorkDir     string          `json:"workDir"`
+	SessionUUID string          `json:"sessionUUID"`
+	Timeout     float64         `json:"timeout"`
+}
+
+// argv decodes Cmd into an argument vector.
+func (req execRequest) argv() ([]string, error) {
+	var line string
+	if err := json.Unmarshal(req.Cmd, &line); err == nil {
+		return splitExecArgs(line)
+	}
+	var argv []string
+	if err := json.Unmarshal(req.Cmd, &argv); err != nil {
+		return nil, errors.New("cmd must be a string or an array of strings")
+	}
+	return argv, nil
+}
+
+// timeout returns the effective timeout, defaulted and clamped.
+func (req execRequest) timeout() time.Duration {
+	if req.Timeout <= 0 {
+		return execDefaultTimeout
+	}
+	// Clamp in seconds: a huge value would overflow the Duration.
+	if req.Timeout >= execMaxTimeout.Seconds() {
+		return execMaxTimeout
+	}
+	return time.Duration(req.Timeout * float64(time.Second))
+}
+
+// resolveExecWorkDir validates a caller-supplied directory against
+// policyWorkDir (pathpolicy.go): the workspace or below it, or under the
+// worktrees or repos directory. Empty means the workspace.
+func resolveExecWorkDir(dir string) (string, error) {
+	if dir == "" {
+		return workspaceDir, nil
+	}
+	return policyWorkDir.Resolve(dir)
+}
+
+// execStream writes NDJSON lines to the response, flushing each so the
+// client sees output as it is produced. It is used as both Stdout and Stderr
+// of the command; exec.Cmd serializes writes when they share one writer, but
+// the exit line is written from the handler goroutine, hence the mutex.
+type execStream struct {
+	mu        sync.Mutex
+	w         http.ResponseWriter
+	enc       *json.Encoder
+	written   int
+	truncated bool
+}
+
+func newExecStream(w http.ResponseWriter) *execStream {
+	return &execStream{w: w, enc: json.NewEncoder(w)}
+}
+
+func (s *execStream) send(v interface{}) {
+	s.enc.Encode(v)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Write streams p as an output line, dropping anything past
+// execMaxOutputBytes. It never fails, so the command is not killed by
+// SIGPIPE when output is truncated.
+func (s *execStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(p)
+	if room := execMaxOutputBytes - s.written; len(p) > room {
+		p = p[:max(room, 0)]
+		s.truncated = true
+	}
+	if len(p) > 0 {
+		s.written += len(p)
+		s.send(map[string]string{"type": "output", "data": string(p)})
+	}
+	return n, nil
+}
+
+// event sends a non-output line (used by the publish API).
+func (s *execStream) event(v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.send(v)
+}
+
+func (s *execStream) exit(exitCode int, timedOut bool, elapsed time.Duration, errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg := map[string]interface{}{
+		"type":       "exit",
+		"exitCode":   exitCode,
+		"durationMs": elapsed.Milliseconds(),
+	}
+	if timedOut {
+		msg["timedOut"] = true
+	}
+	if s.truncated {
+		msg["truncated"] = true
+	}
+	if errMsg != "" {
+		msg["error"] = errMsg
+	}
+	s.send(msg)
+}
+
+// handleExecAPI handles POST /api/exec.
+//
+// Request:  {"cmd": "git log --oneline -5", "sessionUUID": "...", "timeout": 30}
+//
+//	or {"cmd": ["make", "test"], "workDir": "/workspace"}
+//
+// Response: application/x-ndjson, one {"type":"output","data":"..."} line per
+// chunk of combined stdout/stderr, then a final
+// {"type":"exit","exitCode":N,"durationMs":N[,"timedOut":true][,"truncated":true]}.
+// Validation failures (bad JSON, disallowed command, unknown session, bad
+// workDir) are plain HTTP errors before any streaming starts.
+func handleExecAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req execRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	argv, err := req.argv()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(argv) == 0 {
+		http.Error(w, "Missing cmd", http.StatusBadRequest)
+		return
+	}
+	if !execAllowed(execAllowlist, argv) {
+		http.Error(w, fmt.Sprintf("Command not allowed: %q (see SWE_EXEC_ALLOW)", strings.Join(argv, " ")), http.StatusForbidden)
+		return
+	}
+	if arg := execDeniedArg(argv); arg != "" {
+		http.Error(w, fmt.Sprintf("Flag not allowed: %q", arg), http.StatusForbidden)
+		return
+	}
+
+	// Resolve the directory and environment: a session's own working
+	// directory and agent environment (ports, git credential helper), or a
+	// validated workDir with the server's environment.
+	var dir string
+	var env []string
+	if req.SessionUUID != "" {
+		sessionsMu.RLock()
+		sess, ok := sessions[req.SessionUUID]
+		sessionsMu.RUnlock()
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		dir = sess.effectiveWorkDir()
+		sess.mu.RLock()
+		theme := sess.Theme
+		sess.mu.RUnlock()
+		env = buildSessionEnv(SessionEnvParams{
+			PreviewPort:   sess.PreviewPort,
+			AgentChatPort: sess.AgentChatPort,
+			PublicPort:    sess.PublicPort,
+			CDPPort:       sess.CDPPort,
+			VNCPort:       sess.VNCPort,
+			Theme:         theme,
+			WorkDir:       sess.WorkDir,
+			SessionMode:   sess.SessionMode,
+			SID:           sess.UUID,
+			SkipRepoEnv:   sess.untrustedRepo,
+		})
+		env = append(env, "SESSION_UUID="+sess.UUID)
+	} else {
+		if dir, err = resolveExecWorkDir(req.WorkDir); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		env = os.Environ()
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		http.Error(w, fmt.Sprintf("workDir %q is not a directory", dir), http.StatusBadRequest)
+		return
+	}
+
+	timeout := req.timeout()
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	// Own process group so a timeout or client disconnect kills whatever the
+	// command spawned (make -> go test -> test binaries), not just argv[0].
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 2 * time.Second
+
+	stream := newExecStream(w)
+	cmd.Stdout = stream
+	cmd.Stderr = stream
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		log.Printf("Exec from %s: %q in %s failed to start: %v", clientLabel(r), argv, dir, err)
+		stream.exit(-1, false, 0, err.Error())
+		return
+	}
+	log.Printf("Exec from %s: %q in %s (pid %d, timeout %s)", clientLabel(r), argv, dir, cmd.Process.Pid, timeout)
+
+	err = cmd.Wait()
+	elapsed := time.Since(start)
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+	exitCode := 0
+	errMsg := ""
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		exitCode = exitErr.ExitCode()
+	default:
+		exitCode = -1
+		errMsg = err.Error()
+	}
+	if timedOut {
+		errMsg = fmt.Sprintf("timed out after %s", timeout)
+	}
+	log.Printf("Exec from %s: %q exited %d after %s", clientLabel(r), argv, exitCode, elapsed.Round(time.Millisecond))
+	stream.exit(exitCode, timedOut, elapsed, errMsg)
+}
@@ -0,0 +1,226 @@
+// session_actions.go -- what the command palette can do in a session.
+//
+// GET /api/session/{uuid}/actions lists the actions that apply to the session
+// now, so the front end builds its command palette from what the server knows
+// -- the agent's restart and YOLO commands, the worktree, the test and
+// preview commands, the agent's slash commands and the user's role -- instead
+// of repeating those rules in JS:
+//
+//	{"actions": [
+//	  {"id": "restart_agent", "label": "Restart agent", "enabled": true,
+//	   "confirm": true, "ws": {"type": "restart_agent"}},
+//	  {"id": "toggle_yolo", "label": "Turn YOLO mode on", "enabled": true,
+//	   "confirm": true, "ws": {"type": "toggle_yolo"}},
+//	  {"id": "open_shell", "label": "Open terminal", "enabled": true,
+//	   "open": "/session/{shell uuid}?assistant=shell&parent={uuid}"},
+//	  {"id": "publish_worktree", "label": "Publish fix-login", "enabled": true,
+//	   "confirm": true, "http": {"method": "POST", "url": "/api/worktree/fix-login/publish"}},
+//	  {"id": "run_tests", "label": "Run tests", "enabled": false,
+//	   "reason": "tests are running", "http": {"method": "POST", "url": "/api/session/{uuid}/tests/run"}},
+//	  {"id": "start_preview_app", "label": "Start preview app", "enabled": true,
+//	   "ws": {"type": "start_preview_app"}},
+//	  {"id": "macro", "label": "/review", "hint": "Review the diff", "enabled": true,
+//	   "ws": {"type": "prompt", "text": "/review"}}
+//	]}
+//
+// Each action runs one way: "ws" is a control message to send on the
+// session's WebSocket, "http" a request to make, "open" a page to open.
+// An action that applies but cannot run now is listed with enabled false and
+// the reason; one that does not apply (YOLO for an agent without it, publish
+// outside a worktree) is left out. "confirm" asks the client to confirm
+// first. Labels follow the request's language (i18n.go).
+//
+// Macros are the agent's slash commands, project ones first, as autocomplete
+// finds them; running one queues it as a prompt (prompt_queue.go).
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// sessionAction is one command palette entry.
+type sessionAction struct {
+	ID      string         `json:"id"`
+	Label   string         `json:"label"`
+	Hint    string         `json:"hint,omitempty"`
+	Enabled bool           `json:"enabled"`
+	Reason  string         `json:"reason,omitempty"`
+	Confirm bool           `json:"confirm,omitempty"`
+	WS      map[string]any `json:"ws,omitempty"`
+	HTTP    *actionRequest `json:"http,omitempty"`
+	Open    string         `json:"open,omitempty"`
+}
+
+// actionRequest is the HTTP request an action makes.
+type actionRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// deriveShellUUID is static/modules/uuid.js's deriveShellUUID: the session
+// UUID of the shell opened from parentUUID.
+func deriveShellUUID(parentUUID string) string {
+	djb2 := func(s string, seed uint32) uint32 {
+		h := seed
+		for _, c := range []byte(s) {
+			h = h*33 + uint32(c)
+		}
+		return h
+	}
+	input := "shell:" + parentUUID
+	h1 := djb2(input, 5381)
+	h2 := djb2(input, h1)
+	h3 := djb2(input, h2)
+	h4 := djb2(input, h3)
+	hex := fmt.Sprintf("%08x%08x%08x%08x", h1, h2, h3, h4)
+	var variant byte
+	fmt.Sscanf(hex[16:18], "%02x", &variant)
+	return fmt.Sprintf("%s-%s-4%s-%02x%s-%s", hex[:8], hex[8:12], hex[13:16], variant&0x3f|0x80, hex[18:20], hex[20:32])
+}
+
+// restartUnavailable says why the agent cannot be restarted now ("" if it can).
+func (s *Session) restartUnavailable() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch {
+	case s.AssistantConfig.ShellRestartCmd == "":
+		return "this agent has no restart command"
+	case s.hibernated():
+		return "the session is hibernated"
+	case s.Cmd == nil || s.Cmd.Process == nil || s.Cmd.ProcessState != nil:
+		return "the agent is not running"
+	case s.ending:
+		return "the session is ending"
+	}
+	return ""
+}
+
+// sessionActions lists the palette actions for sess. denied is why the
+// requester may not act at all, and yoloDenied why they may not toggle YOLO
+// mode ("" if they may).
+func sessionActions(sess *Session, locale, denied, yoloDenied string) []sessionAction {
+	var actions []sessionAction
+	add := func(a sessionAction, reason string) {
+		if reason == "" {
+			reason = denied
+		}
+		a.Enabled, a.Reason = reason == "", reason
+		actions = append(actions, a)
+	}
+
+	add(sessionAction{ID: "restart_agent", Label: tr(locale, "action.restartAgent"), Confirm: true,
+		WS: map[string]any{"type": "restart_agent"}}, sess.restartUnavailable())
+
+	sess.mu.RLock()
+	yoloSupported, yolo := sess.AssistantConfig.YoloRestartCmd != "", sess.yoloMode
+	isShell := sess.ParentUUID != "" || sess.Assistant == "shell"
+	branch := sess.BranchName
+	sess.mu.RUnlock()
+
+	if yoloSupported {
+		label := tr(locale, "action.yoloOn")
+		if yolo {
+			label = tr(locale, "action.yoloOff")
+		}
+		add(sessionAction{ID: "toggle_yolo", Label: label, Confirm: true,
+			WS: map[string]any{"type": "toggle_yolo"}}, yoloDenied)
+	}
+
+	if !isShell {
+		q := url.Values{"assistant": {"shell"}, "parent": {sess.UUID}}
+		add(sessionAction{ID: "open_shell", Label: tr(locale, "action.openShell"),
+			Open: "/session/" + deriveShellUUID(sess.UUID) + "?" + q.Encode()}, "")
+	}
+
+	if branch != "" && validBranchName(branch) {
+		add(sessionAction{ID: "publish_worktree", Label: tr(locale, "action.publish", branch), Confirm: true,
+			HTTP: &actionRequest{Method: http.MethodPost, URL: "/api/worktree/" + branch + "/publish"}}, "")
+	}
+
+	testReason := ""
+	if strings.TrimSpace(envLookup(sess.spawnEnv())("SWE_TEST_CMD")) == "" {
+		testReason = "no test command: set SWE_TEST_CMD in .swe-swe/env"
+	} else if r := sess.testsSnapshot(); r != nil && r.Status == "running" {
+		testReason = "tests are running"
+	}
+	add(sessionAction{ID: "run_tests", Label: tr(locale, "action.runTests"),
+		HTTP: &actionRequest{Method: http.MethodPost, URL: "/api/session/" + sess.UUID + "/tests/run"}}, testReason)
+
+	if sess.previewAppCommand() != "" {
+		if a := sess.previewAppSnapshot(false); a.Status == "stopped" || a.Status == "crashed" {
+			add(sessionAction{ID: "start_preview_app", Label: tr(locale, "action.startPreviewApp"),
+				WS: map[string]any{"type": "start_preview_app"}}, "")
+		} else {
+			add(sessionAction{ID: "stop_preview_app", Label: tr(locale, "action.stopPreviewApp"),
+				WS: map[string]any{"type": "stop_preview_app"}}, "")
+		}
+	}
+
+	for _, macro := range sessionMacros(sess) {
+		add(sessionAction{ID: "macro", Label: "/" + macro.V, Hint: macro.H,
+			WS: map[string]any{"type": "prompt", "text": "/" + macro.V}}, "")
+	}
+	return actions
+}
+
+// sessionMacros is the agent's slash commands, project ones first, without
+// duplicates.
+func sessionMacros(sess *Session) []autocompleteItem {
+	systemDir, ext := slashCommandDirForAgent(sess.Assistant, sess.AssistantConfig.SlashCmdFormat)
+	var dirs []string
+	if projectDir := projectCommandDir(sess.Assistant, sess.WorkDir); projectDir != "" && projectDir != systemDir {
+		dirs = append(dirs, projectDir)
+	}
+	if systemDir != "" {
+		dirs = append(dirs, systemDir)
+	}
+	var macros []autocompleteItem
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		for _, item := range discoverSlashCommands(dir, ext) {
+			if !seen[item.V] {
+				seen[item.V] = true
+				macros = append(macros, item)
+			}
+		}
+	}
+	return macros
+}
+
+// handleSessionActionsAPI serves GET /api/session/{uuid}/actions.
+func handleSessionActionsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionUUID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/actions")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// A viewer (auth_roles.go) or view-only guest sees what the session can
+	// do, all of it disabled.
+	identity := requestIdentity(r)
+	denied, yoloDenied := "", ""
+	if l := requestShareLink(r); l != nil && l.Scope == shareScopeView {
+		denied = "view-only share"
+	} else if !identity.can(permInput) {
+		denied = "not allowed for role " + identity.Role
+	}
+	if !identity.can(permYolo) {
+		yoloDenied = "not allowed for role " + identity.Role
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"actions": sessionActions(sess, requestLocale(r), denied, yoloDenied),
+	})
+}
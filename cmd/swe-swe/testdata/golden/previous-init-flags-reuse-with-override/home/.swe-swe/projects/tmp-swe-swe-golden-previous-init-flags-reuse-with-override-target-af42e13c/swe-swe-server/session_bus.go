@@ -0,0 +1,239 @@
+// session_bus.go -- messages between agents in different sessions.
+//
+// Agents that work as a team (a "review" session asking the "coding"
+// session's agent for clarification) used to have only send_chat_message,
+// which types into the other agent's chat as if a human had. The bus gives
+// them structured messages instead: the send_to_session MCP tool delivers a
+// message (kind, text, optional JSON data, reply_to) to another live
+// session's inbox, and the subscribe tool long-polls the calling session's
+// own inbox. The sender is always the authenticated calling session (its MCP
+// auth key), so an agent cannot send as someone else.
+//
+// Both sessions' pages also get a "session_message" WebSocket event for every
+// message, shown in the session chat overlay, so humans can follow what the
+// agents say to each other.
+//
+// Inboxes are in memory, bounded, and dropped when the session ends; message
+// IDs are global so a reply_to is unambiguous.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	// sessionInboxLimit bounds each inbox; older messages are dropped.
+	sessionInboxLimit = 200
+	// busMaxText and busMaxData bound one message.
+	busMaxText = 16 << 10
+	busMaxData = 64 << 10
+	// busMaxWait caps how long subscribe blocks.
+	busMaxWait = 55 * time.Second
+)
+
+// busMessage is one message on the bus.
+type busMessage struct {
+	ID       uint64          `json:"id"`
+	Time     time.Time       `json:"time"`
+	From     string          `json:"from"`
+	FromName string          `json:"fromName,omitempty"`
+	To       string          `json:"to"`
+	ToName   string          `json:"toName,omitempty"`
+	Kind     string          `json:"kind,omitempty"`
+	Text     string          `json:"text"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	ReplyTo  uint64          `json:"replyTo,omitempty"`
+}
+
+// busSeq numbers messages across all inboxes (first message is 1).
+var busSeq atomic.Uint64
+
+// sessionInbox is a fixed-size ring of messages addressed to one session.
+type sessionInbox struct {
+	mu    sync.Mutex
+	msgs  []busMessage
+	start int           // index of the oldest message once the ring is full
+	wake  chan struct{} // closed (and replaced) when a message arrives
+}
+
+func newSessionInbox() *sessionInbox {
+	return &sessionInbox{wake: make(chan struct{})}
+}
+
+func (in *sessionInbox) add(m busMessage) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.msgs) < sessionInboxLimit {
+		in.msgs = append(in.msgs, m)
+	} else {
+		in.msgs[in.start] = m
+		in.start = (in.start + 1) % sessionInboxLimit
+	}
+	close(in.wake)
+	in.wake = make(chan struct{})
+}
+
+// since returns messages with ID > after, oldest first, the cursor to pass
+// next time, and a channel closed when another message arrives.
+func (in *sessionInbox) since(after uint64) ([]busMessage, uint64, <-chan struct{}) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	out := []busMessage{}
+	cursor := after
+	for i := range in.msgs {
+		m := in.msgs[(in.start+i)%len(in.msgs)]
+		if m.ID > after {
+			out = append(out, m)
+			cursor = m.ID
+		}
+	}
+	return out, cursor, in.wake
+}
+
+// wait returns messages after the cursor, blocking up to wait for the first
+// one to arrive.
+func (in *sessionInbox) wait(ctx context.Context, after uint64, wait time.Duration) ([]busMessage, uint64) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		msgs, cursor, wake := in.since(after)
+		if len(msgs) > 0 || wait <= 0 {
+			return msgs, cursor
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			return msgs, cursor
+		case <-ctx.Done():
+			return msgs, cursor
+		}
+	}
+}
+
+// sessionInboxes maps session UUID -> *sessionInbox for live sessions.
+var sessionInboxes sync.Map
+
+// registerSessionInbox opens uuid's inbox. Called when the session is added
+// to the sessions map.
+func registerSessionInbox(uuid string) {
+	sessionInboxes.LoadOrStore(uuid, newSessionInbox())
+}
+
+// unregisterSessionInbox drops the inbox. Called at the end of Close.
+func unregisterSessionInbox(uuid string) {
+	sessionInboxes.Delete(uuid)
+}
+
+func loadSessionInbox(uuid string) (*sessionInbox, bool) {
+	v, ok := sessionInboxes.Load(uuid)
+	if !ok {
+		return nil, false
+	}
+	return v.(*sessionInbox), true
+}
+
+// sendSessionMessage delivers m from one live session to another and shows
+// it on both sessions' pages. m.From and m.To must be set; the ID, time and
+// names are filled in.
+func sendSessionMessage(m busMessage) (busMessage, error) {
+	if m.From == m.To {
+		return m, fmt.Errorf("cannot send a message to the calling session itself")
+	}
+	if strings.TrimSpace(m.Text) == "" {
+		return m, fmt.Errorf("text is required")
+	}
+	if len(m.Text) > busMaxText {
+		return m, fmt.Errorf("text is %d bytes, limit %d", len(m.Text), busMaxText)
+	}
+	if len(m.Data) > busMaxData {
+		return m, fmt.Errorf("data is %d bytes, limit %d", len(m.Data), busMaxData)
+	}
+	sessionsMu.RLock()
+	from, to := sessions[m.From], sessions[m.To]
+	sessionsMu.RUnlock()
+	inbox, ok := loadSessionInbox(m.To)
+	if to == nil || !ok || to.isEnding() {
+		return m, fmt.Errorf("session %s not found or ending", m.To)
+	}
+	if from != nil {
+		m.FromName = from.Name
+	}
+	m.ToName = to.Name
+	m.ID = busSeq.Add(1)
+	m.Time = time.Now()
+	inbox.add(m)
+
+	to.BroadcastJSON(map[string]interface{}{"type": "session_message", "direction": "in", "message": m})
+	if from != nil {
+		from.BroadcastJSON(map[string]interface{}{"type": "session_message", "direction": "out", "message": m})
+	}
+	return m, nil
+}
+
+// registerSessionBusTools adds send_to_session and subscribe to the
+// orchestration MCP server.
+func registerSessionBusTools(server *mcp.Server) {
+	type sendToSessionArgs struct {
+		UUID    string         `json:"uuid" jsonschema:"UUID of the session to send to (see list_sessions)"`
+		Kind    string         `json:"kind,omitempty" jsonschema:"What the message is, e.g. question, answer, review, status"`
+		Text    string         `json:"text" jsonschema:"Message text"`
+		Data    map[string]any `json:"data,omitempty" jsonschema:"Optional structured payload"`
+		ReplyTo uint64         `json:"reply_to,omitempty" jsonschema:"ID of the message this answers"`
+	}
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "send_to_session",
+		Description: "Send a structured message to another session's agent. It lands in that session's inbox (read with subscribe) and is shown on both sessions' pages. Returns the delivered message, including its id for reply_to.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, args sendToSessionArgs) (*mcp.CallToolResult, any, error) {
+		caller := callerSessionFromContext(ctx)
+		if caller == "" {
+			return nil, nil, fmt.Errorf("unauthenticated: missing calling session identity")
+		}
+		m := busMessage{From: caller, To: args.UUID, Kind: strings.TrimSpace(args.Kind), Text: args.Text, ReplyTo: args.ReplyTo}
+		if len(args.Data) > 0 {
+			data, err := json.Marshal(args.Data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("data: %w", err)
+			}
+			m.Data = data
+		}
+		sent, err := sendSessionMessage(m)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, _ := json.Marshal(sent)
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(data)}}}, nil, nil
+	})
+
+	type subscribeArgs struct {
+		Cursor      uint64 `json:"cursor,omitempty" jsonschema:"Return messages with id > cursor; pass back the cursor from the previous call. 0 returns everything still in the inbox."`
+		WaitSeconds *int   `json:"wait_seconds,omitempty" jsonschema:"How long to wait for a message when none is pending, 0-55 (default 30); 0 returns at once"`
+	}
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "subscribe",
+		Description: "Read messages other sessions sent to this session with send_to_session, waiting up to wait_seconds for one to arrive. Returns {messages, cursor}; call again with the cursor to keep listening.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, args subscribeArgs) (*mcp.CallToolResult, any, error) {
+		caller := callerSessionFromContext(ctx)
+		if caller == "" {
+			return nil, nil, fmt.Errorf("unauthenticated: missing calling session identity")
+		}
+		inbox, ok := loadSessionInbox(caller)
+		if !ok {
+			return nil, nil, fmt.Errorf("session not found")
+		}
+		wait := 30 * time.Second
+		if args.WaitSeconds != nil {
+			wait = min(max(time.Duration(*args.WaitSeconds)*time.Second, 0), busMaxWait)
+		}
+		msgs, cursor := inbox.wait(ctx, args.Cursor, wait)
+		data, _ := json.Marshal(map[string]interface{}{"messages": msgs, "cursor": cursor})
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(data)}}}, nil, nil
+	})
+}
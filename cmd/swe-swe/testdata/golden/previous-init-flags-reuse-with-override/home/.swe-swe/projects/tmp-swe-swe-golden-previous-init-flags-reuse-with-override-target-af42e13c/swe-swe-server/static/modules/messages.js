@@ -8,6 +8,12 @@
 export const OPCODE_RESIZE = 0x00;
 export const OPCODE_FILE_UPLOAD = 0x01;
 export const OPCODE_CHUNK = 0x02;
+export const OPCODE_IMAGE_PASTE = 0x03;
+export const OPCODE_SEQUENCED_INPUT = 0x04;
+
+// Protocol version this client speaks, sent as ?proto= on connect. Bump it
+// together with wsProtocolVersion in ws_protocol.go.
+export const PROTOCOL_VERSION = 1;
 
 /**
  * Encode a terminal resize message.
@@ -46,6 +52,44 @@ export function encodeFileUpload(filename, data) {
     return message;
 }
 
+/**
+ * Encode a clipboard image paste message. The clipboard carries no filename;
+ * the server derives one from the content type and paste time. Send it
+ * wrapped by encodeSequencedInput: a bare 0x03 frame is Ctrl-C to the server.
+ * Format: [0x03, type_len, ...content_type_bytes, ...image_data]
+ * @param {string} contentType - MIME type, e.g. "image/png" (max 255 bytes)
+ * @param {Uint8Array} data - The image data
+ * @returns {Uint8Array} Binary message
+ */
+export function encodeImagePaste(contentType, data) {
+    const typeBytes = new TextEncoder().encode(contentType).slice(0, 255);
+    const message = new Uint8Array(1 + 1 + typeBytes.length + data.length);
+    message[0] = OPCODE_IMAGE_PASTE;
+    message[1] = typeBytes.length;
+    message.set(typeBytes, 2);
+    message.set(data, 2 + typeBytes.length);
+    return message;
+}
+
+/**
+ * Wrap an input frame (keystrokes, file upload or image paste) with its
+ * sequence number, so the server can drop frames resent after a reconnect.
+ * Format: [0x04, seq_b3, seq_b2, seq_b1, seq_b0, ...frame]
+ * @param {number} seq - Sequence number (1 .. 2^32-1)
+ * @param {Uint8Array} frame - The input frame
+ * @returns {Uint8Array} Binary message
+ */
+export function encodeSequencedInput(seq, frame) {
+    const message = new Uint8Array(5 + frame.length);
+    message[0] = OPCODE_SEQUENCED_INPUT;
+    message[1] = (seq >>> 24) & 0xFF;
+    message[2] = (seq >>> 16) & 0xFF;
+    message[3] = (seq >>> 8) & 0xFF;
+    message[4] = seq & 0xFF;
+    message.set(frame, 5);
+    return message;
+}
+
 /**
  * Check if a binary message is a chunk message.
  * @param {Uint8Array} data - Binary data
@@ -93,7 +93,7 @@ type browserBackend struct {
 
 func newBrowserBackend(maxSessions int, token, advertiseHost string) *browserBackend {
 	if maxSessions <= 0 {
-		maxSessions = vncPortEnd - vncPortStart + 1
+		maxSessions = min(len(cdpPool.list()), len(vncPool.list()))
 	}
 	return &browserBackend{
 		sessions:      make(map[string]*backendSession),
@@ -190,11 +190,11 @@ func (bb *browserBackend) handleCreate(w http.ResponseWriter, r *http.Request) {
 	if id == "" {
 		id = fmt.Sprintf("bb-%d", slot)
 	}
-	cdpPort := cdpPortStart + slot
+	cdpPort := cdpPool.list()[slot]
 	// Internal ports sit one range-size above their public counterparts:
 	// chromium's loopback-only CDP and x11vnc's raw VNC.
 	cdpInternal := cdpPort + (cdpPortEnd - cdpPortStart + 1)
-	vncPort := vncPortStart + slot
+	vncPort := vncPool.list()[slot]
 	vncInternal := vncPort + (vncPortEnd - vncPortStart + 1)
 	display := slot + 10 // avoid :0 (the host's own display)
 	// Reserve the slot before the slow start so concurrent creates don't race
@@ -0,0 +1,320 @@
+// session_preview_app.go -- run a repo's dev server for the preview pane, so
+// the preview does not depend on asking the agent to start one.
+//
+// A repo declares its dev server as SWE_PREVIEW_CMD in .swe-swe/env (or
+// server-wide as an env var, or preview.command in the config file), read
+// from the session's environment like SWE_TEST_CMD. The preview pane proxies
+// the session's preview port, which the command gets as $PORT; a dev server
+// that takes a flag instead is told so in the command:
+//
+//	SWE_PREVIEW_CMD=npm run dev -- --port $PORT
+//
+//	POST /api/session/{uuid}/preview-app/start  start it -> 202 with the status
+//	POST /api/session/{uuid}/preview-app/stop   stop it
+//	GET  /api/session/{uuid}/preview-app        the status and the log tail
+//
+// or, on the session's WebSocket, {"type": "start_preview_app"} and
+// {"type": "stop_preview_app"}.
+//
+// The command runs with `sh -c` in the session's working directory, with the
+// session's environment, in a process group of its own. When it exits
+// without being stopped it is restarted after a backoff (1s, doubling up to
+// 30s); after previewAppMaxRestarts exits in a row, each within
+// previewAppStableAfter of starting, it is left "crashed". Stopping sends
+// the group SIGTERM, then SIGKILL after previewAppStopGrace, and the app is
+// stopped with the session. Every change of status is broadcast as
+// {"type": "preview_app", ...} and rides along in the status message as
+// "previewApp"; the log tail is only in the GET.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// previewAppLogTail is how much of the end of the app's output is kept.
+	previewAppLogTail = 64 << 10
+	// previewAppMaxRestarts is how many quick exits in a row are restarted.
+	previewAppMaxRestarts = 5
+	// previewAppStableAfter is how long the app must run for an exit not to
+	// count towards previewAppMaxRestarts.
+	previewAppStableAfter = time.Minute
+	// previewAppMaxBackoff caps the wait before a restart.
+	previewAppMaxBackoff = 30 * time.Second
+	// previewAppStopGrace is how long the app has to exit after SIGTERM.
+	previewAppStopGrace = 5 * time.Second
+)
+
+// previewAppRestartDelay is the wait before the first restart; it doubles
+// with each quick exit in a row. A var so tests need not wait.
+var previewAppRestartDelay = time.Second
+
+// previewAppStatus is what the session's preview app is doing.
+type previewAppStatus struct {
+	Status    string     `json:"status"` // "starting", "running", "restarting", "crashed", "stopped"
+	Command   string     `json:"command,omitempty"`
+	Port      int        `json:"port"`
+	Listening bool       `json:"listening"` // something accepts connections on Port
+	PID       int        `json:"pid,omitempty"`
+	Restarts  int        `json:"restarts"`
+	ExitCode  *int       `json:"exitCode,omitempty"` // of the last exit
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Log       string     `json:"log,omitempty"` // last previewAppLogTail bytes
+}
+
+// previewAppState is a session's preview app. The zero value is stopped.
+type previewAppState struct {
+	mu       sync.Mutex
+	status   previewAppStatus
+	log      *tailBuffer
+	stop     chan struct{} // closed to stop the supervisor; nil when none runs
+	stopping bool          // stop is closed
+	done     chan struct{} // closed when the supervisor returns
+}
+
+var errPreviewAppRunning = errors.New("the preview app is already running")
+
+// previewAppCommand is the session's SWE_PREVIEW_CMD, or "".
+func (s *Session) previewAppCommand() string {
+	return strings.TrimSpace(envLookup(s.spawnEnv())("SWE_PREVIEW_CMD"))
+}
+
+// previewAppSnapshot returns the app's status, secrets masked (secrets.go);
+// withLog adds the log tail and checks whether the port is listening.
+func (s *Session) previewAppSnapshot(withLog bool) previewAppStatus {
+	s.previewApp.mu.Lock()
+	st := s.previewApp.status
+	logs := s.previewApp.log
+	s.previewApp.mu.Unlock()
+	if st.Status == "" {
+		st.Status = "stopped"
+	}
+	st.Port = s.PreviewPort
+	st.Command = maskSecrets(st.Command)
+	if withLog {
+		if logs != nil {
+			st.Log = maskSecrets(logs.String())
+		}
+		if conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", s.PreviewPort), 300*time.Millisecond); err == nil {
+			conn.Close()
+			st.Listening = true
+		}
+	}
+	return st
+}
+
+// updatePreviewApp applies f to the status and broadcasts the result.
+func (s *Session) updatePreviewApp(f func(*previewAppStatus)) {
+	s.previewApp.mu.Lock()
+	f(&s.previewApp.status)
+	s.previewApp.mu.Unlock()
+	s.broadcastPreviewApp()
+}
+
+// broadcastPreviewApp sends the status, without the log, to the session's
+// clients.
+func (s *Session) broadcastPreviewApp() {
+	msg := struct {
+		Type string `json:"type"`
+		previewAppStatus
+	}{"preview_app", s.previewAppSnapshot(false)}
+	s.BroadcastJSON(msg)
+}
+
+// startPreviewApp starts the session's SWE_PREVIEW_CMD under a supervisor
+// and returns its status.
+func (s *Session) startPreviewApp() (previewAppStatus, error) {
+	env := s.spawnEnv()
+	command := strings.TrimSpace(envLookup(env)("SWE_PREVIEW_CMD"))
+	if command == "" {
+		return previewAppStatus{}, fmt.Errorf("no preview command: set SWE_PREVIEW_CMD in .swe-swe/env")
+	}
+	if s.PreviewPort != 0 {
+		env = append(env, fmt.Sprintf("PORT=%d", s.PreviewPort)) // what the preview pane proxies
+	}
+	s.previewApp.mu.Lock()
+	if s.previewApp.stop != nil {
+		s.previewApp.mu.Unlock()
+		return previewAppStatus{}, errPreviewAppRunning
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.previewApp.stop, s.previewApp.done = stop, done
+	s.previewApp.log = &tailBuffer{n: previewAppLogTail}
+	s.previewApp.status = previewAppStatus{Status: "starting", Command: command}
+	logs := s.previewApp.log
+	s.previewApp.mu.Unlock()
+
+	started := make(chan struct{})
+	go func() {
+		defer recoverGoroutine(fmt.Sprintf("preview app for session %s", s.UUID))
+		defer close(done)
+		s.supervisePreviewApp(command, s.effectiveWorkDir(), env, logs, stop, started)
+	}()
+	<-started
+	return s.previewAppSnapshot(false), nil
+}
+
+// stopPreviewApp stops the app, if it runs, and waits for it to exit.
+func (s *Session) stopPreviewApp() {
+	s.previewApp.mu.Lock()
+	if s.previewApp.stop == nil {
+		s.previewApp.mu.Unlock()
+		return
+	}
+	if !s.previewApp.stopping {
+		close(s.previewApp.stop)
+		s.previewApp.stopping = true
+	}
+	done := s.previewApp.done
+	s.previewApp.mu.Unlock()
+	<-done
+}
+
+// supervisePreviewApp runs command until stop is closed, restarting it when
+// it exits. started is closed once the first start was attempted.
+func (s *Session) supervisePreviewApp(command, dir string, env []string, logs *tailBuffer, stop <-chan struct{}, started chan<- struct{}) {
+	streak := 0
+	for {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = dir
+		cmd.Env = env
+		cmd.Stdout = logs
+		cmd.Stderr = logs
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.WaitDelay = 2 * time.Second // a child holding the output open
+		if err := cmd.Start(); err != nil {
+			s.finishPreviewApp("crashed", err.Error(), nil)
+			if started != nil {
+				close(started)
+			}
+			return
+		}
+		at := time.Now()
+		pid := cmd.Process.Pid
+		s.updatePreviewApp(func(st *previewAppStatus) {
+			st.Status, st.PID, st.StartedAt, st.Error = "running", pid, &at, ""
+		})
+		if started != nil {
+			close(started)
+			started = nil
+		}
+		log.Printf("Session %s: preview app started (pid %d): %s", s.UUID, pid, maskSecrets(command))
+
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+		select {
+		case <-stop:
+			syscall.Kill(-pid, syscall.SIGTERM)
+			select {
+			case <-exited:
+			case <-time.After(previewAppStopGrace):
+				syscall.Kill(-pid, syscall.SIGKILL)
+				<-exited
+			}
+			s.finishPreviewApp("stopped", "", nil)
+			log.Printf("Session %s: preview app stopped", s.UUID)
+			return
+		case err := <-exited:
+			syscall.Kill(-pid, syscall.SIGKILL) // whatever it left behind
+			code := -1
+			var exitErr *exec.ExitError
+			if err == nil {
+				code = 0
+			} else if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			}
+			if time.Since(at) >= previewAppStableAfter {
+				streak = 0
+			}
+			streak++
+			log.Printf("Session %s: preview app exited %d after %s", s.UUID, code, time.Since(at).Round(time.Second))
+			if streak > previewAppMaxRestarts {
+				s.finishPreviewApp("crashed", fmt.Sprintf("exited %d times in a row", streak), &code)
+				return
+			}
+			s.updatePreviewApp(func(st *previewAppStatus) {
+				st.Status, st.PID, st.ExitCode = "restarting", 0, &code
+				st.Restarts++
+			})
+		}
+
+		backoff := previewAppRestartDelay << (streak - 1)
+		if backoff > previewAppMaxBackoff {
+			backoff = previewAppMaxBackoff
+		}
+		select {
+		case <-stop:
+			s.finishPreviewApp("stopped", "", nil)
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// finishPreviewApp records that the supervisor is done, so the app can be
+// started again.
+func (s *Session) finishPreviewApp(status, errMsg string, exitCode *int) {
+	if errMsg != "" {
+		log.Printf("Session %s: preview app %s: %s", s.UUID, status, errMsg)
+	}
+	s.previewApp.mu.Lock()
+	s.previewApp.stop, s.previewApp.stopping = nil, false
+	st := &s.previewApp.status
+	st.Status, st.PID, st.Error = status, 0, errMsg
+	if exitCode != nil {
+		st.ExitCode = exitCode
+	}
+	s.previewApp.mu.Unlock()
+	s.broadcastPreviewApp()
+}
+
+// handleSessionPreviewAppAPI handles GET /api/session/{uuid}/preview-app and
+// POST /api/session/{uuid}/preview-app/{start,stop}.
+func handleSessionPreviewAppAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/session/")
+	sessionUUID, sub, _ := strings.Cut(rest, "/preview-app")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sess.previewAppSnapshot(true))
+	case sub == "/start" && r.Method == http.MethodPost:
+		st, err := sess.startPreviewApp()
+		if errors.Is(err, errPreviewAppRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(st)
+	case sub == "/stop" && r.Method == http.MethodPost:
+		sess.stopPreviewApp()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sess.previewAppSnapshot(false))
+	case sub == "" || sub == "/start" || sub == "/stop":
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
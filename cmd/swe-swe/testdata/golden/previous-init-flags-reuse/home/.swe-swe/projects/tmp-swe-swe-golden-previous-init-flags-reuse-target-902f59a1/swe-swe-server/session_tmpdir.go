@@ -0,0 +1,170 @@
+// session_tmpdir.go -- a temporary directory per session.
+//
+// Agents and the tools they run leave a lot behind in /tmp: go-build
+// caches, npm and pip unpack dirs, screenshots, scratch clones. In a
+// long-lived container none of it is ever cleaned, and none of it says
+// which session made it. So each session is spawned with TMPDIR set to a
+// directory of its own (buildSessionEnv), removed when the session closes:
+//
+//	SWE_SESSION_TMPDIR unset    /tmp/swe-swe/<uuid>
+//	SWE_SESSION_TMPDIR=workdir  <workDir>/.swe-swe/tmp/<uuid>
+//	SWE_SESSION_TMPDIR=off      TMPDIR left as the server's
+//
+// (config key session.tmpDir). The workdir form keeps temp files on the
+// workspace volume, next to the repo, rather than in the container's
+// writable layer; its .swe-swe/tmp holds a .gitignore of "*" so git never
+// sees them.
+//
+// Tools that ignore TMPDIR and write /tmp directly are not covered. A
+// TMPDIR set in .swe-swe/env or the Settings panel wins, and container
+// backends keep their own (hostOnlyEnv).
+//
+// Directories whose session is gone -- a server restart or crash skips
+// Close -- are removed by the session reaper once they have not changed
+// for sessionTmpGrace. For the workdir form it only looks in the workDirs
+// of live sessions, so leftovers in a repo nobody works in stay until a
+// session runs there again.
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// var (not const) so tests can redirect to a temp dir.
+var sessionTmpRoot = "/tmp/swe-swe"
+
+// sessionTmpGrace is how long an orphaned temp dir must sit unchanged
+// before the reaper removes it, so a dir made for a session that is still
+// being created is not taken for an orphan.
+const sessionTmpGrace = 10 * time.Minute
+
+// sessionTmpParent is the directory holding session temp dirs for a
+// session in workDir, or "" when SWE_SESSION_TMPDIR=off.
+func sessionTmpParent(workDir string) string {
+	switch os.Getenv("SWE_SESSION_TMPDIR") {
+	case "off":
+		return ""
+	case "workdir":
+		if workDir != "" {
+			return filepath.Join(workDir, ".swe-swe", "tmp")
+		}
+	}
+	return sessionTmpRoot
+}
+
+// ensureSessionTmpDir creates the session's temp dir and returns its path,
+// or "" when the feature is off.
+func ensureSessionTmpDir(sid, workDir string) (string, error) {
+	parent := sessionTmpParent(workDir)
+	if parent == "" {
+		return "", nil
+	}
+	dir := filepath.Join(parent, sid)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if parent != sessionTmpRoot {
+		ignore := filepath.Join(parent, ".gitignore")
+		if _, err := os.Stat(ignore); errors.Is(err, fs.ErrNotExist) {
+			if err := os.WriteFile(ignore, []byte("*\n"), 0644); err != nil {
+				return "", err
+			}
+		}
+	}
+	return dir, nil
+}
+
+// removeSessionTmpDir removes the session's temp dir, wherever
+// SWE_SESSION_TMPDIR put it.
+func removeSessionTmpDir(sid, workDir string) {
+	if sid == "" {
+		return
+	}
+	dirs := []string{filepath.Join(sessionTmpRoot, sid)}
+	if workDir != "" {
+		dirs = append(dirs, filepath.Join(workDir, ".swe-swe", "tmp", sid))
+	}
+	for _, dir := range dirs {
+		if err := removeTree(dir); err != nil {
+			log.Printf("Session %s: removing temp dir %s: %v", sid, dir, err)
+		}
+	}
+}
+
+// removeTree is os.RemoveAll for trees with read-only directories in them
+// (Go's module cache, some unpacked archives), which RemoveAll cannot empty:
+// on failure it makes every directory writable and tries once more.
+func removeTree(dir string) error {
+	if os.RemoveAll(dir) == nil {
+		return nil
+	}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(path, 0700)
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
+
+// sweepSessionTmpDirs removes the temp dirs of sessions that are no longer
+// live: those under sessionTmpRoot and the .swe-swe/tmp of each live
+// session's workDir, named by a session UUID not in live and unchanged
+// since before now-sessionTmpGrace.
+func sweepSessionTmpDirs(live map[string]bool, workDirs []string, now time.Time) {
+	parents := []string{sessionTmpRoot}
+	for _, wd := range workDirs {
+		parents = append(parents, filepath.Join(wd, ".swe-swe", "tmp"))
+	}
+	seen := map[string]bool{}
+	for _, parent := range parents {
+		if seen[parent] {
+			continue
+		}
+		seen[parent] = true
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() || live[e.Name()] {
+				continue
+			}
+			if _, err := uuid.Parse(e.Name()); err != nil {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil || now.Sub(info.ModTime()) < sessionTmpGrace {
+				continue
+			}
+			dir := filepath.Join(parent, e.Name())
+			if err := removeTree(dir); err != nil {
+				log.Printf("Removing orphaned session temp dir %s: %v", dir, err)
+				continue
+			}
+			log.Printf("Removed orphaned session temp dir %s", dir)
+		}
+	}
+}
+
+// reapSessionTmpDirs runs sweepSessionTmpDirs against the sessions map.
+func reapSessionTmpDirs() {
+	live := map[string]bool{}
+	var workDirs []string
+	sessionsMu.RLock()
+	for id, s := range sessions {
+		live[id] = true
+		if s.WorkDir != "" {
+			workDirs = append(workDirs, s.WorkDir)
+		}
+	}
+	sessionsMu.RUnlock()
+	sweepSessionTmpDirs(live, workDirs, time.Now())
+}
@@ -0,0 +1,94 @@
+/**
+ * Unit tests for input-queue.js
+ * Run with: node --test input-queue.test.js
+ */
+
+import { test } from 'node:test';
+import assert from 'node:assert';
+import {
+    createInputQueue,
+    pushInput,
+    ackInput,
+    resumeInput,
+    pendingBytes,
+    serializeInputQueue,
+    deserializeInputQueue
+} from './input-queue.js';
+
+const bytes = (s) => new TextEncoder().encode(s);
+
+function pushAll(state, ...frames) {
+    const messages = [];
+    for (const f of frames) {
+        const r = pushInput(state, bytes(f));
+        state = r.state;
+        messages.push(r.message);
+    }
+    return { state, messages };
+}
+
+test('createInputQueue starts at seq 1 with nothing pending', () => {
+    assert.deepStrictEqual(createInputQueue('tab1'), { id: 'tab1', nextSeq: 1, pending: [] });
+});
+
+test('pushInput numbers frames and wraps them', () => {
+    const { state, messages } = pushAll(createInputQueue('tab1'), 'ls', '\r');
+    assert.strictEqual(state.nextSeq, 3);
+    assert.deepStrictEqual(state.pending.map(p => p.seq), [1, 2]);
+    assert.deepStrictEqual(Array.from(messages[1]), [0x04, 0, 0, 0, 2, 0x0d]);
+});
+
+test('pushInput does not mutate the previous state', () => {
+    const s0 = createInputQueue('tab1');
+    pushInput(s0, bytes('x'));
+    assert.strictEqual(s0.pending.length, 0);
+    assert.strictEqual(s0.nextSeq, 1);
+});
+
+test('ackInput drops acknowledged frames', () => {
+    const { state } = pushAll(createInputQueue('tab1'), 'a', 'b', 'c');
+    assert.deepStrictEqual(ackInput(state, 2).pending.map(p => p.seq), [3]);
+    assert.strictEqual(ackInput(state, 0), state);
+    assert.strictEqual(ackInput(state, 3).pending.length, 0);
+});
+
+test('resumeInput resends what the server did not apply, in order', () => {
+    const { state } = pushAll(createInputQueue('tab1'), 'a', 'b', 'c');
+    const r = resumeInput(state, 1);
+    assert.deepStrictEqual(r.messages.map(m => m[4]), [2, 3]);
+    assert.deepStrictEqual(r.state.pending.map(p => p.seq), [2, 3]);
+    assert.strictEqual(r.state.nextSeq, 4);
+});
+
+test('resumeInput moves nextSeq past the server position', () => {
+    const r = resumeInput(createInputQueue('tab1'), 7);
+    assert.deepStrictEqual(r.messages, []);
+    assert.strictEqual(r.state.nextSeq, 8);
+});
+
+test('pendingBytes sums pending frames', () => {
+    const { state } = pushAll(createInputQueue('tab1'), 'abc', 'de');
+    assert.strictEqual(pendingBytes(state), 5);
+});
+
+test('serializeInputQueue round-trips through deserializeInputQueue', () => {
+    const { state } = pushAll(createInputQueue('tab1'), 'echo hi', '\r');
+    const restored = deserializeInputQueue(serializeInputQueue(state));
+    assert.strictEqual(restored.id, 'tab1');
+    assert.strictEqual(restored.nextSeq, 3);
+    assert.deepStrictEqual(restored.pending.map(p => new TextDecoder().decode(p.frame)), ['echo hi', '\r']);
+});
+
+test('serializeInputQueue keeps the oldest frames within maxBytes', () => {
+    const { state } = pushAll(createInputQueue('tab1'), 'aaaa', 'bbbb', 'cccc');
+    const restored = deserializeInputQueue(serializeInputQueue(state, 9));
+    assert.deepStrictEqual(restored.pending.map(p => p.seq), [1, 2]);
+    assert.strictEqual(restored.nextSeq, 4);
+});
+
+test('deserializeInputQueue rejects invalid input', () => {
+    assert.strictEqual(deserializeInputQueue(null), null);
+    assert.strictEqual(deserializeInputQueue('not json'), null);
+    assert.strictEqual(deserializeInputQueue('{"id":"","nextSeq":1,"pending":[]}'), null);
+    assert.strictEqual(deserializeInputQueue('{"id":"x","nextSeq":"1","pending":[]}'), null);
+});
@@ -0,0 +1,277 @@
+// recording_changes.go -- the files a session changed, kept with its recording.
+//
+// A recording shows what the agent did on screen, but once the session's
+// worktree is removed there was no way to see what it actually changed in
+// the code. When a session ends, saveMetadata now snapshots its working
+// directory into RecordingMetadata.Changes:
+//
+//   - every file that differs from the commit HEAD was on when the session
+//     started (RecordingMetadata.StartCommit), with its git status code and
+//     line counts -- so commits made during the session count too;
+//   - untracked files (status "??");
+//   - the `git diff --stat` text, for display.
+//
+// The snapshot is shown on the playback page (a "Files changed" panel, and
+// the raw data as <script id="recording-changes" type="application/json">)
+// and returned by GET /api/recording/{uuid}. A working directory that is not
+// a git repository records nothing.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordingChangesMaxFiles caps Files; the rest is counted in Truncated.
+const recordingChangesMaxFiles = 500
+
+// recordingChangesMaxStat caps the stored `git diff --stat` text.
+const recordingChangesMaxStat = 32 << 10
+
+// RecordingChanges is a snapshot of what a session changed in its workDir.
+type RecordingChanges struct {
+	CapturedAt time.Time             `json:"captured_at"`
+	Base       string                `json:"base"`              // commit diffed against
+	Head       string                `json:"head,omitempty"`    // HEAD at capture
+	Commits    int                   `json:"commits,omitempty"` // commits from Base to Head
+	Files      []RecordingFileChange `json:"files"`
+	Insertions int                   `json:"insertions"`
+	Deletions  int                   `json:"deletions"`
+	Truncated  int                   `json:"truncated,omitempty"` // files left out of Files
+	DiffStat   string                `json:"diff_stat,omitempty"` // `git diff --stat Base`
+}
+
+// RecordingFileChange is one changed file.
+type RecordingFileChange struct {
+	Path string `json:"path"`
+	// Status is the `git status --porcelain` code ("M", "A", "D", "R", "??"),
+	// or "C" for a file committed during the session with no changes left in
+	// the working tree.
+	Status     string `json:"status"`
+	Insertions int    `json:"insertions,omitempty"`
+	Deletions  int    `json:"deletions,omitempty"`
+	Binary     bool   `json:"binary,omitempty"`
+}
+
+// gitInWorkDir runs git in dir with a timeout and returns its stdout.
+func gitInWorkDir(dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "core.quotePath=false"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// gitHeadCommit returns the commit HEAD points at in dir, or "".
+func gitHeadCommit(dir string) string {
+	out, err := gitInWorkDir(dir, "rev-parse", "--verify", "-q", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// captureRecordingChanges snapshots dir's changes since base ("" = HEAD).
+// It returns nil when dir is not a git work tree.
+func captureRecordingChanges(dir, base string) *RecordingChanges {
+	if dir == "" {
+		return nil
+	}
+	if out, err := gitInWorkDir(dir, "rev-parse", "--is-inside-work-tree"); err != nil || strings.TrimSpace(out) != "true" {
+		return nil
+	}
+	head := gitHeadCommit(dir)
+	if base == "" {
+		base = head
+	}
+	c := &RecordingChanges{CapturedAt: time.Now(), Base: base, Head: head, Files: []RecordingFileChange{}}
+
+	byPath := map[string]*RecordingFileChange{}
+	var order []string
+	add := func(path string) *RecordingFileChange {
+		if f, ok := byPath[path]; ok {
+			return f
+		}
+		byPath[path] = &RecordingFileChange{Path: path, Status: "C"}
+		order = append(order, path)
+		return byPath[path]
+	}
+	if base != "" {
+		// Working tree against base: committed and uncommitted changes.
+		numstat, _ := gitInWorkDir(dir, "diff", "--numstat", "--no-renames", base)
+		for _, line := range strings.Split(numstat, "\n") {
+			fields := strings.SplitN(line, "\t", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			f := add(fields[2])
+			if fields[0] == "-" {
+				f.Binary = true
+				continue
+			}
+			f.Insertions, _ = strconv.Atoi(fields[0])
+			f.Deletions, _ = strconv.Atoi(fields[1])
+			c.Insertions += f.Insertions
+			c.Deletions += f.Deletions
+		}
+		stat, _ := gitInWorkDir(dir, "diff", "--stat", "--no-renames", base)
+		if len(stat) > recordingChangesMaxStat {
+			stat = stat[:recordingChangesMaxStat] + "\n...\n"
+		}
+		c.DiffStat = stat
+		if head != "" && head != base {
+			if out, err := gitInWorkDir(dir, "rev-list", "--count", base+".."+head); err == nil {
+				c.Commits, _ = strconv.Atoi(strings.TrimSpace(out))
+			}
+		}
+	}
+	status, _ := gitInWorkDir(dir, "status", "--porcelain", "--no-renames", "--untracked-files=all")
+	sc := bufio.NewScanner(strings.NewReader(status))
+	for sc.Scan() {
+		line := sc.Text()
+		if len(line) < 4 {
+			continue
+		}
+		add(line[3:]).Status = strings.TrimSpace(line[:2])
+	}
+
+	for _, path := range order {
+		if len(c.Files) == recordingChangesMaxFiles {
+			c.Truncated = len(order) - len(c.Files)
+			break
+		}
+		c.Files = append(c.Files, *byPath[path])
+	}
+	return c
+}
+
+// readRecordingMetadata loads session-{uuid}.metadata.json, preferring the
+// live session's in-memory copy.
+func readRecordingMetadata(recordingUUID string) (*RecordingMetadata, error) {
+	if sess := liveRecordingSession(recordingUUID); sess != nil {
+		sess.mu.RLock()
+		defer sess.mu.RUnlock()
+		meta := *sess.Metadata
+		return &meta, nil
+	}
+	data, err := os.ReadFile(recordingDir(recordingUUID) + "/session-" + recordingUUID + ".metadata.json")
+	if os.IsNotExist(err) {
+		return nil, errRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta RecordingMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// recordingDetails is the GET /api/recording/{uuid} response.
+type recordingDetails struct {
+	UUID           string            `json:"uuid"`
+	Name           string            `json:"name,omitempty"`
+	Agent          string            `json:"agent,omitempty"`
+	SessionMode    string            `json:"session_mode,omitempty"`
+	BranchName     string            `json:"branch_name,omitempty"`
+	CheckoutBranch string            `json:"checkout_branch,omitempty"`
+	WorkDir        string            `json:"work_dir,omitempty"`
+	StartedAt      time.Time         `json:"started_at"`
+	EndedAt        *time.Time        `json:"ended_at,omitempty"`
+	KeptAt         *time.Time        `json:"kept_at,omitempty"`
+	StartCommit    string            `json:"start_commit,omitempty"`
+	Changes        *RecordingChanges `json:"changes,omitempty"`
+	// Integrity is checked by GET /api/recording/{uuid}/verify
+	// (recording_integrity.go).
+	Integrity *RecordingIntegrity `json:"integrity,omitempty"`
+}
+
+// handleRecordingDetails handles GET /api/recording/{uuid}.
+func handleRecordingDetails(w http.ResponseWriter, r *http.Request, recordingUUID string) {
+	meta, err := readRecordingMetadata(recordingUUID)
+	if errors.Is(err, errRecordingNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recordingDetails{
+		UUID:           recordingUUID,
+		Name:           meta.Name,
+		Agent:          meta.Agent,
+		SessionMode:    meta.SessionMode,
+		BranchName:     meta.BranchName,
+		CheckoutBranch: meta.CheckoutBranch,
+		WorkDir:        meta.WorkDir,
+		StartedAt:      meta.StartedAt,
+		EndedAt:        meta.EndedAt,
+		KeptAt:         meta.KeptAt,
+		StartCommit:    meta.StartCommit,
+		Changes:        meta.Changes,
+		Integrity:      meta.Integrity,
+	})
+}
+
+// recordingChangesPanelStyle styles the playback page's "Files changed" panel.
+const recordingChangesPanelStyle = `<style>
+#recording-changes-panel { position: fixed; right: 12px; bottom: 12px; z-index: 20; max-width: min(640px, 90vw); max-height: 60vh; overflow: auto; background: rgba(30,30,30,0.95); color: #d4d4d4; border: 1px solid #444; border-radius: 6px; font: 12px/1.4 Monaco, Menlo, Consolas, monospace; }
+#recording-changes-panel summary { cursor: pointer; padding: 6px 10px; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; }
+#recording-changes-panel pre { margin: 0; padding: 0 10px 10px; white-space: pre; }
+</style>`
+
+// recordingChangesPanel renders the playback page's data block, and the panel
+// when any file changed; "" when the recording has no snapshot.
+func recordingChangesPanel(c *RecordingChanges) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	// "</" cannot appear in JSON from encoding/json ("<" is escaped), so the
+	// data block cannot end the script early.
+	block := `<script id="recording-changes" type="application/json">` + string(data) + `</script>`
+	if len(c.Files) == 0 {
+		return block
+	}
+	var body strings.Builder
+	body.WriteString(c.DiffStat)
+	var untracked []string
+	for _, f := range c.Files {
+		if f.Status == "??" {
+			untracked = append(untracked, " "+f.Path)
+		}
+	}
+	if len(untracked) > 0 {
+		body.WriteString("\nUntracked:\n" + strings.Join(untracked, "\n") + "\n")
+	}
+	if c.Truncated > 0 {
+		body.WriteString("\n... and " + strconv.Itoa(c.Truncated) + " more files\n")
+	}
+	summary := strconv.Itoa(len(c.Files)+c.Truncated) + " files changed"
+	if len(c.Files)+c.Truncated == 1 {
+		summary = "1 file changed"
+	}
+	summary += ", +" + strconv.Itoa(c.Insertions) + " -" + strconv.Itoa(c.Deletions)
+	if c.Commits > 0 {
+		summary += ", " + strconv.Itoa(c.Commits) + " commits"
+	}
+	return block + recordingChangesPanelStyle +
+		`<details id="recording-changes-panel"><summary>` + html.EscapeString(summary) + `</summary><pre>` +
+		html.EscapeString(body.String()) + `</pre></details>`
+}
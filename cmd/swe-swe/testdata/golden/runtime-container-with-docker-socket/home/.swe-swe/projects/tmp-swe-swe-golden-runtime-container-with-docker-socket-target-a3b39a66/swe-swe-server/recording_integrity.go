@@ -0,0 +1,179 @@
+// recording_integrity.go -- SHA-256 hashes that show a recording was not
+// changed after its session ended.
+//
+// A kept recording can be the evidence of what an agent did, e.g. for an
+// audit. When a session ends and its recorder has closed the files,
+// saveMetadata hashes the recording's output, timing and input files into
+// RecordingMetadata.Integrity, and logs the hashes so the server log holds a
+// copy outside the recordings dir:
+//
+//	"integrity": {"algorithm": "sha256", "hashed_at": "...",
+//	              "log": "9f86d0...", "timing": "...", "input": "..."}
+//
+// The .log hash is of its content: the file is gzip-compressed later
+// (compressionWorker) and a .log.gz is hashed decompressed, so compressing a
+// recording does not break it. GET /api/recording/{uuid}/verify hashes the
+// files again and compares:
+//
+//	{"uuid": "...", "status": "ok", "hashed_at": "...",
+//	 "files": [{"file": "log", "sha256": "9f86d0...", "ok": true}, ...]}
+//
+// status is "ok" when every hashed file matches, "mismatch" when one changed
+// or is gone (its entry has "actual" or "error"), and "unhashed" for a
+// recording that is still running or ended before hashing existed. The
+// metadata file itself is not hashed: keeping or renaming a recording
+// rewrites it.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// recordingHashedFiles are the recording files Integrity covers, by
+// extension.
+var recordingHashedFiles = []string{"log", "timing", "input"}
+
+// RecordingIntegrity is the hashes of a recording's files, taken when its
+// session ended. A file that did not exist has no hash.
+type RecordingIntegrity struct {
+	Algorithm string    `json:"algorithm"`
+	HashedAt  time.Time `json:"hashed_at"`
+	Log       string    `json:"log,omitempty"`
+	Timing    string    `json:"timing,omitempty"`
+	Input     string    `json:"input,omitempty"`
+}
+
+// sum is the stored hash of the file with extension ext.
+func (in *RecordingIntegrity) sum(ext string) *string {
+	switch ext {
+	case "log":
+		return &in.Log
+	case "timing":
+		return &in.Timing
+	default:
+		return &in.Input
+	}
+}
+
+// hashRecordingFile hashes {prefix}.{ext}; the log decompressed, whether or
+// not it has been compressed yet.
+func hashRecordingFile(prefix, ext string) (string, error) {
+	var f io.ReadCloser
+	var err error
+	if ext == "log" {
+		path := resolveLogPath(prefix)
+		if path == "" {
+			return "", os.ErrNotExist
+		}
+		f, err = openLogReader(path)
+	} else {
+		f, err = os.Open(recordingPath(prefix + "." + ext))
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashRecording hashes the files of the recording with prefix, or returns
+// nil when it has none.
+func hashRecording(prefix string) *RecordingIntegrity {
+	in := &RecordingIntegrity{Algorithm: "sha256", HashedAt: time.Now()}
+	hashed := false
+	for _, ext := range recordingHashedFiles {
+		sum, err := hashRecordingFile(prefix, ext)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Recording %s: failed to hash .%s: %v", prefix, ext, err)
+			}
+			continue
+		}
+		*in.sum(ext) = sum
+		hashed = true
+	}
+	if !hashed {
+		return nil
+	}
+	log.Printf("Recording %s sha256: log=%s timing=%s input=%s", prefix, in.Log, in.Timing, in.Input)
+	return in
+}
+
+// recordingFileCheck is one file's result in a recordingVerification.
+type recordingFileCheck struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`           // as hashed when the session ended
+	Actual string `json:"actual,omitempty"` // now, when it differs
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"` // why it could not be hashed now
+}
+
+// recordingVerification is the GET /api/recording/{uuid}/verify response.
+type recordingVerification struct {
+	UUID     string               `json:"uuid"`
+	Status   string               `json:"status"` // "ok", "mismatch" or "unhashed"
+	HashedAt *time.Time           `json:"hashed_at,omitempty"`
+	Files    []recordingFileCheck `json:"files,omitempty"`
+}
+
+// verifyRecording hashes the files of the recording with prefix again and
+// compares them with in.
+func verifyRecording(prefix string, in *RecordingIntegrity) recordingVerification {
+	if in == nil {
+		return recordingVerification{Status: "unhashed"}
+	}
+	v := recordingVerification{Status: "ok", HashedAt: &in.HashedAt}
+	for _, ext := range recordingHashedFiles {
+		want := *in.sum(ext)
+		if want == "" {
+			continue
+		}
+		check := recordingFileCheck{File: ext, SHA256: want}
+		got, err := hashRecordingFile(prefix, ext)
+		switch {
+		case err != nil:
+			check.Error = err.Error()
+		case got != want:
+			check.Actual = got
+		default:
+			check.OK = true
+		}
+		if !check.OK {
+			v.Status = "mismatch"
+		}
+		v.Files = append(v.Files, check)
+	}
+	return v
+}
+
+// handleRecordingVerify handles GET /api/recording/{uuid}/verify.
+func handleRecordingVerify(w http.ResponseWriter, r *http.Request, recordingUUID string) {
+	meta, err := readRecordingMetadata(recordingUUID)
+	if errors.Is(err, errRecordingNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	v := verifyRecording("session-"+recordingUUID, meta.Integrity)
+	v.UUID = recordingUUID
+	if v.Status == "mismatch" {
+		log.Printf("Recording %s failed verification", recordingUUID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,202 @@
+// repo_status.go -- git status of each repo, for the homepage.
+//
+// Starting an agent on a workspace that is 37 commits behind origin wastes
+// the session, and nothing on the homepage said so. repoStatuses reports,
+// for the workspace and every clone under the repos directory, the current
+// branch, how far it is ahead of and behind its upstream (or origin/{branch}
+// when none is set), how many files are uncommitted, and when the repo was
+// last fetched (the FETCH_HEAD mtime). Nothing is fetched here: behind counts
+// are relative to the last fetch, which is why its time is reported.
+//
+// Each repo's status is cached for repoStatusTTL, so the homepage and
+// GET /api/repos/status can ask as often as they like. The homepage warns
+// about repos that are behind; the API returns every repo:
+//
+//	{"repos": [{"name": "workspace", "path": "/workspace", "branch": "main",
+//	  "upstream": "origin/main", "ahead": 0, "behind": 37, "dirty": 2,
+//	  "lastFetch": "2026-10-14T08:00:00Z", "checkedAt": "..."}]}
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// repoStatusTTL is how long a repo's status is reused.
+const repoStatusTTL = 30 * time.Second
+
+// repoStatusTimeout bounds each git command, so a huge or wedged repo cannot
+// hold up the homepage.
+const repoStatusTimeout = 5 * time.Second
+
+// repoStatus is one repo's summary.
+type repoStatus struct {
+	Name      string     `json:"name"` // "workspace" or the clone's directory
+	Path      string     `json:"path"`
+	Branch    string     `json:"branch,omitempty"` // "HEAD" when detached
+	Upstream  string     `json:"upstream,omitempty"`
+	Ahead     int        `json:"ahead"`
+	Behind    int        `json:"behind"`
+	Dirty     int        `json:"dirty"` // changed and untracked files
+	LastFetch *time.Time `json:"lastFetch,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt time.Time  `json:"checkedAt"`
+}
+
+// Warning is the homepage's line for a repo that is behind, or "".
+func (s repoStatus) Warning() string {
+	if s.Behind == 0 {
+		return ""
+	}
+	commits := "commits"
+	if s.Behind == 1 {
+		commits = "commit"
+	}
+	msg := fmt.Sprintf("%s is %d %s behind %s", s.Name, s.Behind, commits, s.Upstream)
+	if s.LastFetch != nil {
+		msg += " (fetched " + formatTimeAgo(*s.LastFetch) + ")"
+	}
+	return msg
+}
+
+var repoStatusCache = struct {
+	mu      sync.Mutex
+	entries map[string]repoStatus
+}{entries: map[string]repoStatus{}}
+
+// statusRepoPaths lists the repos to report on: the workspace, then each
+// clone under the repos directory, as handleReposAPI finds them.
+func statusRepoPaths() []string {
+	var paths []string
+	if _, err := os.Stat(filepath.Join(workspaceDir, ".git")); err == nil {
+		paths = append(paths, workspaceDir)
+	}
+	entries, _ := os.ReadDir(reposDir)
+	for _, entry := range entries {
+		path := filepath.Join(reposDir, entry.Name(), "workspace")
+		if _, err := os.Stat(filepath.Join(path, ".git")); entry.IsDir() && err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// repoStatuses returns the status of every repo, from the cache when fresh.
+// Stale entries are recomputed in parallel.
+func repoStatuses() []repoStatus {
+	paths := statusRepoPaths()
+	out := make([]repoStatus, len(paths))
+	now := time.Now()
+	var wg sync.WaitGroup
+	repoStatusCache.mu.Lock()
+	for i, path := range paths {
+		if s, ok := repoStatusCache.entries[path]; ok && now.Sub(s.CheckedAt) < repoStatusTTL {
+			out[i] = s
+			continue
+		}
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			out[i] = computeRepoStatus(path)
+		}(i, path)
+	}
+	repoStatusCache.mu.Unlock()
+	wg.Wait()
+
+	repoStatusCache.mu.Lock()
+	entries := make(map[string]repoStatus, len(out))
+	for _, s := range out {
+		entries[s.Path] = s
+	}
+	repoStatusCache.entries = entries // drops repos that are gone
+	repoStatusCache.mu.Unlock()
+	return out
+}
+
+// computeRepoStatus runs git in path.
+func computeRepoStatus(path string) repoStatus {
+	s := repoStatus{Name: "workspace", Path: path, CheckedAt: time.Now()}
+	if path != workspaceDir {
+		s.Name = filepath.Base(filepath.Dir(path))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), repoStatusTimeout)
+	defer cancel()
+	git := func(args ...string) (string, error) {
+		out, err := exec.CommandContext(ctx, "git", append([]string{"-C", path}, args...)...).Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	branch, err := git("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		s.Error = "not a git repository, or it has no commits"
+		return s
+	}
+	s.Branch = branch
+
+	if out, err := git("status", "--porcelain"); err != nil {
+		s.Error = "git status failed"
+	} else if out != "" {
+		s.Dirty = strings.Count(out, "\n") + 1
+	}
+
+	if fetchHead, err := git("rev-parse", "--git-path", "FETCH_HEAD"); err == nil {
+		if !filepath.IsAbs(fetchHead) {
+			fetchHead = filepath.Join(path, fetchHead)
+		}
+		if info, err := os.Stat(fetchHead); err == nil {
+			t := info.ModTime()
+			s.LastFetch = &t
+		}
+	}
+
+	upstream, err := git("rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
+	if err != nil && branch != "HEAD" {
+		if _, err := git("rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch); err == nil {
+			upstream = "origin/" + branch
+		}
+	}
+	if upstream == "" || branch == "HEAD" {
+		return s
+	}
+	s.Upstream = upstream
+	counts, err := git("rev-list", "--left-right", "--count", "HEAD..."+upstream)
+	if err != nil {
+		return s
+	}
+	if ahead, behind, ok := strings.Cut(counts, "\t"); ok {
+		s.Ahead, _ = strconv.Atoi(ahead)
+		s.Behind, _ = strconv.Atoi(behind)
+	}
+	return s
+}
+
+// repoStatusWarnings returns the homepage's warnings, one per repo that is
+// behind its upstream.
+func repoStatusWarnings() []string {
+	var warnings []string
+	for _, s := range repoStatuses() {
+		if w := s.Warning(); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+// handleRepoStatusAPI serves GET /api/repos/status.
+func handleRepoStatusAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"repos": repoStatuses()})
+}
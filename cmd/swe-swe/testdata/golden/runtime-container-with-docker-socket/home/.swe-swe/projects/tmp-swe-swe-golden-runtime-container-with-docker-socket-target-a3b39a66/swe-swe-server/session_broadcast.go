@@ -0,0 +1,362 @@
+// session_broadcast.go -- broadcast mode: a session's terminal, watchable by
+// anyone with the link, for live-coding demos.
+//
+//	POST   /api/session/{uuid}/broadcast  start; {"url", "viewers"}
+//	GET    /api/session/{uuid}/broadcast  {"enabled", "url", "viewers"}
+//	DELETE /api/session/{uuid}/broadcast  stop, disconnecting every viewer
+//	GET    /watch/{token}                 the viewer page
+//	GET    /watch/{token}/events          the output, as server-sent events
+//
+// A WebSocket client costs the session a PTY-size vote, a write per PTY read
+// and input handling. A broadcast viewer costs none of these: it has no input
+// and no size, and its output is coalesced. PTY output is appended to one
+// pending buffer per session (under vtMu, in writeToRing) and flushed every
+// broadcastInterval as a single pre-encoded event, which each viewer's own
+// request goroutine writes out. The agent's read loop never waits on a viewer.
+// A viewer whose queue is full is dropped, and its EventSource reconnects into
+// a fresh snapshot; so is every viewer when more than broadcastMaxPending
+// bytes pile up between flushes.
+//
+// The events are
+//
+//	event: reset   data: {"cols":80,"rows":24,"screen":"<base64 ANSI>"}
+//	event: output  data: <base64 PTY bytes>
+//	event: resize  data: {"cols":120,"rows":40}
+//	event: end     data: {}
+//
+// The token in the link is the credential, so /watch/ and the few static
+// files its page loads skip the login (broadcastPath). Only the owner can
+// start or stop a broadcast; ending the session ends it.
+package main
+
+import (
+	crypto_rand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choonkeat/swe-swe/internal/termsnap"
+)
+
+const (
+	broadcastWatchPrefix = "/watch/"
+	// broadcastInterval is how often coalesced output is sent to viewers.
+	broadcastInterval = 200 * time.Millisecond
+	// broadcastMaxPending is the most output kept between two flushes;
+	// beyond it viewers are resynced with a snapshot instead.
+	broadcastMaxPending = 256 << 10
+	// broadcastViewerQueue is how many events a viewer may fall behind
+	// before it is dropped.
+	broadcastViewerQueue = 64
+	// broadcastKeepalive keeps idle event streams open through proxies.
+	broadcastKeepalive = 30 * time.Second
+)
+
+var broadcastWatchTemplate *template.Template
+
+// broadcastPublicAssets are the static files the watch page loads.
+var broadcastPublicAssets = map[string]bool{
+	"/xterm.js":         true,
+	"/xterm.css":        true,
+	"/styles/theme.css": true,
+}
+
+// broadcastPath reports whether path is a watch page, its event stream, or a
+// static file the page needs. These skip the login.
+func broadcastPath(path string) bool {
+	return strings.HasPrefix(path, broadcastWatchPrefix) || broadcastPublicAssets[path]
+}
+
+// broadcastViewer is one connected watcher.
+type broadcastViewer struct {
+	ch chan []byte // encoded events; closed when the viewer is dropped
+}
+
+// liveBroadcast is a session's broadcast state. The zero value is off and
+// costs writeToRing one lock.
+type liveBroadcast struct {
+	mu         sync.Mutex
+	token      string // "" = not broadcasting
+	viewers    map[*broadcastViewer]bool
+	pending    []byte
+	overflow   bool // pending was dropped; resync viewers
+	scheduled  bool // a flush is scheduled
+	cols, rows int  // size last sent
+}
+
+// noteBroadcast queues PTY output for the viewers. Called from writeToRing, with
+// vtMu held.
+func (s *Session) noteBroadcast(data []byte) {
+	b := &s.live
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.viewers) == 0 {
+		return
+	}
+	if len(b.pending)+len(data) > broadcastMaxPending {
+		b.pending, b.overflow = nil, true
+	} else if !b.overflow {
+		b.pending = append(b.pending, data...)
+	}
+	if !b.scheduled {
+		b.scheduled = true
+		time.AfterFunc(broadcastInterval, s.flushBroadcast)
+	}
+}
+
+// flushBroadcast sends the output queued since the last flush.
+func (s *Session) flushBroadcast() {
+	s.vtMu.Lock()
+	defer s.vtMu.Unlock()
+	b := &s.live
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.scheduled = false
+	if b.overflow {
+		b.overflow = false
+		for v := range b.viewers {
+			b.dropLocked(v)
+		}
+		return
+	}
+	var events []byte
+	if cols, rows := s.vt.Size(); cols != b.cols || rows != b.rows {
+		b.cols, b.rows = cols, rows
+		events = append(events, sseEvent("resize", fmt.Sprintf(`{"cols":%d,"rows":%d}`, cols, rows))...)
+	}
+	if len(b.pending) > 0 {
+		events = append(events, sseEvent("output", base64.StdEncoding.EncodeToString(b.pending))...)
+		b.pending = b.pending[:0]
+	}
+	if len(events) > 0 {
+		b.sendLocked(events)
+	}
+}
+
+// sendLocked queues an event for every viewer, dropping those that are too
+// far behind. Call with b.mu held.
+func (b *liveBroadcast) sendLocked(event []byte) {
+	for v := range b.viewers {
+		select {
+		case v.ch <- event:
+		default:
+			b.dropLocked(v)
+		}
+	}
+}
+
+// dropLocked disconnects v. Call with b.mu held.
+func (b *liveBroadcast) dropLocked(v *broadcastViewer) {
+	if b.viewers[v] {
+		delete(b.viewers, v)
+		close(v.ch)
+	}
+}
+
+// startBroadcast turns broadcasting on and returns its token. Idempotent:
+// a broadcast already running keeps its link.
+func (s *Session) startBroadcast() string {
+	b := &s.live
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token == "" {
+		buf := make([]byte, 16)
+		crypto_rand.Read(buf)
+		b.token = hex.EncodeToString(buf)
+	}
+	return b.token
+}
+
+// stopBroadcast turns broadcasting off, telling every viewer it ended.
+func (s *Session) stopBroadcast() {
+	b := &s.live
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.token = ""
+	b.sendLocked(sseEvent("end", "{}"))
+	for v := range b.viewers {
+		b.dropLocked(v)
+	}
+	b.pending = nil
+}
+
+// broadcastStatus returns the broadcast token ("" when off) and the number
+// of viewers.
+func (s *Session) broadcastStatus() (string, int) {
+	b := &s.live
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.token, len(b.viewers)
+}
+
+// addBroadcastViewer subscribes a viewer and returns it with its first event,
+// a snapshot of the screen. nil when token is not this session's broadcast.
+func (s *Session) addBroadcastViewer(token string) (*broadcastViewer, []byte) {
+	// vtMu first, as in flushBroadcast: the snapshot and the subscription
+	// happen between two PTY writes, so the viewer misses and repeats nothing.
+	s.vtMu.Lock()
+	defer s.vtMu.Unlock()
+	b := &s.live
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token == "" || b.token != token {
+		return nil, nil
+	}
+	if len(b.pending) > 0 {
+		// Existing viewers get what the snapshot already shows.
+		b.sendLocked(sseEvent("output", base64.StdEncoding.EncodeToString(b.pending)))
+		b.pending = b.pending[:0]
+	}
+	cols, rows := s.vt.Size()
+	if len(b.viewers) == 0 {
+		b.cols, b.rows = cols, rows
+	}
+	reset, _ := json.Marshal(map[string]any{"cols": cols, "rows": rows, "screen": termsnap.ANSI(s.vt)})
+	v := &broadcastViewer{ch: make(chan []byte, broadcastViewerQueue)}
+	if b.viewers == nil {
+		b.viewers = make(map[*broadcastViewer]bool)
+	}
+	b.viewers[v] = true
+	return v, sseEvent("reset", string(reset))
+}
+
+// removeBroadcastViewer unsubscribes v, if it is still subscribed.
+func (s *Session) removeBroadcastViewer(v *broadcastViewer) {
+	s.live.mu.Lock()
+	defer s.live.mu.Unlock()
+	s.live.dropLocked(v)
+}
+
+// sseEvent encodes one server-sent event. data must be a single line.
+func sseEvent(name, data string) []byte {
+	return []byte("event: " + name + "\ndata: " + data + "\n\n")
+}
+
+// broadcastSession returns the session broadcasting under token.
+func broadcastSession(token string) *Session {
+	if token == "" {
+		return nil
+	}
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, sess := range sessions {
+		if t, _ := sess.broadcastStatus(); t == token {
+			return sess
+		}
+	}
+	return nil
+}
+
+// handleSessionBroadcastAPI serves /api/session/{uuid}/broadcast. Owner-only,
+// like share links.
+func handleSessionBroadcastAPI(w http.ResponseWriter, r *http.Request) {
+	if requestCookieScope(r) != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	sessionUUID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/broadcast")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		sess.startBroadcast()
+		log.Printf("Session %s: broadcast started", sess.UUID)
+	case http.MethodDelete:
+		sess.stopBroadcast()
+		log.Printf("Session %s: broadcast stopped", sess.UUID)
+	case http.MethodGet:
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, viewers := sess.broadcastStatus()
+	resp := map[string]any{"enabled": token != "", "viewers": viewers}
+	if token != "" {
+		resp["url"] = requestBaseURL(r) + broadcastWatchPrefix + token
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleBroadcastWatch serves GET /watch/{token} and /watch/{token}/events.
+func handleBroadcastWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, events, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, broadcastWatchPrefix), "/")
+	sess := broadcastSession(token)
+	if sess == nil || (events != "" && events != "events") {
+		http.Error(w, "This broadcast has ended or the link is wrong.", http.StatusNotFound)
+		return
+	}
+	if events == "events" {
+		serveBroadcastEvents(w, r, sess, token)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	data := struct {
+		Name      string
+		EventsURL string
+	}{sess.Name, broadcastWatchPrefix + token + "/events"}
+	if err := broadcastWatchTemplate.Execute(w, data); err != nil {
+		log.Printf("watch page render error: %v", err)
+	}
+}
+
+// serveBroadcastEvents streams the broadcast to one viewer until it ends,
+// the viewer leaves, or it falls behind.
+func serveBroadcastEvents(w http.ResponseWriter, r *http.Request, sess *Session, token string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	v, reset := sess.addBroadcastViewer(token)
+	if v == nil {
+		http.Error(w, "This broadcast has ended.", http.StatusNotFound)
+		return
+	}
+	defer sess.removeBroadcastViewer(v)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Write(reset)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(broadcastKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-v.ch:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -8,7 +8,8 @@
 // auth gate (authMiddleware) and the per-port proxies (requireAuthCookie).
 //
 // The share password lives on Session.SharePassword (in-memory), so ending the
-// session revokes the share. There is no persistence and no separate revoke.
+// session revokes the share. There is no persistence and no separate revoke;
+// share links (session_share_links.go) are the revocable, expiring kind.
 package main
 
 import (
@@ -118,6 +119,9 @@ func sessionUUIDFromPath(path string) (string, bool) {
 		return firstPathSegment(path[len("/ws/"):]), true
 	case strings.HasPrefix(path, "/proxy/"):
 		return firstPathSegment(path[len("/proxy/"):]), true
+	case strings.HasPrefix(path, "/preview/"):
+		return firstPathSegment(path[len("/preview/"):]), true
+
 	case strings.HasPrefix(path, "/api/session/"):
 		return firstPathSegment(path[len("/api/session/"):]), true
 	}
@@ -133,6 +137,10 @@ func sessionUUIDFromPath(path string) (string, bool) {
 // sessions, browse repos/worktrees, or replay a recording is rejected. Only
 // the guest's own session paths and UUID-less assets are allowed.
 func scopedRequestAllowed(scope string, r *http.Request) bool {
+	// A session preview subdomain (preview_domain.go) is all one session's.
+	if uuid, ok := previewDomainSession(r.Host); ok {
+		return uuid == scope
+	}
 	return scopedPathAllowed(scope, r.URL.Path)
 }
 
@@ -145,24 +153,42 @@ func scopedRequestAllowed(scope string, r *http.Request) bool {
 // Traefik dashboard is NOT a swe-swe-server path -- scopedVerifyAllowed denies
 // it before delegating here.)
 func scopedPathAllowed(scope, path string) bool {
-	// Never for a guest: recordings (any), session spawn/fork, the
-	// repo/worktree management APIs (which enumerate or create other work),
-	// and server shutdown.
+	// Never for a guest: recordings (any; embeds only by share token, below)
+	// and session exports,
+	// session spawn/fork/deep links, the repo/worktree management APIs (which enumerate
+	// or create other work), and the server-wide admin endpoints
+	// (adminOnlyPath, auth_roles.go).
 	switch {
 	case strings.HasPrefix(path, "/recording/"),
 		strings.HasPrefix(path, "/api/recording/"),
 		path == "/api/session/new",
+		strings.HasPrefix(path, "/api/session/") && strings.HasSuffix(path, "/export"),
+		path == "/api/session/name-check",
+		path == "/api/session/auth-check",
+		path == deepLinkPath,
 		strings.HasPrefix(path, "/api/fork/"),
 		path == "/api/worktrees",
-		path == "/api/worktree/check",
+		path == "/api/worktrees/conflicts",
+		strings.HasPrefix(path, "/api/worktree/"),
+		path == "/api/branch/preview",
 		path == "/api/repos",
+		path == "/api/repos/status",
+		path == "/api/repos/fetch",
 		path == "/api/repo/prepare",
 		path == "/api/repo/branches",
-		path == "/api/server/shutdown",
-		path == "/api/server/reboot":
+		path == "/api/repo/env",
+		path == "/api/repo/template-diff",
+		path == "/api/templates/update",
+		adminOnlyPath(path):
 		return false
 	}
 
+	// Recording embeds: a share token is public anyway, but a bare recording
+	// UUID would render that recording's page for the guest.
+	if id, ok := strings.CutPrefix(path, embedRecordingPrefix); ok {
+		return isRecordingShareToken(id)
+	}
+
 	// UUID-bearing session paths (/session, /ws, /proxy, /api/session): allow
 	// only the guest's own session.
 	if uuid, ok := sessionUUIDFromPath(path); ok {
@@ -228,7 +254,8 @@ func scopedHomeTarget(scope string) (target string, ok bool) {
 }
 
 // handleSessionShareAPI handles POST /api/session/{uuid}/share. It returns the
-// guest login link and share password for the session as JSON. Owner-only: a
+// guest login link and share password for the session as JSON, or with a JSON
+// body creates a share link (session_share_links.go). Owner-only: a
 // request carrying a scoped (guest) cookie is forbidden, so a guest cannot mint
 // further shares even for their own session.
 func handleSessionShareAPI(w http.ResponseWriter, r *http.Request) {
@@ -258,6 +285,17 @@ func handleSessionShareAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A JSON body asks for a scoped, expiring share link instead.
+	body, err := readShareBody(r)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		handleSessionShareLinkCreate(w, r, sess, body)
+		return
+	}
+
 	// NOTE: never log the share password -- it is a live credential.
 	resp := map[string]string{
 		"url":      buildShareURL(r, sess),
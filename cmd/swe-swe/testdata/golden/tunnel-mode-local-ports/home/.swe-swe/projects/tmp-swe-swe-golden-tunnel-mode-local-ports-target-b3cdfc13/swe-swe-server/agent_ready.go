@@ -0,0 +1,121 @@
+// agent_ready.go -- knowing when an agent can take its first prompt.
+//
+// A prompt typed before the agent has drawn its input box is swallowed or
+// garbled; one typed long after feels slow. So each assistant has a
+// ReadyPattern, a regexp that matches its screen once it takes input --
+// Claude's "? for shortcuts", Codex's "context left" -- and
+// waitForAgentReady returns as soon as it matches, plus agentReadySettle for
+// the agent to finish drawing.
+//
+// An assistant with no pattern gets the old rule: its output must stay quiet
+// for agentQuietSettle. One whose pattern has not matched (a new version
+// changed its screen) is typed into once its output has been quiet for
+// agentReadyFallback, and a warning is logged.
+//
+// SWE_READY_PATTERN_<BINARY> replaces an assistant's pattern, e.g.
+// SWE_READY_PATTERN_CLAUDE, or SWE_READY_PATTERN_CUSTOM for a -shell agent;
+// "none" removes it.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/choonkeat/swe-swe/internal/termsnap"
+)
+
+const (
+	// agentQuietSettle is how long output must stay quiet when there is no
+	// ready pattern.
+	agentQuietSettle = 1500 * time.Millisecond
+	// agentReadyFallback is how long output must stay quiet when the ready
+	// pattern has not matched.
+	agentReadyFallback = 10 * time.Second
+	// agentReadySettle lets the agent finish drawing after the match.
+	agentReadySettle = 250 * time.Millisecond
+)
+
+// readyPatterns are the compiled ready patterns by assistant binary.
+var readyPatterns map[string]*regexp.Regexp
+
+// loadReadyPatterns compiles the assistants' ready patterns and the
+// SWE_READY_PATTERN_* overrides.
+func loadReadyPatterns() error {
+	patterns, err := loadAgentPatterns("ready", "SWE_READY_PATTERN_", func(a AssistantConfig) string { return a.ReadyPattern })
+	if err != nil {
+		return err
+	}
+	readyPatterns = patterns
+	return nil
+}
+
+// loadAgentPatterns compiles one regexp per assistant binary: the built-in
+// one from AssistantConfig, replaced by the env var envPrefix+BINARY.
+func loadAgentPatterns(kind, envPrefix string, builtin func(AssistantConfig) string) (map[string]*regexp.Regexp, error) {
+	sources := map[string]string{}
+	for _, a := range assistantConfigs {
+		if src := builtin(a); src != "" {
+			sources[a.Binary] = src
+		}
+	}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		binary, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || binary == "" {
+			continue
+		}
+		sources[strings.ToLower(binary)] = value
+	}
+	patterns := map[string]*regexp.Regexp{}
+	for binary, src := range sources {
+		if src == "" || src == "none" {
+			continue
+		}
+		re, err := regexp.Compile(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s pattern for %s: %w", kind, binary, err)
+		}
+		patterns[binary] = re
+	}
+	return patterns, nil
+}
+
+// waitForAgentReady returns once the agent looks ready for input, or after
+// maxWait. It reports whether the ready pattern matched.
+func (s *Session) waitForAgentReady(maxWait time.Duration) bool {
+	const poll = 100 * time.Millisecond
+	re := readyPatterns[s.Assistant]
+	settle := agentQuietSettle
+	if re != nil {
+		settle = agentReadyFallback
+	}
+	deadline := time.Now().Add(maxWait)
+	lastTotal := int64(-1)
+	quietSince := time.Now()
+	for time.Now().Before(deadline) && !s.isEnding() {
+		s.vtMu.Lock()
+		total, n := s.ring.Total(), s.ring.Len()
+		changed := total != lastTotal
+		ready := changed && n > 0 && re != nil && re.MatchString(termsnap.Text(s.vt))
+		s.vtMu.Unlock()
+		switch {
+		case ready:
+			time.Sleep(agentReadySettle)
+			return true
+		case changed:
+			lastTotal = total
+			quietSince = time.Now()
+		case n > 0 && time.Since(quietSince) >= settle:
+			if re != nil {
+				log.Printf("Session %s: %s ready pattern %q never matched; typing after %v of quiet", s.UUID, s.Assistant, re, settle)
+			}
+			return false
+		}
+		time.Sleep(poll)
+	}
+	return false
+}
@@ -0,0 +1,390 @@
+// recording_share.go -- public, unlisted links to a recording's playback.
+//
+// A recording share lets someone without a login watch one recording, e.g.
+// a run embedded in a GitHub issue, without opening anything else on the
+// server. The link is
+//
+//	/swe-swe-auth/recording/{uuid}.{id}.{sig}
+//
+// where SIG is an HMAC (keyed by SWE_SWE_PASSWORD, like session share links)
+// over the recording, share ID, expiry and download flag. Under that token
+// the server answers exactly three things: the playback page, the raw
+// session.log it streams, and -- only if the share allows it -- the same log
+// as a file download. No homepage, no APIs, no other recordings, no cookie.
+//
+// The links live under /swe-swe-auth/ because that prefix is already
+// reachable without a login in both deployments (authMiddleware here, the
+// un-forward-authed Traefik router in compose mode).
+//
+// Shares are kept next to the recording in session-{uuid}.shares.json so
+// they survive restarts, can be listed and revoked, and go away when the
+// recording is deleted. Changing SWE_SWE_PASSWORD invalidates them all.
+// Expiry is optional. Managing them is owner-only (/api/recording/ is off
+// limits to shared-session guests):
+//
+//	POST   /api/recording/{uuid}/share       {"expiresIn": 604800, "allowDownload": false, "label": "..."}
+//	GET    /api/recording/{uuid}/shares      -> {"shares": [...]}
+//	DELETE /api/recording/{uuid}/shares/{id}
+//
+// "Download disabled" removes the download link and endpoint; the player
+// still has to fetch the log to replay it, so a determined viewer can save it.
+package main
+
+import (
+	"crypto/hmac"
+	crypto_rand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	recordtui "github.com/choonkeat/record-tui/playback"
+	"github.com/google/uuid"
+)
+
+const (
+	// recordingShareMaxTTL caps an explicit expiry; 0 means no expiry.
+	recordingShareMaxTTL = 365 * 24 * time.Hour
+	// recordingShareMaxPerRecording bounds how many links one recording holds.
+	recordingShareMaxPerRecording = 20
+	recordingShareMaxLabel        = 100
+
+	recordingSharePrefix = "/swe-swe-auth/recording/"
+)
+
+// recordingShare is one unlisted link to a recording.
+type recordingShare struct {
+	ID            string     `json:"id"`
+	Label         string     `json:"label,omitempty"`
+	AllowDownload bool       `json:"allowDownload"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+}
+
+// recordingShareView is a share as the owner's API returns it.
+type recordingShareView struct {
+	recordingShare
+	URL      string `json:"url"`
+	EmbedURL string `json:"embedUrl"`
+}
+
+func (s recordingShare) expired() bool {
+	return s.ExpiresAt != nil && !time.Now().Before(*s.ExpiresAt)
+}
+
+// recordingSharesMu serializes read-modify-write of the shares files.
+var recordingSharesMu sync.Mutex
+
+func recordingSharesPath(recordingUUID string) string {
+	return recordingDir(recordingUUID) + "/session-" + recordingUUID + ".shares.json"
+}
+
+// loadRecordingShares returns the recording's unexpired shares.
+func loadRecordingShares(recordingUUID string) ([]recordingShare, error) {
+	data, err := os.ReadFile(recordingSharesPath(recordingUUID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all []recordingShare
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	live := all[:0]
+	for _, s := range all {
+		if !s.expired() {
+			live = append(live, s)
+		}
+	}
+	return live, nil
+}
+
+// saveRecordingShares writes the shares file atomically, removing it when
+// there is nothing left to keep.
+func saveRecordingShares(recordingUUID string, shares []recordingShare) error {
+	path := recordingSharesPath(recordingUUID)
+	if len(shares) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(shares, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// createRecordingShare adds a share to an existing recording. ttl 0 means
+// the link never expires.
+func createRecordingShare(recordingUUID, label string, ttl time.Duration, allowDownload bool) (recordingShare, error) {
+	label = strings.TrimSpace(label)
+	switch {
+	case ttl < 0 || ttl > recordingShareMaxTTL:
+		return recordingShare{}, fmt.Errorf("expiresIn must be between 0 (never) and %d seconds", int64(recordingShareMaxTTL/time.Second))
+	case len(label) > recordingShareMaxLabel:
+		return recordingShare{}, fmt.Errorf("label too long (max %d characters)", recordingShareMaxLabel)
+	}
+	b := make([]byte, 8)
+	if _, err := crypto_rand.Read(b); err != nil {
+		return recordingShare{}, err
+	}
+	s := recordingShare{ID: hex.EncodeToString(b), Label: label, AllowDownload: allowDownload, CreatedAt: time.Now()}
+	if ttl > 0 {
+		exp := s.CreatedAt.Add(ttl)
+		s.ExpiresAt = &exp
+	}
+
+	recordingSharesMu.Lock()
+	defer recordingSharesMu.Unlock()
+	shares, err := loadRecordingShares(recordingUUID)
+	if err != nil {
+		return recordingShare{}, err
+	}
+	if len(shares) >= recordingShareMaxPerRecording {
+		return recordingShare{}, fmt.Errorf("too many share links for this recording (max %d); revoke one first", recordingShareMaxPerRecording)
+	}
+	if err := saveRecordingShares(recordingUUID, append(shares, s)); err != nil {
+		return recordingShare{}, err
+	}
+	return s, nil
+}
+
+// revokeRecordingShare deletes a share; false if there was none.
+func revokeRecordingShare(recordingUUID, id string) (bool, error) {
+	recordingSharesMu.Lock()
+	defer recordingSharesMu.Unlock()
+	shares, err := loadRecordingShares(recordingUUID)
+	if err != nil {
+		return false, err
+	}
+	for i, s := range shares {
+		if s.ID == id {
+			return true, saveRecordingShares(recordingUUID, append(shares[:i], shares[i+1:]...))
+		}
+	}
+	return false, nil
+}
+
+// recordingShareToken is the link's credential: the recording, the share ID
+// and an HMAC binding them to the expiry and download flag.
+func recordingShareToken(secret, recordingUUID string, s recordingShare) string {
+	var exp int64
+	if s.ExpiresAt != nil {
+		exp = s.ExpiresAt.Unix()
+	}
+	signed := strings.Join([]string{"recording-share", recordingUUID, s.ID, strconv.FormatInt(exp, 10), strconv.FormatBool(s.AllowDownload)}, authCookieDelimiter)
+	return recordingUUID + "." + s.ID + "." + authComputeHMAC(signed, secret)
+}
+
+// verifyRecordingShareToken returns the recording and live share a token
+// names.
+func verifyRecordingShareToken(secret, token string) (string, recordingShare, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", recordingShare{}, false
+	}
+	recordingUUID, id := parts[0], parts[1]
+	if u, err := uuid.Parse(recordingUUID); err != nil || u.String() != recordingUUID {
+		return "", recordingShare{}, false
+	}
+	shares, err := loadRecordingShares(recordingUUID)
+	if err != nil {
+		log.Printf("Recording %s: cannot read shares: %v", recordingUUID, err)
+		return "", recordingShare{}, false
+	}
+	for _, s := range shares {
+		if s.ID == id && hmac.Equal([]byte(token), []byte(recordingShareToken(secret, recordingUUID, s))) {
+			return recordingUUID, s, true
+		}
+	}
+	return "", recordingShare{}, false
+}
+
+// newRecordingShareView pairs a share with its page and embed URLs.
+func newRecordingShareView(r *http.Request, secret, recordingUUID string, s recordingShare) recordingShareView {
+	token := recordingShareToken(secret, recordingUUID, s)
+	return recordingShareView{
+		recordingShare: s,
+		URL:            requestBaseURL(r) + recordingSharePrefix + token,
+		EmbedURL:       requestBaseURL(r) + embedRecordingPrefix + token,
+	}
+}
+
+// recordingShareHandler serves /swe-swe-auth/recording/{token}[/session.log|/download]
+// to anyone holding a valid token. Bad tokens count against the login rate
+// limit.
+func recordingShareHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, recordingSharePrefix), "/")
+		recordingUUID, share, ok := checkRecordingShareToken(w, r, secret, token)
+		if !ok {
+			return
+		}
+
+		switch action {
+		case "":
+			serveSharedRecordingPage(w, r, token, recordingUUID, share)
+		case "session.log":
+			handleRecordingSessionLog(w, r, recordingUUID)
+		case "download":
+			if !share.AllowDownload {
+				http.Error(w, "Download is disabled for this link", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Disposition", `attachment; filename="session-`+recordingUUID+`.log"`)
+			handleRecordingSessionLog(w, r, recordingUUID)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// checkRecordingShareToken verifies a share token for a public request. On
+// failure it writes the response itself and counts the attempt against the
+// login rate limit.
+func checkRecordingShareToken(w http.ResponseWriter, r *http.Request, secret, token string) (string, recordingShare, bool) {
+	clientKey := loginThrottleKey(r)
+	if !authLoginLimiter.allow(clientKey) || !authGlobalLimiter.allow(authGlobalRateLimitMax) {
+		http.Error(w, "Too many attempts. Please wait a few minutes.", http.StatusTooManyRequests)
+		return "", recordingShare{}, false
+	}
+	recordingUUID, share, ok := verifyRecordingShareToken(secret, token)
+	if !ok || secret == "" {
+		authLoginLimiter.record(clientKey)
+		authGlobalLimiter.record()
+		http.Error(w, "This link is invalid, has expired, or was revoked.", http.StatusNotFound)
+		return "", recordingShare{}, false
+	}
+
+	// Unlisted: keep the token out of search indexes and Referer headers.
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	return recordingUUID, share, true
+}
+
+// serveSharedRecordingPage renders the normal playback page with its data
+// URL pointed under the token, plus a download link when allowed.
+func serveSharedRecordingPage(w http.ResponseWriter, r *http.Request, token, recordingUUID string, share recordingShare) {
+	logPath := resolveLogPath("session-" + recordingUUID)
+	if logPath == "" {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	opts := recordingPlaybackOptions(r, recordingUUID, logPath)
+	opts.DataURL = token + "/session.log"
+	page, err := recordtui.RenderStreamingHTML(opts)
+	if err != nil {
+		http.Error(w, "Failed to render playback", http.StatusInternalServerError)
+		return
+	}
+	if share.AllowDownload {
+		page = strings.Replace(page, `<div id="footer">`,
+			`<div id="footer">`+"\n    "+`<a href="`+token+`/download">download</a> |`, 1)
+	}
+	page = strings.Replace(page, "</head>", oembedDiscoveryLink(r, r.URL.Path)+"\n</head>", 1)
+	// Embedding goes through /embed/recording/{token}; the full page is not
+	// meant to be framed.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// handleRecordingShareCreate is POST /api/recording/{uuid}/share.
+func handleRecordingShareCreate(w http.ResponseWriter, r *http.Request, recordingUUID string) {
+	var req struct {
+		ExpiresIn     int64  `json:"expiresIn"` // seconds; 0 = never
+		AllowDownload bool   `json:"allowDownload"`
+		Label         string `json:"label"`
+	}
+	body, err := readShareBody(r)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	secret := os.Getenv("SWE_SWE_PASSWORD")
+	if secret == "" {
+		http.Error(w, "Share links need the embedded login (SWE_SWE_PASSWORD)", http.StatusConflict)
+		return
+	}
+	if _, err := uuid.Parse(recordingUUID); err != nil || resolveLogPath("session-"+recordingUUID) == "" {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	s, err := createRecordingShare(recordingUUID, req.Label, time.Duration(req.ExpiresIn)*time.Second, req.AllowDownload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Recording %s: created share %s (download %v)", recordingUUID, s.ID, s.AllowDownload)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newRecordingShareView(r, secret, recordingUUID, s))
+}
+
+// handleRecordingSharesAPI handles GET /api/recording/{uuid}/shares (list,
+// with each link's URL) and DELETE /api/recording/{uuid}/shares/{id}.
+func handleRecordingSharesAPI(w http.ResponseWriter, r *http.Request, recordingUUID, shareID string) {
+	if _, err := uuid.Parse(recordingUUID); err != nil {
+		http.Error(w, "Invalid UUID", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && shareID == "":
+		recordingSharesMu.Lock()
+		shares, err := loadRecordingShares(recordingUUID)
+		recordingSharesMu.Unlock()
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		views := []recordingShareView{}
+		secret := os.Getenv("SWE_SWE_PASSWORD")
+		for _, s := range shares {
+			v := recordingShareView{recordingShare: s}
+			if secret != "" {
+				v = newRecordingShareView(r, secret, recordingUUID, s)
+			}
+			views = append(views, v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"shares": views})
+	case r.Method == http.MethodDelete && shareID != "":
+		ok, err := revokeRecordingShare(recordingUUID, shareID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Share not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Recording %s: revoked share %s", recordingUUID, shareID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
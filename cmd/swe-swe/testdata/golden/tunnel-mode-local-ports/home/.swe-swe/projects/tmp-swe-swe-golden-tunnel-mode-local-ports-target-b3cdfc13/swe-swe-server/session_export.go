@@ -0,0 +1,277 @@
+// session_export.go -- GET /api/session/{uuid}/export: a running session as
+// one self-contained HTML file, to attach to a ticket.
+//
+// The recording download (/api/recording/{uuid}/download) is a zip of raw
+// files that needs swe-swe to play, and only exists for a recording; the
+// export is a page that opens straight from disk:
+//
+//   - the terminal output so far, in the static record-tui player, with the
+//     xterm.js it needs inlined instead of loaded from a CDN, and the chapter
+//     table of contents when the session has one;
+//   - the recording metadata (name, agent, owner, start time, visitors...),
+//     as a summary and as a JSON block (id="session-export-metadata");
+//   - the agent-chat history when the session has one, as bubbles and as
+//     the raw events (id="session-export-chat", one JSON event per line).
+//
+// Secrets are masked throughout (secrets.go). A log larger than
+// exportMaxLog is cut to its last exportMaxLog bytes, from a line start, and
+// the page says so; the chapters are left out then, since their line numbers
+// count from the start of the log. Guests of a shared session cannot export
+// it, as they cannot open recordings.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	recordtui "github.com/choonkeat/record-tui/playback"
+)
+
+// exportMaxLog bounds how much of the recording log an export embeds.
+const exportMaxLog = 32 << 20
+
+// exportXtermCDN are the tags the record-tui page loads xterm.js with.
+const (
+	exportXtermCSSTag = `<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/@xterm/xterm@5.5.0/css/xterm.css" />`
+	exportXtermJSTag  = `<script src="https://cdn.jsdelivr.net/npm/@xterm/xterm@5.5.0/lib/xterm.js"></script>`
+)
+
+const exportStyle = `<style>
+#session-export { padding: 16px 24px; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; font-size: 14px; }
+#session-export h2 { font-size: 15px; margin: 16px 0 8px; color: #e0e0e0; }
+#session-export table { border-collapse: collapse; }
+#session-export td { padding: 2px 12px 2px 0; vertical-align: top; }
+#session-export td:first-child { color: #888; }
+#session-export .note { color: #e5c07b; }
+#session-export .bubble { margin: 6px 0; padding: 6px 10px; border-radius: 6px; max-width: 80ch; white-space: pre-wrap; word-wrap: break-word; }
+#session-export .bubble.user { background: #264f78; margin-left: auto; }
+#session-export .bubble.agent { background: #333; }
+#session-export .bubble time { display: block; font-size: 11px; color: #888; }
+</style>`
+
+// exportChatEvent is the part of an agent-chat event the export shows.
+type exportChatEvent struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	TS   string `json:"ts,omitempty"`
+}
+
+// exportLog reads the session's output so far: the recording log, or its
+// last exportMaxLog bytes from a line start (truncated is then true).
+func exportLog(logPath string) (content string, truncated bool, err error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", false, err
+	}
+	start, end := scrollbackBounds(f, info.Size())
+	from := max(start, end-exportMaxLog)
+	buf := make([]byte, end-from)
+	n, _ := f.ReadAt(buf, from)
+	buf = buf[:n]
+	if from > start {
+		truncated = true
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			buf = buf[i+1:]
+		}
+	}
+	return string(buf), truncated, nil
+}
+
+// exportDataBlock renders data as a script block the page does not run.
+// "<" only occurs inside JSON strings, where \u003c means the same, so the
+// block cannot end early.
+func exportDataBlock(id, typ, data string) string {
+	return `<script id="` + id + `" type="` + typ + `">` + strings.ReplaceAll(data, "<", `\u003c`) + `</script>`
+}
+
+// exportChatSection renders agent-chat events (JSON lines) as bubbles.
+func exportChatSection(events string) string {
+	var b strings.Builder
+	b.WriteString(`<h2>Chat</h2>`)
+	shown := 0
+	for _, line := range strings.Split(events, "\n") {
+		var ev exportChatEvent
+		if json.Unmarshal([]byte(line), &ev) != nil || ev.Text == "" {
+			continue
+		}
+		role := ""
+		switch ev.Type {
+		case "userMessage":
+			role = "user"
+		case "agentMessage", "verbalReply":
+			role = "agent"
+		default:
+			continue
+		}
+		b.WriteString(`<div class="bubble ` + role + `">`)
+		if ev.TS != "" {
+			b.WriteString(`<time>` + html.EscapeString(ev.TS) + `</time>`)
+		}
+		b.WriteString(html.EscapeString(ev.Text) + `</div>`)
+		shown++
+	}
+	if shown == 0 {
+		b.WriteString(`<p>No messages yet.</p>`)
+	}
+	return b.String()
+}
+
+// exportMetadataSection renders the recording metadata as a summary table.
+func exportMetadataSection(meta *RecordingMetadata, exportedAt time.Time, truncated bool) string {
+	var b strings.Builder
+	b.WriteString(`<h2>Session</h2>`)
+	if truncated {
+		fmt.Fprintf(&b, `<p class="note">Only the last %d MB of the output are included.</p>`, exportMaxLog>>20)
+	}
+	b.WriteString(`<table>`)
+	row := func(label, value string) {
+		if value != "" {
+			b.WriteString(`<tr><td>` + label + `</td><td>` + html.EscapeString(value) + `</td></tr>`)
+		}
+	}
+	row("Exported", exportedAt.Format(time.RFC3339))
+	if meta != nil {
+		row("Name", meta.Name)
+		row("Agent", meta.Agent)
+		row("Owner", meta.Owner)
+		if !meta.StartedAt.IsZero() {
+			row("Started", meta.StartedAt.Format(time.RFC3339))
+		}
+		if meta.EndedAt != nil {
+			row("Ended", meta.EndedAt.Format(time.RFC3339))
+		}
+		row("Directory", meta.WorkDir)
+		row("Branch", meta.BranchName)
+		row("Command", strings.Join(meta.Command, " "))
+		var visitors []string
+		for _, v := range meta.Visitors {
+			if v.Name != "" && !slices.Contains(visitors, v.Name) {
+				visitors = append(visitors, v.Name)
+			}
+		}
+		row("Visitors", strings.Join(visitors, ", "))
+	}
+	b.WriteString(`</table>`)
+	return b.String()
+}
+
+// renderSessionExport builds the export page of a session whose recording
+// files start with prefix.
+func renderSessionExport(recordingUUID, prefix string, exportedAt time.Time) (string, error) {
+	logPath := recordingPath(prefix + ".log")
+	output, truncated, err := exportLog(logPath)
+	if err != nil {
+		return "", err
+	}
+	output = maskSecrets(recordtui.StripMetadata(output))
+
+	var meta *RecordingMetadata
+	metaJSON := ""
+	if data, err := os.ReadFile(recordingPath(prefix + ".metadata.json")); err == nil {
+		var m RecordingMetadata
+		if json.Unmarshal(data, &m) == nil {
+			meta = &m
+			metaJSON = maskSecrets(string(data))
+		}
+	}
+
+	opts := recordtui.Options{
+		Title: "session-" + recordingUUID[:min(8, len(recordingUUID))],
+		FooterLink: recordtui.FooterLink{
+			Text: "swe-swe",
+			URL:  "https://github.com/choonkeat/swe-swe",
+		},
+	}
+	if meta != nil && meta.Name != "" {
+		opts.Title = meta.Name
+	}
+	if !truncated && prefix == "session-"+recordingUUID {
+		var startedAt time.Time
+		if meta != nil {
+			startedAt = meta.StartedAt
+		}
+		opts.TOC = chapterTOC(recordingUUID, logPath, startedAt)
+		if meta != nil {
+			opts.TOC = mergeTOC(opts.TOC, annotationTOC(recordingUUID, logPath, meta.Annotations))
+		}
+	}
+	page, err := recordtui.RenderHTML([]recordtui.Frame{{Content: output}}, opts)
+	if err != nil {
+		return "", err
+	}
+
+	// Inline xterm.js so the file opens offline.
+	css, err := staticFS.ReadFile("static/xterm.css")
+	if err != nil {
+		return "", err
+	}
+	js, err := staticFS.ReadFile("static/xterm.js")
+	if err != nil {
+		return "", err
+	}
+	page = strings.Replace(page, exportXtermCSSTag, "<style>"+string(css)+"</style>", 1)
+	page = strings.Replace(page, exportXtermJSTag, "<script>"+string(js)+"</script>", 1)
+
+	var extra strings.Builder
+	extra.WriteString(exportStyle + `<div id="session-export">`)
+	extra.WriteString(exportMetadataSection(meta, exportedAt, truncated))
+	if metaJSON != "" {
+		extra.WriteString(exportDataBlock("session-export-metadata", "application/json", metaJSON))
+	}
+	if eventsPath := findChatEventsFile(recordingUUID); eventsPath != "" {
+		if data, err := os.ReadFile(eventsPath); err == nil {
+			events := maskSecrets(string(data))
+			extra.WriteString(exportChatSection(events))
+			extra.WriteString(exportDataBlock("session-export-chat", "application/x-ndjson", events))
+		}
+	}
+	extra.WriteString(`</div>`)
+	if meta != nil {
+		extra.WriteString(recordingChangesPanel(meta.Changes))
+	}
+	return strings.Replace(page, `<div id="footer">`, extra.String()+"\n  "+`<div id="footer">`, 1), nil
+}
+
+// handleSessionExportAPI serves GET /api/session/{uuid}/export.
+func handleSessionExportAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionUUID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/export")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	page, err := renderSessionExport(sess.RecordingUUID, sess.RecordingPrefix, now)
+	if os.IsNotExist(err) {
+		http.Error(w, "Session has no recording", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%s-%s.html"`,
+		sessionUUID[:min(8, len(sessionUUID))], now.UTC().Format("20060102-150405")))
+	w.Write([]byte(page))
+}
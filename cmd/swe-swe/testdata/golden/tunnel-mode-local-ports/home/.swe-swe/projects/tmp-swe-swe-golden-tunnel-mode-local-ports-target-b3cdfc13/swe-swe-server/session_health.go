@@ -0,0 +1,221 @@
+// session_health.go -- server-side checks that show a stuck session.
+//
+// A homepage card with only a viewer count and a duration looks the same
+// whether the agent is working, died, or filled the disk. checkHealth looks
+// at:
+//
+//   - process: the agent process is "running", "zombie" (exited, not yet
+//     reaped) or "gone", from /proc/<pid>/stat, or "hibernated"
+//     (session_hibernate.go). Without /proc (macOS) a zombie counts as
+//     running;
+//   - lastOutputAge: seconds since the terminal last wrote output;
+//   - cpuPercent: CPU used by the agent's process tree since the previous
+//     check, 100 being one core (Linux only);
+//   - pendingApproval: the agent is asking to run a tool (agent_state.go);
+//   - diskFreePercent: free space on the workDir's filesystem, nearly full
+//     below healthDiskLowPercent or healthDiskLowBytes;
+//   - scrollback: the in-memory output buffer joining browsers are replayed
+//     from (termsnap.Ring) -- bytes held, allocated (it grows as output
+//     comes, up to its capacity) and ever written.
+//
+// Problems says what needs attention, in words. The homepage shows it on the
+// card, and each session carries its health in /api/sessions/live and the
+// list_sessions MCP tool.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// healthDiskLowPercent and healthDiskLowBytes: less free space than
+	// either is nearly full.
+	healthDiskLowPercent = 5
+	healthDiskLowBytes   = 1 << 30
+	// healthCPUMinSample is the shortest window cpuPercent is measured
+	// over; checks closer together reuse the last figure.
+	healthCPUMinSample = 2 * time.Second
+	// clockTicksPerSecond is USER_HZ, the unit of /proc/<pid>/stat times;
+	// 100 on every Linux platform.
+	clockTicksPerSecond = 100
+)
+
+// sessionHealth is one check's result.
+type sessionHealth struct {
+	Process         string   `json:"process"` // "running", "zombie", "gone" or "hibernated"
+	LastOutputAge   int      `json:"lastOutputAge"`
+	CPUPercent      *float64 `json:"cpuPercent,omitempty"` // nil until two samples
+	PendingApproval bool     `json:"pendingApproval,omitempty"`
+	DiskFreePercent *float64 `json:"diskFreePercent,omitempty"`
+	DiskNearlyFull  bool     `json:"diskNearlyFull,omitempty"`
+	Scrollback      ringUse  `json:"scrollback"`
+	Problems        []string `json:"problems,omitempty"`
+}
+
+// ringUse is termsnap.RingStats for JSON.
+type ringUse struct {
+	Held      int   `json:"held"`
+	Allocated int   `json:"allocated"`
+	Capacity  int   `json:"capacity"`
+	Written   int64 `json:"written"`
+}
+
+// healthSampler keeps the previous CPU sample for cpuPercent.
+type healthSampler struct {
+	mu      sync.Mutex
+	at      time.Time
+	ticks   int64
+	percent *float64
+}
+
+// ProblemText joins the problems for the homepage card.
+func (h sessionHealth) ProblemText() string {
+	return strings.Join(h.Problems, "; ")
+}
+
+// CPUText is cpuPercent for the homepage card, or "" when unknown.
+func (h sessionHealth) CPUText() string {
+	if h.CPUPercent == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%% CPU", *h.CPUPercent)
+}
+
+// QuietFor is how long the terminal has been quiet, or "" under a minute.
+func (h sessionHealth) QuietFor() string {
+	if h.LastOutputAge < 60 {
+		return ""
+	}
+	return formatDuration(time.Duration(h.LastOutputAge) * time.Second)
+}
+
+// checkHealth runs the checks for the session.
+func (s *Session) checkHealth(now time.Time) sessionHealth {
+	s.mu.RLock()
+	var pid int
+	if s.Cmd != nil && s.Cmd.Process != nil {
+		pid = s.Cmd.Process.Pid
+	}
+	s.mu.RUnlock()
+
+	h := sessionHealth{Process: processState(pid)}
+	if s.hibernated() {
+		h.Process = "hibernated"
+	}
+	s.vtMu.Lock()
+	if !s.lastOutput.IsZero() {
+		h.LastOutputAge = int(now.Sub(s.lastOutput).Seconds())
+	}
+	ring := s.ring.Stats()
+	s.vtMu.Unlock()
+	h.Scrollback = ringUse(ring)
+	if h.Process == "running" {
+		h.CPUPercent = s.health.cpuPercent(pid, now)
+	}
+	h.PendingApproval = s.AgentState() == agentStateWaitingApproval
+	if free, total, ok := diskSpace(s.effectiveWorkDir()); ok && total > 0 {
+		pct := float64(free) * 100 / float64(total)
+		h.DiskFreePercent = &pct
+		h.DiskNearlyFull = pct < healthDiskLowPercent || free < healthDiskLowBytes
+	}
+
+	switch h.Process {
+	case "zombie":
+		h.Problems = append(h.Problems, "agent process is a zombie")
+	case "gone":
+		h.Problems = append(h.Problems, "agent process has exited")
+	}
+	if h.PendingApproval {
+		h.Problems = append(h.Problems, "waiting for approval")
+	}
+	if h.DiskNearlyFull {
+		h.Problems = append(h.Problems, fmt.Sprintf("disk nearly full (%.0f%% free)", *h.DiskFreePercent))
+	}
+	return h
+}
+
+// cpuPercent returns the process tree's CPU use since the previous sample,
+// or nil when there is none yet.
+func (hs *healthSampler) cpuPercent(pid int, now time.Time) *float64 {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if !hs.at.IsZero() && now.Sub(hs.at) < healthCPUMinSample {
+		return hs.percent
+	}
+	ticks, ok := processTreeCPUTicks(pid)
+	if !ok {
+		return nil
+	}
+	if !hs.at.IsZero() {
+		used := max(ticks-hs.ticks, 0) // a child that exited takes its ticks along
+		pct := float64(used) / clockTicksPerSecond / now.Sub(hs.at).Seconds() * 100
+		hs.percent = &pct
+	}
+	hs.at, hs.ticks = now, ticks
+	return hs.percent
+}
+
+// processState reports whether pid is "running", "zombie" or "gone".
+func processState(pid int) string {
+	if pid <= 0 {
+		return "gone"
+	}
+	if fields, err := procStatFields(pid); err == nil {
+		if fields[0] == "Z" || fields[0] == "X" {
+			return "zombie"
+		}
+		return "running"
+	}
+	if syscall.Kill(pid, 0) == nil {
+		return "running"
+	}
+	return "gone"
+}
+
+// processTreeCPUTicks sums user and system time of pid and its descendants.
+func processTreeCPUTicks(pid int) (int64, bool) {
+	if _, err := procStatFields(pid); err != nil {
+		return 0, false
+	}
+	var total int64
+	for _, p := range append([]int{pid}, collectDescendantPIDs(pid)...) {
+		fields, err := procStatFields(p)
+		if err != nil || len(fields) < 13 {
+			continue
+		}
+		utime, _ := strconv.ParseInt(fields[11], 10, 64)
+		stime, _ := strconv.ParseInt(fields[12], 10, 64)
+		total += utime + stime
+	}
+	return total, true
+}
+
+// procStatFields returns the fields of /proc/<pid>/stat after "(comm)",
+// starting with the state.
+func procStatFields(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	stat := string(data)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 || i+2 >= len(stat) {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return strings.Fields(stat[i+2:]), nil
+}
+
+// diskSpace returns the free and total bytes of the filesystem holding dir.
+func diskSpace(dir string) (free, total uint64, ok bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, false
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), true
+}
@@ -0,0 +1,133 @@
+// templates_dir.go -- on-disk overrides for the embedded UI (-templates-dir).
+//
+// The pages and static files are embedded in the binary, so rebranding the
+// UI or tweaking its CSS meant rebuilding swe-swe-server. -templates-dir DIR
+// (env SWE_TEMPLATES_DIR, config key paths.templates) names a directory laid
+// out like the embedded tree:
+//
+//	DIR/page-templates/index.html      session page
+//	DIR/page-templates/selection.html  homepage
+//	DIR/page-templates/fork-confirm.html
+//	DIR/page-templates/deep-link.html  "Open in swe-swe" confirm page
+//	DIR/page-templates/watch.html      broadcast viewer page
+//	DIR/static/styles/theme.css        any file under static/
+//	DIR/static/logo.svg                ... including new ones
+//
+// A file present there is used instead of the embedded one; anything absent
+// falls back to the embedded copy, so an override directory holds only what
+// it changes. A page template that fails to parse is logged and the embedded
+// page is served instead, rather than taking the UI down. Overrides are read
+// at startup: restart the server to pick up edits. Overridden static files
+// get new content hashes (static_assets.go), so browsers fetch them at once.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// templatesDir is the -templates-dir override directory ("" = embedded only).
+var templatesDir string
+
+// loadTemplatesDir applies -templates-dir / SWE_TEMPLATES_DIR. A directory
+// that does not exist is an error, so a typo does not silently serve the
+// stock UI.
+func loadTemplatesDir(flagValue string) error {
+	templatesDir = ""
+	dir := firstNonEmpty(flagValue, os.Getenv("SWE_TEMPLATES_DIR"))
+	if dir == "" {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	templatesDir = dir
+	log.Printf("UI overrides from %s (page-templates/, static/)", dir)
+	return nil
+}
+
+// overlayFS serves files from upper when it has them and from lower
+// otherwise. Directories are merged, so fs.WalkDir sees both trees.
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil {
+		if info, statErr := f.Stat(); statErr == nil && !info.IsDir() {
+			return f, nil
+		}
+		f.Close()
+	}
+	// Directories come from lower (see ReadDir for the merged listing).
+	if lf, lerr := o.lower.Open(name); lerr == nil || err != nil {
+		return lf, lerr
+	}
+	return o.upper.Open(name)
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, uerr := fs.ReadDir(o.upper, name)
+	lower, lerr := fs.ReadDir(o.lower, name)
+	if uerr != nil && lerr != nil {
+		return nil, lerr
+	}
+	byName := map[string]fs.DirEntry{}
+	for _, e := range lower {
+		byName[e.Name()] = e
+	}
+	for _, e := range upper {
+		byName[e.Name()] = e
+	}
+	entries := make([]fs.DirEntry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// withTemplatesDir overlays DIR/sub on the embedded tree when -templates-dir
+// is set.
+func withTemplatesDir(embedded fs.FS, sub string) fs.FS {
+	if templatesDir == "" {
+		return embedded
+	}
+	return overlayFS{upper: os.DirFS(filepath.Join(templatesDir, sub)), lower: embedded}
+}
+
+// parsePageTemplate parses page-templates/<file>, preferring the override
+// directory's copy and falling back to the embedded one when the override
+// is missing or does not parse.
+func parsePageTemplate(name, file string) (*template.Template, error) {
+	if templatesDir != "" {
+		content, err := os.ReadFile(filepath.Join(templatesDir, "page-templates", file))
+		switch {
+		case err == nil:
+			tmpl, err := template.New(name).Funcs(pageTemplateFuncs).Parse(string(content))
+			if err == nil {
+				log.Printf("Using page template override %s", file)
+				return tmpl, nil
+			}
+			log.Printf("WARNING: page template override %s: %v; using the built-in page", file, err)
+		case !errors.Is(err, fs.ErrNotExist):
+			log.Printf("WARNING: page template override %s: %v; using the built-in page", file, err)
+		}
+	}
+	content, err := pageTemplatesFS.ReadFile("page-templates/" + file)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(name).Funcs(pageTemplateFuncs).Parse(string(content))
+}
@@ -0,0 +1,233 @@
+// deep_link.go -- "Open in swe-swe" links from issue trackers and PR comments.
+//
+//	GET  /new?repo=URL&branch=NAME&prompt=TEXT&assistant=claude
+//	POST /new  (same fields, as a form)
+//
+// All fields are optional but assistant, which defaults to claude. repo is a
+// git URL (the default workspace when empty or when it is the workspace's
+// origin), branch a worktree branch, and prompt the first thing to tell the
+// agent.
+//
+// Like /api/fork, the GET is side-effect free: it renders a confirm page
+// showing what will happen, so a link unfurler or prefetcher cannot clone a
+// repo or start an agent, and a link planted in an issue cannot start one
+// with its prompt behind the owner's back. Confirming POSTs the fields back.
+// The POST clones the repo (or fetches it when already cloned), stages the
+// new session with its worktree branch, and redirects into it. The session
+// page creates the worktree and the session as for the New Session dialog.
+//
+// The prompt is typed into the agent's terminal once the agent is ready for
+// input (agent_ready.go), and submitted with Enter. A multi-line prompt is sent as a bracketed paste so
+// its line breaks do not submit it early. A link carries no credentials: a
+// private repo has to be cloned once with the New Session dialog, after which
+// links open it (a failed fetch of an existing clone is not fatal).
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	deepLinkPath = "/new"
+	// deepLinkMaxPrompt bounds the prompt a link can carry.
+	deepLinkMaxPrompt = 16 << 10
+	// deepLinkPromptMaxWait gives up waiting for the agent and types anyway.
+	deepLinkPromptMaxWait = 2 * time.Minute
+)
+
+var deepLinkTemplate *template.Template
+
+// deepLinkRequest is what a deep link asks for.
+type deepLinkRequest struct {
+	Repo      string // git URL; "" = default workspace
+	Branch    string
+	Prompt    string
+	Assistant string
+}
+
+// deepLinkData feeds the confirm page. When Error is non-empty the request
+// is invalid and the Open button is suppressed.
+type deepLinkData struct {
+	deepLinkRequest
+	AssistantName string
+	RepoLabel     string // what the page shows for Repo
+	Cloned        bool   // repo is already cloned (POST fetches it)
+	Error         string
+}
+
+// parseDeepLink reads and validates a deep link's fields from a query string
+// or form.
+func parseDeepLink(v url.Values) (deepLinkRequest, string, error) {
+	req := deepLinkRequest{
+		Repo:      strings.TrimSpace(v.Get("repo")),
+		Branch:    deriveBranchName(strings.TrimSpace(v.Get("branch"))),
+		Prompt:    strings.TrimSpace(v.Get("prompt")),
+		Assistant: strings.TrimSpace(v.Get("assistant")),
+	}
+	if req.Assistant == "" {
+		req.Assistant = "claude"
+	}
+	var assistantName string
+	for _, a := range installedAssistants() {
+		if a.Binary == req.Assistant {
+			assistantName = a.Name
+			break
+		}
+	}
+	if assistantName == "" {
+		return req, "", fmt.Errorf("Unknown assistant %q.", req.Assistant)
+	}
+	if len(req.Prompt) > deepLinkMaxPrompt {
+		return req, assistantName, fmt.Errorf("The prompt is longer than %d KiB.", deepLinkMaxPrompt>>10)
+	}
+	if isWorkspaceRepo(req.Repo) {
+		req.Repo = ""
+	}
+	if req.Repo != "" && sanitizeRepoURL(req.Repo) == "" {
+		return req, assistantName, fmt.Errorf("Invalid repository URL %q.", req.Repo)
+	}
+	return req, assistantName, nil
+}
+
+// handleDeepLink dispatches GET /new (confirm page) and POST /new (open).
+func handleDeepLink(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		handleDeepLinkConfirm(w, r)
+	case http.MethodPost:
+		handleDeepLinkOpen(w, r)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDeepLinkConfirm renders the confirm page. It only validates: no
+// clone, no session.
+func handleDeepLinkConfirm(w http.ResponseWriter, r *http.Request) {
+	req, assistantName, err := parseDeepLink(r.URL.Query())
+	data := deepLinkData{deepLinkRequest: req, AssistantName: assistantName, RepoLabel: "workspace"}
+	if err != nil {
+		data.Error = err.Error()
+	} else if req.Repo != "" {
+		data.RepoLabel = req.Repo
+		_, statErr := os.Stat(filepath.Join(reposDir, sanitizeRepoURL(req.Repo), "workspace", ".git"))
+		data.Cloned = statErr == nil
+	}
+	renderDeepLink(w, http.StatusOK, data)
+}
+
+// handleDeepLinkOpen prepares the repo, stages the session and redirects
+// into it.
+func handleDeepLinkOpen(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req, assistantName, err := parseDeepLink(r.PostForm)
+	data := deepLinkData{deepLinkRequest: req, AssistantName: assistantName, RepoLabel: firstNonEmpty(req.Repo, "workspace")}
+	if err != nil {
+		data.Error = err.Error()
+		renderDeepLink(w, http.StatusBadRequest, data)
+		return
+	}
+	if err := checkSessionLimit(req.Assistant); err != nil {
+		data.Error = err.Error()
+		renderDeepLink(w, http.StatusTooManyRequests, data)
+		return
+	}
+
+	repoPath := ""
+	if req.Repo != "" {
+		var gitOutput string
+		repoPath, _, gitOutput, err = cloneOrFetchRepo(req.Repo, "", "", "")
+		if err != nil && repoPath != "" {
+			// Already cloned: a failed fetch (e.g. a private repo, whose
+			// credentials links do not carry) leaves a usable checkout.
+			log.Printf("Deep link: opening %s without fetching: %v", repoPath, err)
+		} else if err != nil {
+			data.Error = err.Error()
+			if cloneNeedsAuth(gitOutput) {
+				data.Error = "This repository needs credentials. Clone it once with the New Session dialog on the homepage, then open the link again."
+			}
+			renderDeepLink(w, http.StatusBadGateway, data)
+			return
+		}
+		if err := setupSweSweFiles(repoPath); err != nil {
+			log.Printf("Warning: failed to setup swe-swe files in %s: %v", repoPath, err)
+		}
+	}
+
+	newUUID := uuid.New().String()
+	stageSession(newUUID, SessionParams{
+		UUID:      newUUID,
+		Assistant: req.Assistant,
+		Branch:    req.Branch,
+		RepoPath:  repoPath,
+		Prompt:    req.Prompt,
+	}, "new", "")
+	log.Printf("Deep link: staged session %s (assistant=%s repo=%q branch=%q prompt=%d bytes)",
+		newUUID, req.Assistant, req.Repo, req.Branch, len(req.Prompt))
+
+	q := url.Values{}
+	q.Set("assistant", req.Assistant)
+	if req.Branch != "" {
+		q.Set("branch", req.Branch)
+	}
+	if repoPath != "" {
+		q.Set("pwd", repoPath)
+	}
+	http.Redirect(w, r, "/session/"+newUUID+"?"+q.Encode(), http.StatusFound)
+}
+
+func renderDeepLink(w http.ResponseWriter, status int, data deepLinkData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := deepLinkTemplate.Execute(w, data); err != nil {
+		log.Printf("deep link page render error: %v", err)
+	}
+}
+
+// typeInitialPrompt types prompt into the session's terminal once the agent
+// is ready for input, then presses Enter.
+func (s *Session) typeInitialPrompt(prompt string) {
+	defer recoverGoroutine("initial prompt for session " + s.UUID)
+	s.waitForAgentReady(deepLinkPromptMaxWait)
+	if s.isEnding() {
+		return
+	}
+	if err := s.typePrompt(prompt); err != nil {
+		log.Printf("Session %s: initial prompt: %v", s.UUID, err)
+		return
+	}
+	log.Printf("Session %s: typed the initial prompt (%d bytes)", s.UUID, len(prompt))
+}
+
+// typePrompt types prompt into the session's terminal, as a bracketed paste
+// when it has line breaks, and presses Enter.
+func (s *Session) typePrompt(prompt string) error {
+	text := []byte(prompt)
+	if strings.ContainsAny(prompt, "\r\n") {
+		text = []byte("\x1b[200~" + prompt + "\x1b[201~")
+	}
+	if err := s.WriteInput(text); err != nil {
+		return err
+	}
+	s.Checkpoints.noteInput(text)
+	// Same pause as send_session_input, so the TUI takes the text before Enter.
+	time.Sleep(300 * time.Millisecond)
+	if err := s.WriteInput([]byte{'\r'}); err != nil {
+		return err
+	}
+	s.Checkpoints.noteInput([]byte{'\r'})
+	return nil
+}
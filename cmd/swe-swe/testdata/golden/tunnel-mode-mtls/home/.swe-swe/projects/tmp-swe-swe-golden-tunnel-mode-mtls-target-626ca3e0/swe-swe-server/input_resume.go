@@ -0,0 +1,121 @@
+// input_resume.go -- sequenced terminal input, so a client whose connection
+// drops mid-paste or mid-upload can resend what was lost without typing
+// anything twice.
+//
+// A client that wants this opens the WebSocket with ?input={id}, a random id
+// (1-64 of [A-Za-z0-9_-]) it keeps for the page, and wraps each input frame --
+// keystrokes, 0x01 uploads, 0x03 image pastes -- as
+//
+//	[0x04, seq_b3, seq_b2, seq_b1, seq_b0, ...frame]
+//
+// with seq counting up from 1. The server applies a frame only when its seq
+// is beyond the last one applied for that id, and answers every sequenced
+// frame, applied or not, with {"type": "input_ack", "seq": N}. On connect it
+// tells the client where the stream stands with {"type": "input_resume",
+// "seq": N}; the client drops what it has up to N and resends the rest, in
+// order. The position of an id is kept for inputResumeWindow after its last
+// connection closes, so a reconnecting or restored page resumes exactly where
+// it left off. Clients that send plain frames are unaffected. The frame is
+// built and split by wsproto.Sequenced and wsproto.ParseSequenced.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// inputResumeWindow is how long an input stream's position outlives its
+// last connection.
+const inputResumeWindow = 2 * time.Minute
+
+// inputResumeMaxStreams caps the input streams remembered per session.
+const inputResumeMaxStreams = 64
+
+// inputStream is one client's position in its input sequence.
+type inputStream struct {
+	lastSeq  uint32
+	conns    int       // open connections using the id
+	lastSeen time.Time // when the last connection closed
+}
+
+// inputResumeState holds a session's input streams by id. The zero value is
+// ready to use.
+type inputResumeState struct {
+	mu      sync.Mutex
+	streams map[string]*inputStream
+}
+
+// validInputStreamID reports whether id is usable as an input stream id.
+func validInputStreamID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// attach registers a connection for id and returns the last seq applied.
+// Streams idle past inputResumeWindow are forgotten first.
+func (s *inputResumeState) attach(id string, now time.Time) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams == nil {
+		s.streams = map[string]*inputStream{}
+	}
+	for k, st := range s.streams {
+		if st.conns == 0 && now.Sub(st.lastSeen) > inputResumeWindow {
+			delete(s.streams, k)
+		}
+	}
+	st := s.streams[id]
+	if st == nil {
+		if len(s.streams) >= inputResumeMaxStreams {
+			s.evictOldest()
+		}
+		st = &inputStream{}
+		s.streams[id] = st
+	}
+	st.conns++
+	return st.lastSeq
+}
+
+// evictOldest forgets the idle stream unused the longest. Called with mu held.
+func (s *inputResumeState) evictOldest() {
+	oldest := ""
+	for k, st := range s.streams {
+		if st.conns == 0 && (oldest == "" || st.lastSeen.Before(s.streams[oldest].lastSeen)) {
+			oldest = k
+		}
+	}
+	delete(s.streams, oldest)
+}
+
+// detach unregisters a connection for id.
+func (s *inputResumeState) detach(id string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.streams[id]; st != nil {
+		st.conns--
+		st.lastSeen = now
+	}
+}
+
+// accept reports whether the frame with seq on id is new and should be
+// applied, and records it as applied.
+func (s *inputResumeState) accept(id string, seq uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.streams[id]
+	if st == nil {
+		return true
+	}
+	if seq <= st.lastSeq {
+		return false
+	}
+	st.lastSeq = seq
+	return true
+}
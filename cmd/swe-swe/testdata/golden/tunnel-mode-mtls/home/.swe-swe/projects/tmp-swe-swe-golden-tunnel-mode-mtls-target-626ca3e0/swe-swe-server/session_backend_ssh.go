@@ -0,0 +1,269 @@
+// session_backend_ssh.go -- the "ssh" session backend: run the agent on a
+// remote build machine.
+//
+// SWE_SESSION_BACKEND=ssh starts the agent with `ssh -tt` so heavy builds run
+// somewhere other than the box serving the web UI. ssh allocates the remote
+// PTY and turns the local PTY's window-size changes into window-change
+// requests, so swe-swe-server keeps the PTY, resize handling and recording as
+// for a host session.
+//
+// The machine is configured with SWE_SSH_* settings, read from the session
+// env so each repo can name its own in .swe-swe/env:
+//
+//	SWE_SSH_HOST  remote host (required)
+//	SWE_SSH_USER  remote user (default: ssh's default)
+//	SWE_SSH_PORT  remote port (default: ssh's default)
+//	SWE_SSH_KEY   private key file on the server (default: ssh's default)
+//	SWE_SSH_DIR   remote working directory (default: the session's
+//	              working directory, i.e. the repo at the same path)
+//
+// sshd only accepts the variables its AcceptEnv allows, so the session env is
+// not sent with SendEnv: before each start, Command writes it to a 0600 file
+// under ~/.swe-swe/session-env/ on the remote, and the remote command sources
+// it. Values never appear on a command line.
+//
+// A per-session master connection (ControlMaster) carries local forwards of
+// the preview and agent chat ports back to 127.0.0.1 on the server, so the
+// per-session proxies work unchanged; it is restarted if it drops, and the
+// agent's connections reuse it when it is up. Release stops it and removes
+// the env file.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// sshConnectTimeout bounds the reachability check and the env upload.
+	sshConnectTimeout = 30 * time.Second
+	// sshEnvDir holds the per-session env files, relative to the remote home.
+	sshEnvDir = ".swe-swe/session-env"
+)
+
+// sshBackend runs session commands on a remote machine over ssh.
+type sshBackend struct {
+	dest    string   // [user@]host
+	opts    []string // ssh options shared by every connection
+	workDir string   // remote
+	ports   []string // forwarded back to the server
+
+	mu         sync.Mutex
+	stopped    bool
+	stopMaster chan struct{}
+	masterDone chan struct{}
+}
+
+func (b *sshBackend) Name() string { return "ssh:" + b.dest }
+
+// sshArgs returns the ssh argv (without "ssh") for running remote on dest.
+func (b *sshBackend) sshArgs(extra []string, remote string) []string {
+	args := append(append([]string{}, b.opts...), extra...)
+	return append(args, b.dest, remote)
+}
+
+func sshEnvFile(sessionUUID string) string {
+	return "~/" + sshEnvDir + "/" + sessionUUID
+}
+
+func (b *sshBackend) Command(cmdName string, cmdArgs []string, env []string) (string, []string) {
+	sessionUUID := envLookup(env)("SESSION_UUID")
+	if err := b.uploadEnv(sessionUUID, env); err != nil {
+		log.Printf("Session %s: sending env to %s failed: %v", sessionUUID, b.dest, err)
+	}
+	// The remote command is one word on the local command line; ssh hands
+	// it to the remote login shell as is.
+	return "ssh", b.sshArgs([]string{"-tt"}, shellQuote(b.remoteCommand(sessionUUID, cmdName, cmdArgs)))
+}
+
+// remoteCommand is the command line the remote shell runs: enter the working
+// directory, load the session env, and become the agent.
+func (b *sshBackend) remoteCommand(sessionUUID, cmdName string, cmdArgs []string) string {
+	remote := "cd " + shellQuote(b.workDir) + " && . " + sshEnvFile(sessionUUID) + " && exec " + cmdName
+	if len(cmdArgs) > 0 {
+		remote += " " + strings.Join(cmdArgs, " ")
+	}
+	return remote
+}
+
+// uploadEnv writes the forwarded session env to the session's env file.
+func (b *sshBackend) uploadEnv(sessionUUID string, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sshConnectTimeout)
+	defer cancel()
+	remote := "umask 077 && mkdir -p ~/" + sshEnvDir + " && cat > " + sshEnvFile(sessionUUID)
+	cmd := exec.CommandContext(ctx, "ssh", b.sshArgs(nil, remote)...)
+	cmd.Stdin = strings.NewReader(sshEnvScript(env))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// sshEnvScript renders env as `export NAME='value'` lines, forwarding the
+// same variables the container backends do.
+func sshEnvScript(env []string) string {
+	lookup := envLookup(env)
+	var sb strings.Builder
+	for _, name := range forwardedEnvNames(env) {
+		fmt.Fprintf(&sb, "export %s=%s\n", name, shellQuote(lookup(name)))
+	}
+	return sb.String()
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (b *sshBackend) Cleanup(sessionUUID string) {
+	b.runRemote(sessionUUID, "cleanup", "sh -c "+shellQuote(killSessionScript)+" sh "+sessionUUID)
+}
+
+// Release stops the master connection, then signals what is left of the
+// session and removes its env file.
+func (b *sshBackend) Release(sessionUUID string) {
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		return
+	}
+	b.stopped = true
+	close(b.stopMaster)
+	b.mu.Unlock()
+	<-b.masterDone
+
+	b.runRemote(sessionUUID, "release",
+		"sh -c "+shellQuote(killSessionScript)+" sh "+sessionUUID+"; rm -f "+sshEnvFile(sessionUUID))
+}
+
+func (b *sshBackend) runRemote(sessionUUID, what, remote string) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerCleanupTimeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, "ssh", b.sshArgs(nil, remote)...).CombinedOutput(); err != nil {
+		log.Printf("Session %s: %s on %s failed: %v %s", sessionUUID, what, b.dest, err, strings.TrimSpace(string(out)))
+	}
+}
+
+var sshPortRe = regexp.MustCompile(`^[0-9]{1,5}$`)
+
+// newSSHBackend builds the backend from the SWE_SSH_* settings in env.
+func newSSHBackend(p backendParams) (*sshBackend, error) {
+	lookup := envLookup(p.Env)
+	host := strings.TrimSpace(lookup("SWE_SSH_HOST"))
+	user := strings.TrimSpace(lookup("SWE_SSH_USER"))
+	port := strings.TrimSpace(lookup("SWE_SSH_PORT"))
+	key := strings.TrimSpace(lookup("SWE_SSH_KEY"))
+	dir := strings.TrimSpace(lookup("SWE_SSH_DIR"))
+	if host == "" {
+		return nil, fmt.Errorf("ssh backend needs SWE_SSH_HOST")
+	}
+	if dir == "" {
+		dir = p.WorkDir
+	}
+	for _, w := range []string{host, user, key, dir} {
+		if w != "" && (!backendWordRe.MatchString(w) || strings.HasPrefix(w, "-")) {
+			return nil, fmt.Errorf("ssh backend: %q contains characters the session command line cannot carry", w)
+		}
+	}
+	if port != "" && !sshPortRe.MatchString(port) {
+		return nil, fmt.Errorf("invalid SWE_SSH_PORT %q", port)
+	}
+
+	b := &sshBackend{
+		dest:       host,
+		workDir:    dir,
+		stopMaster: make(chan struct{}),
+		masterDone: make(chan struct{}),
+	}
+	if user != "" {
+		b.dest = user + "@" + host
+	}
+	// Short enough for the unix socket path limit.
+	control := filepath.Join(os.TempDir(), "swe-swe-ssh-"+p.SessionUUID)
+	b.opts = []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=15", "-o", "ServerAliveInterval=15",
+		"-o", "ControlPath=" + control}
+	if port != "" {
+		b.opts = append(b.opts, "-p", port)
+	}
+	if key != "" {
+		b.opts = append(b.opts, "-i", key, "-o", "IdentitiesOnly=yes")
+	}
+	for _, k := range []string{"PORT", "AGENT_CHAT_PORT"} {
+		if v := lookup(k); v != "" && v != "0" {
+			b.ports = append(b.ports, v)
+		}
+	}
+	return b, nil
+}
+
+// startSSHSession checks the remote is reachable, then starts the master
+// connection that carries the port forwards.
+func startSSHSession(p backendParams) (sessionBackend, error) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return nil, fmt.Errorf("ssh backend needs the ssh client: %w", err)
+	}
+	b, err := newSSHBackend(p)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sshConnectTimeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, "ssh", b.sshArgs(nil, "test -d "+shellQuote(b.workDir))...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ssh %s: %s missing or host unreachable: %v: %s", b.dest, b.workDir, err, strings.TrimSpace(string(out)))
+	}
+	go func() {
+		defer recoverGoroutine(fmt.Sprintf("ssh master for session %s", p.SessionUUID))
+		b.runMaster(p.SessionUUID)
+	}()
+	return b, nil
+}
+
+// masterArgs is the argv for the master connection: no command, just the
+// forwards, failing rather than running without them.
+func (b *sshBackend) masterArgs() []string {
+	args := append([]string{}, b.opts...)
+	args = append(args, "-N", "-o", "ControlMaster=yes", "-o", "ExitOnForwardFailure=yes")
+	for _, port := range b.ports {
+		args = append(args, "-L", "127.0.0.1:"+port+":127.0.0.1:"+port)
+	}
+	return append(args, b.dest)
+}
+
+// runMaster keeps the master connection up until Release.
+func (b *sshBackend) runMaster(sessionUUID string) {
+	defer close(b.masterDone)
+	for {
+		cmd := exec.Command("ssh", b.masterArgs()...)
+		done := make(chan error, 1)
+		if err := cmd.Start(); err != nil {
+			done <- err
+		} else {
+			go func() { done <- cmd.Wait() }()
+		}
+		select {
+		case <-b.stopMaster:
+			if cmd.Process != nil {
+				// SIGTERM lets ssh remove its control socket.
+				_ = cmd.Process.Signal(syscall.SIGTERM)
+			}
+			<-done
+			return
+		case err := <-done:
+			log.Printf("Session %s: ssh master to %s exited (%v); restarting", sessionUUID, b.dest, err)
+		}
+		select {
+		case <-b.stopMaster:
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
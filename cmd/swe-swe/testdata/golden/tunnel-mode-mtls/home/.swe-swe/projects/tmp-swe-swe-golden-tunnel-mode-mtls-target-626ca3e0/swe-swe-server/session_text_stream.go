@@ -0,0 +1,215 @@
+// session_text_stream.go -- plain-text session stream for screen readers and
+// low-bandwidth clients (/ws/{uuid}?text=1 or ?text=only).
+//
+// The binary stream is raw PTY output: escape sequences, cursor movement and,
+// for full-screen agents, whole-screen repaints on every keystroke. A screen
+// reader fed that text reads the same lines over and over. A client that
+// connects with ?text=1 additionally gets JSON text frames built from the
+// session's vt10x screen instead:
+//
+//	{"type": "text", "seq": 1, "reset": true, "lines": ["$ make test", "ok", "$"]}
+//	{"type": "text", "seq": 2, "lines": ["PASS"]}
+//
+// The first frame (reset) is the whole screen. After that, at most every
+// textStreamInterval, the screen is compared with the one last sent: rows
+// that merely scrolled up are matched to where they were, and only rows that
+// are new or changed are sent, top to bottom, without ANSI codes and with
+// trailing blanks trimmed. A repaint that draws the same text sends nothing.
+// Output that scrolls more than a screenful between two frames is reported as
+// the screen it leaves behind.
+//
+// ?text=only also drops the binary stream (terminal output, scrollback and
+// snapshot) for that connection; input, resize and JSON control messages work
+// as usual.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// textStreamInterval is the most often text frames are sent per session;
+// output in between is coalesced into one frame.
+const textStreamInterval = 250 * time.Millisecond
+
+// textStreamFrame is one server -> client text frame.
+type textStreamFrame struct {
+	Type  string   `json:"type"` // "text"
+	Seq   int64    `json:"seq"`
+	Reset bool     `json:"reset,omitempty"`
+	Lines []string `json:"lines"`
+}
+
+// textStream is a session's plain-text stream state. The zero value has no
+// clients and costs Broadcast one lock.
+type textStream struct {
+	mu      sync.Mutex
+	clients map[*SafeConn]bool
+	prev    []string // screen rows as last sent
+	seq     int64
+	pending bool // a flush is scheduled
+}
+
+// parseTextStreamMode reads the ?text= WebSocket query flag.
+func parseTextStreamMode(v string) (enabled, only bool) {
+	switch v {
+	case "1", "true", "yes":
+		return true, false
+	case "only":
+		return true, true
+	}
+	return false, false
+}
+
+// screenLines returns the screen's rows as text. Call with vtMu held.
+func (s *Session) screenLines() []string {
+	scr := readScreen(s.vt)
+	lines := make([]string, len(scr.Lines))
+	for i, row := range scr.Lines {
+		lines[i] = row.Text
+	}
+	return lines
+}
+
+// addTextClient subscribes conn to the text stream and sends it the current
+// screen. A text-only conn stops receiving binary frames.
+func (s *Session) addTextClient(conn *SafeConn, only bool) {
+	s.flushText() // existing clients get their pending delta first
+
+	if only {
+		s.mu.Lock()
+		if s.textOnlyClients == nil {
+			s.textOnlyClients = make(map[*SafeConn]bool)
+		}
+		s.textOnlyClients[conn] = true
+		s.mu.Unlock()
+	}
+
+	t := &s.text
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.vtMu.Lock()
+	lines := s.screenLines()
+	s.vtMu.Unlock()
+	if len(t.clients) == 0 {
+		t.prev = lines
+	}
+	if t.clients == nil {
+		t.clients = make(map[*SafeConn]bool)
+	}
+	t.clients[conn] = true
+	t.seq++
+	writeTextFrame(conn, textStreamFrame{Type: "text", Seq: t.seq, Reset: true, Lines: squeezeBlankLines(trimBlankTail(lines))})
+}
+
+// removeTextClient unsubscribes conn.
+func (s *Session) removeTextClient(conn *SafeConn) {
+	s.mu.Lock()
+	delete(s.textOnlyClients, conn)
+	s.mu.Unlock()
+	s.text.mu.Lock()
+	delete(s.text.clients, conn)
+	s.text.mu.Unlock()
+}
+
+// textDirty notes that the screen changed, scheduling a flush when anyone
+// is listening. Called from Broadcast.
+func (s *Session) textDirty() {
+	t := &s.text
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.clients) == 0 || t.pending {
+		return
+	}
+	t.pending = true
+	time.AfterFunc(textStreamInterval, s.flushText)
+}
+
+// flushText sends the rows changed since the last frame to every text client.
+func (s *Session) flushText() {
+	t := &s.text
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = false
+	if len(t.clients) == 0 {
+		return
+	}
+	s.vtMu.Lock()
+	cur := s.screenLines()
+	s.vtMu.Unlock()
+	changed := textDelta(t.prev, cur)
+	t.prev = cur
+	if len(changed) == 0 {
+		return
+	}
+	t.seq++
+	frame := textStreamFrame{Type: "text", Seq: t.seq, Lines: changed}
+	for conn := range t.clients {
+		writeTextFrame(conn, frame)
+	}
+}
+
+func writeTextFrame(conn *SafeConn, frame textStreamFrame) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Printf("text stream write error: %v", err)
+	}
+}
+
+// textDelta returns the non-empty rows of cur that are new or changed
+// relative to prev. prev is first aligned to cur at the upward scroll offset
+// that matches the most non-empty rows (the smallest offset on a tie), so
+// rows that only scrolled are not repeated.
+func textDelta(prev, cur []string) []string {
+	best, bestScore := 0, -1
+	for shift := 0; shift <= len(prev); shift++ {
+		score := 0
+		for i := 0; i < len(cur) && i+shift < len(prev); i++ {
+			if cur[i] != "" && cur[i] == prev[i+shift] {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = shift, score
+		}
+	}
+	var changed []string
+	for i, line := range cur {
+		if line == "" {
+			continue
+		}
+		if i+best < len(prev) && prev[i+best] == line {
+			continue
+		}
+		changed = append(changed, line)
+	}
+	return changed
+}
+
+// trimBlankTail drops trailing empty rows.
+func trimBlankTail(lines []string) []string {
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// squeezeBlankLines drops leading and repeated blank rows, so a reset frame
+// reads like the screen without its padding.
+func squeezeBlankLines(lines []string) []string {
+	out := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if line == "" && (i == 0 || lines[i-1] == "") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
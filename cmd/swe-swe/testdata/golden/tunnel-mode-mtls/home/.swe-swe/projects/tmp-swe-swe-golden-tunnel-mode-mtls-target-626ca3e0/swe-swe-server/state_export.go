@@ -0,0 +1,352 @@
+// state_export.go -- move what swe-swe stored to another server.
+//
+//	GET  /api/data/export[?logs=0]
+//	POST /api/data/import[?overwrite=1]
+//
+// The export is a .tar.gz of the recordings dirs (recording_dirs.go), all in
+// one recordings/ directory: each session's metadata (name, annotations,
+// visitors), recording share links, chat history, hook output, remembered
+// ports, the usage ledger, and the terminal recordings themselves unless
+// logs=0. Its first entry, swe-swe-export.json, says what
+// made it. Repo contents are not included, and neither is the server config
+// file, which holds secrets, nor the secrets store and its key; an import
+// skips those two as well. Layout presets live in the browser, and session
+// share links end with their session, so there is nothing to export for
+// either.
+//
+// Import writes the files of such an export into this server's recordings
+// dir. Existing files are kept unless overwrite=1, and a running session's
+// recording is never written over. Usage-ledger entries are merged by UUID.
+// The response lists what was imported and what was skipped.
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// exportManifestName is the first entry of an export.
+	exportManifestName = "swe-swe-export.json"
+	// exportFormat is bumped when an export's layout changes.
+	exportFormat = 1
+	// exportRecordingsPrefix holds the recordings dir's files in an export.
+	exportRecordingsPrefix = "recordings/"
+)
+
+// exportManifest is the content of swe-swe-export.json.
+type exportManifest struct {
+	Format     int       `json:"format"`
+	Version    string    `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	Logs       bool      `json:"logs"` // terminal recordings included
+}
+
+// importReport is the response of POST /api/data/import.
+type importReport struct {
+	Imported     []string `json:"imported"`     // files written, relative to the recordings dir
+	Skipped      []string `json:"skipped"`      // files left alone: existing, live, or not swe-swe state
+	UsageEntries int      `json:"usageEntries"` // usage-ledger entries added
+}
+
+// isTerminalLog reports whether a recording file holds terminal content,
+// which logs=0 leaves out.
+func isTerminalLog(name string) bool {
+	for _, ext := range []string{".log", ".log.gz", ".timing", ".input"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeStateExport writes the export of the recordings dir to w.
+func writeStateExport(w io.Writer, logs bool, now time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(exportManifest{Format: exportFormat, Version: Version, ExportedAt: now.UTC(), Logs: logs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: exportManifestName, Mode: 0644, Size: int64(len(manifest)), ModTime: now}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	// Recordings kept next to their repo are exported alongside the rest,
+	// so an import puts them all in the recordings dir. The index of those
+	// directories means nothing on another server.
+	for _, root := range recordingDirs() {
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && p == root {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), ".tmp") || (!logs && isTerminalLog(d.Name())) || p == recordingIndexPath() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			if isSecretsFile(filepath.ToSlash(rel)) {
+				return nil
+			}
+			return addExportFile(tw, p, exportRecordingsPrefix+filepath.ToSlash(rel))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addExportFile adds the file at p as name. A file still being written is
+// cut at the size it had when it was opened.
+func addExportFile(tw *tar.Writer, p, name string) error {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil // removed while exporting
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
+
+// importState writes the files of an export read from r into the recordings
+// dir.
+func importState(r io.Reader, overwrite bool) (importReport, error) {
+	report := importReport{Imported: []string{}, Skipped: []string{}}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return report, fmt.Errorf("not a swe-swe export: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != exportManifestName {
+		return report, errors.New("not a swe-swe export: " + exportManifestName + " missing")
+	}
+	var manifest exportManifest
+	if err := json.NewDecoder(io.LimitReader(tr, 64<<10)).Decode(&manifest); err != nil {
+		return report, fmt.Errorf("not a swe-swe export: %w", err)
+	}
+	if manifest.Format != exportFormat {
+		return report, fmt.Errorf("export format %d is not supported (want %d)", manifest.Format, exportFormat)
+	}
+	if err := os.MkdirAll(recordingsDir, 0755); err != nil {
+		return report, err
+	}
+
+	portsImported := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		rel, ok := strings.CutPrefix(path.Clean(hdr.Name), exportRecordingsPrefix)
+		if !ok || rel == "" || path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			report.Skipped = append(report.Skipped, hdr.Name)
+			continue
+		}
+		if isSecretsFile(rel) {
+			report.Skipped = append(report.Skipped, rel)
+			continue
+		}
+		if rel == "usage.jsonl" {
+			n, err := mergeUsageLedger(tr)
+			if err != nil {
+				return report, err
+			}
+			report.UsageEntries += n
+			continue
+		}
+		dest := filepath.Join(recordingsDir, filepath.FromSlash(rel))
+		if !strings.Contains(rel, "/") {
+			dest = recordingPath(rel) // where this server keeps that recording
+		}
+		if recordingFileActive(path.Base(rel)) {
+			report.Skipped = append(report.Skipped, rel)
+			continue
+		}
+		if _, err := os.Stat(dest); err == nil && !overwrite {
+			report.Skipped = append(report.Skipped, rel)
+			continue
+		}
+		if err := writeImportedFile(dest, tr); err != nil {
+			return report, err
+		}
+		report.Imported = append(report.Imported, rel)
+		portsImported = portsImported || rel == "ports.json"
+	}
+
+	if portsImported {
+		// Read the imported ports.json on the next reservation.
+		portAlloc.mu.Lock()
+		portAlloc.remembered = nil
+		portAlloc.mu.Unlock()
+	}
+	// Reload the secrets store rather than keep a copy read before the
+	// import replaced the files around it.
+	if dir := secretsDir(); dir != "" {
+		loadSecrets(dir)
+	}
+	return report, nil
+}
+
+// isSecretsFile reports whether rel, relative to a recordings dir, is the
+// secrets store or its key (secrets.go), which never leave this server.
+func isSecretsFile(rel string) bool {
+	return rel == secretsFileName || rel == secretsKeyFileName
+}
+
+// recordingFileActive reports whether name is a file of a recording that a
+// running session is still writing.
+func recordingFileActive(name string) bool {
+	stem, ok := strings.CutPrefix(name, "session-")
+	if !ok {
+		return false
+	}
+	stem, _, _ = strings.Cut(stem, ".")
+	parentUUID, _, ok := parseRecordingFilename(stem)
+	return ok && recordingActive(parentUUID)
+}
+
+// writeImportedFile writes r to dest through a temporary file, so a failed
+// import never leaves half a file behind.
+func writeImportedFile(dest string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// mergeUsageLedger appends the entries of an imported ledger whose UUID is
+// not in this server's ledger yet, returning how many.
+func mergeUsageLedger(r io.Reader) (int, error) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	ledger, err := readUsageLedger()
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool, len(ledger))
+	for _, e := range ledger {
+		seen[e.UUID] = true
+	}
+	var added []usageEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e usageEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.UUID == "" || seen[e.UUID] {
+			continue
+		}
+		seen[e.UUID] = true
+		added = append(added, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	f, err := os.OpenFile(usageLedgerPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range added {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+	return len(added), f.Close()
+}
+
+// handleDataExportAPI serves GET /api/data/export.
+func handleDataExportAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	logs := r.URL.Query().Get("logs") != "0" && r.URL.Query().Get("logs") != "false"
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="swe-swe-export-%s.tar.gz"`, now.Format("20060102-150405")))
+	// The headers are out by the time an error shows; the truncated archive
+	// fails to unpack.
+	if err := writeStateExport(w, logs, now); err != nil {
+		log.Printf("Export: %v", err)
+	}
+}
+
+// handleDataImportAPI serves POST /api/data/import.
+func handleDataImportAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	overwrite := r.URL.Query().Get("overwrite") == "1" || r.URL.Query().Get("overwrite") == "true"
+	report, err := importState(r.Body, overwrite)
+	if err != nil {
+		log.Printf("Import: %v (%d files imported before it)", err, len(report.Imported))
+		http.Error(w, "Import failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Import: %d files, %d usage entries, %d skipped", len(report.Imported), report.UsageEntries, len(report.Skipped))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,167 @@
+// branch_preview.go -- what a session name or branch field turns into.
+//
+// The branch a session runs on is decided in three places: deriveBranchName
+// sanitizes what the user typed, stripRemotePrefix turns "origin/x" into x,
+// and createWorktreeInRepo picks between running in place, re-entering a
+// worktree, attaching to a local branch, tracking a remote one, or creating
+// a fresh branch. The New Session dialog could only ask /api/worktree/check,
+// which knew the first step and the default repo. previewBranch runs the same
+// steps without creating anything:
+//
+//	GET /api/branch/preview?name={typed}[&pwd={repo}]
+//
+// answers with the branch, notes on how the typed text was changed, whether
+// git accepts the result, the directory the session would run in and what is
+// already there (conflict):
+//
+//	current   the repo has the branch checked out; the session runs in place
+//	worktree  the worktree exists and is re-entered
+//	local     a local branch exists; the worktree is attached to it
+//	remote    origin has the branch; the worktree tracks it
+//	""        a new branch is created
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// branchPreview describes what previewBranch found.
+type branchPreview struct {
+	Name     string   `json:"name"`
+	Branch   string   `json:"branch"`
+	Changed  bool     `json:"changed"`
+	Changes  []string `json:"changes,omitempty"`
+	Valid    bool     `json:"valid"`
+	Error    string   `json:"error,omitempty"`
+	RepoPath string   `json:"repo_path"`
+	WorkDir  string   `json:"work_dir,omitempty"`
+	Conflict string   `json:"conflict,omitempty"`
+}
+
+var (
+	branchUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9_./ -]`)
+	branchRepeats     = regexp.MustCompile(`--|//|\.\.`)
+)
+
+// branchNameChanges lists, in plain words, what deriveBranchName did to
+// name. It only explains; deriveBranchName remains the one that decides.
+func branchNameChanges(name, branch string) []string {
+	if name == branch {
+		return nil
+	}
+	var changes []string
+	decomposed := norm.NFD.String(name)
+	if strings.IndexFunc(decomposed, func(r rune) bool { return unicode.Is(unicode.Mn, r) }) >= 0 {
+		changes = append(changes, "accents removed")
+	}
+	if strings.ToLower(name) != name {
+		changes = append(changes, "lowercased")
+	}
+	if strings.Contains(name, " ") {
+		changes = append(changes, "spaces became hyphens")
+	}
+	if branchUnsafeChars.MatchString(strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, decomposed)) {
+		changes = append(changes, "characters other than letters, digits, '_', '.', '/' and '-' became hyphens")
+	}
+	if branchRepeats.MatchString(name) {
+		changes = append(changes, "repeated '-', '/' or '.' collapsed")
+	}
+	if strings.HasSuffix(strings.ToLower(strings.TrimRight(name, "-/. ")), ".lock") {
+		changes = append(changes, `".lock" suffix removed`)
+	}
+	if t := strings.TrimSpace(name); t != strings.Trim(t, "-/.") {
+		changes = append(changes, "leading or trailing '-', '/' or '.' removed")
+	}
+	return changes
+}
+
+// checkBranchRef asks git whether branch is a valid branch name.
+func checkBranchRef(branch string) error {
+	out, err := exec.Command("git", "check-ref-format", "--branch", branch).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return errors.New(strings.TrimPrefix(msg, "fatal: "))
+		}
+		return err
+	}
+	return nil
+}
+
+// previewBranch works out the branch and working directory a session named
+// (or branched) name would get in repoPath, without creating anything.
+// repoPath must already be resolved against policyWorkDir.
+func previewBranch(name, repoPath string) branchPreview {
+	p := branchPreview{Name: name, RepoPath: repoPath}
+	branch := deriveBranchName(name)
+	if branch == "" {
+		p.Error = "no usable branch name: include at least one letter or digit"
+		return p
+	}
+	if stripped := stripRemotePrefix(repoPath, branch); stripped != branch {
+		p.Changes = append(p.Changes, `remote prefix "`+strings.TrimSuffix(branch, stripped)+`" dropped`)
+		branch = stripped
+	}
+	p.Branch = branch
+	p.Changed = branch != name
+	p.Changes = append(branchNameChanges(name, deriveBranchName(name)), p.Changes...)
+	if err := checkBranchRef(branch); err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	p.Valid = true
+
+	// Same order as createWorktreeInRepo.
+	verify := func(ref string) bool {
+		return exec.Command("git", "-C", repoPath, "rev-parse", "--verify", "--quiet", ref).Run() == nil
+	}
+	p.WorkDir = resolveWorkingDirectory(repoPath, branch)
+	if cur, err := getCurrentBranch(repoPath); err == nil && cur == branch {
+		p.WorkDir, p.Conflict = repoPath, "current"
+	} else if _, err := os.Stat(p.WorkDir); err == nil {
+		p.Conflict = "worktree"
+	} else if verify("refs/heads/" + branch) {
+		p.Conflict = "local"
+	} else if verify("refs/remotes/origin/" + branch) {
+		p.Conflict = "remote"
+	}
+	return p
+}
+
+// handleBranchPreviewAPI serves GET /api/branch/preview?name=[&pwd=]. pwd
+// defaults to the workspace.
+func handleBranchPreviewAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		http.Error(w, "name parameter required", http.StatusBadRequest)
+		return
+	}
+	repoPath := workspaceDir
+	if pwd := r.URL.Query().Get("pwd"); pwd != "" {
+		resolved, err := policyWorkDir.Resolve(pwd)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		repoPath = resolved
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(previewBranch(name, repoPath))
+}
@@ -0,0 +1,331 @@
+// data_purge.go -- delete what swe-swe stored about past sessions and
+// visitors, for a team host that has to honour deletion requests.
+//
+//	DELETE /api/data/purge?before=2026-01-01&repo=myapp[&dryRun=1]
+//	DELETE /api/data/purge?visitor=203.0.113.7
+//
+// Filters combine; at least one is required:
+//
+//	before   sessions started before this date (YYYY-MM-DD in the server's
+//	         time zone, or RFC 3339)
+//	repo     sessions in this repo, named as in usage reports ("workspace"
+//	         for the default workspace)
+//	visitor  a visitor's IP address, the label of the share link they
+//	         joined with, or their Tailscale login
+//
+// Without visitor, the selected sessions are deleted: every recording file
+// (terminal, input, timing, chat history, hooks output, share links), the
+// metadata, the uploads written to the session's .swe-swe/uploads while it
+// ran, and their usage-ledger entries. With visitor, only that visitor's join
+// entries are removed from the selected sessions' metadata (every session's
+// when no other filter is given); the sessions themselves stay.
+//
+// Sessions whose process is still running are skipped and listed as such.
+// The response is a report of what was deleted, or with dryRun=1 what would
+// be.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// purgeFilter selects what DELETE /api/data/purge removes.
+type purgeFilter struct {
+	Before  time.Time // zero = any start time
+	Repo    string
+	Visitor string
+}
+
+// purgeReport is the response of DELETE /api/data/purge.
+type purgeReport struct {
+	DryRun         bool     `json:"dryRun,omitempty"`
+	Recordings     []string `json:"recordings"`     // UUIDs of the deleted sessions
+	Files          []string `json:"files"`          // recording files removed, relative to the recordings dir
+	Uploads        []string `json:"uploads"`        // uploaded files removed
+	UsageEntries   int      `json:"usageEntries"`   // usage-ledger entries removed
+	VisitorEntries int      `json:"visitorEntries"` // visitor join entries removed
+	Skipped        []string `json:"skipped"`        // UUIDs of running sessions left alone
+}
+
+// parsePurgeFilter reads the purge filters from the query string.
+func parsePurgeFilter(q url.Values) (purgeFilter, error) {
+	f := purgeFilter{Repo: strings.TrimSpace(q.Get("repo")), Visitor: strings.TrimSpace(q.Get("visitor"))}
+	if v := strings.TrimSpace(q.Get("before")); v != "" {
+		var err error
+		if f.Before, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			if f.Before, err = time.Parse(time.RFC3339, v); err != nil {
+				return f, errors.New("before must be YYYY-MM-DD or RFC 3339")
+			}
+		}
+	}
+	if f.Before.IsZero() && f.Repo == "" && f.Visitor == "" {
+		return f, errors.New("at least one of before, repo or visitor is required")
+	}
+	return f, nil
+}
+
+// matchesSession reports whether a session that started at startedAt in
+// workDir is selected by the before and repo filters.
+func (f purgeFilter) matchesSession(startedAt time.Time, workDir string) bool {
+	if !f.Before.IsZero() && !startedAt.Before(f.Before) {
+		return false
+	}
+	return f.Repo == "" || usageRepo(workDir) == f.Repo
+}
+
+// matchesVisitor reports whether v is the filtered visitor.
+func (f purgeFilter) matchesVisitor(v Visitor) bool {
+	return v.IP == f.Visitor || (v.Name != "" && v.Name == f.Visitor) || (v.Tailnet != "" && v.Tailnet == f.Visitor)
+}
+
+// purgeData applies f to the recordings dirs and the usage ledger; with
+// dryRun it only reports.
+func purgeData(f purgeFilter, dryRun bool) (purgeReport, error) {
+	report := purgeReport{DryRun: dryRun, Recordings: []string{}, Files: []string{}, Uploads: []string{}, Skipped: []string{}}
+	dirEntries, err := readRecordingEntries()
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+	for _, entry := range dirEntries {
+		stem, ok := strings.CutSuffix(strings.TrimPrefix(entry.Name(), "session-"), ".metadata.json")
+		if !ok || entry.IsDir() || !strings.HasPrefix(entry.Name(), "session-") {
+			continue
+		}
+		// Child recordings go with their parent session.
+		parentUUID, childUUID, ok := parseRecordingFilename(stem)
+		if !ok || childUUID != "" {
+			continue
+		}
+		data, err := os.ReadFile(recordingPath(entry.Name()))
+		if err != nil {
+			continue
+		}
+		var meta RecordingMetadata
+		if json.Unmarshal(data, &meta) != nil || !f.matchesSession(meta.StartedAt, meta.WorkDir) {
+			continue
+		}
+
+		if f.Visitor != "" {
+			n, err := purgeVisitorEntries(parentUUID, f, dryRun)
+			if err != nil {
+				return report, err
+			}
+			report.VisitorEntries += n
+			continue
+		}
+		if recordingActive(parentUUID) {
+			report.Skipped = append(report.Skipped, parentUUID)
+			continue
+		}
+		report.Recordings = append(report.Recordings, parentUUID)
+		report.Uploads = append(report.Uploads, purgeSessionUploads(meta, dryRun)...)
+		report.Files = append(report.Files, purgeRecordingFiles(parentUUID, dryRun)...)
+	}
+
+	if f.Visitor == "" {
+		n, err := purgeUsageEntries(f, report.Recordings, dryRun)
+		if err != nil {
+			return report, err
+		}
+		report.UsageEntries = n
+	}
+	return report, nil
+}
+
+// recordingActive reports whether a live session is still recording to
+// recUUID.
+func recordingActive(recUUID string) bool {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, sess := range sessions {
+		if sess.RecordingUUID == recUUID && sess.Cmd != nil && !sess.exited() {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeRecordingFiles removes every file of recording recUUID and its child
+// recordings, returning their names.
+func purgeRecordingFiles(recUUID string, dryRun bool) []string {
+	dir := recordingDir(recUUID)
+	own, _ := filepath.Glob(dir + "/session-" + recUUID + ".*")
+	children, _ := filepath.Glob(dir + "/session-" + recUUID + "-*")
+	var removed []string
+	for _, path := range append(own, children...) {
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				log.Printf("Purge: %v", err)
+				continue
+			}
+		}
+		removed = append(removed, filepath.Base(path))
+	}
+	if !dryRun {
+		forgetRecordingDir(recUUID)
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// purgeSessionUploads removes the files in the session's .swe-swe/uploads
+// that were written while it ran, returning their paths.
+func purgeSessionUploads(meta RecordingMetadata, dryRun bool) []string {
+	if meta.WorkDir == "" || meta.StartedAt.IsZero() {
+		return nil
+	}
+	end := time.Now()
+	if meta.EndedAt != nil {
+		end = *meta.EndedAt
+	}
+	dir := filepath.Join(meta.WorkDir, ".swe-swe", "uploads")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var removed []string
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(meta.StartedAt) || info.ModTime().After(end) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				log.Printf("Purge: %v", err)
+				continue
+			}
+		}
+		removed = append(removed, path)
+	}
+	return removed
+}
+
+// purgeVisitorEntries removes the filtered visitor's join entries from
+// recording recUUID's metadata, through the live session when there is one.
+func purgeVisitorEntries(recUUID string, f purgeFilter, dryRun bool) (int, error) {
+	keep := func(visitors []Visitor) ([]Visitor, int) {
+		var kept []Visitor
+		for _, v := range visitors {
+			if !f.matchesVisitor(v) {
+				kept = append(kept, v)
+			}
+		}
+		return kept, len(visitors) - len(kept)
+	}
+
+	if sess := liveRecordingSession(recUUID); sess != nil {
+		sess.mu.Lock()
+		kept, n := keep(sess.Metadata.Visitors)
+		if n > 0 && !dryRun {
+			sess.Metadata.Visitors = kept
+		}
+		sess.mu.Unlock()
+		if n == 0 || dryRun {
+			return n, nil
+		}
+		return n, sess.saveMetadata()
+	}
+
+	annotationsMu.Lock()
+	defer annotationsMu.Unlock()
+	metadataPath := recordingDir(recUUID) + "/session-" + recUUID + ".metadata.json"
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return 0, err
+	}
+	var meta RecordingMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return 0, err
+	}
+	kept, n := keep(meta.Visitors)
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	meta.Visitors = kept
+	if data, err = json.MarshalIndent(meta, "", "  "); err != nil {
+		return 0, err
+	}
+	tmp := metadataPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp, metadataPath)
+}
+
+// purgeUsageEntries removes from the usage ledger the entries of the purged
+// recordings and any older entry the filter selects (the ledger outlives
+// deleted recordings), returning how many.
+func purgeUsageEntries(f purgeFilter, purged []string, dryRun bool) (int, error) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	ledger, err := readUsageLedger()
+	if err != nil || len(ledger) == 0 {
+		return 0, err
+	}
+	drop := make(map[string]bool, len(purged))
+	for _, id := range purged {
+		drop[id] = true
+	}
+	var kept []usageEntry
+	for _, e := range ledger {
+		// The ledger stores the repo name, so compare it directly.
+		selected := (f.Before.IsZero() || e.StartedAt.Before(f.Before)) && (f.Repo == "" || e.Repo == f.Repo)
+		if !drop[e.UUID] && !(selected && !recordingActive(e.UUID)) {
+			kept = append(kept, e)
+		}
+	}
+	n := len(ledger) - len(kept)
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for _, e := range kept {
+		if err := enc.Encode(e); err != nil {
+			return 0, err
+		}
+	}
+	tmp := usageLedgerPath() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp, usageLedgerPath())
+}
+
+// handleDataPurgeAPI serves DELETE /api/data/purge.
+func handleDataPurgeAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	f, err := parsePurgeFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun := q.Get("dryRun") == "1" || q.Get("dryRun") == "true"
+	report, err := purgeData(f, dryRun)
+	if err != nil {
+		log.Printf("Purge: %v", err)
+		http.Error(w, "Purge failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !dryRun {
+		log.Printf("Purge (before=%q repo=%q): %d recordings, %d files, %d uploads, %d usage entries, %d visitor entries, %d skipped",
+			q.Get("before"), f.Repo, len(report.Recordings), len(report.Files), len(report.Uploads),
+			report.UsageEntries, report.VisitorEntries, len(report.Skipped))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
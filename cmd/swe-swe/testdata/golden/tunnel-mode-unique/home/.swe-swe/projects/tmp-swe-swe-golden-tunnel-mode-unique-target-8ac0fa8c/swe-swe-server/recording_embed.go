@@ -0,0 +1,255 @@
+// recording_embed.go -- iframe player and oEmbed for recordings.
+//
+// GET /embed/recording/{id} is the recording player without chrome: no
+// table of contents, no footer, and the font scaled so the recorded terminal
+// width fits whatever box the iframe is given. It is the only page that
+// explicitly allows being framed by any site (frame-ancestors *); the
+// homepage and the public share page refuse to be framed.
+//
+// {id} is either
+//
+//   - a recording share token (recording_share.go): public, no cookie, so a
+//     third-party page (Notion, Confluence, a blog) can frame it; or
+//   - a recording UUID: behind the normal login, so it only plays where the
+//     viewer's browser sends the auth cookie (same site, or no login).
+//
+// GET /oembed?url=... answers the oEmbed protocol for share links, embed
+// URLs and -- for a logged-in caller -- plain /recording/{uuid} URLs, with a
+// "rich" iframe snippet. Share pages advertise it with a discovery <link>.
+//
+// Public embed and oEmbed requests skip the login in both deployments:
+// authMiddleware exempts them, and authVerifyHandler answers 200 for them
+// so the compose ForwardAuth lets them through (publicEmbedPath).
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	recordtui "github.com/choonkeat/record-tui/playback"
+)
+
+const (
+	embedRecordingPrefix = "/embed/recording/"
+
+	// Default oEmbed frame size when the consumer sets no max.
+	oembedDefaultWidth  = 800
+	oembedDefaultHeight = 450
+)
+
+// embedFitHead hides what the chromeless player does not need and scales the
+// font so the recorded width fits the frame, on load and on every resize.
+const embedFitHead = `<style>
+    html, body { overflow-x: hidden; }
+    #footer { display: none !important; }
+  </style>
+  <script>
+    function fitEmbed() {
+      var screen = document.querySelector('#terminal .xterm-screen');
+      if (typeof xterm === 'undefined' || !xterm || !screen || !screen.offsetWidth) return;
+      var size = xterm.options.fontSize * window.innerWidth / screen.offsetWidth;
+      xterm.options.fontSize = Math.max(4, Math.min(15, Math.floor(size * 10) / 10));
+    }
+    document.addEventListener('xterm-ready', fitEmbed);
+    window.addEventListener('resize', fitEmbed);
+  </script>
+</head>`
+
+// publicEmbedPath reports whether path is an embed or oEmbed request that
+// carries its own credential (a share token) or checks access itself.
+func publicEmbedPath(path string) bool {
+	if path == "/oembed" {
+		return true
+	}
+	id, ok := strings.CutPrefix(path, embedRecordingPrefix)
+	return ok && isRecordingShareToken(id)
+}
+
+// isRecordingShareToken tells a share token ({uuid}.{id}.{sig}) from a bare
+// recording UUID by shape only; verifyRecordingShareToken does the checking.
+func isRecordingShareToken(id string) bool {
+	return strings.Count(id, ".") == 2 && !strings.Contains(id, "/")
+}
+
+// requestBaseURL is the scheme and host the request came in on.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if resolveCookieSecure(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// requestIsOwner reports whether the request carries a full (not guest)
+// login, or there is no login to carry.
+func requestIsOwner(r *http.Request) bool {
+	secret := os.Getenv("SWE_SWE_PASSWORD")
+	if secret == "" {
+		return true
+	}
+	cookie, err := r.Cookie(authCookieName)
+	if err != nil {
+		return false
+	}
+	scope, valid := authVerifyCookieScoped(cookie.Value, secret)
+	return valid && scope == ""
+}
+
+// validRecordingID accepts the recording IDs /recording/ serves: a UUID, or
+// a parent-child pair for terminal recordings.
+func validRecordingID(id string) bool {
+	return len(id) >= 32 && !strings.ContainsAny(id, "/.")
+}
+
+// handleEmbedRecording serves GET /embed/recording/{token|uuid}.
+func handleEmbedRecording(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, embedRecordingPrefix)
+	recordingUUID, dataURL := id, "/recording/"+id+"/session.log"
+	if isRecordingShareToken(id) {
+		var ok bool
+		if recordingUUID, _, ok = checkRecordingShareToken(w, r, os.Getenv("SWE_SWE_PASSWORD"), id); !ok {
+			return
+		}
+		dataURL = recordingSharePrefix + id + "/session.log"
+	} else if !validRecordingID(id) {
+		http.Error(w, "Invalid UUID", http.StatusBadRequest)
+		return
+	}
+
+	logPath := resolveLogPath("session-" + recordingUUID)
+	if logPath == "" {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	opts := recordingPlaybackOptions(r, recordingUUID, logPath)
+	opts.DataURL = dataURL
+	opts.TOC = nil
+	opts.FooterLink = recordtui.FooterLink{}
+	page, err := recordtui.RenderStreamingHTML(opts)
+	if err != nil {
+		http.Error(w, "Failed to render playback", http.StatusInternalServerError)
+		return
+	}
+	page = strings.Replace(page, "</head>", embedFitHead, 1)
+
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// oembedResponse is an oEmbed 1.0 "rich" response.
+type oembedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	CacheAge     int64  `json:"cache_age,omitempty"`
+}
+
+// handleOEmbed serves GET /oembed?url=URL[&maxwidth=N&maxheight=N&format=json].
+func handleOEmbed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	if f := q.Get("format"); f != "" && f != "json" {
+		http.Error(w, "Only format=json is supported", http.StatusNotImplemented)
+		return
+	}
+	target, err := url.Parse(q.Get("url"))
+	if err != nil || q.Get("url") == "" {
+		http.Error(w, "Missing or invalid url", http.StatusBadRequest)
+		return
+	}
+	var id string
+	for _, prefix := range []string{recordingSharePrefix, embedRecordingPrefix, "/recording/"} {
+		if rest, ok := strings.CutPrefix(target.Path, prefix); ok {
+			id, _, _ = strings.Cut(rest, "/")
+			break
+		}
+	}
+
+	recordingUUID := id
+	var cacheAge int64
+	switch {
+	case isRecordingShareToken(id):
+		var share recordingShare
+		var ok bool
+		if recordingUUID, share, ok = checkRecordingShareToken(w, r, os.Getenv("SWE_SWE_PASSWORD"), id); !ok {
+			return
+		}
+		if share.ExpiresAt != nil {
+			cacheAge = int64(time.Until(*share.ExpiresAt).Seconds())
+		}
+	case validRecordingID(id) && requestIsOwner(r):
+	default:
+		// Same answer for "not a recording URL" and "not yours to see".
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if resolveLogPath("session-"+recordingUUID) == "" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	width, height := oembedDefaultWidth, oembedDefaultHeight
+	if n, err := strconv.Atoi(q.Get("maxwidth")); err == nil && n > 0 && n < width {
+		height = height * n / width
+		width = n
+	}
+	if n, err := strconv.Atoi(q.Get("maxheight")); err == nil && n > 0 && n < height {
+		height = n
+	}
+	title := recordingTitle(recordingUUID)
+	src := requestBaseURL(r) + embedRecordingPrefix + id
+	resp := oembedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		Title:        title,
+		ProviderName: "swe-swe",
+		ProviderURL:  requestBaseURL(r) + "/",
+		HTML: `<iframe src="` + html.EscapeString(src) + `" width="` + strconv.Itoa(width) + `" height="` + strconv.Itoa(height) +
+			`" title="` + html.EscapeString(title) + `" style="border:0" allowfullscreen></iframe>`,
+		Width:    width,
+		Height:   height,
+		CacheAge: cacheAge,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// recordingTitle is the recording's display name, as on the playback page.
+func recordingTitle(recordingUUID string) string {
+	if data, err := os.ReadFile(recordingDir(recordingUUID) + "/session-" + recordingUUID + ".metadata.json"); err == nil {
+		var meta RecordingMetadata
+		if json.Unmarshal(data, &meta) == nil && meta.Name != "" {
+			return meta.Name
+		}
+	}
+	if len(recordingUUID) >= 8 {
+		return "session-" + recordingUUID[:8]
+	}
+	return "session-" + recordingUUID
+}
+
+// oembedDiscoveryLink is the <link> that lets oEmbed consumers find the
+// embed for the page at path.
+func oembedDiscoveryLink(r *http.Request, path string) string {
+	href := requestBaseURL(r) + "/oembed?url=" + url.QueryEscape(requestBaseURL(r)+path)
+	return `  <link rel="alternate" type="application/json+oembed" href="` + html.EscapeString(href) + `">`
+}
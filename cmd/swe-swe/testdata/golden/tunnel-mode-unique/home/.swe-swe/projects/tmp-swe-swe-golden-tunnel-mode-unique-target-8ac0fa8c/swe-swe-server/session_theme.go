@@ -0,0 +1,72 @@
+// session_theme.go -- live light/dark theme switching for a running session.
+//
+// The theme is first captured from the ?theme= WebSocket param at session
+// creation and baked into COLORFGBG for the agent process. A "set_theme"
+// control message lets the UI flip it mid-session: the new value is stored on
+// Session.Theme (so any process swe-swe-server spawns afterwards -- YOLO
+// restart, pendingReplacement -- gets the matching COLORFGBG). If the program
+// in the PTY has asked for the background color with an OSC 11 query since the
+// last report, an OSC 11 report is written to the PTY so theme-aware TUIs
+// (vim, neovim) can re-detect their background without a restart. Without a
+// pending query the report is not sent, as it would reach the program as
+// typed input.
+//
+// Processes that are already running keep the environment they were started
+// with; only the OSC 11 report reaches them.
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/choonkeat/swe-swe/internal/termsnap"
+)
+
+// normalizeTheme validates a client-supplied theme name. Only "light" and
+// "dark" are accepted; anything else (including "system", which the browser
+// resolves before sending) reports ok=false.
+func normalizeTheme(theme string) (string, bool) {
+	switch t := strings.ToLower(strings.TrimSpace(theme)); t {
+	case "light", "dark":
+		return t, true
+	}
+	return "", false
+}
+
+// colorFGBG returns the COLORFGBG value for theme. Unknown themes fall back to
+// dark, matching the session default.
+func colorFGBG(theme string) string {
+	if theme == "light" {
+		return "0;15" // dark-on-light
+	}
+	return "15;0" // light-on-dark
+}
+
+// SetTheme records theme as the session's current theme and, when it differs
+// from the previous value and an OSC 11 query is pending, writes an OSC 11
+// background report to the PTY. Returns changed=false (and no error) for a
+// no-op switch.
+func (s *Session) SetTheme(theme string) (bool, error) {
+	t, ok := normalizeTheme(theme)
+	if !ok {
+		return false, fmt.Errorf("invalid theme %q", theme)
+	}
+	s.mu.Lock()
+	if s.Theme == t {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.Theme = t
+	ptmx := s.PTY
+	s.mu.Unlock()
+
+	if ptmx == nil || !s.bgQueryPending.Swap(false) {
+		return true, nil
+	}
+	report := termsnap.BackgroundReport(t)
+	if _, err := ptmx.Write(report); err != nil {
+		return true, fmt.Errorf("write OSC 11 report: %w", err)
+	}
+	s.recorder.Load().Input(report)
+	return true, nil
+}
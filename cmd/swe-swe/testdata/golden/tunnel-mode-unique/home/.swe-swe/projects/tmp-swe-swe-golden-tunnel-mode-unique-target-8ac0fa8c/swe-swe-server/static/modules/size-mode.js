@@ -0,0 +1,66 @@
+/**
+ * Pure helpers for the per-connection terminal size mode.
+ * A "follow" client's size counts toward the shared PTY size; an
+ * "independent" one is left out and shows the PTY grid scaled to fit its
+ * own viewport (see session_size_mode.go for the server side).
+ * @module size-mode
+ */
+
+export const SIZE_MODE_FOLLOW = 'follow';
+export const SIZE_MODE_INDEPENDENT = 'independent';
+
+/**
+ * Smallest scale an independent view shrinks to; past it the grid is
+ * cropped rather than made unreadable.
+ */
+export const INDEPENDENT_MIN_SCALE = 0.5;
+
+/**
+ * Normalize a stored or requested size mode.
+ * @param {string|null|undefined} value - Raw mode (URL param, localStorage, server)
+ * @returns {string} SIZE_MODE_INDEPENDENT or SIZE_MODE_FOLLOW
+ */
+export function normalizeSizeMode(value) {
+    return value === SIZE_MODE_INDEPENDENT ? SIZE_MODE_INDEPENDENT : SIZE_MODE_FOLLOW;
+}
+
+/**
+ * Scale that fits a terminal of termWidth pixels into viewWidth pixels.
+ * Never enlarges, and never shrinks below minScale.
+ * @param {number} viewWidth - Available width in pixels
+ * @param {number} termWidth - Unscaled terminal width in pixels
+ * @param {number} minScale - Smallest scale to return
+ * @returns {number} Scale factor in [minScale, 1]
+ */
+export function independentViewScale(viewWidth, termWidth, minScale = INDEPENDENT_MIN_SCALE) {
+    if (!(viewWidth > 0) || !(termWidth > 0)) {
+        return 1;
+    }
+    return Math.min(1, Math.max(minScale, viewWidth / termWidth));
+}
+
+/**
+ * Parse a pinned-size input such as "200x50" (columns x rows).
+ * @param {string} value - User input; empty means unpin
+ * @returns {{cols: number, rows: number}|null} Size (0x0 to unpin), or null if invalid
+ */
+export function parsePinnedSize(value) {
+    const v = (value || '').trim();
+    if (v === '') {
+        return { cols: 0, rows: 0 };
+    }
+    const m = /^(\d{1,4})\s*[xX]\s*(\d{1,4})$/.exec(v);
+    if (!m) {
+        return null;
+    }
+    return { cols: Number(m[1]), rows: Number(m[2]) };
+}
+
+/**
+ * Format a status pinnedSize for the settings input.
+ * @param {{cols: number, rows: number}|null|undefined} size - Pinned size from status
+ * @returns {string} "COLSxROWS", or '' when not pinned
+ */
+export function formatPinnedSize(size) {
+    return size && size.cols && size.rows ? `${size.cols}x${size.rows}` : '';
+}
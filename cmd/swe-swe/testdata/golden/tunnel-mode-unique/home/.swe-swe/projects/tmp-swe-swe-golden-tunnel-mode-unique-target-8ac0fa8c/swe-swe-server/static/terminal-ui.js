@@ -1,10 +1,12 @@
 import { formatDuration, formatFileSize, escapeHtml, escapeFilename } from './modules/util.js';
 import { validateUsername, validateSessionName } from './modules/validation.js';
 import { deriveShellUUID } from './modules/uuid.js';
-import { getBaseUrl, buildShellUrl, buildPreviewUrl, buildProxyUrl, buildAgentChatUrl, buildPortBasedPreviewUrl, buildPortBasedAgentChatUrl, buildPortBasedFilesUrl, buildPortBasedProxyUrl, buildSubdomainPreviewUrl, buildSubdomainAgentChatUrl, buildSubdomainFilesUrl, accessedViaTunnel, getDebugQueryString, logicalToVhostLabel, buildVhostPreviewUrl, parseLogicalInput } from './modules/url-builder.js';
+import { getBaseUrl, buildShellUrl, buildPreviewUrl, buildProxyUrl, buildAgentChatUrl, buildPortBasedPreviewUrl, buildPortBasedAgentChatUrl, buildPortBasedFilesUrl, buildPortBasedProxyUrl, buildSubdomainPreviewUrl, buildPreviewDomainUrl, buildSubdomainAgentChatUrl, buildSubdomainFilesUrl, accessedViaTunnel, getDebugQueryString, logicalToVhostLabel, buildVhostPreviewUrl, parseLogicalInput } from './modules/url-builder.js';
 import { dedupePanesAcrossSlots } from './modules/slot-state.js';
-import { OPCODE_CHUNK, encodeResize, encodeFileUpload, isChunkMessage, decodeChunkHeader, parseServerMessage } from './modules/messages.js';
+import { OPCODE_CHUNK, PROTOCOL_VERSION, encodeResize, encodeFileUpload, encodeImagePaste, isChunkMessage, decodeChunkHeader, parseServerMessage } from './modules/messages.js';
 import { createReconnectState, getDelay, nextAttempt, resetAttempts, formatCountdown, probeUntilReady } from './modules/reconnect.js';
+import { createInputQueue, pushInput, ackInput, resumeInput, serializeInputQueue, deserializeInputQueue } from './modules/input-queue.js';
+import { SIZE_MODE_FOLLOW, SIZE_MODE_INDEPENDENT, normalizeSizeMode, independentViewScale, parsePinnedSize, formatPinnedSize } from './modules/size-mode.js';
 import { createQueue, enqueue, dequeue, peek, isEmpty as isQueueEmpty, getQueueCount, getQueueInfo, startUploading, stopUploading, clearQueue } from './modules/upload-queue.js';
 import { createAssembler, addChunk, isComplete, getReceivedCount, assemble, reset as resetAssembler, getProgress } from './modules/chunk-assembler.js';
 import { getStatusBarClasses, renderStatusInfo, renderServiceLinks, renderCustomLinks, renderAssistantLink } from './modules/status-renderer.js';
@@ -247,6 +249,10 @@ class TerminalUI extends HTMLElement {
         this.connectedAt = null;
         this.reconnectState = createReconnectState();
         this.reconnectTimeout = null;
+        // Output resume (output_resume.go): token and byte offset of the
+        // output received, so a reconnect replays only what was missed
+        this.outputResumeToken = '';
+        this.outputOffset = 0;
         // Per-pane iframe load supervisors (files/shell/browser). Each watches
         // its iframe's initial navigation and retries a dropped load -- an
         // <iframe> never auto-retries, so a lost first GET otherwise leaves the
@@ -262,17 +268,27 @@ class TerminalUI extends HTMLElement {
         this.viewers = 0;
         this.ptyRows = 0;
         this.ptyCols = 0;
+        // follow: our size counts toward the PTY size; independent: we show
+        // the PTY grid scaled to fit (see applySizeMode)
+        this.sizeMode = normalizeSizeMode(new URLSearchParams(location.search).get('size') || this.loadSizeMode());
+        // {cols, rows} while the session's PTY size is pinned, shown like an
+        // independent view
+        this.pinnedSize = null;
+        this.queuedPrompts = 0;
         this.assistantName = '';
         this.sessionName = '';
+        this.nameBranch = '';
         this.uuidShort = '';
         this.workDir = '';
         this.previewPort = null;
         this.previewBaseUrl = null;
         this.agentChatPort = null;
+        this.agentChatStatus = null;
         this.sessionUUID = null;
         // Port-based proxy mode state
         this._proxyMode = null; // null = undecided, 'port' = per-port, 'path' = path-based
         this.previewProxyPort = null;
+        this.previewDomainHost = null; // {uuid}.SWE_PREVIEW_DOMAIN, when configured
         this.agentChatProxyPort = null;
         this.filesProxyPort = null;
         this.publicPort = null;
@@ -462,6 +478,7 @@ class TerminalUI extends HTMLElement {
             if (!this.previewMode) {
                 this.initTerminal();
                 this.debugLog('initTerminal done');
+                this.initInputQueue();
                 // iOS Safari needs a brief delay before WebSocket connection
                 // Without this, the connection silently fails (works with Web Inspector attached
                 // because the debugger adds enough delay)
@@ -508,6 +525,10 @@ class TerminalUI extends HTMLElement {
             this.ws.close();
             this.ws = null;
         }
+        if (this._inputChannel) {
+            this._inputChannel.close();
+            this._inputChannel = null;
+        }
         if (this.reconnectTimeout) {
             clearTimeout(this.reconnectTimeout);
         }
@@ -582,6 +603,10 @@ class TerminalUI extends HTMLElement {
                                 <button class="settings-panel__nav-item" role="tab" data-tab="share" aria-selected="false">
                                     <span class="settings-panel__nav-label">Share session</span>
                                 </button>
+                                <span class="settings-panel__nav-section">Troubleshoot</span>
+                                <button class="settings-panel__nav-item" role="tab" data-tab="events" aria-selected="false">
+                                    <span class="settings-panel__nav-label">Server events</span>
+                                </button>
                             </nav>
                             <div class="settings-panel__pane-host">
                                 <!-- PROFILE -->
@@ -628,6 +653,20 @@ class TerminalUI extends HTMLElement {
                                             </div>
                                         </div>
                                     </div>
+                                    <div class="settings-panel__field-row">
+                                        <label class="settings-panel__label">Terminal size</label>
+                                        <div class="settings-panel__theme-toggle" id="settings-size-mode-toggle">
+                                            <button class="settings-panel__theme-btn selected" data-size-mode="follow" type="button">Follow</button>
+                                            <button class="settings-panel__theme-btn" data-size-mode="independent" type="button">Independent</button>
+                                        </div>
+                                    </div>
+                                    <p class="settings-panel__hint settings-panel__hint--inline">Follow sizes the shared terminal to the smallest viewer. Independent leaves this tab out and scales the terminal to fit it instead. Applies now.</p>
+                                    <div class="settings-panel__field-row">
+                                        <label class="settings-panel__label" for="settings-pin-size">Pinned size</label>
+                                        <input type="text" id="settings-pin-size" class="settings-panel__input" placeholder="e.g. 200x50 (empty = off)" maxlength="11">
+                                        <button class="settings-panel__btn settings-panel__btn--secondary" id="settings-pin-size-apply" type="button">Apply</button>
+                                    </div>
+                                    <p class="settings-panel__hint settings-panel__hint--inline">Pins the shared terminal to COLUMNSxROWS for everyone, whoever connects, until cleared. Smaller screens see it scaled. Applies now.</p>
                                     <div class="settings-panel__pane-footer">
                                         <span class="settings-panel__pane-status" id="settings-appearance-status">Live preview &mdash; not yet saved</span>
                                         <button class="settings-panel__btn settings-panel__btn--secondary" id="settings-appearance-revert" type="button">Revert</button>
@@ -717,7 +756,23 @@ class TerminalUI extends HTMLElement {
                                 <!-- SHARE SESSION -->
                                 <section class="settings-panel__pane" data-pane="share" role="tabpanel" hidden>
                                     <h3 class="settings-panel__pane-title">Share this session</h3>
-                                    <p class="settings-panel__pane-sub">Create a link and password that let one other person join <strong>this</strong> session as a full participant. They can only reach this session &mdash; not the other sessions on the homepage, not new sessions, not recordings. The link stops working the moment this session ends.</p>
+                                    <p class="settings-panel__pane-sub">Create a link that lets someone else into <strong>this</strong> session. They can only reach this session &mdash; not the other sessions on the homepage, not new sessions, not recordings. Every link stops working the moment this session ends.</p>
+                                    <div class="settings-panel__field-row">
+                                        <label class="settings-panel__label" for="settings-share-scope">Access</label>
+                                        <select id="settings-share-scope" class="settings-panel__input">
+                                            <option value="view">View only (link)</option>
+                                            <option value="control">Full control (link)</option>
+                                            <option value="password">Full control (link + password, no expiry)</option>
+                                        </select>
+                                    </div>
+                                    <div class="settings-panel__field-row" id="settings-share-expiry-row">
+                                        <label class="settings-panel__label" for="settings-share-expiry">Expires after</label>
+                                        <select id="settings-share-expiry" class="settings-panel__input">
+                                            <option value="3600">1 hour</option>
+                                            <option value="86400" selected>24 hours</option>
+                                            <option value="604800">7 days</option>
+                                        </select>
+                                    </div>
                                     <div class="settings-panel__pane-footer">
                                         <span class="settings-panel__pane-status" id="settings-share-status"></span>
                                         <button class="settings-panel__btn settings-panel__btn--primary" id="settings-share-create" type="button">Create share link</button>
@@ -730,14 +785,40 @@ class TerminalUI extends HTMLElement {
                                                 <button class="settings-panel__btn settings-panel__btn--secondary" data-copy-target="settings-share-url" type="button">Copy</button>
                                             </div>
                                         </div>
-                                        <div class="settings-panel__field-row settings-panel__field-row--stacked">
+                                        <div class="settings-panel__field-row settings-panel__field-row--stacked" id="settings-share-password-row">
                                             <label class="settings-panel__label" for="settings-share-password">Password</label>
                                             <div class="settings-panel__share-copyrow">
                                                 <input type="text" id="settings-share-password" class="settings-panel__input" readonly>
                                                 <button class="settings-panel__btn settings-panel__btn--secondary" data-copy-target="settings-share-password" type="button">Copy</button>
                                             </div>
                                         </div>
-                                        <p class="settings-panel__hint settings-panel__hint--inline">Send the link and password to your guest over a trusted channel. Anyone with both can act as a full participant in this session.</p>
+                                        <p class="settings-panel__hint settings-panel__hint--inline" id="settings-share-hint"></p>
+                                    </div>
+                                    <h4 class="settings-panel__label settings-panel__share-list-title">Active links</h4>
+                                    <ul class="settings-panel__share-list" id="settings-share-list"></ul>
+                                    <h4 class="settings-panel__label settings-panel__share-list-title">Live broadcast</h4>
+                                    <p class="settings-panel__hint settings-panel__hint--inline">For live demos: anyone with the watch link can follow this terminal without logging in, but cannot type or resize it. Hundreds of viewers cost the session about as much as one.</p>
+                                    <div class="settings-panel__field-row settings-panel__field-row--stacked" id="settings-broadcast-url-row" hidden>
+                                        <label class="settings-panel__label" for="settings-broadcast-url">Watch link</label>
+                                        <div class="settings-panel__share-copyrow">
+                                            <input type="text" id="settings-broadcast-url" class="settings-panel__input" readonly>
+                                            <button class="settings-panel__btn settings-panel__btn--secondary" data-copy-target="settings-broadcast-url" type="button">Copy</button>
+                                        </div>
+                                    </div>
+                                    <div class="settings-panel__pane-footer">
+                                        <span class="settings-panel__pane-status" id="settings-broadcast-status"></span>
+                                        <button class="settings-panel__btn settings-panel__btn--primary" id="settings-broadcast-toggle" type="button">Start broadcast</button>
+                                    </div>
+                                </section>
+
+                                <!-- SERVER EVENTS -->
+                                <section class="settings-panel__pane" data-pane="events" role="tabpanel" hidden>
+                                    <h3 class="settings-panel__pane-title">Server events</h3>
+                                    <p class="settings-panel__pane-sub">What swe-swe-server has logged about this session &mdash; connects, snapshot sends, PTY and broadcast errors. The last 500 events are kept while the session runs.</p>
+                                    <ol class="settings-panel__events" id="settings-events-list"></ol>
+                                    <div class="settings-panel__pane-footer">
+                                        <span class="settings-panel__pane-status" id="settings-events-status"></span>
+                                        <button class="settings-panel__btn settings-panel__btn--secondary" id="settings-events-refresh" type="button">Refresh</button>
                                     </div>
                                 </section>
                             </div>
@@ -819,6 +900,7 @@ class TerminalUI extends HTMLElement {
                         <a href="/" class="terminal-ui__back-btn" title="Back to sessions">←</a>
                         <div class="terminal-ui__session-info">
                             <span class="terminal-ui__session-name" title="Click to rename session">Session</span>
+                            <span class="terminal-ui__agent-state" hidden></span>
                             <span class="terminal-ui__branch-badge desktop-only"></span>
                         </div>
                         <span class="terminal-ui__connection-status">
@@ -836,6 +918,7 @@ class TerminalUI extends HTMLElement {
                             <div class="terminal-ui__preset-picker"></div>
                         </div>
                         <div class="terminal-ui__header-sep desktop-only" aria-hidden="true"></div>
+                        <button class="terminal-ui__bookmark-btn desktop-only" title="Bookmark this moment in the recording">&#128278;</button>
                         <button class="terminal-ui__chat-btn desktop-only" title="Chat with viewers" style="display: none;">
                             <span class="terminal-ui__chat-icon">💬</span>
                             <span class="terminal-ui__chat-badge" style="display: none;">0</span>
@@ -970,6 +1053,15 @@ class TerminalUI extends HTMLElement {
                     </div>
                 </div>
 
+                <div class="approval-panel" hidden>
+                    <div class="approval-panel__question"></div>
+                    <pre class="approval-panel__context"></pre>
+                    <div class="approval-panel__buttons">
+                        <button class="approval-panel__approve">Approve</button>
+                        <button class="approval-panel__deny">Deny</button>
+                    </div>
+                </div>
+
                 <div class="mobile-keyboard">
                     <div class="mobile-keyboard__main">
                         <button data-key="Escape">Esc</button>
@@ -1003,6 +1095,7 @@ class TerminalUI extends HTMLElement {
                         </button>
                         <input type="file" class="mobile-keyboard__file-input" multiple hidden>
                         <textarea rows="1" placeholder="Type command..." class="mobile-keyboard__text" autocomplete="off"></textarea>
+                        <button class="mobile-keyboard__queue" title="Type this once the agent is idle">Queue</button>
                         <button class="mobile-keyboard__send">Enter</button>
                     </div>
                 </div>
@@ -1312,6 +1405,46 @@ class TerminalUI extends HTMLElement {
         if (this.ws && this.ws.readyState === WebSocket.OPEN) {
             this.ws.send(encodeResize(this.term.rows, this.term.cols));
         }
+        this.applySizeMode();
+    }
+
+    loadSizeMode() {
+        try { return localStorage.getItem('swe-swe-size-mode'); }
+        catch (e) { return null; }
+    }
+
+    // setSizeMode switches this connection between following the shared PTY
+    // size and an independent, scaled view. Remembered for later sessions.
+    setSizeMode(mode) {
+        this.sizeMode = normalizeSizeMode(mode);
+        try { localStorage.setItem('swe-swe-size-mode', this.sizeMode); }
+        catch (e) { /* storage disabled -- this page only */ }
+        this.sendJSON({ type: 'set_size_mode', data: { mode: this.sizeMode } });
+        if (this.sizeMode === SIZE_MODE_FOLLOW && this.term && this.fitAddon) {
+            this.applySizeMode();
+            this.fitAndPreserveScroll();
+        }
+        this.populateSizeModeToggle();
+    }
+
+    // applySizeMode keeps an independent view -- or any view while the size
+    // is pinned -- at the PTY's grid, scaled down to the pane width (cropped
+    // below INDEPENDENT_MIN_SCALE). The fitted size is still reported first,
+    // so switching back to follow is instant.
+    applySizeMode() {
+        const el = this.term && this.term.element;
+        if (!el) return;
+        const scaled = this.sizeMode === SIZE_MODE_INDEPENDENT || !!this.pinnedSize;
+        if (!scaled || !this.ptyCols || !this.ptyRows) {
+            el.style.transform = '';
+            return;
+        }
+        if (this.term.cols !== this.ptyCols || this.term.rows !== this.ptyRows) {
+            this.term.resize(this.ptyCols, this.ptyRows);
+        }
+        const scale = independentViewScale(el.parentElement.clientWidth, el.scrollWidth);
+        el.style.transformOrigin = 'top left';
+        el.style.transform = scale < 1 ? `scale(${scale})` : '';
     }
 
     // Fit terminal and preserve scroll position, unless user is near bottom
@@ -1349,6 +1482,8 @@ class TerminalUI extends HTMLElement {
 
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
         let url = protocol + '//' + window.location.host + '/ws/' + this.uuid + '?assistant=' + encodeURIComponent(this.assistant);
+        // Protocol handshake: the server refuses a version it no longer speaks
+        url += '&proto=' + PROTOCOL_VERSION;
         // Forward name param from page URL to WebSocket URL (for session naming)
         const nameParam = new URLSearchParams(location.search).get('name');
         if (nameParam) {
@@ -1382,6 +1517,19 @@ class TerminalUI extends HTMLElement {
         // Forward current theme to server so shell env (COLORFGBG) matches
         const currentTheme = document.documentElement.getAttribute('data-theme') || 'dark';
         url += '&theme=' + encodeURIComponent(currentTheme);
+        // Sequenced input stream, so input lost with a dropped connection is
+        // resent on reconnect (see initInputQueue)
+        if (this.inputQueue) {
+            url += '&input=' + encodeURIComponent(this.inputQueue.id);
+        }
+        if (this.sizeMode === SIZE_MODE_INDEPENDENT) {
+            url += '&size=' + SIZE_MODE_INDEPENDENT;
+        }
+        // Resume token from the last connection: the server then sends only
+        // the output missed since outputOffset (see 'output_resume')
+        if (this.outputResumeToken) {
+            url += '&resume=' + encodeURIComponent(this.outputResumeToken) + '&offset=' + this.outputOffset;
+        }
 
         this.debugLog('Creating WebSocket to: ' + url);
         console.log('[WS] Connecting to', url);
@@ -1431,6 +1579,7 @@ class TerminalUI extends HTMLElement {
                 if (isChunkMessage(data)) {
                     this.handleChunk(data);
                 } else {
+                    this.outputOffset += data.length;
                     this.onTerminalData(data);
                 }
             } else if (typeof event.data === 'string') {
@@ -1470,6 +1619,29 @@ class TerminalUI extends HTMLElement {
             // arrived as a session_error JSON message just before close --
             // prefer it over the truncated close-reason field.
             if (event.code === 4002) {
+                // This script is older (or newer) than the server: reload to
+                // get the matching one, but only once a minute so a server
+                // that keeps refusing cannot trap the tab in a reload loop.
+                if (this._sessionErrorCode === 'protocol_mismatch') {
+                    const last = Number(sessionStorage.getItem('swe-swe-protocol-reload') || 0);
+                    if (Date.now() - last > 60000) {
+                        sessionStorage.setItem('swe-swe-protocol-reload', String(Date.now()));
+                        window.location.reload();
+                        return;
+                    }
+                }
+                // The login cookie expired: the page has to log in again.
+                if (this._sessionErrorCode === 'auth_required' && this._sessionErrorLogin) {
+                    window.location.href = this._sessionErrorLogin;
+                    return;
+                }
+                // Retryable (a full port pool, the session limit, low memory):
+                // the server kept the creation intent, so try again later.
+                if (this._sessionErrorRetryable) {
+                    this.updateStatus('error', this._sessionErrorMsg || reason);
+                    setTimeout(() => this.scheduleReconnect(), 3000);
+                    return;
+                }
                 this.processExited = true;
                 this.updateStatus('error', this._sessionErrorMsg || reason || 'Could not create session');
                 return;
@@ -1638,8 +1810,88 @@ class TerminalUI extends HTMLElement {
         }
     }
 
+    // Sequenced input: every input frame (keystrokes, uploads, image pastes)
+    // is numbered and kept until the server acks it. On (re)connect the server
+    // says how far it got (input_resume) and the rest is resent, so a dropped
+    // connection loses nothing and repeats nothing. The queue is mirrored in
+    // sessionStorage so a restored tab resumes too.
+    initInputQueue() {
+        this._inputQueueKey = 'swe-swe-input:' + this.uuid;
+        let stored = null;
+        try { stored = deserializeInputQueue(sessionStorage.getItem(this._inputQueueKey)); }
+        catch (e) { /* storage disabled -- queue is page-only */ }
+        this.inputQueue = stored || createInputQueue(this.newInputStreamId());
+        // A duplicated tab inherits sessionStorage. If another open tab
+        // answers for the restored id, it is that tab's stream: start afresh
+        // (this runs well before the first connect).
+        if (typeof BroadcastChannel !== 'undefined') {
+            this._inputChannel = new BroadcastChannel('swe-swe-input');
+            this._inputChannel.onmessage = (e) => {
+                const m = e.data || {};
+                if (m.id !== this.inputQueue.id) return;
+                if (m.type === 'probe') {
+                    this._inputChannel.postMessage({ type: 'in-use', id: m.id });
+                } else if (m.type === 'in-use' && !this.ws) {
+                    this.inputQueue = createInputQueue(this.newInputStreamId());
+                    this.saveInputQueue();
+                }
+            };
+            if (stored) {
+                this._inputChannel.postMessage({ type: 'probe', id: stored.id });
+            }
+        }
+    }
+
+    newInputStreamId() {
+        const b = crypto.getRandomValues(new Uint8Array(16));
+        return Array.from(b, x => x.toString(16).padStart(2, '0')).join('');
+    }
+
+    saveInputQueue() {
+        try { sessionStorage.setItem(this._inputQueueKey, serializeInputQueue(this.inputQueue)); }
+        catch (e) { /* out of quota / disabled -- queue is page-only */ }
+    }
+
+    // sendInput sends an input frame over the open WebSocket, sequenced when
+    // the input queue is on.
+    sendInput(frame) {
+        if (!this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+        if (!this.inputQueue) {
+            this.ws.send(frame);
+            return;
+        }
+        const r = pushInput(this.inputQueue, frame);
+        this.inputQueue = r.state;
+        this.saveInputQueue();
+        this.ws.send(r.message);
+    }
+
     handleJSONMessage(msg) {
         switch (msg.type) {
+            case 'input_resume':
+                if (this.inputQueue) {
+                    const r = resumeInput(this.inputQueue, msg.seq || 0);
+                    this.inputQueue = r.state;
+                    this.saveInputQueue();
+                    if (r.messages.length > 0) {
+                        console.log(`[WS] Resending ${r.messages.length} input frame(s) after reconnect`);
+                    }
+                    for (const m of r.messages) {
+                        this.ws.send(m);
+                    }
+                }
+                break;
+            case 'size_mode':
+                this.sizeMode = normalizeSizeMode(msg.mode);
+                this.applySizeMode();
+                this.populateSizeModeToggle();
+                break;
+            case 'input_ack':
+                if (this.inputQueue) {
+                    this.inputQueue = ackInput(this.inputQueue, msg.seq || 0);
+                    this.saveInputQueue();
+                }
+                break;
             case 'pong':
                 // Heartbeat response
                 if (msg.data && msg.data.ts) {
@@ -1647,12 +1899,101 @@ class TerminalUI extends HTMLElement {
                     console.log(`Heartbeat pong: ${latency}ms`);
                 }
                 break;
+            case 'bookmark_added':
+                this.showStatusNotification('Bookmarked at ' + Math.round(msg.annotation.timestampSeconds) + 's');
+                break;
+            case 'bookmark_error':
+                this.showStatusNotification('Bookmark failed: ' + msg.message, 5000);
+                break;
+            case 'prompt_queued':
+                this.showStatusNotification(msg.queued === 1 ? 'Prompt queued' : `Prompt queued (${msg.queued} waiting)`);
+                break;
+            case 'prompt_error':
+                this.showStatusNotification('Prompt not queued: ' + msg.message, 5000);
+                break;
+            case 'base_branch_moved':
+                // A background fetch moved the branch this worktree was cut
+                // from (repo_fetch.go).
+                this.showStatusNotification(msg.upstream + ' has ' + msg.behind + ' commit' +
+                    (msg.behind === 1 ? '' : 's') + ' this worktree lacks. Consider rebasing onto it.', 10000);
+                break;
+            case 'preview_app':
+                // The repo's dev server (session_preview_app.go).
+                if (msg.status === 'crashed') {
+                    this.showStatusNotification('Preview app crashed' + (msg.error ? ': ' + msg.error : ''), 10000);
+                } else if (msg.status === 'restarting') {
+                    this.showStatusNotification('Preview app exited (code ' + msg.exitCode + '), restarting...', 5000);
+                }
+                break;
+            case 'preview_app_error':
+                this.showStatusNotification('Preview app not started: ' + msg.message, 5000);
+                break;
+            case 'copy_ready': {
+                // A large selection, extracted by the server (session_copy.go):
+                // download it, since the clipboard copy may have been cut short.
+                const a = document.createElement('a');
+                a.href = msg.url;
+                a.download = '';
+                document.body.appendChild(a);
+                a.click();
+                a.remove();
+                this.showStatusNotification('Large selection: downloading ' + msg.lines + ' lines as a text file', 4000);
+                break;
+            }
+            case 'copy_error':
+                this.showStatusNotification('Large selection not copied: ' + msg.message, 5000);
+                break;
+            case 'hibernation':
+                // The session was hibernated while idle and is starting its
+                // agent again (session_hibernate.go).
+                if (msg.state === 'resuming') {
+                    this.showStatusNotification('Resuming hibernated session...', 10000);
+                } else if (msg.state === 'awake') {
+                    this.showStatusNotification('Session resumed');
+                } else if (msg.state === 'failed') {
+                    this.showStatusNotification('Session not resumed: ' + msg.message, 10000);
+                }
+                break;
+            case 'approval_request':
+                // The agent asks to run a tool (approval_relay.go).
+                this.showApprovalRequest(msg);
+                break;
+            case 'approval_resolved':
+                this.hideApprovalRequest(msg.id);
+                break;
+            case 'approval_error':
+                this.hideApprovalRequest(msg.id);
+                this.showStatusNotification('Not answered: ' + msg.message, 5000);
+                break;
+            case 'rename_error':
+                // rename_session refused: an invalid name, or one another
+                // session has under SWE_SESSION_NAMES=reject (session_names.go).
+                this.showStatusNotification('Not renamed: ' + msg.message, 8000);
+                break;
+            case 'output_resume':
+                // Output position for resuming after a dropped connection
+                // (output_resume.go). Plain output frames after this count
+                // from offset; scrollback and snapshot chunks do not count.
+                this.outputResumeToken = typeof msg.token === 'string' ? msg.token : '';
+                this.outputOffset = typeof msg.offset === 'number' ? msg.offset : 0;
+                break;
+            case 'protocol':
+                // Server's protocol handshake (ws_protocol.go). A newer server
+                // still speaks our version; suggest a reload for the new UI.
+                if (typeof msg.version === 'number' && msg.version > PROTOCOL_VERSION) {
+                    this.showStatusNotification('swe-swe was updated. Reload the page to get the new version.', 10000);
+                }
+                break;
             case 'session_error':
                 // Fatal error from the server (e.g. worktree creation failed).
                 // Stash the full text so the onclose 4002 handler can display it
                 // -- the WS close-reason field would otherwise truncate the
                 // most useful tail of git's output.
                 this._sessionErrorMsg = typeof msg.message === 'string' ? msg.message : '';
+                // Machine-readable reason (ws_errors.go), e.g. worktree_conflict.
+                this._sessionErrorCode = typeof msg.code === 'string' ? msg.code : '';
+                this._sessionErrorRetryable = msg.retryable === true;
+                this._sessionErrorLogin = typeof msg.login === 'string' ? msg.login : '';
                 break;
             case 'session_gone':
                 // No live session and no creation intent for this UUID: a stale
@@ -1810,10 +2151,22 @@ class TerminalUI extends HTMLElement {
                 this.viewers = msg.viewers || 0;
                 this.ptyCols = msg.cols || 0;
                 this.ptyRows = msg.rows || 0;
+                const wasPinned = !!this.pinnedSize;
+                this.pinnedSize = msg.pinnedSize || null;
+                this.updateQueuedPrompts(msg.queuedPrompts || 0);
+                this.updateAgentState(msg.agentState || '');
+                this.applySizeMode();
+                if (wasPinned && !this.pinnedSize && this.sizeMode === SIZE_MODE_FOLLOW && this.fitAddon) {
+                    // Unpinned: back to our own fitted size
+                    this.fitAndPreserveScroll();
+                }
                 if (msg.assistant) {
                     this.assistantName = msg.assistant;
                 }
                 this.sessionName = msg.sessionName || '';
+                // Named after the first prompt (SWE_SESSION_AUTONAME); the
+                // branch-safe form is shown, the branch itself is unchanged.
+                this.nameBranch = msg.autoNamed ? (msg.nameBranch || '') : '';
                 this.uuidShort = msg.uuidShort || '';
                 const prevWorkDir = this.workDir;
                 this.workDir = msg.workDir || '';
@@ -1827,7 +2180,9 @@ class TerminalUI extends HTMLElement {
                 this.agentChatPort = msg.agentChatPort || null;
                 this.sessionUUID = msg.sessionUUID || null;
                 this.previewProxyPort = msg.previewProxyPort || null;
+                this.previewDomainHost = msg.previewDomainHost || null;
                 this.agentChatProxyPort = msg.agentChatProxyPort || null;
+                this.updateAgentChatStatus(msg.agentChatStatus);
                 this.filesProxyPort = msg.filesProxyPort || null;
                 this.publicPort = msg.publicPort || null;
                 this.cdpPort = msg.cdpPort || null;
@@ -2069,6 +2424,13 @@ class TerminalUI extends HTMLElement {
                     this.addChatMessage(msg.userName, msg.text, isOwn);
                 }
                 break;
+            case 'session_message':
+                // Cross-session bus message (session_bus.go) to or from this
+                // session's agent
+                if (msg.message && msg.message.text) {
+                    this.addSessionBusMessage(msg.direction, msg.message);
+                }
+                break;
             case 'file_upload':
                 // File upload response
                 if (msg.success) {
@@ -2182,6 +2544,9 @@ class TerminalUI extends HTMLElement {
 
         if (sessionNameEl) {
             sessionNameEl.textContent = this.sessionName || this.uuidShort || 'Session';
+            sessionNameEl.title = this.nameBranch
+                ? `Named after the first prompt (as a branch: ${this.nameBranch}). Click to rename session`
+                : 'Click to rename session';
         }
 
         if (viewersEl && isConnected) {
@@ -2342,6 +2707,78 @@ class TerminalUI extends HTMLElement {
         }
     }
 
+    // Show whether the agent is working, idle at its prompt, or asking to
+    // run a tool (agent_state.go) next to the session name.
+    updateAgentState(state) {
+        const el = this.querySelector('.terminal-ui__agent-state');
+        if (!el) return;
+        const labels = {
+            busy: ['working', 'The agent is working'],
+            idle: ['idle', 'The agent is waiting for your reply'],
+            waiting_approval: ['needs approval', 'The agent is asking to run a tool'],
+        };
+        const label = labels[state];
+        el.hidden = !label;
+        if (!label) return;
+        el.textContent = label[0];
+        el.title = label[1];
+        el.dataset.state = state;
+        if (state !== 'waiting_approval') this.hideApprovalRequest();
+    }
+
+    // Show the agent's approval prompt with big buttons that answer it; the
+    // server types the agent's keys (approval_relay.go).
+    showApprovalRequest(req) {
+        const panel = this.querySelector('.approval-panel');
+        if (!panel) return;
+        this.approvalId = req.id;
+        panel.querySelector('.approval-panel__question').textContent = req.question;
+        const context = panel.querySelector('.approval-panel__context');
+        context.textContent = (req.context || []).join('\n');
+        context.hidden = !context.textContent;
+        panel.querySelector('.approval-panel__deny').hidden = !req.canDeny;
+        panel.querySelectorAll('button').forEach(b => { b.disabled = false; });
+        panel.hidden = false;
+    }
+
+    // Hide the approval prompt, or only the one with this id.
+    hideApprovalRequest(id) {
+        if (id && id !== this.approvalId) return;
+        this.approvalId = null;
+        const panel = this.querySelector('.approval-panel');
+        if (panel) panel.hidden = true;
+    }
+
+    answerApproval(decision) {
+        if (!this.approvalId) return;
+        this.querySelectorAll('.approval-panel button').forEach(b => { b.disabled = true; });
+        this.sendJSON({ type: decision, id: this.approvalId, userName: this.currentUserName });
+    }
+
+    // Show how many queued prompts are waiting for the agent on the input
+    // bar's Queue button.
+    updateQueuedPrompts(n) {
+        this.queuedPrompts = n;
+        const queueBtn = this.querySelector('.mobile-keyboard__queue');
+        if (queueBtn) {
+            queueBtn.textContent = n > 0 ? `Queue (${n})` : 'Queue';
+        }
+    }
+
+    // Pin a note at the current moment of the session's recording; it shows
+    // up as a chapter marker in playback.
+    promptBookmark() {
+        const text = window.prompt('Bookmark this moment in the recording:', '');
+        if (text === null || !text.trim()) {
+            return;
+        }
+        this.sendJSON({
+            type: 'bookmark',
+            text: text.trim(),
+            userName: this.currentUserName
+        });
+    }
+
     toggleYoloMode() {
         if (!this.yoloSupported) {
             return;
@@ -2506,6 +2943,7 @@ class TerminalUI extends HTMLElement {
 
         // Theme mode toggle (light/dark/system) -- live preview only
         this.setupThemeToggle();
+        this.setupSizeModeToggle();
 
         // Theme color picker -- live preview only
         this.setupColorPicker();
@@ -2520,11 +2958,29 @@ class TerminalUI extends HTMLElement {
             apprRevert.addEventListener('click', () => this._revertAppearance());
         }
 
+        const eventsRefresh = panel.querySelector('#settings-events-refresh');
+        if (eventsRefresh) {
+            eventsRefresh.addEventListener('click', () => this._loadSessionEvents());
+        }
+
         // Share pane: create the guest link, plus per-field copy buttons.
         const shareCreate = panel.querySelector('#settings-share-create');
         if (shareCreate) {
             shareCreate.addEventListener('click', () => this._createShareLink());
         }
+        const broadcastToggle = panel.querySelector('#settings-broadcast-toggle');
+        if (broadcastToggle) {
+            broadcastToggle.addEventListener('click', () => {
+                this._loadBroadcast(broadcastToggle.dataset.enabled === 'true' ? 'DELETE' : 'POST');
+            });
+        }
+        const shareScope = panel.querySelector('#settings-share-scope');
+        const shareExpiryRow = panel.querySelector('#settings-share-expiry-row');
+        if (shareScope && shareExpiryRow) {
+            shareScope.addEventListener('change', () => {
+                shareExpiryRow.hidden = shareScope.value === 'password';
+            });
+        }
         panel.querySelectorAll('[data-copy-target]').forEach(btn => {
             btn.addEventListener('click', () => {
                 const el = panel.querySelector('#' + btn.dataset.copyTarget);
@@ -2955,6 +3411,13 @@ class TerminalUI extends HTMLElement {
         if (tab === 'env') {
             this.populateEnvSection();
         }
+        if (tab === 'events') {
+            this._loadSessionEvents();
+        }
+        if (tab === 'share') {
+            this._loadShareLinks();
+            this._loadBroadcast('GET');
+        }
     }
 
     // Render a warning at the top of the SSH Signing pane when the
@@ -3060,7 +3523,12 @@ class TerminalUI extends HTMLElement {
         const result = panel.querySelector('#settings-share-result');
         const urlInput = panel.querySelector('#settings-share-url');
         const pwInput = panel.querySelector('#settings-share-password');
+        const pwRow = panel.querySelector('#settings-share-password-row');
+        const hint = panel.querySelector('#settings-share-hint');
         const btn = panel.querySelector('#settings-share-create');
+        const scopeSel = panel.querySelector('#settings-share-scope');
+        const expirySel = panel.querySelector('#settings-share-expiry');
+        const scope = scopeSel ? scopeSel.value : 'password';
 
         const uuid = this.sessionUUID;
         if (!uuid) {
@@ -3077,19 +3545,34 @@ class TerminalUI extends HTMLElement {
         }
         if (btn) btn.disabled = true;
 
-        fetch('/api/session/' + encodeURIComponent(uuid) + '/share', { method: 'POST' })
+        // No body = the session's share password; a body = an expiring link.
+        const init = { method: 'POST' };
+        if (scope !== 'password') {
+            init.headers = { 'Content-Type': 'application/json' };
+            init.body = JSON.stringify({ scope: scope, expiresIn: Number(expirySel ? expirySel.value : 86400) });
+        }
+        fetch('/api/session/' + encodeURIComponent(uuid) + '/share', init)
             .then(resp => {
-                if (!resp.ok) throw new Error('HTTP ' + resp.status);
+                if (!resp.ok) return resp.text().then(t => { throw new Error(t.trim() || 'HTTP ' + resp.status); });
                 return resp.json();
             })
             .then(data => {
+                const withPassword = scope === 'password';
                 if (urlInput) urlInput.value = data.url || '';
                 if (pwInput) pwInput.value = data.password || '';
+                if (pwRow) pwRow.hidden = !withPassword;
+                if (hint) {
+                    hint.textContent = withPassword
+                        ? 'Send the link and password to your guest over a trusted channel. Anyone with both can act as a full participant in this session.'
+                        : 'Anyone with this link can ' + (scope === 'view' ? 'watch' : 'act as a full participant in') +
+                          ' this session until ' + new Date(data.expiresAt).toLocaleString() + ', or until you revoke it below.';
+                }
                 if (result) result.removeAttribute('hidden');
                 if (status) {
-                    status.textContent = 'Link ready. Send both to your guest.';
+                    status.textContent = withPassword ? 'Link ready. Send both to your guest.' : 'Link ready.';
                     status.setAttribute('data-state', 'ok');
                 }
+                if (!withPassword) this._loadShareLinks();
             })
             .catch(err => {
                 if (status) {
@@ -3102,6 +3585,153 @@ class TerminalUI extends HTMLElement {
             });
     }
 
+    // List the session's live share links, each with a Revoke button.
+    // Rendered with textContent only: labels are user input.
+    _loadShareLinks() {
+        const panel = this.querySelector('.settings-panel');
+        if (!panel) return;
+        const list = panel.querySelector('#settings-share-list');
+        const uuid = this.sessionUUID;
+        if (!list || !uuid) return;
+        const base = '/api/session/' + encodeURIComponent(uuid) + '/shares';
+        fetch(base, { cache: 'no-store' })
+            .then(resp => {
+                if (!resp.ok) throw new Error('HTTP ' + resp.status);
+                return resp.json();
+            })
+            .then(data => {
+                const shares = data.shares || [];
+                if (shares.length === 0) {
+                    const li = document.createElement('li');
+                    li.className = 'settings-panel__share-item settings-panel__share-item--empty';
+                    li.textContent = 'No active links.';
+                    list.replaceChildren(li);
+                    return;
+                }
+                list.replaceChildren(...shares.map(sh => {
+                    const li = document.createElement('li');
+                    li.className = 'settings-panel__share-item';
+                    const desc = document.createElement('span');
+                    desc.textContent = (sh.scope === 'view' ? 'View only' : 'Full control') +
+                        (sh.label ? ' - ' + sh.label : '') +
+                        ', expires ' + new Date(sh.expiresAt).toLocaleString();
+                    const revoke = document.createElement('button');
+                    revoke.type = 'button';
+                    revoke.className = 'settings-panel__btn settings-panel__btn--secondary';
+                    revoke.textContent = 'Revoke';
+                    revoke.addEventListener('click', () => {
+                        revoke.disabled = true;
+                        fetch(base + '/' + encodeURIComponent(sh.id), { method: 'DELETE' })
+                            .finally(() => this._loadShareLinks());
+                    });
+                    li.append(desc, revoke);
+                    return li;
+                }));
+            })
+            .catch(() => {
+                list.replaceChildren();
+            });
+    }
+
+    // Read (GET), start (POST) or stop (DELETE) the session's broadcast and
+    // show its watch link and viewer count.
+    _loadBroadcast(method) {
+        const panel = this.querySelector('.settings-panel');
+        if (!panel) return;
+        const btn = panel.querySelector('#settings-broadcast-toggle');
+        const urlRow = panel.querySelector('#settings-broadcast-url-row');
+        const urlInput = panel.querySelector('#settings-broadcast-url');
+        const status = panel.querySelector('#settings-broadcast-status');
+        const uuid = this.sessionUUID;
+        if (!btn || !uuid) return;
+        btn.disabled = true;
+        fetch('/api/session/' + encodeURIComponent(uuid) + '/broadcast', { method: method, cache: 'no-store' })
+            .then(resp => {
+                if (!resp.ok) return resp.text().then(t => { throw new Error(t.trim() || 'HTTP ' + resp.status); });
+                return resp.json();
+            })
+            .then(data => {
+                btn.dataset.enabled = data.enabled ? 'true' : 'false';
+                btn.textContent = data.enabled ? 'Stop broadcast' : 'Start broadcast';
+                if (urlInput) urlInput.value = data.url || '';
+                if (urlRow) urlRow.hidden = !data.enabled;
+                if (status) {
+                    status.textContent = data.enabled
+                        ? 'Live, ' + data.viewers + (data.viewers === 1 ? ' viewer.' : ' viewers.')
+                        : '';
+                    status.setAttribute('data-state', 'ok');
+                }
+            })
+            .catch(err => {
+                if (status) {
+                    status.textContent = 'Broadcast: ' + err.message;
+                    status.setAttribute('data-state', 'err');
+                }
+            })
+            .finally(() => {
+                btn.disabled = false;
+            });
+    }
+
+    // Fetch the session's server-side event buffer into the Server events
+    // pane. Rendered with textContent only: messages can carry paths, URLs
+    // and error strings from anywhere.
+    _loadSessionEvents() {
+        const panel = this.querySelector('.settings-panel');
+        if (!panel) return;
+        const list = panel.querySelector('#settings-events-list');
+        const status = panel.querySelector('#settings-events-status');
+        const uuid = this.sessionUUID;
+        if (!list) return;
+        if (!uuid) {
+            if (status) {
+                status.textContent = 'Session not ready yet.';
+                status.setAttribute('data-state', 'err');
+            }
+            return;
+        }
+        if (status) {
+            status.textContent = 'Loading...';
+            status.removeAttribute('data-state');
+        }
+        fetch('/api/session/' + encodeURIComponent(uuid) + '/events', { cache: 'no-store' })
+            .then(resp => {
+                if (!resp.ok) throw new Error('HTTP ' + resp.status);
+                return resp.json();
+            })
+            .then(data => {
+                const events = data.events || [];
+                list.replaceChildren(...events.map(ev => {
+                    const li = document.createElement('li');
+                    li.className = 'settings-panel__event';
+                    li.dataset.level = (ev.level || '').toLowerCase();
+                    const time = document.createElement('time');
+                    time.dateTime = ev.time;
+                    time.textContent = new Date(ev.time).toLocaleTimeString();
+                    const level = document.createElement('span');
+                    level.className = 'settings-panel__event-level';
+                    level.textContent = ev.level;
+                    const msg = document.createElement('span');
+                    msg.className = 'settings-panel__event-msg';
+                    const attrs = Object.entries(ev.attrs || {}).map(([k, v]) => k + '=' + v).join(' ');
+                    msg.textContent = (ev.subsystem ? '[' + ev.subsystem + '] ' : '') + ev.msg + (attrs ? '  ' + attrs : '');
+                    li.append(time, level, msg);
+                    return li;
+                }));
+                list.scrollTop = list.scrollHeight;
+                if (status) {
+                    status.textContent = events.length ? events.length + ' events' : 'No events yet.';
+                    status.setAttribute('data-state', 'ok');
+                }
+            })
+            .catch(err => {
+                if (status) {
+                    status.textContent = 'Could not load events: ' + err.message;
+                    status.setAttribute('data-state', 'err');
+                }
+            });
+    }
+
     _revertProfile({ silent = false } = {}) {
         const panel = this.querySelector('.settings-panel');
         if (!panel || !this._settingsSnapshot) return;
@@ -3166,6 +3796,7 @@ class TerminalUI extends HTMLElement {
         if (!panel) return;
         // Sync UI controls back to snapshot values.
         this.populateThemeToggle();
+        this.populateSizeModeToggle();
         this.populateColorPicker();
         if (!silent) {
             const status = panel.querySelector('#settings-appearance-status');
@@ -3692,6 +4323,14 @@ class TerminalUI extends HTMLElement {
             });
         });
 
+        // Bookmark button in header
+        const bookmarkBtn = this.querySelector('.terminal-ui__bookmark-btn');
+        if (bookmarkBtn) {
+            bookmarkBtn.addEventListener('click', () => {
+                this.promptBookmark();
+            });
+        }
+
         // Chat button in header
         const chatBtn = this.querySelector('.terminal-ui__chat-btn');
         if (chatBtn) {
@@ -3936,6 +4575,30 @@ class TerminalUI extends HTMLElement {
         if (mobileOpt) mobileOpt.textContent = 'Agent Chat';
     }
 
+    // Reflect the server's view of the agent-chat port (agentChatStatus:
+    // listening / starting / no-listener) in the chat placeholder. A listener
+    // that goes away after the iframe loaded re-arms the probe, so the iframe
+    // reloads once the server is back.
+    updateAgentChatStatus(status) {
+        const prev = this.agentChatStatus;
+        this.agentChatStatus = status || null;
+        const ph = this.querySelector('.terminal-ui__agent-chat-placeholder');
+        const text = ph && ph.querySelector('.terminal-ui__iframe-placeholder-text');
+        if (text) {
+            if (status === 'no-listener') {
+                text.textContent = `Agent chat server is not running (nothing is listening on port ${this.agentChatPort})`;
+            } else if (status === 'starting') {
+                text.textContent = 'Starting agent chat server...';
+            } else {
+                text.textContent = 'Connecting to chat...';
+            }
+        }
+        if (status === 'no-listener' && prev === 'listening' && this._agentChatAvailable) {
+            this._agentChatAvailable = false;
+            if (ph) ph.classList.remove('hidden');
+        }
+    }
+
     // Set username helper
     setUsername(name) {
         this.currentUserName = name;
@@ -3972,6 +4635,7 @@ class TerminalUI extends HTMLElement {
 
         // Theme mode toggle
         this.populateThemeToggle();
+        this.populateSizeModeToggle();
 
         // Theme color picker
         this.populateColorPicker();
@@ -4111,6 +4775,42 @@ class TerminalUI extends HTMLElement {
         });
     }
 
+    populateSizeModeToggle() {
+        const toggle = this.querySelector('#settings-size-mode-toggle');
+        if (!toggle) return;
+        toggle.querySelectorAll('.settings-panel__theme-btn').forEach(btn => {
+            btn.classList.toggle('selected', btn.dataset.sizeMode === this.sizeMode);
+        });
+        const pinInput = this.querySelector('#settings-pin-size');
+        if (pinInput) pinInput.value = formatPinnedSize(this.pinnedSize);
+    }
+
+    // Terminal size mode applies (and persists) immediately, outside the
+    // Appearance pane's Save/Revert: it changes what other viewers get too.
+    setupSizeModeToggle() {
+        const toggle = this.querySelector('#settings-size-mode-toggle');
+        if (!toggle) return;
+        toggle.addEventListener('click', (e) => {
+            const btn = e.target.closest('.settings-panel__theme-btn');
+            if (!btn || !btn.dataset.sizeMode || btn.dataset.sizeMode === this.sizeMode) return;
+            this.setSizeMode(btn.dataset.sizeMode);
+        });
+        const pinInput = this.querySelector('#settings-pin-size');
+        const pinApply = this.querySelector('#settings-pin-size-apply');
+        if (!pinInput || !pinApply) return;
+        pinInput.addEventListener('input', () => pinInput.setCustomValidity(''));
+        pinApply.addEventListener('click', () => {
+            const size = parsePinnedSize(pinInput.value);
+            if (!size) {
+                pinInput.setCustomValidity('Use COLUMNSxROWS, e.g. 200x50');
+                pinInput.reportValidity();
+                return;
+            }
+            // The server checks the bounds; status echoes the result.
+            this.sendJSON({ type: 'pin_size', data: size });
+        });
+    }
+
     // Setup theme toggle click handler. Live preview only -- the change
     // is visible immediately but does not persist to localStorage until
     // the user presses Save in the Appearance pane (or revert kicks in).
@@ -4146,6 +4846,10 @@ class TerminalUI extends HTMLElement {
                 const resolved = e.detail?.resolved;
                 this.term.options.theme = resolved === 'light' ? LIGHT_XTERM_THEME : DARK_XTERM_THEME;
             }
+            // Tell the server so COLORFGBG and the OSC 11 report follow the UI
+            if (e.detail?.resolved) {
+                this.sendJSON({ type: 'set_theme', data: { theme: e.detail.resolved } });
+            }
         });
     }
 
@@ -4299,7 +5003,7 @@ class TerminalUI extends HTMLElement {
 
         if (text && this.ws && this.ws.readyState === WebSocket.OPEN) {
             const encoder = new TextEncoder();
-            this.ws.send(encoder.encode(text));
+            this.sendInput(encoder.encode(text));
         }
 
         this.hidePasteOverlay();
@@ -4336,6 +5040,15 @@ class TerminalUI extends HTMLElement {
         }
     }
 
+    // Show an agent-to-agent message in the chat overlay, labelled with the
+    // other session and the message kind.
+    addSessionBusMessage(direction, m) {
+        const incoming = direction === 'in';
+        const peer = incoming ? (m.fromName || (m.from || '').slice(0, 5)) : (m.toName || (m.to || '').slice(0, 5));
+        const kind = m.kind ? ` [${m.kind}]` : '';
+        this.addChatMessage(`${incoming ? 'from' : 'to'} ${peer}${kind}`, m.text, !incoming);
+    }
+
     showStatusNotification(message, durationMs = 3000) {
         const overlay = this.querySelector('.terminal-ui__chat-overlay');
         if (!overlay) return;
@@ -4439,7 +5152,7 @@ class TerminalUI extends HTMLElement {
     sendKey(code) {
         if (this.ws && this.ws.readyState === WebSocket.OPEN) {
             const encoder = new TextEncoder();
-            this.ws.send(encoder.encode(code));
+            this.sendInput(encoder.encode(code));
         }
     }
 
@@ -4733,6 +5446,16 @@ class TerminalUI extends HTMLElement {
             });
         });
 
+        // Approval prompt buttons (approval_relay.go)
+        this.querySelector('.approval-panel__approve').addEventListener('click', (e) => {
+            e.preventDefault();
+            this.answerApproval('approve');
+        });
+        this.querySelector('.approval-panel__deny').addEventListener('click', (e) => {
+            e.preventDefault();
+            this.answerApproval('deny');
+        });
+
         // Input bar
         const textInput = this.querySelector('.mobile-keyboard__text');
         const sendBtn = this.querySelector('.mobile-keyboard__send');
@@ -4764,6 +5487,22 @@ class TerminalUI extends HTMLElement {
             }
         });
 
+        // Queue button: the server types the text once the agent is idle
+        // (prompt_queue.go). With nothing typed it offers to clear the queue.
+        const queueBtn = this.querySelector('.mobile-keyboard__queue');
+        queueBtn.addEventListener('click', (e) => {
+            e.preventDefault();
+            const text = textInput.value;
+            if (text.trim()) {
+                this.sendJSON({ type: 'prompt', text });
+                textInput.value = '';
+                sendBtn.textContent = 'Enter';
+                this.blurMobileKeyboard();
+            } else if (this.queuedPrompts > 0 && confirm(`Drop the ${this.queuedPrompts} queued prompt(s)?`)) {
+                this.sendJSON({ type: 'clear_prompts' });
+            }
+        });
+
         // File attachment button
         const attachBtn = this.querySelector('.mobile-keyboard__attach');
         const fileInput = this.querySelector('.mobile-keyboard__file-input');
@@ -4795,7 +5534,7 @@ class TerminalUI extends HTMLElement {
             this.term.onData(data => {
                 if (this.ws && this.ws.readyState === WebSocket.OPEN) {
                     const encoder = new TextEncoder();
-                    this.ws.send(encoder.encode(data));
+                    this.sendInput(encoder.encode(data));
                 }
             });
 
@@ -5076,6 +5815,19 @@ class TerminalUI extends HTMLElement {
             return cleaned;
         };
 
+        // Selections of LARGE_COPY_ROWS rows or more are also extracted by the
+        // server (copy_rows, session_copy.go) and downloaded: the browser
+        // copy breaks long lines at the terminal width and big clipboard
+        // writes fail. Rows are sent counted back from the buffer's end.
+        const LARGE_COPY_ROWS = 1000;
+        const requestServerCopy = () => {
+            const pos = this.term.getSelectionPosition();
+            if (!pos || pos.end.y - pos.start.y + 1 < LARGE_COPY_ROWS) return false;
+            const length = this.term.buffer.active.length;
+            this.sendJSON({ type: 'copy_rows', data: { start: pos.start.y - length, end: pos.end.y - length } });
+            return true;
+        };
+
         // Intercept copy events to trim trailing whitespace from each line.
         // Terminal lines are fixed-width, so selections include padding spaces.
         document.addEventListener('copy', (e) => {
@@ -5084,6 +5836,7 @@ class TerminalUI extends HTMLElement {
             if (selection) {
                 e.clipboardData.setData('text/plain', copySelection(selection));
                 e.preventDefault();
+                requestServerCopy();
             }
         });
 
@@ -5094,6 +5847,7 @@ class TerminalUI extends HTMLElement {
                 if (e.button !== 0) return; // left-click only
                 if (!this.term || !this.term.hasSelection()) return;
                 copySelection(this.term.getSelection());
+                if (requestServerCopy()) return; // copy_ready says what happened
                 this.showStatusNotification('Copied to clipboard', 1500);
             });
         }
@@ -5119,10 +5873,15 @@ class TerminalUI extends HTMLElement {
             // Prevent default paste behavior
             e.preventDefault();
 
-            // Handle each file
+            // Handle each file. A bitmap straight off the OS clipboard (a
+            // screenshot) has no real name -- browsers call it "image.png" --
+            // so it goes up as an image paste and the server names it.
             for (const item of fileItems) {
                 const file = item.getAsFile();
-                if (file) {
+                if (!file) continue;
+                if (item.type.startsWith('image/') && (!file.name || /^image\.\w+$/.test(file.name))) {
+                    await this.handleImagePaste(file);
+                } else {
                     await this.handleFile(file);
                 }
             }
@@ -5217,7 +5976,7 @@ class TerminalUI extends HTMLElement {
                 return;
             }
             const encoder = new TextEncoder();
-            this.ws.send(encoder.encode(text));
+            this.sendInput(encoder.encode(text));
             this.showStatusNotification(`Pasted: ${file.name} (${formatFileSize(text.length)})`);
         } else {
             // Binary file upload
@@ -5226,11 +5985,25 @@ class TerminalUI extends HTMLElement {
                 this.showStatusNotification(`Error reading: ${file.name}`, 5000);
                 return;
             }
-            this.ws.send(encodeFileUpload(file.name, fileData));
+            this.sendInput(encodeFileUpload(file.name, fileData));
             this.showStatusNotification(`Uploaded: ${file.name} (${formatFileSize(file.size)}, temporary)`);
         }
     }
 
+    async handleImagePaste(file) {
+        if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
+            this.showStatusNotification('Not connected', 3000);
+            return;
+        }
+        const imageData = await this.readFileAsBinary(file);
+        if (imageData === null) {
+            this.showStatusNotification('Error reading pasted image', 5000);
+            return;
+        }
+        this.sendInput(encodeImagePaste(file.type, imageData));
+        this.showStatusNotification(`Uploading pasted image (${formatFileSize(file.size)})`);
+    }
+
     isTextFile(file) {
         // Check MIME type first
         if (file.type.startsWith('text/')) return true;
@@ -6401,6 +7174,11 @@ class TerminalUI extends HTMLElement {
     }
 
     getPreviewBaseUrl() {
+        // A configured preview subdomain ({uuid}.SWE_PREVIEW_DOMAIN) wins:
+        // the admin set it up so apps with absolute paths work.
+        if (this.previewDomainHost) {
+            return buildPreviewDomainUrl(window.location, this.previewDomainHost);
+        }
         // Tunnel mode wins over port-based mode: the swe-swe-tunnel demuxes
         // {previewProxyPort}.{publicHostname} -> 127.0.0.1:{previewProxyPort}
         // (the swe-swe-server auth proxy port = previewPort + proxyPortOffset),
@@ -6974,9 +7752,10 @@ class TerminalUI extends HTMLElement {
         this.updateVhostModeIndicator();
         const probeBase = buildPreviewUrl(getBaseUrl(window.location), this.sessionUUID);
         if (!probeBase) return;
-        const subdomainBase = (this.effectivePublicHostname && this.previewProxyPort)
-            ? buildSubdomainPreviewUrl(window.location, this.previewProxyPort, this.effectivePublicHostname)
-            : null;
+        const subdomainBase = buildPreviewDomainUrl(window.location, this.previewDomainHost)
+            || ((this.effectivePublicHostname && this.previewProxyPort)
+                ? buildSubdomainPreviewUrl(window.location, this.previewProxyPort, this.effectivePublicHostname)
+                : null);
         const base = subdomainBase || probeBase;
         let path;
         if (iframePath !== null) {
@@ -7031,7 +7810,8 @@ class TerminalUI extends HTMLElement {
                 signal: this._previewProbeController.signal,
             }).then(() => {
                 if (subdomainBase) {
-                    // Tunnel mode: iframe already targets the subdomain URL.
+                    // Tunnel mode or a preview subdomain: iframe already
+                    // targets the subdomain URL.
                     this._proxyMode = 'subdomain';
                     return;
                 }
@@ -7221,37 +8001,42 @@ class TerminalUI extends HTMLElement {
 
     /**
      * Reverse-map a proxy URL to the logical localhost:PORT URL.
-     * e.g., https://host/proxy/{uuid}/preview/dashboard?tab=1#s -> http://localhost:3000/dashboard?tab=1#s
+     * e.g., https://host/preview/{uuid}/dashboard?tab=1#s -> http://localhost:3000/dashboard?tab=1#s
      */
     reverseMapProxyUrl(proxyUrl) {
         if (!this.previewPort) return proxyUrl;
         try {
             const parsed = new URL(proxyUrl);
-            // Strip the /proxy/{sessionUUID}/preview prefix from path-based routing
-            const prefix = this.sessionUUID ? `/proxy/${this.sessionUUID}/preview` : '';
-            let path = parsed.pathname;
-            if (prefix && path.startsWith(prefix)) {
-                path = path.slice(prefix.length) || '/';
-            }
+            // Strip the path-based routing prefix
+            const path = this.stripPreviewPrefix(parsed.pathname);
             return `http://localhost:${this.previewPort}${path}${parsed.search}${parsed.hash}`;
         } catch {
             return proxyUrl;
         }
     }
 
+    /**
+     * Strip the /preview/{uuid} (or older /proxy/{uuid}/preview) prefix
+     * that path-based preview routing adds to the app's own path.
+     */
+    stripPreviewPrefix(pathname) {
+        if (!this.sessionUUID) return pathname;
+        for (const prefix of [`/preview/${this.sessionUUID}`, `/proxy/${this.sessionUUID}/preview`]) {
+            if (pathname.startsWith(prefix)) {
+                return pathname.slice(prefix.length) || '/';
+            }
+        }
+        return pathname;
+    }
+
     /**
      * Extract just the path from a proxy URL.
-     * e.g., https://host/proxy/{uuid}/preview/dashboard?tab=1#s -> /dashboard?tab=1#s
+     * e.g., https://host/preview/{uuid}/dashboard?tab=1#s -> /dashboard?tab=1#s
      */
     pathFromProxyUrl(proxyUrl) {
         try {
             const parsed = new URL(proxyUrl);
-            const prefix = this.sessionUUID ? `/proxy/${this.sessionUUID}/preview` : '';
-            let path = parsed.pathname;
-            if (prefix && path.startsWith(prefix)) {
-                path = path.slice(prefix.length) || '/';
-            }
-            return path + parsed.search + parsed.hash;
+            return this.stripPreviewPrefix(parsed.pathname) + parsed.search + parsed.hash;
         } catch {
             return '/';
         }
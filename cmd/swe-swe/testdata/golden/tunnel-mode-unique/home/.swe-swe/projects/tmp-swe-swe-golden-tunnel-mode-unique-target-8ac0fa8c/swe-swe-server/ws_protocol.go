@@ -0,0 +1,67 @@
+// ws_protocol.go -- protocol version handshake on the session WebSocket.
+//
+// The binary opcodes and JSON control messages (docs/websocket-protocol.md)
+// grow with the server, but a browser can keep running yesterday's cached
+// terminal-ui.js against it. The client says which protocol it speaks with
+// ?proto=N on /ws/{uuid}, and the server's first frame says what it speaks:
+//
+//	{"type": "protocol", "version": 1, "min": 1}
+//
+// A client older than min, or newer than version, is refused with a
+// session_error of code protocol_mismatch (ws_errors.go): the page reloads to
+// get JS that matches. A client within range is served; if version is newer
+// than its own, it may suggest a reload, but everything it knows still works.
+// A client that sends no proto (scripts, `swe-swe attach` before this
+// handshake) is served as before.
+//
+// The versions are wsproto.Version and wsproto.MinVersion (and
+// static/modules/messages.js PROTOCOL_VERSION). Bump Version when the client
+// would need to change to keep working -- a new opcode it must handle, a
+// changed message shape -- and raise MinVersion only when the server stops
+// speaking an old one. GET /api/protocol describes the frames and messages
+// of this version.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/choonkeat/swe-swe/internal/wsproto"
+)
+
+// wsProtocolHello is the server's first frame.
+func wsProtocolHello() wsproto.Protocol {
+	return wsproto.Protocol{Version: wsproto.Version, Min: wsproto.MinVersion}
+}
+
+// checkWSProtocol checks the client's ?proto value ("" when not sent). It
+// returns a session_error to refuse the client with, or nil to serve it.
+func checkWSProtocol(proto string) *wsError {
+	if proto == "" {
+		return nil
+	}
+	v, err := strconv.Atoi(proto)
+	if err == nil && v >= wsproto.MinVersion && v <= wsproto.Version {
+		return nil
+	}
+	return &wsError{
+		Type: "session_error",
+		Code: wsErrProtocolMismatch,
+		Message: fmt.Sprintf("This page speaks protocol %s but the server speaks %d to %d. Reload the page.",
+			proto, wsproto.MinVersion, wsproto.Version),
+	}
+}
+
+// handleProtocolAPI serves GET /api/protocol: the binary frames and typed
+// control messages of the session WebSocket, for clients built outside this
+// repo.
+func handleProtocolAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wsproto.Describe())
+}
@@ -0,0 +1,197 @@
+// assistant_auth.go -- is an assistant logged in, before a session starts it?
+//
+// A session started with an agent that has no credentials opens on the
+// agent's login screen, after its worktree was made. So each assistant has
+// an auth check: AuthEnv, env vars any of which holds an API key, and
+// AuthFiles, the credential files its login writes ("~/" is $HOME, and a
+// path under an unset $VAR is skipped). It is logged in if one is set or
+// exists and is not empty.
+//
+// SWE_AUTH_CHECK_<BINARY> replaces an assistant's check, e.g.
+// SWE_AUTH_CHECK_GEMINI:
+//
+//   - "none": not checked;
+//   - "file:<path>[,<path>...]": logged in if one of the files exists;
+//   - anything else: a command run with sh -c, logged in if it exits 0.
+//
+// The check runs with the assistant checks (assistant_health.go), and again
+// for GET /api/session/auth-check?assistant= and POST /api/session/new. The
+// homepage flags a logged-out assistant, and /api/session/new refuses one
+// with 409 (error "not_logged_in") unless the form has auth_ack=1, so the
+// new-session dialog can ask first. The check sees the server's environment,
+// not a repo's .swe-swe/env.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	authOK        = "ok"
+	authLoggedOut = "logged_out"
+	// authCheckTimeout bounds a SWE_AUTH_CHECK_* command.
+	authCheckTimeout = 10 * time.Second
+)
+
+// assistantAuth is the result of an auth check. State is authOK,
+// authLoggedOut, or "" when the assistant has no check.
+type assistantAuth struct {
+	State  string `json:"auth,omitempty"`
+	Detail string `json:"authDetail,omitempty"` // what was looked for, when logged out
+}
+
+// checkAuth runs cfg's auth check.
+func checkAuth(cfg AssistantConfig) assistantAuth {
+	override := strings.TrimSpace(os.Getenv("SWE_AUTH_CHECK_" + strings.ToUpper(cfg.Binary)))
+	switch {
+	case override == "none":
+		return assistantAuth{}
+	case strings.HasPrefix(override, "file:"):
+		return checkAuthFiles(nil, strings.Split(strings.TrimPrefix(override, "file:"), ","))
+	case override != "":
+		return checkAuthCommand(override)
+	case len(cfg.AuthEnv) == 0 && len(cfg.AuthFiles) == 0:
+		return assistantAuth{}
+	}
+	return checkAuthFiles(cfg.AuthEnv, cfg.AuthFiles)
+}
+
+// checkAuthFiles is logged in if one of envs is set or one of files exists
+// and is not empty.
+func checkAuthFiles(envs, files []string) assistantAuth {
+	var looked []string
+	for _, name := range envs {
+		if os.Getenv(name) != "" {
+			return assistantAuth{State: authOK}
+		}
+		looked = append(looked, name)
+	}
+	for _, f := range files {
+		f = strings.TrimSpace(f)
+		path, ok := expandAuthPath(f)
+		if !ok {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+			return assistantAuth{State: authOK}
+		}
+		looked = append(looked, f)
+	}
+	return assistantAuth{State: authLoggedOut, Detail: "no " + strings.Join(looked, ", ")}
+}
+
+// expandAuthPath expands "~/" and $VARs in an AuthFiles path. It reports
+// false when the path uses an unset variable or the home directory is
+// unknown.
+func expandAuthPath(p string) (string, bool) {
+	if p == "" {
+		return "", false
+	}
+	ok := true
+	p = os.Expand(p, func(name string) string {
+		v := os.Getenv(name)
+		if v == "" {
+			ok = false
+		}
+		return v
+	})
+	if rest, found := strings.CutPrefix(p, "~/"); found {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+		p = filepath.Join(home, rest)
+	}
+	return p, ok
+}
+
+// checkAuthCommand is logged in if command exits 0.
+func checkAuthCommand(command string) assistantAuth {
+	ctx, cancel := context.WithTimeout(context.Background(), authCheckTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		return assistantAuth{State: authLoggedOut, Detail: fmt.Sprintf("%s: %v", command, err)}
+	}
+	return assistantAuth{State: authOK}
+}
+
+// recheckAssistantAuth runs the auth check of an installed assistant now and
+// records the result with its status. ok is false for an unknown assistant.
+func recheckAssistantAuth(binary string) (cfg AssistantConfig, auth assistantAuth, ok bool) {
+	for _, a := range installedAssistants() {
+		if a.Binary == binary {
+			cfg, ok = a, true
+			break
+		}
+	}
+	if !ok {
+		return cfg, auth, false
+	}
+	auth = checkAuth(cfg)
+	assistantHealth.mu.Lock()
+	if st, seen := assistantHealth.statuses[binary]; seen {
+		st.assistantAuth = auth
+		assistantHealth.statuses[binary] = st
+	}
+	assistantHealth.mu.Unlock()
+	return cfg, auth, true
+}
+
+// assistantLoggedOut reports whether the last check found binary logged out.
+func assistantLoggedOut(binary string) bool {
+	assistantHealth.mu.Lock()
+	defer assistantHealth.mu.Unlock()
+	return assistantHealth.statuses[binary].State == authLoggedOut
+}
+
+// notLoggedInMessage says why a session of cfg may not work.
+func notLoggedInMessage(cfg AssistantConfig, auth assistantAuth) string {
+	return fmt.Sprintf("%s does not look logged in (%s), so it may open on its login screen.", cfg.Name, auth.Detail)
+}
+
+// writeNotLoggedInError refuses a session whose agent is not logged in.
+func writeNotLoggedInError(w http.ResponseWriter, cfg AssistantConfig, auth assistantAuth) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":     "not_logged_in",
+		"message":   notLoggedInMessage(cfg, auth),
+		"assistant": cfg.Binary,
+		"detail":    auth.Detail,
+	})
+}
+
+// handleAuthCheckAPI serves GET /api/session/auth-check?assistant=: the
+// assistant's auth check, run now.
+func handleAuthCheckAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, auth, ok := recheckAssistantAuth(r.URL.Query().Get("assistant"))
+	if !ok {
+		http.Error(w, "unknown assistant", http.StatusBadRequest)
+		return
+	}
+	resp := struct {
+		Assistant string `json:"assistant"`
+		Name      string `json:"name"`
+		assistantAuth
+		Message string `json:"message,omitempty"`
+	}{Assistant: cfg.Binary, Name: cfg.Name, assistantAuth: auth}
+	if auth.State == authLoggedOut {
+		resp.Message = notLoggedInMessage(cfg, auth)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -35,10 +35,13 @@ import (
 	"syscall"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	agentproxy "github.com/choonkeat/agent-reverse-proxy"
 	recordtui "github.com/choonkeat/record-tui/playback"
 	"github.com/choonkeat/swe-swe/forkconvo"
+	"github.com/choonkeat/swe-swe/internal/termsnap"
+	"github.com/choonkeat/swe-swe/internal/wsproto"
 	"github.com/creack/pty"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -118,17 +121,14 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: checkWebSocketOrigin,
 }
 
-// Chunked WebSocket constants for iOS Safari compatibility
+// Chunked WebSocket constants for iOS Safari compatibility; the chunk frames
+// are wsproto.OpChunk.
 // See: research/2026-01-04-ios-safari-websocket-chunking.md
 const (
-	// ChunkMarker identifies a chunked binary message (0x02)
-	ChunkMarker = 0x02
 	// DefaultChunkSize is 8KB - safe for iOS Safari WebSocket
 	DefaultChunkSize = 8192
 	// MinChunkSize prevents excessively small chunks
 	MinChunkSize = 512
-	// RingBufferSize is the size of the terminal scrollback ring buffer (512KB)
-	RingBufferSize = 512 * 1024
 )
 
 var (
@@ -201,11 +201,6 @@ func ansiCyan(s string) string   { return "\033[0;36m" + s + "\033[0m" }
 func ansiDim(s string) string    { return "\033[2m" + s + "\033[0m" }
 func ansiYellow(s string) string { return "\033[0;33m" + s + "\033[0m" }
 
-// dsrQuery is the Device Status Report escape sequence that terminals
-// use to query cursor position. Applications (like Codex CLI via crossterm)
-// send this and expect a response of the form \x1b[{row};{col}R.
-var dsrQuery = []byte("\x1b[6n")
-
 // TermSize represents terminal dimensions
 type TermSize struct {
 	Rows uint16
@@ -229,6 +224,7 @@ func NewSafeConn(conn *websocket.Conn) *SafeConn {
 func (sc *SafeConn) WriteMessage(messageType int, data []byte) error {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
+	sc.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
 	return sc.conn.WriteMessage(messageType, data)
 }
 
@@ -236,9 +232,19 @@ func (sc *SafeConn) WriteMessage(messageType int, data []byte) error {
 func (sc *SafeConn) WriteJSON(v interface{}) error {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
+	sc.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
 	return sc.conn.WriteJSON(v)
 }
 
+// Send sends a typed control message (thread-safe)
+func (sc *SafeConn) Send(m wsproto.Message) error {
+	data, err := wsproto.Encode(m)
+	if err != nil {
+		return err
+	}
+	return sc.WriteMessage(websocket.TextMessage, data)
+}
+
 // ReadMessage reads the next message (no lock needed - reads are already safe)
 func (sc *SafeConn) ReadMessage() (messageType int, p []byte, err error) {
 	return sc.conn.ReadMessage()
@@ -268,6 +274,12 @@ type AssistantConfig struct {
 	Binary          string             // Binary name to check with exec.LookPath
 	Homepage        bool               // Whether to show on homepage (false = hidden, e.g., shell)
 	SlashCmdFormat  SlashCommandFormat // Slash command format ("md", "toml", or "" for none)
+	ReadyPattern    string             // Regexp on the screen once it takes input (agent_ready.go)
+	ApprovalPattern string             // Regexp on the screen while it asks to run a tool (agent_state.go)
+	ApproveKeys     string             // Keys that answer its approval prompt yes (approval_relay.go)
+	DenyKeys        string             // Keys that answer it no; empty if it cannot be denied that way
+	AuthEnv         []string           // Env vars, any of which logs it in (assistant_auth.go)
+	AuthFiles       []string           // Credential files, any of which logs it in (assistant_auth.go)
 }
 
 // SessionInfo holds session data for template rendering
@@ -379,10 +391,13 @@ type SessionInfo struct {
 	PublicPort    int    // PUBLIC_PORT env var value (e.g. 5000)
 	Query         SessionPageQuery
 	SummaryLine   string // One-line summary: "{who}: {message}" from last chat event or terminal
-	SummaryStatus string // "green" (waiting for user) or "red" (agent busy) or "" (unknown)
+	SummaryStatus string // "green" (waiting for user), "red" (agent busy), "amber" (waiting for approval) or "" (unknown)
 	MemoryUsage   string // Human-readable RSS of session process tree (e.g. "1.2 GB")
 	Ending        bool   // teardown in flight: card is inert until the poll drops it
 	EndRequested  bool   // agent is committing the chat log, then ending itself: card stays joinable
+	Thumb         string // hash of the screen preview (session_thumbnail.go); "" until one is rendered
+	AgentState    string // "busy", "idle", "waiting_approval" or "" (agent_state.go)
+	Health        sessionHealth
 }
 
 // formatDuration returns a human-readable duration string
@@ -406,14 +421,19 @@ func formatDuration(d time.Duration) string {
 type AgentWithSessions struct {
 	Assistant AssistantConfig
 	Sessions  []SessionInfo // sorted by CreatedAt desc (most recent first)
+	LoggedOut bool          // the last auth check found it logged out (assistant_auth.go)
 }
 
 // RecordingMetadata stores information about a terminal recording session
 type RecordingMetadata struct {
+	// SchemaVersion is the metadata layout version; files from before it
+	// existed are 0 and are upgraded at startup (see recording_migrate.go).
+	SchemaVersion int        `json:"schema_version,omitempty"`
 	UUID          string     `json:"uuid"`
 	Name          string     `json:"name,omitempty"`
 	Agent         string     `json:"agent"`
 	AgentBinary   string     `json:"agent_binary,omitempty"`   // binary name for URLs (e.g. "claude"); empty in old recordings
+	AgentVersion  string     `json:"agent_version,omitempty"`  // the agent's --version at start, when known (assistant_health.go)
 	RecordingType string     `json:"recording_type,omitempty"` // "agent", "chat", "terminal"
 	SessionMode   string     `json:"session_mode,omitempty"`   // "terminal" or "chat"
 	BranchName    string     `json:"branch_name,omitempty"`    // git branch / worktree name (set only when a worktree branch was requested)
@@ -425,6 +445,10 @@ type RecordingMetadata struct {
 	StartedAt     time.Time  `json:"started_at"`
 	EndedAt       *time.Time `json:"ended_at,omitempty"`
 	KeptAt        *time.Time `json:"kept_at,omitempty"` // When user marked this recording to keep (nil = recent, auto-deletable)
+	// Owner is the user who started the session and KeptBy the one who kept
+	// the recording; empty without named users (auth_roles.go).
+	Owner  string `json:"owner,omitempty"`
+	KeptBy string `json:"kept_by,omitempty"`
 	Command       []string   `json:"command"`
 	Visitors      []Visitor  `json:"visitors,omitempty"`
 	MaxCols       uint16     `json:"max_cols,omitempty"`      // Max terminal columns during recording
@@ -440,12 +464,25 @@ type RecordingMetadata struct {
 	// Older recordings predate this field and leave it empty; fork falls back
 	// to the legacy lookup in that case.
 	AgentSessionID string `json:"agent_session_id,omitempty"`
+	// Annotations are user bookmarks on the recording's timeline, shown as
+	// chapter markers in playback (see recording_annotations.go).
+	Annotations []RecordingAnnotation `json:"annotations,omitempty"`
+	// StartCommit is the commit WorkDir's HEAD was on when the session
+	// started; Changes is what the session changed relative to it, captured
+	// when the session ends (see recording_changes.go).
+	StartCommit string            `json:"start_commit,omitempty"`
+	Changes     *RecordingChanges `json:"changes,omitempty"`
+	// Integrity is the hashes of the recording files, taken once the session
+	// ended and its recorder closed them (see recording_integrity.go).
+	Integrity *RecordingIntegrity `json:"integrity,omitempty"`
 }
 
 // Visitor represents a client that joined the session
 type Visitor struct {
 	JoinedAt time.Time `json:"joined_at"`
-	IP       string    `json:"ip"`
+	IP       string    `json:"ip"`                // the client's address (client_ip.go)
+	Name     string    `json:"name,omitempty"`    // share-link label for a share guest, else the user's name
+	Tailnet  string    `json:"tailnet,omitempty"` // Tailscale login, with SWE_TAILSCALE_IDENTITY
 }
 
 // Predefined assistant configurations (ordered for consistent display)
@@ -458,6 +495,12 @@ var assistantConfigs = []AssistantConfig{
 		YoloRestartCmd:  "claude --dangerously-skip-permissions --continue",
 		Binary:          "claude",
 		Homepage:        true,
+		AuthEnv:         []string{"ANTHROPIC_API_KEY", "CLAUDE_CODE_OAUTH_TOKEN"},
+		AuthFiles:       []string{"$CLAUDE_CONFIG_DIR/.credentials.json", "~/.claude/.credentials.json"},
+		ReadyPattern:    `\? for shortcuts`,
+		ApprovalPattern: `Do you want to [^\n]*\?`,
+		ApproveKeys:     "1",
+		DenyKeys:        "\x1b",
 		SlashCmdFormat:  SlashCmdMD,
 	},
 	{
@@ -468,6 +511,12 @@ var assistantConfigs = []AssistantConfig{
 		YoloRestartCmd:  "gemini --resume --approval-mode=yolo",
 		Binary:          "gemini",
 		Homepage:        true,
+		AuthEnv:         []string{"GEMINI_API_KEY", "GOOGLE_API_KEY"},
+		AuthFiles:       []string{"~/.gemini/oauth_creds.json"},
+		ReadyPattern:    `Type your message`,
+		ApprovalPattern: `Allow execution|Apply this change\?`,
+		ApproveKeys:     "1",
+		DenyKeys:        "\x1b",
 		SlashCmdFormat:  SlashCmdTOML,
 	},
 	{
@@ -478,6 +527,12 @@ var assistantConfigs = []AssistantConfig{
 		YoloRestartCmd:  "codex --yolo resume --last",
 		Binary:          "codex",
 		Homepage:        true,
+		AuthEnv:         []string{"OPENAI_API_KEY"},
+		AuthFiles:       []string{"$CODEX_HOME/auth.json", "~/.codex/auth.json"},
+		ReadyPattern:    `context left|⏎ send`,
+		ApprovalPattern: `Allow command\?|Would you like to (run|make)`,
+		ApproveKeys:     "y",
+		DenyKeys:        "\x1b",
 		SlashCmdFormat:  SlashCmdMD,
 	},
 	{
@@ -488,6 +543,11 @@ var assistantConfigs = []AssistantConfig{
 		YoloRestartCmd:  "GOOSE_MODE=auto goose session -r",
 		Binary:          "goose",
 		Homepage:        true,
+		AuthEnv:         []string{"GOOSE_PROVIDER"},
+		AuthFiles:       []string{"~/.config/goose/config.yaml"},
+		ReadyPattern:    `\( O\)>`,
+		ApprovalPattern: `do you allow\?`,
+		ApproveKeys:     "\r",
 		SlashCmdFormat:  SlashCmdNone,
 	},
 	{
@@ -498,6 +558,12 @@ var assistantConfigs = []AssistantConfig{
 		YoloRestartCmd:  "aider --yes-always --restore-chat-history",
 		Binary:          "aider",
 		Homepage:        true,
+		AuthEnv:         []string{"ANTHROPIC_API_KEY", "OPENAI_API_KEY", "GEMINI_API_KEY", "DEEPSEEK_API_KEY", "OPENROUTER_API_KEY"},
+		AuthFiles:       []string{"~/.aider.conf.yml", "~/.env"},
+		ReadyPattern:    `(?m)^\w*> *$`,
+		ApprovalPattern: `\(Y\)es/\(N\)o`,
+		ApproveKeys:     "y\r",
+		DenyKeys:        "n\r",
 		SlashCmdFormat:  SlashCmdNone,
 	},
 	{
@@ -508,6 +574,8 @@ var assistantConfigs = []AssistantConfig{
 		YoloRestartCmd:  "", // YOLO mode not supported
 		Binary:          "opencode",
 		Homepage:        true,
+		AuthEnv:         []string{"ANTHROPIC_API_KEY", "OPENAI_API_KEY", "GEMINI_API_KEY", "OPENROUTER_API_KEY"},
+		AuthFiles:       []string{"~/.local/share/opencode/auth.json"},
 		SlashCmdFormat:  SlashCmdMD,
 	},
 	{
@@ -518,6 +586,8 @@ var assistantConfigs = []AssistantConfig{
 		YoloRestartCmd:  "", // YOLO mode not supported
 		Binary:          "pi",
 		Homepage:        true,
+		AuthEnv:         []string{"ANTHROPIC_API_KEY", "OPENAI_API_KEY", "GEMINI_API_KEY"},
+		AuthFiles:       []string{"$PI_HOME/agent/auth.json", "~/.pi/agent/auth.json"},
 		SlashCmdFormat:  SlashCmdMD,
 	},
 	{
@@ -540,21 +610,61 @@ type Session struct {
 	PTY             *os.File
 	wsClients       map[*SafeConn]bool     // WebSocket clients (SafeConn for thread-safe writes)
 	wsClientSizes   map[*SafeConn]TermSize // WebSocket client terminal sizes
+	textOnlyClients map[*SafeConn]bool     // ?text=only clients: no binary frames (session_text_stream.go)
+	sizeIndependent map[*SafeConn]bool     // clients left out of PTY sizing (session_size_mode.go)
+	text            textStream             // plain-text stream state (session_text_stream.go)
+	live            liveBroadcast          // broadcast mode viewers (session_broadcast.go)
+	tests           testRunState           // latest test run (session_tests.go)
+	previewApp      previewAppState        // the repo's dev server (session_preview_app.go)
+	prompts         promptQueue            // prompts waiting for the agent (prompt_queue.go)
+	inputResume     inputResumeState       // sequenced input positions by client (input_resume.go)
+	hibernation     hibernationState       // idle hibernation (session_hibernate.go)
 	ptySize         TermSize               // Current PTY dimensions (for dedup)
+	pinnedSize      TermSize               // Sticky PTY size; zero = follow clients (session_size_pin.go)
 	mu              sync.RWMutex
 	CreatedAt       time.Time // when the session was created
 	lastActive      time.Time
-	vt              vt10x.Terminal // virtual terminal for screen state tracking
+	vt              vt10x.Terminal // virtual terminal for screen state tracking (a termsnap.Tracked)
 	vtMu            sync.Mutex     // separate mutex for VT operations
-	// Ring buffer for terminal scrollback history
-	ringBuf  []byte // circular buffer storage
-	ringHead int    // write position (where next byte goes)
-	ringLen  int    // current bytes stored (0 to RingBufferSize)
+	// snapshots is the last compressed screen snapshot, by VT generation,
+	// shared by clients that join before the screen changes.
+	snapshots termsnap.Cache
+	// ring is the terminal scrollback history; its Total is the output
+	// offset resuming clients count in (output_resume.go). Guarded by vtMu.
+	ring         termsnap.Ring
+	dsr          termsnap.DSRWatcher // used by the PTY reader only
+	// bgQuery sees OSC 11 queries in the output (PTY reader only);
+	// bgQueryPending is set by one and cleared by SetTheme's report.
+	bgQuery        termsnap.BackgroundWatcher
+	bgQueryPending atomic.Bool
+	outputResume outputResumeState
+	// lastOutput is when the PTY last wrote output; guarded by vtMu.
+	lastOutput time.Time
+	// agentState is busy, idle or waiting_approval (agent_state.go).
+	agentState atomic.Value
+	// approval is the pending approval prompt (approval_relay.go).
+	approval approvalRelay
+	// adminStatus is the last status sent to /ws/admin (admin_events.go).
+	adminStatus atomic.Value
+	// health keeps the previous CPU sample (session_health.go).
+	health healthSampler
 	// Recording
 	RecordingUUID   string             // UUID for recording files (separate from session UUID for restarts)
 	RecordingPrefix string             // Filename prefix: "session-{uuid}" or "session-{parent}-{child}"
 	Metadata        *RecordingMetadata // Recording metadata (saved on name change or visitor join)
 	metadataMu      sync.Mutex         // serializes saveMetadata writes (atomic temp+rename below isn't enough on its own)
+	// recorder writes the PTY traffic to the recording files
+	// (session_recorder.go); replaced when the process restarts.
+	recorder atomic.Pointer[sessionRecorder]
+	// sinks tees the PTY output to the workspace's log sinks
+	// (output_sinks.go); nil without any.
+	sinks *outputTee
+	// Owner is the named user who started the session (auth_roles.go);
+	// "" without users. Guarded by mu.
+	Owner string
+	// untrustedRepo leaves out the tree's .swe-swe/env and hooks
+	// (SessionParams.UntrustedRepo).
+	untrustedRepo bool
 	// Parent session relationship
 	ParentUUID     string // UUID of parent session (for shell sessions opened from agent sessions)
 	PreviewPort    int    // App preview target port for this session
@@ -592,13 +702,19 @@ type Session struct {
 	pendingReplacement string // If set, replace process with this command instead of ending session
 	// UI theme at session creation (for COLORFGBG env var)
 	Theme string // "light" or "dark"
+	// Locale is the message catalog (i18n.go) for the notices the server
+	// writes into the terminal, picked from the creating browser's request.
+	Locale string
 	// SharePassword, when non-empty, is the password a shared-session guest
 	// types to log in scoped to THIS session (see session_share.go). It lives
 	// only in memory, so it dies when the session ends -- that is the whole
 	// revocation model. Guarded by mu.
 	SharePassword string
+	// ShareLinks are the session's live share links by ID
+	// (session_share_links.go). Guarded by mu.
+	ShareLinks map[string]*shareLink
 	// Agent Chat sidecar (nil for terminal-only sessions)
-	AgentChatCmd    *exec.Cmd
+	AgentChat       *agentChatSidecar  // watches (and with SWE_AGENT_CHAT_CMD runs) the agent-chat server
 	agentChatCancel context.CancelFunc // cancels sessionCtx (stops sidecar watcher)
 	// MCP-less mode: the mcp-cli-proxy processes swe-swe-server launched for this
 	// session (nil in native-MCP mode). Killed on session teardown.
@@ -606,6 +722,7 @@ type Session struct {
 	SessionMode     string             // "terminal" or "chat"
 	ChatLogPath     string             // AGENT_CHAT_EVENT_LOG path for this session (chat mode only)
 	AgentSessionID  string             // agent-side conversation id (e.g. Claude .jsonl stem); captured at spawn for /api/fork
+	Backend         sessionBackend     // where the command runs (session_backend.go); nil means host
 	// Preview vhost host-demux state (see preview_vhost.go / ADR-0045).
 	// VhostPin is the degraded (pinned) mode target: when the browser cannot
 	// reach wildcard subdomains, label-less requests route here. Guarded by mu.
@@ -617,6 +734,11 @@ type Session struct {
 	// Per-session preview proxy (hosted in swe-swe-server, not a separate process)
 	PreviewProxy         *agentproxy.Proxy // Per-session preview proxy instance
 	SessionMux           http.Handler      // Handles /proxy/{uuid}/preview/ AND /proxy/{uuid}/agentchat/
+	PreviewHostProxy     http.Handler      // Root-mounted preview proxy for {uuid}.SWE_PREVIEW_DOMAIN (nil when unset)
+	PreviewMCP           http.Handler      // Preview MCP tools, also served at /mcp/preview?key= (preview_debug.go)
+	Checkpoints          *sessionCheckpointer // working-tree checkpoints; nil when off (checkpoints.go)
+	FSWatch              *sessionFSWatcher    // live files_changed feed; nil when off (session_fs_watch.go)
+	AutoName             *sessionAutoNamer    // names the session after its first prompt; nil when off (session_autoname.go)
 	PreviewProxyServer   *http.Server      // Per-port listener for preview proxy (port-based mode)
 	AgentChatProxyServer *http.Server      // Per-port listener for agent chat proxy (port-based mode)
 	VNCProxyServer       *http.Server      // Per-port listener for vnc proxy (auth-checked websockify reverse proxy)
@@ -632,6 +754,9 @@ type Session struct {
 	// session that is mid-restart. Guarded by mu. (A direct RestartProcess call
 	// needs no flag: it holds mu across the whole Wait-and-reassign.)
 	restarting bool
+	// endHookOnce makes on-session-end run once, whether the process exited
+	// on its own (startPTYReader) or the session was torn down (Close).
+	endHookOnce sync.Once
 
 	// ending is latched true the moment a teardown is committed to, BEFORE any
 	// process is signalled. Teardown is not instantaneous -- SIGTERM grace is 3s
@@ -715,7 +840,9 @@ func (s *Session) setRestarting(v bool) {
 func (s *Session) reapable() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	if s.restarting {
+	// A hibernated agent has exited too, but runs again on the next visit
+	// (session_hibernate.go).
+	if s.restarting || s.hibernated() {
 		return false
 	}
 	return s.Cmd != nil && s.Cmd.ProcessState != nil
@@ -766,6 +893,13 @@ func (s *Session) trackProxyServer(srv *http.Server, store func(*Session, *http.
 	s.mu.Unlock()
 }
 
+// isYoloMode reports whether the session is in YOLO mode.
+func (s *Session) isYoloMode() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.yoloMode
+}
+
 // computeRestartCommand returns the appropriate restart command based on YOLO mode.
 // If yoloMode is true and the agent supports YOLO, returns YoloRestartCmd.
 // Otherwise returns ShellRestartCmd.
@@ -776,6 +910,30 @@ func (s *Session) computeRestartCommand(yoloMode bool) string {
 	return s.AssistantConfig.ShellRestartCmd
 }
 
+// restartAgent replaces the agent process with replacement: msgKey is shown
+// in the terminal, the process group gets SIGTERM, and the exit handling
+// starts replacement in its place (pendingReplacement).
+func (s *Session) restartAgent(replacement, msgKey string) {
+	s.mu.Lock()
+	s.pendingReplacement = replacement
+	cmd := s.Cmd
+	s.mu.Unlock()
+
+	// Send visual feedback to terminal
+	feedbackMsg := []byte("\r\n" + tr(s.Locale, msgKey) + "\r\n")
+	s.vtMu.Lock()
+	s.vt.Write(feedbackMsg)
+	s.writeToRing(feedbackMsg)
+	s.vtMu.Unlock()
+	s.Broadcast(feedbackMsg)
+
+	// Kill process group - pendingReplacement will cause process to be replaced
+	if cmd != nil && cmd.Process != nil {
+		log.Printf("[KILL] agent restart: sending SIGTERM to process group -%d (server pid=%d)", cmd.Process.Pid, os.Getpid())
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+}
+
 // detectYoloMode checks if the given command contains YOLO mode flags.
 // Returns true if any known YOLO flag is present.
 func detectYoloMode(cmd string) bool {
@@ -804,11 +962,12 @@ type SessionEnvParams struct {
 	Theme         string
 	WorkDir       string
 	SessionMode   string
-	SID           string // session UUID; used to scope GIT_CONFIG_GLOBAL per-session
+	SID           string // session UUID; used to scope GIT_CONFIG_GLOBAL and TMPDIR per-session
+	SkipRepoEnv   bool   // leave out WorkDir's .swe-swe/env (an untrusted checkout)
 }
 
 func buildSessionEnv(p SessionEnvParams) []string {
-	env := filterEnv(os.Environ(), "TERM", "PORT", "BROWSER", "PATH", "COLORFGBG", "AGENT_CHAT_PORT", "AGENT_CHAT_DISABLE", "PUBLIC_PORT", "BROWSER_CDP_PORT", "BROWSER_VNC_PORT", "GH_TOKEN", "GITLAB_TOKEN")
+	env := filterEnv(os.Environ(), "TERM", "PORT", "BROWSER", "PATH", "COLORFGBG", "AGENT_CHAT_PORT", "AGENT_CHAT_DISABLE", "PUBLIC_PORT", "BROWSER_CDP_PORT", "BROWSER_VNC_PORT", "GH_TOKEN", "GITLAB_TOKEN", "TMPDIR")
 	env = append(env,
 		"TERM=xterm-256color",
 		fmt.Sprintf("PORT=%d", p.PreviewPort),
@@ -841,16 +1000,26 @@ func buildSessionEnv(p SessionEnvParams) []string {
 			env = append(env, "GIT_CONFIG_GLOBAL="+path)
 		}
 	}
+	// Per-session TMPDIR (session_tmpdir.go), removed on Close. Without
+	// one the server's own TMPDIR, if any, carries through.
+	var tmpDir string
+	if p.SID != "" {
+		var err error
+		if tmpDir, err = ensureSessionTmpDir(p.SID, p.WorkDir); err != nil {
+			log.Printf("Session %s: ensureSessionTmpDir failed: %v (shared temp dir)", p.SID, err)
+		}
+	}
+	if tmpDir != "" {
+		env = append(env, "TMPDIR="+tmpDir)
+	} else if v, ok := os.LookupEnv("TMPDIR"); ok {
+		env = append(env, "TMPDIR="+v)
+	}
 	// Disable agent chat sidecar for non-chat sessions
 	if p.SessionMode != "chat" {
 		env = append(env, "AGENT_CHAT_DISABLE=1")
 	}
 	// Set COLORFGBG so CLI tools (vim, bat, ls --color, etc.) adapt to background
-	if p.Theme == "light" {
-		env = append(env, "COLORFGBG=0;15") // dark-on-light
-	} else {
-		env = append(env, "COLORFGBG=15;0") // light-on-dark
-	}
+	env = append(env, "COLORFGBG="+colorFGBG(p.Theme))
 	// Surface the session's stored per-host HTTPS tokens under the conventional
 	// CLI env var names (github.com -> GH_TOKEN, gitlab.com/gitlab.* ->
 	// GITLAB_TOKEN) so tools like prctx pick them up without re-entry. Env is
@@ -877,8 +1046,8 @@ func buildSessionEnv(p SessionEnvParams) []string {
 	// Without this, such a line would silently drop the swe-swe PATH prefixes,
 	// causing `agent-chat`, `agent-whiteboard`, etc. to resolve to the wrong
 	// binary (or fail to resolve) and MCP servers to fail to start.
-	if p.WorkDir != "" {
-		env = append(env, loadEnvFile(filepath.Join(p.WorkDir, ".swe-swe", "env"), envLookup(env))...)
+	if p.WorkDir != "" && !p.SkipRepoEnv {
+		env = append(env, loadEnvFile(filepath.Join(p.WorkDir, ".swe-swe", "env"), p.SID, envLookup(env))...)
 	}
 	return env
 }
@@ -928,12 +1097,12 @@ func envLookup(env []string) func(string) string {
 	}
 }
 
-func loadEnvFile(path string, lookup func(string) string) []string {
+func loadEnvFile(path, sid string, lookup func(string) string) []string {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil
 	}
-	return parseEnvLines(string(data), lookup, nil, nil)
+	return parseEnvLines(string(data), sid, lookup, nil, nil)
 }
 
 // parseEnvLines parses KEY=VALUE lines from raw text, skipping blanks and
@@ -941,9 +1110,10 @@ func loadEnvFile(path string, lookup func(string) string) []string {
 // lines then `lookup` (nil -> os.Getenv). If drop is non-nil and returns
 // true for a (trimmed) key, that line is skipped entirely -- not stored,
 // not available for later expansion -- and the key is appended to *dropped.
+// ${secret:NAME} values are masked while session sid lives (secrets.go).
 // Shared by loadEnvFile (.swe-swe/env, no drops) and the repo env-vars
 // store (drops reserved keys) so both parse identically.
-func parseEnvLines(raw string, lookup func(string) string, drop func(string) bool, dropped *[]string) []string {
+func parseEnvLines(raw, sid string, lookup func(string) string, drop func(string) bool, dropped *[]string) []string {
 	if lookup == nil {
 		lookup = os.Getenv
 	}
@@ -962,6 +1132,9 @@ func parseEnvLines(raw string, lookup func(string) string, drop func(string) boo
 				continue
 			}
 			val = os.Expand(val, func(k string) string {
+				if name, ok := strings.CutPrefix(k, "secret:"); ok {
+					return expandSecretRef(sid, name) // ${secret:NAME}, secrets.go
+				}
 				if v, ok := local[k]; ok {
 					return v
 				}
@@ -1014,33 +1187,33 @@ func (s *Session) RemoveClient(conn *SafeConn) {
 	defer s.mu.Unlock()
 	delete(s.wsClients, conn)
 	delete(s.wsClientSizes, conn)
+	delete(s.sizeIndependent, conn)
 	s.lastActive = time.Now()
 	log.Printf("Client removed from session %s (total: %d)", s.UUID, len(s.wsClients))
 
 	// Recalculate PTY size based on remaining clients
-	if len(s.wsClientSizes) > 0 && s.PTY != nil {
-		minRows, minCols := s.calculateMinSize()
-
-		// Only resize if session's min size actually changed
-		if s.ptySize.Rows != minRows || s.ptySize.Cols != minCols {
-			s.ptySize = TermSize{Rows: minRows, Cols: minCols}
-			pty.Setsize(s.PTY, &pty.Winsize{Rows: minRows, Cols: minCols})
-			log.Printf("Session %s: resized PTY to %dx%d (from %d clients)", s.UUID, minCols, minRows, len(s.wsClientSizes))
-
-			// Also resize the virtual terminal for accurate snapshots
-			s.vtMu.Lock()
-			s.vt.Resize(int(minCols), int(minRows))
-			s.vtMu.Unlock()
-		}
-	}
+	s.resizePTYToClients()
 
 	// Broadcast status after lock is released
 	go s.BroadcastStatus()
 }
 
-// WriteInput writes data directly to the session PTY.
+// WriteInput writes data directly to the session PTY, waking the session
+// first if it is hibernated (session_hibernate.go).
 func (s *Session) WriteInput(data []byte) error {
+	if err := s.awaitAwake(); err != nil {
+		return err
+	}
 	_, err := s.PTY.Write(data)
+	noEcho := ptyEchoOff(s.PTY)
+	if noEcho {
+		s.recorder.Load().InputNoEcho(data)
+	} else {
+		s.recorder.Load().Input(data)
+	}
+	if err == nil {
+		s.AutoName.noteInput(data, noEcho)
+	}
 	return err
 }
 
@@ -1075,6 +1248,7 @@ func (s *Session) UpdateClientSize(conn *SafeConn, rows, cols uint16) {
 	// Apply to PTY
 	if s.PTY != nil {
 		pty.Setsize(s.PTY, &pty.Winsize{Rows: minRows, Cols: minCols})
+		s.recorder.Load().Resize(minRows, minCols)
 		log.Printf("Session %s: resized PTY to %dx%d (from %d clients)", s.UUID, minCols, minRows, len(s.wsClientSizes))
 	}
 
@@ -1087,9 +1261,14 @@ func (s *Session) UpdateClientSize(conn *SafeConn, rows, cols uint16) {
 	go s.BroadcastStatus()
 }
 
-// calculateMinSize returns the minimum rows and cols across all clients
+// calculateMinSize returns the pinned size if any, else the minimum rows and
+// cols across the clients that size the PTY (see sizingClientSizes).
 // Must be called with lock held
 func (s *Session) calculateMinSize() (uint16, uint16) {
+	if s.pinned() {
+		return s.pinnedSize.Rows, s.pinnedSize.Cols
+	}
+
 	// Return default if no clients at all
 	if len(s.wsClientSizes) == 0 {
 		return 24, 80 // default size
@@ -1098,7 +1277,7 @@ func (s *Session) calculateMinSize() (uint16, uint16) {
 	var minRows, minCols uint16 = 0xFFFF, 0xFFFF
 
 	// Include WebSocket client sizes
-	for _, size := range s.wsClientSizes {
+	for _, size := range s.sizingClientSizes() {
 		if size.Rows < minRows {
 			minRows = size.Rows
 		}
@@ -1146,10 +1325,14 @@ func (s *Session) Broadcast(data []byte) {
 	defer s.mu.RUnlock()
 
 	for conn := range s.wsClients {
+		if s.textOnlyClients[conn] {
+			continue
+		}
 		if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
-			log.Printf("Broadcast write error: %v", err)
+			s.logger().Warn("broadcast write error", "error", err)
 		}
 	}
+	s.textDirty()
 }
 
 // buildStatusPayload returns the map sent over the WebSocket as a session
@@ -1202,7 +1385,50 @@ func (s *Session) buildStatusPayload(viewers int, rows, cols uint16) map[string]
 	}
 	if agentChatPort != 0 {
 		status["agentChatProxyPort"] = agentChatProxyPort(agentChatPort)
+		if st := s.AgentChat.Status(); st != "" {
+			status["agentChatStatus"] = st
+		}
+	}
+	if h := previewDomainHost(s.UUID); h != "" {
+		status["previewDomainHost"] = h
+	}
+	if n := len(s.sizeIndependent); n > 0 {
+		status["independentViewers"] = n
+	}
+	if s.pinned() {
+		status["pinnedSize"] = map[string]uint16{"cols": s.pinnedSize.Cols, "rows": s.pinnedSize.Rows}
+	}
+	if s.RecordingPaused() {
+		status["recordingPaused"] = true
+	}
+	if n := s.queuedPrompts(); n > 0 {
+		status["queuedPrompts"] = n
+	}
+	if s.AutoName.named(s.Name) {
+		status["autoNamed"] = true
+		status["nameBranch"] = deriveBranchName(s.Name)
+	}
+	if state := s.AgentState(); state != "" {
+		status["agentState"] = state
 	}
+	if c := sessionWorktreeConflicts(s.UUID); len(c) > 0 {
+		status["worktreeConflicts"] = c
+	}
+	if t := s.testsSnapshot(); t != nil {
+		t.Output = ""
+		status["tests"] = t
+	}
+	if a := s.previewAppSnapshot(false); a.Status != "stopped" || a.ExitCode != nil {
+		status["previewApp"] = a
+	}
+	if pathProxyMode() {
+
+		// No per-port listeners: the page must use the path routes only.
+		for _, k := range []string{"previewProxyPort", "agentChatProxyPort", "vncProxyPort", "filesProxyPort"} {
+			delete(status, k)
+		}
+	}
+
 	// tunnelStatus rides along when the tunnel supervisor has
 	// observed at least one event. State="" means no supervisor or
 	// pre-startup -- the frontend treats that as "not in tunnel mode."
@@ -1219,16 +1445,17 @@ func (s *Session) BroadcastStatus() {
 
 	rows, cols := s.calculateMinSize()
 	status := s.buildStatusPayload(len(s.wsClients), rows, cols)
+	s.publishAdminStatusLocked()
 
 	data, err := json.Marshal(status)
 	if err != nil {
-		log.Printf("BroadcastStatus marshal error: %v", err)
+		s.logger().Error("broadcast status marshal error", "error", err)
 		return
 	}
 
 	for conn := range s.wsClients {
 		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("BroadcastStatus write error: %v", err)
+			s.logger().Warn("broadcast status write error", "error", err)
 		}
 	}
 	log.Printf("Session %s: broadcast status (viewers=%d, size=%dx%d)", s.UUID, len(s.wsClients), cols, rows)
@@ -1242,14 +1469,14 @@ func (s *Session) BroadcastStatus() {
 func (s *Session) BroadcastJSON(v interface{}) {
 	data, err := json.Marshal(v)
 	if err != nil {
-		log.Printf("BroadcastJSON marshal error: %v", err)
+		s.logger().Error("broadcast JSON marshal error", "error", err)
 		return
 	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	for conn := range s.wsClients {
 		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("BroadcastJSON write error: %v", err)
+			s.logger().Warn("broadcast JSON write error", "error", err)
 		}
 	}
 }
@@ -1271,22 +1498,15 @@ func (s *Session) BroadcastChatMessage(userName, text string) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	chatJSON := map[string]interface{}{
-		"type":      "chat",
-		"userName":  userName,
-		"text":      text,
-		"timestamp": time.Now().Format(time.RFC3339),
-	}
-
-	data, err := json.Marshal(chatJSON)
+	data, err := wsproto.Encode(wsproto.Chat{UserName: userName, Text: text, Timestamp: time.Now().Format(time.RFC3339)})
 	if err != nil {
-		log.Printf("BroadcastChatMessage marshal error: %v", err)
+		s.logger().Error("broadcast chat marshal error", "error", err)
 		return
 	}
 
 	for conn := range s.wsClients {
 		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("BroadcastChatMessage write error: %v", err)
+			s.logger().Warn("broadcast chat write error", "error", err)
 		}
 	}
 }
@@ -1334,48 +1554,25 @@ func (s *Session) BroadcastExit(exitCode int) {
 
 	data, err := json.Marshal(exitJSON)
 	if err != nil {
-		log.Printf("BroadcastExit marshal error: %v", err)
+		s.logger().Error("broadcast exit marshal error", "error", err)
 		return
 	}
 
 	for conn := range s.wsClients {
 		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("BroadcastExit write error: %v", err)
+			s.logger().Warn("broadcast exit write error", "error", err)
 		}
 	}
 	log.Printf("Session %s: broadcast exit (code=%d)", s.UUID, exitCode)
 }
 
-// writeToRing writes data to the ring buffer, wrapping around when full.
+// writeToRing writes data to the ring buffer, wrapping around when full, and
+// queues it for broadcast viewers (session_broadcast.go).
 // Must be called with vtMu held (shares lock with VT operations).
 func (s *Session) writeToRing(data []byte) {
-	for _, b := range data {
-		s.ringBuf[s.ringHead] = b
-		s.ringHead = (s.ringHead + 1) % RingBufferSize
-		if s.ringLen < RingBufferSize {
-			s.ringLen++
-		}
-	}
-}
-
-// readRing returns a copy of the ring buffer contents in correct order (oldest to newest).
-// Must be called with vtMu held (shares lock with VT operations).
-func (s *Session) readRing() []byte {
-	if s.ringLen == 0 {
-		return nil
-	}
-
-	result := make([]byte, s.ringLen)
-	if s.ringLen < RingBufferSize {
-		// Buffer not full yet, data starts at 0
-		copy(result, s.ringBuf[:s.ringLen])
-	} else {
-		// Buffer is full, data starts at ringHead (oldest)
-		start := s.ringHead
-		copy(result, s.ringBuf[start:])
-		copy(result[RingBufferSize-start:], s.ringBuf[:start])
-	}
-	return result
+	s.ring.Write(data)
+	s.lastOutput = time.Now()
+	s.noteBroadcast(data)
 }
 
 // Close terminates the session
@@ -1392,12 +1589,16 @@ func (s *Session) Close() {
 		log.Printf("Failed to save metadata on close: %v", err)
 	}
 
+	// Broadcast viewers see the end, then lose the link.
+	s.stopBroadcast()
+
 	s.mu.Lock()
 
 	// Mark closed before tearing down the per-port proxy servers so any listener
 	// still being wired (the session is in the sessions map before its listeners
 	// are set up) is shut down by trackProxyServer instead of stored and leaked.
 	s.closed = true
+	s.releaseHibernation()
 
 	// Cancel session context (used for coordinating shutdown of chat sessions)
 	if s.agentChatCancel != nil {
@@ -1446,27 +1647,40 @@ func (s *Session) Close() {
 	// e.g. claude creates its own process group).  Reuse the same
 	// descendant-aware kill that endSessionByUUID uses.
 	s.mu.Unlock()
+	// The repo's dev server (session_preview_app.go) has its own process
+	// group; stopping it broadcasts, so not under s.mu.
+	s.stopPreviewApp()
 	killSessionProcessGroup(s)
+	// Killing the host-side docker/kubectl exec does not stop what it
+	// started in a container backend; reach in and stop it, and free the
+	// backend's resources (a pod).
+	if s.Backend != nil {
+		go func(b sessionBackend) {
+			defer recoverGoroutine(fmt.Sprintf("backend release for session %s", s.UUID))
+			b.Release(s.UUID)
+		}(s.Backend)
+	}
 	s.mu.Lock()
 	if s.PTY != nil {
 		s.PTY.Close()
 	}
 
 	s.mu.Unlock()
-	return
-}
 
-// compressSnapshot compresses data using gzip for efficient WebSocket transmission
-func compressSnapshot(data []byte) ([]byte, error) {
-	var buf bytes.Buffer
-	w := gzip.NewWriter(&buf)
-	if _, err := w.Write(data); err != nil {
-		return nil, err
-	}
-	if err := w.Close(); err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+	// Runs once: a no-op if startPTYReader already ran it on a natural exit.
+	s.runSessionEndHook(sessionExitCode(s), false)
+
+	// The session page is gone with the session; drop its event buffer,
+	// message inbox and preview debug buffer.
+	unregisterSessionEvents(s.UUID)
+	unregisterSessionInbox(s.UUID)
+	publishAdminEvent(adminEvent{Type: "session_ended", Session: s.UUID})
+	unregisterPreviewDebug(s.UUID)
+	s.Checkpoints.Close()
+	s.FSWatch.Close()
+	removeSessionTmpDir(s.UUID, s.WorkDir)
+	forgetSessionSecrets(s.UUID)
+	return
 }
 
 // sendChunked sends compressed data as multiple chunks for iOS Safari compatibility.
@@ -1477,109 +1691,59 @@ func sendChunked(conn *SafeConn, data []byte, chunkSize int) (int, error) {
 		chunkSize = MinChunkSize
 	}
 
-	totalChunks := (len(data) + chunkSize - 1) / chunkSize
-	if totalChunks > 255 {
-		// Cap at 255 chunks (protocol limit with single byte for count)
-		totalChunks = 255
-		chunkSize = (len(data) + 254) / 255
-	}
-	if totalChunks == 0 {
-		totalChunks = 1 // At least one chunk even for empty data
-	}
-
-	for i := 0; i < totalChunks; i++ {
-		start := i * chunkSize
-		end := start + chunkSize
-		if end > len(data) {
-			end = len(data)
-		}
-
-		// Build chunk: [marker, index, total, ...payload]
-		chunk := make([]byte, 3+end-start)
-		chunk[0] = ChunkMarker
-		chunk[1] = byte(i)
-		chunk[2] = byte(totalChunks)
-		copy(chunk[3:], data[start:end])
-
+	chunks := wsproto.Chunks(data, chunkSize)
+	for i, chunk := range chunks {
 		if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
 			return i, err
 		}
-		log.Printf("Sent chunk %d/%d (%d bytes)", i+1, totalChunks, len(chunk)-3)
+		log.Printf("Sent chunk %d/%d (%d bytes)", i+1, len(chunks), len(chunk)-3)
 	}
 
-	return totalChunks, nil
+	return len(chunks), nil
+}
+
+// sendChunkedTraced is sendChunked inside a ws.send_chunked span; what names
+// the payload ("scrollback" or "snapshot").
+func sendChunkedTraced(ctx context.Context, what string, conn *SafeConn, data []byte, chunkSize int) (int, error) {
+	_, span := startSpan(ctx, "ws.send_chunked", "payload", what, "bytes", len(data))
+	n, err := sendChunked(conn, data, chunkSize)
+	span.SetAttrs("chunks", n)
+	span.End(err)
+	return n, err
 }
 
 // GenerateSnapshot creates ANSI escape sequences to recreate the current screen state
-// Returns gzip-compressed data for efficient transmission
+// Returns gzip-compressed data for efficient transmission. Only copying the
+// screen holds vtMu, so PTY output is not stalled by rendering and gzip. A
+// snapshot of an unchanged screen, or one already being rendered for another
+// joining client, is reused. The result is shared: do not modify it.
 func (s *Session) GenerateSnapshot() []byte {
 	s.vtMu.Lock()
-	defer s.vtMu.Unlock()
-
-	var buf bytes.Buffer
-
-	cols, rows := s.vt.Size()
-
-	// Clear screen and move cursor to home
-	buf.WriteString("\x1b[2J") // clear entire screen
-	buf.WriteString("\x1b[H")  // cursor to home (1,1)
-
-	// Track current attributes to minimize escape sequences
-	var lastFG, lastBG vt10x.Color = vt10x.DefaultFG, vt10x.DefaultBG
-
-	// Render each cell
-	for row := 0; row < rows; row++ {
-		for col := 0; col < cols; col++ {
-			cell := s.vt.Cell(col, row)
-
-			// Handle color changes
-			if cell.FG != lastFG || cell.BG != lastBG {
-				buf.WriteString("\x1b[0m") // reset attributes
-
-				// Set foreground color
-				if cell.FG != vt10x.DefaultFG && cell.FG < 256 {
-					fmt.Fprintf(&buf, "\x1b[38;5;%dm", cell.FG)
-				}
-				// Set background color
-				if cell.BG != vt10x.DefaultBG && cell.BG < 256 {
-					fmt.Fprintf(&buf, "\x1b[48;5;%dm", cell.BG)
-				}
-				lastFG, lastBG = cell.FG, cell.BG
-			}
-
-			// Write character (or space if null)
-			if cell.Char == 0 {
-				buf.WriteRune(' ')
-			} else {
-				buf.WriteRune(cell.Char)
-			}
-		}
-		// Move to next line (except for last row)
-		if row < rows-1 {
-			buf.WriteString("\r\n")
+	gen, tracked := termsnap.Generation(s.vt)
+	if tracked {
+		if reuse, ok := s.snapshots.Reuse(gen); ok {
+			s.vtMu.Unlock()
+			return reuse()
 		}
 	}
+	screen := termsnap.Copy(s.vt)
+	s.vtMu.Unlock()
 
-	// Reset attributes
-	buf.WriteString("\x1b[0m")
-
-	// Position cursor
-	cursor := s.vt.Cursor()
-	fmt.Fprintf(&buf, "\x1b[%d;%dH", cursor.Y+1, cursor.X+1)
-
-	rawData := buf.Bytes()
-
-	// Compress the snapshot
-	compressed, err := compressSnapshot(rawData)
-	if err != nil {
-		log.Printf("Failed to compress snapshot, sending uncompressed: %v", err)
-		return rawData
+	render := func() []byte {
+		rawData := termsnap.ANSI(screen)
+		compressed, err := termsnap.Compress(rawData)
+		if err != nil {
+			log.Printf("Failed to compress snapshot, sending uncompressed: %v", err)
+			return rawData
+		}
+		ratio := float64(len(compressed)) * 100 / float64(len(rawData))
+		log.Printf("Snapshot compressed: %d -> %d bytes (%.1f%%)", len(rawData), len(compressed), ratio)
+		return compressed
 	}
-
-	ratio := float64(len(compressed)) * 100 / float64(len(rawData))
-	log.Printf("Snapshot compressed: %d -> %d bytes (%.1f%%)", len(rawData), len(compressed), ratio)
-
-	return compressed
+	if !tracked {
+		return render()
+	}
+	return s.snapshots.Get(gen, render)
 }
 
 // RestartProcess restarts the shell process for this session
@@ -1594,6 +1758,11 @@ func (s *Session) RestartProcess(cmdStr string) error {
 		untrackPid(oldPID)
 		unregisterSessionPid(oldPID)
 	}
+	// A container backend's old agent outlives its docker exec client; stop
+	// it before starting the replacement.
+	if s.Backend != nil {
+		s.Backend.Cleanup(s.UUID)
+	}
 
 	// Close old PTY
 	if s.PTY != nil {
@@ -1601,13 +1770,7 @@ func (s *Session) RestartProcess(cmdStr string) error {
 	}
 
 	// Create new command and PTY
-	cmdName, cmdArgs := parseCommand(cmdStr)
-
-	// Wrap with script for recording (reuse existing recording prefix)
-	cmdName, cmdArgs = wrapWithScript(cmdName, cmdArgs, s.RecordingPrefix)
-
-	cmd := exec.Command(cmdName, cmdArgs...)
-	cmd.Env = buildSessionEnv(SessionEnvParams{
+	env := buildSessionEnv(SessionEnvParams{
 		PreviewPort:   s.PreviewPort,
 		AgentChatPort: s.AgentChatPort,
 		PublicPort:    s.PublicPort,
@@ -1617,15 +1780,26 @@ func (s *Session) RestartProcess(cmdStr string) error {
 		WorkDir:       s.WorkDir,
 		SessionMode:   s.SessionMode,
 		SID:           s.UUID,
+		SkipRepoEnv:   s.untrustedRepo,
 	})
 	// Mirror the initial-spawn env (see createSession): SESSION_UUID and the
 	// per-session MCP key must survive a restart, or the in-container shims
 	// (open/xdg-open -> preview proxy open endpoint) break afterwards.
 	// issueSessionKey is idempotent, so this returns the same key.
-	cmd.Env = append(cmd.Env,
+	env = append(env,
 		fmt.Sprintf("SESSION_UUID=%s", s.UUID),
 		fmt.Sprintf("MCP_AUTH_KEY=%s", issueSessionKey(s.UUID)),
 	)
+	cmdName, cmdArgs := parseCommand(cmdStr)
+	// Same backend as the original spawn.
+	if s.Backend != nil {
+		cmdName, cmdArgs = s.Backend.Command(cmdName, cmdArgs, env)
+	}
+
+	cmdName, cmdArgs = wrapWithShell(cmdName, cmdArgs)
+
+	cmd := exec.Command(cmdName, cmdArgs...)
+	cmd.Env = env
 	if s.WorkDir != "" {
 		cmd.Dir = s.WorkDir
 	}
@@ -1639,8 +1813,23 @@ func (s *Session) RestartProcess(cmdStr string) error {
 		return err
 	}
 
-	// Set initial terminal size
-	pty.Setsize(ptmx, &pty.Winsize{Rows: 24, Cols: 80})
+	// Same size as before the restart: the pinned size, else the clients'
+	rows, cols := uint16(24), uint16(80)
+	if s.pinned() || len(s.wsClientSizes) > 0 {
+		rows, cols = s.calculateMinSize()
+	}
+	pty.Setsize(ptmx, &pty.Winsize{Rows: rows, Cols: cols})
+	s.ptySize = TermSize{Rows: rows, Cols: cols}
+
+	// Record the new process under the same prefix, still paused if the old
+	// one was.
+	old := s.recorder.Load()
+	old.Close(0)
+	recorder, err := startSessionRecorder(s.RecordingPrefix, cmdArgs[1], rows, cols, old.Paused())
+	if err != nil {
+		log.Printf("Session %s: not recording: %v", s.UUID, err)
+	}
+	s.recorder.Store(recorder)
 
 	trackPid(cmd.Process.Pid)
 	registerSessionPid(cmd.Process.Pid, s.UUID)
@@ -1658,6 +1847,7 @@ func (s *Session) RestartProcess(cmdStr string) error {
 func (s *Session) startPTYReader() {
 	go func() {
 		defer recoverGoroutine(fmt.Sprintf("PTY reader for session %s", s.UUID))
+		defer s.sinks.close()
 		buf := make([]byte, 4096)
 		for {
 			s.mu.RLock()
@@ -1667,7 +1857,7 @@ func (s *Session) startPTYReader() {
 			n, err := ptyFile.Read(buf)
 			if err != nil {
 				if err != io.EOF {
-					log.Printf("PTY read error: %v", err)
+					s.logger().Error("PTY read error", "error", err)
 				}
 
 				// Process has died - check if we should restart
@@ -1711,6 +1901,25 @@ func (s *Session) startPTYReader() {
 					unregisterSessionPid(ptyPID)
 				}
 
+				s.recorder.Load().Close(exitCode)
+
+				// Hibernation stopped the agent: wait for the next visit and
+				// start it again (session_hibernate.go).
+				if s.hibernated() {
+					resumed, err := s.sleep()
+					if resumed {
+						continue
+					}
+					if err == nil {
+						return // closed while hibernated; Close did the rest
+					}
+					// Could not wake: end the session below, for whoever
+					// came to wake it.
+					s.mu.RLock()
+					clientCount = len(s.wsClients)
+					s.mu.RUnlock()
+				}
+
 				// Check for pending replacement (e.g., from YOLO toggle)
 				s.mu.Lock()
 				replacementCmd := s.pendingReplacement
@@ -1728,7 +1937,7 @@ func (s *Session) startPTYReader() {
 					s.setRestarting(false)
 					if err != nil {
 						log.Printf("Session %s: failed to replace process: %v", s.UUID, err)
-						errMsg := []byte("\r\n[Failed to replace process: " + err.Error() + "]\r\n")
+						errMsg := []byte("\r\n" + tr(s.Locale, "terminal.replaceFailed", err.Error()) + "\r\n")
 						s.vtMu.Lock()
 						s.vt.Write(errMsg)
 						s.writeToRing(errMsg)
@@ -1743,6 +1952,7 @@ func (s *Session) startPTYReader() {
 					// reaper see it.
 					s.setRestarting(false)
 				}
+				publishAdminExit(s.UUID, exitCode)
 
 				if clientCount == 0 {
 					log.Printf("Session %s: process exited with no clients", s.UUID)
@@ -1756,6 +1966,7 @@ func (s *Session) startPTYReader() {
 					if err := s.saveMetadata(); err != nil {
 						log.Printf("Failed to save metadata on exit: %v", err)
 					}
+					s.runSessionEndHook(exitCode, false)
 					return
 				}
 
@@ -1771,7 +1982,7 @@ func (s *Session) startPTYReader() {
 					log.Printf("Failed to save metadata on exit: %v", err)
 				}
 
-				exitMsg := []byte(fmt.Sprintf("\r\n[Process exited (code %d)]\r\n", exitCode))
+				exitMsg := []byte("\r\n" + tr(s.Locale, "terminal.processExited", exitCode) + "\r\n")
 				s.vtMu.Lock()
 				s.vt.Write(exitMsg)
 				s.writeToRing(exitMsg)
@@ -1780,6 +1991,7 @@ func (s *Session) startPTYReader() {
 
 				// Send structured exit message so browser can prompt user
 				s.BroadcastExit(exitCode)
+				s.runSessionEndHook(exitCode, true)
 				return
 			}
 
@@ -1790,16 +2002,24 @@ func (s *Session) startPTYReader() {
 			// \x1b[{row};{col}R. In a web terminal, the browser round-trip
 			// is too slow for crossterm's timeout. Respond immediately from
 			// the server using the current PTY size as the cursor position.
-			if bytes.Contains(data, dsrQuery) {
+			// A query split across two reads is still seen (termsnap).
+			if n := s.dsr.Queries(data); n > 0 {
 				s.mu.RLock()
 				rows := s.ptySize.Rows
 				s.mu.RUnlock()
 				if rows == 0 {
 					rows = 24
 				}
-				response := []byte(fmt.Sprintf("\x1b[%d;1R", rows))
+				response := bytes.Repeat(termsnap.CursorReport(int(rows), 1), n)
 				s.PTY.Write(response)
+				s.recorder.Load().Input(response)
 			}
+			if s.bgQuery.Queries(data) > 0 {
+				s.bgQueryPending.Store(true)
+			}
+
+			s.recorder.Load().Output(data)
+			s.sinks.write(data)
 
 			// Update virtual terminal state and ring buffer
 			s.vtMu.Lock()
@@ -1836,7 +2056,7 @@ var (
 	shellCmd            string
 	shellRestartCmd     string
 	workingDir          string
-	availableAssistants []AssistantConfig // Populated at startup by detectAvailableAssistants
+	availableAssistants []AssistantConfig // Populated at startup by detectAvailableAssistants; read with installedAssistants
 
 	// SSL certificate download endpoint
 	tlsCertPath string // Path to TLS certificate file
@@ -1855,48 +2075,61 @@ var (
 // If -shell flag is provided, it adds a "custom" assistant.
 // Returns an error if no assistants are available.
 func detectAvailableAssistants() error {
-	availableAssistants = nil
+	found := detectAssistants(func(binary string) bool {
+		_, err := exec.LookPath(binary)
+		return err == nil
+	})
+	for _, cfg := range found {
+		switch {
+		case cfg.Binary == "custom":
+			log.Printf("Detected assistant: Custom (shell=%q, shell-restart=%q)", shellCmd, shellRestartCmd)
+		case cfg.Homepage:
+			log.Printf("Detected assistant: %s (%s)", cfg.Name, cfg.Binary)
+		}
+	}
+	setAvailableAssistants(found)
+
+	if len(found) == 0 {
+		return fmt.Errorf("no AI assistants available: install claude, gemini, codex, goose, or aider; or provide -shell flag")
+	}
 
-	// Check each predefined assistant
+	return nil
+}
+
+// detectAssistants lists the assistants to offer, given which binaries are
+// installed: every non-homepage one (like shell), the homepage ones that
+// are installed, and the custom one from the -shell flag.
+func detectAssistants(installed func(binary string) bool) []AssistantConfig {
+	var found []AssistantConfig
 	for _, cfg := range assistantConfigs {
-		// Non-homepage assistants (like shell) are always available
-		if !cfg.Homepage {
-			availableAssistants = append(availableAssistants, cfg)
-			continue
-		}
-		// Homepage assistants need their binary to be installed
-		if _, err := exec.LookPath(cfg.Binary); err == nil {
-			log.Printf("Detected assistant: %s (%s)", cfg.Name, cfg.Binary)
-			availableAssistants = append(availableAssistants, cfg)
+		// Non-homepage assistants (like shell) are always available;
+		// homepage assistants need their binary to be installed.
+		if !cfg.Homepage || installed(cfg.Binary) {
+			found = append(found, cfg)
 		}
 	}
 
 	// Add custom assistant if -shell flag was provided (non-default)
 	if shellCmd != "claude" || shellRestartCmd != "claude --continue" {
-		log.Printf("Detected assistant: Custom (shell=%q, shell-restart=%q)", shellCmd, shellRestartCmd)
-		availableAssistants = append(availableAssistants, AssistantConfig{
+		found = append(found, AssistantConfig{
 			Name:            "Custom",
 			ShellCmd:        shellCmd,
 			ShellRestartCmd: shellRestartCmd,
 			Binary:          "custom",
 		})
 	}
-
-	if len(availableAssistants) == 0 {
-		return fmt.Errorf("no AI assistants available: install claude, gemini, codex, goose, or aider; or provide -shell flag")
-	}
-
-	return nil
+	return found
 }
 
 // agentChatWaitingPage is shown by the agent chat proxy when the MCP sidecar
 // hasn't started yet. It auto-polls and reloads once the backend is up.
-// Note: %% is used to escape % characters in CSS for fmt.Fprintf
+// Note: %% is used to escape % characters in CSS for fmt.Fprintf; the verbs
+// are the locale, the title and the status text (i18n.go).
 const agentChatWaitingPage = `<!DOCTYPE html>
-<html>
+<html lang="%s">
 <head>
     <meta charset="utf-8">
-    <title>Agent Chat</title>
+    <title>%s</title>
     <script>
         (function(){var m=document.cookie.match(/(?:^|;\s*)swe-swe-theme=([^;]+)/);
         if(m)document.documentElement.setAttribute('data-theme',m[1]);})();
@@ -1944,7 +2177,7 @@ const agentChatWaitingPage = `<!DOCTYPE html>
 <body>
     <div class="status">
         <span class="status-dot"></span>
-        <span>Waiting for Agent Chat...</span>
+        <span>%s</span>
     </div>
     <script>
         async function checkApp() {
@@ -2034,7 +2267,8 @@ func agentChatProxyHandler(target *url.URL) http.Handler {
 			log.Printf("Agent chat proxy error: %v", err)
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
 			w.WriteHeader(http.StatusBadGateway)
-			fmt.Fprint(w, agentChatWaitingPage)
+			locale := requestLocale(r)
+			fmt.Fprintf(w, agentChatWaitingPage, locale, trHTML(locale, "agentChat.title"), trHTML(locale, "agentChat.waiting"))
 			return
 		}
 		defer resp.Body.Close()
@@ -2075,65 +2309,6 @@ func processProxyResponse(w http.ResponseWriter, resp *http.Response, target *ur
 	io.Copy(w, resp.Body)
 }
 
-// handleWebSocketRelay upgrades both sides to WebSocket and relays frames
-// between client and backend. Using gorilla/websocket on both sides gives
-// each proxy hop a proper WebSocket handshake, which is required for
-// multi-hop proxy chains (e.g. Cloudflare -> cloudflared -> Traefik).
-func handleWebSocketRelay(w http.ResponseWriter, r *http.Request, target *url.URL) {
-	// Dial backend FIRST -- if it's down we can still return HTTP 502
-	backendWsURL := fmt.Sprintf("ws://%s%s", target.Host, r.URL.RequestURI())
-	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
-	backendConn, _, err := dialer.Dial(backendWsURL, nil)
-	if err != nil {
-		log.Printf("Agent chat proxy: WebSocket backend dial error: %v", err)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
-		return
-	}
-	defer backendConn.Close()
-
-	// Upgrade client connection
-	clientConn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("Agent chat proxy: WebSocket client upgrade error: %v", err)
-		return
-	}
-	defer clientConn.Close()
-
-	// Bidirectional frame relay
-	errc := make(chan error, 2)
-	// backend -> client
-	go func() {
-		defer recoverGoroutine("WebSocket relay backend->client")
-		for {
-			mt, msg, err := backendConn.ReadMessage()
-			if err != nil {
-				errc <- err
-				return
-			}
-			if err := clientConn.WriteMessage(mt, msg); err != nil {
-				errc <- err
-				return
-			}
-		}
-	}()
-	// client -> backend
-	go func() {
-		defer recoverGoroutine("WebSocket relay client->backend")
-		for {
-			mt, msg, err := clientConn.ReadMessage()
-			if err != nil {
-				errc <- err
-				return
-			}
-			if err := backendConn.WriteMessage(mt, msg); err != nil {
-				errc <- err
-				return
-			}
-		}
-	}()
-	<-errc // first error closes both via defers
-}
-
 // isHopByHopHeader returns true if the header is a hop-by-hop header
 func isHopByHopHeader(header string) bool {
 	hopByHop := map[string]bool{
@@ -2173,6 +2348,9 @@ func main() {
 			"the localhost tunnel client can reach swe-swe-server.")
 	version := flag.Bool("version", false, "Show version and exit")
 	dumpTemplates := flag.String("dump-container-templates", "", "Dump all container templates to directory and exit")
+	diffTemplates := flag.String("diff-container-templates", "", "Show what updating the repo at this directory to the container templates would change, write nothing, and exit")
+	updateTemplates := flag.String("update-container-templates", "", "Update the repo at this directory to the container templates (three-way merge), and exit")
+	replaceTemplates := flag.String("replace-templates", "", "With -update-container-templates: comma-separated template paths to overwrite instead of merge")
 	flag.StringVar(&shellCmd, "shell", "claude", "Command to execute")
 	flag.StringVar(&shellRestartCmd, "shell-restart", "claude --continue", "Command to restart on process death")
 	flag.StringVar(&workingDir, "working-directory", "", "Working directory for shell (defaults to current directory)")
@@ -2226,8 +2404,64 @@ func main() {
 	browserBackendHost := flag.String("browser-backend-host", "",
 		"browser-backend mode: hostname clients should dial for the CDP/VNC "+
 			"ports (env: SWE_BROWSER_BACKEND_HOST).")
+	logFormat := flag.String("log-format", "",
+		"Log output format: text (default) | json. Env: SWE_LOG_FORMAT.")
+	logLevelFlag := flag.String("log-level", "",
+		"Minimum log level: debug | info (default) | warn | error. Adjustable "+
+			"at runtime via /api/log-level. Env: SWE_LOG_LEVEL.")
+	logFile := flag.String("log-file", "",
+		"Also write logs to this file, rotated by size. Env: SWE_LOG_FILE.")
+	logFileMaxMB := flag.Int("log-file-max-mb", 50, "Rotate -log-file once it reaches this many MB.")
+	logFileBackups := flag.Int("log-file-backups", 5, "Number of rotated -log-file backups to keep.")
+	templatesDirFlag := flag.String("templates-dir", "",
+		"Directory of UI overrides: page-templates/*.html and static/* files "+
+			"here replace the built-in ones. Env: SWE_TEMPLATES_DIR.")
+	fetchIntervalFlag := flag.String("fetch-interval", "",
+		"Run git fetch in the workspace and each repo this often, e.g. 15m, "+
+			"and announce branches that moved (off by default). Env: SWE_FETCH_INTERVAL.")
+	configFlag := flag.String("config", "",
+
+		"Path to a JSON config file covering listen address, paths, port "+
+			"ranges, assistant command, auth, tunnel and exec settings. Flags "+
+			"and env vars override it. Env: SWE_CONFIG.")
 	flag.Parse()
 
+	// `swe-swe-server self-update [...]` installs a newer release and exits
+	// (self_update.go).
+	if flag.Arg(0) == "self-update" {
+		os.Exit(runSelfUpdate(flag.Args()[1:]))
+	}
+
+	// Apply the optional config file underneath flags and env (flag -> env
+	// -> config file -> default) before anything below reads them; see
+	// config_file.go.
+	serverConfigPath = firstNonEmpty(*configFlag, os.Getenv("SWE_CONFIG"))
+	cfgSources, cfgErr := applyServerConfig(flag.CommandLine, serverConfigPath)
+	if cfgErr != nil {
+		log.Fatalf("Config file: %v", cfgErr)
+	}
+	serverConfigSources = cfgSources
+
+	// Structured logging: from here on log.Printf goes through slog (see
+	// logging.go), so set it up before anything else logs.
+	logCloser, logErr := setupLogging(logOptions{
+		Format:     firstNonEmpty(*logFormat, os.Getenv("SWE_LOG_FORMAT")),
+		Level:      firstNonEmpty(*logLevelFlag, os.Getenv("SWE_LOG_LEVEL")),
+		File:       firstNonEmpty(*logFile, os.Getenv("SWE_LOG_FILE")),
+		MaxSizeMB:  *logFileMaxMB,
+		MaxBackups: *logFileBackups,
+	})
+	if logErr != nil {
+		log.Fatalf("Logging: %v", logErr)
+	}
+	if logCloser != nil {
+		defer logCloser.Close()
+	}
+	setupTracing()
+	if serverConfigPath != "" {
+		log.Printf("Loaded config file %s", serverConfigPath)
+	}
+
 	// Resolve the Agent View backend (flag -> env -> default "local"). On a
 	// lean host with no display stack, local mode reports the tab unavailable
 	// rather than 500ing on browser/start.
@@ -2242,6 +2476,72 @@ func main() {
 	reposDir = firstNonEmpty(*reposFlag, os.Getenv("SWE_REPOS_DIR"), reposDir)
 	sweHomeDir = firstNonEmpty(*sweHomeFlag, os.Getenv("SWE_HOME_DIR"), sweHomeDir)
 	recordingsDir = filepath.Join(workspaceDir, ".swe-swe", "recordings")
+	loadSecrets(recordingsDir)
+	loadExecAllowlist()
+	if err := loadAuthUsers(); err != nil {
+		log.Fatalf("Auth users: %v", err)
+	}
+	if err := loadRateLimits(); err != nil {
+		log.Fatalf("Rate limits: %v", err)
+	}
+	if err := loadHookTimeout(); err != nil {
+		log.Fatalf("Session hooks: %v", err)
+	}
+	if err := loadSetupTimeout(); err != nil {
+		log.Fatalf("Setup task: %v", err)
+	}
+	if err := loadUsageReport(); err != nil {
+		log.Fatalf("Usage report: %v", err)
+	}
+	if err := loadBackupConfig(); err != nil {
+		log.Fatalf("Backups: %v", err)
+	}
+	if err := loadSessionLimits(); err != nil {
+		log.Fatalf("Session limits: %v", err)
+	}
+	if err := loadSessionNamePolicy(); err != nil {
+		log.Fatalf("Session names: %v", err)
+	}
+	if err := loadRepoFetchInterval(firstNonEmpty(*fetchIntervalFlag, os.Getenv("SWE_FETCH_INTERVAL"))); err != nil {
+		log.Fatalf("Repo fetch: %v", err)
+	}
+	if err := loadReadyPatterns(); err != nil {
+		log.Fatalf("Ready patterns: %v", err)
+	}
+	if err := loadApprovalPatterns(); err != nil {
+		log.Fatalf("Approval patterns: %v", err)
+	}
+	if err := loadApprovalKeys(); err != nil {
+		log.Fatalf("Approval keys: %v", err)
+	}
+	if err := loadPromptQuiet(); err != nil {
+		log.Fatalf("Prompt queue: %v", err)
+	}
+	if err := loadCheckpoints(); err != nil {
+		log.Fatalf("Checkpoints: %v", err)
+	}
+	if err := loadHibernateAfter(); err != nil {
+		log.Fatalf("Hibernation: %v", err)
+	}
+	if err := loadAssistantCheckInterval(); err != nil {
+		log.Fatalf("Assistant checks: %v", err)
+	}
+	if err := loadFSWatch(); err != nil {
+		log.Fatalf("File watch: %v", err)
+	}
+	if err := loadTestTimeout(); err != nil {
+		log.Fatalf("Test timeout: %v", err)
+	}
+	if err := loadProxyMode(); err != nil {
+		log.Fatalf("Proxy mode: %v", err)
+	}
+	if err := loadPreviewDomain(); err != nil {
+		log.Fatalf("Preview domain: %v", err)
+	}
+	if err := loadTemplatesDir(*templatesDirFlag); err != nil {
+		log.Fatalf("Templates dir: %v", err)
+	}
+	loadAgentChatCommand()
 
 	// Resolve the swe-swe-server bind early so the tunnel supervisor can
 	// log the correct OPEN AT URL ({port}.{hostname}) when it learns the
@@ -2255,32 +2555,12 @@ func main() {
 		}
 	}
 
-	// Override CDP port range from environment (set by docker-compose).
-	// Parsed BEFORE the browser-backend dispatch: the allocation service
-	// hands these exact ports to clients, so ignoring the env there meant
+	// Port pools from the SWE_*_PORTS variables (set by docker-compose).
+	// Loaded BEFORE the browser-backend dispatch: the allocation service
+	// hands CDP/VNC pool ports to clients, so ignoring the env there meant
 	// the container's published/documented ranges silently did not apply.
-	if portRange := os.Getenv("SWE_CDP_PORTS"); portRange != "" {
-		if parts := strings.SplitN(portRange, "-", 2); len(parts) == 2 {
-			if start, err := strconv.Atoi(parts[0]); err == nil {
-				if end, err := strconv.Atoi(parts[1]); err == nil {
-					cdpPortStart = start
-					cdpPortEnd = end
-				}
-			}
-		}
-	}
-
-	// Override VNC port range from environment (set by docker-compose).
-	// Same before-dispatch requirement as SWE_CDP_PORTS above.
-	if portRange := os.Getenv("SWE_VNC_PORTS"); portRange != "" {
-		if parts := strings.SplitN(portRange, "-", 2); len(parts) == 2 {
-			if start, err := strconv.Atoi(parts[0]); err == nil {
-				if end, err := strconv.Atoi(parts[1]); err == nil {
-					vncPortStart = start
-					vncPortEnd = end
-				}
-			}
-		}
+	if err := loadPortPools(); err != nil {
+		log.Fatalf("Port pools: %v", err)
 	}
 
 	// browser-backend mode: run the standalone Agent View allocation service
@@ -2321,45 +2601,6 @@ func main() {
 		ClientCertPath: resolvedTunnelClientCert,
 	})
 
-	// Override preview port range from environment (set by docker-compose)
-	if portRange := os.Getenv("SWE_PREVIEW_PORTS"); portRange != "" {
-		if parts := strings.SplitN(portRange, "-", 2); len(parts) == 2 {
-			if start, err := strconv.Atoi(parts[0]); err == nil {
-				if end, err := strconv.Atoi(parts[1]); err == nil {
-					previewPortStart = start
-					previewPortEnd = end
-				}
-			}
-		}
-	}
-
-	// Override agent chat port range from environment (set by docker-compose)
-	if portRange := os.Getenv("SWE_AGENT_CHAT_PORTS"); portRange != "" {
-		if parts := strings.SplitN(portRange, "-", 2); len(parts) == 2 {
-			if start, err := strconv.Atoi(parts[0]); err == nil {
-				if end, err := strconv.Atoi(parts[1]); err == nil {
-					agentChatPortStart = start
-					agentChatPortEnd = end
-				}
-			}
-		}
-	}
-
-	// Override public port range from environment (set by docker-compose)
-	if portRange := os.Getenv("SWE_PUBLIC_PORTS"); portRange != "" {
-		if parts := strings.SplitN(portRange, "-", 2); len(parts) == 2 {
-			if start, err := strconv.Atoi(parts[0]); err == nil {
-				if end, err := strconv.Atoi(parts[1]); err == nil {
-					publicPortStart = start
-					publicPortEnd = end
-				}
-			}
-		}
-	}
-
-	// (SWE_CDP_PORTS / SWE_VNC_PORTS are parsed earlier, before the
-	// browser-backend mode dispatch.)
-
 	// Override proxy port offset from environment (set by docker-compose / .env)
 	if offsetStr := os.Getenv("SWE_PROXY_PORT_OFFSET"); offsetStr != "" {
 		if v, err := strconv.Atoi(offsetStr); err == nil {
@@ -2384,6 +2625,32 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Handle --diff-container-templates flag (template_diff.go)
+	if *diffTemplates != "" {
+		changes, err := diffContainerTemplates(*diffTemplates)
+		if err != nil {
+			log.Fatalf("Failed to diff container templates: %v", err)
+		}
+		writeTemplateChanges(os.Stdout, changes)
+		os.Exit(0)
+	}
+
+	// Handle --update-container-templates flag (template_update.go)
+	if *updateTemplates != "" {
+		var replace []string
+		for _, p := range strings.Split(*replaceTemplates, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				replace = append(replace, p)
+			}
+		}
+		results, err := applyTemplateUpdate(*updateTemplates, replace, false)
+		if err != nil {
+			log.Fatalf("Failed to update container templates: %v", err)
+		}
+		writeTemplateResults(os.Stdout, results)
+		os.Exit(0)
+	}
+
 	log.Printf("swe-swe-server %s (%s)", Version, GitCommit)
 	// Baseline parent identity at boot. Paired with the shutdown log, this
 	// makes a graceful "exit 0" attributable to whoever forwarded the signal.
@@ -2401,7 +2668,7 @@ func main() {
 	if err := detectAvailableAssistants(); err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Available assistants: %d", len(availableAssistants))
+	log.Printf("Available assistants: %d", len(installedAssistants()))
 
 	// Initialize SSL certificate download endpoint
 	tlsCertPath = os.Getenv("TLS_CERT_PATH")
@@ -2412,54 +2679,50 @@ func main() {
 		log.Printf("SSL certificate available at: /ssl/ca.crt")
 	}
 
-	// Parse templates
-	indexContent, err := pageTemplatesFS.ReadFile("page-templates/index.html")
+	// Parse templates (-templates-dir overrides first; see templates_dir.go)
+	var err error
+	indexTemplate, err = parsePageTemplate("index", "index.html")
 	if err != nil {
 		log.Fatal(err)
 	}
-	indexTemplate, err = template.New("index").Parse(string(indexContent))
+
+	selectionTemplate, err = parsePageTemplate("selection", "selection.html")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	selectionContent, err := pageTemplatesFS.ReadFile("page-templates/selection.html")
+	forkConfirmTemplate, err = parsePageTemplate("fork-confirm", "fork-confirm.html")
 	if err != nil {
 		log.Fatal(err)
 	}
-	selectionTemplate, err = template.New("selection").Parse(string(selectionContent))
+
+	deepLinkTemplate, err = parsePageTemplate("deep-link", "deep-link.html")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	forkConfirmContent, err := pageTemplatesFS.ReadFile("page-templates/fork-confirm.html")
+	broadcastWatchTemplate, err = parsePageTemplate("watch", "watch.html")
 	if err != nil {
 		log.Fatal(err)
 	}
-	forkConfirmTemplate, err = template.New("fork-confirm").Parse(string(forkConfirmContent))
+
+	// Serve static files from embedded filesystem, under -templates-dir/static
+	embeddedStatic, err := fs.Sub(staticFS, "static")
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// Serve static files from embedded filesystem
-	staticContent, err := fs.Sub(staticFS, "static")
+	staticContent := withTemplatesDir(embeddedStatic, "static")
+	staticHandler := http.FileServer(http.FS(staticContent))
+	// Hashed, compressed, ETagged copies of the same files (static_assets.go);
+	// staticHandler only answers what the table doesn't have (directories).
+	staticAssets, err = loadStaticAssets(staticContent)
 	if err != nil {
 		log.Fatal(err)
 	}
-	staticHandler := http.FileServer(http.FS(staticContent))
 
-	// Handler for terminal-ui.js with template substitution (dev mode compatibility)
-	http.HandleFunc("/terminal-ui.js", func(w http.ResponseWriter, r *http.Request) {
-		content, err := staticFS.ReadFile("static/terminal-ui.js")
-		if err != nil {
-			http.Error(w, "File not found", http.StatusNotFound)
-			return
-		}
-		// Replace template variables with defaults for dev mode
-		result := strings.ReplaceAll(string(content), "{{TERMINAL_FONT_SIZE}}", "14")
-		result = strings.ReplaceAll(result, "{{TERMINAL_FONT_FAMILY}}", "Monaco, Menlo, Consolas, monospace")
-		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
-		w.Write([]byte(result))
-	})
+	// Upgrade metadata written by older servers (and repair what can be
+	// repaired) before the recovery scan below judges it.
+	migrateRecordingMetadata()
 
 	// One-shot recovery scan: rename any unparseable metadata.json to
 	// .corrupt so they stop hiding their recordings on the homepage. Also
@@ -2470,6 +2733,16 @@ func main() {
 	go sessionReaper()
 	go compressionWorker()
 	go pendingSessionSweeper()
+	go thumbnailRefresher()
+	go usageAggregator()
+	go backupScheduler()
+	go worktreeConflictAnalyzer()
+	go repoFetcher()
+	go hibernationSweeper()
+	go assistantChecker()
+	go recordingListRefresher()
+	go agentStateTracker()
+	go restartOnSignal()
 
 	// Global MCP orchestration server
 	orchMCPSrv := mcp.NewServer(&mcp.Implementation{
@@ -2487,6 +2760,9 @@ func main() {
 	// resolved caller session UUID into the request context, so create_session
 	// can inherit the calling session's git credentials (see mcp_authkey.go).
 	http.Handle("/mcp", mcpAuthMiddleware(orchHandler))
+	// The calling (or ?session=) session's preview MCP tools, so clients need
+	// not know the session UUID (see preview_debug.go).
+	http.HandleFunc("/mcp/preview", handlePreviewMCP)
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// Root path: show assistant selection page
@@ -2540,6 +2816,8 @@ func main() {
 					// Survives a reload: without this the "committing" card
 					// reverted to looking live until the next live-poll tick.
 					EndRequested: sess.isEndRequested(),
+					Thumb:        sessionThumbHash(sess.UUID),
+					AgentState:   sess.AgentState(),
 					Query: SessionPageQuery{
 						Assistant:   sess.Assistant,
 						SessionMode: sess.SessionMode,
@@ -2581,8 +2859,13 @@ func main() {
 						summaryLine = termLine
 					}
 				}
+				// The live agent state, when known, beats the chat log's.
+				if st := agentStateSummaryStatus(si.sess.AgentState()); st != "" {
+					status = st
+				}
 				sessionsByAssistant[si.assistant][si.index].SummaryLine = summaryLine
 				sessionsByAssistant[si.assistant][si.index].SummaryStatus = status
+				sessionsByAssistant[si.assistant][si.index].Health = si.sess.checkHealth(time.Now())
 				if si.pid > 0 {
 					rss := getProcessTreeRSS(si.pid)
 					if rss > 0 {
@@ -2598,9 +2881,8 @@ func main() {
 				})
 			}
 
-			// Load recordings (sorted by timestamp) for the page-level recordings list.
-			recordings := loadEndedRecordings()
-
+			// One page of the recordings (newest first) for the page-level
+			// recordings list, cut from the index (recording_list.go).
 			const defaultRecordingsPerPage = 10
 			recordingsPerPage := defaultRecordingsPerPage
 			if sizeStr := r.URL.Query().Get("recordings_page_size"); sizeStr != "" {
@@ -2621,19 +2903,8 @@ func main() {
 					recordingsPage = pageVal
 				}
 			}
-			recordingsTotalPages := 0
-			if len(recordings) > 0 {
-				recordingsTotalPages = (len(recordings) + recordingsPerPage - 1) / recordingsPerPage
-				if recordingsPage > recordingsTotalPages {
-					recordingsPage = recordingsTotalPages
-				}
-				start := (recordingsPage - 1) * recordingsPerPage
-				end := start + recordingsPerPage
-				if end > len(recordings) {
-					end = len(recordings)
-				}
-				recordings = recordings[start:end]
-			}
+			recordings, recordingsPage, recordingsTotal := endedRecordingsPage(recordingsPage, recordingsPerPage)
+			recordingsTotalPages := (recordingsTotal + recordingsPerPage - 1) / recordingsPerPage
 
 			// Propagate debug flag to recording restart queries
 			if debugMode {
@@ -2643,8 +2914,9 @@ func main() {
 			}
 
 			// Build AgentWithSessions for all available assistants (homepage only)
-			agents := make([]AgentWithSessions, 0, len(availableAssistants))
-			for _, assistant := range availableAssistants {
+			assistants := installedAssistants()
+			agents := make([]AgentWithSessions, 0, len(assistants))
+			for _, assistant := range assistants {
 				// Skip non-homepage assistants (like shell)
 				if !assistant.Homepage {
 					continue
@@ -2652,6 +2924,7 @@ func main() {
 				agents = append(agents, AgentWithSessions{
 					Assistant: assistant,
 					Sessions:  sessionsByAssistant[assistant.Binary], // nil if no sessions
+					LoggedOut: assistantLoggedOut(assistant.Binary),
 				})
 			}
 
@@ -2689,6 +2962,9 @@ func main() {
 				DefaultRepoUrl       string
 				Version              string
 				VersionNumber        string
+				RecordingsFeedURL    string
+				SessionCalendarURL   string
+				RepoWarnings         []string
 			}{
 				Agents:               agents,
 				Recordings:           recordings,
@@ -2704,7 +2980,10 @@ func main() {
 				DefaultRepoUrl:       defaultRepoUrl,
 				Version:              Version + " (" + GitCommit + ")",
 				// bare version, for the npm update check in homepage-main.js
-				VersionNumber: Version,
+				VersionNumber:      Version,
+				RecordingsFeedURL:  feedURL(r, recordingsFeedPath),
+				SessionCalendarURL: feedURL(r, sessionCalendarPath),
+				RepoWarnings:       repoStatusWarnings(),
 			}
 			if err := selectionTemplate.Execute(w, data); err != nil {
 				log.Printf("Selection template error: %v", err)
@@ -2713,12 +2992,19 @@ func main() {
 			return
 		}
 
-		// Session proxy: /proxy/{uuid}/{preview|agentchat}/...
-		if strings.HasPrefix(r.URL.Path, "/proxy/") {
+		// Session proxy: /proxy/{uuid}/{preview|agentchat}/... and the
+		// single-port preview at /preview/{uuid}/... (proxy_mode.go)
+		if strings.HasPrefix(r.URL.Path, "/proxy/") || strings.HasPrefix(r.URL.Path, "/preview/") {
 			handleProxyRoute(w, r)
 			return
 		}
 
+		// Events of every session (admin_events.go); before /ws/{uuid}.
+		if r.URL.Path == adminEventsPath {
+			handleAdminEvents(w, r)
+			return
+		}
+
 		// WebSocket path: handle WebSocket connection
 		if strings.HasPrefix(r.URL.Path, "/ws/") {
 			sessionUUID := strings.TrimPrefix(r.URL.Path, "/ws/")
@@ -2732,6 +3018,18 @@ func main() {
 			return
 		}
 
+		// What this server lets the caller do, for the UI (capabilities.go)
+		if r.URL.Path == "/api/capabilities" {
+			handleCapabilitiesAPI(w, r)
+			return
+		}
+
+		// Overlapping changes between live worktree sessions (worktree_conflicts.go)
+		if r.URL.Path == "/api/worktrees/conflicts" {
+			handleWorktreeConflictsAPI(w, r)
+			return
+		}
+
 		// Worktrees API endpoint
 		if r.URL.Path == "/api/worktrees" {
 			handleWorktreesAPI(w, r)
@@ -2744,15 +3042,45 @@ func main() {
 			return
 		}
 
+		// Branch preview for the New Session dialog (branch_preview.go)
+		if r.URL.Path == "/api/branch/preview" {
+			handleBranchPreviewAPI(w, r)
+			return
+		}
+
+		// Worktree merge-back into the main checkout's branch (worktree_merge.go)
+		if strings.HasPrefix(r.URL.Path, "/api/worktree/") && strings.HasSuffix(r.URL.Path, "/merge") {
+			handleWorktreeMergeAPI(w, r)
+			return
+		}
+
+		// Worktree publish: pre-publish checks, then push (worktree_publish.go)
+		if strings.HasPrefix(r.URL.Path, "/api/worktree/") && strings.HasSuffix(r.URL.Path, "/publish") {
+			handleWorktreePublishAPI(w, r)
+			return
+		}
+
 		// Repos list API endpoint
 		if r.URL.Path == "/api/repos" {
 			handleReposAPI(w, r)
 			return
 		}
 
+		// Branch, ahead/behind and dirty files of each repo (repo_status.go)
+		if r.URL.Path == "/api/repos/status" {
+			handleRepoStatusAPI(w, r)
+			return
+		}
+
+		// Last background fetch of each repo; POST fetches now (repo_fetch.go)
+		if r.URL.Path == "/api/repos/fetch" {
+			handleRepoFetchAPI(w, r)
+			return
+		}
+
 		// Repo prepare API endpoint (clone/fetch)
 		if r.URL.Path == "/api/repo/prepare" {
-			handleRepoPrepareAPI(w, r)
+			traceHandler(w, r, "repo.prepare", http.HandlerFunc(handleRepoPrepareAPI))
 			return
 		}
 
@@ -2762,6 +3090,24 @@ func main() {
 			return
 		}
 
+		// Apply that update (template_update.go)
+		if r.URL.Path == "/api/templates/update" {
+			handleTemplatesUpdateAPI(w, r)
+			return
+		}
+
+		// What updating a repo's swe-swe files would change (template_diff.go)
+		if r.URL.Path == "/api/repo/template-diff" {
+			handleRepoTemplateDiffAPI(w, r)
+			return
+		}
+
+		// Read and edit a repo's .swe-swe/env (repo_env.go)
+		if r.URL.Path == "/api/repo/env" {
+			handleRepoEnvAPI(w, r)
+			return
+		}
+
 		// Autocomplete API endpoint (for agent-chat slash command completion)
 		if strings.HasPrefix(r.URL.Path, "/api/autocomplete/") {
 			handleAutocompleteAPI(w, r)
@@ -2790,6 +3136,69 @@ func main() {
 			return
 		}
 
+		// One-shot command API: POST /api/exec runs an allowlisted command
+		// in a session's working directory (not through the agent PTY) and
+		// streams combined output as NDJSON. Denied to shared-session guests.
+		if r.URL.Path == "/api/exec" {
+			handleExecAPI(w, r)
+			return
+		}
+
+		// Runtime log level: GET to read, POST {"level": "debug"} to change.
+		if r.URL.Path == "/api/log-level" {
+			handleLogLevelAPI(w, r)
+			return
+		}
+
+		// Usage report: session counts and durations per assistant and repo.
+		if r.URL.Path == "/api/reports/usage" {
+			handleUsageReportAPI(w, r)
+			return
+		}
+
+		// Data purge: delete stored sessions and visitor data (data_purge.go).
+		if r.URL.Path == "/api/data/purge" {
+			handleDataPurgeAPI(w, r)
+			return
+		}
+
+		// State export/import: move recordings and metadata to another
+		// server (state_export.go).
+		if r.URL.Path == "/api/data/export" {
+			handleDataExportAPI(w, r)
+			return
+		}
+		if r.URL.Path == "/api/data/import" {
+			handleDataImportAPI(w, r)
+			return
+		}
+
+		// Scheduled backups: status, or run one now (backup.go).
+		if r.URL.Path == "/api/backups" {
+			handleBackupsAPI(w, r)
+			return
+		}
+
+		// Session WebSocket protocol description for other clients
+		// (ws_protocol.go).
+		if r.URL.Path == "/api/protocol" {
+			handleProtocolAPI(w, r)
+			return
+		}
+
+		// Running version against the latest GitHub release
+		// (self_update.go).
+		if r.URL.Path == "/api/version/check" {
+			handleVersionCheckAPI(w, r)
+			return
+		}
+
+		// Effective server configuration (read-only, secrets masked).
+		if r.URL.Path == "/api/config" {
+			handleConfigAPI(w, r)
+			return
+		}
+
 		// Live-session poll for the homepage: lets an ending card show a
 		// terminating state and then remove itself once teardown finishes.
 		if r.URL.Path == "/api/sessions/live" {
@@ -2816,6 +3225,13 @@ func main() {
 			return
 		}
 
+		// Share-link management: GET /api/session/{uuid}/shares,
+		// DELETE /api/session/{uuid}/shares/{id}.
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && (strings.HasSuffix(r.URL.Path, "/shares") || strings.Contains(r.URL.Path, "/shares/")) {
+			handleSessionSharesAPI(w, r)
+			return
+		}
+
 		// New-session staging endpoint: POST /api/session/new -> 302 /session/{new-uuid}.
 		// Stages a "new" creation intent so the WS handler is permitted to
 		// materialize the session. Must be checked before the generic
@@ -2825,6 +3241,27 @@ func main() {
 			return
 		}
 
+		// Session name policy check: GET /api/session/name-check
+		// (session_names.go).
+		if r.URL.Path == "/api/session/name-check" {
+			handleSessionNameCheckAPI(w, r)
+			return
+		}
+
+		// Agent login check: GET /api/session/auth-check
+		// (assistant_auth.go).
+		if r.URL.Path == "/api/session/auth-check" {
+			handleAuthCheckAPI(w, r)
+			return
+		}
+
+		// "Open in swe-swe" links: GET /new confirm page, POST /new opens
+		// the session (deep_link.go).
+		if r.URL.Path == deepLinkPath {
+			handleDeepLink(w, r)
+			return
+		}
+
 		// Session fork API endpoint:
 		//   GET  /api/fork/{source-uuid} -> skeleton confirm page (no side effects)
 		//   POST /api/fork/{source-uuid} -> fork + 302 /session/{new-uuid}
@@ -2845,9 +3282,132 @@ func main() {
 			return
 		}
 
-		// Files (md-serve) readiness probe -- same rationale as vnc-ready.
-		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/files-ready") {
-			handleFilesReadyAPI(w, r)
+		// Per-session server-side event buffer (Diagnostics pane).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/events") {
+			handleSessionEventsAPI(w, r)
+			return
+		}
+
+		// Per-session preview debug messages (preview_debug.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/preview-debug") {
+			handlePreviewDebugAPI(w, r)
+			return
+		}
+
+		// Working-tree checkpoints (checkpoints.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && (strings.HasSuffix(r.URL.Path, "/checkpoints") || strings.Contains(r.URL.Path, "/checkpoints/")) {
+			handleSessionCheckpointsAPI(w, r)
+			return
+		}
+
+		// Broadcast mode (session_broadcast.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/broadcast") {
+			handleSessionBroadcastAPI(w, r)
+			return
+		}
+
+		// Recording pause/resume (session_recorder.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.Contains(r.URL.Path, "/recording/") {
+			handleSessionRecordingAPI(w, r)
+			return
+		}
+
+		// Repo test runs (session_tests.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && (strings.HasSuffix(r.URL.Path, "/tests") || strings.HasSuffix(r.URL.Path, "/tests/run")) {
+			handleSessionTestsAPI(w, r)
+			return
+		}
+
+		// Named secrets (secrets.go); admin-only via adminOnlyPath.
+		if r.URL.Path == "/api/secrets" || strings.HasPrefix(r.URL.Path, "/api/secrets/") {
+			handleSecretsAPI(w, r)
+			return
+		}
+
+		// Process tree and signals (session_processes.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && (strings.HasSuffix(r.URL.Path, "/processes") || strings.HasSuffix(r.URL.Path, "/signal")) {
+			handleSessionProcessesAPI(w, r)
+			return
+		}
+
+		// Preview app start/stop (session_preview_app.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.Contains(r.URL.Path, "/preview-app") {
+			handleSessionPreviewAppAPI(w, r)
+			return
+		}
+
+		// Command palette actions (session_actions.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/actions") {
+			handleSessionActionsAPI(w, r)
+			return
+		}
+
+		// Current terminal screen as text / structured rows.
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/screen") {
+			handleSessionScreenAPI(w, r)
+			return
+		}
+
+		// Output by byte offset from the recording log (session_scrollback.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/scrollback") {
+			handleSessionScrollbackAPI(w, r)
+			return
+		}
+
+		// The session so far as one HTML file (session_export.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/export") {
+			handleSessionExportAPI(w, r)
+			return
+		}
+
+		// One-time download of a copy_rows extraction (session_copy.go).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.Contains(r.URL.Path, "/copy/") {
+			handleSessionCopyAPI(w, r)
+			return
+		}
+
+		// Homepage card preview (SVG).
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/thumbnail") {
+			handleSessionThumbnailAPI(w, r)
+			return
+		}
+
+		// Files (md-serve) readiness probe -- same rationale as vnc-ready.
+		if strings.HasPrefix(r.URL.Path, "/api/session/") && strings.HasSuffix(r.URL.Path, "/files-ready") {
+			handleFilesReadyAPI(w, r)
+			return
+		}
+
+		// Chromeless recording player for iframes, and its oEmbed endpoint
+		if strings.HasPrefix(r.URL.Path, embedRecordingPrefix) {
+			handleEmbedRecording(w, r)
+			return
+		}
+		if r.URL.Path == "/oembed" {
+			handleOEmbed(w, r)
+			return
+		}
+
+		// Atom feed of finished recordings (recordings_feed.go) and iCal
+		// calendar of session history (session_calendar.go)
+		if r.URL.Path == recordingsFeedPath {
+			handleRecordingsFeed(w, r)
+			return
+		}
+		if r.URL.Path == sessionCalendarPath {
+			handleSessionCalendar(w, r)
+			return
+		}
+
+		// Broadcast watch page and its event stream (session_broadcast.go)
+		if strings.HasPrefix(r.URL.Path, broadcastWatchPrefix) {
+			handleBroadcastWatch(w, r)
+			return
+		}
+
+		// GitHub pull request webhook (github_webhook.go)
+		if r.URL.Path == githubWebhookPath {
+			handleGitHubWebhook(w, r)
 			return
 		}
 
@@ -2904,7 +3464,7 @@ func main() {
 			// Validate assistant exists in available list and get its display name
 			var validAssistant bool
 			var assistantName string
-			for _, a := range availableAssistants {
+			for _, a := range installedAssistants() {
 				if a.Binary == assistant {
 					validAssistant = true
 					assistantName = a.Name
@@ -3006,7 +3566,11 @@ func main() {
 		}
 
 		// All other paths: serve static files
+		if serveStaticAsset(staticAssets, w, r) {
+			return
+		}
 		staticHandler.ServeHTTP(w, r)
+
 	})
 
 	// listenAddr/landingAddr were resolved earlier so the tunnel supervisor
@@ -3043,6 +3607,10 @@ func main() {
 	// Landing/health server on $PORT, if separate from the swe-swe listener.
 	startLandingServer(serverCtx, landingAddr, listenAddr)
 
+	// Bonjour advertisement for LAN discovery, if SWE_MDNS=1
+	// (lan_discovery.go).
+	startLANDiscovery(serverCtx, listenAddr)
+
 	// Set up embedded auth if SWE_SWE_PASSWORD is set (dockerfile-only mode).
 	// In compose mode, Traefik + auth service handle authentication externally.
 	var handler http.Handler
@@ -3050,6 +3618,12 @@ func main() {
 		handler = setupEmbeddedAuth(authPassword)
 		log.Printf("Embedded auth enabled (SWE_SWE_PASSWORD set)")
 	}
+	if handler == nil {
+		handler = previewDomainRouter(http.DefaultServeMux)
+	}
+	// Outermost, so a client hammering the expensive APIs is turned away
+	// before auth or the handler does any work (ratelimit.go).
+	handler = rateLimitMiddleware(handler)
 
 	srv := &http.Server{Addr: listenAddr, Handler: handler}
 	go func() {
@@ -3082,6 +3656,7 @@ func main() {
 			log.Printf("Closing session %s on shutdown", uuid)
 			toClose = append(toClose, sess)
 			delete(sessions, uuid)
+			releaseSessionPorts(uuid)
 		}
 		sessionsMu.Unlock()
 		var closeWG sync.WaitGroup
@@ -3102,6 +3677,12 @@ func main() {
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	traceCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdownTracing(traceCtx)
+	if restartAfterShutdown.Load() {
+		reexecSelf()
+	}
 }
 
 // deriveBranchName converts a session name to a valid git branch name
@@ -3515,10 +4096,11 @@ func getMainRepoBranch() string {
 
 // worktreeExists checks if a worktree directory already exists for the given
 // branch name in the DEFAULT repo (/worktrees). This is intentionally scoped to
-// the default repo: its only caller (handleWorktreeCheckAPI) receives a session
-// name with no repo context, and the same branch name in a different external
-// repo is a legitimately distinct worktree -- treating it as a conflict here
-// would produce false positives. See listWorktrees for the cross-repo view.
+// the default repo: its caller (freeSessionNameLocked) has a session name with
+// no repo context, and the same branch name in a different external repo is a
+// legitimately distinct worktree -- treating it as a conflict here would
+// produce false positives. See listWorktrees for the cross-repo view, and
+// previewBranch for the check against a given repo.
 func worktreeExists(branchName string) bool {
 	worktreePath := worktreeDir + "/" + worktreeDirName(branchName)
 	_, err := os.Stat(worktreePath)
@@ -3696,7 +4278,8 @@ func handleReposAPI(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleWorktreeCheckAPI handles GET /api/worktree/check?name={branch}
-// Returns whether the branch/worktree exists and what type
+// Returns whether the branch/worktree exists in the default repo and what
+// type. It is previewBranch (branch_preview.go) in its older response shape.
 func handleWorktreeCheckAPI(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -3709,33 +4292,13 @@ func handleWorktreeCheckAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Derive branch name from session name
-	branchName := deriveBranchName(name)
-	if branchName == "" {
-		// No valid branch name derived
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"exists": false,
-		})
-		return
-	}
-
-	// Check in priority order: worktree > local branch > remote branch
-	var conflictType string
-	if worktreeExists(branchName) {
-		conflictType = "worktree"
-	} else if localBranchExists(branchName) {
-		conflictType = "local"
-	} else if remoteBranchExists(branchName) {
-		conflictType = "remote"
-	}
-
+	preview := previewBranch(name, workspaceDir)
 	w.Header().Set("Content-Type", "application/json")
-	if conflictType != "" {
+	if preview.Conflict != "" {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"exists": true,
-			"type":   conflictType,
-			"branch": branchName,
+			"type":   preview.Conflict,
+			"branch": preview.Branch,
 		})
 	} else {
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -3891,6 +4454,13 @@ func renameSession(sess *Session, name string) error {
 			return fmt.Errorf("invalid character %q in name %q", r, name)
 		}
 	}
+	if sess.ParentUUID == "" {
+		resolved, err := resolveSessionName(name, sess.UUID)
+		if err != nil {
+			return err
+		}
+		name = resolved
+	}
 	sess.mu.Lock()
 	sess.Name = name
 	if sess.Metadata != nil {
@@ -4010,6 +4580,8 @@ func handleRepoPrepareAPI(w http.ResponseWriter, r *http.Request) {
 
 	req.Path = strings.TrimSpace(req.Path)
 
+	spanFromContext(r.Context()).SetAttrs("mode", req.Mode)
+
 	switch req.Mode {
 	case "workspace":
 		handleRepoPrepareWorkspace(w, req.Path)
@@ -4030,9 +4602,9 @@ func handleRepoPrepareWorkspace(w http.ResponseWriter, repoPath string) {
 	isWorkspace := true
 
 	if repoPath != "" {
-		// Validate path: must start with /repos/ and not contain traversal
-		cleaned := filepath.Clean(repoPath)
-		if !strings.HasPrefix(cleaned, reposDir+"/") {
+		// Must be an existing clone under the repos directory (pathpolicy.go).
+		cleaned, err := policyClonedRepo.Resolve(repoPath)
+		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid repository path"})
@@ -4072,30 +4644,23 @@ func handleRepoPrepareWorkspace(w http.ResponseWriter, repoPath string) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleRepoPrepareClone handles the clone mode - clone external URL (hard fail).
-// credHost/credUsername/credToken are optional HTTPS credentials wired through
-// the broker for the duration of the clone (private repos); all empty ->
-// bare clone (public repos). Never embeds credentials in the URL.
-func handleRepoPrepareClone(w http.ResponseWriter, url, credHost, credUsername, credToken string) {
-	if url == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "URL is required for clone mode"})
-		return
-	}
+// errInvalidRepoURL is cloneOrFetchRepo's error for a URL that sanitizes to
+// nothing.
+var errInvalidRepoURL = errors.New("Invalid repository URL")
 
+// cloneOrFetchRepo clones url to /repos/{sanitized-url}/workspace, or fetches
+// it when it is already cloned, and returns the checkout. On a git failure
+// gitOutput carries git's output, so callers can tell an auth failure
+// (cloneNeedsAuth) from the rest. credHost/credUsername/credToken are as for
+// handleRepoPrepareClone.
+func cloneOrFetchRepo(url, credHost, credUsername, credToken string) (repoPath string, justCloned bool, gitOutput string, err error) {
 	sanitizedURL := sanitizeRepoURL(url)
 	if sanitizedURL == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid repository URL"})
-		return
+		return "", false, "", errInvalidRepoURL
 	}
 
 	repoBase := filepath.Join(reposDir, sanitizedURL)
-	repoPath := filepath.Join(repoBase, "workspace")
-
-	justCloned := false
+	repoPath = filepath.Join(repoBase, "workspace")
 
 	// Check if already cloned
 	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
@@ -4104,38 +4669,50 @@ func handleRepoPrepareClone(w http.ResponseWriter, url, credHost, credUsername,
 		output, err := runGitWithTransientCred(credHost, credUsername, credToken, "-C", repoPath, "fetch", "--all")
 		if err != nil {
 			log.Printf("Git fetch failed: %v, output: %s", err, string(output))
-			if cloneNeedsAuth(string(output)) {
-				writeCloneAuthNeeded(w, credHost)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Git fetch failed: %s", string(output))})
-			return
-		}
-	} else {
-		// Clone the repo
-		log.Printf("Cloning %s to %s", url, repoPath)
-		if err := os.MkdirAll(repoBase, 0755); err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to create directory: %v", err)})
-			return
+			return repoPath, false, string(output), fmt.Errorf("Git fetch failed: %s", string(output))
 		}
+		return repoPath, false, "", nil
+	}
 
-		output, err := runGitWithTransientCred(credHost, credUsername, credToken, "clone", url, repoPath)
-		if err != nil {
-			log.Printf("Git clone failed: %v, output: %s", err, string(output))
-			if cloneNeedsAuth(string(output)) {
-				writeCloneAuthNeeded(w, credHost)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Git clone failed: %s", string(output))})
+	// Clone the repo
+	log.Printf("Cloning %s to %s", url, repoPath)
+	if err := os.MkdirAll(repoBase, 0755); err != nil {
+		return "", false, "", fmt.Errorf("Failed to create directory: %v", err)
+	}
+	output, err := runGitWithTransientCred(credHost, credUsername, credToken, "clone", url, repoPath)
+	if err != nil {
+		log.Printf("Git clone failed: %v, output: %s", err, string(output))
+		return "", false, string(output), fmt.Errorf("Git clone failed: %s", string(output))
+	}
+	return repoPath, true, "", nil
+}
+
+// handleRepoPrepareClone handles the clone mode - clone external URL (hard fail).
+// credHost/credUsername/credToken are optional HTTPS credentials wired through
+// the broker for the duration of the clone (private repos); all empty ->
+// bare clone (public repos). Never embeds credentials in the URL.
+func handleRepoPrepareClone(w http.ResponseWriter, url, credHost, credUsername, credToken string) {
+	if url == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "URL is required for clone mode"})
+		return
+	}
+
+	repoPath, justCloned, gitOutput, err := cloneOrFetchRepo(url, credHost, credUsername, credToken)
+	if err != nil {
+		if cloneNeedsAuth(gitOutput) {
+			writeCloneAuthNeeded(w, credHost)
 			return
 		}
-		justCloned = true
+		status := http.StatusInternalServerError
+		if err == errInvalidRepoURL {
+			status = http.StatusBadRequest
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
 	}
 
 	// Set up swe-swe files (.swe-swe/docs/*) and clean up legacy .mcp.json
@@ -4334,17 +4911,16 @@ func handleRepoBranchesAPI(w http.ResponseWriter, r *http.Request) {
 		repoPath = workspaceDir
 	}
 
-	// Security check: only allow /workspace or /repos/* paths
-	if repoPath != workspaceDir && !strings.HasPrefix(repoPath, reposDir+"/") {
+	// Security check: only /workspace or /repos/* (pathpolicy.go). The
+	// policy cleans before checking, so "/repos/../etc" is rejected.
+	repoPath, err := policyRepoPath.Resolve(repoPath)
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid repository path"})
 		return
 	}
 
-	// Clean path to prevent traversal
-	repoPath = filepath.Clean(repoPath)
-
 	// fetch=1: freshen remote refs before listing. Soft-fail -- a failed
 	// fetch still returns the cached branch list, plus a warning. The dialog
 	// calls this in the background after the instant no-fetch listing.
@@ -4513,6 +5089,9 @@ func createWorktreeInRepo(repoPath, branchName string) (string, error) {
 
 	output, err = cmd.CombinedOutput()
 	if err != nil {
+		if worktreeConflictOutput(string(output)) {
+			err = fmt.Errorf("%w: %w", errWorktreeConflict, err)
+		}
 		return "", fmt.Errorf("failed to create worktree: %w (output: %s)", err, string(output))
 	}
 
@@ -4529,24 +5108,12 @@ func createWorktreeInRepo(repoPath, branchName string) (string, error) {
 // isValidWorktreePath checks if a path is a valid worktree path (under worktreeDir).
 // This is a security check to prevent path traversal attacks.
 func isValidWorktreePath(path string) bool {
-	if path == "" {
-		return false
-	}
-
-	// Clean the path to resolve any .. or . components
-	cleanPath := filepath.Clean(path)
-
-	// Must start with worktreeDir
-	if !strings.HasPrefix(cleanPath, worktreeDir+"/") {
-		return false
-	}
-
-	// Must not contain path traversal after cleaning
-	if cleanPath != path && strings.Contains(path, "..") {
+	// Reject ".." outright, even when it cleans to a path inside worktreeDir:
+	// a legitimate caller never sends one.
+	if strings.Contains(path, "..") {
 		return false
 	}
-
-	return true
+	return policyWorktree.Allowed(path)
 }
 
 // sessionReaper periodically cleans up sessions where the process has exited
@@ -4571,15 +5138,19 @@ func sessionReaper() {
 				log.Printf("Session cleaned up (process finished): %s", uuid)
 				toReap = append(toReap, sess)
 				delete(sessions, uuid)
+				releaseSessionPorts(uuid)
 			}
 		}
 		sessionsMu.Unlock()
 		for _, s := range toReap {
 			s.Close()
 		}
+		reclaimLeakedPorts()
 
 		// Clean up old recent recordings
 		cleanupRecentRecordings()
+		// And temp dirs left by sessions that never closed
+		reapSessionTmpDirs()
 	}
 }
 
@@ -4600,7 +5171,7 @@ var compressCh = make(chan string, 256)
 // rather than at session end, avoiding delays during endSession.
 // Expiry: recordings without KeptAt are deleted recentRecordingMaxAge after EndedAt.
 func cleanupRecentRecordings() {
-	entries, err := os.ReadDir(recordingsDir)
+	entries, err := readRecordingEntries()
 	if err != nil {
 		return
 	}
@@ -4609,12 +5180,15 @@ func cleanupRecentRecordings() {
 	activeRecordings := make(map[string]bool)
 	sessionsMu.RLock()
 	for _, sess := range sessions {
-		if sess.RecordingUUID != "" && sess.Cmd != nil && sess.Cmd.ProcessState == nil {
+		if sess.RecordingUUID != "" && sess.Cmd != nil && !sess.exited() {
 			activeRecordings[sess.RecordingUUID] = true
 		}
 	}
 	sessionsMu.RUnlock()
 
+	// Mask passwords in recordings from before input redaction
+	redactEndedRecordingInputs(entries, activeRecordings)
+
 	// Compress .log files from ended sessions to .log.gz
 	compressEndedSessionLogs(entries, activeRecordings)
 
@@ -4646,7 +5220,7 @@ func cleanupRecentRecordings() {
 		}
 
 		// Read metadata
-		metadataPath := recordingsDir + "/session-" + uuid + ".metadata.json"
+		metadataPath := recordingDir(uuid) + "/session-" + uuid + ".metadata.json"
 		metaData, err := os.ReadFile(metadataPath)
 		if err != nil {
 			continue
@@ -4701,7 +5275,7 @@ func cleanupRecentRecordings() {
 		}
 		// Extract stem by removing "session-" prefix and any known suffix
 		stem := strings.TrimPrefix(name, "session-")
-		for _, suffix := range []string{".timing", ".input", ".metadata.json", ".events.jsonl"} {
+		for _, suffix := range []string{".timing", ".input", ".metadata.json", ".events.jsonl", ".hooks.txt", ".shares.json"} {
 			stem = strings.TrimSuffix(stem, suffix)
 		}
 
@@ -4716,7 +5290,7 @@ func cleanupRecentRecordings() {
 			continue
 		}
 
-		os.Remove(recordingsDir + "/" + name)
+		os.Remove(recordingPath(name))
 		orphanCount++
 	}
 	if orphanCount > 0 {
@@ -4749,7 +5323,7 @@ func compressEndedSessionLogs(entries []os.DirEntry, activeRecordings map[string
 			continue
 		}
 
-		logPath := recordingsDir + "/" + name
+		logPath := recordingPath(name)
 
 		// Skip if already compressed
 		gzPath := logPath + ".gz"
@@ -4868,7 +5442,7 @@ func cacheRootRecordingSummary(logPath string) {
 	}
 	terminalSummary := extractSummaryFromBytes(buf)
 
-	metadataPath := recordingsDir + "/session-" + uuidStr + ".metadata.json"
+	metadataPath := recordingDir(uuidStr) + "/session-" + uuidStr + ".metadata.json"
 	metaBytes, err := os.ReadFile(metadataPath)
 	if err != nil {
 		return
@@ -4910,41 +5484,27 @@ func cacheRootRecordingSummary(logPath string) {
 
 // deleteRecordingFiles removes all files for a recording and its children.
 func deleteRecordingFiles(recUUID string) {
+	dir := recordingDir(recUUID)
 	// Delete parent files
-	suffixes := []string{".log", ".log.gz", ".log.pipe", ".timing", ".input", ".metadata.json"}
+	suffixes := []string{".log", ".log.gz", ".log.pipe", ".timing", ".input", ".metadata.json", ".hooks.txt", ".shares.json"}
 	for _, suffix := range suffixes {
-		os.Remove(recordingsDir + "/session-" + recUUID + suffix)
+		os.Remove(dir + "/session-" + recUUID + suffix)
 	}
 	// Delete child files (session-{uuid}-*)
-	childMatches, _ := filepath.Glob(recordingsDir + "/session-" + recUUID + "-*")
+	childMatches, _ := filepath.Glob(dir + "/session-" + recUUID + "-*")
 	for _, path := range childMatches {
 		os.Remove(path)
 	}
+	forgetRecordingDir(recUUID)
 }
 
-func agentChatPortFromPreview(previewPort int) int {
-	return previewPort + 1000
-}
-
-func publicPortFromPreview(previewPort int) int {
-	return previewPort + 2000
-}
-
-func cdpPortFromPreview(previewPort int) int {
-	return previewPort + 3000
-}
-
-func vncPortFromPreview(previewPort int) int {
-	return previewPort + 4000
-}
-
-func filesPortFromPreview(previewPort int) int {
-	return previewPort + 6000
-}
-
-// displayNumberFromPreview derives a unique X11 display number from a preview port.
-// Preview port 3000 -> DISPLAY=:1, 3001 -> :2, etc.
+// displayNumberFromPreview derives a unique X11 display number from a preview
+// port: its position in the preview pool, so the first port -> DISPLAY=:1,
+// the second -> :2, etc.
 func displayNumberFromPreview(previewPort int) int {
+	if i := previewPool.index(previewPort); i >= 0 {
+		return i + 1
+	}
 	return (previewPort - previewPortStart) + 1
 }
 
@@ -5085,37 +5645,6 @@ func stopSessionMdServe(sess *Session) {
 	sess.FilesPID = 0
 }
 
-// findAvailablePortQuintuple finds a preview port and its derived agent chat,
-// public, CDP, and VNC ports that are not already allocated to an existing session.
-// Must be called while holding sessionsMu.
-// Returns (previewPort, agentChatPort, publicPort, cdpPort, vncPort, error).
-func findAvailablePortQuintuple() (int, int, int, int, int, error) {
-	// Collect ports already assigned to live sessions.
-	usedPorts := make(map[int]bool)
-	for _, sess := range sessions {
-		if sess.PreviewPort != 0 {
-			usedPorts[sess.PreviewPort] = true
-		}
-	}
-
-	for port := previewPortStart; port <= previewPortEnd; port++ {
-		if usedPorts[port] {
-			continue
-		}
-		acPort := agentChatPortFromPreview(port)
-		pubPort := publicPortFromPreview(port)
-		cdpPort := cdpPortFromPreview(port)
-		if agentViewRemote() {
-			// See remoteCDPProxyOffset: never share a number with the
-			// backend's published CDP range.
-			cdpPort += remoteCDPProxyOffset
-		}
-		vncPort := vncPortFromPreview(port)
-		return port, acPort, pubPort, cdpPort, vncPort, nil
-	}
-	return 0, 0, 0, 0, 0, fmt.Errorf("no available port quintuple in preview range %d-%d", previewPortStart, previewPortEnd)
-}
-
 // SessionParams holds the parameters for creating or retrieving a session.
 // Using a struct avoids positional string parameter confusion.
 type SessionParams struct {
@@ -5129,6 +5658,7 @@ type SessionParams struct {
 	ParentName          string // parent session name
 	ParentRecordingUUID string // parent recording UUID
 	Theme               string // terminal theme
+	Locale              string // message catalog for server-written terminal notices (i18n.go)
 	SessionMode         string // "terminal" or "chat"
 	ExtraArgs           string // extra CLI flags appended to the agent command (whitespace-split)
 	PrepopulateChatLog  string // when non-empty, copy this file into the new session's chat event log before the agent starts (used by /api/fork)
@@ -5145,6 +5675,19 @@ type SessionParams struct {
 	// way the blob reaches a brand-new browser session's process env. Never
 	// persisted; memory-only, exactly like set_env.
 	EnvRaw string
+	// Setup is the repo setup task mode: "" or "auto", "skip", "force"
+	// (session_setup.go).
+	Setup string
+	// Prompt is typed into the agent once its terminal settles, for a
+	// session opened from a deep link (deep_link.go).
+	Prompt string
+	// UntrustedRepo marks a working tree nobody has vetted, such as a pull
+	// request checked out by the GitHub webhook (github_webhook.go): the
+	// session runs without its .swe-swe/env, hooks or setup task.
+	UntrustedRepo bool
+	// TraceCtx parents the spans recorded while creating the session
+	// (tracing.go). Optional.
+	TraceCtx context.Context
 }
 
 // stagedSession is a creation intent parked in pendingSessions until the first
@@ -5273,6 +5816,7 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 			log.Printf("Cleaning up dead session on reconnect: %s (exit code=%d)", p.UUID, sess.Cmd.ProcessState.ExitCode())
 			sess.Close()
 			delete(sessions, p.UUID)
+			releaseSessionPorts(p.UUID)
 			// Fall through to create a new session (only if allowCreate)
 		} else {
 			return sess, false, nil // existing session
@@ -5285,11 +5829,31 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 	if !allowCreate {
 		return nil, false, errSessionGone
 	}
+	if restartDraining.Load() {
+		return nil, false, errServerRestarting
+	}
+	// Shells and sessions opened from an untrusted session work on the same
+	// unvetted tree.
+	for _, from := range []string{p.ParentUUID, p.InheritCredsFrom} {
+		if other, ok := sessions[from]; ok && other.untrustedRepo {
+			p.UntrustedRepo = true
+		}
+	}
+
+	// RepoPath arrives from the browser (pwd) or MCP: keep it inside the
+	// workspace, worktrees, or repos directories (pathpolicy.go).
+	if p.RepoPath != "" {
+		repoPath, err := policyWorkDir.Resolve(p.RepoPath)
+		if err != nil {
+			return nil, false, err
+		}
+		p.RepoPath = repoPath
+	}
 
 	// Find the assistant config
 	var cfg AssistantConfig
 	var found bool
-	for _, a := range availableAssistants {
+	for _, a := range installedAssistants() {
 		if a.Binary == p.Assistant {
 			cfg = a
 			found = true
@@ -5297,7 +5861,31 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		}
 	}
 	if !found {
-		return nil, false, fmt.Errorf("unknown assistant: %s", p.Assistant)
+		return nil, false, fmt.Errorf("%w: %s", errUnknownAssistant, p.Assistant)
+	}
+
+	// Session limits (session_limits.go), before any worktree is created.
+	// Child sessions share their parent's ports and are not counted.
+	if p.ParentUUID == "" {
+		if err := checkSessionLimitLocked(p.Assistant); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// Name uniqueness (session_names.go), also before any worktree: a
+	// suffixed name takes its branch along when the branch came from it.
+	if p.ParentUUID == "" && p.Name != "" {
+		resolved, err := resolveSessionNameLocked(p.Name, p.UUID)
+		if err != nil {
+			return nil, false, err
+		}
+		if resolved != p.Name {
+			log.Printf("Session name %q is taken, using %q", p.Name, resolved)
+			if p.Branch != "" && p.Branch == deriveBranchName(p.Name) {
+				p.Branch = deriveBranchName(resolved)
+			}
+			p.Name = resolved
+		}
 	}
 
 	// Ensure recordings directory exists
@@ -5334,7 +5922,9 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		// request was dropped. Return the error instead so the caller can show it.
 		if p.Branch != "" {
 			var err error
+			_, wtSpan := startSpan(p.TraceCtx, "git.worktree_add", "git.repo", baseRepo, "git.branch", p.Branch)
 			workDir, err = createWorktreeInRepo(baseRepo, p.Branch)
+			wtSpan.End(err)
 			if err != nil {
 				return nil, false, fmt.Errorf("worktree for branch %q in %s: %w", p.Branch, baseRepo, err)
 			}
@@ -5353,6 +5943,7 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 	var pubPort int
 	var cdpPort int
 	var vncPort int
+	var filesPort int
 	if p.ParentUUID != "" {
 		if parentSess, ok := sessions[p.ParentUUID]; ok {
 			previewPort = parentSess.PreviewPort
@@ -5360,14 +5951,25 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 			pubPort = parentSess.PublicPort
 			cdpPort = parentSess.CDPPort
 			vncPort = parentSess.VNCPort
+			filesPort = parentSess.FilesPort
 		}
 	}
+	// portsReserved stays true until the session is in the sessions map, so a
+	// failure below hands the reservation straight back (port_pool.go).
+	portsReserved := false
 	if previewPort == 0 {
-		var err error
-		previewPort, acPort, pubPort, cdpPort, vncPort, err = findAvailablePortQuintuple()
+		ports, err := reserveSessionPorts(p.UUID)
 		if err != nil {
 			return nil, false, err
 		}
+		portsReserved = true
+		defer func() {
+			if portsReserved {
+				releaseSessionPorts(p.UUID)
+			}
+		}()
+		previewPort, acPort, pubPort, cdpPort, vncPort, filesPort =
+			ports.Preview, ports.AgentChat, ports.Public, ports.cdpListenPort(), ports.VNC, ports.Files
 	}
 
 	// Inherit name from parent session if this is a shell session with a parent
@@ -5379,11 +5981,13 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 
 	// Derive default session name if none provided.
 	// Format: {owner}/{repo}@{branch}
+	derivedName := ""
 	if workDir != "" && name == "" {
 		derived := deriveDefaultSessionName(workDir)
 		if derived != "" {
 			log.Printf("Derived default session name: %s", derived)
 			name = derived
+			derivedName = derived
 		}
 	}
 
@@ -5391,6 +5995,14 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 	recordingUUID := uuid.New().String()
 	recPrefix := recordingPrefix(p.ParentRecordingUUID, recordingUUID)
 
+	// A root recording goes next to its repo; child recordings follow
+	// their parent's.
+	if p.ParentRecordingUUID == "" {
+		if _, err := registerRecordingDir(recordingUUID, workDir); err != nil {
+			log.Printf("Warning: failed to create recordings directory for %s: %v", workDir, err)
+		}
+	}
+
 	// Determine recording type
 	var recType string
 	if p.ParentUUID != "" {
@@ -5451,10 +6063,6 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		}
 	}
 
-	// Wrap with script for recording
-	cmdName, cmdArgs = wrapWithScript(cmdName, cmdArgs, recPrefix)
-	log.Printf("Recording session to: %s/%s.{log,timing}", recordingsDir, recPrefix)
-
 	// Populate the child's repo env store BEFORE buildSessionEnv reads it --
 	// buildSessionEnv bakes the result into cmd.Env, which pty.Start freezes
 	// below, so anything not in the store by now never reaches the process.
@@ -5482,6 +6090,7 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		WorkDir:       workDir,
 		SessionMode:   p.SessionMode,
 		SID:           p.UUID,
+		SkipRepoEnv:   p.UntrustedRepo,
 	})
 	env = append(env, fmt.Sprintf("SESSION_UUID=%s", p.UUID))
 	// Per-session MCP auth key: authenticates this session's agent to the
@@ -5495,7 +6104,7 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 	if p.SessionMode == "chat" {
 		chatRecordingUUID = uuid.New().String()
 		chatPrefix := recordingPrefix(recordingUUID, chatRecordingUUID)
-		chatLogPath = fmt.Sprintf("%s/%s.events.jsonl", recordingsDir, chatPrefix)
+		chatLogPath = recordingPath(chatPrefix + ".events.jsonl")
 		// /api/fork prepopulates the new session's chat event log so the
 		// browser tab shows the same history bubbles as the source session
 		// before the user types anything.
@@ -5510,13 +6119,23 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		env = defaultChatExportEnv(env, workDir)
 	}
 
-	// Agent-chat sidecar context for chat sessions.
-	// The sidecar process itself is managed externally (e.g. baked into the container image),
-	// but we keep the context/cancel infrastructure for graceful shutdown coordination.
-	var agentChatCmd *exec.Cmd
+	// Agent-chat sidecar for chat sessions (agent_chat_sidecar.go). By
+	// default the agent launches the agent-chat server and the sidecar only
+	// watches its port; with SWE_AGENT_CHAT_CMD it runs the server too,
+	// except in MCP-less mode where the fleet below launches it. Started
+	// once the session is registered; sessionCancel stops it.
+	var agentChat *agentChatSidecar
+	var sessionCtx context.Context
 	var sessionCancel context.CancelFunc
 	if p.SessionMode == "chat" {
-		_, sessionCancel = context.WithCancel(serverCtx)
+		sessionCtx, sessionCancel = context.WithCancel(serverCtx)
+		chatCmd := agentChatCommand
+		if mcpLessEnabled() {
+			chatCmd = ""
+		}
+		if p.ParentUUID == "" && acPort != 0 {
+			agentChat = newAgentChatSidecar(p.UUID, acPort, chatCmd, workDir, env)
+		}
 	}
 
 	// MCP-less mode: swe-swe-server (not the agent's native MCP client) owns the
@@ -5536,6 +6155,37 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		}
 	}
 
+	// Run the agent in the session backend (session_backend.go). Resolved
+	// here, once env is final, because some backends bake it into what they
+	// create (a pod spec). This is the innermost wrap, so the setup task and
+	// the recording still run on the host and own the PTY.
+	backend, err := resolveSessionBackend(backendParams{SessionUUID: p.UUID, WorkDir: workDir, Env: env})
+	if err != nil {
+		stopMcpLessFleet(mcpLessProxies)
+		if sessionCancel != nil {
+			sessionCancel()
+		}
+		if unlockAgentSpawn != nil {
+			unlockAgentSpawn()
+		}
+		return nil, false, fmt.Errorf("session backend: %w", err)
+	}
+	cmdName, cmdArgs = backend.Command(cmdName, cmdArgs, env)
+	if _, isHost := backend.(hostBackend); !isHost {
+		log.Printf("Session %s: running in %s", p.UUID, backend.Name())
+	}
+
+	// Run the repo's setup task in the PTY ahead of the agent. wrapWithShell
+	// joins cmdName and cmdArgs into one command line, so the prefix rides in
+	// front of cmdName. Shell sub-sessions share their parent's setup.
+	if p.ParentUUID == "" && !p.UntrustedRepo {
+		if prefix := setupTaskPrefix(p.UUID, workDir, p.Setup); prefix != "" {
+			cmdName = prefix + cmdName
+		}
+	}
+
+	cmdName, cmdArgs = wrapWithShell(cmdName, cmdArgs)
+
 	cmd := exec.Command(cmdName, cmdArgs...)
 	cmd.Env = env
 	if workDir != "" {
@@ -5551,6 +6201,7 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		if sessionCancel != nil {
 			sessionCancel()
 		}
+		backend.Release(p.UUID)
 		return nil, false, err
 	}
 
@@ -5560,6 +6211,13 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 	// Set initial terminal size
 	pty.Setsize(ptmx, &pty.Winsize{Rows: 24, Cols: 80})
 
+	recorder, err := startSessionRecorder(recPrefix, cmdArgs[1], 24, 80, false)
+	if err != nil {
+		log.Printf("Session %s: not recording: %v", p.UUID, err)
+	} else {
+		log.Printf("Recording session to: %s.{log,timing}", recordingPath(recPrefix))
+	}
+
 	// Capture the branch the working directory is actually on so a recording's
 	// "+ New" can prefill it even when no worktree branch was passed. Skip a
 	// detached HEAD ("HEAD") -- there's no branch name to reproduce.
@@ -5584,34 +6242,39 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		ptySize:         TermSize{Rows: 24, Cols: 80},
 		CreatedAt:       now,
 		lastActive:      now,
-		vt:              vt10x.New(vt10x.WithSize(80, 24)),
-		ringBuf:         make([]byte, RingBufferSize),
+		vt:              termsnap.Track(vt10x.New(vt10x.WithSize(80, 24))),
 		RecordingUUID:   recordingUUID,
 		RecordingPrefix: recPrefix,
 		ParentUUID:      p.ParentUUID,
+		untrustedRepo:   p.UntrustedRepo,
 		PreviewPort:     previewPort,
 		AgentChatPort:   acPort,
 		PublicPort:      pubPort,
 		CDPPort:         cdpPort,
 		VNCPort:         vncPort,
-		FilesPort:       filesPortFromPreview(previewPort),
+		FilesPort:       filesPort,
 		Theme:           p.Theme,
+		Locale:          p.Locale,
 		yoloMode:        detectYoloMode(shellCmdToUse), // Detect initial YOLO mode from startup command
-		AgentChatCmd:    agentChatCmd,
+		AgentChat:       agentChat,
 		agentChatCancel: sessionCancel,
 		McpLessProxies:  mcpLessProxies,
 		SessionMode:     p.SessionMode,
 		ChatLogPath:     chatLogPath,
 		AgentSessionID:  agentSessionID,
+		Backend:         backend,
 		Metadata: &RecordingMetadata{
+			SchemaVersion:  recordingSchemaVersion,
 			UUID:           recordingUUID,
 			Name:           name,
 			Agent:          cfg.Name,
 			AgentBinary:    cfg.Binary,
+			AgentVersion:   installedAssistantVersion(cfg.Binary),
 			RecordingType:  recType,
 			SessionMode:    p.SessionMode,
 			BranchName:     p.Branch,
 			CheckoutBranch: checkoutBranch,
+			StartCommit:    gitHeadCommit(workDir),
 			StartedAt:      now,
 			Command:        append([]string{cmdName}, cmdArgs...),
 			MaxCols:        80, // Default starting size
@@ -5621,7 +6284,21 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 			AgentSessionID: agentSessionID,
 		},
 	}
+	sess.recorder.Store(recorder)
 	sessions[p.UUID] = sess
+	portsReserved = false
+	registerSessionEvents(p.UUID)
+	registerSessionInbox(p.UUID)
+	info := sess.adminInfo()
+	publishAdminEvent(adminEvent{Type: "session_created", Session: p.UUID, Info: &info})
+	sess.startOutputSinks()
+	sess.runSessionStartHook()
+	if p.Prompt != "" {
+		go sess.typeInitialPrompt(p.Prompt)
+	}
+	if agentChat != nil {
+		agentChat.Start(sessionCtx, func() { go sess.BroadcastStatus() })
+	}
 
 	// Inherit git credentials/signing from the authenticated calling session
 	// (MCP create_session). Done after the session is registered so the
@@ -5667,9 +6344,41 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		previewProxy.RegisterTools(mcpSrv)
 		previewProxy.RegisterResources(mcpSrv)
 
+		sess.PreviewMCP = previewProxy.MCPHandler(mcpSrv)
+		registerPreviewDebug(sess.UUID, sharedHub)
+
 		sessMux := http.NewServeMux()
-		sessMux.Handle("/proxy/"+sess.UUID+"/preview/mcp", previewProxy.MCPHandler(mcpSrv))
-		sessMux.Handle("/proxy/"+sess.UUID+"/preview/", previewProxy)
+		sessMux.Handle("/proxy/"+sess.UUID+"/preview/mcp", sess.PreviewMCP)
+		sessMux.Handle("/proxy/"+sess.UUID+"/preview/", localizePreviewErrorPage(previewProxy))
+		// Browser-facing preview at /preview/{uuid}/ (proxy_mode.go): the only
+		// preview route in single-port mode, and the path the session page
+		// probes first in every mode. Same hub, so MCP tools see its pages.
+		if browserPreviewProxy, err := agentproxy.New(agentproxy.Config{
+			BasePath:    previewPathBase(sess.UUID),
+			Target:      previewTarget,
+			ToolPrefix:  "preview",
+			ThemeCookie: "swe-swe-theme",
+			Hub:         sharedHub,
+		}); err != nil {
+			log.Printf("Warning: failed to create path preview proxy for session %s: %v", sess.UUID, err)
+		} else {
+			sessMux.Handle(previewPathBase(sess.UUID)+"/", localizePreviewErrorPage(browserPreviewProxy))
+		}
+		// Preview subdomain {uuid}.SWE_PREVIEW_DOMAIN (preview_domain.go):
+		// mounted at the root so apps with absolute paths work.
+		if previewDomain != "" {
+			if hostProxy, err := agentproxy.New(agentproxy.Config{
+				Target:      previewTarget,
+				ToolPrefix:  "preview",
+				ThemeCookie: "swe-swe-theme",
+				Hub:         sharedHub,
+			}); err != nil {
+				log.Printf("Warning: failed to create subdomain preview proxy for session %s: %v", sess.UUID, err)
+			} else {
+				sess.PreviewHostProxy = localizePreviewErrorPage(hostProxy)
+			}
+		}
+
 		// Agent chat proxy route (same-origin, path-based)
 		acTarget, _ := url.Parse(fmt.Sprintf("http://localhost:%d", acPort))
 		sessMux.Handle("/proxy/"+sess.UUID+"/agentchat/", http.StripPrefix(
@@ -5679,93 +6388,97 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 		sess.PreviewProxy = previewProxy
 		sess.SessionMux = sessMux
 
-		// Start per-port listeners for port-based proxy mode.
-		// Port-based proxy uses empty BasePath (no URL rewriting) and shares
-		// the same DebugHub so MCP tools and debug WebSockets work in both modes.
-		// Preview host-demux: the port-based listener is what browsers hit at
-		// <reach>:proxyPort, so it carries the vhost ResolveTarget +
-		// CookieDomainRewrite hooks (see preview_vhost.go / ADR-0045). The
-		// path-based previewProxy above stays same-origin and unhooked.
-		portPreviewProxy, _ := agentproxy.New(agentproxy.Config{
-			Target:      previewTarget,
-			ToolPrefix:  "preview",
-			ThemeCookie: "swe-swe-theme",
-			Hub:         sharedHub,
-			ResolveTarget: func(inboundHost string) (*url.URL, string, bool) {
-				return previewResolveTarget(inboundHost, sess)
-			},
-			CookieDomainRewrite: previewCookieDomainRewrite,
-		})
-		// Tunnel mode safety: tunneld dials the per-port listeners directly
-		// without Traefik's ForwardAuth in front. Wrap each per-port handler
-		// in requireAuthCookie so the apex login cookie is validated before
-		// any traffic reaches the upstream. No-op when SWE_SWE_PASSWORD is
-		// empty (legacy compose mode where Traefik handles auth externally).
-		authPassword := os.Getenv("SWE_SWE_PASSWORD")
-
-		previewPP := previewProxyPort(previewPort)
-		previewHandler := corsWrapper(requireAuthCookie(authPassword, func(scope string) bool {
-			return scopeOwnsProxyPort(scope, previewPP, func(s *Session) int { return previewProxyPort(s.PreviewPort) })
-		}, previewVhostPinHandler(sess, portPreviewProxy)))
-		sess.trackProxyServer(
-			startProxyListener("preview", sess.UUID, fmt.Sprintf(":%d", previewPP), previewHandler),
-			func(s *Session, srv *http.Server) { s.PreviewProxyServer = srv })
-
-		acPP := agentChatProxyPort(acPort)
-		acHandler := corsWrapper(requireAuthCookie(authPassword, func(scope string) bool {
-			return scopeOwnsProxyPort(scope, acPP, func(s *Session) int { return agentChatProxyPort(s.AgentChatPort) })
-		}, agentChatProxyHandler(acTarget)))
-		sess.trackProxyServer(
-			startProxyListener("agent chat", sess.UUID, fmt.Sprintf(":%d", acPP), acHandler),
-			func(s *Session, srv *http.Server) { s.AgentChatProxyServer = srv })
-
-		// VNC proxy at vncProxyPort (default 27000-27019). Reverse-proxies
-		// HTTP + WebSocket upgrade to localhost:vncPort (websockify on
-		// 7000-7019). httputil.ReverseProxy supports WS upgrade since Go
-		// 1.12, so /websockify, /vnc_lite.html, and the noVNC static assets
-		// all flow through the same handler. Auth-wrapped exactly like
-		// preview/agent-chat above; in legacy/Traefik mode that wrap is a
-		// no-op since SWE_SWE_PASSWORD is empty.
-		vncTarget, _ := url.Parse(fmt.Sprintf("http://localhost:%d", vncPort))
-		vncReverseProxy := httputil.NewSingleHostReverseProxy(vncTarget)
-		// websockify presents itself with its own Host; rewriting the Host
-		// header to match the target avoids virtual-host filters and CORS
-		// quirks if websockify ever adds them. The target is resolved per
-		// request so a remote browser-backend (sess.RemoteVNCTarget, set on
-		// browser/start) redirects here without rebuilding the proxy; local
-		// mode keeps targeting localhost:vncPort.
-		vncReverseProxy.Director = func(req *http.Request) {
-			host := vncTarget.Host
-			if sess.RemoteVNCTarget != "" {
-				host = sess.RemoteVNCTarget
-			}
-			req.URL.Scheme = "http"
-			req.URL.Host = host
-			req.Host = host
-		}
-		vncPP := vncProxyPort(vncPort)
-		vncHandler := requireAuthCookie(authPassword, func(scope string) bool {
-			return scopeOwnsProxyPort(scope, vncPP, func(s *Session) int { return vncProxyPort(s.VNCPort) })
-		}, vncReverseProxy)
-		sess.trackProxyServer(
-			startProxyListener("vnc", sess.UUID, fmt.Sprintf(":%d", vncPP), vncHandler),
-			func(s *Session, srv *http.Server) { s.VNCProxyServer = srv })
-
-		// Files proxy at filesProxyPort (default 29000-29019). Plain
-		// reverse-proxy to the per-session md-serve on localhost:FilesPort
-		// (9000-9019); md-serve renders full pages, so no DebugHub/inject.js
-		// machinery is needed. Auth-wrapped exactly like preview/agent-chat
-		// above; in legacy/Traefik mode that wrap is a no-op since
-		// SWE_SWE_PASSWORD is empty.
-		filesTarget, _ := url.Parse(fmt.Sprintf("http://localhost:%d", sess.FilesPort))
-		filesReverseProxy := httputil.NewSingleHostReverseProxy(filesTarget)
-		filesPP := filesProxyPort(sess.FilesPort)
-		filesHandler := corsWrapper(requireAuthCookie(authPassword, func(scope string) bool {
-			return scopeOwnsProxyPort(scope, filesPP, func(s *Session) int { return filesProxyPort(s.FilesPort) })
-		}, filesReverseProxy))
-		sess.trackProxyServer(
-			startProxyListener("files", sess.UUID, fmt.Sprintf(":%d", filesPP), filesHandler),
-			func(s *Session, srv *http.Server) { s.FilesProxyServer = srv })
+		// Start per-port listeners for port-based proxy mode; single-port
+		// mode (SWE_PROXY_MODE=path) reaches everything through sessMux.
+		if !pathProxyMode() {
+			// Port-based proxy uses empty BasePath (no URL rewriting) and shares
+			// the same DebugHub so MCP tools and debug WebSockets work in both modes.
+			// Preview host-demux: the port-based listener is what browsers hit at
+			// <reach>:proxyPort, so it carries the vhost ResolveTarget +
+			// CookieDomainRewrite hooks (see preview_vhost.go / ADR-0045). The
+			// path-based previewProxy above stays same-origin and unhooked.
+			portPreviewProxy, _ := agentproxy.New(agentproxy.Config{
+				Target:      previewTarget,
+				ToolPrefix:  "preview",
+				ThemeCookie: "swe-swe-theme",
+				Hub:         sharedHub,
+				ResolveTarget: func(inboundHost string) (*url.URL, string, bool) {
+					return previewResolveTarget(inboundHost, sess)
+				},
+				CookieDomainRewrite: previewCookieDomainRewrite,
+			})
+			// Tunnel mode safety: tunneld dials the per-port listeners directly
+			// without Traefik's ForwardAuth in front. Wrap each per-port handler
+			// in requireAuthCookie so the apex login cookie is validated before
+			// any traffic reaches the upstream. No-op when SWE_SWE_PASSWORD is
+			// empty (legacy compose mode where Traefik handles auth externally).
+			authPassword := os.Getenv("SWE_SWE_PASSWORD")
+
+			previewPP := previewProxyPort(previewPort)
+			previewHandler := corsWrapper(requireAuthCookie(authPassword, func(scope string) bool {
+				return scopeOwnsProxyPort(scope, previewPP, func(s *Session) int { return previewProxyPort(s.PreviewPort) })
+			}, previewVhostPinHandler(sess, localizePreviewErrorPage(portPreviewProxy))))
+
+			sess.trackProxyServer(
+				startProxyListener("preview", sess.UUID, fmt.Sprintf(":%d", previewPP), previewHandler),
+				func(s *Session, srv *http.Server) { s.PreviewProxyServer = srv })
+
+			acPP := agentChatProxyPort(acPort)
+			acHandler := corsWrapper(requireAuthCookie(authPassword, func(scope string) bool {
+				return scopeOwnsProxyPort(scope, acPP, func(s *Session) int { return agentChatProxyPort(s.AgentChatPort) })
+			}, agentChatProxyHandler(acTarget)))
+			sess.trackProxyServer(
+				startProxyListener("agent chat", sess.UUID, fmt.Sprintf(":%d", acPP), acHandler),
+				func(s *Session, srv *http.Server) { s.AgentChatProxyServer = srv })
+
+			// VNC proxy at vncProxyPort (default 27000-27019). Reverse-proxies
+			// HTTP + WebSocket upgrade to localhost:vncPort (websockify on
+			// 7000-7019). httputil.ReverseProxy supports WS upgrade since Go
+			// 1.12, so /websockify, /vnc_lite.html, and the noVNC static assets
+			// all flow through the same handler. Auth-wrapped exactly like
+			// preview/agent-chat above; in legacy/Traefik mode that wrap is a
+			// no-op since SWE_SWE_PASSWORD is empty.
+			vncTarget, _ := url.Parse(fmt.Sprintf("http://localhost:%d", vncPort))
+			vncReverseProxy := httputil.NewSingleHostReverseProxy(vncTarget)
+			// websockify presents itself with its own Host; rewriting the Host
+			// header to match the target avoids virtual-host filters and CORS
+			// quirks if websockify ever adds them. The target is resolved per
+			// request so a remote browser-backend (sess.RemoteVNCTarget, set on
+			// browser/start) redirects here without rebuilding the proxy; local
+			// mode keeps targeting localhost:vncPort.
+			vncReverseProxy.Director = func(req *http.Request) {
+				host := vncTarget.Host
+				if sess.RemoteVNCTarget != "" {
+					host = sess.RemoteVNCTarget
+				}
+				req.URL.Scheme = "http"
+				req.URL.Host = host
+				req.Host = host
+			}
+			vncPP := vncProxyPort(vncPort)
+			vncHandler := requireAuthCookie(authPassword, func(scope string) bool {
+				return scopeOwnsProxyPort(scope, vncPP, func(s *Session) int { return vncProxyPort(s.VNCPort) })
+			}, vncReverseProxy)
+			sess.trackProxyServer(
+				startProxyListener("vnc", sess.UUID, fmt.Sprintf(":%d", vncPP), vncHandler),
+				func(s *Session, srv *http.Server) { s.VNCProxyServer = srv })
+
+			// Files proxy at filesProxyPort (default 29000-29019). Plain
+			// reverse-proxy to the per-session md-serve on localhost:FilesPort
+			// (9000-9019); md-serve renders full pages, so no DebugHub/inject.js
+			// machinery is needed. Auth-wrapped exactly like preview/agent-chat
+			// above; in legacy/Traefik mode that wrap is a no-op since
+			// SWE_SWE_PASSWORD is empty.
+			filesTarget, _ := url.Parse(fmt.Sprintf("http://localhost:%d", sess.FilesPort))
+			filesReverseProxy := httputil.NewSingleHostReverseProxy(filesTarget)
+			filesPP := filesProxyPort(sess.FilesPort)
+			filesHandler := corsWrapper(requireAuthCookie(authPassword, func(scope string) bool {
+				return scopeOwnsProxyPort(scope, filesPP, func(s *Session) int { return filesProxyPort(s.FilesPort) })
+			}, filesReverseProxy))
+			sess.trackProxyServer(
+				startProxyListener("files", sess.UUID, fmt.Sprintf(":%d", filesPP), filesHandler),
+				func(s *Session, srv *http.Server) { s.FilesProxyServer = srv })
+		}
 
 		// Public port: Traefik routes directly to the app (no swe-swe-server proxy needed)
 	}
@@ -5779,6 +6492,7 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 	if chatRecordingUUID != "" {
 		chatPrefix := recordingPrefix(recordingUUID, chatRecordingUUID)
 		chatMeta := &RecordingMetadata{
+			SchemaVersion: recordingSchemaVersion,
 			UUID:          chatRecordingUUID,
 			Name:          name,
 			Agent:         cfg.Name,
@@ -5786,7 +6500,7 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 			StartedAt:     now,
 			WorkDir:       workDir,
 		}
-		chatMetaPath := fmt.Sprintf("%s/%s.metadata.json", recordingsDir, chatPrefix)
+		chatMetaPath := recordingPath(chatPrefix + ".metadata.json")
 		if data, err := json.MarshalIndent(chatMeta, "", "  "); err == nil {
 			if err := os.WriteFile(chatMetaPath, data, 0644); err != nil {
 				log.Printf("Failed to save chat metadata: %v", err)
@@ -5798,6 +6512,20 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 	// (triggered by mcp-lazy-init on first Playwright MCP tool call).
 	// Child sessions share the parent's browser.
 
+	// Working-tree checkpoints (checkpoints.go) and the files_changed feed
+	// (session_fs_watch.go); shell sub-sessions share their parent's tree
+	// and get neither.
+	if p.ParentUUID == "" {
+		sess.Checkpoints = newSessionCheckpointer(sess.UUID, workDir, sess.isYoloMode)
+		sess.FSWatch = newSessionFSWatcher(workDir, func(f fsChangesFrame) { sess.BroadcastJSON(f) })
+		// Naming after the first prompt (session_autoname.go).
+		sess.AutoName = newSessionAutoNamer(derivedName, func() string {
+			sess.mu.RLock()
+			defer sess.mu.RUnlock()
+			return sess.Name
+		}, func(name string) error { return renameSession(sess, name) })
+	}
+
 	// Eagerly start the per-session md-serve for the Files tab. Child sessions
 	// inherit the parent's FilesPort, so they share the parent's md-serve (a
 	// second instance on the same port would fail to bind). md-serve is
@@ -5813,13 +6541,11 @@ func getOrCreateSession(p SessionParams, allowCreate bool) (*Session, bool, erro
 }
 
 func handleProxyRoute(w http.ResponseWriter, r *http.Request) {
-	// Extract session UUID from /proxy/{uuid}/{preview|agentchat}/...
-	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/proxy/"), "/", 2)
-	if len(parts) < 2 {
+	sessionUUID, ok := proxyRouteSessionUUID(r.URL.Path)
+	if !ok {
 		http.NotFound(w, r)
 		return
 	}
-	sessionUUID := parts[0]
 
 	sessionsMu.RLock()
 	sess, ok := sessions[sessionUUID]
@@ -5828,18 +6554,24 @@ func handleProxyRoute(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	sess.SessionMux.ServeHTTP(w, r)
+	traceHandler(w, r, "proxy.request", sess.SessionMux, "session.uuid", sessionUUID)
 }
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string) {
 	// Log client info for debugging
 	userAgent := r.Header.Get("User-Agent")
-	remoteAddr := r.RemoteAddr
-	log.Printf("WebSocket upgrade request: session=%s remote=%s UA=%s", sessionUUID, remoteAddr, userAgent)
+	remoteAddr := clientIP(r)
+	wsLog := requestLogger(r, "ws").With("session", sessionUUID)
+	wsLog.Info("WebSocket upgrade request", "ua", userAgent)
+
+	// ws.connect covers upgrade through the catch-up snapshot; ended
+	// explicitly below, the defer only catches early returns.
+	traceCtx, connectSpan := startServerSpan(r, "ws.connect", "session.uuid", sessionUUID)
+	defer connectSpan.End(nil)
 
 	rawConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v (remote=%s)", err, remoteAddr)
+		wsLog.Warn("WebSocket upgrade error", "error", err)
 		return
 	}
 	defer rawConn.Close()
@@ -5847,11 +6579,19 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 	// Wrap in SafeConn for thread-safe writes
 	conn := NewSafeConn(rawConn)
 
+	// Protocol handshake (ws_protocol.go), before anything else is sent.
+	if wsErr := checkWSProtocol(r.URL.Query().Get("proto")); wsErr != nil {
+		wsLog.Warn("WebSocket protocol mismatch", "proto", r.URL.Query().Get("proto"))
+		sendWSError(conn, *wsErr, "protocol mismatch")
+		return
+	}
+	conn.Send(wsProtocolHello())
+
 	// Get assistant from query param
 	assistant := r.URL.Query().Get("assistant")
 	if assistant == "" {
-		log.Printf("WebSocket error: no assistant specified (remote=%s)", remoteAddr)
-		conn.WriteMessage(websocket.TextMessage, []byte("Error: no assistant specified"))
+		wsLog.Warn("WebSocket error: no assistant specified")
+		sendWSError(conn, wsError{Type: "session_error", Code: wsErrAssistantUnknown, Message: "No assistant specified"}, "no assistant")
 		return
 	}
 
@@ -5950,6 +6690,7 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 		staged.params.SessionMode = resolveStagedMode(staged.params.SessionMode, sessionMode)
 		params = staged.params
 	}
+	params.Locale = requestLocale(r)
 
 	// Creation is permitted only when there is an explicit intent for this
 	// UUID: either a staged "new"/"fork" intent, or this is a child shell
@@ -5959,8 +6700,18 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 	// instead of materializing a ghost session -- and a live session always
 	// attaches regardless (the allowCreate flag only governs the create path).
 	allowCreate := isPending || parentUUID != ""
+	// A role without input (auth_roles.go) may watch live sessions but not
+	// start one.
+	identity := requestIdentity(r)
+	if !identity.can(permInput) {
+		allowCreate = false
+	}
 
+	params.TraceCtx = traceCtx
+	_, createSpan := startSpan(traceCtx, "session.get_or_create", "session.uuid", sessionUUID, "allow_create", allowCreate)
 	sess, isNew, err := getOrCreateSession(params, allowCreate)
+	createSpan.SetAttrs("session.new", isNew)
+	createSpan.End(err)
 	if errors.Is(err, errSessionGone) {
 		// No live session and no permission to create: stale tab, ended
 		// session, or a bogus/bookmarked UUID. Tell the client it's gone (and
@@ -5969,7 +6720,7 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 		_, _, _, ferr := validateForkSourceCheap(sessionUUID)
 		canResume := ferr == nil
 		hasChat, hasTerminal := sessionGoneRecordings(sessionUUID)
-		log.Printf("Session %s gone (no live session, no creation intent); canResume=%v hasChat=%v hasTerminal=%v (remote=%s)", sessionUUID, canResume, hasChat, hasTerminal, remoteAddr)
+		wsLog.Info("session gone (no live session, no creation intent)", "canResume", canResume, "hasChat", hasChat, "hasTerminal", hasTerminal)
 		if data, jerr := json.Marshal(map[string]interface{}{
 			"type":        "session_gone",
 			"uuid":        sessionUUID,
@@ -5986,34 +6737,87 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 		return
 	}
 	if err != nil {
-		log.Printf("Session creation error: %v (remote=%s)", err, remoteAddr)
+		wsLog.Error("session creation error", "error", err)
 		// Send the full error as JSON before closing -- the WS close-reason
 		// field is capped at 123 bytes and would truncate the useful tail of
 		// git's output (e.g. "fatal: 'main' is already checked out at ...").
-		// Close code 4002 tells the client "fatal, don't reconnect".
-		if data, jerr := json.Marshal(map[string]string{
-			"type":    "session_error",
-			"message": err.Error(),
-		}); jerr == nil {
-			conn.WriteMessage(websocket.TextMessage, data)
-		}
-		conn.WriteMessage(websocket.CloseMessage,
-			websocket.FormatCloseMessage(4002, "session creation failed"))
+		// Close code 4002 tells the client "fatal, don't reconnect" unless
+		// the error is retryable (ws_errors.go), in which case the intent is
+		// staged again for the retry to consume.
+		wsErr := newWSError(err)
+		if wsErr.Retryable && isPending {
+			stageSession(sessionUUID, staged.params, staged.kind, staged.orphanCleanupPath)
+		}
+		sendWSError(conn, wsErr, "session creation failed")
 		return
 	}
 
+	// A share-link guest: dropped when the share is revoked or expires, and
+	// a view-only one may watch but not type, resize or send control messages.
+	share := requestShareLink(r)
+	viewOnly := share != nil && share.Scope == shareScopeView || !identity.can(permInput)
+	if share != nil {
+		defer watchShareLinkConn(share, conn)()
+	}
+
 	// Add this client to the session
 	sess.AddClient(conn)
 	defer sess.RemoveClient(conn)
 
-	// Track visitor in metadata (for non-first clients)
+	// ?size=independent: left out of PTY sizing (session_size_mode.go).
+	if mode, ok := parseSizeMode(r.URL.Query().Get("size")); ok && mode == sizeModeIndependent {
+		sess.SetClientSizeMode(conn, mode)
+	}
+
+	// ?text=1 / ?text=only: plain-text stream (session_text_stream.go).
+	textStreamOn, textOnly := parseTextStreamMode(r.URL.Query().Get("text"))
+	if textStreamOn {
+		sess.addTextClient(conn, textOnly)
+		defer sess.removeTextClient(conn)
+	}
+
+	// Catch the new client up on the live files_changed feed (session_fs_watch.go).
+	if f := sess.FSWatch.replay(); f != nil {
+		conn.WriteJSON(f)
+	}
+
+	// ?input={id}: sequenced input; tell the client where its stream stands
+	// so it resends only what was lost (input_resume.go).
+	inputID := r.URL.Query().Get("input")
+	if !validInputStreamID(inputID) || viewOnly {
+		inputID = ""
+	}
+	if inputID != "" {
+		conn.Send(wsproto.InputResume{Seq: sess.inputResume.attach(inputID, time.Now())})
+		defer func() { sess.inputResume.detach(inputID, time.Now()) }()
+	}
+
+	// Record who started the session, and track visitors (non-first clients)
+	if isNew && identity.User != "" && len(authUsers) > 0 {
+		sess.mu.Lock()
+		sess.Owner = identity.User
+		if sess.Metadata != nil {
+			sess.Metadata.Owner = identity.User
+		}
+		sess.mu.Unlock()
+		if err := sess.saveMetadata(); err != nil {
+			log.Printf("Failed to save metadata for owner: %v", err)
+		}
+	}
 	if !isNew {
 		sess.mu.Lock()
 		if sess.Metadata != nil {
-			sess.Metadata.Visitors = append(sess.Metadata.Visitors, Visitor{
-				JoinedAt: time.Now(),
-				IP:       remoteAddr,
-			})
+			visitor := Visitor{JoinedAt: time.Now(), IP: remoteAddr}
+			login, tailnetName := tailnetUser(r)
+			visitor.Tailnet = login
+			if share != nil {
+				visitor.Name = share.Label
+			} else if len(authUsers) > 0 {
+				visitor.Name = identity.User
+			} else {
+				visitor.Name = tailnetName
+			}
+			sess.Metadata.Visitors = append(sess.Metadata.Visitors, visitor)
 		}
 		sess.mu.Unlock()
 		if err := sess.saveMetadata(); err != nil {
@@ -6021,46 +6825,69 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 		}
 	}
 
-	log.Printf("WebSocket connected: session=%s (new=%v, remote=%s)", sessionUUID, isNew, remoteAddr)
+	wsLog.Info("WebSocket connected", "new", isNew)
+
+	// ?resume={token}&offset={n}: a reconnect within the grace window gets
+	// just the output it missed (output_resume.go).
+	resumed := false
+	if !textOnly {
+		var resumeToken string
+		resumed, resumeToken = sess.startOutputResume(conn, r.URL.Query().Get("resume"), r.URL.Query().Get("offset"))
+		defer func() { sess.outputResume.closed(resumeToken, time.Now()) }()
+	}
 
 	// If this is a new session, start the PTY reader goroutine
 	if isNew {
 		sess.startPTYReader()
-	} else {
+	} else if !textOnly && !resumed {
 		// Send ring buffer (scrollback history) first, then VT snapshot
+
 		// Both are gzip-compressed and sent as chunked messages for iOS Safari compatibility
-		log.Printf("Generating scrollback and snapshot for joining client (remote=%s)", remoteAddr)
+		wsLog.Info("generating scrollback and snapshot for joining client")
 
 		// Send ring buffer contents (scrollback history) if any
 		sess.vtMu.Lock()
-		ringData := sess.readRing()
+		ringData := sess.ring.Bytes()
 		sess.vtMu.Unlock()
 
 		if len(ringData) > 0 {
-			compressed, err := compressSnapshot(ringData)
+			compressed, err := termsnap.Compress(ringData)
 			if err != nil {
-				log.Printf("Failed to compress scrollback: %v (remote=%s)", err, remoteAddr)
+				wsLog.Error("failed to compress scrollback", "error", err)
 			} else {
-				log.Printf("Sending %d bytes of scrollback history (compressed: %d bytes, remote=%s)", len(ringData), len(compressed), remoteAddr)
-				numChunks, err := sendChunked(conn, compressed, DefaultChunkSize)
+				wsLog.Info("sending scrollback history", "bytes", len(ringData), "compressed", len(compressed))
+				numChunks, err := sendChunkedTraced(traceCtx, "scrollback", conn, compressed, DefaultChunkSize)
 				if err != nil {
-					log.Printf("Failed to send scrollback chunks: %v (remote=%s, sent %d chunks before error)", err, remoteAddr, numChunks)
+					wsLog.Warn("failed to send scrollback chunks", "error", err, "chunksSent", numChunks)
 				} else {
-					log.Printf("Sent scrollback history (%d bytes raw, %d chunks, remote=%s)", len(ringData), numChunks, remoteAddr)
+					wsLog.Info("sent scrollback history", "bytes", len(ringData), "chunks", numChunks)
 				}
 			}
 		}
 
 		// Send VT snapshot (positions cursor correctly on current screen)
+		_, snapSpan := startSpan(traceCtx, "session.snapshot.generate")
 		snapshot := sess.GenerateSnapshot()
-		log.Printf("Sending %d byte snapshot to client (remote=%s)", len(snapshot), remoteAddr)
-		numChunks, err := sendChunked(conn, snapshot, DefaultChunkSize)
+		snapSpan.SetAttrs("bytes", len(snapshot))
+		snapSpan.End(nil)
+		wsLog.Info("sending screen snapshot", "bytes", len(snapshot))
+		numChunks, err := sendChunkedTraced(traceCtx, "snapshot", conn, snapshot, DefaultChunkSize)
 		if err != nil {
-			log.Printf("Failed to send snapshot chunks: %v (remote=%s, sent %d chunks before error)", err, remoteAddr, numChunks)
+			wsLog.Warn("failed to send snapshot chunks", "error", err, "chunksSent", numChunks)
 		} else {
-			log.Printf("Sent screen snapshot to new client (%d bytes in %d chunks, remote=%s)", len(snapshot), numChunks, remoteAddr)
+			wsLog.Info("sent screen snapshot", "bytes", len(snapshot), "chunks", numChunks)
 		}
 	}
+	connectSpan.SetAttrs("session.new", isNew)
+	connectSpan.End(nil)
+
+	// A hibernated session wakes for its visitor (session_hibernate.go).
+	sess.wakeForClient(conn)
+
+	// An approval prompt waiting for an answer (approval_relay.go).
+	if req := sess.pendingApproval(); req != nil {
+		conn.WriteJSON(req)
+	}
 
 	// Push the connect-time credential/signing snapshot so the Settings
 	// panel reflects true server-side state without a manual Save. Sent to
@@ -6075,53 +6902,123 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 	//   - If starts with 0x00 and len >= 5: resize message [0x00, rows_hi, rows_lo, cols_hi, cols_lo]
 	//   - Otherwise: terminal input
 	// - Text frames: JSON control messages {"type": "...", ...}
+	// Pings with a pong deadline drop half-open connections (ws_heartbeat.go).
+	defer startWSHeartbeat(conn)()
 	for {
 		messageType, data, err := conn.ReadMessage()
 		if err != nil {
 			// Provide more context on disconnect reason
+			var ne net.Error
 			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
-				log.Printf("WebSocket closed: %v (remote=%s)", err, remoteAddr)
+				wsLog.Info("WebSocket closed", "reason", err)
+			} else if errors.As(err, &ne) && ne.Timeout() {
+				wsLog.Info("WebSocket dead: no pong", "after", wsPongWait)
 			} else {
-				log.Printf("WebSocket read error: %v (remote=%s)", err, remoteAddr)
+				wsLog.Warn("WebSocket read error", "error", err)
 			}
 			break
 		}
 
+		conn.extendReadDeadline()
+
+		if viewOnly && !viewOnlyMessageAllowed(messageType, data) {
+			continue
+		}
+
 		// Handle text (JSON) messages
 		if messageType == websocket.TextMessage {
-			var msg struct {
-				Type     string          `json:"type"`
-				Data     json.RawMessage `json:"data,omitempty"`
-				UserName string          `json:"userName,omitempty"`
-				Text     string          `json:"text,omitempty"`
-				Name     string          `json:"name,omitempty"`
+			// Typed control messages; see wsproto/messages.go.
+			m, err := wsproto.Decode(data)
+			if errors.Is(err, wsproto.ErrUnknownType) {
+				log.Printf("Unknown message type: %v", err)
+				continue
 			}
-			if err := json.Unmarshal(data, &msg); err != nil {
-				log.Printf("Invalid JSON message: %v", err)
+			if err != nil {
+				log.Printf("Session %s: invalid message: %v", sess.UUID, err)
+				var env wsproto.Envelope
+				if json.Unmarshal(data, &env) == nil && env.Type == "copy_rows" {
+					conn.Send(wsproto.CopyError{Message: "invalid copy_rows payload"})
+				}
 				continue
 			}
 
-			switch msg.Type {
-			case "ping":
-				response := map[string]interface{}{"type": "pong"}
-				if msg.Data != nil {
-					response["data"] = msg.Data
-				}
-				if err := conn.WriteJSON(response); err != nil {
+			switch msg := m.(type) {
+			case *wsproto.Ping:
+				if err := conn.Send(wsproto.Pong{Data: msg.Data}); err != nil {
 					log.Printf("Failed to send pong: %v", err)
 				}
-			case "chat":
+			case *wsproto.Chat:
 				// Handle incoming chat message
 				if msg.UserName != "" && msg.Text != "" {
 					sess.BroadcastChatMessage(msg.UserName, msg.Text)
 					log.Printf("Chat message from %s: %s", msg.UserName, msg.Text)
 				}
-			case "rename_session":
+			case *wsproto.Bookmark:
+				// Pin a note at this moment of the recording
+				handleBookmarkMessage(sess, conn, msg.Text, msg.UserName)
+			case *wsproto.Prompt:
+				// Hold a prompt until the agent goes quiet; see prompt_queue.go.
+				var reply wsproto.Message
+				if n, err := sess.QueuePrompt(msg.Text); err != nil {
+					reply = wsproto.PromptError{Message: err.Error()}
+				} else {
+					reply = wsproto.PromptQueued{Queued: n}
+				}
+				if err := conn.Send(reply); err != nil {
+					log.Printf("Failed to send prompt reply: %v", err)
+				}
+			case *wsproto.ClearPrompts:
+				sess.ClearPrompts()
+			case *wsproto.RenameSession:
 				// Handle session rename request
 				if err := renameSession(sess, msg.Name); err != nil {
 					log.Printf("Session rename rejected: %v", err)
+					reply := wsproto.RenameError{Code: "invalid_name", Message: err.Error()}
+					var nameErr *sessionNameError
+					if errors.As(err, &nameErr) {
+						reply.Code, reply.Suggestion = wsErrNameTaken, nameErr.Suggestion
+					}
+					if err := conn.Send(reply); err != nil {
+						log.Printf("Failed to send rename reply: %v", err)
+					}
+				}
+			case *wsproto.SetTheme:
+				// The UI switched light/dark mid-session. Stored for processes
+				// spawned from here on (COLORFGBG) and reported to the running
+				// TUI via OSC 11; see session_theme.go.
+				changed, err := sess.SetTheme(msg.Theme)
+				if err != nil {
+					log.Printf("Session %s: set_theme: %v", sess.UUID, err)
+				}
+				if changed {
+					log.Printf("Session %s: theme set to %s", sess.UUID, msg.Theme)
+				}
+			case *wsproto.SetSizeMode:
+				// Follow the shared PTY size or render it scaled; see
+				// session_size_mode.go.
+				mode, ok := parseSizeMode(msg.Mode)
+				if !ok {
+					log.Printf("Session %s: set_size_mode invalid mode %q", sess.UUID, msg.Mode)
+					continue
+				}
+				sess.SetClientSizeMode(conn, mode)
+				conn.Send(wsproto.SizeMode{Mode: mode})
+			case *wsproto.PinSize:
+				// Pin the PTY to an explicit size, 0x0 to unpin; see
+				// session_size_pin.go.
+				if err := sess.SetPinnedSize(msg.Cols, msg.Rows); err != nil {
+					log.Printf("Session %s: pin_size rejected: %v", sess.UUID, err)
+					continue
+				}
+				log.Printf("Session %s: PTY size pinned to %dx%d", sess.UUID, msg.Cols, msg.Rows)
+			case *wsproto.PauseRecording, *wsproto.ResumeRecording:
+				// Stop or restart capture; see session_recorder.go.
+				sess.SetRecordingPaused(m.MessageType() == "pause_recording")
+			case *wsproto.ToggleYolo:
+				if !identity.can(permYolo) {
+					log.Printf("Session %s: toggle_yolo refused for %s (role %s)", sess.UUID, identity.User, identity.Role)
+					continue
 				}
-			case "toggle_yolo":
 				// Handle YOLO mode toggle request
 				// Check if agent supports YOLO mode
 				if sess.AssistantConfig.YoloRestartCmd == "" {
@@ -6132,8 +7029,6 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 				sess.mu.Lock()
 				newYoloMode := !sess.yoloMode
 				sess.yoloMode = newYoloMode
-				sess.pendingReplacement = sess.computeRestartCommand(newYoloMode)
-				cmd := sess.Cmd
 				sess.mu.Unlock()
 
 				log.Printf("Session %s: toggling YOLO mode to %v", sess.UUID, newYoloMode)
@@ -6141,43 +7036,57 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 				// Broadcast status update with new YOLO state
 				sess.BroadcastStatus()
 
-				// Send visual feedback to terminal
-				modeStr := "OFF"
+				msgKey := "terminal.yoloOff"
 				if newYoloMode {
-					modeStr = "ON"
+					msgKey = "terminal.yoloOn"
 				}
-				feedbackMsg := []byte(fmt.Sprintf("\r\n[Switching YOLO mode %s, restarting agent...]\r\n", modeStr))
-				sess.vtMu.Lock()
-				sess.vt.Write(feedbackMsg)
-				sess.writeToRing(feedbackMsg)
-				sess.vtMu.Unlock()
-				sess.Broadcast(feedbackMsg)
-
-				// Kill process group - pendingReplacement will cause process to be replaced
-				if cmd != nil && cmd.Process != nil {
-					log.Printf("[KILL] YOLO toggle: sending SIGTERM to process group -%d (server pid=%d)", cmd.Process.Pid, os.Getpid())
-					syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+				sess.restartAgent(sess.computeRestartCommand(newYoloMode), msgKey)
+			case *wsproto.Approve:
+				// Answer the agent's approval prompt; see approval_relay.go.
+				if err := sess.answerApproval("approve", msg.ID, msg.UserName); err != nil {
+					conn.Send(wsproto.ApprovalError{ID: msg.ID, Message: err.Error()})
+				}
+			case *wsproto.Deny:
+				if err := sess.answerApproval("deny", msg.ID, msg.UserName); err != nil {
+					conn.Send(wsproto.ApprovalError{ID: msg.ID, Message: err.Error()})
 				}
-			case "set_credentials":
+			case *wsproto.RestartAgent:
+				// Restart the agent in place, keeping its YOLO mode; see
+				// the command palette actions (session_actions.go).
+				if reason := sess.restartUnavailable(); reason != "" {
+					log.Printf("Session %s: restart_agent ignored (%s)", sess.UUID, reason)
+					continue
+				}
+				sess.mu.RLock()
+				yolo := sess.yoloMode
+				sess.mu.RUnlock()
+				log.Printf("Session %s: restarting agent", sess.UUID)
+				sess.restartAgent(sess.computeRestartCommand(yolo), "terminal.restarting")
+			case *wsproto.StartPreviewApp:
+				// Start the repo's dev server; see session_preview_app.go.
+				if _, err := sess.startPreviewApp(); err != nil {
+					conn.Send(wsproto.PreviewAppError{Message: err.Error()})
+				}
+			case *wsproto.StopPreviewApp:
+				go sess.stopPreviewApp()
+			case *wsproto.CopyRows:
+				// Rows of the session's history as a one-time download; see
+				// session_copy.go. Replaying the output can take a moment.
+				go func() {
+					defer recoverGoroutine(fmt.Sprintf("copy for session %s", sess.UUID))
+					reply, err := sess.copyRows(msg.Start, msg.End)
+					if err != nil {
+						conn.Send(wsproto.CopyError{Message: err.Error()})
+						return
+					}
+					conn.WriteJSON(reply)
+				}()
+			case *wsproto.SetCredentials:
 				// Browser supplies per-session HTTPS credentials over the
 				// already-authenticated WebSocket. Write-only: there is no
 				// API to read them back to the browser. Server-side they
 				// flow OUT to git via the broker socket.
-				var payload struct {
-					Host                 string `json:"host"`
-					Username             string `json:"username"`
-					Token                string `json:"token"`
-					Name                 string `json:"name"`
-					Email                string `json:"email"`
-					SigningPrivateKeyPEM string `json:"signing_private_key_pem"`
-					SigningPassphrase    string `json:"signing_passphrase"`
-					SigningKeyLabel      string `json:"signing_key_label"`
-				}
-				if err := json.Unmarshal(msg.Data, &payload); err != nil {
-					log.Printf("Session %s: set_credentials invalid payload: %v", sess.UUID, err)
-					continue
-				}
-				host := strings.TrimSpace(payload.Host)
+				host := strings.TrimSpace(msg.Host)
 				if host == "" {
 					host = "github.com"
 				}
@@ -6190,8 +7099,8 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 					signingFingerprint string
 					signingError       string
 				)
-				if pem := strings.TrimSpace(payload.SigningPrivateKeyPEM); pem != "" {
-					key, err := parseSigningKey([]byte(pem), payload.SigningPassphrase, strings.TrimSpace(payload.SigningKeyLabel))
+				if pem := strings.TrimSpace(msg.SigningPrivateKeyPEM); pem != "" {
+					key, err := parseSigningKey([]byte(pem), msg.SigningPassphrase, strings.TrimSpace(msg.SigningKeyLabel))
 					if err != nil {
 						signingError = err.Error()
 						log.Printf("Session %s: signing key parse failed: %v", sess.UUID, err)
@@ -6207,12 +7116,12 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 				// never sees a half-applied update (new author, old key, etc.).
 				sessionCredStateMu.Lock()
 				setCredential(sess.UUID, host, CredentialBag{
-					Username: strings.TrimSpace(payload.Username),
-					Token:    payload.Token,
+					Username: strings.TrimSpace(msg.Username),
+					Token:    msg.Token,
 				})
 				setAuthor(sess.UUID, AuthorIdent{
-					Name:  strings.TrimSpace(payload.Name),
-					Email: strings.TrimSpace(payload.Email),
+					Name:  strings.TrimSpace(msg.Name),
+					Email: strings.TrimSpace(msg.Email),
 				})
 				if haveParsedKey {
 					setSigningKey(sess.UUID, parsedKey)
@@ -6231,67 +7140,41 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 				// Push the refreshed state to all conns of this session so
 				// co-viewers do not go stale (idempotent; see BroadcastJSON).
 				sess.BroadcastJSON(buildSessionCredState(sess.UUID, sess.effectiveWorkDir()))
-				ack := map[string]any{
-					"type":  "credentials_stored",
-					"host":  host,
-					"hosts": listCredentialHosts(sess.UUID),
-				}
-				if signingFingerprint != "" {
-					ack["signing_fingerprint"] = signingFingerprint
-				}
-				if signingError != "" {
-					ack["signing_error"] = signingError
+				ack := wsproto.CredentialsStored{
+					Host:               host,
+					Hosts:              listCredentialHosts(sess.UUID),
+					SigningFingerprint: signingFingerprint,
+					SigningError:       signingError,
 				}
-				if err := conn.WriteJSON(ack); err != nil {
+				if err := conn.Send(ack); err != nil {
 					log.Printf("Session %s: failed to ack set_credentials: %v", sess.UUID, err)
 				}
-			case "test_credentials":
+			case *wsproto.TestCredentials:
 				// Validate the supplied HTTPS credentials by attempting an
 				// authenticated GET against the host. Does not persist the
 				// credentials; the user explicitly hits Save Credentials
-				// after a successful test.
-				var payload struct {
-					Host     string `json:"host"`
-					Username string `json:"username"`
-					Token    string `json:"token"`
-				}
-				if err := json.Unmarshal(msg.Data, &payload); err != nil {
-					log.Printf("Session %s: test_credentials invalid payload: %v", sess.UUID, err)
-					continue
-				}
-				host := strings.TrimSpace(payload.Host)
+				// after a successful test.
+				host := strings.TrimSpace(msg.Host)
 				if host == "" {
 					host = "github.com"
 				}
-				ack := map[string]any{"type": "credentials_tested"}
-				if strings.TrimSpace(payload.Token) == "" {
-					ack["ok"] = false
-					ack["message"] = "Token is empty"
-				} else {
+				ack := wsproto.CredentialsTested{Message: "Token is empty"}
+				if strings.TrimSpace(msg.Token) != "" {
 					ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
-					ok, message := testGitCredentials(ctx, host, strings.TrimSpace(payload.Username), payload.Token)
+					ack.OK, ack.Message = testGitCredentials(ctx, host, strings.TrimSpace(msg.Username), msg.Token)
 					cancel()
-					ack["ok"] = ok
-					ack["message"] = message
-					log.Printf("Session %s: test_credentials host=%q ok=%v", sess.UUID, host, ok)
+					log.Printf("Session %s: test_credentials host=%q ok=%v", sess.UUID, host, ack.OK)
 				}
-				if err := conn.WriteJSON(ack); err != nil {
+				if err := conn.Send(ack); err != nil {
 					log.Printf("Session %s: failed to ack test_credentials: %v", sess.UUID, err)
 				}
-			case "set_env":
+			case *wsproto.SetEnv:
 				// Browser supplies the repo env-vars textarea blob over the
 				// authenticated WS. Stored in memory only (never on disk);
 				// injected into NEWLY opened sessions by buildSessionEnv. The
 				// current session is unaffected -- env is materialized at spawn.
-				var payload struct {
-					Raw string `json:"raw"`
-				}
-				if err := json.Unmarshal(msg.Data, &payload); err != nil {
-					log.Printf("Session %s: set_env invalid payload: %v", sess.UUID, err)
-					continue
-				}
 				sessionCredStateMu.Lock()
-				setSessionEnv(sess.UUID, payload.Raw)
+				setSessionEnv(sess.UUID, msg.Raw)
 				sessionCredStateMu.Unlock()
 				// Reserved keys the user tried to set are reported back so the
 				// UI can show which were ignored. Lookup is throwaway here (we
@@ -6302,10 +7185,10 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 				if dropped == nil {
 					dropped = []string{}
 				}
-				if err := conn.WriteJSON(map[string]any{"type": "env_stored", "dropped": dropped}); err != nil {
+				if err := conn.Send(wsproto.EnvStored{Dropped: dropped}); err != nil {
 					log.Printf("Session %s: failed to ack set_env: %v", sess.UUID, err)
 				}
-			case "clear_env":
+			case *wsproto.ClearEnv:
 				// "Forget on this device" also clears the server-side copy so a
 				// co-viewer's next session doesn't silently inherit stale vars.
 				sessionCredStateMu.Lock()
@@ -6313,37 +7196,28 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 				sessionCredStateMu.Unlock()
 				log.Printf("Session %s: cleared repo env vars", sess.UUID)
 				sess.BroadcastJSON(buildSessionCredState(sess.UUID, sess.effectiveWorkDir()))
-				if err := conn.WriteJSON(map[string]any{"type": "env_cleared"}); err != nil {
+				if err := conn.Send(wsproto.EnvCleared{}); err != nil {
 					log.Printf("Session %s: failed to ack clear_env: %v", sess.UUID, err)
 				}
-			case "set_signing_key":
+			case *wsproto.SetSigningKey:
 				// Browser supplies an SSH signing key independent of the
 				// HTTPS PAT. Mirrors the signing branch of set_credentials
 				// but never touches credential / author state.
-				var payload struct {
-					SigningPrivateKeyPEM string `json:"signing_private_key_pem"`
-					SigningPassphrase    string `json:"signing_passphrase"`
-					SigningKeyLabel      string `json:"signing_key_label"`
-				}
-				if err := json.Unmarshal(msg.Data, &payload); err != nil {
-					log.Printf("Session %s: set_signing_key invalid payload: %v", sess.UUID, err)
-					continue
-				}
-				ack := map[string]any{"type": "signing_key_stored"}
-				pem := strings.TrimSpace(payload.SigningPrivateKeyPEM)
+				var ack wsproto.SigningKeyStored
+				pem := strings.TrimSpace(msg.SigningPrivateKeyPEM)
 				if pem == "" {
-					ack["error"] = "no key provided"
+					ack.Error = "no key provided"
 				} else {
-					key, err := parseSigningKey([]byte(pem), payload.SigningPassphrase, strings.TrimSpace(payload.SigningKeyLabel))
+					key, err := parseSigningKey([]byte(pem), msg.SigningPassphrase, strings.TrimSpace(msg.SigningKeyLabel))
 					if err != nil {
-						ack["error"] = err.Error()
+						ack.Error = err.Error()
 						log.Printf("Session %s: set_signing_key parse failed: %v", sess.UUID, err)
 					} else {
 						setSigningKey(sess.UUID, key)
 						if err := writeSessionGitconfig(sess.UUID, sess.effectiveWorkDir()); err != nil {
 							log.Printf("Session %s: writeSessionGitconfig failed: %v", sess.UUID, err)
 						}
-						ack["fingerprint"] = key.Fingerprint
+						ack.Fingerprint = key.Fingerprint
 						log.Printf("Session %s: stored signing key (fp=%s, label=%q)", sess.UUID, key.Fingerprint, key.Label)
 						// Refresh all conns: a freshly auto-restored key may flip
 						// signing_active true (e.g. after the connect-time snapshot
@@ -6351,80 +7225,83 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 						sess.BroadcastJSON(buildSessionCredState(sess.UUID, sess.effectiveWorkDir()))
 					}
 				}
-				if err := conn.WriteJSON(ack); err != nil {
+				if err := conn.Send(ack); err != nil {
 					log.Printf("Session %s: failed to ack set_signing_key: %v", sess.UUID, err)
 				}
-			case "verify_signing_key":
+			case *wsproto.VerifySigningKey:
 				// Parse + decrypt the supplied key, return the fingerprint,
 				// then discard. Does NOT persist and does NOT contact the forge.
-				var payload struct {
-					SigningPrivateKeyPEM string `json:"signing_private_key_pem"`
-					SigningPassphrase    string `json:"signing_passphrase"`
-					SigningKeyLabel      string `json:"signing_key_label"`
-				}
-				if err := json.Unmarshal(msg.Data, &payload); err != nil {
-					log.Printf("Session %s: verify_signing_key invalid payload: %v", sess.UUID, err)
-					continue
-				}
-				ack := map[string]any{"type": "signing_key_verified"}
-				pem := strings.TrimSpace(payload.SigningPrivateKeyPEM)
+				var ack wsproto.SigningKeyVerified
+				pem := strings.TrimSpace(msg.SigningPrivateKeyPEM)
 				if pem == "" {
-					ack["error"] = "no key provided"
+					ack.Error = "no key provided"
 				} else {
-					key, err := parseSigningKey([]byte(pem), payload.SigningPassphrase, strings.TrimSpace(payload.SigningKeyLabel))
+					key, err := parseSigningKey([]byte(pem), msg.SigningPassphrase, strings.TrimSpace(msg.SigningKeyLabel))
 					if err != nil {
-						ack["error"] = err.Error()
+						ack.Error = err.Error()
 					} else {
-						ack["fingerprint"] = key.Fingerprint
+						ack.Fingerprint = key.Fingerprint
 					}
 				}
-				if err := conn.WriteJSON(ack); err != nil {
+				if err := conn.Send(ack); err != nil {
 					log.Printf("Session %s: failed to ack verify_signing_key: %v", sess.UUID, err)
 				}
-			case "verify_stored_signing_key":
+			case *wsproto.VerifyStoredSigningKey:
 				// Verify the key ALREADY registered for this session (e.g.
 				// auto-restored) without re-sending the PEM, which the form
 				// may not hold this session. Signs a tiny test payload with
 				// the in-memory signer to prove it is loadable and functional,
 				// then returns the fingerprint. Acks with the same
 				// signing_key_verified type the frontend already handles.
-				ack := map[string]any{"type": "signing_key_verified"}
+				var ack wsproto.SigningKeyVerified
 				if key, ok := getSigningKey(sess.UUID); !ok || key.Signer == nil {
-					ack["error"] = "no signing key registered this session"
+					ack.Error = "no signing key registered this session"
 				} else if _, err := signSSH([]byte("swe-swe verify"), key.Signer, "git"); err != nil {
-					ack["error"] = "stored key failed to sign: " + err.Error()
+					ack.Error = "stored key failed to sign: " + err.Error()
 				} else {
-					ack["fingerprint"] = key.Fingerprint
+					ack.Fingerprint = key.Fingerprint
 				}
-				if err := conn.WriteJSON(ack); err != nil {
+				if err := conn.Send(ack); err != nil {
 					log.Printf("Session %s: failed to ack verify_stored_signing_key: %v", sess.UUID, err)
 				}
 			default:
-				log.Printf("Unknown message type: %s", msg.Type)
+				log.Printf("Unexpected message type from client: %s", m.MessageType())
 			}
 			continue
 		}
 
-		// Handle binary messages (terminal I/O)
+		// Handle binary messages (terminal I/O); frames are in wsproto/binary.go
+		// Unwrap sequenced input (0x04 prefix), dropping frames already
+		// applied before a reconnect (input_resume.go). Only a client that
+		// connected with ?input= sends these; for any other, 0x04 is Ctrl-D
+		// and goes to the PTY as typed.
+		sequenced := false
+		if seq, frame, ok := wsproto.ParseSequenced(data); ok && inputID != "" {
+			apply := sess.inputResume.accept(inputID, seq)
+			if err := conn.Send(wsproto.InputAck{Seq: seq}); err != nil {
+				wsLog.Warn("input ack write error", "error", err)
+			}
+			if !apply || len(frame) == 0 {
+				continue
+			}
+			data, sequenced = frame, true
+		}
+
 		// Check for resize message (0x00 prefix)
-		if len(data) >= 5 && data[0] == 0x00 {
-			rows := uint16(data[1])<<8 | uint16(data[2])
-			cols := uint16(data[3])<<8 | uint16(data[4])
+		if rows, cols, ok := wsproto.ParseResize(data); ok {
 			sess.UpdateClientSize(conn, rows, cols)
 			continue
 		}
 
 		// Check for file upload message (0x01 prefix)
-		// Format: [0x01, name_len_hi, name_len_lo, ...name_bytes, ...file_data]
-		if len(data) >= 3 && data[0] == 0x01 {
-			nameLen := int(data[1])<<8 | int(data[2])
-			if len(data) < 3+nameLen {
+		if upload, ok, err := wsproto.ParseUpload(data); ok {
+			if err != nil {
 				log.Printf("Invalid file upload: data too short for filename")
 				sendFileUploadResponse(conn, false, "", "Invalid upload format")
 				continue
 			}
-			filename := string(data[3 : 3+nameLen])
-			fileData := data[3+nameLen:]
+			filename := upload.Name
+			fileData := upload.Data
 
 			// Sanitize filename: only keep the base name, no path traversal
 			filename = sanitizeFilename(filename)
@@ -6433,25 +7310,13 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 				continue
 			}
 
-			// Resolve uploads directory relative to session's working directory
-			baseDir := sess.WorkDir
-			if baseDir == "" {
-				baseDir, _ = os.Getwd()
-			}
-			uploadsDir := filepath.Join(baseDir, ".swe-swe", "uploads")
-			if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-				log.Printf("Failed to create uploads directory: %v", err)
-				sendFileUploadResponse(conn, false, filename, "Failed to create uploads directory")
-				continue
-			}
-
-			// Save the file to the uploads directory
-			filePath := filepath.Join(uploadsDir, filename)
-			if err := os.WriteFile(filePath, fileData, 0644); err != nil {
+			filePath, err := saveSessionUpload(sess, filename, fileData)
+			if err != nil {
 				log.Printf("File upload error: %v", err)
 				sendFileUploadResponse(conn, false, filename, err.Error())
 				continue
 			}
+			filename = filepath.Base(filePath)
 
 			log.Printf("File uploaded: %s (%d bytes)", filePath, len(fileData))
 			sendFileUploadResponse(conn, true, filename, "")
@@ -6459,19 +7324,52 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, sessionUUID string)
 			// Send the file path to PTY - Claude Code will detect it and read from disk
 			absFilePath := filePath
 			if err := sess.WriteInput([]byte(absFilePath)); err != nil {
-				log.Printf("PTY write error for uploaded file path: %v", err)
+				wsLog.Error("PTY write error for uploaded file path", "error", err)
+			}
+			continue
+		}
+
+		// Check for clipboard image paste message (0x03 prefix), which is
+		// only taken from inside a sequenced frame: bare, 0x03 is Ctrl-C.
+		// The clipboard carries no filename, so one is derived from the
+		// content type and the paste time.
+		if paste, ok, err := wsproto.ParsePaste(data); ok && sequenced {
+			if err != nil {
+				log.Printf("Invalid image paste: data too short for content type")
+				sendFileUploadResponse(conn, false, "", "Invalid paste format")
+				continue
+			}
+			imageData := paste.Data
+			filename, err := pastedImageName(paste.ContentType, imageData, time.Now())
+			if err != nil {
+				sendFileUploadResponse(conn, false, "", err.Error())
+				continue
+			}
+
+			filePath, err := saveSessionUpload(sess, filename, imageData)
+			if err != nil {
+				log.Printf("Image paste error: %v", err)
+				sendFileUploadResponse(conn, false, filename, err.Error())
+				continue
+			}
+
+			log.Printf("Image pasted: %s (%d bytes)", filePath, len(imageData))
+			sendFileUploadResponse(conn, true, filepath.Base(filePath), "")
+			if err := sess.WriteInput([]byte(filePath)); err != nil {
+				wsLog.Error("PTY write error for pasted image path", "error", err)
 			}
 			continue
 		}
 
 		// Regular terminal input
 		if err := sess.WriteInput(data); err != nil {
-			log.Printf("PTY write error: %v", err)
+			wsLog.Error("PTY write error", "error", err)
 			break
 		}
+		sess.Checkpoints.noteInput(data)
 	}
 
-	log.Printf("WebSocket disconnected: session=%s (remote=%s)", sessionUUID, remoteAddr)
+	wsLog.Info("WebSocket disconnected")
 }
 
 // parseCommand splits a command string into executable and arguments
@@ -6538,7 +7436,31 @@ func (s *Session) saveMetadata() error {
 		}
 	}
 
-	path := fmt.Sprintf("%s/%s.metadata.json", recordingsDir, recPrefix)
+	// Snapshot the files the session changed when it ends (only once), while
+	// the working directory still exists.
+	if metadata.EndedAt != nil && metadata.Changes == nil {
+		s.mu.RLock()
+		startCommit := metadata.StartCommit
+		s.mu.RUnlock()
+		if changes := captureRecordingChanges(s.effectiveWorkDir(), startCommit); changes != nil {
+			s.mu.Lock()
+			s.Metadata.Changes = changes
+			s.mu.Unlock()
+		}
+	}
+
+	// Hash the recording once its files are complete. A session closed from
+	// the server is saved again when its process exits and the recorder
+	// closes.
+	if metadata.EndedAt != nil && metadata.Integrity == nil && s.recorder.Load().Closed() {
+		if integrity := hashRecording(recPrefix); integrity != nil {
+			s.mu.Lock()
+			s.Metadata.Integrity = integrity
+			s.mu.Unlock()
+		}
+	}
+
+	path := recordingPath(recPrefix + ".metadata.json")
 	data, err := json.MarshalIndent(metadata, "", "  ")
 	if err != nil {
 		return err
@@ -6559,7 +7481,7 @@ func (s *Session) saveMetadata() error {
 // whose metadata is quarantined will fall through loadEndedRecordings' mtime
 // fallback path and remain visible on the homepage.
 func quarantineCorruptMetadata() {
-	entries, err := os.ReadDir(recordingsDir)
+	entries, err := readRecordingEntries()
 	if err != nil {
 		return
 	}
@@ -6572,7 +7494,7 @@ func quarantineCorruptMetadata() {
 		name := entry.Name()
 		// Clean up stale .tmp files from interrupted atomic writes
 		if strings.HasPrefix(name, "session-") && strings.HasSuffix(name, ".metadata.json.tmp") {
-			path := recordingsDir + "/" + name
+			path := recordingPath(name)
 			if err := os.Remove(path); err == nil {
 				tmps++
 			}
@@ -6580,7 +7502,7 @@ func quarantineCorruptMetadata() {
 		}
 		// Clean up old .corrupt files past TTL (same as recording expiry)
 		if strings.HasPrefix(name, "session-") && strings.HasSuffix(name, ".metadata.json.corrupt") {
-			path := recordingsDir + "/" + name
+			path := recordingPath(name)
 			if fi, err := entry.Info(); err == nil && now.Sub(fi.ModTime()) > recentRecordingMaxAge {
 				if err := os.Remove(path); err == nil {
 					stale++
@@ -6591,7 +7513,7 @@ func quarantineCorruptMetadata() {
 		if !strings.HasPrefix(name, "session-") || !strings.HasSuffix(name, ".metadata.json") {
 			continue
 		}
-		path := recordingsDir + "/" + name
+		path := recordingPath(name)
 		data, err := os.ReadFile(path)
 		if err != nil {
 			continue
@@ -6655,49 +7577,16 @@ func parseRecordingFilename(stem string) (parentUUID, childUUID string, ok bool)
 	}
 }
 
-// wrapWithScript wraps a command with the Linux script command for recording.
-// Returns the new command name and arguments to record terminal output and timing.
-// The prefix is the filename stem (e.g. "session-{uuid}" or "session-{parent}-{child}").
-//
-// Terminal output is written uncompressed to a .log file. Compression to .log.gz
-// happens lazily in the cleanup scheduler (cleanupRecentRecordings) after the
-// session ends. This avoids the complexity and reliability issues of real-time
-// gzip via FIFO (gzip buffers output internally and only flushes on EOF, which
-// conflicts with session termination via SIGKILL).
-func wrapWithScript(cmdName string, cmdArgs []string, prefix string) (string, []string) {
-	// Build the full command string for script -c
+// wrapWithShell joins a command and its arguments into one command line
+// and runs it with bash -c, so a setup-task prefix and shell syntax in the
+// agent command work. Recording is not done here: the server records the PTY
+// itself (session_recorder.go).
+func wrapWithShell(cmdName string, cmdArgs []string) (string, []string) {
 	fullCmd := cmdName
 	if len(cmdArgs) > 0 {
 		fullCmd += " " + strings.Join(cmdArgs, " ")
 	}
-
-	logPath := fmt.Sprintf("%s/%s.log", recordingsDir, prefix)
-	timingPath := fmt.Sprintf("%s/%s.timing", recordingsDir, prefix)
-	inputPath := fmt.Sprintf("%s/%s.input", recordingsDir, prefix)
-
-	wrapperScript := scriptWrapperCommand(runtime.GOOS, logPath, timingPath, inputPath, fullCmd)
-	return "bash", []string{"-c", wrapperScript}
-}
-
-// scriptWrapperCommand builds the shell command that records a PTY session.
-// Linux uses util-linux `script` with separate timing/input/output files,
-// enabling timed playback. macOS/BSD `script` has none of those flags
-// (-f/-T/-I/-O), so it records combined output to the .log file only -- no
-// timing file, so playback is plain (untimed) on macOS. The caller still
-// computes the .timing/.input paths; they simply will not exist on macOS,
-// which the cleanup + playback paths already tolerate (they stat for
-// existence and gate on HasTiming).
-func scriptWrapperCommand(goos, logPath, timingPath, inputPath, fullCmd string) string {
-	if goos == "linux" {
-		return fmt.Sprintf(
-			`script -q -f -T %[1]q -I %[2]q -O %[3]q -c %[4]q`,
-			timingPath, inputPath, logPath, fullCmd,
-		)
-	}
-	// BSD/macOS: `script [-q] file command ...` -- record combined output to
-	// the log file; run the command via bash -c to preserve the full command
-	// string (BSD script takes the command as trailing argv, not -c).
-	return fmt.Sprintf(`script -q %[1]q /bin/bash -c %[2]q`, logPath, fullCmd)
+	return "bash", []string{"-c", fullCmd}
 }
 
 // resolveLogPath returns the path to a recording's log file, checking for both
@@ -6719,12 +7608,12 @@ func sessionGoneRecordings(sourceUUID string) (hasChat, hasTerminal bool) {
 }
 
 func resolveLogPath(prefix string) string {
-	gzPath := fmt.Sprintf("%s/%s.log.gz", recordingsDir, prefix)
-	if _, err := os.Stat(gzPath); err == nil {
+	gzPath := recordingPath(prefix + ".log.gz")
+	if _, err := os.Stat(gzPath); err == nil && policyRecording.Allowed(gzPath) {
 		return gzPath
 	}
-	plainPath := fmt.Sprintf("%s/%s.log", recordingsDir, prefix)
-	if _, err := os.Stat(plainPath); err == nil {
+	plainPath := recordingPath(prefix + ".log")
+	if _, err := os.Stat(plainPath); err == nil && policyRecording.Allowed(plainPath) {
 		return plainPath
 	}
 	return ""
@@ -6793,8 +7682,20 @@ func (g *gzipReadCloser) Close() error {
 	return err
 }
 
-// sanitizeFilename removes path components and validates the filename
+// sanitizeFilename removes path components and validates the filename.
+// The name is NFC-normalized (so a decomposed accent, as macOS sends it, and
+// the composed form map to the same file) and stripped of control and
+// bidi-override characters, which could otherwise hide a spoofed extension or
+// corrupt the PTY echo.
 func sanitizeFilename(name string) string {
+	name = norm.NFC.String(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+
 	// Extract base name (remove any path components)
 	name = strings.TrimSpace(name)
 	if idx := strings.LastIndex(name, "/"); idx >= 0 {
@@ -6812,19 +7713,127 @@ func sanitizeFilename(name string) string {
 	return name
 }
 
-// sendFileUploadResponse sends a JSON response for file upload
-func sendFileUploadResponse(conn *SafeConn, success bool, filename, errMsg string) {
-	response := map[string]interface{}{
-		"type":    "file_upload",
-		"success": success,
+// saveSessionUpload stores an already-sanitized upload in the session's
+// .swe-swe/uploads directory (relative to its WorkDir) and returns the path
+// written, which may carry a collision suffix.
+func saveSessionUpload(sess *Session, filename string, data []byte) (string, error) {
+	baseDir := sess.WorkDir
+	if baseDir == "" {
+		baseDir, _ = os.Getwd()
+	}
+	uploadsDir := filepath.Join(baseDir, ".swe-swe", "uploads")
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		log.Printf("Failed to create uploads directory: %v", err)
+		return "", errors.New("Failed to create uploads directory")
+	}
+	return writeUniqueUpload(uploadsDir, filename, data)
+}
+
+// pastedImageExts maps clipboard image content types to file extensions.
+// Kept explicit rather than using mime.ExtensionsByType, whose answer
+// depends on the host's mime.types (image/jpeg -> ".jfif" on some distros).
+var pastedImageExts = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/bmp":     ".bmp",
+	"image/tiff":    ".tiff",
+	"image/svg+xml": ".svg",
+	"image/avif":    ".avif",
+	"image/heic":    ".heic",
+}
+
+// pastedImageName derives a filename for a clipboard image paste, e.g.
+// "paste-20260102-150405.png". The browser-reported content type wins; when
+// it is missing or unknown the bytes are sniffed. Non-image content is
+// rejected so the paste path cannot be used to drop arbitrary files.
+func pastedImageName(contentType string, data []byte, now time.Time) (string, error) {
+	if len(data) == 0 {
+		return "", errors.New("Empty image")
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = strings.TrimSpace(contentType[:i])
+	}
+	ext, ok := pastedImageExts[contentType]
+	if !ok {
+		sniffed := http.DetectContentType(data)
+		if i := strings.Index(sniffed, ";"); i >= 0 {
+			sniffed = sniffed[:i]
+		}
+		if ext, ok = pastedImageExts[sniffed]; !ok {
+			return "", fmt.Errorf("Unsupported paste type %q", contentType)
+		}
+	}
+	return "paste-" + now.Format("20060102-150405") + ext, nil
+}
+
+// maxUploadNameBytes caps a stored upload name, leaving room under the
+// common 255-byte filesystem limit for a collision suffix.
+const maxUploadNameBytes = 240
+
+// writeUniqueUpload writes data into dir under name, or under the first free
+// "stem-N.ext" variant when name is taken, and returns the path written.
+// O_EXCL makes the pick race-free against a concurrent upload of the same
+// name. name must already be sanitized.
+func writeUniqueUpload(dir, name string, data []byte) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if stem == "" {
+		// Dotfile like ".env": treat the whole name as the stem.
+		stem, ext = ext, ""
+	}
+	if len(ext) > maxUploadNameBytes/2 {
+		// Too long to be a real extension: truncate it with the stem.
+		stem, ext = stem+ext, ""
+	}
+	stem = truncateUTF8(stem, maxUploadNameBytes-len(ext))
+
+	for i := 0; i < 1000; i++ {
+		candidate := stem + ext
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+		path := filepath.Join(dir, candidate)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			os.Remove(path)
+			return "", err
+		}
+		if err := f.Close(); err != nil {
+			os.Remove(path)
+			return "", err
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("too many uploads named %q", name)
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
 	}
-	if filename != "" {
-		response["filename"] = filename
+	if n <= 0 {
+		return ""
 	}
-	if errMsg != "" {
-		response["error"] = errMsg
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	if err := conn.WriteJSON(response); err != nil {
+	return s[:n]
+}
+
+// sendFileUploadResponse sends a JSON response for file upload
+func sendFileUploadResponse(conn *SafeConn, success bool, filename, errMsg string) {
+	if err := conn.Send(wsproto.FileUpload{Success: success, Filename: filename, Error: errMsg}); err != nil {
 		log.Printf("Failed to send file upload response: %v", err)
 	}
 }
@@ -6847,7 +7856,7 @@ func handleSSLCertDownload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Length", strconv.Itoa(len(certData)))
 
 	w.Write(certData)
-	log.Printf("SSL certificate downloaded from %s", r.RemoteAddr)
+	log.Printf("SSL certificate downloaded from %s", clientLabel(r))
 }
 
 // RecordingListItem represents a recording in the API response
@@ -6874,6 +7883,7 @@ type RecordingInfo struct {
 	AgentBadgeClass string
 	EndedAgo        string           // "15m ago", "2h ago", "yesterday"
 	EndedAt         time.Time        // actual timestamp for sorting
+	StartedAt       time.Time        // zero when there is no metadata
 	KeptAt          *time.Time       // When user marked this recording to keep (nil = recent, auto-deletable)
 	IsKept          bool             // Convenience field for templates
 	ExpiresIn       string           // "59m", "30m" - time until auto-deletion (only for non-kept)
@@ -6946,251 +7956,11 @@ func formatSizeHuman(bytes int64) string {
 	}
 }
 
-// loadEndedRecordings returns a list of ended recordings for the homepage.
-// Child recordings (terminal, chat) are grouped into their parent's RecordingInfo.
+// loadEndedRecordings returns every ended recording, newest first. Child
+// recordings (terminal, chat) are grouped into their parent's RecordingInfo.
+// See recording_list.go.
 func loadEndedRecordings() []RecordingInfo {
-	entries, err := os.ReadDir(recordingsDir)
-	if err != nil {
-		return nil
-	}
-
-	// Build map of active recording UUIDs (only for sessions with running processes)
-	activeRecordings := make(map[string]bool)
-	sessionsMu.RLock()
-	for _, sess := range sessions {
-		// Only consider recording "active" if the process is still running
-		if sess.RecordingUUID != "" && sess.Cmd != nil && sess.Cmd.ProcessState == nil {
-			activeRecordings[sess.RecordingUUID] = true
-		}
-	}
-	sessionsMu.RUnlock()
-
-	// First pass: classify files into root and child sets.
-	// Track which parent UUIDs have children, and what kind.
-	type childInfo struct {
-		chatUUID      string
-		terminalUUIDs []string
-	}
-	children := make(map[string]*childInfo)  // parentUUID -> child info
-	rootLogs := make(map[string]os.DirEntry) // uuid -> dir entry for .log files
-	rootSizes := make(map[string]int64)      // parentUUID -> total bytes
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		name := entry.Name()
-		if !strings.HasPrefix(name, "session-") {
-			continue
-		}
-
-		// Strip "session-" prefix and extension to get the stem
-		rest := strings.TrimPrefix(name, "session-")
-
-		// Determine file type from extension
-		var stem string
-		var fileType string // "log", "events", "metadata", "timing", "input"
-		switch {
-		case strings.HasSuffix(rest, ".events.jsonl"):
-			stem = strings.TrimSuffix(rest, ".events.jsonl")
-			fileType = "events"
-		case strings.HasSuffix(rest, ".metadata.json"):
-			stem = strings.TrimSuffix(rest, ".metadata.json")
-			fileType = "metadata"
-		case strings.HasSuffix(rest, ".log.gz"):
-			stem = strings.TrimSuffix(rest, ".log.gz")
-			fileType = "log"
-		case strings.HasSuffix(rest, ".log"):
-			stem = strings.TrimSuffix(rest, ".log")
-			fileType = "log"
-		case strings.HasSuffix(rest, ".log.pipe"):
-			continue // skip FIFO pipes used during recording
-		case strings.HasSuffix(rest, ".timing"):
-			stem = strings.TrimSuffix(rest, ".timing")
-			fileType = "timing"
-		case strings.HasSuffix(rest, ".input"):
-			stem = strings.TrimSuffix(rest, ".input")
-			fileType = "input"
-		default:
-			continue
-		}
-
-		parentUUID, childUUID, ok := parseRecordingFilename(stem)
-		if !ok {
-			continue
-		}
-
-		// Accumulate file size for the parent recording
-		if fi, err := entry.Info(); err == nil {
-			rootSizes[parentUUID] += fi.Size()
-		}
-
-		if childUUID == "" {
-			// Root recording
-			if fileType == "log" {
-				rootLogs[parentUUID] = entry
-			}
-		} else {
-			// Child recording -- classify by file type
-			ci := children[parentUUID]
-			if ci == nil {
-				ci = &childInfo{}
-				children[parentUUID] = ci
-			}
-			if fileType == "events" {
-				if fi, err := entry.Info(); err == nil && fi.Size() > 0 {
-					ci.chatUUID = childUUID
-				}
-			} else if fileType == "log" {
-				ci.terminalUUIDs = append(ci.terminalUUIDs, childUUID)
-			}
-		}
-	}
-
-	// Second pass: build RecordingInfo entries for root recordings
-	var recordings []RecordingInfo
-	for ruuid, entry := range rootLogs {
-		// Skip active recordings
-		if activeRecordings[ruuid] {
-			continue
-		}
-
-		uuidShort := ruuid
-		if len(ruuid) >= 8 {
-			uuidShort = ruuid[:8]
-		}
-
-		info := RecordingInfo{
-			UUID:        ruuid,
-			UUIDShort:   uuidShort,
-			RestartUUID: uuid.New().String(),
-			SizeHuman:   formatSizeHuman(rootSizes[ruuid]),
-		}
-
-		// Attach child info
-		if ci := children[ruuid]; ci != nil {
-			if ci.chatUUID != "" {
-				info.HasChat = true
-				info.ChatUUID = ci.chatUUID
-			}
-			if len(ci.terminalUUIDs) > 0 {
-				info.HasTerminal = true
-				info.TerminalUUIDs = ci.terminalUUIDs
-			}
-		}
-
-		// Load metadata if exists
-		metadataPath := recordingsDir + "/session-" + ruuid + ".metadata.json"
-		metaParsed := false
-		if metaData, err := os.ReadFile(metadataPath); err == nil {
-			var meta RecordingMetadata
-			if uerr := json.Unmarshal(metaData, &meta); uerr == nil {
-				metaParsed = true
-				info.Name = meta.Name
-				info.Agent = meta.Agent
-				info.AgentBadgeClass = agentBadgeClass(meta.Agent)
-				info.KeptAt = meta.KeptAt
-				info.IsKept = meta.KeptAt != nil
-				if meta.EndedAt != nil {
-					info.EndedAt = *meta.EndedAt
-					info.EndedAgo = formatTimeAgo(*meta.EndedAt)
-				} else {
-					info.EndedAt = meta.StartedAt
-					info.EndedAgo = formatTimeAgo(meta.StartedAt)
-				}
-				// Build restart query from metadata
-				binary := meta.AgentBinary
-				if binary == "" {
-					// Old recordings: fall back to lowercased display name
-					binary = strings.ToLower(meta.Agent)
-				}
-				info.Query = SessionPageQuery{
-					Assistant:      binary,
-					SessionMode:    meta.SessionMode,
-					Name:           meta.Name,
-					BranchName:     meta.BranchName,
-					CheckoutBranch: meta.CheckoutBranch,
-					WorkDir:        meta.WorkDir,
-					ExtraArgs:      meta.ExtraArgs,
-				}
-				// Forkable via /api/fork only for chat-mode claude/codex
-				// recordings that have a chat event log to prepopulate.
-				info.CanResume = info.HasChat && meta.SessionMode == "chat" && (binary == "claude" || binary == "codex")
-				// Prefer the cached summary if it was extracted at end-of-session.
-				// This avoids decompressing the entire .log.gz on every homepage render.
-				info.SummaryLine = meta.SummaryLine
-			} else {
-				log.Printf("loadEndedRecordings: corrupt metadata for %s: %v (falling back to mtime)", ruuid[:8], uerr)
-			}
-		}
-		if !metaParsed {
-			// No metadata file, or it failed to parse. Fall back to log file
-			// mtime so the recording still appears on the homepage instead of
-			// silently vanishing. Default the agent to "claude" so the
-			// recording lands in a visible bucket -- homepage rendering only
-			// queries availableAssistants by binary name, and an empty agent
-			// would otherwise be bucketed under "" and never shown.
-			if fileInfo, err := entry.Info(); err == nil {
-				info.EndedAt = fileInfo.ModTime()
-				info.EndedAgo = formatTimeAgo(fileInfo.ModTime())
-			}
-			info.Agent = "Claude"
-			info.AgentBadgeClass = agentBadgeClass("Claude")
-		}
-		if info.AgentBadgeClass == "" {
-			info.AgentBadgeClass = agentBadgeClass(info.Agent)
-		}
-
-		// Calculate ExpiresIn for non-kept recordings based on EndedAt
-		// (info.EndedAt is already set from metadata or fallback above)
-		if !info.IsKept {
-			remaining := recentRecordingMaxAge - time.Since(info.EndedAt)
-			if remaining > 0 {
-				days := int(remaining.Hours()) / 24
-				hours := int(remaining.Hours()) % 24
-				if days > 0 {
-					info.ExpiresIn = fmt.Sprintf("%dd%dh", days, hours)
-				} else if hours > 0 {
-					mins := int(remaining.Minutes()) % 60
-					info.ExpiresIn = fmt.Sprintf("%dh%dm", hours, mins)
-				} else {
-					mins := int(remaining.Minutes())
-					if mins < 1 {
-						info.ExpiresIn = "<1m"
-					} else {
-						info.ExpiresIn = fmt.Sprintf("%dm", mins)
-					}
-				}
-			} else {
-				info.ExpiresIn = "soon"
-			}
-		}
-
-		// Extract summary from chat events (if available), fall back to agent terminal log
-		if info.SummaryLine == "" && info.HasChat {
-			summaryLine, _ := getSessionSummaryFromChat(ruuid)
-			info.SummaryLine = summaryLine
-		}
-		if info.SummaryLine == "" {
-			// Only attempt the log-tail fallback for plain .log files; .log.gz
-			// would require full-file decompression (gzip is not seekable) and
-			// would block the homepage for minutes on a busy host. The compression
-			// worker now caches summary_line into metadata.json before gzipping,
-			// so the .log.gz path should normally never be hit here.
-			logPath := resolveLogPath("session-" + ruuid)
-			if logPath != "" && !strings.HasSuffix(logPath, ".log.gz") {
-				info.SummaryLine = getSessionSummaryFromLog(ruuid)
-			}
-		}
-
-		recordings = append(recordings, info)
-	}
-
-	// Sort by most recent first (newest EndedAt timestamp first)
-	sort.Slice(recordings, func(i, j int) bool {
-		return recordings[i].EndedAt.After(recordings[j].EndedAt)
-	})
-
+	recordings, _, _ := endedRecordingsPage(1, 0)
 	return recordings
 }
 
@@ -7202,7 +7972,7 @@ func agentNameToBinary(name string) string {
 		}
 	}
 	// Check availableAssistants for custom assistants
-	for _, cfg := range availableAssistants {
+	for _, cfg := range installedAssistants() {
 		if cfg.Name == name {
 			return cfg.Binary
 		}
@@ -7592,9 +8362,9 @@ func extractSummaryFromBytes(buf []byte) string {
 // findChatEventsFile finds the .events.jsonl file for a parent recording UUID.
 // Returns the full path, or empty string if not found.
 func findChatEventsFile(parentUUID string) string {
-	pattern := recordingsDir + "/session-" + parentUUID + "-*.events.jsonl"
+	pattern := recordingDir(parentUUID) + "/session-" + parentUUID + "-*.events.jsonl"
 	matches, err := filepath.Glob(pattern)
-	if err != nil || len(matches) == 0 {
+	if err != nil || len(matches) == 0 || !policyRecording.Allowed(matches[0]) {
 		return ""
 	}
 	return matches[0]
@@ -7635,7 +8405,7 @@ func handleChatPlaybackPage(w http.ResponseWriter, r *http.Request, parentUUID s
 
 	// Load metadata for title
 	title := "Chat Playback"
-	metaPattern := recordingsDir + "/session-" + parentUUID + ".metadata.json"
+	metaPattern := recordingDir(parentUUID) + "/session-" + parentUUID + ".metadata.json"
 	if metaData, err := os.ReadFile(metaPattern); err == nil {
 		var meta RecordingMetadata
 		if json.Unmarshal(metaData, &meta) == nil && meta.Name != "" {
@@ -7703,9 +8473,30 @@ func handleRecordingPage(w http.ResponseWriter, r *http.Request, recordingUUID s
 		return
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	opts := recordingPlaybackOptions(r, recordingUUID, logPath)
+	opts.DataURL = recordingUUID + "/session.log"
+
+	html, err := recordtui.RenderStreamingHTML(opts)
+	if err != nil {
+		http.Error(w, "Failed to render playback", http.StatusInternalServerError)
+		return
+	}
+	// Files the session changed (recording_changes.go).
+	if meta, err := readRecordingMetadata(recordingUUID); err == nil {
+		html = strings.Replace(html, "</body>", recordingChangesPanel(meta.Changes)+"\n</body>", 1)
+	}
+	w.Write([]byte(html))
+}
+
+
+// recordingPlaybackOptions builds the streaming player options for a
+// recording (title, size, TOC). The caller sets DataURL.
+func recordingPlaybackOptions(r *http.Request, recordingUUID, logPath string) recordtui.StreamingOptions {
 	// Load metadata if exists
 	var metadata *RecordingMetadata
-	metadataPath := recordingsDir + "/session-" + recordingUUID + ".metadata.json"
+	metadataPath := recordingDir(recordingUUID) + "/session-" + recordingUUID + ".metadata.json"
 	if metaData, err := os.ReadFile(metadataPath); err == nil {
 		var meta RecordingMetadata
 		if json.Unmarshal(metaData, &meta) == nil {
@@ -7723,8 +8514,6 @@ func handleRecordingPage(w http.ResponseWriter, r *http.Request, recordingUUID s
 		name = metadata.Name
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
 	// Streaming approach (embedded mode removed to avoid reading entire log into memory)
 	var cols uint16
 	if metadata != nil && metadata.PlaybackCols > 0 {
@@ -7754,8 +8543,7 @@ func handleRecordingPage(w http.ResponseWriter, r *http.Request, recordingUUID s
 	}
 
 	opts := recordtui.StreamingOptions{
-		Title:   name,
-		DataURL: recordingUUID + "/session.log",
+		Title: name,
 		FooterLink: recordtui.FooterLink{
 			Text: "swe-swe",
 			URL:  "https://github.com/choonkeat/swe-swe",
@@ -7764,30 +8552,18 @@ func handleRecordingPage(w http.ResponseWriter, r *http.Request, recordingUUID s
 		MaxRows: maxRows,
 	}
 
-	// Build TOC from timing + input + session log files if available.
-	// Uses streaming BuildTOC to avoid reading the entire session
-	// log into memory (recordings can be hundreds of MB).
-	timingPath := recordingsDir + "/session-" + recordingUUID + ".timing"
-	inputPath := recordingsDir + "/session-" + recordingUUID + ".input"
-	timingFile, err := os.Open(timingPath)
-	if err == nil {
-		defer timingFile.Close()
-		inputBytes, err := os.ReadFile(inputPath)
-		if err == nil {
-			sessionReader, err := openLogReader(logPath)
-			if err == nil {
-				defer sessionReader.Close()
-				opts.TOC = recordtui.BuildTOC(timingFile, inputBytes, sessionReader)
-			}
-		}
+	// Build TOC chapters (prompts, idle gaps) from timing + input + session
+	// log files if available. Streams the log to avoid reading the entire
+	// session into memory (recordings can be hundreds of MB).
+	var startedAt time.Time
+	if metadata != nil {
+		startedAt = metadata.StartedAt
 	}
-
-	html, err := recordtui.RenderStreamingHTML(opts)
-	if err != nil {
-		http.Error(w, "Failed to render playback", http.StatusInternalServerError)
-		return
+	opts.TOC = chapterTOC(recordingUUID, logPath, startedAt)
+	if metadata != nil {
+		opts.TOC = mergeTOC(opts.TOC, annotationTOC(recordingUUID, logPath, metadata.Annotations))
 	}
-	w.Write([]byte(html))
+	return opts
 }
 
 // handleRecordingSessionLog serves raw session.log for streaming playback.
@@ -7950,8 +8726,8 @@ func checkMemoryForNewSession() error {
 		return nil // no active sessions, allow
 	}
 	if maxRSS > avail {
-		return fmt.Errorf("insufficient memory: largest session uses %s but only %s available",
-			formatBytes(maxRSS), formatBytes(avail))
+		return fmt.Errorf("%w: largest session uses %s but only %s available",
+			errInsufficientMemory, formatBytes(maxRSS), formatBytes(avail))
 	}
 	return nil
 }
@@ -8254,19 +9030,20 @@ func endSessionByUUID(sessionUUID string) error {
 		delete(sessions, childUUID)
 	}
 	sessionsMu.Unlock()
+	releaseSessionPorts(sessionUUID)
 
 	// Enqueue recording logs for prompt compression.
 	// The parent session's log is session-{recUUID}.log; child logs are
 	// session-{parentRecUUID}-{childRecUUID}.log.
 	if session.RecordingUUID != "" {
-		parentLogPath := fmt.Sprintf("%s/session-%s.log", recordingsDir, session.RecordingUUID)
+		parentLogPath := recordingDir(session.RecordingUUID) + "/session-" + session.RecordingUUID + ".log"
 		select {
 		case compressCh <- parentLogPath:
 		default:
 		}
 		for _, child := range childSessions {
 			if child.RecordingUUID != "" {
-				childLogPath := fmt.Sprintf("%s/session-%s-%s.log", recordingsDir, session.RecordingUUID, child.RecordingUUID)
+				childLogPath := recordingDir(session.RecordingUUID) + "/session-" + session.RecordingUUID + "-" + child.RecordingUUID + ".log"
 				select {
 				case compressCh <- childLogPath:
 				default:
@@ -8319,7 +9096,7 @@ func handleNewSessionAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var validAssistant bool
-	for _, a := range availableAssistants {
+	for _, a := range installedAssistants() {
 		if a.Binary == assistant {
 			validAssistant = true
 			break
@@ -8330,6 +9107,31 @@ func handleNewSessionAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setupMode, err := normalizeSetupMode(r.FormValue("setup"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Refuse now rather than on the session page's WebSocket; the dialog
+	// reads capacity from /api/sessions/live and normally never gets here.
+	if err := checkSessionLimit(assistant); err != nil {
+		writeSessionLimitError(w, err.(*sessionLimitError))
+		return
+	}
+	if _, err := resolveSessionName(strings.TrimSpace(r.FormValue("name")), ""); err != nil {
+		writeSessionNameError(w, err.(*sessionNameError))
+		return
+	}
+	// An agent that is not logged in opens on its login screen: refuse
+	// before the worktree is made, unless the dialog asked and the user
+	// chose to start anyway.
+	if r.FormValue("auth_ack") != "1" {
+		if cfg, auth, _ := recheckAssistantAuth(assistant); auth.State == authLoggedOut {
+			writeNotLoggedInError(w, cfg, auth)
+			return
+		}
+	}
+
 	newUUID := uuid.New().String()
 	// Stage the full creation wiring from the dialog. The WS handler that
 	// materializes the session replaces its URL-derived params with this staged
@@ -8354,6 +9156,7 @@ func handleNewSessionAPI(w http.ResponseWriter, r *http.Request) {
 		// brand-new session actually gets the vars (a set_env over the WS would
 		// arrive after spawn -- too late). Memory-only, never persisted.
 		EnvRaw: r.FormValue("env"),
+		Setup:  setupMode,
 	}, "new", "")
 
 	// Echo the dialog's params onto the redirect so the WS handler resolves the
@@ -8604,6 +9407,12 @@ func handleForkExecute(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Check session limits before forkconvo writes a new rollout file.
+	if err := checkSessionLimit(src.Assistant); err != nil {
+		renderForkError(w, http.StatusTooManyRequests, sourceUUID, err.Error(), false, false)
+		return
+	}
+
 	forkRes, err := forkconvo.Fork(forkOpts)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("fork %s session: %s", src.Assistant, err.Error()), http.StatusInternalServerError)
@@ -8871,7 +9680,7 @@ func handleServerShutdownAPI(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"shutting_down"}`))
 	select {
-	case serverShutdownRequests <- fmt.Sprintf("shutdown requested via web UI from %s", r.RemoteAddr):
+	case serverShutdownRequests <- fmt.Sprintf("shutdown requested via web UI from %s", clientLabel(r)):
 	default: // a shutdown is already in flight
 	}
 }
@@ -8900,6 +9709,10 @@ func handleSessionEndAPI(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
+	if !requestIdentity(r).mayEnd(session) {
+		http.Error(w, "Forbidden: another user's session", http.StatusForbidden)
+		return
+	}
 	publicPort := session.PublicPort
 	if publicPort != 0 {
 		confirmed := r.Header.Get("X-Confirm-Public-Port")
@@ -9093,7 +9906,8 @@ func requestChatLogCommitThenEnd(sess *Session) error {
 // handleLiveSessionsAPI serves GET /api/sessions/live: the uuids the homepage
 // still has cards for, each flagged if it is being torn down. The homepage is
 // server-rendered with no other polling, so this is what lets an ending card
-// show a terminating state and then vanish on its own.
+// show a terminating state and then vanish on its own. It also reports how
+// many more sessions may start (session_limits.go), overall and per assistant.
 func handleLiveSessionsAPI(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
@@ -9106,8 +9920,20 @@ func handleLiveSessionsAPI(w http.ResponseWriter, r *http.Request) {
 		// EndRequested: the agent is committing the chat log and will end
 		// itself afterwards. The card stays joinable, unlike Ending.
 		EndRequested bool `json:"endRequested"`
+		// Hibernated: the agent was stopped while idle and starts again
+		// when the session is opened (session_hibernate.go).
+		Hibernated bool `json:"hibernated,omitempty"`
+		// Thumb is the hash of the card's preview image; it changes when
+		// the screen does.
+		Thumb string `json:"thumb,omitempty"`
+		// AgentState is busy, idle or waiting_approval (agent_state.go).
+		AgentState string `json:"agentState,omitempty"`
+		// Health is the session's server-side checks (session_health.go).
+		Health sessionHealth `json:"health"`
 	}
 	live := []liveSession{}
+	var liveSessions []*Session
+	wantThumbnails()
 
 	sessionsMu.RLock()
 	for uuid, sess := range sessions {
@@ -9116,15 +9942,22 @@ func handleLiveSessionsAPI(w http.ResponseWriter, r *http.Request) {
 		if sess.ParentUUID != "" {
 			continue
 		}
-		if sess.Cmd != nil && sess.Cmd.ProcessState != nil {
+		if sess.exited() {
 			continue
 		}
-		live = append(live, liveSession{UUID: uuid, Ending: sess.isEnding(), EndRequested: sess.isEndRequested()})
+		live = append(live, liveSession{UUID: uuid, Ending: sess.isEnding(), EndRequested: sess.isEndRequested(), Hibernated: sess.hibernated(), Thumb: sessionThumbHash(uuid), AgentState: sess.AgentState()})
+		liveSessions = append(liveSessions, sess)
 	}
+	capacity := sessionCapacityReport()
 	sessionsMu.RUnlock()
+	// Health reads /proc and the disk, so it runs after the lock is released.
+	now := time.Now()
+	for i, sess := range liveSessions {
+		live[i].Health = sess.checkHealth(now)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{"sessions": live})
+	json.NewEncoder(w).Encode(map[string]any{"sessions": live, "capacity": capacity, "assistants": assistantStatuses()})
 }
 
 // handleBrowserStartAPI handles POST /api/session/{uuid}/browser/start
@@ -9402,8 +10235,9 @@ type mcpSessionInfo struct {
 	PreviewPort   int    `json:"previewPort"`
 	PublicPort    int    `json:"publicPort"`
 	RecordingUUID string `json:"recordingUUID,omitempty"`
-	Busy          *bool  `json:"busy,omitempty"`
-	Ending        bool   `json:"ending,omitempty"`
+	Busy          *bool          `json:"busy,omitempty"`
+	Ending        bool           `json:"ending,omitempty"`
+	Health        *sessionHealth `json:"health,omitempty"`
 }
 
 // listSessionsSnapshot builds the list_sessions payload. Never returns nil, so
@@ -9411,11 +10245,13 @@ type mcpSessionInfo struct {
 func listSessionsSnapshot() []mcpSessionInfo {
 	result := []mcpSessionInfo{}
 	var agentSessionIDs []string
+	var live []*Session
 	sessionsMu.RLock()
 	for _, sess := range sessions {
-		if sess.Cmd.ProcessState != nil {
+		if sess.exited() {
 			continue
 		}
+		live = append(live, sess)
 		sess.mu.RLock()
 		result = append(result, mcpSessionInfo{
 			UUID:          sess.UUID,
@@ -9439,8 +10275,11 @@ func listSessionsSnapshot() []mcpSessionInfo {
 	sessionsMu.RUnlock()
 	// Busy classification reads each agent's session log, so it runs
 	// after both locks are released.
+	now := time.Now()
 	for i := range result {
 		result[i].Busy = sessionTailBusy(result[i].Assistant, result[i].WorkDir, agentSessionIDs[i])
+		health := live[i].checkHealth(now)
+		result[i].Health = &health
 	}
 	return result
 }
@@ -9475,7 +10314,7 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 	// list_sessions
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "list_sessions",
-		Description: "List all active agent sessions. busy=true means the agent is mid-work on an unresolved tool call (ending or forking it would truncate in-flight work); busy absent means unknown (agent has no tail classifier or no session id captured). ending=true means the session is being torn down: it can no longer be joined and will vanish from this list once cleanup finishes. recordingUUID feeds /api/fork/<recordingUUID>, which keeps working after the session ends -- post those as resume links before a planned shutdown.",
+		Description: "List all active agent sessions. busy=true means the agent is mid-work on an unresolved tool call (ending or forking it would truncate in-flight work); busy absent means unknown (agent has no tail classifier or no session id captured). ending=true means the session is being torn down: it can no longer be joined and will vanish from this list once cleanup finishes. recordingUUID feeds /api/fork/<recordingUUID>, which keeps working after the session ends -- post those as resume links before a planned shutdown. health holds server-side checks: process (running, zombie or gone), lastOutputAge in seconds, cpuPercent of the process tree, pendingApproval, diskFreePercent of the workDir, and problems, a list of what needs attention.",
 	}, func(ctx context.Context, req *mcp.CallToolRequest, _ struct{}) (*mcp.CallToolResult, any, error) {
 		data, _ := json.Marshal(listSessionsSnapshot())
 		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(data)}}}, nil, nil
@@ -9488,6 +10327,7 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 		Branch    string `json:"branch,omitempty" jsonschema:"Git branch to create worktree for"`
 		RepoPath  string `json:"repo_path" jsonschema:"required,Repository path for worktree creation"`
 		ExtraArgs string `json:"extra_args,omitempty" jsonschema:"Extra CLI flags appended to the agent command, e.g. --channels server:agent-chat"`
+		Setup     string `json:"setup,omitempty" jsonschema:"Repo setup task (.swe-swe/hooks/setup): auto (default, run unless already done), skip, or force"`
 	}
 	mcp.AddTool(server, &mcp.Tool{
 		Name:        "create_session",
@@ -9499,6 +10339,9 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 		if args.RepoPath == "" {
 			return nil, nil, fmt.Errorf("repo_path is required")
 		}
+		if _, err := normalizeSetupMode(args.Setup); err != nil {
+			return nil, nil, err
+		}
 		// The calling session is identified by its per-session MCP auth key
 		// (injected by mcpAuthMiddleware). Hard-fail when absent: without a
 		// trusted caller identity we cannot safely inherit credentials, and
@@ -9507,6 +10350,9 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 		if parentUUID == "" {
 			return nil, nil, fmt.Errorf("unauthenticated: missing calling session identity")
 		}
+		// An agent that is not logged in is started all the same, as the
+		// caller has no one to ask; the reply says so (assistant_auth.go).
+		authCfg, auth, _ := recheckAssistantAuth(args.Assistant)
 		sessionUUID := uuid.New().String()
 		// MCP create_session is an explicit, authenticated creation action: it
 		// mints the UUID and creates eagerly (allowCreate=true). The browser
@@ -9519,6 +10365,7 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 			RepoPath:         args.RepoPath,
 			SessionMode:      "chat",
 			ExtraArgs:        args.ExtraArgs,
+			Setup:            args.Setup,
 			InheritCredsFrom: parentUUID,
 		}, true)
 		if err != nil {
@@ -9531,6 +10378,9 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 			"assistant": sess.Assistant,
 			"workDir":   sess.WorkDir,
 		}
+		if auth.State == authLoggedOut {
+			info["warning"] = notLoggedInMessage(authCfg, auth)
+		}
 		data, _ := json.Marshal(info)
 		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(data)}}}, nil, nil
 	})
@@ -9622,7 +10472,7 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 		var text string
 		if args.Mode == "scrollback" {
 			sess.vtMu.Lock()
-			raw := sess.readRing()
+			raw := sess.ring.Bytes()
 			sess.vtMu.Unlock()
 			// Strip ANSI escape sequences
 			text = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]|\x1b\].*?\x07|\x1b\[.*?[mGKHJP]`).ReplaceAllString(string(raw), "")
@@ -9676,12 +10526,14 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 			if err := sess.WriteInput([]byte(text)); err != nil {
 				return nil, nil, fmt.Errorf("write failed: %w", err)
 			}
+			sess.Checkpoints.noteInput([]byte(text))
 		}
 		if hasTrailingNewline {
 			time.Sleep(300 * time.Millisecond)
 			if err := sess.WriteInput([]byte{'\r'}); err != nil {
 				return nil, nil, fmt.Errorf("write failed: %w", err)
 			}
+			sess.Checkpoints.noteInput([]byte{'\r'})
 		}
 		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: "input sent"}}}, nil, nil
 	})
@@ -9729,18 +10581,11 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 		Name:        "list_recordings",
 		Description: "List ended session recordings",
 	}, func(ctx context.Context, req *mcp.CallToolRequest, args listRecordingsArgs) (*mcp.CallToolResult, any, error) {
-		recordings := loadEndedRecordings()
 		limit := args.Limit
 		if limit <= 0 {
 			limit = 20
 		}
-		if limit > len(recordings) {
-			limit = len(recordings)
-		}
-		// Sort newest first
-		sort.Slice(recordings, func(i, j int) bool {
-			return recordings[i].EndedAt.After(recordings[j].EndedAt)
-		})
+		recordings, _, _ := endedRecordingsPage(1, limit)
 		type recInfo struct {
 			UUID    string `json:"uuid"`
 			Name    string `json:"name,omitempty"`
@@ -9749,7 +10594,7 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 			HasChat bool   `json:"hasChat,omitempty"`
 		}
 		var result []recInfo
-		for _, r := range recordings[:limit] {
+		for _, r := range recordings {
 			result = append(result, recInfo{
 				UUID:    r.UUID,
 				Name:    r.Name,
@@ -9786,8 +10631,8 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 		case "workspace":
 			workDir := workspaceDir
 			if args.Path != "" {
-				cleaned := filepath.Clean(args.Path)
-				if !strings.HasPrefix(cleaned, reposDir+"/") {
+				cleaned, err := policyClonedRepo.Resolve(args.Path)
+				if err != nil {
 					return nil, nil, fmt.Errorf("invalid repository path")
 				}
 				workDir = cleaned
@@ -9949,6 +10794,11 @@ func registerOrchestrationTools(server *mcp.Server) (err error) {
 		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(out)}}}, nil, nil
 	})
 
+	// send_to_session, subscribe -- the cross-session message bus
+	registerSessionBusTools(server)
+	registerPreviewDebugTools(server)
+
+
 	return nil
 }
 
@@ -10063,6 +10913,12 @@ func handleRecordingAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// GET /api/recording/{uuid}
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		handleRecordingDetails(w, r, recordingUUID)
+		return
+	}
+
 	// GET /api/recording/{uuid}/download
 	if len(parts) == 2 && parts[1] == "download" && r.Method == http.MethodGet {
 		handleDownloadRecording(w, r, recordingUUID)
@@ -10081,12 +10937,42 @@ func handleRecordingAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// POST /api/recording/{uuid}/share
+	if len(parts) == 2 && parts[1] == "share" && r.Method == http.MethodPost {
+		handleRecordingShareCreate(w, r, recordingUUID)
+		return
+	}
+
+	// GET /api/recording/{uuid}/verify
+	if len(parts) == 2 && parts[1] == "verify" && r.Method == http.MethodGet {
+		handleRecordingVerify(w, r, recordingUUID)
+		return
+	}
+
+	// POST /api/recording/{uuid}/replay
+	if len(parts) == 2 && parts[1] == "replay" && r.Method == http.MethodPost {
+		handleRecordingReplay(w, r, recordingUUID)
+		return
+	}
+
+	// GET/POST /api/recording/{uuid}/annotations, DELETE /api/recording/{uuid}/annotations/{id}
+	if len(parts) >= 2 && parts[1] == "annotations" {
+		handleRecordingAnnotationsAPI(w, r, recordingUUID, strings.Join(parts[2:], "/"))
+		return
+	}
+
+	// GET /api/recording/{uuid}/shares, DELETE /api/recording/{uuid}/shares/{id}
+	if len(parts) >= 2 && parts[1] == "shares" {
+		handleRecordingSharesAPI(w, r, recordingUUID, strings.Join(parts[2:], "/"))
+		return
+	}
+
 	http.Error(w, "Not Found", http.StatusNotFound)
 }
 
 // handleListRecordings returns a list of all recordings
 func handleListRecordings(w http.ResponseWriter, r *http.Request) {
-	entries, err := os.ReadDir(recordingsDir)
+	entries, err := readRecordingEntries()
 	if err != nil {
 		if os.IsNotExist(err) {
 			// No recordings directory yet
@@ -10104,7 +10990,7 @@ func handleListRecordings(w http.ResponseWriter, r *http.Request) {
 	sessionsMu.RLock()
 	for _, sess := range sessions {
 		// Only consider recording "active" if the process is still running
-		if sess.RecordingUUID != "" && sess.Cmd != nil && sess.Cmd.ProcessState == nil {
+		if sess.RecordingUUID != "" && sess.Cmd != nil && !sess.exited() {
 			activeRecordings[sess.RecordingUUID] = true
 		}
 	}
@@ -10212,13 +11098,13 @@ func handleListRecordings(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Check if timing file exists
-		timingPath := recordingsDir + "/session-" + recUUID + ".timing"
+		timingPath := recordingDir(recUUID) + "/session-" + recUUID + ".timing"
 		if _, err := os.Stat(timingPath); err == nil {
 			item.HasTiming = true
 		}
 
 		// Load metadata if exists
-		metadataPath := recordingsDir + "/session-" + recUUID + ".metadata.json"
+		metadataPath := recordingDir(recUUID) + "/session-" + recUUID + ".metadata.json"
 		if metaData, err := os.ReadFile(metadataPath); err == nil {
 			var meta RecordingMetadata
 			if json.Unmarshal(metaData, &meta) == nil {
@@ -10252,20 +11138,14 @@ func handleListRecordings(w http.ResponseWriter, r *http.Request) {
 // handleDeleteRecording deletes a recording and its associated files (including children)
 func handleDeleteRecording(w http.ResponseWriter, r *http.Request, uuid string) {
 	// Check if recording is active (only block if process is still running)
-	sessionsMu.RLock()
-	for _, sess := range sessions {
-		// Only consider recording "active" if the process is still running
-		if sess.RecordingUUID == uuid && sess.Cmd != nil && sess.Cmd.ProcessState == nil {
-			sessionsMu.RUnlock()
-			http.Error(w, "Cannot delete active recording", http.StatusConflict)
-			return
-		}
+	if recordingActive(uuid) {
+		http.Error(w, "Cannot delete active recording", http.StatusConflict)
+		return
 	}
-	sessionsMu.RUnlock()
 
 	// Check if recording exists before deleting
 	logPath := resolveLogPath("session-" + uuid)
-	metaPath := recordingsDir + "/session-" + uuid + ".metadata.json"
+	metaPath := recordingDir(uuid) + "/session-" + uuid + ".metadata.json"
 	if logPath == "" {
 		if _, err2 := os.Stat(metaPath); err2 != nil {
 			http.Error(w, "Recording not found", http.StatusNotFound)
@@ -10273,6 +11153,15 @@ func handleDeleteRecording(w http.ResponseWriter, r *http.Request, uuid string)
 		}
 	}
 
+	// Another user's kept recording needs recordings-any (auth_roles.go).
+	if data, err := os.ReadFile(metaPath); err == nil {
+		var meta RecordingMetadata
+		if json.Unmarshal(data, &meta) == nil && !requestIdentity(r).mayDeleteRecording(&meta) {
+			http.Error(w, "Forbidden: another user's kept recording", http.StatusForbidden)
+			return
+		}
+	}
+
 	deleteRecordingFiles(uuid)
 	log.Printf("Deleted recording group: %s", uuid)
 	w.WriteHeader(http.StatusNoContent)
@@ -10280,7 +11169,7 @@ func handleDeleteRecording(w http.ResponseWriter, r *http.Request, uuid string)
 
 // handleKeepRecording marks a recording as "kept" so it won't be auto-deleted
 func handleKeepRecording(w http.ResponseWriter, r *http.Request, uuid string) {
-	metadataPath := recordingsDir + "/session-" + uuid + ".metadata.json"
+	metadataPath := recordingDir(uuid) + "/session-" + uuid + ".metadata.json"
 
 	// Read existing metadata
 	metaData, err := os.ReadFile(metadataPath)
@@ -10315,6 +11204,9 @@ func handleKeepRecording(w http.ResponseWriter, r *http.Request, uuid string) {
 	// Set KeptAt to now
 	now := time.Now()
 	meta.KeptAt = &now
+	if len(authUsers) > 0 {
+		meta.KeptBy = requestIdentity(r).User
+	}
 
 	// Write back metadata
 	updatedMeta, err := json.MarshalIndent(meta, "", "  ")
@@ -10331,6 +11223,7 @@ func handleKeepRecording(w http.ResponseWriter, r *http.Request, uuid string) {
 	}
 
 	log.Printf("Recording %s marked as kept", uuid)
+	publishAdminEvent(adminEvent{Type: "recording_kept", Recording: uuid})
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -10364,7 +11257,7 @@ func handleRenameRecording(w http.ResponseWriter, r *http.Request, uuid string)
 		}
 	}
 
-	metadataPath := recordingsDir + "/session-" + uuid + ".metadata.json"
+	metadataPath := recordingDir(uuid) + "/session-" + uuid + ".metadata.json"
 
 	// Read existing metadata
 	metaData, err := os.ReadFile(metadataPath)
@@ -10424,10 +11317,11 @@ func handleDownloadRecording(w http.ResponseWriter, r *http.Request, uuid string
 		path string
 		name string
 	}{
-		{recordingsDir + "/session-" + uuid + ".log", "session.log"},
-		{recordingsDir + "/session-" + uuid + ".log.gz", "session.log.gz"},
-		{recordingsDir + "/session-" + uuid + ".timing", "session.timing"},
-		{recordingsDir + "/session-" + uuid + ".metadata.json", "session.metadata.json"},
+		{recordingDir(uuid) + "/session-" + uuid + ".log", "session.log"},
+		{recordingDir(uuid) + "/session-" + uuid + ".log.gz", "session.log.gz"},
+		{recordingDir(uuid) + "/session-" + uuid + ".timing", "session.timing"},
+		{recordingDir(uuid) + "/session-" + uuid + ".metadata.json", "session.metadata.json"},
+		{recordingDir(uuid) + "/session-" + uuid + ".hooks.txt", "session.hooks.txt"},
 	}
 	for _, f := range parentFiles {
 		data, err := os.ReadFile(f.path)
@@ -10439,7 +11333,7 @@ func handleDownloadRecording(w http.ResponseWriter, r *http.Request, uuid string
 	}
 
 	// Add child files (session-{uuid}-*)
-	childMatches, _ := filepath.Glob(recordingsDir + "/session-" + uuid + "-*")
+	childMatches, _ := filepath.Glob(recordingDir(uuid) + "/session-" + uuid + "-*")
 	for _, path := range childMatches {
 		data, err := os.ReadFile(path)
 		if err != nil {
@@ -0,0 +1,71 @@
+// proxy_mode.go -- single-port (path-only) proxy mode.
+//
+// By default every session also gets per-port proxy listeners (preview,
+// agent chat, VNC, files on proxyPortOffset + port) that Traefik or the tunnel
+// publishes, and the browser prefers them when it can reach them. Publishing
+// four extra ports per session is a lot to ask of some deployments, so
+// SWE_PROXY_MODE=path turns the listeners off: the session page then reaches
+// everything through the main server port.
+//
+//   - /preview/{uuid}/... is the session's app. The path-based agentproxy
+//     instance rewrites the upstream Host to localhost:{previewPort}, relays
+//     WebSockets (HMR, the debug channel) and roots the injected debug script
+//     and shell URLs at the prefix. The older /proxy/{uuid}/preview/ route
+//     stays for the agent's MCP bridge and the open shims.
+//   - /proxy/{uuid}/agentchat/... is Agent Chat, as before.
+//
+// The Files tab and Agent View have no path-based route yet, so they are
+// unavailable in path mode; the status message omits their proxy ports and
+// the session page hides them.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	proxyModePorts = "ports"
+	proxyModePath  = "path"
+)
+
+// proxyMode is SWE_PROXY_MODE: proxyModePorts (default) or proxyModePath.
+var proxyMode = proxyModePorts
+
+// loadProxyMode applies SWE_PROXY_MODE.
+func loadProxyMode() error {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("SWE_PROXY_MODE"))); v {
+	case "", proxyModePorts:
+		proxyMode = proxyModePorts
+	case proxyModePath:
+		proxyMode = proxyModePath
+		log.Printf("Proxy mode from SWE_PROXY_MODE: path (no per-session proxy ports)")
+	default:
+		return fmt.Errorf("SWE_PROXY_MODE=%q: want ports or path", v)
+	}
+	return nil
+}
+
+// pathProxyMode reports whether per-session proxy ports are disabled.
+func pathProxyMode() bool { return proxyMode == proxyModePath }
+
+// previewPathBase is the browser-facing path-based preview prefix.
+func previewPathBase(sessionUUID string) string { return "/preview/" + sessionUUID }
+
+// proxyRouteSessionUUID returns the session a handleProxyRoute request is
+// for: /proxy/{uuid}/{preview|agentchat}/... or /preview/{uuid}[/...].
+func proxyRouteSessionUUID(path string) (string, bool) {
+	if rest, ok := strings.CutPrefix(path, "/proxy/"); ok {
+		if uuid, _, found := strings.Cut(rest, "/"); found && uuid != "" {
+			return uuid, true
+		}
+		return "", false
+	}
+	if rest, ok := strings.CutPrefix(path, "/preview/"); ok {
+		uuid := firstPathSegment(rest)
+		return uuid, uuid != ""
+	}
+	return "", false
+}
@@ -0,0 +1,340 @@
+// session_tests.go -- run a repo's tests outside the agent PTY and surface
+// the result, so the status bar can show a green/red test badge.
+//
+// A repo declares its test command as SWE_TEST_CMD in .swe-swe/env (or
+// server-wide as an env var, or tests.command in the config file); it is read
+// from the session's environment like SWE_SESSION_BACKEND:
+//
+//	SWE_TEST_CMD=go test ./...
+//
+//	POST /api/session/{uuid}/tests/run  start a run -> 202 with the "running" result
+//	GET  /api/session/{uuid}/tests      the latest result (null before the first run)
+//
+// The command runs with `sh -c` in the session's working directory, with the
+// session's environment plus SWE_HOOK=tests, and is killed with everything it
+// started after SWE_TEST_TIMEOUT (default 15m). One run at a time per
+// session; a second POST while one runs gets 409. Output from go test, jest
+// and pytest is parsed into pass/fail/skip counts; for anything else only the
+// exit code decides. Every change of the result (started, finished) is
+// broadcast to the session's clients as {"type": "tests", ...} and rides
+// along in the status message as "tests", so a client that connects later
+// sees the latest run.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// testOutputTail is how much of the end of a run's output is kept with the
+// result (and parsed for summary lines).
+const testOutputTail = 64 << 10
+
+// testTimeout bounds a test run. Set from SWE_TEST_TIMEOUT by loadTestTimeout.
+var testTimeout = 15 * time.Minute
+
+// loadTestTimeout applies SWE_TEST_TIMEOUT (a duration or seconds). Empty
+// keeps the default.
+func loadTestTimeout() error {
+	v := strings.TrimSpace(os.Getenv("SWE_TEST_TIMEOUT"))
+	if v == "" {
+		return nil
+	}
+	d, err := parseTimeoutSetting("SWE_TEST_TIMEOUT", v)
+	if err != nil {
+		return err
+	}
+	testTimeout = d
+	log.Printf("Test run timeout from SWE_TEST_TIMEOUT: %s", d)
+	return nil
+}
+
+// testResult is a session's latest test run.
+type testResult struct {
+	Status     string     `json:"status"`           // "running", "passed", "failed", "error"
+	Format     string     `json:"format,omitempty"` // "go", "jest", "pytest" when recognized
+	Passed     int        `json:"passed"`
+	Failed     int        `json:"failed"`
+	Skipped    int        `json:"skipped"`
+	ExitCode   int        `json:"exitCode"`
+	TimedOut   bool       `json:"timedOut,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Command    string     `json:"command"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	DurationMs int64      `json:"durationMs,omitempty"`
+	Output     string     `json:"output,omitempty"` // last testOutputTail bytes
+}
+
+// testRunState is a session's test-run state. The zero value has no result.
+type testRunState struct {
+	mu     sync.Mutex
+	latest *testResult
+}
+
+// testCounts is what parseTestOutput found.
+type testCounts struct {
+	Format                  string
+	Passed, Failed, Skipped int
+}
+
+var (
+	goTestLineRE  = regexp.MustCompile(`(?m)^\s*--- (PASS|FAIL|SKIP): `)
+	goPkgLineRE   = regexp.MustCompile(`(?m)^(ok|FAIL|\?)\s+\S+\s`)
+	jestSummaryRE = regexp.MustCompile(`(?m)^Tests:\s+(.+?)\s*$`)
+	pytestLineRE  = regexp.MustCompile(`(?m)^=+ (.*\d+ (?:passed|failed|skipped|errors?).*?) in [\d.]+s.* =+\s*$`)
+	countWordRE   = regexp.MustCompile(`(\d+) (passed|failed|skipped|todo|errors?|xfailed|xpassed|deselected)`)
+)
+
+// parseTestOutput extracts pass/fail/skip counts from go test, jest or pytest
+// output. ok is false when no known summary was found.
+func parseTestOutput(out string) (c testCounts, ok bool) {
+	if m := jestSummaryRE.FindAllStringSubmatch(out, -1); len(m) > 0 {
+		c.Format = "jest"
+		addCountWords(&c, m[len(m)-1][1])
+		return c, true
+	}
+	if m := pytestLineRE.FindAllStringSubmatch(out, -1); len(m) > 0 {
+		c.Format = "pytest"
+		addCountWords(&c, m[len(m)-1][1])
+		return c, true
+	}
+	// go test -v reports each test; without -v only packages.
+	if m := goTestLineRE.FindAllStringSubmatch(out, -1); len(m) > 0 {
+		c.Format = "go"
+		for _, g := range m {
+			switch g[1] {
+			case "PASS":
+				c.Passed++
+			case "FAIL":
+				c.Failed++
+			case "SKIP":
+				c.Skipped++
+			}
+		}
+		return c, true
+	}
+	if m := goPkgLineRE.FindAllStringSubmatch(out, -1); len(m) > 0 {
+		c.Format = "go"
+		for _, g := range m {
+			switch g[1] {
+			case "ok":
+				c.Passed++
+			case "FAIL":
+				c.Failed++
+			}
+		}
+		return c, true
+	}
+	return c, false
+}
+
+// addCountWords adds "3 passed, 1 failed"-style counts to c.
+func addCountWords(c *testCounts, s string) {
+	for _, m := range countWordRE.FindAllStringSubmatch(s, -1) {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "passed", "xpassed":
+			c.Passed += n
+		case "failed", "error", "errors":
+			c.Failed += n
+		case "skipped", "todo", "xfailed", "deselected":
+			c.Skipped += n
+		}
+	}
+}
+
+// tailBuffer keeps the last n bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	n   int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.n {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-t.n:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// testsSnapshot returns a copy of the latest result, or nil. Secrets are
+// masked (secrets.go).
+func (s *Session) testsSnapshot() *testResult {
+	s.tests.mu.Lock()
+	defer s.tests.mu.Unlock()
+	if s.tests.latest == nil {
+		return nil
+	}
+	r := *s.tests.latest
+	r.Command, r.Output = maskSecrets(r.Command), maskSecrets(r.Output)
+	return &r
+}
+
+// setTestResult stores r as the latest result and broadcasts it.
+func (s *Session) setTestResult(r testResult) {
+	s.tests.mu.Lock()
+	s.tests.latest = &r
+	s.tests.mu.Unlock()
+	s.broadcastTestResult(r)
+}
+
+// broadcastTestResult sends r, without its output, to the session's clients.
+func (s *Session) broadcastTestResult(r testResult) {
+	msg := struct {
+		Type string `json:"type"`
+		testResult
+	}{"tests", r}
+	msg.Output = "" // fetched with GET .../tests; keep the broadcast small
+	msg.Command = maskSecrets(msg.Command)
+	s.BroadcastJSON(msg)
+}
+
+var errTestsRunning = errors.New("a test run is already in progress")
+
+// startTestRun starts the session's test command in the background and
+// returns the "running" result.
+func (s *Session) startTestRun() (*testResult, error) {
+	env := s.hookEnv("tests")
+	command := strings.TrimSpace(envLookup(env)("SWE_TEST_CMD"))
+	if command == "" {
+		return nil, fmt.Errorf("no test command: set SWE_TEST_CMD in .swe-swe/env")
+	}
+	s.tests.mu.Lock()
+	if s.tests.latest != nil && s.tests.latest.Status == "running" {
+		s.tests.mu.Unlock()
+		return nil, errTestsRunning
+	}
+	running := testResult{Status: "running", Command: command, StartedAt: time.Now()}
+	s.tests.latest = &running
+	s.tests.mu.Unlock()
+	s.broadcastTestResult(running)
+
+	go func() {
+		defer recoverGoroutine(fmt.Sprintf("test run for session %s", s.UUID))
+		s.setTestResult(runTestCommand(command, s.effectiveWorkDir(), env, running.StartedAt))
+	}()
+	return &running, nil
+}
+
+// runShellCommand runs command with sh -c in dir, copying combined output to
+// out, and kills its whole process group after timeout or when ctx is done.
+// err is set only when the command could not be started or waited on; a
+// non-zero exit is reported in exitCode (-1 when killed).
+func runShellCommand(ctx context.Context, command, dir string, env []string, out io.Writer, timeout time.Duration) (exitCode int, timedOut bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 2 * time.Second
+	if err := cmd.Start(); err != nil {
+		return -1, false, err
+	}
+	err = cmd.Wait()
+	timedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, false, nil
+	case timedOut:
+		return -1, true, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), false, nil
+	}
+	return -1, false, err
+}
+
+// runTestCommand runs command and returns the finished result.
+func runTestCommand(command, dir string, env []string, started time.Time) testResult {
+	res := testResult{Command: command, StartedAt: started}
+	out := &tailBuffer{n: testOutputTail}
+	exitCode, timedOut, err := runShellCommand(context.Background(), command, dir, env, out, testTimeout)
+	finished := time.Now()
+	res.FinishedAt = &finished
+	res.DurationMs = finished.Sub(started).Milliseconds()
+	res.Output = out.String()
+	res.ExitCode, res.TimedOut = exitCode, timedOut
+	if err != nil {
+		res.Status, res.Error = "error", err.Error()
+		return res
+	}
+	if c, ok := parseTestOutput(res.Output); ok {
+		res.Format, res.Passed, res.Failed, res.Skipped = c.Format, c.Passed, c.Failed, c.Skipped
+	}
+	switch {
+	case res.TimedOut:
+		res.Status, res.Error = "error", fmt.Sprintf("timed out after %s", testTimeout)
+	case res.ExitCode == 0 && res.Failed == 0:
+		res.Status = "passed"
+	default:
+		res.Status = "failed"
+	}
+	return res
+}
+
+// handleSessionTestsAPI handles GET /api/session/{uuid}/tests and
+// POST /api/session/{uuid}/tests/run.
+func handleSessionTestsAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/session/")
+	sessionUUID, sub, _ := strings.Cut(rest, "/tests")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sess.testsSnapshot())
+	case sub == "/run" && r.Method == http.MethodPost:
+		res, err := sess.startTestRun()
+		if errors.Is(err, errTestsRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		res.Command = maskSecrets(res.Command)
+		log.Printf("Session %s: test run started: %s", sess.UUID, res.Command)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(res)
+	case sub == "" || sub == "/run":
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,130 @@
+// agent_state.go -- is each session's agent busy, idle, or asking to run a
+// tool?
+//
+// agentStateTracker looks at every session's terminal each
+// agentStateInterval:
+//
+//   - busy: the PTY wrote output within agentBusyWindow. Agents redraw a
+//     spinner or timer while they generate, so output is never quiet for
+//     long while they work. A session that has written nothing yet is busy
+//     starting up;
+//   - waiting_approval: output is quiet and the screen matches the
+//     assistant's ApprovalPattern, e.g. Claude's "Do you want to proceed?";
+//   - idle: output is quiet otherwise -- the agent sits at its prompt.
+//
+// A change is broadcast as the status message's agentState and to /ws/admin
+// (admin_events.go). The homepage reads the same state from
+// /api/sessions/live. An approval prompt is also sent to the session's
+// clients to answer with buttons (approval_relay.go).
+//
+// SWE_APPROVAL_PATTERN_<BINARY> replaces an assistant's pattern, like
+// SWE_READY_PATTERN_<BINARY> (agent_ready.go); "none" removes it.
+package main
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/choonkeat/swe-swe/internal/termsnap"
+)
+
+const (
+	agentStateBusy            = "busy"
+	agentStateIdle            = "idle"
+	agentStateWaitingApproval = "waiting_approval"
+
+	// agentBusyWindow is how recent output must be for the agent to count
+	// as busy.
+	agentBusyWindow = 2 * time.Second
+	// agentStateInterval is how often agentStateTracker looks.
+	agentStateInterval = time.Second
+)
+
+// approvalPatterns are the compiled approval patterns by assistant binary.
+var approvalPatterns map[string]*regexp.Regexp
+
+// loadApprovalPatterns compiles the assistants' approval patterns and the
+// SWE_APPROVAL_PATTERN_* overrides.
+func loadApprovalPatterns() error {
+	patterns, err := loadAgentPatterns("approval", "SWE_APPROVAL_PATTERN_", func(a AssistantConfig) string { return a.ApprovalPattern })
+	if err != nil {
+		return err
+	}
+	approvalPatterns = patterns
+	return nil
+}
+
+// agentStateTracker runs updateAgentStates periodically.
+func agentStateTracker() {
+	defer recoverGoroutine("agent state tracker")
+	ticker := time.NewTicker(agentStateInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		updateAgentStates()
+	}
+}
+
+// updateAgentStates updates every session's agent state and sends a status
+// update to the viewers of those that changed, and an event to /ws/admin.
+func updateAgentStates() {
+	sessionsMu.RLock()
+	live := make([]*Session, 0, len(sessions))
+	for _, sess := range sessions {
+		live = append(live, sess)
+	}
+	sessionsMu.RUnlock()
+
+	now := time.Now()
+	for _, sess := range live {
+		state := sess.computeAgentState(now)
+		prev := sess.agentState.Swap(state)
+		if prev == state {
+			continue
+		}
+		if prev == agentStateWaitingApproval {
+			sess.closeApprovalRequest()
+		}
+		publishAdminEvent(adminEvent{Type: "agent_state", Session: sess.UUID, State: state})
+		if state == agentStateWaitingApproval {
+			publishAdminEvent(adminEvent{Type: "attention", Session: sess.UUID, Reason: agentStateWaitingApproval})
+			sess.openApprovalRequest()
+		}
+		if sess.ClientCount() > 0 {
+			sess.BroadcastStatus()
+		}
+	}
+}
+
+// computeAgentState works out the agent's state from its terminal at now.
+func (s *Session) computeAgentState(now time.Time) string {
+	s.vtMu.Lock()
+	defer s.vtMu.Unlock()
+	if s.lastOutput.IsZero() || now.Sub(s.lastOutput) < agentBusyWindow {
+		return agentStateBusy
+	}
+	if re := approvalPatterns[s.Assistant]; re != nil && re.MatchString(termsnap.Text(s.vt)) {
+		return agentStateWaitingApproval
+	}
+	return agentStateIdle
+}
+
+// AgentState returns the agent's state as of the last look, or "" before
+// the first.
+func (s *Session) AgentState() string {
+	state, _ := s.agentState.Load().(string)
+	return state
+}
+
+// agentStateSummaryStatus maps an agent state to the homepage card's status
+// color; mirrored in homepage-main.js.
+func agentStateSummaryStatus(state string) string {
+	switch state {
+	case agentStateIdle:
+		return "green"
+	case agentStateBusy:
+		return "red"
+	case agentStateWaitingApproval:
+		return "amber"
+	}
+	return ""
+}
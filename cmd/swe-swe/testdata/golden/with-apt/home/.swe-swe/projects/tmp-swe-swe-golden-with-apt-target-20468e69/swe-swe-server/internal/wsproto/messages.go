@@ -0,0 +1,310 @@
+package wsproto
+
+import "encoding/json"
+
+func init() {
+	register(ClientToServer, false,
+		Ping{}, Bookmark{}, Prompt{}, ClearPrompts{}, RenameSession{},
+		PauseRecording{}, ResumeRecording{}, ToggleYolo{}, Approve{}, Deny{},
+		RestartAgent{}, StartPreviewApp{}, StopPreviewApp{}, ClearEnv{},
+		VerifyStoredSigningKey{})
+	register(ClientToServer, true,
+		SetTheme{}, SetSizeMode{}, PinSize{}, CopyRows{}, SetCredentials{},
+		TestCredentials{}, SetEnv{}, SetSigningKey{}, VerifySigningKey{})
+	register(Both, false, Chat{})
+	register(ServerToClient, false,
+		Protocol{}, Pong{}, FileUpload{}, PromptQueued{}, PromptError{},
+		RenameError{}, SizeMode{}, ApprovalError{}, PreviewAppError{},
+		CopyError{}, InputResume{}, InputAck{}, CredentialsStored{},
+		CredentialsTested{}, EnvStored{}, EnvCleared{}, SigningKeyStored{},
+		SigningKeyVerified{})
+}
+
+// Client to server.
+
+// Ping is the client's heartbeat; the server echoes Data in a Pong.
+type Ping struct {
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Chat is an in-session chat line: sent by a client, and broadcast to every
+// client of the session with Timestamp (RFC 3339) set.
+type Chat struct {
+	UserName  string `json:"userName"`
+	Text      string `json:"text"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// Bookmark pins a note at this moment of the recording.
+type Bookmark struct {
+	Text     string `json:"text"`
+	UserName string `json:"userName,omitempty"`
+}
+
+// Prompt queues text to type into the agent once it goes quiet.
+type Prompt struct {
+	Text string `json:"text"`
+}
+
+// ClearPrompts drops the queued prompts.
+type ClearPrompts struct{}
+
+// RenameSession renames the session; refused with a RenameError.
+type RenameSession struct {
+	Name string `json:"name"`
+}
+
+// SetTheme reports the UI's switch between "light" and "dark".
+type SetTheme struct {
+	Theme string `json:"theme"`
+}
+
+// SetSizeMode picks whether this client sizes the PTY ("shared") or renders
+// it scaled ("independent"); answered with a SizeMode.
+type SetSizeMode struct {
+	Mode string `json:"mode"`
+}
+
+// PinSize pins the PTY to Cols x Rows, or unpins it with 0 x 0.
+type PinSize struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+// PauseRecording stops capturing the session until ResumeRecording.
+type PauseRecording struct{}
+
+// ResumeRecording restarts capture after PauseRecording.
+type ResumeRecording struct{}
+
+// ToggleYolo restarts the agent with YOLO mode flipped.
+type ToggleYolo struct{}
+
+// Approve answers the agent's pending approval prompt ID with yes.
+type Approve struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName,omitempty"`
+}
+
+// Deny answers the agent's pending approval prompt ID with no.
+type Deny struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName,omitempty"`
+}
+
+// RestartAgent restarts the agent in place, keeping its YOLO mode.
+type RestartAgent struct{}
+
+// StartPreviewApp starts the repo's dev server.
+type StartPreviewApp struct{}
+
+// StopPreviewApp stops the repo's dev server.
+type StopPreviewApp struct{}
+
+// CopyRows asks for rows Start to End of the session's history (negative
+// counts back from the bottom) as a one-time download.
+type CopyRows struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SigningKey is an SSH commit-signing key as the client sends it.
+type SigningKey struct {
+	SigningPrivateKeyPEM string `json:"signing_private_key_pem"`
+	SigningPassphrase    string `json:"signing_passphrase"`
+	SigningKeyLabel      string `json:"signing_key_label"`
+}
+
+// SetCredentials stores HTTPS git credentials, the commit author and,
+// optionally, a signing key for the session; answered with
+// CredentialsStored.
+type SetCredentials struct {
+	Host     string `json:"host"` // "" for github.com
+	Username string `json:"username"`
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	SigningKey
+}
+
+// TestCredentials checks HTTPS git credentials without storing them;
+// answered with CredentialsTested.
+type TestCredentials struct {
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// SetEnv stores the repo's env vars (KEY=value lines) for sessions started
+// after it; answered with EnvStored.
+type SetEnv struct {
+	Raw string `json:"raw"`
+}
+
+// ClearEnv forgets the stored env vars; answered with EnvCleared.
+type ClearEnv struct{}
+
+// SetSigningKey stores a signing key on its own; answered with
+// SigningKeyStored.
+type SetSigningKey struct {
+	SigningKey
+}
+
+// VerifySigningKey checks a signing key parses without storing it;
+// answered with SigningKeyVerified.
+type VerifySigningKey struct {
+	SigningKey
+}
+
+// VerifyStoredSigningKey checks the session's stored signing key can sign;
+// answered with SigningKeyVerified.
+type VerifyStoredSigningKey struct{}
+
+// Server to client.
+
+// Protocol is the server's first frame: the protocol versions it speaks.
+type Protocol struct {
+	Version int `json:"version"`
+	Min     int `json:"min"`
+}
+
+// Pong answers a Ping.
+type Pong struct {
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// FileUpload answers an upload or paste frame.
+type FileUpload struct {
+	Success  bool   `json:"success"`
+	Filename string `json:"filename,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// PromptQueued answers a Prompt with how many are now queued.
+type PromptQueued struct {
+	Queued int `json:"queued"`
+}
+
+// PromptError refuses a Prompt.
+type PromptError struct {
+	Message string `json:"message"`
+}
+
+// RenameError refuses a RenameSession. Code is "invalid_name", or
+// "name_taken" with a free Suggestion.
+type RenameError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// SizeMode confirms a SetSizeMode.
+type SizeMode struct {
+	Mode string `json:"mode"`
+}
+
+// ApprovalError refuses an Approve or Deny.
+type ApprovalError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// PreviewAppError refuses a StartPreviewApp.
+type PreviewAppError struct {
+	Message string `json:"message"`
+}
+
+// CopyError refuses a CopyRows.
+type CopyError struct {
+	Message string `json:"message"`
+}
+
+// InputResume tells a client connected with ?input={id} the last Seq applied
+// from its input stream; it resends what came after.
+type InputResume struct {
+	Seq uint32 `json:"seq"`
+}
+
+// InputAck acknowledges a sequenced input frame, applied or not.
+type InputAck struct {
+	Seq uint32 `json:"seq"`
+}
+
+// CredentialsStored answers SetCredentials.
+type CredentialsStored struct {
+	Host               string   `json:"host"`
+	Hosts              []string `json:"hosts"`
+	SigningFingerprint string   `json:"signing_fingerprint,omitempty"`
+	SigningError       string   `json:"signing_error,omitempty"`
+}
+
+// CredentialsTested answers TestCredentials.
+type CredentialsTested struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// EnvStored answers SetEnv with the reserved keys it ignored.
+type EnvStored struct {
+	Dropped []string `json:"dropped"`
+}
+
+// EnvCleared answers ClearEnv.
+type EnvCleared struct{}
+
+// SigningKeyStored answers SetSigningKey with the key's fingerprint, or why
+// it was not stored.
+type SigningKeyStored struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// SigningKeyVerified answers VerifySigningKey and VerifyStoredSigningKey.
+type SigningKeyVerified struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (Ping) MessageType() string                   { return "ping" }
+func (Chat) MessageType() string                   { return "chat" }
+func (Bookmark) MessageType() string               { return "bookmark" }
+func (Prompt) MessageType() string                 { return "prompt" }
+func (ClearPrompts) MessageType() string           { return "clear_prompts" }
+func (RenameSession) MessageType() string          { return "rename_session" }
+func (SetTheme) MessageType() string               { return "set_theme" }
+func (SetSizeMode) MessageType() string            { return "set_size_mode" }
+func (PinSize) MessageType() string                { return "pin_size" }
+func (PauseRecording) MessageType() string         { return "pause_recording" }
+func (ResumeRecording) MessageType() string        { return "resume_recording" }
+func (ToggleYolo) MessageType() string             { return "toggle_yolo" }
+func (Approve) MessageType() string                { return "approve" }
+func (Deny) MessageType() string                   { return "deny" }
+func (RestartAgent) MessageType() string           { return "restart_agent" }
+func (StartPreviewApp) MessageType() string        { return "start_preview_app" }
+func (StopPreviewApp) MessageType() string         { return "stop_preview_app" }
+func (CopyRows) MessageType() string               { return "copy_rows" }
+func (SetCredentials) MessageType() string         { return "set_credentials" }
+func (TestCredentials) MessageType() string        { return "test_credentials" }
+func (SetEnv) MessageType() string                 { return "set_env" }
+func (ClearEnv) MessageType() string               { return "clear_env" }
+func (SetSigningKey) MessageType() string          { return "set_signing_key" }
+func (VerifySigningKey) MessageType() string       { return "verify_signing_key" }
+func (VerifyStoredSigningKey) MessageType() string { return "verify_stored_signing_key" }
+func (Protocol) MessageType() string               { return "protocol" }
+func (Pong) MessageType() string                   { return "pong" }
+func (FileUpload) MessageType() string             { return "file_upload" }
+func (PromptQueued) MessageType() string           { return "prompt_queued" }
+func (PromptError) MessageType() string            { return "prompt_error" }
+func (RenameError) MessageType() string            { return "rename_error" }
+func (SizeMode) MessageType() string               { return "size_mode" }
+func (ApprovalError) MessageType() string          { return "approval_error" }
+func (PreviewAppError) MessageType() string        { return "preview_app_error" }
+func (CopyError) MessageType() string              { return "copy_error" }
+func (InputResume) MessageType() string            { return "input_resume" }
+func (InputAck) MessageType() string               { return "input_ack" }
+func (CredentialsStored) MessageType() string      { return "credentials_stored" }
+func (CredentialsTested) MessageType() string      { return "credentials_tested" }
+func (EnvStored) MessageType() string              { return "env_stored" }
+func (EnvCleared) MessageType() string             { return "env_cleared" }
+func (SigningKeyStored) MessageType() string       { return "signing_key_stored" }
+func (SigningKeyVerified) MessageType() string     { return "signing_key_verified" }
@@ -0,0 +1,243 @@
+// preview_debug.go -- per-session view of the preview debug channel.
+//
+// Each session's preview proxies share one agent-reverse-proxy DebugHub, which
+// relays what inject.js reports from the app (console output, errors, URL
+// changes, query results) to whoever is connected at that moment. Nothing
+// kept those messages, nothing said which session they came from, and an
+// agent could reach the preview tools only through /proxy/{uuid}/preview/mcp
+// -- so the Pi MCP bridge guessed the UUID from SESSION_UUID, or failing that
+// from the first entry in list_sessions.
+//
+// This file scopes the channel to the session:
+//
+//   - Every hub is subscribed for the life of its session, and each message
+//     is kept in a bounded per-session buffer, wrapped with the session UUID.
+//     GET /api/session/{uuid}/preview-debug[?since=N] serves the buffer, and
+//     the preview_debug_messages MCP tool reads it for a `session` argument
+//     (default: the calling session), optionally waiting for new messages.
+//   - /mcp/preview?key=K[&session=UUID] serves the preview MCP tools of the
+//     given session, defaulting to the session that owns the key, so a
+//     client needs only its MCP auth key.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	agentproxy "github.com/choonkeat/agent-reverse-proxy"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// previewDebugLimit bounds each session's buffer; older messages are dropped.
+const previewDebugLimit = 500
+
+// previewDebugMessage is one message from the session's preview, as relayed
+// by its DebugHub.
+type previewDebugMessage struct {
+	Seq         uint64          `json:"seq"`
+	Time        time.Time       `json:"time"`
+	SessionUUID string          `json:"sessionUUID"`
+	Type        string          `json:"type,omitempty"` // the message's "t" field
+	Message     json.RawMessage `json:"message"`
+}
+
+// previewDebugLog is a fixed-size ring of one session's debug messages.
+type previewDebugLog struct {
+	sessionUUID string
+	stop        func()
+
+	mu   sync.Mutex
+	msgs []previewDebugMessage
+	// start is the index of the oldest message once the ring is full.
+	start int
+	next  uint64        // seq the next message gets (first message is 1)
+	wake  chan struct{} // closed (and replaced) when a message arrives
+}
+
+func (l *previewDebugLog) add(raw []byte) {
+	m := previewDebugMessage{Time: time.Now(), SessionUUID: l.sessionUUID}
+	var envelope struct {
+		T string `json:"t"`
+	}
+	if json.Unmarshal(raw, &envelope) == nil {
+		m.Type = envelope.T
+		m.Message = raw
+	} else {
+		// Not JSON: keep it as a string so the wrapper still encodes.
+		m.Message, _ = json.Marshal(string(raw))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next++
+	m.Seq = l.next
+	if len(l.msgs) < previewDebugLimit {
+		l.msgs = append(l.msgs, m)
+	} else {
+		l.msgs[l.start] = m
+		l.start = (l.start + 1) % previewDebugLimit
+	}
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// since returns buffered messages with Seq > after, oldest first, the seq of
+// the newest message, and a channel closed when another message arrives.
+func (l *previewDebugLog) since(after uint64) ([]previewDebugMessage, uint64, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]previewDebugMessage, 0, len(l.msgs))
+	for i := range l.msgs {
+		m := l.msgs[(l.start+i)%len(l.msgs)]
+		if m.Seq > after {
+			out = append(out, m)
+		}
+	}
+	return out, l.next, l.wake
+}
+
+// wait is since, blocking up to wait for the first message after the cursor.
+func (l *previewDebugLog) wait(ctx context.Context, after uint64, wait time.Duration) ([]previewDebugMessage, uint64) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		msgs, next, wake := l.since(after)
+		if len(msgs) > 0 || wait <= 0 {
+			return msgs, next
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			return msgs, next
+		case <-ctx.Done():
+			return msgs, next
+		}
+	}
+}
+
+// previewDebugLogs maps session UUID -> *previewDebugLog for live sessions.
+var previewDebugLogs sync.Map
+
+// registerPreviewDebug subscribes to a session's DebugHub and buffers what it
+// relays. Called once the session's preview proxies are created.
+func registerPreviewDebug(sessionUUID string, hub *agentproxy.DebugHub) {
+	sub := hub.Subscribe()
+	done := make(chan struct{})
+	l := &previewDebugLog{sessionUUID: sessionUUID, wake: make(chan struct{})}
+	var once sync.Once
+	l.stop = func() { once.Do(func() { close(done); hub.Unsubscribe(sub) }) }
+	if old, loaded := previewDebugLogs.Swap(sessionUUID, l); loaded {
+		old.(*previewDebugLog).stop()
+	}
+	go func() {
+		defer recoverGoroutine("preview debug log for session " + sessionUUID)
+		for {
+			select {
+			case msg := <-sub:
+				l.add(msg)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// unregisterPreviewDebug stops buffering. Called at the end of Close.
+func unregisterPreviewDebug(sessionUUID string) {
+	if v, ok := previewDebugLogs.LoadAndDelete(sessionUUID); ok {
+		v.(*previewDebugLog).stop()
+	}
+}
+
+func loadPreviewDebug(sessionUUID string) (*previewDebugLog, bool) {
+	v, ok := previewDebugLogs.Load(sessionUUID)
+	if !ok {
+		return nil, false
+	}
+	return v.(*previewDebugLog), true
+}
+
+// handlePreviewDebugAPI handles GET /api/session/{uuid}/preview-debug[?since=N]:
+//
+//	{"sessionUUID": "...",
+//	 "messages": [{"seq": 1, "time": "...", "sessionUUID": "...",
+//	               "type": "console", "message": {"t": "console", ...}}, ...],
+//	 "next": 42}
+func handlePreviewDebugAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionUUID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/preview-debug")
+	l, ok := loadPreviewDebug(sessionUUID)
+	if sessionUUID == "" || !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	var after uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+	msgs, next, _ := l.since(after)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessionUUID": sessionUUID, "messages": msgs, "next": next})
+}
+
+// handlePreviewMCP serves /mcp/preview?key=K[&session=UUID]: the preview MCP
+// tools (the same server as /proxy/{uuid}/preview/mcp) of the given session,
+// defaulting to the one that owns the key.
+func handlePreviewMCP(w http.ResponseWriter, r *http.Request) {
+	caller, ok := sessionForKey(r.URL.Query().Get("key"))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionUUID := firstNonEmpty(r.URL.Query().Get("session"), caller)
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sess == nil || sess.PreviewMCP == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	traceHandler(w, r, "mcp.preview", sess.PreviewMCP, "session.uuid", sessionUUID)
+}
+
+// registerPreviewDebugTools adds preview_debug_messages to the orchestration
+// MCP server.
+func registerPreviewDebugTools(server *mcp.Server) {
+	type previewDebugArgs struct {
+		Session     string `json:"session,omitempty" jsonschema:"UUID of the session whose preview to read (see list_sessions); default: the calling session"`
+		Cursor      uint64 `json:"cursor,omitempty" jsonschema:"Return messages with seq > cursor; pass back the cursor from the previous call. 0 returns everything still buffered."`
+		WaitSeconds int    `json:"wait_seconds,omitempty" jsonschema:"How long to wait for a message when none is pending, 0-55 (default 0, return at once)"`
+	}
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "preview_debug_messages",
+		Description: "Read what a session's App Preview reported through its debug channel: console output, errors, URL changes and query results, each tagged with the session UUID. Returns {sessionUUID, messages, cursor}; call again with the cursor for newer messages.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, args previewDebugArgs) (*mcp.CallToolResult, any, error) {
+		caller := callerSessionFromContext(ctx)
+		if caller == "" {
+			return nil, nil, fmt.Errorf("unauthenticated: missing calling session identity")
+		}
+		sessionUUID := firstNonEmpty(strings.TrimSpace(args.Session), caller)
+		l, ok := loadPreviewDebug(sessionUUID)
+		if !ok {
+			return nil, nil, fmt.Errorf("session %s not found or has no preview", sessionUUID)
+		}
+		wait := min(max(time.Duration(args.WaitSeconds)*time.Second, 0), busMaxWait)
+		msgs, cursor := l.wait(ctx, args.Cursor, wait)
+		data, _ := json.Marshal(map[string]interface{}{"sessionUUID": sessionUUID, "messages": msgs, "cursor": cursor})
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(data)}}}, nil, nil
+	})
+}
@@ -0,0 +1,261 @@
+// session_backend.go -- where a session's command runs: the host, a docker
+// container, a devcontainer, a Kubernetes pod, or a machine reached over ssh.
+//
+// A sessionBackend rewrites the agent command before wrapWithShell wraps it,
+// so the host keeps the PTY, resize handling and the recording exactly as
+// for a host session; only the innermost command changes
+// from `claude ...` to `docker exec -it ... claude ...`. docker exec -it
+// allocates a TTY in the container and forwards window-size changes to it.
+//
+// The backend is chosen with SWE_SESSION_BACKEND, read from the session's
+// environment, so a repo selects its own in .swe-swe/env and the server-wide
+// value (env, or session.backend in the config file) is the default:
+//
+//	host                         run on the host (default)
+//	docker:NAME                  docker exec into running container NAME, in
+//	                             the same path as on the host (bind mount)
+//	docker:NAME:/path            ... in /path instead
+//	devcontainer                 `devcontainer up` the working directory's
+//	                             .devcontainer/devcontainer.json, then exec
+//	                             into it as its remoteUser, in its
+//	                             remoteWorkspaceFolder
+//	k8s                          a pod per session (session_backend_k8s.go)
+//	ssh                          a remote machine (session_backend_ssh.go)
+//
+// Environment: the session env is forwarded by name (`-e NAME`, docker takes
+// the value from its own environment, so values never touch a command line),
+// minus hostOnlyEnv -- paths and helpers that only exist on the host.
+//
+// Killing the `docker exec` client does not stop what it started in the
+// container, so Cleanup signals every container process whose environment
+// carries the session's SESSION_UUID. Release runs once the session is over
+// and also frees whatever the backend created for it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sessionBackend runs session commands somewhere.
+type sessionBackend interface {
+	// Name describes the backend for logs, e.g. "docker:dev".
+	Name() string
+	// Command rewrites cmdName/cmdArgs to run in the backend; env is the
+	// session environment the returned command will be started with (ssh
+	// sends it ahead to the remote here).
+	Command(cmdName string, cmdArgs []string, env []string) (string, []string)
+	// Cleanup stops anything of the session's still running in the backend
+	// after the host-side process tree has been killed (e.g. before a
+	// restart replaces the agent).
+	Cleanup(sessionUUID string)
+	// Release is Cleanup for a session that is over: it also frees what the
+	// backend created for the session.
+	Release(sessionUUID string)
+}
+
+// backendParams is what resolveSessionBackend knows about the session.
+type backendParams struct {
+	SessionUUID string
+	WorkDir     string   // on the host
+	Env         []string // final session env; SWE_SESSION_BACKEND and friends are read from it
+}
+
+// hostBackend runs commands directly on the host.
+type hostBackend struct{}
+
+func (hostBackend) Name() string { return "host" }
+
+func (hostBackend) Command(cmdName string, cmdArgs []string, env []string) (string, []string) {
+	return cmdName, cmdArgs
+}
+
+func (hostBackend) Cleanup(string) {}
+
+func (hostBackend) Release(string) {}
+
+// containerBackend runs commands with docker exec in a running container.
+type containerBackend struct {
+	kind      string // "docker" or "devcontainer", for Name
+	container string
+	workDir   string // in the container; "" = the container's default
+	user      string // "" = the container's default
+}
+
+func (b containerBackend) Name() string { return b.kind + ":" + b.container }
+
+func (b containerBackend) Command(cmdName string, cmdArgs []string, env []string) (string, []string) {
+	args := []string{"exec", "-it"}
+	if b.user != "" {
+		args = append(args, "-u", b.user)
+	}
+	if b.workDir != "" {
+		args = append(args, "-w", b.workDir)
+	}
+	for _, name := range forwardedEnvNames(env) {
+		args = append(args, "-e", name)
+	}
+	args = append(args, b.container, cmdName)
+	return "docker", append(args, cmdArgs...)
+}
+
+// containerCleanupTimeout bounds the exec that signals leftovers.
+const containerCleanupTimeout = 10 * time.Second
+
+// killSessionScript, run with sh -c in a container with the session UUID as
+// $1, signals every process whose environment carries that SESSION_UUID.
+const killSessionScript = `for p in /proc/[0-9]*; do ` +
+	`if tr '\0' '\n' < "$p/environ" 2>/dev/null | grep -qx "SESSION_UUID=$1"; then kill -TERM "${p#/proc/}" 2>/dev/null; fi; ` +
+	`done`
+
+func (b containerBackend) Cleanup(sessionUUID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerCleanupTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "exec", b.container, "sh", "-c", killSessionScript, "sh", sessionUUID).CombinedOutput()
+	if err != nil {
+		log.Printf("Session %s: cleanup in container %s failed: %v %s", sessionUUID, b.container, err, strings.TrimSpace(string(out)))
+	}
+}
+
+// Release leaves the container running: it is the user's (or the
+// devcontainer CLI's), shared by every session in the repo.
+func (b containerBackend) Release(sessionUUID string) { b.Cleanup(sessionUUID) }
+
+// hostOnlyEnv is not forwarded into containers: host paths, host helpers,
+// and per-login variables the container sets for itself.
+var hostOnlyEnv = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "LOGNAME": true, "SHELL": true,
+	"HOSTNAME": true, "PWD": true, "OLDPWD": true, "SHLVL": true, "_": true,
+	"TMPDIR": true, "BROWSER": true, "SWE_SESSION_BACKEND": true, "SSH_AUTH_SOCK": true,
+	// The per-session gitconfig and the swe-swe credential helper are host
+	// files and a host binary.
+	"GIT_CONFIG_GLOBAL": true, "GIT_CONFIG_COUNT": true,
+	"GIT_CONFIG_KEY_0": true, "GIT_CONFIG_VALUE_0": true,
+}
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// forwardedEnvNames returns the distinct variable names in env to pass with
+// docker exec -e, in first-seen order.
+func forwardedEnvNames(env []string) []string {
+	seen := make(map[string]bool, len(env))
+	var names []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if seen[name] || hostOnlyEnv[name] || !envNameRe.MatchString(name) {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// backendWordRe admits container names, users and paths that survive
+// wrapWithShell's space-joined command line unchanged.
+var backendWordRe = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]+$`)
+
+// resolveSessionBackend reads SWE_SESSION_BACKEND from the session env and
+// returns the backend for it. For devcontainer and k8s this starts (or
+// reuses) the container, which can take a while the first time; for ssh it
+// checks the machine is reachable.
+func resolveSessionBackend(p backendParams) (sessionBackend, error) {
+	spec := strings.TrimSpace(envLookup(p.Env)("SWE_SESSION_BACKEND"))
+	hostWorkDir := p.WorkDir
+	switch {
+	case spec == "" || spec == "host":
+		return hostBackend{}, nil
+	case spec == "devcontainer":
+		return devcontainerUp(hostWorkDir)
+	case spec == "k8s":
+		return startK8sSessionPod(p)
+	case spec == "ssh":
+		return startSSHSession(p)
+	case strings.HasPrefix(spec, "docker:"):
+		name, dir, _ := strings.Cut(strings.TrimPrefix(spec, "docker:"), ":")
+		if name == "" {
+			return nil, fmt.Errorf("SWE_SESSION_BACKEND %q: missing container name", spec)
+		}
+		if dir == "" {
+			dir = hostWorkDir
+		}
+		b := containerBackend{kind: "docker", container: name, workDir: dir}
+		return b, b.validate()
+	}
+	return nil, fmt.Errorf("invalid SWE_SESSION_BACKEND %q (want host, docker:NAME[:/path], devcontainer, k8s or ssh)", spec)
+}
+
+func (b containerBackend) validate() error {
+	for _, w := range []string{b.container, b.workDir, b.user} {
+		if w != "" && !backendWordRe.MatchString(w) {
+			return fmt.Errorf("%s backend: %q contains characters the session command line cannot carry", b.kind, w)
+		}
+	}
+	return nil
+}
+
+// devcontainerUpTimeout bounds `devcontainer up`; a first build can be slow.
+const devcontainerUpTimeout = 10 * time.Minute
+
+// devcontainerUp runs `devcontainer up` for workDir (a no-op beyond a status
+// check when the container is already running) and returns a backend for it.
+func devcontainerUp(workDir string) (sessionBackend, error) {
+	if _, err := exec.LookPath("devcontainer"); err != nil {
+		return nil, fmt.Errorf("devcontainer backend needs the devcontainer CLI (npm install -g @devcontainers/cli): %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), devcontainerUpTimeout)
+	defer cancel()
+	start := time.Now()
+	out, err := exec.CommandContext(ctx, "devcontainer", "up", "--workspace-folder", workDir).Output()
+	if err != nil {
+		return nil, fmt.Errorf("devcontainer up in %s: %w", workDir, err)
+	}
+	b, err := parseDevcontainerUp(out)
+	if err != nil {
+		return nil, fmt.Errorf("devcontainer up in %s: %w", workDir, err)
+	}
+	log.Printf("devcontainer up in %s: container %s (%s)", workDir, b.container, time.Since(start).Round(time.Millisecond))
+	return b, nil
+}
+
+// parseDevcontainerUp reads the result object `devcontainer up` prints as its
+// last JSON line on stdout.
+func parseDevcontainerUp(out []byte) (containerBackend, error) {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var res struct {
+			Outcome               string `json:"outcome"`
+			Message               string `json:"message"`
+			ContainerID           string `json:"containerId"`
+			RemoteUser            string `json:"remoteUser"`
+			RemoteWorkspaceFolder string `json:"remoteWorkspaceFolder"`
+		}
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			continue
+		}
+		if res.Outcome != "success" {
+			return containerBackend{}, fmt.Errorf("outcome %q: %s", res.Outcome, res.Message)
+		}
+		if res.ContainerID == "" {
+			return containerBackend{}, fmt.Errorf("no containerId in result")
+		}
+		b := containerBackend{
+			kind:      "devcontainer",
+			container: res.ContainerID,
+			workDir:   res.RemoteWorkspaceFolder,
+			user:      res.RemoteUser,
+		}
+		return b, b.validate()
+	}
+	return containerBackend{}, fmt.Errorf("no result in output")
+}
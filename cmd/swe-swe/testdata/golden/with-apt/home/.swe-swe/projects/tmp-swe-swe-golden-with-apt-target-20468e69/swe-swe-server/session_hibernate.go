@@ -0,0 +1,385 @@
+// session_hibernate.go -- stop idle sessions' agents and start them again on
+// the next visit.
+//
+// On a shared server most sessions sit idle most of the time, each holding an
+// agent process tree, a PTY, and whatever listens on its ports. With
+// SWE_HIBERNATE_AFTER (e.g. 2h; off by default) a session is hibernated once,
+// for that long, it has had no viewers (page or broadcast), its agent has
+// written no output and nobody has typed into it. Only a top-level session
+// whose agent sits idle at its prompt (agent_state.go) and has a restart
+// command (the plain shell has none) qualifies, and not while one of its
+// terminal tabs runs.
+//
+// Hibernating:
+//
+//   - takes a working-tree checkpoint (checkpoints.go), when they are on;
+//   - remembers the agent's restart command -- the one the restart button
+//     runs, which resumes the agent's last conversation -- and whether
+//     md-serve and the preview app (session_preview_app.go) were running;
+//   - stops Agent View, md-serve and the preview app, then the agent's
+//     process group (SIGTERM, SIGKILL after hibernateStopGrace) and what it
+//     left running in a container backend, and closes the PTY.
+//
+// The session stays in the sessions map with its worktree, scrollback,
+// screen, credentials and port reservation, so its URLs keep working; it is
+// not reaped, and still counts against SWE_MAX_SESSIONS.
+//
+// The next WebSocket connection wakes it. That client gets the usual
+// scrollback and snapshot, then
+//
+//	{"type": "hibernation", "state": "resuming"}
+//
+// while the server runs the restart
+// command in the same working directory, at the clients' size, and starts
+// md-serve and the preview app again. Every client then gets
+// {"type": "hibernation", "state": "awake"}, or "failed" with a "message";
+// a session that cannot be woken ends, like a failed restart. Input for a
+// hibernated session -- keystrokes, another agent's send_input -- wakes it
+// too, and waits up to hibernateWakeTimeout for it. /ws/admin gets a
+// hibernation event for each step, and session health says "hibernated".
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// hibernateStopGrace is how long the agent gets to exit on SIGTERM.
+	hibernateStopGrace = 5 * time.Second
+	// hibernateWakeTimeout bounds how long input waits for a waking session.
+	hibernateWakeTimeout = 30 * time.Second
+	// hibernateSweepInterval is how often idle sessions are looked for.
+	hibernateSweepInterval = time.Minute
+)
+
+// hibernateAfter is SWE_HIBERNATE_AFTER; 0 is off.
+var hibernateAfter time.Duration
+
+// loadHibernateAfter applies SWE_HIBERNATE_AFTER: a Go duration of at least a
+// minute, or "off"/"0"/empty for no hibernation.
+func loadHibernateAfter() error {
+	v := strings.TrimSpace(os.Getenv("SWE_HIBERNATE_AFTER"))
+	switch strings.ToLower(v) {
+	case "", "0", "off":
+		hibernateAfter = 0
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Minute {
+		return fmt.Errorf("SWE_HIBERNATE_AFTER=%q: want a duration of at least 1m, or off", v)
+	}
+	hibernateAfter = d
+	log.Printf("Hibernating sessions idle for %s", d)
+	return nil
+}
+
+// hibernationState is a session's hibernation.
+type hibernationState struct {
+	// asleep is set from the moment hibernation starts until the agent runs
+	// again: the agent's Cmd has exited, but the session is not over. Read
+	// without locks; written under mu.
+	asleep atomic.Bool
+
+	mu      sync.Mutex
+	command string        // the restart command to wake with
+	files   bool          // md-serve was running
+	preview bool          // the preview app was running
+	parked  chan struct{} // closed once the PTY reader waits to be woken
+	wake    chan struct{} // closed to wake the PTY reader
+	woken   bool          // wake is closed
+	awake   chan struct{} // closed once the wake is over
+	err     error         // why the last wake failed
+}
+
+// hibernated reports whether the session is hibernated, or on its way into
+// or out of it.
+func (s *Session) hibernated() bool {
+	return s.hibernation.asleep.Load()
+}
+
+// exited reports whether the agent process has finished for good: a
+// hibernated session's has exited, but it will run again.
+func (s *Session) exited() bool {
+	return s.Cmd != nil && s.Cmd.ProcessState != nil && !s.hibernated()
+}
+
+// hibernationSweeper hibernates idle sessions every hibernateSweepInterval.
+func hibernationSweeper() {
+	if hibernateAfter <= 0 {
+		return
+	}
+	defer recoverGoroutine("hibernation sweeper")
+	ticker := time.NewTicker(hibernateSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		hibernateIdleSessions(time.Now())
+	}
+}
+
+// hibernateIdleSessions hibernates the sessions idle for hibernateAfter.
+func hibernateIdleSessions(now time.Time) {
+	sessionsMu.RLock()
+	busyParents := map[string]bool{}
+	for _, sess := range sessions {
+		if sess.ParentUUID != "" && !sess.reapable() {
+			busyParents[sess.ParentUUID] = true
+		}
+	}
+	var idle []*Session
+	for uuid, sess := range sessions {
+		if !busyParents[uuid] && sess.hibernationDue(now, hibernateAfter) {
+			idle = append(idle, sess)
+		}
+	}
+	sessionsMu.RUnlock()
+	for _, sess := range idle {
+		sess.hibernate()
+	}
+}
+
+// hibernationDue reports whether the session may be hibernated at now,
+// having been idle for after.
+func (s *Session) hibernationDue(now time.Time, after time.Duration) bool {
+	if s.ParentUUID != "" || s.hibernated() || s.AgentState() != agentStateIdle {
+		return false
+	}
+	if _, viewers := s.broadcastStatus(); viewers > 0 {
+		return false
+	}
+	s.vtMu.Lock()
+	lastOutput := s.lastOutput
+	s.vtMu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch {
+	case s.AssistantConfig.ShellRestartCmd == "",
+		s.Cmd == nil || s.Cmd.Process == nil || s.Cmd.ProcessState != nil,
+		s.closed, s.ending, s.endRequested, s.restarting, s.pendingReplacement != "",
+		len(s.wsClients) > 0:
+		return false
+	}
+	return now.Sub(lastOutput) >= after && now.Sub(s.lastActive) >= after
+}
+
+// hibernate stops the session's agent and what runs beside it. It reports
+// whether it did; a session that got a viewer or changed meanwhile is left.
+func (s *Session) hibernate() bool {
+	h := &s.hibernation
+	s.mu.Lock()
+	if s.closed || s.ending || s.restarting || s.pendingReplacement != "" || len(s.wsClients) > 0 ||
+		s.Cmd == nil || s.Cmd.Process == nil || s.Cmd.ProcessState != nil || s.hibernated() {
+		s.mu.Unlock()
+		return false
+	}
+	cmd := s.Cmd
+	h.mu.Lock()
+	h.command = s.computeRestartCommand(s.yoloMode)
+	h.files = s.FilesPID != 0
+	h.parked, h.wake, h.awake = make(chan struct{}), make(chan struct{}), make(chan struct{})
+	h.woken, h.err = false, nil
+	h.asleep.Store(true)
+	parked := h.parked
+	h.mu.Unlock()
+	stopSessionAgentView(s)
+	s.BrowserStarted = false
+	stopSessionMdServe(s)
+	s.mu.Unlock()
+
+	log.Printf("Session %s: hibernating after %s idle", s.UUID, hibernateAfter)
+	if s.Checkpoints != nil {
+		if _, err := s.Checkpoints.take("hibernate", false); err != nil {
+			log.Printf("Session %s: checkpoint failed: %v", s.UUID, err)
+		}
+	}
+	preview := s.previewAppSnapshot(false).Status
+	h.mu.Lock()
+	h.preview = preview != "stopped" && preview != "crashed"
+	h.mu.Unlock()
+	s.stopPreviewApp()
+
+	// Like restartAgent, not killSessionProcessGroup: the session's
+	// credentials, MCP key and gitconfig must outlive the agent.
+	pid := cmd.Process.Pid
+	descendants := collectDescendantPIDs(pid)
+	log.Printf("[KILL] hibernate: sending SIGTERM to process group -%d (server pid=%d)", pid, os.Getpid())
+	syscall.Kill(-pid, syscall.SIGTERM)
+	select {
+	case <-parked:
+	case <-time.After(hibernateStopGrace):
+		log.Printf("[KILL] hibernate: sending SIGKILL to process group -%d (server pid=%d)", pid, os.Getpid())
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+	for _, dpid := range descendants {
+		if dpid != os.Getpid() {
+			syscall.Kill(dpid, syscall.SIGKILL)
+		}
+	}
+	if s.Backend != nil {
+		s.Backend.Cleanup(s.UUID)
+	}
+	publishAdminEvent(adminEvent{Type: "hibernation", Session: s.UUID, State: "hibernated"})
+	s.mu.Lock()
+	s.publishAdminStatusLocked()
+	s.mu.Unlock()
+	return true
+}
+
+// sleep is the PTY reader's side of hibernation, once the agent has exited
+// and been waited for: it closes the PTY, waits to be woken, and runs the
+// restart command. It reports whether the agent runs again; if not, err
+// says why, or is nil when the session was closed instead.
+func (s *Session) sleep() (resumed bool, err error) {
+	h := &s.hibernation
+	h.mu.Lock()
+	parked, wake, command := h.parked, h.wake, h.command
+	h.mu.Unlock()
+
+	s.mu.Lock()
+	if s.PTY != nil {
+		s.PTY.Close()
+	}
+	// asleep keeps the reaper off now.
+	s.restarting = false
+	s.mu.Unlock()
+	s.terminalNotice(tr(s.Locale, "terminal.hibernated", formatDuration(hibernateAfter)))
+	close(parked)
+
+	<-wake
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		s.endHibernation(nil)
+		return false, nil
+	}
+
+	s.terminalNotice(tr(s.Locale, "terminal.resuming"))
+	log.Printf("Session %s: waking: %s", s.UUID, command)
+	err = s.RestartProcess(command)
+	if err == nil {
+		h.mu.Lock()
+		files, preview := h.files, h.preview
+		h.mu.Unlock()
+		if files {
+			s.mu.Lock()
+			if err := startSessionMdServe(s); err != nil {
+				log.Printf("Failed to start md-serve for session %s: %v", s.UUID, err)
+			}
+			s.mu.Unlock()
+		}
+		if preview {
+			if _, err := s.startPreviewApp(); err != nil {
+				log.Printf("Session %s: preview app not restarted: %v", s.UUID, err)
+			}
+		}
+	}
+	s.finishWake(err)
+	return err == nil, err
+}
+
+// endHibernation clears the hibernation and lets whoever waits for the
+// wake go on; err is why the wake failed.
+func (s *Session) endHibernation(err error) {
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = err
+	h.asleep.Store(false)
+	close(h.awake)
+}
+
+// finishWake ends the hibernation and tells the clients and /ws/admin how
+// the wake went.
+func (s *Session) finishWake(err error) {
+	s.endHibernation(err)
+	state := "awake"
+	msg := map[string]string{"type": "hibernation", "state": state}
+	if err != nil {
+		log.Printf("Session %s: could not wake: %v", s.UUID, err)
+		s.terminalNotice(tr(s.Locale, "terminal.resumeFailed", err.Error()))
+		state = "failed"
+		msg["state"], msg["message"] = state, err.Error()
+	}
+	s.BroadcastJSON(msg)
+	publishAdminEvent(adminEvent{Type: "hibernation", Session: s.UUID, State: state})
+	s.mu.Lock()
+	s.publishAdminStatusLocked()
+	s.mu.Unlock()
+}
+
+// wake starts waking a hibernated session. It returns a channel closed once
+// the session is awake (or failed to wake), or nil if it is not hibernated.
+func (s *Session) wake() <-chan struct{} {
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.asleep.Load() {
+		return nil
+	}
+	if !h.woken {
+		h.woken = true
+		close(h.wake)
+		publishAdminEvent(adminEvent{Type: "hibernation", Session: s.UUID, State: "resuming"})
+	}
+	return h.awake
+}
+
+// releaseHibernation lets the PTY reader of a hibernated session that is
+// being closed go: it finds the session closed and returns.
+func (s *Session) releaseHibernation() {
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.asleep.Load() && !h.woken {
+		h.woken = true
+		close(h.wake)
+	}
+}
+
+// wakeForClient wakes a hibernated session for a client that just
+// connected, telling it first.
+func (s *Session) wakeForClient(conn *SafeConn) {
+	if !s.hibernated() {
+		return
+	}
+	conn.WriteJSON(map[string]string{"type": "hibernation", "state": "resuming"})
+	s.wake()
+}
+
+// awaitAwake wakes a hibernated session and waits for it, so input reaches
+// the new agent. It returns nil at once for a session that is not
+// hibernated.
+func (s *Session) awaitAwake() error {
+	awake := s.wake()
+	if awake == nil {
+		return nil
+	}
+	select {
+	case <-awake:
+	case <-time.After(hibernateWakeTimeout):
+		return errors.New("the session is still resuming")
+	}
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// terminalNotice writes a line of text to the terminal, for the clients and
+// the scrollback.
+func (s *Session) terminalNotice(text string) {
+	msg := []byte("\r\n" + text + "\r\n")
+	s.vtMu.Lock()
+	s.vt.Write(msg)
+	s.writeToRing(msg)
+	s.vtMu.Unlock()
+	s.Broadcast(msg)
+}
@@ -29,14 +29,15 @@ export function buildShellUrl(config) {
 }
 
 /**
- * Build the app preview base URL (path-based, same origin).
+ * Build the app preview base URL (path-based, same origin). This is the
+ * only preview route in single-port mode (SWE_PROXY_MODE=path).
  * @param {string} baseUrl - The base URL of swe-swe-server
  * @param {string} sessionUUID - Session UUID
  * @returns {string|null} Preview proxy base URL, or null if no sessionUUID
  */
 export function buildPreviewUrl(baseUrl, sessionUUID) {
     if (!sessionUUID) return null;
-    return `${baseUrl}/proxy/${sessionUUID}/preview`;
+    return `${baseUrl}/preview/${sessionUUID}`;
 }
 
 /**
@@ -158,6 +159,20 @@ export function themeCookieDomain(location, publicHostname) {
     return accessedViaTunnel(location, publicHostname) ? publicHostname : '';
 }
 
+/**
+ * Build the per-session preview subdomain URL (SWE_PREVIEW_DOMAIN). The
+ * server routes "{sessionUUID}.{previewDomain}" on its own port to the
+ * session's app, mounted at the root, so the page's port is kept.
+ * @param {{protocol: string, port: string}} location - Location-like object
+ * @param {string} previewDomainHost - The session's preview host from the status message
+ * @returns {string|null} Preview subdomain URL, or null if not configured
+ */
+export function buildPreviewDomainUrl(location, previewDomainHost) {
+    if (!previewDomainHost) return null;
+    const port = location.port ? `:${location.port}` : '';
+    return `${location.protocol}//${previewDomainHost}${port}`;
+}
+
 /**
  * Build the subdomain-based preview URL for tunnel mode. When swe-swe runs
  * behind a reverse tunnel (SWE_PUBLIC_HOSTNAME / --public-hostname), browser
@@ -0,0 +1,288 @@
+// worktree_merge.go -- POST /api/worktree/{branch}/merge: merge a finished
+// worktree's branch back into the branch checked out in its repo's main
+// checkout, so the exit dialog can offer a one-click merge.
+//
+//	POST /api/worktree/fix/login/merge
+//	{"strategy": "squash", "deleteAfter": true}
+//
+// strategy is "merge" (default; fast-forwards when it can), "squash" (one
+// commit on the target branch) or "rebase" (rebase the branch onto the target
+// in the worktree, then fast-forward the target). Both checkouts must have no
+// uncommitted changes to tracked files. A merge that conflicts is undone --
+// both checkouts are left as they were -- and answered with 409 and the
+// conflicting files:
+//
+//	{"merged": false, "strategy": "merge", "branch": "fix/login", "targetBranch": "main",
+//	 "conflicts": [{"path": "auth/login.go", "kind": "both modified"}]}
+//
+// On success (200) the response has merged: true and the target's new commit.
+// With deleteAfter the worktree and its branch are removed afterwards, unless
+// a session still runs in it or it holds untracked files, which is reported
+// in warnings instead. Refusals before anything is touched are plain HTTP
+// errors.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// worktreeMergeMu serializes merges, which write to a shared main checkout.
+var worktreeMergeMu sync.Mutex
+
+// mergeRequest is the optional POST /api/worktree/{branch}/merge body.
+type mergeRequest struct {
+	Strategy    string `json:"strategy"`    // "merge" (default), "squash" or "rebase"
+	DeleteAfter bool   `json:"deleteAfter"` // remove the worktree and branch after merging
+	Path        string `json:"path"`        // worktree directory, as for publish
+}
+
+// mergeConflictFile is one file a failed merge left unmerged.
+type mergeConflictFile struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"` // "both modified", "deleted by them", ...
+}
+
+// mergeResult is the POST /api/worktree/{branch}/merge response.
+type mergeResult struct {
+	Merged       bool                `json:"merged"`
+	Strategy     string              `json:"strategy"`
+	Branch       string              `json:"branch"`
+	TargetBranch string              `json:"targetBranch"`
+	Commit       string              `json:"commit,omitempty"`
+	UpToDate     bool                `json:"upToDate,omitempty"` // target already had every commit
+	Deleted      bool                `json:"deleted,omitempty"`
+	Conflicts    []mergeConflictFile `json:"conflicts,omitempty"`
+	Warnings     []string            `json:"warnings,omitempty"`
+	Error        string              `json:"error,omitempty"`
+}
+
+// unmergedKinds names the `git status --porcelain` codes of unmerged paths.
+var unmergedKinds = map[string]string{
+	"DD": "both deleted",
+	"AU": "added by us",
+	"UD": "deleted by them",
+	"UA": "added by them",
+	"DU": "deleted by us",
+	"AA": "both added",
+	"UU": "both modified",
+}
+
+// gitMergeStep runs one git command of a merge in dir, returning its combined
+// output in the error when it fails.
+func gitMergeStep(dir string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unmergedFiles returns the files a failed merge or rebase in dir left
+// unmerged.
+func unmergedFiles(dir string) []mergeConflictFile {
+	out, err := gitInWorkDir(dir, "status", "--porcelain", "-z", "--untracked-files=no")
+	if err != nil {
+		return nil
+	}
+	var files []mergeConflictFile
+	for _, entry := range strings.Split(out, "\x00") {
+		if len(entry) < 4 {
+			continue
+		}
+		if kind, ok := unmergedKinds[entry[:2]]; ok {
+			files = append(files, mergeConflictFile{Path: entry[3:], Kind: kind})
+		}
+	}
+	return files
+}
+
+// hasTrackedChanges reports whether dir has uncommitted changes to tracked
+// files.
+func hasTrackedChanges(dir string) (bool, error) {
+	out, err := gitInWorkDir(dir, "status", "--porcelain", "--untracked-files=no")
+	return strings.TrimSpace(out) != "", err
+}
+
+// mergeWorktree merges branch, checked out in the worktree dir, into the
+// branch of the repo's main checkout. status is the HTTP status to answer
+// with; on a refusal before anything was touched, err says why.
+func mergeWorktree(dir, branch string, req mergeRequest) (res mergeResult, status int, err error) {
+	res = mergeResult{Strategy: req.Strategy, Branch: branch}
+	mainTree, err := mainWorktree(dir)
+	if err != nil {
+		return res, http.StatusConflict, fmt.Errorf("%s is not a git worktree", dir)
+	}
+	if filepath.Clean(mainTree) == filepath.Clean(dir) {
+		return res, http.StatusConflict, fmt.Errorf("%s is the repo's main checkout, not a worktree", dir)
+	}
+
+	// Held from the checks through cleanup, so a concurrent merge cannot
+	// move or dirty the main checkout in between.
+	worktreeMergeMu.Lock()
+	defer worktreeMergeMu.Unlock()
+
+	out, _ := gitInWorkDir(mainTree, "branch", "--show-current")
+	if res.TargetBranch = strings.TrimSpace(out); res.TargetBranch == "" {
+		return res, http.StatusConflict, fmt.Errorf("%s has no branch checked out", mainTree)
+	}
+	for _, d := range []string{dir, mainTree} {
+		dirty, err := hasTrackedChanges(d)
+		if err != nil {
+			return res, http.StatusInternalServerError, fmt.Errorf("git status in %s: %v", d, err)
+		}
+		if dirty {
+			return res, http.StatusConflict, fmt.Errorf("%s has uncommitted changes; commit or stash them first", d)
+		}
+	}
+
+	ref := "refs/heads/" + branch
+	if _, err := gitInWorkDir(mainTree, "merge-base", "--is-ancestor", ref, "HEAD"); err == nil {
+		res.Merged, res.UpToDate = true, true
+	} else if conflicts, err := runMergeStrategy(dir, mainTree, branch, req.Strategy); len(conflicts) > 0 {
+		res.Conflicts = conflicts
+		return res, http.StatusConflict, nil
+	} else if err != nil {
+		res.Error = err.Error()
+		return res, http.StatusInternalServerError, nil
+	} else {
+		res.Merged = true
+	}
+	res.Commit = gitHeadCommit(mainTree)
+
+	if req.DeleteAfter {
+		if warning := removeMergedWorktree(dir, mainTree, branch, req.Strategy); warning != "" {
+			res.Warnings = append(res.Warnings, warning)
+		} else {
+			res.Deleted = true
+		}
+	}
+	return res, http.StatusOK, nil
+}
+
+// runMergeStrategy merges branch into mainTree's branch with strategy. When
+// the merge conflicts it is undone and the conflicting files returned.
+func runMergeStrategy(dir, mainTree, branch, strategy string) ([]mergeConflictFile, error) {
+	switch strategy {
+	case "rebase":
+		target, _ := gitInWorkDir(mainTree, "branch", "--show-current")
+		if err := gitMergeStep(dir, "rebase", "refs/heads/"+strings.TrimSpace(target)); err != nil {
+			conflicts := unmergedFiles(dir)
+			gitMergeStep(dir, "rebase", "--abort")
+			return conflicts, err
+		}
+		return nil, gitMergeStep(mainTree, "merge", "--ff-only", branch)
+	case "squash":
+		if err := gitMergeStep(mainTree, "merge", "--squash", branch); err != nil {
+			conflicts := unmergedFiles(mainTree)
+			gitMergeStep(mainTree, "reset", "--merge")
+			return conflicts, err
+		}
+		if gitMergeStep(mainTree, "diff", "--cached", "--quiet") == nil {
+			return nil, nil // the branch's changes are already on the target
+		}
+		return nil, gitMergeStep(mainTree, "commit", "--no-verify", "-m", fmt.Sprintf("Squash merge branch '%s'", branch))
+	default:
+		if err := gitMergeStep(mainTree, "merge", "--no-edit", branch); err != nil {
+			conflicts := unmergedFiles(mainTree)
+			gitMergeStep(mainTree, "merge", "--abort")
+			return conflicts, err
+		}
+		return nil, nil
+	}
+}
+
+// removeMergedWorktree removes the worktree dir and its branch after a
+// merge. It returns why it did not, or "".
+func removeMergedWorktree(dir, mainTree, branch, strategy string) string {
+	sessionsMu.RLock()
+	for _, sess := range sessions {
+		if filepath.Clean(sess.WorkDir) == filepath.Clean(dir) {
+			sessionsMu.RUnlock()
+			return fmt.Sprintf("worktree not removed: session %s is still running in it", sess.UUID)
+		}
+	}
+	sessionsMu.RUnlock()
+
+	// Untracked files other than the swe-swe files symlinked in from the main
+	// checkout would be lost.
+	out, _ := gitInWorkDir(dir, "ls-files", "-z", "--others", "--exclude-standard", "--directory")
+	for _, p := range strings.Split(out, "\x00") {
+		p = strings.TrimSuffix(p, "/")
+		if p == "" {
+			continue
+		}
+		if target, err := os.Readlink(filepath.Join(dir, p)); err == nil && strings.HasPrefix(target, mainTree+string(filepath.Separator)) {
+			continue
+		}
+		return fmt.Sprintf("worktree not removed: it has untracked files (%s)", p)
+	}
+
+	if err := gitMergeStep(mainTree, "worktree", "remove", "--force", dir); err != nil {
+		return fmt.Sprintf("worktree not removed: %v", err)
+	}
+	// A squashed branch is not an ancestor of the target, so -d would refuse.
+	deleteFlag := "-d"
+	if strategy == "squash" {
+		deleteFlag = "-D"
+	}
+	if err := gitMergeStep(mainTree, "branch", deleteFlag, branch); err != nil {
+		return fmt.Sprintf("worktree removed, branch kept: %v", err)
+	}
+	return ""
+}
+
+// handleWorktreeMergeAPI handles POST /api/worktree/{branch}/merge.
+func handleWorktreeMergeAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	branch := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/worktree/"), "/merge")
+	if !validBranchName(branch) {
+		http.Error(w, fmt.Sprintf("Invalid branch %q", branch), http.StatusBadRequest)
+		return
+	}
+	var req mergeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	switch req.Strategy {
+	case "":
+		req.Strategy = "merge"
+	case "merge", "squash", "rebase":
+	default:
+		http.Error(w, fmt.Sprintf("Invalid strategy %q: want merge, squash or rebase", req.Strategy), http.StatusBadRequest)
+		return
+	}
+	dir, err := resolveBranchWorktree(branch, req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	res, status, err := mergeWorktree(dir, branch, req)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Printf("Merge from %s: %s into %s (%s): merged=%v conflicts=%d deleted=%v", clientLabel(r), branch, res.TargetBranch, req.Strategy, res.Merged, len(res.Conflicts), res.Deleted)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,259 @@
+// approval_relay.go -- answering an agent's approval prompt without a
+// keyboard.
+//
+// When agent_state.go sees a session's agent waiting for approval, the
+// prompt is read off the screen and sent to the session's clients:
+//
+//	{"type": "approval_request", "id": "3", "assistant": "claude",
+//	 "question": "Do you want to proceed?",
+//	 "context": ["Bash command", "rm -rf build", "Remove build dir"],
+//	 "options": ["1. Yes", "2. Yes, and don't ask again", "3. No (esc)"],
+//	 "canDeny": true}
+//
+// question is the line the ApprovalPattern matched, context the lines above
+// it (what is asked about, up to approvalContextLines) and options the lines
+// below it. A client answers with {"type": "approve", "id": "3"} or
+// {"type": "deny", "id": "3"}, and the server types the assistant's
+// ApproveKeys or DenyKeys -- Claude's "1" and Esc, Aider's "y" and "n" and
+// Enter -- so the mobile UI can offer two big buttons instead of a tiny
+// numbered menu. Once the prompt is answered or leaves the screen, clients
+// get {"type": "approval_resolved", "id": "3"}, with "decision" and "by" when
+// a client answered it. A client that connects meanwhile gets the pending
+// request.
+//
+// SWE_APPROVE_KEYS_<BINARY> and SWE_DENY_KEYS_<BINARY> replace an
+// assistant's keys, written as a Go string body ("y\r", "\x1b"); "none" for
+// deny means the prompt can only be approved from the buttons. Share-link
+// viewers cannot answer.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/choonkeat/swe-swe/internal/termsnap"
+)
+
+const (
+	approvalContextLines = 10
+	approvalOptionLines  = 6
+)
+
+var (
+	errNoApproval     = errors.New("no approval is pending")
+	errApprovalStale  = errors.New("that approval is no longer pending")
+	errApprovalNoKeys = errors.New("this agent's prompt cannot be answered from here")
+)
+
+// approvalKeys are the keystrokes that answer an assistant's approval
+// prompt, by binary.
+var approvalKeys map[string]approvalKeySet
+
+type approvalKeySet struct {
+	Approve, Deny string
+}
+
+// loadApprovalKeys reads the assistants' approve and deny keys and the
+// SWE_APPROVE_KEYS_* / SWE_DENY_KEYS_* overrides.
+func loadApprovalKeys() error {
+	keys := map[string]approvalKeySet{}
+	for _, a := range assistantConfigs {
+		keys[a.Binary] = approvalKeySet{Approve: a.ApproveKeys, Deny: a.DenyKeys}
+	}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		var field *string
+		binary, approve := strings.CutPrefix(name, "SWE_APPROVE_KEYS_")
+		if !approve {
+			var deny bool
+			if binary, deny = strings.CutPrefix(name, "SWE_DENY_KEYS_"); !deny {
+				continue
+			}
+		}
+		if binary == "" {
+			continue
+		}
+		binary = strings.ToLower(binary)
+		set := keys[binary]
+		if approve {
+			field = &set.Approve
+		} else {
+			field = &set.Deny
+		}
+		if value == "none" {
+			*field = ""
+		} else {
+			v, err := strconv.Unquote(`"` + value + `"`)
+			if err != nil || v == "" {
+				return fmt.Errorf("%s=%q: want keys as a Go string body, e.g. y\\r, or none", name, value)
+			}
+			*field = v
+		}
+		keys[binary] = set
+	}
+	approvalKeys = keys
+	return nil
+}
+
+// approvalRequest is an approval prompt read off a session's screen.
+type approvalRequest struct {
+	ID        string   `json:"id"`
+	Assistant string   `json:"assistant"`
+	Question  string   `json:"question"`
+	Context   []string `json:"context,omitempty"`
+	Options   []string `json:"options,omitempty"`
+	CanDeny   bool     `json:"canDeny"`
+}
+
+// approvalRelay is a session's pending approval prompt.
+type approvalRelay struct {
+	mu       sync.Mutex
+	seq      int
+	pending  *approvalRequest
+	answered bool
+}
+
+// openApprovalRequest reads the approval prompt off the screen and sends it
+// to the clients. Called when the agent starts waiting for approval.
+func (s *Session) openApprovalRequest() {
+	re := approvalPatterns[s.Assistant]
+	keys := approvalKeys[s.Assistant]
+	if re == nil || keys.Approve == "" {
+		return
+	}
+	s.vtMu.Lock()
+	screen := termsnap.Text(s.vt)
+	s.vtMu.Unlock()
+	req, ok := parseApprovalScreen(screen, re)
+	if !ok {
+		return
+	}
+	req.Assistant, req.CanDeny = s.Assistant, keys.Deny != ""
+
+	s.approval.mu.Lock()
+	s.approval.seq++
+	req.ID = strconv.Itoa(s.approval.seq)
+	s.approval.pending, s.approval.answered = &req, false
+	s.approval.mu.Unlock()
+	s.BroadcastJSON(approvalRequestMessage(req))
+}
+
+// closeApprovalRequest tells the clients the prompt is gone. Called when the
+// agent stops waiting for approval.
+func (s *Session) closeApprovalRequest() {
+	s.approval.mu.Lock()
+	req, answered := s.approval.pending, s.approval.answered
+	s.approval.pending = nil
+	s.approval.mu.Unlock()
+	if req != nil && !answered {
+		s.BroadcastJSON(map[string]string{"type": "approval_resolved", "id": req.ID})
+	}
+}
+
+// pendingApproval is the message for a client that connects while a prompt
+// waits, or nil.
+func (s *Session) pendingApproval() any {
+	s.approval.mu.Lock()
+	defer s.approval.mu.Unlock()
+	if s.approval.pending == nil || s.approval.answered {
+		return nil
+	}
+	return approvalRequestMessage(*s.approval.pending)
+}
+
+func approvalRequestMessage(req approvalRequest) any {
+	return struct {
+		Type string `json:"type"`
+		approvalRequest
+	}{"approval_request", req}
+}
+
+// answerApproval types the keys for decision ("approve" or "deny") into the
+// pending prompt. id, when not empty, must be the pending request's, so a
+// late tap cannot answer the next prompt.
+func (s *Session) answerApproval(decision, id, by string) error {
+	keys := approvalKeys[s.Assistant]
+	input := keys.Approve
+	if decision == "deny" {
+		input = keys.Deny
+	}
+	if input == "" {
+		return errApprovalNoKeys
+	}
+	s.approval.mu.Lock()
+	req := s.approval.pending
+	switch {
+	case req == nil:
+		s.approval.mu.Unlock()
+		return errNoApproval
+	case s.approval.answered || (id != "" && id != req.ID):
+		s.approval.mu.Unlock()
+		return errApprovalStale
+	}
+	s.approval.answered = true
+	s.approval.mu.Unlock()
+
+	if err := s.WriteInput([]byte(input)); err != nil {
+		return err
+	}
+	log.Printf("Session %s: approval %s answered %s by %q: %s", s.UUID, req.ID, decision, by, req.Question)
+	s.BroadcastJSON(map[string]string{"type": "approval_resolved", "id": req.ID, "decision": decision, "by": by})
+	return nil
+}
+
+// approvalBorder is what frames the prompt boxes agents draw.
+const approvalBorder = "│┃|╭╮╰╯┌┐└┘─━ \t"
+
+// parseApprovalScreen finds the last line of screen that re matches, and
+// the lines around it.
+func parseApprovalScreen(screen string, re *regexp.Regexp) (approvalRequest, bool) {
+	matches := re.FindAllStringIndex(screen, -1)
+	if len(matches) == 0 {
+		return approvalRequest{}, false
+	}
+	lines := strings.Split(screen, "\n")
+	q := strings.Count(screen[:matches[len(matches)-1][0]], "\n")
+	req := approvalRequest{Question: approvalLine(lines[q])}
+
+	// The context runs up to the top of the box, or two blank lines.
+	blank := 0
+	for i := q - 1; i >= 0 && len(req.Context) < approvalContextLines; i-- {
+		raw := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(raw, "╭") || strings.HasPrefix(raw, "┌") {
+			break
+		}
+		line := approvalLine(lines[i])
+		if line == "" {
+			if blank++; blank == 2 && len(req.Context) > 0 {
+				break
+			}
+			continue
+		}
+		blank = 0
+		req.Context = append([]string{line}, req.Context...)
+	}
+	// The options run down to the bottom of the box, or a blank line.
+	for i := q + 1; i < len(lines) && len(req.Options) < approvalOptionLines; i++ {
+		raw := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(raw, "╰") || strings.HasPrefix(raw, "└") {
+			break
+		}
+		line := strings.TrimLeft(approvalLine(lines[i]), "❯›> ")
+		if line == "" {
+			break
+		}
+		req.Options = append(req.Options, line)
+	}
+	return req, true
+}
+
+// approvalLine is a screen line without the box around it.
+func approvalLine(line string) string {
+	return strings.Trim(line, approvalBorder)
+}
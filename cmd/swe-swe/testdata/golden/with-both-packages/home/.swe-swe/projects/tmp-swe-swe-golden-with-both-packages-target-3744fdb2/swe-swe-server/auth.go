@@ -16,6 +16,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 const (
@@ -129,27 +131,15 @@ func (g *authGlobalRateLimiter) record() {
 	g.times = append(g.times, time.Now())
 }
 
-// loginThrottleKey returns the identifier used to bucket login attempts.
-//
-// By default this is the transport peer address (RemoteAddr host), which the
-// client cannot forge. X-Forwarded-For is consulted ONLY when
-// SWE_TRUST_FORWARDED_FOR=true, i.e. the operator has confirmed a trusted
-// proxy fronts the server and sets that header. Trusting X-Forwarded-For
+// loginThrottleKey returns the identifier used to bucket login attempts: the
+// client's address (clientIP). That is the transport peer address, which the
+// client cannot forge, unless the operator has said which proxies front the
+// server (SWE_TRUSTED_PROXIES, or SWE_TRUST_FORWARDED_FOR=true for any peer)
+// -- then the address those proxies forwarded. Trusting X-Forwarded-For
 // unconditionally lets an attacker rotate the value to dodge the per-key
 // limiter entirely (see authGlobalRateLimiter for the backstop).
 func loginThrottleKey(r *http.Request) string {
-	if os.Getenv("SWE_TRUST_FORWARDED_FOR") == "true" {
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			if idx := strings.Index(xff, ","); idx != -1 {
-				xff = xff[:idx]
-			}
-			return strings.TrimSpace(xff)
-		}
-	}
-	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-		return host
-	}
-	return r.RemoteAddr
+	return clientIP(r)
 }
 
 // safeRedirect constrains a post-login redirect target to a same-origin,
@@ -249,6 +239,17 @@ func authSignScopedCookie(secret, scope string) string {
 	return signed + authCookieDelimiter + signature
 }
 
+// authSignShareCookie is authSignScopedCookie for a guest who came in through
+// a share link (session_share_links.go): the share ID is signed in too, so the
+// cookie stops validating as soon as that share is revoked or expires.
+//
+//   - "timestamp|scope|shareID|hmac(timestamp|scope|shareID)"
+func authSignShareCookie(secret, scope, shareID string) string {
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	signed := timestamp + authCookieDelimiter + scope + authCookieDelimiter + shareID
+	return signed + authCookieDelimiter + authComputeHMAC(signed, secret)
+}
+
 // authVerifyCookie validates an HMAC-signed cookie value and checks expiry.
 // It accepts both legacy (unscoped) and scoped cookies; use
 // authVerifyCookieScoped when the scope is needed.
@@ -259,13 +260,33 @@ func authVerifyCookie(cookie, secret string) bool {
 
 // authVerifyCookieScoped validates an HMAC-signed cookie value, checks expiry,
 // and returns the session scope it is bound to ("" for a full/unscoped user).
-// Handles both wire shapes:
+// Handles all wire shapes:
 //
-//   - "timestamp|signature"        -> scope ""       (legacy full user)
-//   - "timestamp|scope|signature"  -> scope <scope>  (shared-session guest)
+//   - "timestamp|signature"                -> scope ""       (legacy full user)
+//   - "timestamp|scope|signature"          -> scope <scope>  (shared-session guest)
+//   - "timestamp|scope|shareID|signature"  -> scope <scope>  (share-link guest;
+//     valid only while the share is)
+//   - "timestamp|user:NAME|signature"      -> scope ""       (named user)
 func authVerifyCookieScoped(cookie, secret string) (scope string, valid bool) {
+	scope, _, valid = authVerifyCookieShare(cookie, secret)
+	return scope, valid
+}
+
+// authVerifyCookieShare is authVerifyCookieScoped that also returns the share
+// ID of a share-link guest's cookie ("" for the other shapes).
+func authVerifyCookieShare(cookie, secret string) (scope, shareID string, valid bool) {
+	scope, shareID, _, valid = authVerifyCookieUser(cookie, secret)
+	return scope, shareID, valid
+}
+
+// authVerifyCookieUser is authVerifyCookieShare that also returns the named
+// user of a user's cookie (auth_roles.go), which has scope "":
+//
+//   - "timestamp|user:NAME|signature"      -> scope "", user NAME (valid
+//     only while NAME is in SWE_AUTH_USERS)
+func authVerifyCookieUser(cookie, secret string) (scope, shareID, user string, valid bool) {
 	if cookie == "" {
-		return "", false
+		return "", "", "", false
 	}
 
 	parts := strings.Split(cookie, authCookieDelimiter)
@@ -282,26 +303,49 @@ func authVerifyCookieScoped(cookie, secret string) (scope string, valid bool) {
 		scope = parts[1]
 		signature = parts[2]
 		signed = timestamp + authCookieDelimiter + scope
+		if name, ok := strings.CutPrefix(scope, authUserScopePrefix); ok {
+			scope, user = "", name
+		}
+	case 4: // share link: timestamp|scope|shareID|signature
+		timestamp = parts[0]
+		scope = parts[1]
+		shareID = parts[2]
+		signature = parts[3]
+		signed = timestamp + authCookieDelimiter + scope + authCookieDelimiter + shareID
+		if scope == "" || shareID == "" {
+			return "", "", "", false
+		}
 	default:
-		return "", false
+		return "", "", "", false
 	}
 
-	// Verify HMAC signature (keyed by the master secret in both shapes).
+	// Verify HMAC signature (keyed by the master secret in every shape).
 	expectedSignature := authComputeHMAC(signed, secret)
 	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
-		return "", false
+		return "", "", "", false
 	}
 
 	// Verify timestamp hasn't expired
 	ts, err := strconv.ParseInt(timestamp, 10, 64)
 	if err != nil {
-		return "", false
+		return "", "", "", false
 	}
 	if time.Now().Unix()-ts > int64(authCookieMaxAge) {
-		return "", false
+		return "", "", "", false
+	}
+
+	// A share-link cookie dies with its share (revoked, expired, or the
+	// session ended).
+	if shareID != "" && !shareLinkActive(scope, shareID) {
+		return "", "", "", false
 	}
 
-	return scope, true
+	// A user's cookie dies with the user.
+	if user != "" && lookupAuthUser(user) == nil {
+		return "", "", "", false
+	}
+
+	return scope, shareID, user, true
 }
 
 // authComputeHMAC generates an HMAC-SHA256 signature.
@@ -520,9 +564,11 @@ func resolveCookieDomain(publicHostname, requestHost string) string {
 }
 
 // sessionCookieDomain decides the Domain attribute for the session auth cookie,
-// combining the two cross-subdomain modes. Tunnel mode wins: if the browser
+// combining the cross-subdomain modes. Tunnel mode wins: if the browser
 // reached us via the live tunnel apex (or a per-port subdomain of it), pin to
-// that apex. Otherwise, in non-tunnel wildcard preview, pin to the reach suffix
+// that apex. Next, with SWE_PREVIEW_DOMAIN, pin to its parent so the login
+// covers every session's preview subdomain (preview_domain.go). Otherwise, in
+// non-tunnel wildcard preview, pin to the reach suffix
 // when the request landed on a configured preview reach origin, so the cookie is
 // sent to all "{name}-{port}.{reach}" sub-app origins. Anything else (localhost,
 // a LAN IP, an unknown host) stays host-only.
@@ -530,6 +576,9 @@ func sessionCookieDomain(requestHost string) string {
 	if d := resolveCookieDomain(getLiveTunnelHostname(), requestHost); d != "" {
 		return d
 	}
+	if d := previewDomainCookieDomain(requestHost); d != "" {
+		return d
+	}
 	return previewCookieReach(requestHost)
 }
 
@@ -564,14 +613,19 @@ func authLoginPostHandler(w http.ResponseWriter, r *http.Request, secret string)
 
 	// Validate the password. A scoped login checks the session's share
 	// password (constant-time inside validSessionShareLogin); an unscoped
-	// login checks the master secret. An unknown/ended session or a scope
-	// with sharing off fails exactly like a wrong password -- no existence
-	// leak.
+	// login checks the master secret, then the named users' passwords
+	// (auth_roles.go). An unknown/ended session or a scope with sharing off
+	// fails exactly like a wrong password -- no existence leak.
 	var passwordMatch bool
+	var user *authUser
 	if scope != "" {
 		passwordMatch = validSessionShareLogin(scope, password)
 	} else {
 		passwordMatch = subtle.ConstantTimeCompare([]byte(password), []byte(secret)) == 1
+		if !passwordMatch {
+			user = authUserByPassword(password)
+			passwordMatch = user != nil
+		}
 	}
 	if password == "" || !passwordMatch {
 		authLoginLimiter.record(clientKey)
@@ -582,11 +636,17 @@ func authLoginPostHandler(w http.ResponseWriter, r *http.Request, secret string)
 		return
 	}
 
+	// scope == "" yields the legacy unscoped cookie; a non-empty scope
+	// boxes the guest into that session; a named user's cookie carries
+	// their name.
+	value := authSignScopedCookie(secret, scope)
+	if user != nil {
+		value = authSignUserCookie(secret, user.Name)
+		log.Printf("User %s (%s) logged in from %s", user.Name, user.Role, clientLabel(r))
+	}
 	http.SetCookie(w, &http.Cookie{
-		Name: authCookieName,
-		// scope == "" yields the legacy unscoped cookie; a non-empty scope
-		// boxes the guest into that session.
-		Value: authSignScopedCookie(secret, scope),
+		Name:  authCookieName,
+		Value: value,
 		Path:  "/",
 		Domain:   sessionCookieDomain(r.Host),
 		MaxAge:   authCookieMaxAge,
@@ -627,11 +687,17 @@ func authLogoutHandler() http.HandlerFunc {
 // Used by Traefik ForwardAuth middleware in compose mode.
 func authVerifyHandler(secret string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Public recording embeds, oEmbed, the feeds, webhooks and broadcast
+		// watch links check their own credential.
+		if uri, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Uri"), "?"); publicEmbedPath(uri) || feedPath(uri) || webhookPath(uri) || broadcastPath(uri) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		cookie, err := r.Cookie(authCookieName)
-		var scope string
+		var scope, shareID, user string
 		if err == nil {
 			var valid bool
-			scope, valid = authVerifyCookieScoped(cookie.Value, secret)
+			scope, shareID, user, valid = authVerifyCookieUser(cookie.Value, secret)
 			if !valid {
 				err = http.ErrNoCookie
 			}
@@ -667,10 +733,38 @@ func authVerifyHandler(secret string) http.HandlerFunc {
 			if i := strings.IndexByte(uri, '?'); i >= 0 {
 				uri = uri[:i]
 			}
-			if !scopedVerifyAllowed(scope, uri) {
+			allowed := scopedVerifyAllowed(scope, uri)
+			if uuid, ok := previewDomainSession(r.Header.Get("X-Forwarded-Host")); ok {
+				allowed = uuid == scope
+			}
+			if !allowed {
 				http.Error(w, "forbidden", http.StatusForbidden)
 				return
 			}
+			if shareID != "" {
+				method := r.Header.Get("X-Forwarded-Method")
+				if method == "" {
+					method = http.MethodGet
+				}
+				if l, _ := lookupShareLink(scope, shareID); !shareViewOnlyAllowed(l, method, false) {
+					http.Error(w, "forbidden: view-only share", http.StatusForbidden)
+					return
+				}
+			}
+		} else {
+			// A named user is held to their role (auth_roles.go).
+			uri, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Uri"), "?")
+			if _, ok := previewDomainSession(r.Header.Get("X-Forwarded-Host")); ok {
+				uri = ""
+			}
+			method := r.Header.Get("X-Forwarded-Method")
+			if method == "" {
+				method = http.MethodGet
+			}
+			if id := identityFor(user); !id.allowsRequest(method, uri, false) {
+				http.Error(w, "forbidden: not allowed for role "+id.Role, http.StatusForbidden)
+				return
+			}
 		}
 		w.WriteHeader(http.StatusOK)
 	}
@@ -716,21 +810,34 @@ func proxyPreviewMCPPath(path string) (uuid string, ok bool) {
 // authMiddleware wraps an http.Handler with cookie-based authentication.
 // Unauthenticated requests are redirected to /swe-swe-auth/login.
 // Exempt paths: /swe-swe-auth/login, /swe-swe-auth/verify, /ssl/*, /mcp,
-// /api/session/*, /api/autocomplete/* (these use API key auth instead), and
-// the preview proxy open-URL control endpoint (per-session MCP key auth).
+// /mcp/preview, /api/session/*, /api/autocomplete/* (these use API key auth instead), the
+// preview proxy open-URL control endpoint (per-session MCP key auth), and
+// recording share links and their embeds (the token in the path is the
+// credential) plus /oembed, the feeds, webhooks and broadcast watch links,
+// which check access themselves.
 func authMiddleware(next http.Handler, secret string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
+		// On a session preview subdomain every path but /swe-swe-auth/ is
+		// the app's, so none of the other exemptions apply there.
+		_, onPreviewHost := previewDomainSession(r.Host)
 
 		// Exempt paths that don't require authentication
 		// (API key-authenticated routes handle their own auth)
 		if path == "/swe-swe-auth/login" ||
 			path == "/swe-swe-auth/logout" ||
-			path == "/swe-swe-auth/verify" ||
-			strings.HasPrefix(path, "/ssl/") ||
-			path == "/mcp" ||
-			(strings.HasPrefix(path, "/api/session/") && strings.HasSuffix(path, "/browser/start")) ||
-			strings.HasPrefix(path, "/api/autocomplete/") {
+			!onPreviewHost && (path == "/swe-swe-auth/verify" ||
+				path == "/swe-swe-auth/share" ||
+				strings.HasPrefix(path, recordingSharePrefix) ||
+				publicEmbedPath(path) ||
+				feedPath(path) ||
+				webhookPath(path) ||
+				broadcastPath(path) ||
+				strings.HasPrefix(path, "/ssl/") ||
+				path == "/mcp" ||
+				path == "/mcp/preview" ||
+				(strings.HasPrefix(path, "/api/session/") && strings.HasSuffix(path, "/browser/start")) ||
+				strings.HasPrefix(path, "/api/autocomplete/")) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -739,7 +846,7 @@ func authMiddleware(next http.Handler, secret string) http.Handler {
 		// open/xdg-open shims, which are non-browser clients with no auth
 		// cookie. Authorize it with the per-session MCP key scoped to the path
 		// UUID instead -- same scheme as /api/autocomplete and /browser/start.
-		if uuid, ok := proxyOpenControlPath(path); ok {
+		if uuid, ok := proxyOpenControlPath(path); ok && !onPreviewHost {
 			if sessionKeyMatchesPath(r, uuid) {
 				next.ServeHTTP(w, r)
 			} else {
@@ -752,7 +859,7 @@ func authMiddleware(next http.Handler, secret string) http.Handler {
 		// native agent MCP client) -- headless clients with no auth cookie.
 		// Authorize with the per-session MCP key scoped to the path UUID, same
 		// scheme as the open-URL control endpoint above.
-		if uuid, ok := proxyPreviewMCPPath(path); ok {
+		if uuid, ok := proxyPreviewMCPPath(path); ok && !onPreviewHost {
 			if sessionKeyMatchesPath(r, uuid) {
 				next.ServeHTTP(w, r)
 			} else {
@@ -763,10 +870,10 @@ func authMiddleware(next http.Handler, secret string) http.Handler {
 
 		// Check session cookie
 		cookie, err := r.Cookie(authCookieName)
-		var scope string
+		var scope, user string
 		if err == nil {
 			var valid bool
-			scope, valid = authVerifyCookieScoped(cookie.Value, secret)
+			scope, _, user, valid = authVerifyCookieUser(cookie.Value, secret)
 			if !valid {
 				err = http.ErrNoCookie
 			}
@@ -778,6 +885,16 @@ func authMiddleware(next http.Handler, secret string) http.Handler {
 				redirectURI = "/"
 			}
 			loginURL := "/swe-swe-auth/login?redirect=" + url.QueryEscape(redirectURI)
+			if strings.HasPrefix(path, "/ws/") && path != adminEventsPath && !onPreviewHost && websocket.IsWebSocketUpgrade(r) {
+				// Log back in to the session page, not its WebSocket.
+				pageURI := "/session/" + strings.TrimPrefix(path, "/ws/")
+				if r.URL.RawQuery != "" {
+					pageURI += "?" + r.URL.RawQuery
+				}
+				loginURL = "/swe-swe-auth/login?redirect=" + url.QueryEscape(pageURI)
+				writeWSAuthRequired(w, r, loginURL)
+				return
+			}
 			http.Redirect(w, r, loginURL, http.StatusFound)
 			return
 		}
@@ -788,7 +905,7 @@ func authMiddleware(next http.Handler, secret string) http.Handler {
 		// session; every other request must resolve to that session or be a
 		// UUID-less asset. See scopedRequestAllowed.
 		if scope != "" {
-			if path == "/" {
+			if path == "/" && !onPreviewHost {
 				target, ok := scopedHomeTarget(scope)
 				if !ok {
 					// Session ended: the share password died with it, so there
@@ -804,6 +921,23 @@ func authMiddleware(next http.Handler, secret string) http.Handler {
 				http.Error(w, "forbidden", http.StatusForbidden)
 				return
 			}
+			// View-only share: reads only. The session WebSocket is fine, its
+			// handler drops a viewer's input.
+			if !shareViewOnlyAllowed(requestShareLink(r), r.Method, false) {
+				http.Error(w, "forbidden: view-only share", http.StatusForbidden)
+				return
+			}
+		} else {
+			// Everyone else is held to their role (auth_roles.go). On a
+			// preview subdomain no path is ours, so only the method counts.
+			rolePath := path
+			if onPreviewHost {
+				rolePath = ""
+			}
+			if id := identityFor(user); !id.allowsRequest(r.Method, rolePath, false) {
+				http.Error(w, "forbidden: not allowed for role "+id.Role, http.StatusForbidden)
+				return
+			}
 		}
 
 		next.ServeHTTP(w, r)
@@ -852,11 +986,26 @@ func requireAuthCookie(secret string, authorized func(scope string) bool, next h
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		scope, valid := authVerifyCookieScoped(cookie.Value, secret)
+		scope, shareID, user, valid := authVerifyCookieUser(cookie.Value, secret)
 		if !valid || !authorized(scope) {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		// Likewise a user whose role has no input (auth_roles.go).
+		if scope == "" {
+			if id := identityFor(user); !id.allowsRequest(r.Method, "", websocket.IsWebSocketUpgrade(r)) {
+				http.Error(w, "forbidden: not allowed for role "+id.Role, http.StatusForbidden)
+				return
+			}
+		}
+		// A view-only share guest gets reads only, and no WebSocket: these
+		// listeners forward it unfiltered (agent chat input, VNC input).
+		if shareID != "" {
+			if l, _ := lookupShareLink(scope, shareID); !shareViewOnlyAllowed(l, r.Method, websocket.IsWebSocketUpgrade(r)) {
+				http.Error(w, "forbidden: view-only share", http.StatusForbidden)
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -904,7 +1053,9 @@ func setupEmbeddedAuth(password string) http.Handler {
 	http.HandleFunc("/swe-swe-auth/login", authLoginHandler(password))
 	http.HandleFunc("/swe-swe-auth/logout", authLogoutHandler())
 	http.HandleFunc("/swe-swe-auth/verify", authVerifyHandler(password))
+	http.HandleFunc("/swe-swe-auth/share", authShareHandler(password))
+	http.HandleFunc(recordingSharePrefix, recordingShareHandler(password))
 
 	// Wrap default mux with auth middleware
-	return authMiddleware(http.DefaultServeMux, password)
+	return authMiddleware(previewDomainRouter(http.DefaultServeMux), password)
 }
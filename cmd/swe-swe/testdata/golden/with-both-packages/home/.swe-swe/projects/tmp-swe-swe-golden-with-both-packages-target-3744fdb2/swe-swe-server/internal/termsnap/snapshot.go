@@ -0,0 +1,96 @@
+package termsnap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+
+	"github.com/hinshun/vt10x"
+)
+
+// Grid is the part of a terminal emulator a snapshot reads;
+// vt10x.Terminal is one.
+type Grid interface {
+	Size() (cols, rows int)
+	Cell(x, y int) vt10x.Glyph
+	Cursor() vt10x.Cursor
+}
+
+// ANSI returns escape sequences that clear a terminal and redraw g's screen
+// on it -- characters and 256-color palette colors -- leaving the cursor
+// where g's is.
+func ANSI(g Grid) []byte {
+	var buf bytes.Buffer
+	cols, rows := g.Size()
+	buf.Grow(cols*rows + 64)
+
+	buf.WriteString("\x1b[2J") // clear entire screen
+	buf.WriteString("\x1b[H")  // cursor to home (1,1)
+
+	// Track current attributes to minimize escape sequences
+	lastFG, lastBG := vt10x.DefaultFG, vt10x.DefaultBG
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			cell := g.Cell(col, row)
+			if cell.FG != lastFG || cell.BG != lastBG {
+				buf.WriteString("\x1b[0m") // reset attributes
+				if cell.FG != vt10x.DefaultFG && cell.FG < 256 {
+					fmt.Fprintf(&buf, "\x1b[38;5;%dm", cell.FG)
+				}
+				if cell.BG != vt10x.DefaultBG && cell.BG < 256 {
+					fmt.Fprintf(&buf, "\x1b[48;5;%dm", cell.BG)
+				}
+				lastFG, lastBG = cell.FG, cell.BG
+			}
+			if cell.Char == 0 {
+				buf.WriteByte(' ')
+			} else {
+				buf.WriteRune(cell.Char)
+			}
+		}
+		// Move to next line (except for last row)
+		if row < rows-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+
+	buf.WriteString("\x1b[0m")
+	cursor := g.Cursor()
+	fmt.Fprintf(&buf, "\x1b[%d;%dH", cursor.Y+1, cursor.X+1)
+	return buf.Bytes()
+}
+
+// Text returns g's screen as plain text, one line per row with trailing
+// blanks trimmed.
+func Text(g Grid) string {
+	cols, rows := g.Size()
+	var b strings.Builder
+	b.Grow((cols + 1) * rows)
+	line := make([]rune, cols)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			ch := g.Cell(x, y).Char
+			if ch == 0 {
+				ch = ' '
+			}
+			line[x] = ch
+		}
+		b.WriteString(strings.TrimRight(string(line), " "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Compress gzips a snapshot or scrollback for sending.
+func Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -434,17 +434,12 @@ class McpBridge {
     });
 
     // swe-swe-preview: agent-reverse-proxy MCP served by swe-swe-server at
-    // /proxy/<uuid>/preview/mcp. authMiddleware key-exempts this path (via
-    // proxyPreviewMCPPath + sessionKeyMatchesPath), so the per-session
-    // MCP_AUTH_KEY authorizes it -- same scheme as /mcp and browser/start.
-    // Requires SESSION_UUID; without it we can't build the scoped path.
-    if (sessionUuid) {
+    // /mcp/preview. The server routes it to the preview of the session that
+    // owns MCP_AUTH_KEY, so there is no session UUID to guess here.
+    if (sweAuthKey) {
       endpoints.push({
         name: "swe-swe-preview",
-        client: new HttpMcpClient(
-          "swe-swe-preview",
-          `http://localhost:${sweServerPort}/proxy/${sessionUuid}/preview/mcp${sweAuthKey ? `?key=${sweAuthKey}` : ""}`,
-        ),
+        client: new HttpMcpClient("swe-swe-preview", `http://localhost:${sweServerPort}/mcp/preview?key=${sweAuthKey}`),
       });
     }
 
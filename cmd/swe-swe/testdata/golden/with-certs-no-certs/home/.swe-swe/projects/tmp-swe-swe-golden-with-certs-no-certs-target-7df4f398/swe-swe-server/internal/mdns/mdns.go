@@ -0,0 +1,392 @@
+// Package mdns advertises and finds swe-swe servers on the local network
+// with multicast DNS (RFC 6762) and DNS-SD (RFC 6763), under the service type
+// _swe-swe._tcp. It speaks only what that needs -- PTR, SRV, TXT and A
+// records over IPv4 -- with the standard library alone, so the server and
+// the swe-swe CLI can both carry it.
+//
+// The canonical copy lives in internal/mdns with its tests; the server bundles
+// a non-test copy under swe-swe-server/internal/mdns (make check-mdns-sync).
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service is the DNS-SD service name swe-swe servers register under.
+const Service = "_swe-swe._tcp.local."
+
+// servicesName lists every service type on the link (RFC 6763 section 9).
+const servicesName = "_services._dns-sd._udp.local."
+
+// ttl is how long others may cache our records, in seconds. Legacy unicast
+// answers are capped at 10 (RFC 6762 section 6.7).
+const (
+	ttl       = 120
+	legacyTTL = 10
+)
+
+var groupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Instance is one advertised server.
+type Instance struct {
+	Name  string   // instance label, e.g. "swe-swe on laptop"
+	Host  string   // host name, e.g. "laptop.local."
+	Port  int      // HTTP port
+	Addrs []net.IP // IPv4 addresses
+	Text  []string // TXT "key=value" strings
+}
+
+// URL is the address to open the instance at, on its first address.
+func (in Instance) URL() string {
+	host := strings.TrimSuffix(in.Host, ".")
+	if len(in.Addrs) > 0 {
+		host = in.Addrs[0].String()
+	}
+	return fmt.Sprintf("http://%s/", net.JoinHostPort(host, fmt.Sprint(in.Port)))
+}
+
+// TextValue returns the value of the TXT string key=value.
+func (in Instance) TextValue(key string) string {
+	for _, t := range in.Text {
+		if k, v, ok := strings.Cut(t, "="); ok && strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (in Instance) fqdn() string {
+	return in.Name + "." + Service
+}
+
+// Label makes s usable as an instance or host label: no dots, at most 63
+// bytes.
+func Label(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ".", "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	if s == "" {
+		s = "swe-swe"
+	}
+	return s
+}
+
+// HostName is this machine's mDNS host name, e.g. "laptop.local.".
+func HostName() string {
+	h, _ := os.Hostname()
+	h, _, _ = strings.Cut(h, ".")
+	return Label(h) + ".local."
+}
+
+// LocalAddrs returns the IPv4 addresses of this machine's up, non-loopback,
+// multicast-capable interfaces: the ones a LAN peer can reach.
+func LocalAddrs() []net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.To4() != nil && !ipn.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipn.IP.To4())
+			}
+		}
+	}
+	return ips
+}
+
+// records returns the PTR, SRV, TXT and A records for in, with ttl t.
+func (in Instance) records(t uint32) (ptr record, rest []record) {
+	ptr = record{name: Service, rtype: typePTR, class: classIN, ttl: t, target: in.fqdn()}
+	rest = []record{
+		{name: in.fqdn(), rtype: typeSRV, class: classIN | topBit, ttl: t, target: in.Host, port: uint16(in.Port)},
+		{name: in.fqdn(), rtype: typeTXT, class: classIN | topBit, ttl: t, txt: in.Text},
+	}
+	for _, ip := range in.Addrs {
+		rest = append(rest, record{name: in.Host, rtype: typeA, class: classIN | topBit, ttl: t, a: ip})
+	}
+	return ptr, rest
+}
+
+// answer builds the reply to query, or nil when it asks nothing about in.
+// Matching records are answers; the rest of in's records ride along as
+// additional records so a browser needs one round trip.
+func (in Instance) answer(query *message, legacy bool) *message {
+	t := uint32(ttl)
+	if legacy {
+		t = legacyTTL
+	}
+	ptr, rest := in.records(t)
+	all := append([]record{ptr}, rest...)
+	var answers []record
+	for _, q := range query.questions {
+		for _, r := range all {
+			if strings.EqualFold(q.name, r.name) && (q.qtype == r.rtype || q.qtype == typeANY) {
+				answers = append(answers, r)
+			}
+		}
+		if strings.EqualFold(q.name, servicesName) && (q.qtype == typePTR || q.qtype == typeANY) {
+			answers = append(answers, record{name: servicesName, rtype: typePTR, class: classIN, ttl: t, target: Service})
+		}
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+	reply := &message{response: true, answers: len(answers), records: answers}
+	for _, r := range all {
+		if !containsRecord(answers, r) {
+			reply.records = append(reply.records, r)
+		}
+	}
+	if legacy {
+		// A legacy querier matches the reply by id and question, and is no
+		// mDNS cache to flush.
+		reply.id = query.id
+		reply.questions = query.questions
+		for i := range reply.records {
+			reply.records[i].class &^= topBit
+		}
+	}
+	return reply
+}
+
+func containsRecord(rs []record, r record) bool {
+	for _, x := range rs {
+		if x.rtype == r.rtype && strings.EqualFold(x.name, r.name) && x.a.Equal(r.a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Responder advertises one Instance until closed.
+type Responder struct {
+	in   Instance
+	conn *net.UDPConn
+	once sync.Once
+	done chan struct{}
+}
+
+// Advertise starts answering queries for in on the mDNS group and announces
+// it. Close withdraws it.
+func Advertise(in Instance) (*Responder, error) {
+	if in.Port <= 0 || in.Port > 65535 {
+		return nil, fmt.Errorf("mdns: bad port %d", in.Port)
+	}
+	in.Name, in.Host = Label(in.Name), strings.ToLower(in.Host)
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		return nil, fmt.Errorf("mdns: listen %s: %w", groupAddr, err)
+	}
+	r := &Responder{in: in, conn: conn, done: make(chan struct{})}
+	go r.serve()
+	go r.announce()
+	return r, nil
+}
+
+// announce sends the records unasked, twice a second apart (RFC 6762
+// section 8.3), so browsers already listening see the server at once.
+func (r *Responder) announce() {
+	for i := 0; i < 2; i++ {
+		r.sendAll(ttl)
+		select {
+		case <-r.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (r *Responder) sendAll(t uint32) {
+	ptr, rest := r.in.records(t)
+	m := &message{response: true, answers: 1 + len(rest), records: append([]record{ptr}, rest...)}
+	if b, err := m.encode(); err == nil {
+		r.conn.WriteToUDP(b, groupAddr)
+	}
+}
+
+func (r *Responder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				log.Printf("mdns: read: %v", err)
+			}
+			return
+		}
+		query, err := parse(buf[:n])
+		if err != nil || query.response {
+			continue
+		}
+		// Queries not from port 5353 come from simple resolvers (and `swe-swe
+		// discover`) that only hear a unicast reply to their own port.
+		legacy := src.Port != groupAddr.Port
+		reply := r.in.answer(query, legacy)
+		if reply == nil {
+			continue
+		}
+		b, err := reply.encode()
+		if err != nil {
+			continue
+		}
+		dst := groupAddr
+		if legacy || unicastAsked(query) {
+			dst = src
+		}
+		r.conn.WriteToUDP(b, dst)
+	}
+}
+
+func unicastAsked(m *message) bool {
+	for _, q := range m.questions {
+		if q.qclass&topBit != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Close sends a goodbye (the records with TTL 0) and stops answering.
+func (r *Responder) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.done)
+		r.sendAll(0)
+		err = r.conn.Close()
+	})
+	return err
+}
+
+// Browse asks the link for swe-swe servers and collects the answers that
+// arrive within wait (or until ctx ends), sorted by name.
+func Browse(ctx context.Context, wait time.Duration) ([]Instance, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query, err := (&message{questions: []question{{name: Service, qtype: typePTR, qclass: classIN}}}).encode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(query, groupAddr); err != nil {
+		return nil, fmt.Errorf("mdns: query: %w", err)
+	}
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	var c collector
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		if m, err := parse(buf[:n]); err == nil && m.response {
+			c.add(m, src.IP)
+		}
+	}
+	return c.instances(), nil
+}
+
+// collector assembles instances from the records of any number of replies.
+type collector struct {
+	names []string            // instance names, in PTR order
+	srv   map[string]record   // by instance name
+	txt   map[string][]string // by instance name
+	addrs map[string][]net.IP // by host name
+	from  map[string]net.IP   // by instance name: the reply's source
+	seen  map[string]bool     // by instance name
+}
+
+func (c *collector) add(m *message, src net.IP) {
+	if c.seen == nil {
+		c.srv, c.txt, c.addrs = map[string]record{}, map[string][]string{}, map[string][]net.IP{}
+		c.from, c.seen = map[string]net.IP{}, map[string]bool{}
+	}
+	for _, r := range m.records {
+		name := strings.ToLower(r.name)
+		switch r.rtype {
+		case typePTR:
+			target := strings.ToLower(r.target)
+			if name == Service && strings.HasSuffix(target, "."+Service) && !c.seen[target] && r.ttl > 0 {
+				c.seen[target] = true
+				c.names = append(c.names, r.target)
+				c.from[target] = src
+			}
+		case typeSRV:
+			c.srv[name] = r
+		case typeTXT:
+			c.txt[name] = r.txt
+		case typeA:
+			if !containsIP(c.addrs[name], r.a) {
+				c.addrs[name] = append(c.addrs[name], r.a)
+			}
+		}
+	}
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, x := range ips {
+		if x.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *collector) instances() []Instance {
+	var out []Instance
+	for _, full := range c.names {
+		key := strings.ToLower(full)
+		srv, ok := c.srv[key]
+		if !ok {
+			continue
+		}
+		in := Instance{
+			Name: full[:len(full)-len("."+Service)],
+			Host: srv.target,
+			Port: int(srv.port),
+			Text: c.txt[key],
+		}
+		// Prefer the address the reply came from: it is one we can reach.
+		if src := c.from[key]; src != nil {
+			in.Addrs = append(in.Addrs, src)
+		}
+		for _, ip := range c.addrs[strings.ToLower(srv.target)] {
+			if !containsIP(in.Addrs, ip) {
+				in.Addrs = append(in.Addrs, ip)
+			}
+		}
+		out = append(out, in)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,291 @@
+// ratelimit.go -- per-client, per-route token buckets for the expensive APIs.
+//
+// A few endpoints do real work per request: /api/repo/prepare clones or
+// fetches, /api/recording/{uuid}/download builds a zip, /api/session/{uuid}/end
+// tears a session down, /api/exec spawns a process. A buggy client (a retry
+// loop, a stuck polling tab) can hammer them. rateLimitMiddleware sits in
+// front of the whole mux and gives each (route class, client) pair a token
+// bucket; an empty bucket gets 429 with Retry-After.
+//
+// The client is the same key the login limiter uses (loginThrottleKey): the
+// peer address, or the address a trusted proxy forwarded (client_ip.go).
+// Behind Traefik without SWE_TRUSTED_PROXIES every user shares one bucket per
+// class, which the defaults are sized for.
+//
+// SWE_RATE_LIMITS overrides the defaults per class: "repo=10/m:5,exec=off"
+// (count per s/m/h, optional burst after the colon; burst defaults to the
+// count capped at 20). "off" on its own disables rate limiting.
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit is a token-bucket rate: Rate tokens per second, up to Burst.
+type rateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// rateRoute assigns a request path to a limit class.
+type rateRoute struct {
+	Class string
+	Match func(path string) bool
+}
+
+// rateRoutes is checked in order; the first match wins. Unmatched requests
+// (pages, assets, WebSockets, cheap APIs) are never limited.
+var rateRoutes = []rateRoute{
+	{"repo", func(p string) bool { return p == "/api/repo/prepare" || p == "/api/repo/branches" }},
+	{"recording-download", func(p string) bool {
+		return (strings.HasPrefix(p, "/api/recording/") && strings.HasSuffix(p, "/download")) ||
+			(strings.HasPrefix(p, "/api/session/") && strings.HasSuffix(p, "/export"))
+	}},
+	{"recording", func(p string) bool { return strings.HasPrefix(p, "/api/recording/") }},
+	{"session-end", func(p string) bool { return strings.HasPrefix(p, "/api/session/") && strings.HasSuffix(p, "/end") }},
+	{"session-create", func(p string) bool { return p == "/api/session/new" || strings.HasPrefix(p, "/api/fork/") }},
+	{"exec", func(p string) bool {
+		return p == "/api/exec" || (strings.HasPrefix(p, "/api/worktree/") && strings.HasSuffix(p, "/publish"))
+	}},
+}
+
+// defaultRateLimits are per client per class. Generous for a person clicking
+// around, tight for a loop.
+var defaultRateLimits = map[string]rateLimit{
+	"repo":               {Rate: 30.0 / 60, Burst: 10},
+	"recording-download": {Rate: 10.0 / 60, Burst: 5},
+	"recording":          {Rate: 120.0 / 60, Burst: 30},
+	"session-end":        {Rate: 30.0 / 60, Burst: 10},
+	"session-create":     {Rate: 30.0 / 60, Burst: 10},
+	"exec":               {Rate: 60.0 / 60, Burst: 10},
+}
+
+// rateLimits is the effective table; a class missing from it is unlimited.
+// Set by loadRateLimits.
+var rateLimits = defaultRateLimits
+
+// loadRateLimits applies SWE_RATE_LIMITS on top of the defaults. Empty keeps
+// the defaults (so docker-compose can pass the variable through
+// unconditionally); "off" disables every class.
+func loadRateLimits() error {
+	v := strings.TrimSpace(os.Getenv("SWE_RATE_LIMITS"))
+	if v == "" {
+		return nil
+	}
+	limits, err := parseRateLimits(v, defaultRateLimits)
+	if err != nil {
+		return err
+	}
+	rateLimits = limits
+	if len(limits) == 0 {
+		log.Printf("Rate limiting disabled (SWE_RATE_LIMITS=off)")
+	} else {
+		log.Printf("Rate limits from SWE_RATE_LIMITS: %s", formatRateLimits(limits))
+	}
+	return nil
+}
+
+// parseRateLimits parses "class=N/unit[:burst],class=off" into a copy of base
+// with those classes replaced or removed. "off" alone returns an empty table.
+func parseRateLimits(s string, base map[string]rateLimit) (map[string]rateLimit, error) {
+	if strings.TrimSpace(s) == "off" {
+		return map[string]rateLimit{}, nil
+	}
+	out := make(map[string]rateLimit, len(base))
+	for k, v := range base {
+		out[k] = v
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		class, spec, ok := strings.Cut(item, "=")
+		class, spec = strings.TrimSpace(class), strings.TrimSpace(spec)
+		if !ok || class == "" {
+			return nil, fmt.Errorf("invalid rate limit %q (want class=N/unit[:burst])", item)
+		}
+		if _, known := defaultRateLimits[class]; !known {
+			return nil, fmt.Errorf("unknown rate limit class %q (known: %s)", class, strings.Join(rateClassNames(), ", "))
+		}
+		if spec == "off" {
+			delete(out, class)
+			continue
+		}
+		l, err := parseRateSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %s: %v", class, err)
+		}
+		out[class] = l
+	}
+	return out, nil
+}
+
+// parseRateSpec parses "N/unit[:burst]" with unit s, m or h.
+func parseRateSpec(spec string) (rateLimit, error) {
+	rate, burstStr, hasBurst := strings.Cut(spec, ":")
+	countStr, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return rateLimit{}, fmt.Errorf("invalid rate %q (want N/s, N/m or N/h)", spec)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(countStr))
+	if err != nil || count <= 0 {
+		return rateLimit{}, fmt.Errorf("invalid count in %q", spec)
+	}
+	var per time.Duration
+	switch strings.TrimSpace(unit) {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return rateLimit{}, fmt.Errorf("invalid unit in %q (want s, m or h)", spec)
+	}
+	burst := min(count, 20)
+	if hasBurst {
+		burst, err = strconv.Atoi(strings.TrimSpace(burstStr))
+		if err != nil || burst <= 0 {
+			return rateLimit{}, fmt.Errorf("invalid burst in %q", spec)
+		}
+	}
+	return rateLimit{Rate: float64(count) / per.Seconds(), Burst: float64(burst)}, nil
+}
+
+func rateClassNames() []string {
+	names := make([]string, 0, len(defaultRateLimits))
+	for k := range defaultRateLimits {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatRateLimits renders the table as "class=N/m:burst" for the startup log.
+func formatRateLimits(limits map[string]rateLimit) string {
+	var parts []string
+	for _, class := range rateClassNames() {
+		if l, ok := limits[class]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%g/m:%g", class, l.Rate*60, l.Burst))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// rateClass returns the limit class for path, or "".
+func rateClass(path string) string {
+	for _, rt := range rateRoutes {
+		if rt.Match(path) {
+			return rt.Class
+		}
+	}
+	return ""
+}
+
+// tokenBucket is one (class, client) bucket.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// limited is set on the first rejection and cleared by the next allowed
+	// request, so a hammering client logs once per episode, not per request.
+	limited bool
+}
+
+// rateLimiter holds every live bucket.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // "class|client"
+	now     func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// allow takes a token from the (class, client) bucket. When the bucket is
+// empty it returns false, the wait until the next token, and whether this is
+// the first rejection since the client was last allowed.
+func (rl *rateLimiter) allow(class, client string, l rateLimit) (ok bool, retryAfter time.Duration, first bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	key := class + "|" + client
+	b := rl.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: l.Burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(l.Burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, 0, false
+	}
+	first = !b.limited
+	b.limited = true
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second)), first
+}
+
+// cleanup drops buckets that have refilled completely: a fresh bucket would
+// be identical, so they carry no state worth keeping.
+func (rl *rateLimiter) cleanup() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	for key, b := range rl.buckets {
+		class, _, _ := strings.Cut(key, "|")
+		l, ok := rateLimits[class]
+		if !ok || b.tokens+now.Sub(b.last).Seconds()*l.Rate >= l.Burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// apiRateLimiter is the process-wide limiter used by rateLimitMiddleware.
+var apiRateLimiter = newRateLimiter()
+
+// rateLimitMiddleware enforces rateLimits in front of next. With every class
+// disabled it returns next unchanged.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	if len(rateLimits) == 0 {
+		return next
+	}
+	go func() {
+		defer recoverGoroutine("rate limiter cleanup")
+		for {
+			time.Sleep(time.Minute)
+			apiRateLimiter.cleanup()
+		}
+	}()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := rateClass(r.URL.Path)
+		l, limited := rateLimits[class]
+		if class == "" || !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := loginThrottleKey(r)
+		ok, wait, first := apiRateLimiter.allow(class, client, l)
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secs := int(math.Ceil(wait.Seconds()))
+		if first {
+			requestLogger(r, "ratelimit").Warn("rate limited", "class", class, "client", client, "path", r.URL.Path, "retryAfter", secs)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		http.Error(w, fmt.Sprintf("Too many requests; retry in %d seconds", secs), http.StatusTooManyRequests)
+	})
+}
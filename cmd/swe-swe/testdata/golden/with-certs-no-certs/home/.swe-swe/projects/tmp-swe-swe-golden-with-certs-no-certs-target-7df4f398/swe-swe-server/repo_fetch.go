@@ -0,0 +1,275 @@
+// repo_fetch.go -- fetch every repo in the background and say when branches
+// move.
+//
+// Behind counts (repo_status.go) are only as fresh as the last fetch, and a
+// worktree session never learns that the branch it was cut from moved on.
+// With -fetch-interval (SWE_FETCH_INTERVAL, e.g. 15m; off by default) the
+// server runs `git fetch --prune origin` in the workspace and in each clone
+// under the repos directory, one repo at a time, and compares origin's
+// branches before and after. For each branch that moved:
+//
+//   - /ws/admin gets a branch_moved event (repo, branch, from, to, commits).
+//   - Each live worktree session of that repo whose base branch (the one
+//     checked out in the main working tree, as in worktree_conflicts.go) is
+//     that branch gets a base_branch_moved message with how many of the new
+//     commits it lacks, so the page can suggest a rebase.
+//   - The repo's .swe-swe/hooks/on-branch-moved runs, if it has one, in the
+//     repo with SWE_BRANCH, SWE_BRANCH_FROM, SWE_BRANCH_TO and
+//     SWE_BRANCH_COMMITS (see session_hooks.go for timeouts and lookup).
+//
+// New and deleted branches are not moves. Fetches never prompt for
+// credentials; a fetch that fails is recorded with its error and retried on
+// the next pass. GET /api/repos/fetch reports the last result per repo;
+// POST fetches now.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// repoFetchTimeout bounds one repo's fetch.
+const repoFetchTimeout = 2 * time.Minute
+
+// repoFetchInterval is -fetch-interval / SWE_FETCH_INTERVAL; 0 is off.
+var repoFetchInterval time.Duration
+
+// loadRepoFetchInterval applies -fetch-interval or SWE_FETCH_INTERVAL: a Go
+// duration of at least a minute, or "off"/"0"/empty for no fetching.
+func loadRepoFetchInterval(v string) error {
+	v = strings.TrimSpace(v)
+	switch strings.ToLower(v) {
+	case "", "0", "off":
+		repoFetchInterval = 0
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Minute {
+		return fmt.Errorf("SWE_FETCH_INTERVAL=%q: want a duration of at least 1m, or off", v)
+	}
+	repoFetchInterval = d
+	return nil
+}
+
+// branchMove is one origin branch that a fetch moved.
+type branchMove struct {
+	Branch  string `json:"branch"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Commits int    `json:"commits"` // new commits on the branch; 0 after a force push
+}
+
+// repoFetchResult is what the last fetch of one repo did.
+type repoFetchResult struct {
+	Repo     string       `json:"repo"`
+	At       time.Time    `json:"at"`
+	Duration string       `json:"duration"`
+	Error    string       `json:"error,omitempty"`
+	Moved    []branchMove `json:"moved,omitempty"`
+}
+
+var repoFetchState = struct {
+	mu      sync.Mutex
+	running sync.Mutex // one pass at a time
+	results map[string]repoFetchResult
+	lastRun time.Time
+}{results: map[string]repoFetchResult{}}
+
+// repoFetcher fetches every repoFetchInterval, when set.
+func repoFetcher() {
+	if repoFetchInterval <= 0 {
+		return
+	}
+	defer recoverGoroutine("repo fetcher")
+	log.Printf("Fetching repos every %s", repoFetchInterval)
+	ticker := time.NewTicker(repoFetchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		fetchAllRepos()
+	}
+}
+
+// fetchAllRepos fetches each repo in turn and reports what moved.
+func fetchAllRepos() []repoFetchResult {
+	repoFetchState.running.Lock()
+	defer repoFetchState.running.Unlock()
+	var results []repoFetchResult
+	for _, path := range statusRepoPaths() {
+		res := fetchRepo(path)
+		results = append(results, res)
+		if res.Error != "" {
+			log.Printf("Repo fetch %s: %s", path, res.Error)
+		}
+		for _, m := range res.Moved {
+			announceBranchMove(path, m)
+		}
+		repoStatusCache.mu.Lock()
+		delete(repoStatusCache.entries, path)
+		repoStatusCache.mu.Unlock()
+	}
+	repoFetchState.mu.Lock()
+	repoFetchState.results = map[string]repoFetchResult{}
+	for _, res := range results {
+		repoFetchState.results[res.Repo] = res
+	}
+	repoFetchState.lastRun = time.Now()
+	repoFetchState.mu.Unlock()
+	return results
+}
+
+// originBranches returns origin's branches in path and the commit each is at.
+func originBranches(path string) map[string]string {
+	out, err := gitInWorkDir(path, "for-each-ref", "--format=%(refname:lstrip=3) %(objectname)", "refs/remotes/origin/")
+	if err != nil {
+		return nil
+	}
+	refs := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if branch, sha, ok := strings.Cut(line, " "); ok && branch != "HEAD" {
+			refs[branch] = sha
+		}
+	}
+	return refs
+}
+
+// fetchRepo fetches origin in path and compares its branches before and after.
+func fetchRepo(path string) repoFetchResult {
+	res := repoFetchResult{Repo: path, At: time.Now()}
+	before := originBranches(path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), repoFetchTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "-C", path, "fetch", "--prune", "--quiet", "origin")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	res.Duration = time.Since(res.At).Round(time.Millisecond).String()
+	if err != nil {
+		res.Error = strings.TrimSpace(err.Error() + ": " + string(bytes.TrimSpace(out)))
+		return res
+	}
+
+	after := originBranches(path)
+	for branch, to := range after {
+		from, ok := before[branch]
+		if !ok || from == to {
+			continue
+		}
+		m := branchMove{Branch: branch, From: from, To: to}
+		if n, err := gitInWorkDir(path, "rev-list", "--count", from+".."+to); err == nil {
+			m.Commits, _ = strconv.Atoi(strings.TrimSpace(n))
+		}
+		res.Moved = append(res.Moved, m)
+	}
+	sort.Slice(res.Moved, func(i, j int) bool { return res.Moved[i].Branch < res.Moved[j].Branch })
+	return res
+}
+
+// announceBranchMove publishes m, tells the worktree sessions based on the
+// branch, and runs the repo's on-branch-moved hook.
+func announceBranchMove(repo string, m branchMove) {
+	log.Printf("Repo fetch %s: origin/%s moved %s..%s (%d commits)", repo, m.Branch, shortSHA(m.From), shortSHA(m.To), m.Commits)
+	publishAdminEvent(adminEvent{Type: "branch_moved", Repo: repo, Branch: m.Branch, From: m.From, To: m.To, Commits: m.Commits})
+
+	sessionsMu.RLock()
+	var based []*Session
+	for _, sess := range sessions {
+		if sess.ParentUUID == "" && isWorktreeWorkDir(sess.WorkDir) {
+			based = append(based, sess)
+		}
+	}
+	sessionsMu.RUnlock()
+	for _, sess := range based {
+		mainTree, err := mainWorktree(sess.WorkDir)
+		if err != nil || filepath.Clean(mainTree) != filepath.Clean(repo) {
+			continue
+		}
+		base, err := gitInWorkDir(mainTree, "branch", "--show-current")
+		if err != nil || strings.TrimSpace(base) != m.Branch {
+			continue
+		}
+		behind, err := gitInWorkDir(sess.WorkDir, "rev-list", "--count", "HEAD.."+m.To)
+		n, _ := strconv.Atoi(strings.TrimSpace(behind))
+		if err != nil || n == 0 {
+			continue
+		}
+		sess.BroadcastJSON(map[string]any{
+			"type":       "base_branch_moved",
+			"baseBranch": m.Branch,
+			"upstream":   "origin/" + m.Branch,
+			"commits":    m.Commits,
+			"behind":     n,
+		})
+	}
+
+	hook, err := findSessionHook(repo, hookBranchMoved)
+	if err != nil {
+		log.Printf("Repo fetch %s: %v", repo, err)
+	}
+	if hook == "" {
+		return
+	}
+	env := append(os.Environ(),
+		"SWE_HOOK="+hookBranchMoved,
+		"SWE_BRANCH="+m.Branch,
+		"SWE_BRANCH_FROM="+m.From,
+		"SWE_BRANCH_TO="+m.To,
+		"SWE_BRANCH_COMMITS="+strconv.Itoa(m.Commits),
+	)
+	var out bytes.Buffer
+	res, err := runHook(hook, repo, env, &cappedWriter{w: &out, n: hookMaxOutputBytes}, hookTimeout)
+	if err != nil {
+		log.Printf("Repo fetch %s: %s: %v", repo, hookBranchMoved, err)
+		return
+	}
+	log.Printf("Repo fetch %s: %s exited %d in %s: %s", repo, hookBranchMoved, res.ExitCode, res.Elapsed.Round(time.Millisecond), strings.TrimSpace(out.String()))
+}
+
+// shortSHA abbreviates a commit hash for logs.
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+// handleRepoFetchAPI serves /api/repos/fetch: GET reports the last fetch of
+// each repo, POST fetches now and reports that.
+func handleRepoFetchAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		fetchAllRepos()
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repoFetchState.mu.Lock()
+	results := []repoFetchResult{}
+	for _, res := range repoFetchState.results {
+		results = append(results, res)
+	}
+	interval := "off"
+	if repoFetchInterval > 0 {
+		interval = repoFetchInterval.String()
+	}
+	resp := map[string]any{"interval": interval, "repos": results}
+	if !repoFetchState.lastRun.IsZero() {
+		resp["lastRun"] = repoFetchState.lastRun
+	}
+	repoFetchState.mu.Unlock()
+	sort.Slice(results, func(i, j int) bool { return results[i].Repo < results[j].Repo })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
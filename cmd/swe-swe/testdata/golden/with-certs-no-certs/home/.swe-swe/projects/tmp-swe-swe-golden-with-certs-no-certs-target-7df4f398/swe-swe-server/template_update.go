@@ -0,0 +1,296 @@
+// template_update.go -- apply a swe-swe template update to a repo.
+//
+// template_diff.go says what updating a repo's swe-swe files would do;
+// applyTemplateUpdate does it, so /swe-swe:update no longer dumps the
+// templates and merges each file by hand:
+//
+//	add, update   the template is written
+//	unchanged     nothing; the baseline is brought up to date if it is not
+//	keep          the repo's copy stays
+//	merge         baseline, repo copy and template are merged line by line
+//	              (mergeLines); where both sides changed the same lines the
+//	              file gets conflict markers, as git writes them:
+//
+//	                  <<<<<<< current
+//	                  the repo's lines
+//	                  =======
+//	                  the template's lines
+//	                  >>>>>>> swe-swe 2.14.0
+//
+//	ask           there is no baseline to merge from, so the repo's copy is
+//	              skipped unless the request names it in replace
+//
+// A path in replace is overwritten with the template whatever its action.
+// Every file written, merged or not, gets the template as its baseline, the
+// way the slash command left it, so the next update compares against this
+// one. With dryRun nothing is written and the results say what would be.
+//
+//	swe-swe-server --update-container-templates /workspace [--replace-templates a,b]
+//	POST /api/templates/update  {"path": "/repos/x/workspace", "replace": [".swe-swe/docs/AGENTS.md"], "dryRun": false}
+//	    -> {"path": "...", "conflicts": 1, "files": [{"path": ".swe-swe/docs/AGENTS.md",
+//	        "action": "merge", "result": "conflict", "conflicts": 1}, ...]}
+//
+// Results: added, updated, replaced, merged, conflict, kept, unchanged,
+// skipped. Writing needs the input permission, like any POST.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// templateUpdateMu serializes updates, so two cannot interleave their
+// writes to one repo.
+var templateUpdateMu sync.Mutex
+
+// templateResult is what an update did to one template in a repo.
+type templateResult struct {
+	Path      string `json:"path"`
+	Action    string `json:"action"` // from diffContainerTemplates
+	Result    string `json:"result"`
+	Conflicts int    `json:"conflicts,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// applyTemplateUpdate updates repoDir's swe-swe files to the embedded
+// templates. replace lists template paths to overwrite outright.
+func applyTemplateUpdate(repoDir string, replace []string, dryRun bool) ([]templateResult, error) {
+	templateUpdateMu.Lock()
+	defer templateUpdateMu.Unlock()
+	changes, err := diffContainerTemplates(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	overwrite := map[string]bool{}
+	for _, p := range replace {
+		overwrite[filepath.ToSlash(filepath.Clean(p))] = true
+	}
+
+	var results []templateResult
+	for _, c := range changes {
+		res := templateResult{Path: c.Path, Action: c.Action}
+		tmpl, err := containerTemplatesFS.ReadFile("container-templates/" + c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedded %s: %w", c.Path, err)
+		}
+		path := filepath.Join(repoDir, c.Path)
+		content := tmpl
+		write := true
+		switch {
+		case overwrite[c.Path] && c.Action != templateUnchanged:
+			res.Result = "replaced"
+		case c.Action == templateAdd:
+			res.Result = "added"
+		case c.Action == templateUpdate:
+			res.Result = "updated"
+		case c.Action == templateUnchanged:
+			res.Result, write = "unchanged", false
+		case c.Action == templateKeep:
+			res.Result, write = "kept", false
+		case c.Action == templateAsk:
+			res.Result, write = "skipped", false
+		case c.Action == templateMerge:
+			current, _, err1 := readOptionalFile(path)
+			baseline, _, err2 := readOptionalFile(filepath.Join(repoDir, ".swe-swe", "baseline", c.Path))
+			if err := firstError(err1, err2); err != nil {
+				res.Result, res.Error, write = "skipped", err.Error(), false
+				break
+			}
+			content, res.Conflicts = mergeLines(baseline, current, tmpl, "current", "swe-swe "+Version)
+			res.Result = "merged"
+			if res.Conflicts > 0 {
+				res.Result = "conflict"
+			}
+		}
+		if !dryRun && res.Error == "" {
+			if write {
+				if err := writeRepoFile(path, content); err != nil {
+					res.Error = err.Error()
+				}
+			}
+			// The baseline follows the template for every file the update
+			// accounts for; a skipped one keeps its old baseline (or none).
+			if res.Result != "skipped" && res.Error == "" {
+				base := filepath.Join(repoDir, ".swe-swe", "baseline", c.Path)
+				if old, ok, _ := readOptionalFile(base); !ok || !bytes.Equal(old, tmpl) {
+					if err := writeRepoFile(base, tmpl); err != nil {
+						res.Error = err.Error()
+					}
+				}
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// firstError returns the first non-nil error.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRepoFile writes a template or baseline file, creating its directory.
+func writeRepoFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return atomicWriteFile(path, data, 0644)
+}
+
+// writeTemplateResults prints results for --update-container-templates.
+func writeTemplateResults(w io.Writer, results []templateResult) {
+	for _, r := range results {
+		line := fmt.Sprintf("%-9s %s", r.Result, r.Path)
+		if r.Conflicts > 0 {
+			line += fmt.Sprintf(" (%d conflicts)", r.Conflicts)
+		}
+		if r.Error != "" {
+			line += ": " + r.Error
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// handleTemplatesUpdateAPI handles POST /api/templates/update.
+func handleTemplatesUpdateAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Path    string   `json:"path"`
+		Replace []string `json:"replace"`
+		DryRun  bool     `json:"dryRun"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	repoPath := req.Path
+	if repoPath == "" {
+		repoPath = workspaceDir
+	}
+	repoPath, err := policyRepoPath.Resolve(repoPath)
+	if err != nil {
+		http.Error(w, "Invalid repository path", http.StatusBadRequest)
+		return
+	}
+	if fi, err := os.Stat(repoPath); err != nil || !fi.IsDir() {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	results, err := applyTemplateUpdate(repoPath, req.Replace, req.DryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conflicts := 0
+	for _, res := range results {
+		conflicts += res.Conflicts
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"path": repoPath, "dryRun": req.DryRun, "conflicts": conflicts, "files": results})
+}
+
+// mergeLines merges the changes from base to ours and from base to theirs,
+// line by line (diff3). Where both changed the same lines differently it
+// writes both between conflict markers labelled ours and theirs, and counts
+// the conflict.
+func mergeLines(base, ours, theirs []byte, oursLabel, theirsLabel string) ([]byte, int) {
+	o, a, b := splitDiffLines(base), splitDiffLines(ours), splitDiffLines(theirs)
+	matchA, matchB := baseMatches(o, a), baseMatches(o, b)
+
+	var out strings.Builder
+	conflicts := 0
+	emit := func(lines []string) {
+		for _, l := range lines {
+			out.WriteString(l)
+		}
+	}
+	// emitBlock writes lines inside conflict markers, ending the last with
+	// a newline so the marker after it starts a line.
+	emitBlock := func(lines []string) {
+		emit(lines)
+		if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+			out.WriteByte('\n')
+		}
+	}
+	i, j, k := 0, 0, 0
+	for i < len(o) || j < len(a) || k < len(b) {
+		// Lines all three agree on pass through.
+		if i < len(o) && matchA[i] == j && matchB[i] == k {
+			out.WriteString(o[i])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+		// Otherwise the chunk runs up to the next base line both sides kept.
+		ni := i
+		for ni < len(o) && (matchA[ni] < 0 || matchB[ni] < 0) {
+			ni++
+		}
+		nj, nk := len(a), len(b)
+		if ni < len(o) {
+			nj, nk = matchA[ni], matchB[ni]
+		}
+		oc, ac, bc := o[i:ni], a[j:nj], b[k:nk]
+		switch {
+		case equalLines(ac, oc):
+			emit(bc)
+		case equalLines(bc, oc), equalLines(ac, bc):
+			emit(ac)
+		default:
+			conflicts++
+			out.WriteString("<<<<<<< " + oursLabel + "\n")
+			emitBlock(ac)
+			out.WriteString("=======\n")
+			emitBlock(bc)
+			out.WriteString(">>>>>>> " + theirsLabel + "\n")
+		}
+		i, j, k = ni, nj, nk
+	}
+	return []byte(out.String()), conflicts
+}
+
+// baseMatches maps each line of base to the line of other it is matched
+// with by diffLineOps, or -1.
+func baseMatches(base, other []string) []int {
+	m := make([]int, len(base))
+	i, j := 0, 0
+	for _, op := range diffLineOps(base, other) {
+		switch op.kind {
+		case ' ':
+			m[i] = j
+			i, j = i+1, j+1
+		case '-':
+			m[i] = -1
+			i++
+		case '+':
+			j++
+		}
+	}
+	return m
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
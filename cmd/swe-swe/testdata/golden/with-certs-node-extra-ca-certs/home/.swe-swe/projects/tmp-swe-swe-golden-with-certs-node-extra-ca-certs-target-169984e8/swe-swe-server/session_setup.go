@@ -0,0 +1,143 @@
+// session_setup.go -- run the repo's setup task before the agent starts.
+//
+// Repos often need "npm install && make generate" before an agent is useful.
+// A repo opts in by checking in an executable .swe-swe/hooks/setup (found the
+// same way as the session hooks, see session_hooks.go). When a new top-level
+// session starts in a working directory that has no .swe-swe/setup-done
+// marker, the setup task runs in the session's PTY ahead of the agent, so its
+// output streams to the terminal and into the recording like anything else
+// the session prints. Success writes the marker; failure leaves it absent (so
+// the next session retries) and the agent starts anyway.
+//
+// (The swe-swe/setup that ships as the /swe-swe:setup slash command is a
+// prompt for the agent, not a script -- it is not what runs here.)
+//
+// Session creation takes a setup mode: "" or "auto" (run when the marker is
+// absent), "skip" (never), "force" (run even when the marker is present).
+//
+// The task is prepended to the agent command line that wrapWithShell hands
+// to `bash -c`. The prefix therefore uses no $-expansions, backticks or
+// control characters, and paths that would need them are refused (the setup
+// is skipped with a log line).
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	setupHookName = "setup"
+	// setupMarker is relative to the working directory. Per working
+	// directory, so each worktree gets its own setup.
+	setupMarker = ".swe-swe/setup-done"
+
+	setupModeAuto  = "auto"
+	setupModeSkip  = "skip"
+	setupModeForce = "force"
+)
+
+// setupTimeout bounds the setup task. Set from SWE_SETUP_TIMEOUT by
+// loadSetupTimeout.
+var setupTimeout = 10 * time.Minute
+
+// loadSetupTimeout applies SWE_SETUP_TIMEOUT (a duration or seconds). Empty
+// keeps the default.
+func loadSetupTimeout() error {
+	v := strings.TrimSpace(os.Getenv("SWE_SETUP_TIMEOUT"))
+	if v == "" {
+		return nil
+	}
+	d, err := parseTimeoutSetting("SWE_SETUP_TIMEOUT", v)
+	if err != nil {
+		return err
+	}
+	setupTimeout = d
+	log.Printf("Setup task timeout from SWE_SETUP_TIMEOUT: %s", d)
+	return nil
+}
+
+// normalizeSetupMode validates a session-creation setup mode; "" means auto.
+func normalizeSetupMode(v string) (string, error) {
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "", setupModeAuto:
+		return setupModeAuto, nil
+	case setupModeSkip, setupModeForce:
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid setup mode %q (want auto, skip or force)", v)
+}
+
+// setupTaskPrefix returns the shell text to run ahead of the agent command in
+// workDir, or "" when there is nothing to run. mode is a setup mode as
+// accepted by normalizeSetupMode; an invalid one is treated as auto.
+func setupTaskPrefix(sessionUUID, workDir, mode string) string {
+	if mode, _ = normalizeSetupMode(mode); mode == setupModeSkip {
+		return ""
+	}
+	if workDir == "" {
+		workDir, _ = os.Getwd()
+	}
+	hook, err := findSessionHook(workDir, setupHookName)
+	if err != nil {
+		log.Printf("Session %s: setup task skipped: %v", sessionUUID, err)
+		return ""
+	}
+	if hook == "" {
+		return ""
+	}
+	marker := filepath.Join(workDir, setupMarker)
+	if mode != setupModeForce {
+		if _, err := os.Stat(marker); err == nil {
+			return ""
+		}
+	}
+	_, lookErr := exec.LookPath("timeout")
+	prefix, err := setupTaskCommand(hook, marker, setupTimeout, lookErr == nil)
+	if err != nil {
+		log.Printf("Session %s: setup task skipped: %v", sessionUUID, err)
+		return ""
+	}
+	log.Printf("Session %s: running setup task %s before the agent (mode %s, timeout %s)", sessionUUID, hook, mode, setupTimeout)
+	return prefix
+}
+
+// setupTaskCommand builds the shell prefix that runs hook with stdin closed,
+// writes marker on success, and reports the outcome. Without coreutils
+// `timeout` (macOS) the task runs unbounded. The result ends in "; " so the
+// agent command can follow it directly.
+func setupTaskCommand(hook, marker string, timeout time.Duration, haveTimeout bool) (string, error) {
+	qHook, err := setupShellQuote(hook)
+	if err != nil {
+		return "", err
+	}
+	qMarker, err := setupShellQuote(marker)
+	if err != nil {
+		return "", err
+	}
+	qDir, _ := setupShellQuote(filepath.Dir(marker))
+	run := "env SWE_HOOK=" + setupHookName + " " + qHook
+	if haveTimeout {
+		run = fmt.Sprintf("timeout -k 10 %d %s", int(timeout.Seconds()), run)
+	}
+	return fmt.Sprintf("echo '[swe-swe] Running setup task %s'; "+
+		"if %s </dev/null; then mkdir -p %s && date -u > %s; echo '[swe-swe] Setup task done'; "+
+		"else echo '[swe-swe] Setup task failed or timed out; starting the agent anyway'; fi; ",
+		filepath.Base(filepath.Dir(filepath.Dir(hook)))+"/hooks/"+setupHookName, run, qDir, qMarker), nil
+}
+
+// setupShellQuote single-quotes p for the setup prefix, refusing anything that
+// would be expanded or mangled on its way through wrapWithShell's bash -c.
+func setupShellQuote(p string) (string, error) {
+	for _, r := range p {
+		if r == '\'' || r == '$' || r == '`' || r == '\\' || r == '"' || r < 0x20 || r > 0x7e {
+			return "", fmt.Errorf("path %q contains characters the setup command line cannot carry", p)
+		}
+	}
+	return "'" + p + "'", nil
+}
@@ -31,7 +31,7 @@ cat > /home/app/.config/opencode/opencode.json << 'EOF'
     },
     "swe-swe-preview": {
       "type": "local",
-      "command": ["sh", "-c", "exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/proxy/$SESSION_UUID/preview/mcp?key=$MCP_AUTH_KEY"]
+      "command": ["sh", "-c", "exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp/preview?key=$MCP_AUTH_KEY"]
     },
     "swe-swe-whiteboard": {
       "type": "local",
@@ -71,7 +71,7 @@ env_vars = ["SWE_SERVER_PORT", "SESSION_UUID", "MCP_AUTH_KEY", "BROWSER_CDP_PORT
 
 [mcp_servers.swe-swe-preview]
 command = "swe-npx"
-args = ["-y", "@choonkeat/agent-reverse-proxy", "--bridge", "http://localhost:$SWE_SERVER_PORT/proxy/$SESSION_UUID/preview/mcp?key=$MCP_AUTH_KEY"]
+args = ["-y", "@choonkeat/agent-reverse-proxy", "--bridge", "http://localhost:$SWE_SERVER_PORT/mcp/preview?key=$MCP_AUTH_KEY"]
 env_vars = ["SWE_SERVER_PORT", "SESSION_UUID", "MCP_AUTH_KEY"]
 
 [mcp_servers.swe-swe-whiteboard]
@@ -101,7 +101,7 @@ cat > /home/app/.gemini/settings.json << 'EOF'
     },
     "swe-swe-preview": {
       "command": "sh",
-      "args": ["-c", "exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/proxy/$SESSION_UUID/preview/mcp?key=$MCP_AUTH_KEY"]
+      "args": ["-c", "exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp/preview?key=$MCP_AUTH_KEY"]
     },
     "swe-swe-whiteboard": {
       "command": "swe-npx",
@@ -138,7 +138,7 @@ extensions:
     cmd: sh
     args:
       - "-c"
-      - "exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/proxy/$SESSION_UUID/preview/mcp?key=$MCP_AUTH_KEY"
+      - "exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp/preview?key=$MCP_AUTH_KEY"
   swe-swe-whiteboard:
     type: stdio
     cmd: swe-npx
@@ -176,7 +176,7 @@ claude_mcp_setup() {
   claude mcp remove --scope user swe-swe 2>/dev/null || true
   claude mcp add --scope user --transport stdio swe-swe-agent-chat -- sh -c 'exec swe-npx -y @choonkeat/agent-chat --theme-cookie swe-swe-theme --welcome-replies "What can you help me with?,Give me an overview of this project,What has changed recently?,/swe-swe:recordings-list-orphaned" --autocomplete-triggers /=slash-command --autocomplete-url http://localhost:$SWE_SERVER_PORT/api/autocomplete/$SESSION_UUID?key=$MCP_AUTH_KEY'
   claude mcp add --scope user --transport stdio swe-swe-playwright -- sh -c 'exec mcp-lazy-init --init-method POST --init-url http://localhost:$SWE_SERVER_PORT/api/session/$SESSION_UUID/browser/start?key=$MCP_AUTH_KEY -- npx -y @playwright/mcp@latest --cdp-endpoint http://localhost:$BROWSER_CDP_PORT'
-  claude mcp add --scope user --transport stdio swe-swe-preview -- sh -c 'exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/proxy/$SESSION_UUID/preview/mcp?key=$MCP_AUTH_KEY'
+  claude mcp add --scope user --transport stdio swe-swe-preview -- sh -c 'exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp/preview?key=$MCP_AUTH_KEY'
   claude mcp add --scope user --transport stdio swe-swe-whiteboard -- swe-npx -y @choonkeat/agent-whiteboard
   claude mcp add --scope user --transport stdio swe-swe -- sh -c 'exec swe-npx -y @choonkeat/agent-reverse-proxy --bridge http://localhost:$SWE_SERVER_PORT/mcp?key=$MCP_AUTH_KEY'
 }
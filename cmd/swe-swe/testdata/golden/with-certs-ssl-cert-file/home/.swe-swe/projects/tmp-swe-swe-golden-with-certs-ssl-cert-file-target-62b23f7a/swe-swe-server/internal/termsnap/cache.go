@@ -0,0 +1,138 @@
+package termsnap
+
+import (
+	"sync"
+
+	"github.com/hinshun/vt10x"
+)
+
+// Tracked is a vt10x.Terminal that counts its changes: every Write and
+// Resize starts a new generation, so a snapshot taken at one generation is
+// still the screen until Gen moves on. Parse, which reads a whole stream,
+// is not counted. It is no safer for concurrent use than the terminal it
+// wraps.
+type Tracked struct {
+	vt10x.Terminal
+	gen uint64
+}
+
+// Track wraps t to count its changes.
+func Track(t vt10x.Terminal) *Tracked {
+	return &Tracked{Terminal: t}
+}
+
+func (t *Tracked) Write(p []byte) (int, error) {
+	t.gen++
+	return t.Terminal.Write(p)
+}
+
+func (t *Tracked) Resize(cols, rows int) {
+	t.gen++
+	t.Terminal.Resize(cols, rows)
+}
+
+// Gen is the current generation.
+func (t *Tracked) Gen() uint64 { return t.gen }
+
+// Generation returns g's generation when g is Tracked; ok is false for any
+// other grid, whose snapshots cannot be reused.
+func Generation(g Grid) (gen uint64, ok bool) {
+	if t, ok := g.(*Tracked); ok {
+		return t.gen, true
+	}
+	return 0, false
+}
+
+// Screen is a copy of a grid's cells and cursor, for rendering after the
+// terminal has moved on (or while it is being written to).
+type Screen struct {
+	cols, rows int
+	cells      []vt10x.Glyph
+	cursor     vt10x.Cursor
+}
+
+// Copy copies g's screen. It reads every cell once and allocates one slice,
+// so it is cheap enough to do under the lock that guards g.
+func Copy(g Grid) *Screen {
+	cols, rows := g.Size()
+	s := &Screen{cols: cols, rows: rows, cells: make([]vt10x.Glyph, cols*rows), cursor: g.Cursor()}
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			s.cells[y*cols+x] = g.Cell(x, y)
+		}
+	}
+	return s
+}
+
+func (s *Screen) Size() (cols, rows int)    { return s.cols, s.rows }
+func (s *Screen) Cell(x, y int) vt10x.Glyph { return s.cells[y*s.cols+x] }
+func (s *Screen) Cursor() vt10x.Cursor      { return s.cursor }
+
+// Cache holds the last snapshot rendered, by generation. Callers that ask
+// for the same generation while it is being rendered wait for that render
+// instead of starting their own, so clients joining together cost one
+// render. The bytes it returns are shared: do not modify them.
+type Cache struct {
+	mu      sync.Mutex
+	gen     uint64
+	data    []byte
+	pending *cacheFill
+}
+
+// cacheFill is a render in progress.
+type cacheFill struct {
+	gen  uint64
+	done chan struct{}
+	data []byte
+}
+
+// Reuse returns a function yielding the snapshot of generation gen when
+// there is one to reuse: rendered, or being rendered by another caller (the
+// function then waits for it). It does not wait itself, so it can be called
+// under the lock that guards the terminal, before paying for a Copy.
+func (c *Cache) Reuse(gen uint64) (get func() []byte, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.data != nil && c.gen == gen {
+		data := c.data
+		return func() []byte { return data }, true
+	}
+	if f := c.pending; f != nil && f.gen == gen {
+		return func() []byte { <-f.done; return f.data }, true
+	}
+	return nil, false
+}
+
+// Get returns the snapshot of generation gen: the cached one, the one
+// another caller is rendering, or the result of render. A render for an
+// older generation than the cached one is returned but not kept.
+func (c *Cache) Get(gen uint64, render func() []byte) []byte {
+	c.mu.Lock()
+	if c.data != nil && c.gen == gen {
+		data := c.data
+		c.mu.Unlock()
+		return data
+	}
+	if f := c.pending; f != nil && f.gen == gen {
+		c.mu.Unlock()
+		<-f.done
+		return f.data
+	}
+	f := &cacheFill{gen: gen, done: make(chan struct{})}
+	c.pending = f
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		if c.pending == f {
+			c.pending = nil
+		}
+		if f.data != nil && (c.data == nil || gen >= c.gen) {
+			c.gen, c.data = gen, f.data
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	f.data = render()
+	return f.data
+}
@@ -0,0 +1,60 @@
+// session_size_pin.go -- sticky PTY size override.
+//
+// Normally the PTY follows the clients (calculateMinSize), so a phone that
+// peeks in shrinks the agent's output for everyone. Pinning fixes the PTY at
+// an explicit size regardless of who is connected:
+//
+//	{"type": "pin_size", "data": {"cols": 200, "rows": 50}}
+//	{"type": "pin_size", "data": {"cols": 0, "rows": 0}}   // unpin
+//
+// The pin lives on the Session, so it holds while clients come and go and is
+// applied again when the agent process is restarted (RestartProcess). The
+// status message reports it as pinnedSize; clients smaller than the pin
+// render the grid scaled, as in independent size mode.
+package main
+
+import "fmt"
+
+// Bounds for a pinned size: wide enough for real agent output, small enough
+// that a typo cannot ask the PTY for a million columns.
+const (
+	pinnedSizeMinCols = 20
+	pinnedSizeMaxCols = 1000
+	pinnedSizeMinRows = 5
+	pinnedSizeMaxRows = 500
+)
+
+// validatePinnedSize checks a pin_size request; 0x0 (unpin) is valid.
+func validatePinnedSize(cols, rows int) error {
+	if cols == 0 && rows == 0 {
+		return nil
+	}
+	if cols < pinnedSizeMinCols || cols > pinnedSizeMaxCols {
+		return fmt.Errorf("cols %d out of range %d-%d", cols, pinnedSizeMinCols, pinnedSizeMaxCols)
+	}
+	if rows < pinnedSizeMinRows || rows > pinnedSizeMaxRows {
+		return fmt.Errorf("rows %d out of range %d-%d", rows, pinnedSizeMinRows, pinnedSizeMaxRows)
+	}
+	return nil
+}
+
+// SetPinnedSize pins the PTY to cols x rows, or unpins it with 0x0, and
+// resizes the PTY accordingly.
+func (s *Session) SetPinnedSize(cols, rows int) error {
+	if err := validatePinnedSize(cols, rows); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pinnedSize = TermSize{Rows: uint16(rows), Cols: uint16(cols)}
+	s.resizePTYToClients()
+
+	// Broadcast status after lock is released
+	go s.BroadcastStatus()
+	return nil
+}
+
+// pinned reports whether the PTY size is pinned. Call with s.mu held.
+func (s *Session) pinned() bool {
+	return s.pinnedSize.Rows > 0 && s.pinnedSize.Cols > 0
+}
@@ -0,0 +1,231 @@
+// Package wsproto is the session WebSocket protocol (/ws/{uuid}) spoken
+// between swe-swe-server and its clients: the binary frames that carry
+// terminal I/O, uploads and resizes, and the JSON control messages.
+//
+// Binary frames start with an opcode (OpResize, OpUpload, ...); binary.go
+// has an encoder and a parser for each. Anything else is terminal bytes.
+//
+// Control messages are JSON text frames with a "type". Most carry their
+// fields beside it,
+//
+//	{"type": "prompt", "text": "now add tests"}
+//
+// and a few nest them in "data":
+//
+//	{"type": "pin_size", "data": {"cols": 200, "rows": 50}}
+//
+// Each type is a struct here (messages.go) that knows its shape, so clients
+// and the server go through Encode and Decode rather than building maps:
+//
+//	b, _ := wsproto.Encode(wsproto.Prompt{Text: "now add tests"})
+//	m, err := wsproto.Decode(b) // *wsproto.Prompt
+//
+// Server broadcasts with large or evolving shapes (status, text,
+// files_changed, tests, ...) are not typed here; Decode reports them as
+// ErrUnknownType and docs/websocket-protocol.md describes them. Describe
+// lists everything this package knows, and swe-swe-server serves it as
+// GET /api/protocol for clients built outside this repo.
+package wsproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// Version is the protocol version the server speaks; clients send theirs
+	// as ?proto=N and the server's first frame is a Protocol message.
+	// Bump it when a client would need to change to keep working.
+	Version = 1
+	// MinVersion is the oldest client protocol the server still serves.
+	MinVersion = 1
+)
+
+// ErrUnknownType is returned by Decode for a message type it has no struct
+// for.
+var ErrUnknownType = errors.New("wsproto: unknown message type")
+
+// Direction is who sends a message.
+type Direction string
+
+const (
+	ClientToServer Direction = "client_to_server"
+	ServerToClient Direction = "server_to_client"
+	Both           Direction = "both"
+)
+
+// Message is a typed control message.
+type Message interface {
+	MessageType() string
+}
+
+// Envelope is the part every control message has: its type, and the "data"
+// object of the types that nest their fields.
+type Envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// messageSpec is how a registered type is framed.
+type messageSpec struct {
+	typ    reflect.Type // the struct
+	dir    Direction
+	nested bool // fields in "data"
+}
+
+var registry = map[string]messageSpec{}
+
+// register adds the message types of ms, which are struct values.
+func register(dir Direction, nested bool, ms ...Message) {
+	for _, m := range ms {
+		registry[m.MessageType()] = messageSpec{typ: reflect.TypeOf(m), dir: dir, nested: nested}
+	}
+}
+
+// Encode encodes m as a control message.
+func Encode(m Message) ([]byte, error) {
+	t := m.MessageType()
+	spec, ok := registry[t]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, t)
+	}
+	fields, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	typ, _ := json.Marshal(t)
+	if spec.nested {
+		return json.Marshal(Envelope{Type: t, Data: fields})
+	}
+	// Splice "type" in front of the struct's own fields.
+	var b bytes.Buffer
+	b.WriteString(`{"type":`)
+	b.Write(typ)
+	if len(fields) > 2 {
+		b.WriteByte(',')
+		b.Write(fields[1:])
+	} else {
+		b.WriteByte('}')
+	}
+	return b.Bytes(), nil
+}
+
+// Decode decodes a control message into a pointer to its struct. A message
+// of a type not registered here returns ErrUnknownType; use Envelope to read
+// its type.
+func Decode(b []byte) (Message, error) {
+	var env Envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	spec, ok := registry[env.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, env.Type)
+	}
+	v := reflect.New(spec.typ)
+	src := b
+	if spec.nested {
+		src = env.Data
+	}
+	if len(src) > 0 {
+		if err := json.Unmarshal(src, v.Interface()); err != nil {
+			return nil, fmt.Errorf("wsproto: %s: %w", env.Type, err)
+		}
+	}
+	return v.Interface().(Message), nil
+}
+
+// Spec describes the protocol; it is the body of GET /api/protocol.
+type Spec struct {
+	Version    int           `json:"version"`
+	MinVersion int           `json:"min_version"`
+	Binary     []OpcodeSpec  `json:"binary"`
+	Messages   []MessageSpec `json:"messages"`
+}
+
+// OpcodeSpec describes a binary frame.
+type OpcodeSpec struct {
+	Opcode    byte      `json:"opcode"`
+	Name      string    `json:"name"`
+	Direction Direction `json:"direction"`
+	Layout    string    `json:"layout"`
+}
+
+// MessageSpec describes a control message.
+type MessageSpec struct {
+	Type      string      `json:"type"`
+	Direction Direction   `json:"direction"`
+	Nested    bool        `json:"nested,omitempty"` // fields in "data"
+	Fields    []FieldSpec `json:"fields"`
+}
+
+// FieldSpec describes a control message field.
+type FieldSpec struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // string, integer, boolean, string[], or json
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// Describe describes every binary frame and typed control message.
+func Describe() Spec {
+	s := Spec{
+		Version:    Version,
+		MinVersion: MinVersion,
+		Binary: []OpcodeSpec{
+			{OpResize, "resize", ClientToServer, "[0x00, rows u16, cols u16]"},
+			{OpUpload, "upload", ClientToServer, "[0x01, name_len u16, name, data]"},
+			{OpChunk, "chunk", ServerToClient, "[0x02, index u8, total u8, data]"},
+			{OpPaste, "paste", ClientToServer, "[0x03, type_len u8, content_type, data]"},
+			{OpSequenced, "sequenced", ClientToServer, "[0x04, seq u32, frame]"},
+		},
+		Messages: []MessageSpec{},
+	}
+	for t, spec := range registry {
+		s.Messages = append(s.Messages, MessageSpec{Type: t, Direction: spec.dir, Nested: spec.nested, Fields: describeFields(spec.typ)})
+	}
+	sort.Slice(s.Messages, func(i, j int) bool { return s.Messages[i].Type < s.Messages[j].Type })
+	return s
+}
+
+// describeFields lists the JSON fields of struct type t, embedded structs'
+// included.
+func describeFields(t reflect.Type) []FieldSpec {
+	fields := []FieldSpec{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, describeFields(f.Type)...)
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, FieldSpec{Name: name, Type: fieldType(f.Type), Optional: opts == "omitempty"})
+	}
+	return fields
+}
+
+func fieldType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return "string[]"
+		}
+	}
+	return "json"
+}
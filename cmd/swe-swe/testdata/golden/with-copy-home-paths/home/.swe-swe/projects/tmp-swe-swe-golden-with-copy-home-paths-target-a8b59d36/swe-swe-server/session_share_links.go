@@ -0,0 +1,417 @@
+// session_share_links.go -- signed, expiring share links with a scope.
+//
+// The password share (session_share.go) gives a guest full control of the
+// session until it ends, with no way to take it back. A share link is safer to
+// send to someone outside the tailnet:
+//
+//   - it expires (default 24h, at most 30 days) and can be revoked;
+//   - its scope is "view" (watch the terminal) or "control" (what a password
+//     guest can do);
+//   - the URL itself is the credential: /swe-swe-auth/share?session=UUID&token=ID.SIG,
+//     where SIG is an HMAC (keyed by SWE_SWE_PASSWORD) over the session, share
+//     ID, scope and expiry. No password to pass along separately.
+//
+// Opening the link issues a scoped auth cookie that also names the share
+// (auth.go: authSignShareCookie). authVerifyCookieScoped accepts that cookie
+// only while the share is live, so a revoked or expired share stops working
+// at the next request -- page load, API call or WebSocket upgrade -- on the
+// main server and the per-port proxies alike. Terminal WebSockets opened with
+// a share are also closed the moment it is revoked or expires.
+//
+// A view-only guest may only read: other methods are refused
+// (shareViewOnlyAllowed), the per-port proxies refuse their WebSocket upgrades
+// (agent chat and the browser are control surfaces), and the session
+// WebSocket drops everything they send except pings.
+//
+// Shares live on the Session in memory, like the share password: they die
+// with the session. Managing them is owner-only:
+//
+//	POST   /api/session/{uuid}/share       {"scope": "view", "expiresIn": 3600, "label": "..."}
+//	GET    /api/session/{uuid}/shares      -> {"shares": [...]}
+//	DELETE /api/session/{uuid}/shares/{id}
+package main
+
+import (
+	"crypto/hmac"
+	crypto_rand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	shareScopeView    = "view"
+	shareScopeControl = "control"
+
+	shareLinkDefaultTTL = 24 * time.Hour
+	shareLinkMaxTTL     = 30 * 24 * time.Hour
+	// shareLinkMaxPerSession bounds how many live links one session holds.
+	shareLinkMaxPerSession = 20
+	shareLinkMaxLabel      = 100
+)
+
+// shareLink is one share of a session. Guarded by the owning Session's mu.
+type shareLink struct {
+	ID        string    `json:"id"`
+	Scope     string    `json:"scope"`
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	done chan struct{} // closed on revoke
+}
+
+func (l *shareLink) expired(now time.Time) bool { return !now.Before(l.ExpiresAt) }
+
+// shareLinkView is a share as the management API returns it.
+type shareLinkView struct {
+	shareLink
+	URL string `json:"url,omitempty"`
+}
+
+// createShareLink adds a share to sess, dropping expired ones first.
+func createShareLink(sess *Session, scope, label string, ttl time.Duration) (*shareLink, error) {
+	if scope != shareScopeView && scope != shareScopeControl {
+		return nil, fmt.Errorf("invalid scope %q (want %s or %s)", scope, shareScopeView, shareScopeControl)
+	}
+	if ttl <= 0 || ttl > shareLinkMaxTTL {
+		return nil, fmt.Errorf("expiry must be between 1s and %s", shareLinkMaxTTL)
+	}
+	label = strings.TrimSpace(label)
+	if len(label) > shareLinkMaxLabel {
+		return nil, fmt.Errorf("label is longer than %d bytes", shareLinkMaxLabel)
+	}
+	buf := make([]byte, 8)
+	crypto_rand.Read(buf)
+	now := time.Now()
+	l := &shareLink{
+		ID:        hex.EncodeToString(buf),
+		Scope:     scope,
+		Label:     label,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl).Truncate(time.Second),
+		done:      make(chan struct{}),
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	pruneShareLinksLocked(sess, now)
+	if len(sess.ShareLinks) >= shareLinkMaxPerSession {
+		return nil, fmt.Errorf("session already has %d share links; revoke one first", shareLinkMaxPerSession)
+	}
+	if sess.ShareLinks == nil {
+		sess.ShareLinks = make(map[string]*shareLink)
+	}
+	sess.ShareLinks[l.ID] = l
+	return l, nil
+}
+
+// pruneShareLinksLocked drops expired shares. Call with sess.mu held.
+func pruneShareLinksLocked(sess *Session, now time.Time) {
+	for id, l := range sess.ShareLinks {
+		if l.expired(now) {
+			delete(sess.ShareLinks, id)
+		}
+	}
+}
+
+// listShareLinks returns sess's live shares, oldest first.
+func listShareLinks(sess *Session) []*shareLink {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	pruneShareLinksLocked(sess, time.Now())
+	out := make([]*shareLink, 0, len(sess.ShareLinks))
+	for _, l := range sess.ShareLinks {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// revokeShareLink removes a share and disconnects its guests. False if there
+// was no such share.
+func revokeShareLink(sess *Session, id string) bool {
+	sess.mu.Lock()
+	l, ok := sess.ShareLinks[id]
+	delete(sess.ShareLinks, id)
+	sess.mu.Unlock()
+	if ok {
+		close(l.done)
+	}
+	return ok
+}
+
+// lookupShareLink returns the live (unexpired, unrevoked) share id of the live
+// session uuid.
+func lookupShareLink(uuid, id string) (*shareLink, bool) {
+	if uuid == "" || id == "" {
+		return nil, false
+	}
+	sessionsMu.RLock()
+	sess, ok := sessions[uuid]
+	sessionsMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	sess.mu.RLock()
+	l, ok := sess.ShareLinks[id]
+	sess.mu.RUnlock()
+	if !ok || l.expired(time.Now()) {
+		return nil, false
+	}
+	return l, true
+}
+
+// shareLinkActive reports whether a share cookie's share still grants access.
+func shareLinkActive(uuid, id string) bool {
+	_, ok := lookupShareLink(uuid, id)
+	return ok
+}
+
+// shareLinkToken is the link's credential: the share ID and an HMAC binding
+// it to the session, scope and expiry.
+func shareLinkToken(secret, uuid string, l *shareLink) string {
+	signed := strings.Join([]string{"share", uuid, l.ID, l.Scope, strconv.FormatInt(l.ExpiresAt.Unix(), 10)}, authCookieDelimiter)
+	return l.ID + "." + authComputeHMAC(signed, secret)
+}
+
+// verifyShareLinkToken returns the live share a link token names.
+func verifyShareLinkToken(secret, uuid, token string) (*shareLink, bool) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+	l, ok := lookupShareLink(uuid, id)
+	if !ok {
+		return nil, false
+	}
+	if !hmac.Equal([]byte(token), []byte(shareLinkToken(secret, uuid, l))) {
+		return nil, false
+	}
+	return l, true
+}
+
+// buildShareLinkURL builds the absolute URL a guest opens.
+func buildShareLinkURL(r *http.Request, secret, uuid string, l *shareLink) string {
+	scheme := "http"
+	if resolveCookieSecure(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/swe-swe-auth/share?session=" + url.QueryEscape(uuid) +
+		"&token=" + url.QueryEscape(shareLinkToken(secret, uuid, l))
+}
+
+// requestShareLink returns the share the request's auth cookie was issued
+// for, nil for anyone else (full users, password guests, no embedded auth).
+func requestShareLink(r *http.Request) *shareLink {
+	secret := os.Getenv("SWE_SWE_PASSWORD")
+	if secret == "" {
+		return nil
+	}
+	cookie, err := r.Cookie(authCookieName)
+	if err != nil {
+		return nil
+	}
+	scope, shareID, valid := authVerifyCookieShare(cookie.Value, secret)
+	if !valid || shareID == "" {
+		return nil
+	}
+	l, _ := lookupShareLink(scope, shareID)
+	return l
+}
+
+// shareViewOnlyAllowed is the view-only guest policy: reads only. forwarded
+// says the request is a WebSocket upgrade the listener would pass through
+// unfiltered (the per-port proxies); the session terminal's own WebSocket
+// drops a viewer's input, so it is not.
+func shareViewOnlyAllowed(l *shareLink, method string, forwarded bool) bool {
+	if l == nil || l.Scope != shareScopeView {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return !forwarded
+	}
+	return false
+}
+
+// authShareHandler handles GET /swe-swe-auth/share?session=UUID&token=TOKEN:
+// a valid token gets a cookie scoped to the session and the share, and a
+// redirect into the session. Failures count against the login rate limit.
+func authShareHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		clientKey := loginThrottleKey(r)
+		if !authLoginLimiter.allow(clientKey) || !authGlobalLimiter.allow(authGlobalRateLimitMax) {
+			http.Error(w, "Too many attempts. Please wait a few minutes.", http.StatusTooManyRequests)
+			return
+		}
+		uuid := r.URL.Query().Get("session")
+		l, ok := verifyShareLinkToken(secret, uuid, r.URL.Query().Get("token"))
+		var target string
+		if ok {
+			target, ok = scopedHomeTarget(uuid)
+		}
+		if !ok {
+			authLoginLimiter.record(clientKey)
+			authGlobalLimiter.record()
+			http.Error(w, "This share link is invalid, has expired, or was revoked.", http.StatusForbidden)
+			return
+		}
+
+		maxAge := min(authCookieMaxAge, int(time.Until(l.ExpiresAt).Seconds())+1)
+		http.SetCookie(w, &http.Cookie{
+			Name:     authCookieName,
+			Value:    authSignShareCookie(secret, uuid, l.ID),
+			Path:     "/",
+			Domain:   sessionCookieDomain(r.Host),
+			MaxAge:   maxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+			Secure:   resolveCookieSecure(r),
+		})
+		log.Printf("Session %s: opened %s share %s from %s", uuid, l.Scope, l.ID, clientKey)
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// watchShareLinkConn closes conn when l is revoked or expires, telling the
+// client not to reconnect. Returns a stop func for when conn ends first.
+func watchShareLinkConn(l *shareLink, conn *SafeConn) (stop func()) {
+	stopCh := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(time.Until(l.ExpiresAt))
+		defer timer.Stop()
+		select {
+		case <-stopCh:
+			return
+		case <-l.done:
+		case <-timer.C:
+		}
+		sendWSError(conn, wsError{
+			Type:    "session_error",
+			Code:    wsErrShareEnded,
+			Message: "This share link was revoked or has expired.",
+		}, "share ended")
+		conn.Close()
+	}()
+	return func() { close(stopCh) }
+}
+
+// viewOnlyMessageAllowed filters what a view-only guest's session WebSocket
+// may send: keepalive pings, nothing that types, resizes or changes state.
+func viewOnlyMessageAllowed(messageType int, data []byte) bool {
+	if messageType != websocket.TextMessage {
+		return false
+	}
+	var msg struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(data, &msg) == nil && msg.Type == "ping"
+}
+
+// handleSessionShareLinkCreate is POST /api/session/{uuid}/share with a JSON
+// body: it creates a share link instead of returning the share password.
+func handleSessionShareLinkCreate(w http.ResponseWriter, r *http.Request, sess *Session, body []byte) {
+	var req struct {
+		Scope     string `json:"scope"`
+		ExpiresIn int64  `json:"expiresIn"` // seconds; 0 = shareLinkDefaultTTL
+		Label     string `json:"label"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	secret := os.Getenv("SWE_SWE_PASSWORD")
+	if secret == "" {
+		http.Error(w, "Share links need the embedded login (SWE_SWE_PASSWORD)", http.StatusConflict)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = shareScopeControl
+	}
+	ttl := shareLinkDefaultTTL
+	if req.ExpiresIn != 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	l, err := createShareLink(sess, req.Scope, req.Label, ttl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Session %s: created %s share %s (expires %s)", sess.UUID, l.Scope, l.ID, l.ExpiresAt.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shareLinkView{shareLink: *l, URL: buildShareLinkURL(r, secret, sess.UUID, l)})
+}
+
+// readShareBody returns the request body, "" when there is none.
+func readShareBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4<<10))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimSpace(string(body))), nil
+}
+
+// handleSessionSharesAPI handles GET /api/session/{uuid}/shares (list, with
+// each link's URL) and DELETE /api/session/{uuid}/shares/{id} (revoke).
+// Owner-only, like creating a share.
+func handleSessionSharesAPI(w http.ResponseWriter, r *http.Request) {
+	if requestCookieScope(r) != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/api/session/")
+	sessionUUID, shareID, _ := strings.Cut(rest, "/shares")
+	shareID = strings.TrimPrefix(shareID, "/")
+
+	sessionsMu.RLock()
+	sess, exists := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case shareID == "" && r.Method == http.MethodGet:
+		secret := os.Getenv("SWE_SWE_PASSWORD")
+		views := []shareLinkView{}
+		for _, l := range listShareLinks(sess) {
+			v := shareLinkView{shareLink: *l}
+			if secret != "" {
+				v.URL = buildShareLinkURL(r, secret, sess.UUID, l)
+			}
+			views = append(views, v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]any{"shares": views})
+	case shareID != "" && r.Method == http.MethodDelete:
+		if !revokeShareLink(sess, shareID) {
+			http.Error(w, "Share not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Session %s: revoked share %s", sess.UUID, shareID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,242 @@
+// session_thumbnail.go -- small live previews of sessions for the homepage.
+//
+// thumbnailRefresher re-renders every live top-level session's terminal
+// screen (readScreen in session_screen.go) as a small SVG every
+// thumbnailInterval and keeps it with a short hash of its content. The hash
+// goes out in GET /api/sessions/live, which the homepage already polls, and
+// the card swaps its <img> to GET /api/session/{uuid}/thumbnail?h=HASH only
+// when the hash changes, so an idle session costs nothing after the first
+// load. SVG text keeps the image a few KB and sharp at any size, and needs no
+// font rasterizer on the server.
+//
+// Rendering only happens while someone is looking: the live poll stamps
+// thumbnailsWantedAt, and the refresher skips ticks once nobody has polled
+// for thumbnailIdleAfter. A thumbnail requested before the refresher has one
+// is rendered on the spot.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// thumbnailInterval is how often thumbnails are re-rendered.
+	thumbnailInterval = 5 * time.Second
+	// thumbnailIdleAfter stops rendering when the homepage has not polled
+	// for this long.
+	thumbnailIdleAfter = 30 * time.Second
+
+	// Cell size and font in SVG user units.
+	thumbCellW    = 6
+	thumbCellH    = 12
+	thumbFontSize = 10
+
+	// Same as the session page's dark xterm theme (theme-mode.js).
+	thumbDefaultFG = "#d4d4d4"
+	thumbDefaultBG = "#1e1e1e"
+)
+
+// sessionThumb is one rendered thumbnail.
+type sessionThumb struct {
+	hash string
+	svg  []byte
+}
+
+var (
+	thumbsMu sync.RWMutex
+	thumbs   = map[string]sessionThumb{}
+
+	// thumbnailsWantedAt is the unix time of the last homepage live poll.
+	thumbnailsWantedAt atomic.Int64
+)
+
+// wantThumbnails records that the homepage is showing thumbnails.
+func wantThumbnails() { thumbnailsWantedAt.Store(time.Now().Unix()) }
+
+// sessionThumbHash returns the current thumbnail hash for uuid, "" if none.
+func sessionThumbHash(uuid string) string {
+	thumbsMu.RLock()
+	defer thumbsMu.RUnlock()
+	return thumbs[uuid].hash
+}
+
+// thumbnailRefresher re-renders thumbnails while the homepage is polling.
+func thumbnailRefresher() {
+	defer recoverGoroutine("thumbnail refresher")
+	ticker := time.NewTicker(thumbnailInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if time.Since(time.Unix(thumbnailsWantedAt.Load(), 0)) > thumbnailIdleAfter {
+			continue
+		}
+		refreshThumbnails()
+	}
+}
+
+// refreshThumbnails renders a thumbnail for every live top-level session and
+// forgets the ones whose session is gone.
+func refreshThumbnails() {
+	sessionsMu.RLock()
+	live := make(map[string]*Session, len(sessions))
+	for uuid, sess := range sessions {
+		if sess.ParentUUID != "" || sess.vt == nil {
+			continue
+		}
+		if sess.exited() {
+			continue
+		}
+		live[uuid] = sess
+	}
+	sessionsMu.RUnlock()
+
+	fresh := make(map[string]sessionThumb, len(live))
+	for uuid, sess := range live {
+		fresh[uuid] = renderSessionThumb(sess)
+	}
+	thumbsMu.Lock()
+	thumbs = fresh
+	thumbsMu.Unlock()
+}
+
+func renderSessionThumb(sess *Session) sessionThumb {
+	sess.vtMu.Lock()
+	scr := readScreen(sess.vt)
+	sess.vtMu.Unlock()
+	svg := renderThumbnailSVG(scr)
+	h := fnv.New64a()
+	h.Write(svg)
+	return sessionThumb{hash: fmt.Sprintf("%016x", h.Sum64()), svg: svg}
+}
+
+// renderThumbnailSVG draws the screen as SVG text: one <text> per row, pinned
+// to the grid width with textLength, a <tspan> per styled run, and a <rect>
+// under runs with a non-default background.
+func renderThumbnailSVG(scr sessionScreen) []byte {
+	var b bytes.Buffer
+	w, h := scr.Cols*thumbCellW, scr.Rows*thumbCellH
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d">`, w, h, w, h)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`, thumbDefaultBG)
+	fmt.Fprintf(&b, `<g font-family="Menlo, Consolas, monospace" font-size="%d" fill="%s" xml:space="preserve">`, thumbFontSize, thumbDefaultFG)
+	for y, line := range scr.Lines {
+		if line.Text == "" {
+			continue
+		}
+		var text strings.Builder
+		x := 0
+		for _, sp := range line.Spans {
+			n := len([]rune(sp.Text))
+			fg, bg := thumbColor(sp.FG, thumbDefaultFG), thumbColor(sp.BG, thumbDefaultBG)
+			if sp.Reverse {
+				fg, bg = bg, fg
+			}
+			if bg != thumbDefaultBG {
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`, x*thumbCellW, y*thumbCellH, n*thumbCellW, thumbCellH, bg)
+			}
+			x += n
+			text.WriteString("<tspan")
+			if fg != thumbDefaultFG {
+				fmt.Fprintf(&text, ` fill="%s"`, fg)
+			}
+			if sp.Bold {
+				text.WriteString(` font-weight="bold"`)
+			}
+			if sp.Italic {
+				text.WriteString(` font-style="italic"`)
+			}
+			if sp.Underline {
+				text.WriteString(` text-decoration="underline"`)
+			}
+			fmt.Fprintf(&text, ">%s</tspan>", html.EscapeString(strings.Map(xmlSafeRune, sp.Text)))
+		}
+		fmt.Fprintf(&b, `<text x="0" y="%d" textLength="%d" lengthAdjust="spacingAndGlyphs">%s</text>`,
+			y*thumbCellH+thumbCellH-3, x*thumbCellW, text.String())
+	}
+	b.WriteString("</g></svg>")
+	return b.Bytes()
+}
+
+// xmlSafeRune blanks runes XML 1.0 does not allow in text.
+func xmlSafeRune(r rune) rune {
+	if r < 0x20 || r == 0xfffe || r == 0xffff || (r >= 0xd800 && r <= 0xdfff) {
+		return ' '
+	}
+	return r
+}
+
+// thumbColor turns a screenSpan color (nil, palette index or "#rrggbb") into
+// an SVG color.
+func thumbColor(c any, def string) string {
+	switch v := c.(type) {
+	case int:
+		return xterm256Color(v)
+	case string:
+		return v
+	}
+	return def
+}
+
+// ansi16 is xterm's default 16-color palette.
+var ansi16 = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// xterm256Color returns the hex color of xterm palette index i.
+func xterm256Color(i int) string {
+	switch {
+	case i < 16:
+		return ansi16[i]
+	case i < 232:
+		i -= 16
+		level := func(n int) int {
+			if n == 0 {
+				return 0
+			}
+			return 55 + n*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(i/36), level(i/6%6), level(i%6))
+	}
+	g := 8 + (i-232)*10
+	return fmt.Sprintf("#%02x%02x%02x", g, g, g)
+}
+
+// handleSessionThumbnailAPI handles GET /api/session/{uuid}/thumbnail: the
+// session's latest thumbnail as image/svg+xml, with its hash as the ETag.
+func handleSessionThumbnailAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionUUID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/thumbnail")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil || sess.vt == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	thumbsMu.RLock()
+	thumb, ok := thumbs[sessionUUID]
+	thumbsMu.RUnlock()
+	if !ok {
+		thumb = renderSessionThumb(sess)
+	}
+
+	etag := `"` + thumb.hash + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(thumb.svg)
+}
@@ -0,0 +1,339 @@
+// logging.go -- structured logging (log/slog) for swe-swe-server.
+//
+// Code can log through slog with explicit attrs -- s.logger() for anything
+// about one session, requestLogger(r, subsystem) inside HTTP handlers. Most
+// call sites, old and new, still use log.Printf ("Session %s: ..."); those
+// are bridged rather than rewritten: setupLogging installs an slog default,
+// which routes the standard log package through logHandler. For those
+// plain-text records logHandler fills in what the message already says:
+//
+//   - subsystem: the calling source file (tunnel_supervisor.go -> "tunnel_supervisor",
+//     main.go -> "server")
+//   - session:   the first session UUID in the message
+//   - remote:    a "remote=<addr>" token in the message
+//   - level:     "Warning:"/"WARN:" prefixes -> WARN, "Failed"/"Error"
+//     prefixes -> ERROR, "[DEBUG]" -> DEBUG; everything else INFO
+//
+// The minimum level is a slog.LevelVar, adjustable at runtime through
+// /api/log-level. -log-file additionally writes every line to a size-rotated
+// file so production debugging doesn't require scraping docker logs.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// logLevel is the minimum level written. Adjusted by -log-level and
+// /api/log-level.
+var logLevel = new(slog.LevelVar)
+
+var (
+	logUUIDPattern   = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	logRemotePattern = regexp.MustCompile(`remote=([^\s,)]+)`)
+)
+
+// logHandler wraps the output handler (text or JSON) and supplies the
+// subsystem/session/remote/level a bridged log.Printf record lacks. Records
+// that already carry those attrs (slog call sites) keep their own.
+//
+// Enabled always reports true: the log-package bridge asks about INFO before
+// formatting, and a "Failed ..." line must still get a chance to be promoted
+// to ERROR when the level is WARN. Filtering happens in Handle.
+type logHandler struct {
+	inner slog.Handler
+	// with holds the attrs attached via With, for the subsystem/session/
+	// remote checks and the session event buffer.
+	with []slog.Attr
+}
+
+func newLogHandler(inner slog.Handler) *logHandler {
+	return &logHandler{inner: inner}
+}
+
+func (h *logHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	with := append(append([]slog.Attr(nil), h.with...), attrs...)
+	return &logHandler{inner: h.inner.WithAttrs(attrs), with: with}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{inner: h.inner.WithGroup(name), with: h.with}
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := map[string]string{}
+	for _, a := range h.with {
+		attrs[a.Key] = a.Value.String()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+	var extra []slog.Attr
+	infer := func(key, value string) {
+		if _, ok := attrs[key]; !ok && value != "" {
+			attrs[key] = value
+			extra = append(extra, slog.String(key, value))
+		}
+	}
+	infer("subsystem", logSubsystem(r.PC))
+	infer("session", logUUIDPattern.FindString(r.Message))
+	if m := logRemotePattern.FindStringSubmatch(r.Message); m != nil {
+		infer("remote", m[1])
+	}
+	if r.Level == slog.LevelInfo {
+		r.Level = inferLogLevel(r.Message)
+	}
+
+	// Buffer for the session's Diagnostics pane before level filtering, so
+	// the pane has the detail even when stdout is at WARN.
+	if id := attrs["session"]; id != "" {
+		ev := sessionEvent{Time: r.Time, Level: r.Level.String(), Subsystem: attrs["subsystem"], Message: r.Message}
+		for k, v := range attrs {
+			if k != "session" && k != "subsystem" {
+				if ev.Attrs == nil {
+					ev.Attrs = map[string]string{}
+				}
+				ev.Attrs[k] = v
+			}
+		}
+		recordSessionEvent(id, ev)
+	}
+
+	if r.Level < logLevel.Level() {
+		return nil
+	}
+	if len(extra) > 0 {
+		r = r.Clone()
+		r.AddAttrs(extra...)
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+// inferLogLevel maps the prefixes existing log.Printf messages use onto
+// levels. Only called for INFO records, so explicit slog levels win.
+func inferLogLevel(msg string) slog.Level {
+	switch {
+	case strings.HasPrefix(msg, "[DEBUG]"), strings.HasPrefix(msg, "DEBUG"):
+		return slog.LevelDebug
+	case strings.HasPrefix(msg, "Warning:"), strings.HasPrefix(msg, "WARN"):
+		return slog.LevelWarn
+	case strings.HasPrefix(msg, "Failed"), strings.HasPrefix(msg, "ERROR"), strings.HasPrefix(msg, "Error"):
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// logSubsystem names the subsystem after the source file that logged:
+// "tunnel_supervisor.go" -> "tunnel_supervisor", with main.go reported as
+// "server" and platform suffixes dropped (peercred_linux.go -> "peercred").
+func logSubsystem(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	if frame.File == "" {
+		return ""
+	}
+	name := strings.TrimSuffix(filepath.Base(frame.File), ".go")
+	for _, suffix := range []string{"_linux", "_darwin", "_other", "_unix"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	if name == "main" {
+		return "server"
+	}
+	return name
+}
+
+// parseLogLevel accepts debug/info/warn/error (any case).
+func parseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (want debug, info, warn, or error)", s)
+	}
+	return l, nil
+}
+
+// logOptions configures setupLogging.
+type logOptions struct {
+	Format     string // "text" (default) or "json"
+	Level      string // initial minimum level; "" = info
+	File       string // also write to this file, rotated by size; "" = stderr only
+	MaxSizeMB  int    // rotate File past this size (default 50)
+	MaxBackups int    // keep this many rotated files (default 5)
+}
+
+// setupLogging installs the slog default and bridges the log package
+// through it. Returns the log file (if any) so main can close it on exit.
+func setupLogging(opts logOptions) (io.Closer, error) {
+	if opts.Level != "" {
+		l, err := parseLogLevel(opts.Level)
+		if err != nil {
+			return nil, err
+		}
+		logLevel.Set(l)
+	}
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer
+	if opts.File != "" {
+		rf, err := openRotatingFile(opts.File, int64(opts.MaxSizeMB)<<20, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = io.MultiWriter(os.Stderr, rf)
+		closer = rf
+	}
+
+	// Filtering is logHandler's job; let the inner handler pass everything.
+	hopts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var inner slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		inner = slog.NewTextHandler(out, hopts)
+	case "json":
+		inner = slog.NewJSONHandler(out, hopts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want text or json)", opts.Format)
+	}
+
+	// Lshortfile makes the bridge capture the caller's PC (for subsystem);
+	// slog.SetDefault then resets the log package's flags to 0.
+	log.SetFlags(log.Lshortfile)
+	slog.SetDefault(slog.New(newLogHandler(inner)))
+	return closer, nil
+}
+
+// logger returns a logger carrying this session's UUID.
+func (s *Session) logger() *slog.Logger {
+	return slog.With("session", s.UUID)
+}
+
+// requestLogger returns a logger for an HTTP handler, tagged with the
+// subsystem and the client (clientLabel).
+func requestLogger(r *http.Request, subsystem string) *slog.Logger {
+	return slog.With("subsystem", subsystem, "remote", clientLabel(r))
+}
+
+// rotatingFile is an append-only log file rotated by size: path is renamed
+// to path.1 (path.1 -> path.2, ...) once it would exceed maxSize, keeping at
+// most backups old files.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = 50 << 20
+	}
+	if backups <= 0 {
+		backups = 5
+	}
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.backups))
+	for i := rf.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
+
+// handleLogLevelAPI handles /api/log-level:
+//
+//	GET                        -> {"level": "INFO"}
+//	POST {"level": "debug"}    -> {"level": "DEBUG"}
+//
+// The change is process-wide and lasts until restart. Behind the auth cookie
+// and denied to shared-session guests.
+func handleLogLevelAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		l, err := parseLogLevel(req.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prev := logLevel.Level()
+		logLevel.Set(l)
+		// Logged at the higher of the two levels so the change itself is
+		// always recorded.
+		requestLogger(r, "logging").Log(r.Context(), max(prev, l), "log level changed", "from", prev.String(), "to", l.String())
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"level": logLevel.Level().String()})
+}
@@ -0,0 +1,241 @@
+// websocket_proxy.go -- WebSocket relay for the agent chat proxy.
+//
+// agentChatProxyHandler hands WebSocket upgrades to handleWebSocketRelay,
+// which completes a real handshake on each side and relays frames between
+// them (gorilla/websocket both ways, so every hop of a proxy chain such as
+// Cloudflare -> cloudflared -> Traefik sees a proper handshake).
+//
+// The backend is dialed first, so a failure can still be answered over HTTP:
+// a backend that refuses the upgrade has its status passed through (a 401
+// stays a 401), anything else is a 502. http/https targets map to ws/wss;
+// TLS verification is skipped by default, as for the proxy's HTTP requests
+// (agentChatClient), because the backend is a local dev server.
+//
+// Once relaying, each side is pinged every wsRelayPingInterval. A side that
+// sends neither a frame nor a pong within IdleTimeout, or that stops
+// accepting writes for wsRelayWriteTimeout, ends the relay, so a hung backend
+// no longer holds the client connection and both goroutines forever.
+//
+// Counters are published with expvar under "wsRelay" (GET /debug/vars,
+// next to /debug/pprof).
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsRelayPingInterval is how often the relay pings each side.
+	wsRelayPingInterval = 30 * time.Second
+	// wsRelayWriteTimeout bounds a single frame write to either side.
+	wsRelayWriteTimeout = 10 * time.Second
+)
+
+// wsRelayConfig tunes handleWebSocketRelay.
+type wsRelayConfig struct {
+	HandshakeTimeout   time.Duration // dialing and upgrading the backend
+	IdleTimeout        time.Duration // max silence (no frame, no pong) from either side
+	InsecureSkipVerify bool          // skip TLS verification for https/wss targets
+}
+
+// defaultWSRelay is the configuration agentChatProxyHandler relays with.
+var defaultWSRelay = wsRelayConfig{
+	HandshakeTimeout:   10 * time.Second,
+	IdleTimeout:        90 * time.Second,
+	InsecureSkipVerify: true,
+}
+
+// wsRelayStats counts relays: active (open now), total (established),
+// dialFailures (backend unreachable or refused the upgrade) and idleTimeouts.
+var (
+	wsRelayStats        = expvar.NewMap("wsRelay")
+	wsRelayActive       = new(expvar.Int)
+	wsRelayTotal        = new(expvar.Int)
+	wsRelayDialFailures = new(expvar.Int)
+	wsRelayIdleTimeouts = new(expvar.Int)
+)
+
+func init() {
+	wsRelayStats.Set("active", wsRelayActive)
+	wsRelayStats.Set("total", wsRelayTotal)
+	wsRelayStats.Set("dialFailures", wsRelayDialFailures)
+	wsRelayStats.Set("idleTimeouts", wsRelayIdleTimeouts)
+}
+
+// wsRelayBackendURL is the ws/wss URL for a request relayed to target.
+func wsRelayBackendURL(target *url.URL, r *http.Request) (string, error) {
+	scheme := ""
+	switch target.Scheme {
+	case "http", "ws", "":
+		scheme = "ws"
+	case "https", "wss":
+		scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported backend scheme %q", target.Scheme)
+	}
+	return scheme + "://" + target.Host + singleJoiningSlash(target.Path, r.URL.Path) + querySuffix(r.URL.RawQuery), nil
+}
+
+func querySuffix(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	return "?" + rawQuery
+}
+
+// handleWebSocketRelay relays a WebSocket upgrade request to target with
+// defaultWSRelay.
+func handleWebSocketRelay(w http.ResponseWriter, r *http.Request, target *url.URL) {
+	defaultWSRelay.relay(w, r, target)
+}
+
+func (c wsRelayConfig) relay(w http.ResponseWriter, r *http.Request, target *url.URL) {
+	backendURL, err := wsRelayBackendURL(target, r)
+	if err != nil {
+		log.Printf("Agent chat proxy: WebSocket relay: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	// Dial backend FIRST -- if it's down we can still return an HTTP error.
+	dialer := websocket.Dialer{
+		HandshakeTimeout: c.HandshakeTimeout,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify},
+		Subprotocols:     websocket.Subprotocols(r),
+	}
+	header := http.Header{}
+	for _, key := range []string{"Cookie", "Authorization", "User-Agent"} {
+		if v := r.Header.Values(key); len(v) > 0 {
+			header[key] = v
+		}
+	}
+	backendConn, resp, err := dialer.DialContext(r.Context(), backendURL, header)
+	if err != nil {
+		wsRelayDialFailures.Add(1)
+		status := http.StatusBadGateway
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			// The backend answered but did not switch protocols; pass its
+			// verdict on rather than pretending it is unreachable.
+			resp.Body.Close()
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+				status = resp.StatusCode
+			}
+			log.Printf("Agent chat proxy: WebSocket backend refused upgrade: %s", resp.Status)
+		} else {
+			log.Printf("Agent chat proxy: WebSocket backend dial error: %v", err)
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer backendConn.Close()
+
+	// Upgrade the client, agreeing to whatever subprotocol the backend chose.
+	var respHeader http.Header
+	if p := backendConn.Subprotocol(); p != "" {
+		respHeader = http.Header{"Sec-Websocket-Protocol": {p}}
+	}
+	clientConn, err := upgrader.Upgrade(w, r, respHeader)
+	if err != nil {
+		log.Printf("Agent chat proxy: WebSocket client upgrade error: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	wsRelayTotal.Add(1)
+	wsRelayActive.Add(1)
+	defer wsRelayActive.Add(-1)
+
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() { once.Do(func() { close(done) }) }
+	client := newWSRelayPeer(clientConn, c.IdleTimeout)
+	backend := newWSRelayPeer(backendConn, c.IdleTimeout)
+	go client.pump(backend, stop, "WebSocket relay client->backend")
+	go backend.pump(client, stop, "WebSocket relay backend->client")
+	go client.ping(done)
+	go backend.ping(done)
+	<-done
+	// Closing both connections (deferred) unblocks the other pump's read.
+	if client.idle.Load() || backend.idle.Load() {
+		wsRelayIdleTimeouts.Add(1)
+	}
+}
+
+// wsRelayPeer is one side of a relay. gorilla/websocket allows one
+// concurrent writer, so frames and pings to a peer share writeMu.
+type wsRelayPeer struct {
+	conn        *websocket.Conn
+	idleTimeout time.Duration
+	writeMu     sync.Mutex
+	idle        atomic.Bool // reading stopped at the idle deadline
+}
+
+func newWSRelayPeer(conn *websocket.Conn, idleTimeout time.Duration) *wsRelayPeer {
+	p := &wsRelayPeer{conn: conn, idleTimeout: idleTimeout}
+	conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	})
+	return p
+}
+
+func (p *wsRelayPeer) write(mt int, data []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(wsRelayWriteTimeout))
+	return p.conn.WriteMessage(mt, data)
+}
+
+// pump copies frames from p to dst until either side fails.
+func (p *wsRelayPeer) pump(dst *wsRelayPeer, stop func(), where string) {
+	defer recoverGoroutine(where)
+	defer stop()
+	for {
+		mt, msg, err := p.conn.ReadMessage()
+		if err != nil {
+			var ne interface{ Timeout() bool }
+			var ce *websocket.CloseError
+			switch {
+			case errors.As(err, &ne) && ne.Timeout():
+				p.idle.Store(true)
+			case errors.As(err, &ce) && ce.Code != websocket.CloseAbnormalClosure:
+				// Pass a clean close on so the other side sees its code.
+				dst.write(websocket.CloseMessage, websocket.FormatCloseMessage(ce.Code, ce.Text))
+			}
+			return
+		}
+		p.conn.SetReadDeadline(time.Now().Add(p.idleTimeout))
+		if err := dst.write(mt, msg); err != nil {
+			return
+		}
+	}
+}
+
+// ping keeps the read deadline fed by pongs while the peer is alive.
+func (p *wsRelayPeer) ping(done <-chan struct{}) {
+	defer recoverGoroutine("WebSocket relay ping")
+	interval := min(wsRelayPingInterval, p.idleTimeout/3)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if err := p.write(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// worktree_conflicts.go -- warn when two live worktree sessions edit the same
+// files.
+//
+// Two sessions that branch off the same base and touch the same files will
+// collide: whichever merges second gets conflicts. Every
+// worktreeConflictInterval the analyzer lists, for each live top-level
+// session whose working directory is a worktree, the files it changed since
+// it forked from its base branch -- commits since the merge base, uncommitted
+// edits and untracked files. The base branch is the one checked out in the
+// repo's main working tree. Sessions of the same repo on the same base are
+// compared pairwise; files both changed are an overlap.
+//
+// Each affected session gets a {"type": "worktree_conflicts", "conflicts":
+// [...]} WebSocket message when its overlaps change (an empty list when they
+// clear), and its current overlaps ride along in the status message as
+// "worktreeConflicts". GET /api/worktrees/conflicts returns the whole
+// picture; ?refresh=1 analyzes now instead of returning the last pass.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// worktreeConflictInterval is how often the analyzer runs.
+const worktreeConflictInterval = 2 * time.Minute
+
+// worktreeConflictMaxFiles caps the files listed per overlap.
+const worktreeConflictMaxFiles = 50
+
+// worktreeChanges is what one worktree session changed since its base.
+type worktreeChanges struct {
+	SessionUUID string
+	SessionName string
+	Branch      string
+	WorkDir     string
+	Repo        string // git common dir, identifies the repo
+	BaseBranch  string
+	Files       map[string]bool
+}
+
+// worktreeOverlap is one session's view of a conflict with another session.
+type worktreeOverlap struct {
+	SessionUUID string   `json:"sessionUUID"` // the other session
+	SessionName string   `json:"sessionName,omitempty"`
+	Branch      string   `json:"branch"`
+	BaseBranch  string   `json:"baseBranch"`
+	Files       []string `json:"files"`
+	Truncated   int      `json:"truncated,omitempty"`
+}
+
+// worktreeConflict is one pair of sessions with overlapping changes.
+type worktreeConflict struct {
+	Repo       string   `json:"repo"`
+	BaseBranch string   `json:"baseBranch"`
+	Sessions   []string `json:"sessions"` // the two session UUIDs
+	Branches   []string `json:"branches"`
+	Files      []string `json:"files"`
+	Truncated  int      `json:"truncated,omitempty"`
+}
+
+var (
+	worktreeConflictsMu sync.Mutex
+	// worktreeConflictsBySession is each session's overlaps from the last pass.
+	worktreeConflictsBySession = map[string][]worktreeOverlap{}
+	worktreeConflictsAll       = []worktreeConflict{}
+	worktreeConflictsAt        time.Time
+)
+
+// worktreeConflictAnalyzer runs analyzeWorktreeConflicts periodically.
+func worktreeConflictAnalyzer() {
+	defer recoverGoroutine("worktree conflict analyzer")
+	ticker := time.NewTicker(worktreeConflictInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		analyzeWorktreeConflicts()
+	}
+}
+
+// collectWorktreeChanges returns the files c.WorkDir changed since
+// its base branch, or nil when they cannot be determined.
+func collectWorktreeChanges(c worktreeChanges) *worktreeChanges {
+	common, err := gitInWorkDir(c.WorkDir, "rev-parse", "--path-format=absolute", "--git-common-dir")
+	if err != nil {
+		return nil
+	}
+	c.Repo = strings.TrimSpace(common)
+	mainTree, err := mainWorktree(c.WorkDir)
+	if err != nil {
+		return nil
+	}
+	if filepath.Clean(mainTree) == filepath.Clean(c.WorkDir) {
+		return nil // the main tree itself is not a worktree session
+	}
+	base, err := gitInWorkDir(mainTree, "branch", "--show-current")
+	if c.BaseBranch = strings.TrimSpace(base); err != nil || c.BaseBranch == "" {
+		return nil
+	}
+	mergeBase, err := gitInWorkDir(c.WorkDir, "merge-base", "HEAD", "refs/heads/"+c.BaseBranch)
+	if err != nil {
+		return nil
+	}
+	c.Files = map[string]bool{}
+	if out, err := gitInWorkDir(c.WorkDir, "diff", "--name-only", "-z", strings.TrimSpace(mergeBase)); err == nil {
+		for _, p := range strings.Split(out, "\x00") {
+			if p != "" {
+				c.Files[p] = true
+			}
+		}
+	}
+	if out, err := gitInWorkDir(c.WorkDir, "ls-files", "-z", "--others", "--exclude-standard"); err == nil {
+		for _, p := range strings.Split(out, "\x00") {
+			if p != "" {
+				c.Files[p] = true
+			}
+		}
+	}
+	return &c
+}
+
+// mainWorktree returns the repo's main working tree for any of its worktrees:
+// the first `git worktree list` entry.
+func mainWorktree(dir string) (string, error) {
+	list, err := gitInWorkDir(dir, "worktree", "list", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	mainTree, _, _ := strings.Cut(strings.TrimPrefix(list, "worktree "), "\n")
+	return mainTree, nil
+}
+
+// findWorktreeConflicts compares changes pairwise within each repo and base.
+func findWorktreeConflicts(changes []*worktreeChanges) []worktreeConflict {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].SessionUUID < changes[j].SessionUUID })
+	conflicts := []worktreeConflict{}
+	for i, a := range changes {
+		for _, b := range changes[i+1:] {
+			if a.Repo != b.Repo || a.BaseBranch != b.BaseBranch || a.WorkDir == b.WorkDir {
+				continue
+			}
+			var files []string
+			for p := range a.Files {
+				if b.Files[p] {
+					files = append(files, p)
+				}
+			}
+			if len(files) == 0 {
+				continue
+			}
+			sort.Strings(files)
+			c := worktreeConflict{
+				Repo:       a.Repo,
+				BaseBranch: a.BaseBranch,
+				Sessions:   []string{a.SessionUUID, b.SessionUUID},
+				Branches:   []string{a.Branch, b.Branch},
+				Files:      files,
+			}
+			if len(files) > worktreeConflictMaxFiles {
+				c.Files, c.Truncated = files[:worktreeConflictMaxFiles], len(files)-worktreeConflictMaxFiles
+			}
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+
+// analyzeWorktreeConflicts runs one pass and notifies sessions whose overlaps
+// changed.
+func analyzeWorktreeConflicts() {
+	sessionsMu.RLock()
+	var candidates []worktreeChanges
+	live := map[string]*Session{}
+	for uuid, sess := range sessions {
+		if sess.ParentUUID != "" || !isWorktreeWorkDir(sess.WorkDir) {
+			continue
+		}
+		live[uuid] = sess
+		candidates = append(candidates, worktreeChanges{SessionUUID: uuid, SessionName: sess.Name, Branch: sess.BranchName, WorkDir: sess.WorkDir})
+	}
+	sessionsMu.RUnlock()
+
+	var changes []*worktreeChanges
+	byUUID := map[string]*worktreeChanges{}
+	for _, c := range candidates {
+		if ch := collectWorktreeChanges(c); ch != nil {
+			changes = append(changes, ch)
+			byUUID[ch.SessionUUID] = ch
+		}
+	}
+	conflicts := findWorktreeConflicts(changes)
+
+	bySession := map[string][]worktreeOverlap{}
+	for _, c := range conflicts {
+		for i, uuid := range c.Sessions {
+			other := byUUID[c.Sessions[1-i]]
+			bySession[uuid] = append(bySession[uuid], worktreeOverlap{
+				SessionUUID: other.SessionUUID,
+				SessionName: other.SessionName,
+				Branch:      other.Branch,
+				BaseBranch:  c.BaseBranch,
+				Files:       c.Files,
+				Truncated:   c.Truncated,
+			})
+		}
+	}
+
+	worktreeConflictsMu.Lock()
+	prev := worktreeConflictsBySession
+	worktreeConflictsBySession = bySession
+	worktreeConflictsAll = conflicts
+	worktreeConflictsAt = time.Now()
+	worktreeConflictsMu.Unlock()
+
+	for uuid, sess := range live {
+		if reflect.DeepEqual(prev[uuid], bySession[uuid]) {
+			continue
+		}
+		overlaps := bySession[uuid]
+		if overlaps == nil {
+			overlaps = []worktreeOverlap{}
+		} else {
+			sess.logger().Warn("worktree overlaps other sessions", "sessions", len(overlaps))
+		}
+		sess.BroadcastJSON(map[string]any{"type": "worktree_conflicts", "conflicts": overlaps})
+	}
+}
+
+// sessionWorktreeConflicts returns the session's overlaps from the last pass.
+func sessionWorktreeConflicts(uuid string) []worktreeOverlap {
+	worktreeConflictsMu.Lock()
+	defer worktreeConflictsMu.Unlock()
+	return worktreeConflictsBySession[uuid]
+}
+
+// handleWorktreeConflictsAPI handles GET /api/worktrees/conflicts[?refresh=1].
+func handleWorktreeConflictsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Get("refresh") == "1" {
+		analyzeWorktreeConflicts()
+	}
+	worktreeConflictsMu.Lock()
+	resp := map[string]any{"conflicts": worktreeConflictsAll}
+	if !worktreeConflictsAt.IsZero() {
+		resp["checkedAt"] = worktreeConflictsAt
+	}
+	worktreeConflictsMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,638 @@
+// backup.go -- scheduled backups of recordings and metadata.
+//
+// With SWE_BACKUP_SCHEDULE and SWE_BACKUP_DEST set, backupScheduler writes
+// the state export (state_export.go) to the destination on a cron schedule,
+// so recordings and session history survive a lost Docker volume without
+// anyone remembering to copy it. A backup is restored like any export, with
+// POST /api/data/import.
+//
+//	SWE_BACKUP_SCHEDULE  cron expression, "minute hour day month weekday"
+//	                     in the server's time zone, or @hourly, @daily,
+//	                     @weekly
+//	SWE_BACKUP_DEST      an absolute directory, or s3://bucket/prefix
+//	SWE_BACKUP_KEEP      backups kept at the destination (default 7); older
+//	                     ones are deleted after each backup
+//
+// S3 uploads take the usual AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN and AWS_REGION, and AWS_ENDPOINT_URL_S3 or
+// AWS_ENDPOINT_URL for S3-compatible stores such as MinIO.
+//
+//	GET  /api/backups  schedule, last run, next run and the stored backups
+//	POST /api/backups  run a backup now
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupDefaultKeep = 7
+	backupNamePrefix  = "swe-swe-backup-"
+	backupNameSuffix  = ".tar.gz"
+)
+
+// backupConfig is the SWE_BACKUP_* settings; Schedule is nil when backups
+// are off.
+var backupConfig struct {
+	Spec     string
+	Schedule *cronSchedule
+	Dest     string
+	Keep     int
+	Target   backupTarget
+}
+
+// backupRun is the outcome of one backup.
+type backupRun struct {
+	Name       string    `json:"name,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Deleted    []string  `json:"deleted,omitempty"` // older backups rotated out
+	Error      string    `json:"error,omitempty"`
+}
+
+// backupState is the scheduler's progress, shown by GET /api/backups.
+var backupState struct {
+	mu      sync.Mutex
+	running bool
+	last    *backupRun
+	next    time.Time
+}
+
+// backupTarget is where backups are stored.
+type backupTarget interface {
+	// put stores the backup file f of size bytes as name.
+	put(name string, f *os.File, size int64) error
+	// list returns the names of the stored backups.
+	list() ([]string, error)
+	remove(name string) error
+	String() string
+}
+
+// loadBackupConfig applies SWE_BACKUP_SCHEDULE, SWE_BACKUP_DEST and
+// SWE_BACKUP_KEEP.
+func loadBackupConfig() error {
+	backupConfig.Spec = strings.TrimSpace(os.Getenv("SWE_BACKUP_SCHEDULE"))
+	backupConfig.Dest = strings.TrimSpace(os.Getenv("SWE_BACKUP_DEST"))
+	backupConfig.Schedule, backupConfig.Target = nil, nil
+	backupConfig.Keep = backupDefaultKeep
+	if v := strings.TrimSpace(os.Getenv("SWE_BACKUP_KEEP")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("SWE_BACKUP_KEEP=%q: want a number of backups, at least 1", v)
+		}
+		backupConfig.Keep = n
+	}
+	switch {
+	case backupConfig.Spec == "" && backupConfig.Dest == "":
+		return nil
+	case backupConfig.Spec == "":
+		return errors.New("SWE_BACKUP_DEST is set without SWE_BACKUP_SCHEDULE")
+	case backupConfig.Dest == "":
+		return errors.New("SWE_BACKUP_SCHEDULE is set without SWE_BACKUP_DEST")
+	}
+	schedule, err := parseCronSchedule(backupConfig.Spec)
+	if err != nil {
+		return fmt.Errorf("SWE_BACKUP_SCHEDULE=%q: %w", backupConfig.Spec, err)
+	}
+	target, err := newBackupTarget(backupConfig.Dest)
+	if err != nil {
+		return fmt.Errorf("SWE_BACKUP_DEST=%q: %w", backupConfig.Dest, err)
+	}
+	backupConfig.Schedule, backupConfig.Target = schedule, target
+	return nil
+}
+
+// newBackupTarget returns the target for a SWE_BACKUP_DEST value.
+func newBackupTarget(dest string) (backupTarget, error) {
+	if rest, ok := strings.CutPrefix(dest, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		if bucket == "" {
+			return nil, errors.New("no bucket")
+		}
+		return newS3BackupTarget(bucket, prefix)
+	}
+	if !filepath.IsAbs(dest) {
+		return nil, errors.New("want an absolute directory or s3://bucket/prefix")
+	}
+	return localBackupTarget(filepath.Clean(dest)), nil
+}
+
+// backupScheduler runs a backup at each scheduled time.
+func backupScheduler() {
+	defer recoverGoroutine("backup scheduler")
+	if backupConfig.Schedule == nil {
+		return
+	}
+	log.Printf("Backups: %q to %s, keeping %d", backupConfig.Spec, backupConfig.Target, backupConfig.Keep)
+	for {
+		next := backupConfig.Schedule.next(time.Now())
+		backupState.mu.Lock()
+		backupState.next = next
+		backupState.mu.Unlock()
+		time.Sleep(time.Until(next))
+		if run, err := runBackup(time.Now()); err != nil {
+			log.Printf("Backup: %v", err)
+		} else if run.Error != "" {
+			log.Printf("Backup %s: %s", run.Name, run.Error)
+		}
+	}
+}
+
+// errBackupRunning is returned when a backup is asked for during another.
+var errBackupRunning = errors.New("a backup is already running")
+
+// runBackup writes a backup to the target and rotates out the oldest. A
+// failed backup is reported in the run, not as the error.
+func runBackup(now time.Time) (backupRun, error) {
+	backupState.mu.Lock()
+	if backupState.running {
+		backupState.mu.Unlock()
+		return backupRun{}, errBackupRunning
+	}
+	backupState.running = true
+	backupState.mu.Unlock()
+
+	run := backupRun{Name: backupNamePrefix + now.Format("20060102-150405") + backupNameSuffix, StartedAt: now}
+	if err := writeBackup(&run, now); err != nil {
+		run.Error = err.Error()
+	}
+	run.FinishedAt = time.Now()
+
+	backupState.mu.Lock()
+	backupState.running = false
+	backupState.last = &run
+	backupState.mu.Unlock()
+	return run, nil
+}
+
+// writeBackup exports to a temporary file, stores it and rotates.
+func writeBackup(run *backupRun, now time.Time) error {
+	f, err := os.CreateTemp("", "swe-swe-backup-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := writeStateExport(f, true, now); err != nil {
+		return err
+	}
+	if run.Size, err = f.Seek(0, io.SeekCurrent); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := backupConfig.Target.put(run.Name, f, run.Size); err != nil {
+		return err
+	}
+	run.Deleted, err = rotateBackups(backupConfig.Target, backupConfig.Keep)
+	return err
+}
+
+// rotateBackups deletes all but the newest keep backups. Names sort by time.
+func rotateBackups(target backupTarget, keep int) ([]string, error) {
+	names, err := target.list()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var deleted []string
+	for len(names) > keep {
+		if err := target.remove(names[0]); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, names[0])
+		names = names[1:]
+	}
+	return deleted, nil
+}
+
+// isBackupName reports whether name is a backup this server wrote.
+func isBackupName(name string) bool {
+	return strings.HasPrefix(name, backupNamePrefix) && strings.HasSuffix(name, backupNameSuffix)
+}
+
+// handleBackupsAPI serves GET and POST /api/backups.
+func handleBackupsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if backupConfig.Schedule == nil {
+		http.Error(w, "Backups are off: set SWE_BACKUP_SCHEDULE and SWE_BACKUP_DEST", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPost {
+		run, err := runBackup(time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if run.Error != "" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		json.NewEncoder(w).Encode(run)
+		return
+	}
+
+	status := map[string]any{
+		"schedule":    backupConfig.Spec,
+		"destination": backupConfig.Target.String(),
+		"keep":        backupConfig.Keep,
+	}
+	backupState.mu.Lock()
+	status["running"] = backupState.running
+	if backupState.last != nil {
+		status["lastRun"] = *backupState.last
+	}
+	if !backupState.next.IsZero() {
+		status["nextRun"] = backupState.next
+	}
+	backupState.mu.Unlock()
+	names, err := backupConfig.Target.list()
+	if err != nil {
+		status["listError"] = err.Error()
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	status["backups"] = append([]string{}, names...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// localBackupTarget stores backups in a directory.
+type localBackupTarget string
+
+func (d localBackupTarget) String() string { return string(d) }
+
+func (d localBackupTarget) put(name string, f *os.File, size int64) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	return writeImportedFile(filepath.Join(string(d), name), io.LimitReader(f, size))
+}
+
+func (d localBackupTarget) list() ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && isBackupName(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d localBackupTarget) remove(name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+// s3BackupTarget stores backups in an S3 bucket under prefix, signing
+// requests with AWS Signature Version 4.
+type s3BackupTarget struct {
+	bucket, prefix string
+	region         string
+	endpoint       *url.URL // nil: https://<bucket>.s3.<region>.amazonaws.com
+	accessKey      string
+	secretKey      string
+	sessionToken   string
+	client         *http.Client
+}
+
+func newS3BackupTarget(bucket, prefix string) (*s3BackupTarget, error) {
+	t := &s3BackupTarget{
+		bucket:       bucket,
+		prefix:       strings.Trim(prefix, "/"),
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 30 * time.Minute},
+	}
+	if t.prefix != "" {
+		t.prefix += "/"
+	}
+	if t.region == "" {
+		t.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if t.region == "" {
+		t.region = "us-east-1"
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for s3://")
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("bad S3 endpoint %q", endpoint)
+		}
+		t.endpoint = u
+	}
+	return t, nil
+}
+
+func (t *s3BackupTarget) String() string { return "s3://" + t.bucket + "/" + t.prefix }
+
+// objectURL returns the URL of key, or of the bucket for "". A custom
+// endpoint is addressed path-style, AWS virtual-hosted-style.
+func (t *s3BackupTarget) objectURL(key string) *url.URL {
+	if t.endpoint != nil {
+		u := *t.endpoint
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + t.bucket + "/" + key
+		return &u
+	}
+	return &url.URL{Scheme: "https", Host: t.bucket + ".s3." + t.region + ".amazonaws.com", Path: "/" + key}
+}
+
+func (t *s3BackupTarget) put(name string, f *os.File, size int64) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.LimitReader(f, size)); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, t.objectURL(t.prefix+name).String(), io.LimitReader(f, size))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	_, err = t.do(req, hex.EncodeToString(h.Sum(nil)))
+	return err
+}
+
+func (t *s3BackupTarget) list() ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		u := t.objectURL("")
+		q := url.Values{"list-type": {"2"}, "prefix": {t.prefix + backupNamePrefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := t.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("S3 list: %w", err)
+		}
+		for _, c := range result.Contents {
+			if name := strings.TrimPrefix(c.Key, t.prefix); isBackupName(name) && !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (t *s3BackupTarget) remove(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, t.objectURL(t.prefix+name).String(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = t.do(req, emptyPayloadHash)
+	return err
+}
+
+// do signs and sends req, returning the response body of a 2xx response.
+func (t *s3BackupTarget) do(req *http.Request, payloadHash string) ([]byte, error) {
+	if t.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.sessionToken)
+	}
+	signS3Request(req, payloadHash, t.accessKey, t.secretKey, t.region, time.Now())
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("S3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signS3Request adds the X-Amz-Date, X-Amz-Content-Sha256 and Authorization
+// headers of AWS Signature Version 4, signing the host and every header
+// already set.
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalS3Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalS3Query is the query string sorted and escaped as SigV4 wants:
+// spaces as %20, not +.
+func canonicalS3Query(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// cronSchedule is a parsed five-field cron expression.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bit n set: value n matches
+	domAny, dowAny                bool   // the field was "*"
+}
+
+// parseCronSchedule parses "minute hour day month weekday" or an @alias.
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("want 5 fields (minute hour day month weekday) or @hourly, @daily, @weekly")
+	}
+	var s cronSchedule
+	var err error
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}} {
+		if *f.bits, err = parseCronField(fields[i], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("field %d %q: %w", i+1, fields[i], err)
+		}
+	}
+	if s.dow&(1<<7) != 0 { // 7 is Sunday too
+		s.dow |= 1
+	}
+	s.domAny, s.dowAny = fields[2] == "*", fields[4] == "*"
+	if s.next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, errors.New("never runs")
+	}
+	return &s, nil
+}
+
+// parseCronField parses one field: *, n, a-b, and lists of them, each
+// optionally /step.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step %q", stepText)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", a)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad value %q", b)
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q is outside %d-%d", rng, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first matching minute after t, or the zero time when
+// none falls within five years.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies cron's day rule: when both day of month and weekday
+// are restricted, either may match.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,172 @@
+// session_autoname.go -- name a session after its first prompt.
+//
+// A session started without a name is called after its repo and branch
+// ("owner/repo@main"), so a list of them is a wall of the same name. With
+// SWE_SESSION_AUTONAME=1 (config key session.autoName) the first prompt
+// submitted to a session -- Enter after at least two typed characters, in
+// the terminal or through send_session_input, the same detection as
+// prompt checkpoints (checkpoints.go) -- names it, when its name is still
+// empty or the one derived from the repo:
+//
+//	"Fix the login redirect, it loops on Safari!" -> "Fix the login redirect it loops on Safari"
+//
+// The text is cut to autoNameMaxLen at a word boundary and reduced to the
+// characters a session name may hold (accents dropped, "-_./" kept inside
+// words only, anything else a space); a prompt with fewer than two letters
+// or digits left names nothing.
+// The rename goes through renameSession, so it is persisted, broadcast,
+// passed on to terminal tabs and subject to SWE_SESSION_NAMES. The branch
+// the session runs on does not change: the status frame carries the name's
+// branch-safe form (deriveBranchName) as nameBranch, for display only.
+//
+// Only the first prompt counts, whether or not it renamed the session, and a
+// name the user gave or changed is never replaced. Input typed while the
+// terminal is not echoing (a password at a getpass prompt, input_redact.go)
+// is not a prompt.
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// autoNameMaxLen is the longest name taken from a prompt, in bytes.
+const autoNameMaxLen = 48
+
+// sessionAutoNamer names one session after its first prompt.
+type sessionAutoNamer struct {
+	derived string             // the name derived from the repo, "" when none
+	current func() string      // the session's name now
+	rename  func(string) error // renameSession
+
+	mu   sync.Mutex
+	keys []byte // terminal input since the last Enter
+	done bool   // the first prompt has been seen
+	set  string // the name given, once given
+}
+
+// newSessionAutoNamer returns the namer for a session created with the name
+// derived (empty if it was created without one), or nil when
+// SWE_SESSION_AUTONAME is not 1.
+func newSessionAutoNamer(derived string, current func() string, rename func(string) error) *sessionAutoNamer {
+	if os.Getenv("SWE_SESSION_AUTONAME") != "1" {
+		return nil
+	}
+	return &sessionAutoNamer{derived: derived, current: current, rename: rename}
+}
+
+// noteInput watches terminal input for the first submitted prompt. noEcho is
+// set when the terminal was not echoing data.
+func (a *sessionAutoNamer) noteInput(data []byte, noEcho bool) {
+	if a == nil || len(data) == 0 {
+		return
+	}
+	a.mu.Lock()
+	if a.done {
+		a.mu.Unlock()
+		return
+	}
+	if noEcho {
+		data = []byte(strings.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' {
+				return r
+			}
+			return inputRedactMask
+		}, string(data)))
+	}
+	a.keys = append(a.keys, data...)
+	if len(a.keys) > 8<<10 {
+		a.keys = a.keys[len(a.keys)-8<<10:]
+	}
+	if !submitsPrompt(a.keys) {
+		a.mu.Unlock()
+		return
+	}
+	text := promptText(a.keys)
+	a.keys = a.keys[:0]
+	if utf8.RuneCountInString(text) < 2 || redactedText(text) {
+		a.mu.Unlock()
+		return
+	}
+	a.done, a.keys = true, nil
+	a.mu.Unlock()
+	go a.apply(text)
+}
+
+// apply names the session after prompt unless it already has a name of its
+// own.
+func (a *sessionAutoNamer) apply(prompt string) {
+	defer recoverGoroutine("session auto-name")
+	if current := a.current(); current != "" && current != a.derived {
+		return
+	}
+	name := promptSessionName(prompt)
+	if name == "" {
+		return
+	}
+	if err := a.rename(name); err != nil {
+		log.Printf("Session auto-name %q: %v", name, err)
+		return
+	}
+	a.mu.Lock()
+	a.set = a.current()
+	a.mu.Unlock()
+}
+
+// named reports whether name is the one the namer gave the session.
+func (a *sessionAutoNamer) named(name string) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.set != "" && a.set == name
+}
+
+// promptSessionName turns a prompt into a session name renameSession
+// accepts, or "" when too little of it is left.
+func promptSessionName(prompt string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	s, _, _ := transform.String(t, prompt)
+	s = strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./", r)) {
+			return r
+		}
+		return ' '
+	}, s)
+	var name string
+	for _, word := range strings.Fields(s) {
+		if word = strings.Trim(word, "-_./"); word == "" {
+			continue
+		}
+		if name == "" && len(word) > autoNameMaxLen {
+			name = word[:autoNameMaxLen]
+			break
+		}
+		if len(name)+1+len(word) > autoNameMaxLen {
+			break
+		}
+		if name != "" {
+			name += " "
+		}
+		name += word
+	}
+	alnum := 0
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			alnum++
+		}
+	}
+	if alnum < 2 {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,123 @@
+// session_scrollback.go -- GET /api/session/{uuid}/scrollback: a session's
+// output by byte offset, from its recording log.
+//
+// The WebSocket replays at most the 512KB ring buffer, so a page cannot
+// scroll back further than that, and keeping a whole day of output in the
+// browser is not an option either. The recording log (session_recorder.go)
+// has all of it on disk; this endpoint serves a window of it:
+//
+//	GET /api/session/{uuid}/scrollback?from=0&limit=65536
+//
+// Offsets count output bytes from the start of the log, after its "Script
+// started on" header. from defaults to 0; a negative from counts back from
+// the end, so from=-65536 is the latest 64KB. limit defaults to 64KB and is
+// capped at 1MB. The body is the raw output bytes, and the headers place it:
+//
+//	X-Scrollback-From: 131072    offset of the first byte returned
+//	X-Scrollback-Next: 196608    offset just past the last one
+//	X-Scrollback-Size: 5242880   output recorded so far
+//
+// To scroll back page by page, start from a negative offset and request
+// from=From-limit until From is 0. The log only has what was recorded:
+// output while recording was paused is not in it, and it starts over when
+// the agent is restarted (YOLO toggle).
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	scrollbackDefaultLimit = 64 * 1024
+	scrollbackMaxLimit     = 1024 * 1024
+)
+
+// scrollbackBounds returns where the output in a recording log of size bytes
+// starts and ends: after the header line, and before the "Script done on"
+// footer once the recorder has closed.
+func scrollbackBounds(f *os.File, size int64) (start, end int64) {
+	head := make([]byte, min(size, 4096))
+	n, _ := f.ReadAt(head, 0)
+	head = head[:n]
+	if bytes.HasPrefix(head, []byte("Script started on ")) {
+		if i := bytes.IndexByte(head, '\n'); i >= 0 {
+			start = int64(i + 1)
+		}
+	}
+	end = size
+	tailStart := max(start, size-256)
+	tail := make([]byte, size-tailStart)
+	n, _ = f.ReadAt(tail, tailStart)
+	if i := bytes.LastIndex(tail[:n], []byte("\nScript done on ")); i >= 0 {
+		end = tailStart + int64(i)
+	}
+	return start, end
+}
+
+// handleSessionScrollbackAPI serves GET /api/session/{uuid}/scrollback.
+func handleSessionScrollbackAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, limit := int64(0), int64(scrollbackDefaultLimit)
+	if v := q.Get("from"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+		from = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, scrollbackMaxLimit)
+	}
+
+	sessionUUID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/scrollback")
+	sessionsMu.RLock()
+	sess := sessions[sessionUUID]
+	sessionsMu.RUnlock()
+	if sessionUUID == "" || sess == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(recordingPath(sess.RecordingPrefix + ".log"))
+	if err != nil {
+		http.Error(w, "Session has no recording", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	start, end := scrollbackBounds(f, info.Size())
+	size := end - start
+	if from < 0 {
+		from = max(size+from, 0)
+	}
+	from = min(from, size)
+	buf := make([]byte, min(limit, size-from))
+	n, _ := f.ReadAt(buf, start+from)
+	buf = buf[:n]
+
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Scrollback-From", strconv.FormatInt(from, 10))
+	h.Set("X-Scrollback-Next", strconv.FormatInt(from+int64(n), 10))
+	h.Set("X-Scrollback-Size", strconv.FormatInt(size, 10))
+	w.Write(buf)
+}
@@ -0,0 +1,188 @@
+// session_names.go -- optional uniqueness of session names.
+//
+// Two sessions could share a name, and since the worktree branch is derived
+// from the name (deriveBranchName), two sessions called "Fix login" ended up
+// on the same fix-login worktree. SWE_SESSION_NAMES picks a policy:
+//
+//	allow   duplicates are fine (the default)
+//	suffix  a taken name gets the first free "-2", "-3", ... suffix
+//	reject  creating or renaming to a taken name fails with name_taken
+//
+// A name is taken when another running top-level session has it (ignoring
+// case) or has a name that derives the same branch. A suffixed name also
+// skips names whose branch already has a worktree, so it never lands in
+// another session's checkout. Only names the user gave are checked: names
+// derived from the repo ({owner}/{repo}@{branch}) and terminal tabs are not.
+//
+// getOrCreateSession applies the policy under sessionsMu, and moves the
+// branch along with the name when the branch was derived from it. A refusal
+// is a *sessionNameError: 409 JSON from POST /api/session/new,
+// session_error with code name_taken on the WebSocket, rename_error for
+// rename_session. GET /api/session/name-check?name=[&uuid=] answers ahead of
+// time, so the New Session dialog can say so inline.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	sessionNamesAllow  = "allow"
+	sessionNamesSuffix = "suffix"
+	sessionNamesReject = "reject"
+)
+
+// sessionNamePolicy is SWE_SESSION_NAMES.
+var sessionNamePolicy = sessionNamesAllow
+
+// loadSessionNamePolicy applies SWE_SESSION_NAMES.
+func loadSessionNamePolicy() error {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("SWE_SESSION_NAMES")))
+	switch v {
+	case "":
+		sessionNamePolicy = sessionNamesAllow
+	case sessionNamesAllow, sessionNamesSuffix, sessionNamesReject:
+		sessionNamePolicy = v
+	default:
+		return fmt.Errorf("SWE_SESSION_NAMES=%q: want allow, suffix or reject", v)
+	}
+	return nil
+}
+
+// sessionNameError is a refused session name.
+type sessionNameError struct {
+	Name       string `json:"name"`
+	Session    string `json:"session"` // UUID of the session that has it
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (e *sessionNameError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("A session named %q is already running. Pick another name, such as %q.", e.Name, e.Suggestion)
+	}
+	return fmt.Sprintf("A session named %q is already running. Pick another name.", e.Name)
+}
+
+// sessionNameHolderLocked returns the UUID of a running top-level session
+// other than self whose name collides with name, or "". Must be called
+// while holding sessionsMu.
+func sessionNameHolderLocked(name, self string) string {
+	branch := deriveBranchName(name)
+	for id, sess := range sessions {
+		if id == self || sess.ParentUUID != "" || sess.reapable() {
+			continue
+		}
+		sess.mu.RLock()
+		other := sess.Name
+		sess.mu.RUnlock()
+		if other == "" {
+			continue
+		}
+		if strings.EqualFold(other, name) || (branch != "" && deriveBranchName(other) == branch) {
+			return id
+		}
+	}
+	return ""
+}
+
+// nameSuffix matches a "-N" suffix added by an earlier dedup.
+var nameSuffix = regexp.MustCompile(`-([0-9]+)$`)
+
+// freeSessionNameLocked returns the first "{name}-N" that no other session
+// has and whose branch has no worktree. An existing "-N" suffix is counted
+// up rather than suffixed again. Must be called while holding sessionsMu.
+func freeSessionNameLocked(name, self string) string {
+	base, n := name, 2
+	if m := nameSuffix.FindStringSubmatch(name); m != nil {
+		if i, err := strconv.Atoi(m[1]); err == nil && i < 1000 {
+			base, n = strings.TrimSuffix(name, m[0]), i+1
+		}
+	}
+	for ; ; n++ {
+		candidate := base + "-" + strconv.Itoa(n)
+		if sessionNameHolderLocked(candidate, self) != "" {
+			continue
+		}
+		if branch := deriveBranchName(candidate); branch != "" && worktreeExists(branch) {
+			continue
+		}
+		return candidate
+	}
+}
+
+// resolveSessionNameLocked applies the policy to a name the user gave
+// session self: it returns the name to use, or a *sessionNameError. Must be
+// called while holding sessionsMu.
+func resolveSessionNameLocked(name, self string) (string, error) {
+	if name == "" || sessionNamePolicy == sessionNamesAllow {
+		return name, nil
+	}
+	holder := sessionNameHolderLocked(name, self)
+	if holder == "" {
+		return name, nil
+	}
+	suggestion := freeSessionNameLocked(name, self)
+	if sessionNamePolicy == sessionNamesSuffix {
+		return suggestion, nil
+	}
+	return "", &sessionNameError{Name: name, Session: holder, Suggestion: suggestion}
+}
+
+// resolveSessionName is resolveSessionNameLocked for callers not holding
+// sessionsMu.
+func resolveSessionName(name, self string) (string, error) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	return resolveSessionNameLocked(name, self)
+}
+
+// writeSessionNameError answers an API request refused for its name.
+func writeSessionNameError(w http.ResponseWriter, err *sessionNameError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+		*sessionNameError
+	}{wsErrNameTaken, err.Error(), err})
+}
+
+// handleSessionNameCheckAPI serves GET /api/session/name-check?name=[&uuid=]:
+// what the policy would do with name for a new session, or for session uuid
+// when renaming it.
+func handleSessionNameCheckAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	self := r.URL.Query().Get("uuid")
+	resp := struct {
+		Policy     string `json:"policy"`
+		Name       string `json:"name"`
+		Taken      bool   `json:"taken"`
+		Session    string `json:"session,omitempty"`
+		Allowed    bool   `json:"allowed"`
+		Use        string `json:"use,omitempty"` // the name the session would get
+		Suggestion string `json:"suggestion,omitempty"`
+	}{Policy: sessionNamePolicy, Name: name}
+	sessionsMu.RLock()
+	if name != "" {
+		resp.Session = sessionNameHolderLocked(name, self)
+		resp.Taken = resp.Session != ""
+		if resp.Taken {
+			resp.Suggestion = freeSessionNameLocked(name, self)
+		}
+	}
+	use, err := resolveSessionNameLocked(name, self)
+	sessionsMu.RUnlock()
+	resp.Allowed, resp.Use = err == nil, use
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -151,6 +151,75 @@ function markCardCommitting(card) {
     if (join && !join.title) { join.title = COMMITTING_HINT; }
 }
 
+// Point the card's screen preview at the thumbnail with this hash. The hash
+// only changes when the session's screen does, so an idle card never refetches.
+function updateCardThumb(card, hash) {
+    var link = card.querySelector('.session-card__thumb');
+    if (!link || !hash || link.dataset.thumb === hash) { return; }
+    link.dataset.thumb = hash;
+    var img = link.querySelector('img');
+    if (!img) {
+        img = document.createElement('img');
+        img.alt = 'Screen of session-' + card.dataset.sessionUuid.slice(0, 5);
+        link.appendChild(img);
+    }
+    img.src = '/api/session/' + card.dataset.sessionUuid + '/thumbnail?h=' + encodeURIComponent(hash);
+}
+
+// Color the card's icon by the agent's state (agent_state.go): green when it
+// waits for a reply, red while it works, amber when it asks to run a tool.
+// Same mapping as agentStateSummaryStatus on the server.
+var AGENT_STATE_STATUS = {
+    idle: ['status-green', 'Waiting for your reply'],
+    busy: ['status-red', 'Agent is working'],
+    waiting_approval: ['status-amber', 'Waiting for your approval']
+};
+
+function updateCardAgentState(card, state) {
+    var icon = card.querySelector('.session-card__repo svg');
+    var status = AGENT_STATE_STATUS[state];
+    if (!icon || !status) { return; }
+    icon.classList.remove('status-green', 'status-red', 'status-amber');
+    icon.classList.add(status[0]);
+    icon.setAttribute('title', status[1]);
+}
+
+// Refresh the card's health line and CPU / quiet figures from the server's
+// checks (session_health.go). Mirrors the selection.html card template.
+function updateCardHealth(card, health) {
+    if (!health) { return; }
+    var problems = card.querySelector('.session-card__health');
+    if (problems) {
+        var text = (health.problems || []).join('; ');
+        problems.textContent = text;
+        problems.hidden = !text;
+    }
+    var cpu = card.querySelector('.session-card__cpu');
+    if (cpu) {
+        var hasCPU = typeof health.cpuPercent === 'number';
+        cpu.hidden = !hasCPU;
+        if (hasCPU) {
+            cpu.querySelector('.session-card__cpu-text').textContent = Math.round(health.cpuPercent) + '% CPU';
+        }
+    }
+    var quiet = card.querySelector('.session-card__quiet');
+    if (quiet) {
+        var age = health.lastOutputAge || 0;
+        quiet.hidden = age < 60;
+        if (age >= 60) {
+            quiet.querySelector('.session-card__quiet-text').textContent = 'quiet ' + formatQuietAge(age);
+        }
+    }
+}
+
+// Same format as formatDuration on the server: "5m", "1h 23m".
+function formatQuietAge(seconds) {
+    var h = Math.floor(seconds / 3600);
+    var m = Math.floor((seconds % 3600) / 60);
+    if (h > 0) { return m > 0 ? h + 'h ' + m + 'm' : h + 'h'; }
+    return m + 'm';
+}
+
 // Reconcile the rendered session cards against the server's live set: flag the
 // ones being torn down, drop the ones that are gone. The homepage is otherwise
 // server-rendered with no polling, so without this an ended session's card
@@ -216,6 +285,13 @@ function pollLiveSessions() {
             var live = {};
             body.sessions.forEach(function(s) { live[s.uuid] = s; });
 
+            // Flag agents the last auth check found logged out
+            // (assistant_auth.go) in the new-session dialog.
+            (body.assistants || []).forEach(function(a) {
+                var label = document.querySelector('.dialog__agent[data-agent="' + a.binary + '"]');
+                if (label) label.classList.toggle('dialog__agent--logged-out', a.auth === 'logged_out');
+            });
+
             var cards = document.querySelectorAll('.session-card[data-session-uuid]');
             for (var i = 0; i < cards.length; i++) {
                 var card = cards[i];
@@ -229,6 +305,14 @@ function pollLiveSessions() {
                     // re-applied after a reload -- the server owns this state.
                     markCardCommitting(card);
                 }
+                if (entry) {
+                    // The agent is stopped until the session is opened
+                    // (session_hibernate.go).
+                    card.classList.toggle('session-card--hibernated', !!entry.hibernated);
+                    updateCardThumb(card, entry.thumb);
+                    updateCardAgentState(card, entry.agentState);
+                    updateCardHealth(card, entry.health);
+                }
             }
         })
         .catch(function() {
@@ -279,6 +363,35 @@ function pollLiveSessions() {
     };
 })();
 
+// Hide what the server would refuse anyway (capabilities.go): the shutdown
+// button without the admin permission, Log out without a login, New Session
+// for a role that cannot start one. A failed fetch (an older server) leaves
+// the page as rendered.
+(function() {
+    fetch('/api/capabilities', { cache: 'no-store' })
+        .then(function(resp) { return resp.ok ? resp.json() : null; })
+        .then(function(caps) {
+            if (!caps || !caps.auth) return;
+            var perms = caps.auth.permissions || [];
+            var hideField = function(el) {
+                var field = el && el.closest('.dialog__field');
+                if (field) field.style.display = 'none';
+            };
+            if (perms.indexOf('admin') === -1) {
+                hideField(document.getElementById('server-shutdown-btn'));
+            }
+            if (caps.auth.mode === 'none') {
+                hideField(document.querySelector('.settings-logout-btn'));
+            }
+            var newBtn = document.getElementById('btn-new-session');
+            if (newBtn && perms.indexOf('input') === -1) {
+                newBtn.disabled = true;
+                newBtn.title = 'Your role (' + caps.auth.role + ') cannot start sessions';
+            }
+        })
+        .catch(function() {});
+})();
+
 function deleteRecording(uuid, button) {
     if (!confirm('Delete this recording?')) {
         return;
@@ -368,6 +481,48 @@ function renameRecording(uuid, button) {
     });
 }
 
+// Create an unlisted public link to a recording's playback page (needs the
+// embedded login). The link is copied to the clipboard when possible.
+function shareRecording(uuid) {
+    var days = prompt('Public link to this recording.\nExpires after how many days? (blank = never)', '7');
+    if (days === null) {
+        return; // User cancelled
+    }
+    days = days.trim();
+    if (days !== '' && !(Number(days) > 0)) {
+        alert('Enter a number of days, or leave blank for no expiry');
+        return;
+    }
+    var allowDownload = confirm('Let viewers download the recording?');
+
+    fetch('/api/recording/' + uuid + '/share', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            expiresIn: days === '' ? 0 : Math.round(Number(days) * 86400),
+            allowDownload: allowDownload
+        })
+    }).then(function(response) {
+        if (!response.ok) {
+            return response.text().then(function(text) {
+                alert('Failed to create link: ' + text);
+            });
+        }
+        return response.json().then(function(share) {
+            var shown = function() { prompt('Anyone with this link can watch the recording:', share.url); };
+            if (navigator.clipboard && navigator.clipboard.writeText) {
+                navigator.clipboard.writeText(share.url).then(shown, shown);
+            } else {
+                shown();
+            }
+        });
+    }).catch(function(err) {
+        alert('Error: ' + err.message);
+    });
+}
+
 // Open the New Session dialog pre-filled with a recording's settings
 // (assistant, repo, branch, name, extra args) so the user can tweak any of
 // them before starting, instead of creating the session immediately.
@@ -423,6 +578,8 @@ document.addEventListener('DOMContentLoaded', function() {
             keepRecording(uuid, btn);
         } else if (action === 'rename-recording') {
             renameRecording(uuid, btn);
+        } else if (action === 'share-recording') {
+            shareRecording(uuid);
         } else if (action === 'new-from-recording') {
             newSessionFromRecording(btn);
         }
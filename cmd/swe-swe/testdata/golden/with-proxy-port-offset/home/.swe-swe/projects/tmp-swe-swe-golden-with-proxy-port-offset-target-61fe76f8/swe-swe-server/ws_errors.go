@@ -0,0 +1,129 @@
+// ws_errors.go -- machine-readable errors on the session WebSocket.
+//
+// When the session WebSocket cannot be served, the server sends one
+// session_error frame and closes with code 4002 (fatal, do not reconnect):
+//
+//	{"type": "session_error", "code": "worktree_conflict", "retryable": false,
+//	 "message": "worktree for branch \"main\" in /workspace: ... already checked out at ..."}
+//
+// message is for people; code is for the client to pick what to show:
+//
+//	assistant_unknown  no assistant, or one this server does not have
+//	port_exhausted     a port pool is full (port_pool.go)
+//	session_limit      SWE_MAX_SESSIONS(_PER_ASSISTANT) reached; "limit"
+//	                   holds the *sessionLimitError (session_limits.go)
+//	memory_low         not enough memory for another session
+//	worktree_conflict  git would not add the branch's worktree (the branch is
+//	                   checked out elsewhere, or the path is taken)
+//	name_taken         SWE_SESSION_NAMES=reject and another session has the
+//	                   name; "conflict" holds the *sessionNameError
+//	                   (session_names.go)
+//	auth_required      no valid login cookie; "login" is the login page
+//	share_ended        the share link was revoked or expired
+//	protocol_mismatch  the client's protocol version is not served
+//	                   (ws_protocol.go); reload the page
+//	session_failed     anything else
+//
+// retryable is true when the same request may succeed later without the user
+// changing it -- once another session ends or frees memory. The creation
+// intent is kept for a retryable error, so reconnecting tries again.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsErrAssistantUnknown = "assistant_unknown"
+	wsErrPortExhausted    = "port_exhausted"
+	wsErrSessionLimit     = "session_limit"
+	wsErrMemoryLow        = "memory_low"
+	wsErrRestarting       = "server_restarting"
+	wsErrWorktreeConflict = "worktree_conflict"
+	wsErrNameTaken        = "name_taken"
+	wsErrAuthRequired     = "auth_required"
+	wsErrShareEnded       = "share_ended"
+	wsErrProtocolMismatch = "protocol_mismatch"
+	wsErrSessionFailed    = "session_failed"
+)
+
+var (
+	errUnknownAssistant   = errors.New("unknown assistant")
+	errPortsExhausted     = errors.New("no free port")
+	errInsufficientMemory = errors.New("insufficient memory")
+	errServerRestarting   = errors.New("server is restarting; try again shortly")
+	errWorktreeConflict   = errors.New("worktree conflict")
+)
+
+// wsError is a session_error frame.
+type wsError struct {
+	Type      string             `json:"type"`
+	Code      string             `json:"code"`
+	Message   string             `json:"message"`
+	Retryable bool               `json:"retryable"`
+	Limit     *sessionLimitError `json:"limit,omitempty"`
+	Conflict  *sessionNameError  `json:"conflict,omitempty"`
+	Login     string             `json:"login,omitempty"`
+}
+
+// newWSError classifies err.
+func newWSError(err error) wsError {
+	e := wsError{Type: "session_error", Code: wsErrSessionFailed, Message: err.Error()}
+	var limitErr *sessionLimitError
+	var nameErr *sessionNameError
+	switch {
+	case errors.As(err, &limitErr):
+		e.Code, e.Retryable, e.Limit = wsErrSessionLimit, true, limitErr
+	case errors.As(err, &nameErr):
+		e.Code, e.Conflict = wsErrNameTaken, nameErr
+	case errors.Is(err, errPortsExhausted):
+		e.Code, e.Retryable = wsErrPortExhausted, true
+	case errors.Is(err, errInsufficientMemory):
+		e.Code, e.Retryable = wsErrMemoryLow, true
+	case errors.Is(err, errServerRestarting):
+		e.Code, e.Retryable = wsErrRestarting, true
+	case errors.Is(err, errUnknownAssistant):
+		e.Code = wsErrAssistantUnknown
+	case errors.Is(err, errWorktreeConflict):
+		e.Code = wsErrWorktreeConflict
+	}
+	return e
+}
+
+// worktreeConflictOutput reports whether `git worktree add` failed because
+// the branch or path is already in use, rather than for some other reason.
+func worktreeConflictOutput(output string) bool {
+	return strings.Contains(output, "already checked out") ||
+		strings.Contains(output, "is already used by worktree") ||
+		strings.Contains(output, "already exists")
+}
+
+// sendWSError sends e and closes conn with 4002.
+func sendWSError(conn *SafeConn, e wsError, closeReason string) {
+	if data, err := json.Marshal(e); err == nil {
+		conn.WriteMessage(websocket.TextMessage, data)
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4002, closeReason))
+}
+
+// writeWSAuthRequired answers a session WebSocket upgrade that has no valid
+// login cookie. A browser cannot follow the login redirect from a WebSocket,
+// so it is upgraded only to be told where to log in.
+func writeWSAuthRequired(w http.ResponseWriter, r *http.Request, loginURL string) {
+	rawConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer rawConn.Close()
+	sendWSError(NewSafeConn(rawConn), wsError{
+		Type:    "session_error",
+		Code:    wsErrAuthRequired,
+		Message: "Your login has expired. Log in again to continue.",
+		Login:   loginURL,
+	}, "auth required")
+}
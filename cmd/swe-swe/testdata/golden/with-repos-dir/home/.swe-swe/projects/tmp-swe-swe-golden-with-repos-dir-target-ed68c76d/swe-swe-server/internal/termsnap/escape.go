@@ -0,0 +1,82 @@
+package termsnap
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DSRQuery is the Device Status Report cursor position query. Applications
+// (like Codex CLI via crossterm) send it and wait for CursorReport; a browser
+// round-trip is too slow for their timeout, so the server answers.
+var DSRQuery = []byte("\x1b[6n")
+
+// DSRWatcher counts the DSR queries in a PTY's output, including one split
+// across two reads. The zero value is ready to use.
+type DSRWatcher struct {
+	w queryWatcher
+}
+
+// Queries returns how many queries data completes.
+func (w *DSRWatcher) Queries(data []byte) int {
+	return w.w.count(DSRQuery, data)
+}
+
+// BackgroundQuery is the OSC 11 background color query, up to the BEL or ST
+// that ends it. Theme-aware TUIs (vim, neovim) send it to pick their colors.
+var BackgroundQuery = []byte("\x1b]11;?")
+
+// BackgroundWatcher counts the OSC 11 queries in a PTY's output the way
+// DSRWatcher counts DSR queries. The zero value is ready to use.
+type BackgroundWatcher struct {
+	w queryWatcher
+}
+
+// Queries returns how many queries data completes.
+func (w *BackgroundWatcher) Queries(data []byte) int {
+	return w.w.count(BackgroundQuery, data)
+}
+
+// queryWatcher finds a fixed query in a stream of reads.
+type queryWatcher struct {
+	partial []byte // a proper prefix of the query that ended the last read
+}
+
+// count returns how many copies of query data completes.
+func (w *queryWatcher) count(query, data []byte) int {
+	n := 0
+	if len(w.partial) > 0 {
+		rest := query[len(w.partial):]
+		switch {
+		case bytes.HasPrefix(data, rest):
+			n++
+		case len(data) < len(rest) && bytes.HasPrefix(rest, data):
+			w.partial = append(w.partial, data...)
+			return 0
+		}
+	}
+	n += bytes.Count(data, query)
+	w.partial = w.partial[:0]
+	for k := min(len(query)-1, len(data)); k > 0; k-- {
+		if bytes.HasSuffix(data, query[:k]) {
+			w.partial = append(w.partial, query[:k]...)
+			break
+		}
+	}
+	return n
+}
+
+// CursorReport is the reply to a DSR query: the cursor at row, col (from 1).
+func CursorReport(row, col int) []byte {
+	return []byte(fmt.Sprintf("\x1b[%d;%dR", row, col))
+}
+
+// BackgroundReport returns the OSC 11 reply a terminal sends when asked for
+// its background color, for the xterm.js palettes the UI uses in each theme
+// (see LIGHT_XTERM_THEME / DARK_XTERM_THEME in static/theme-mode.js).
+func BackgroundReport(theme string) []byte {
+	rgb := "1e1e/1e1e/1e1e"
+	if theme == "light" {
+		rgb = "ffff/ffff/ffff"
+	}
+	return []byte(fmt.Sprintf("\x1b]11;rgb:%s\x1b\\", rgb))
+}
@@ -0,0 +1,84 @@
+// lan_discovery.go -- advertise the server on the local network with mDNS.
+//
+// With SWE_MDNS=1 the server registers itself as a _swe-swe._tcp service
+// (internal/mdns) named SWE_MDNS_NAME, default "swe-swe on <hostname>", with
+// its port and a TXT record carrying its version. `swe-swe discover`, or any
+// Bonjour browser on the same network, then lists it with a URL to open --
+// from a phone, say, without knowing the machine's IP address.
+//
+// A server bound to loopback only (dockerless `swe-swe up` binds
+// 127.0.0.1 by default) is unreachable from the LAN, so it is not advertised,
+// and the log says why. In a container the advertisement only reaches the LAN
+// with host networking: multicast does not leave a bridge network.
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/choonkeat/swe-swe/internal/mdns"
+)
+
+// lanDiscoveryInstance builds the advertisement for a server listening on
+// listenAddr, or says why there is none.
+func lanDiscoveryInstance(listenAddr, name string, localAddrs []net.IP) (mdns.Instance, string) {
+	host, portStr, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return mdns.Instance{}, "bad listen address " + listenAddr
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port == 0 {
+		return mdns.Instance{}, "no fixed port in " + listenAddr
+	}
+	addrs := localAddrs
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		if ip.IsLoopback() {
+			return mdns.Instance{}, "listening on loopback " + listenAddr + " only"
+		}
+		if ip.To4() == nil {
+			return mdns.Instance{}, "listening on IPv6 " + listenAddr + " only"
+		}
+		addrs = []net.IP{ip.To4()}
+	}
+	if len(addrs) == 0 {
+		return mdns.Instance{}, "no LAN-facing IPv4 address"
+	}
+	hostName := mdns.HostName()
+	if name == "" {
+		name = "swe-swe on " + hostName[:len(hostName)-len(".local.")]
+	}
+	return mdns.Instance{
+		Name:  mdns.Label(name),
+		Host:  hostName,
+		Port:  port,
+		Addrs: addrs,
+		Text:  []string{"version=" + Version, "path=/"},
+	}, ""
+}
+
+// startLANDiscovery advertises the server until ctx ends, then withdraws the
+// advertisement (best effort: the process may exit first).
+func startLANDiscovery(ctx context.Context, listenAddr string) {
+	if os.Getenv("SWE_MDNS") != "1" {
+		return
+	}
+	in, why := lanDiscoveryInstance(listenAddr, os.Getenv("SWE_MDNS_NAME"), mdns.LocalAddrs())
+	if why != "" {
+		log.Printf("mDNS: not advertising: %s", why)
+		return
+	}
+	r, err := mdns.Advertise(in)
+	if err != nil {
+		log.Printf("mDNS: %v", err)
+		return
+	}
+	log.Printf("mDNS: advertising %q on port %d (%v)", in.Name, in.Port, in.Addrs)
+	go func() {
+		defer recoverGoroutine("mdns advertise")
+		<-ctx.Done()
+		r.Close()
+	}()
+}
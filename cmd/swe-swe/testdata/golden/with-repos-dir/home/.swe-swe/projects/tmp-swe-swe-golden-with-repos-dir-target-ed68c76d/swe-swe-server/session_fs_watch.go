@@ -0,0 +1,389 @@
+// session_fs_watch.go -- live "files changed" feed for the session page, so
+// the UI can show what the agent is editing without polling git status.
+//
+// Each session (not its shell sub-sessions, which share the tree) watches its
+// working directory with fsnotify. Events are coalesced per path and sent to
+// every WebSocket client once the tree has been quiet for fsWatchDebounce (or
+// fsWatchMaxDelay after the first event, under a steady stream of writes):
+//
+//	{"type": "files_changed", "seq": 3, "files": [{"path": "src/app.go", "op": "write"},
+//	  {"path": "src/old.go", "op": "remove"}]}
+//
+// op is create, write, remove or rename (the old name of a renamed file; the
+// new name arrives as create). A file created and removed within one window
+// is not reported. .git is never watched. In a git work tree, directories and
+// files .gitignore excludes are left out -- node_modules, build output -- and
+// tracked files are always reported. A client that connects gets the last
+// fsWatchRecent changes as one frame with "replay": true.
+//
+// SWE_FS_WATCH=off (config key session.fsWatch) turns the watcher off, e.g.
+// when a huge tree would exhaust the inotify watch limit. At most
+// fsWatchMaxDirs directories are watched per session.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// fsWatchDebounce is how long the tree must be quiet before a frame is sent.
+	fsWatchDebounce = 500 * time.Millisecond
+	// fsWatchMaxDelay caps how long a change waits for a quiet moment.
+	fsWatchMaxDelay = 2 * time.Second
+	// fsWatchMaxFiles is the most paths per frame; the rest are counted.
+	fsWatchMaxFiles = 200
+	// fsWatchRecent is how many changes are replayed to a new client.
+	fsWatchRecent = 50
+	// fsWatchMaxDirs bounds the inotify watches one session may hold.
+	fsWatchMaxDirs = 4000
+)
+
+// fsWatchEnabled is false with SWE_FS_WATCH=off.
+var fsWatchEnabled = true
+
+// loadFSWatch applies SWE_FS_WATCH: "on" (default) or "off".
+func loadFSWatch() error {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("SWE_FS_WATCH"))); v {
+	case "", "on", "true", "1":
+		fsWatchEnabled = true
+	case "off", "false", "0":
+		fsWatchEnabled = false
+		log.Printf("File watch feed disabled (SWE_FS_WATCH=%s)", v)
+	default:
+		return fmt.Errorf("SWE_FS_WATCH=%q: want on or off", v)
+	}
+	return nil
+}
+
+// fsChange is one changed path, relative to the working directory.
+type fsChange struct {
+	Path string `json:"path"`
+	Op   string `json:"op"` // "create", "write", "remove", "rename"
+}
+
+// fsChangesFrame is the server -> client files_changed message.
+type fsChangesFrame struct {
+	Type      string     `json:"type"` // "files_changed"
+	Seq       int64      `json:"seq"`
+	Replay    bool       `json:"replay,omitempty"`
+	Files     []fsChange `json:"files"`
+	Truncated int        `json:"truncated,omitempty"` // paths left out of this frame
+}
+
+// sessionFSWatcher watches one session's working directory.
+type sessionFSWatcher struct {
+	root    string
+	gitRepo bool
+	send    func(fsChangesFrame)
+	w       *fsnotify.Watcher
+
+	mu      sync.Mutex
+	pending map[string]string // path -> op since the last frame
+	order   []string          // pending paths, first change first
+	first   time.Time         // when the oldest pending change arrived
+	timer   *time.Timer
+	seq     int64
+	recent  []fsChange
+	dirs    int
+	full    bool // hit fsWatchMaxDirs
+	closed  bool
+}
+
+// newSessionFSWatcher starts watching root and calls send with each frame.
+// It returns nil when the watcher is off or cannot start.
+func newSessionFSWatcher(root string, send func(fsChangesFrame)) *sessionFSWatcher {
+	if !fsWatchEnabled || root == "" {
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("File watch for %s: %v", root, err)
+		return nil
+	}
+	fw := &sessionFSWatcher{root: root, send: send, w: w, pending: map[string]string{}}
+	if out, err := gitInWorkDir(root, "rev-parse", "--is-inside-work-tree"); err == nil && strings.TrimSpace(out) == "true" {
+		fw.gitRepo = true
+	}
+	fw.addTree(root)
+	go fw.run()
+	return fw
+}
+
+// Close stops the watcher and drops pending changes.
+func (fw *sessionFSWatcher) Close() {
+	if fw == nil {
+		return
+	}
+	fw.mu.Lock()
+	if fw.closed {
+		fw.mu.Unlock()
+		return
+	}
+	fw.closed = true
+	if fw.timer != nil {
+		fw.timer.Stop()
+	}
+	fw.mu.Unlock()
+	fw.w.Close()
+}
+
+// addTree watches dir and every directory below it that is not ignored.
+func (fw *sessionFSWatcher) addTree(dir string) {
+	ignored := fw.ignoredDirs(dir)
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && (d.Name() == ".git" || ignored[path]) {
+			return filepath.SkipDir
+		}
+		fw.mu.Lock()
+		if fw.dirs >= fsWatchMaxDirs {
+			if !fw.full {
+				log.Printf("File watch for %s: more than %d directories, the rest are not watched", fw.root, fsWatchMaxDirs)
+			}
+			fw.full = true
+			fw.mu.Unlock()
+			return filepath.SkipAll
+		}
+		fw.dirs++
+		fw.mu.Unlock()
+		if err := fw.w.Add(path); err != nil {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// ignoredDirs returns the gitignored directories under dir, absolute.
+func (fw *sessionFSWatcher) ignoredDirs(dir string) map[string]bool {
+	set := map[string]bool{}
+	if !fw.gitRepo {
+		return set
+	}
+	out, err := fw.git(nil, "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory", "--", dir)
+	if err != nil {
+		return set
+	}
+	for _, p := range strings.Split(out, "\x00") {
+		if strings.HasSuffix(p, "/") {
+			set[filepath.Join(fw.root, filepath.FromSlash(strings.TrimSuffix(p, "/")))] = true
+		}
+	}
+	return set
+}
+
+// git runs git in the root with stdin and returns its stdout. git
+// check-ignore exits 1 when nothing matched; that is not an error.
+func (fw *sessionFSWatcher) git(stdin []byte, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "core.quotePath=false"}, args...)...)
+	cmd.Dir = fw.root
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	return string(out), err
+}
+
+func (fw *sessionFSWatcher) run() {
+	defer recoverGoroutine("file watch " + fw.root)
+	for {
+		select {
+		case ev, ok := <-fw.w.Events:
+			if !ok {
+				return
+			}
+			fw.handle(ev)
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("File watch for %s: %v", fw.root, err)
+		}
+	}
+}
+
+func (fw *sessionFSWatcher) handle(ev fsnotify.Event) {
+	rel, err := filepath.Rel(fw.root, ev.Name)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".git" || strings.HasPrefix(rel, ".git/") || strings.Contains(rel, "/.git/") {
+		return
+	}
+	var op string
+	switch {
+	case ev.Has(fsnotify.Create):
+		op = "create"
+		if info, err := os.Lstat(ev.Name); err == nil && info.IsDir() && filepath.Base(ev.Name) != ".git" {
+			// Watch the new directory (and anything already inside it,
+			// e.g. from mkdir -p or a checkout) unless it is ignored.
+			if !fw.isIgnored(ev.Name) {
+				go fw.addTree(ev.Name)
+			}
+			return
+		}
+	case ev.Has(fsnotify.Write):
+		op = "write"
+	case ev.Has(fsnotify.Remove):
+		op = "remove"
+	case ev.Has(fsnotify.Rename):
+		op = "rename"
+	default:
+		return // chmod
+	}
+	fw.note(rel, op)
+}
+
+// isIgnored reports whether path is excluded by .gitignore.
+func (fw *sessionFSWatcher) isIgnored(path string) bool {
+	if !fw.gitRepo {
+		return filepath.Base(path) == "node_modules"
+	}
+	out, err := fw.git(nil, "check-ignore", "--", path)
+	return err == nil && strings.TrimSpace(out) != ""
+}
+
+// note records a change to rel and schedules a frame.
+func (fw *sessionFSWatcher) note(rel, op string) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.closed {
+		return
+	}
+	prev, seen := fw.pending[rel]
+	switch merged := mergeFSOp(prev, op); {
+	case merged == "":
+		delete(fw.pending, rel)
+	case !seen:
+		fw.pending[rel] = merged
+		fw.order = append(fw.order, rel)
+	default:
+		fw.pending[rel] = merged
+	}
+	now := time.Now()
+	if fw.timer == nil {
+		fw.first = now
+		fw.timer = time.AfterFunc(fsWatchDebounce, fw.flush)
+		return
+	}
+	wait := fsWatchDebounce
+	if left := fsWatchMaxDelay - now.Sub(fw.first); left < wait {
+		wait = max(left, 0)
+	}
+	fw.timer.Reset(wait)
+}
+
+// mergeFSOp folds a new event for a path into its pending op. "" means the
+// path is back where it was (created and removed within the window).
+func mergeFSOp(prev, op string) string {
+	switch {
+	case prev == "":
+		return op
+	case prev == "create" && op == "write":
+		return "create"
+	case prev == "create" && (op == "remove" || op == "rename"):
+		return ""
+	case (prev == "remove" || prev == "rename") && op == "create":
+		return "write" // replaced, e.g. an editor's atomic save
+	}
+	return op
+}
+
+// flush sends the pending changes, minus gitignored files, as one frame.
+func (fw *sessionFSWatcher) flush() {
+	fw.mu.Lock()
+	var changes []fsChange
+	for _, p := range fw.order {
+		if op, ok := fw.pending[p]; ok {
+			changes = append(changes, fsChange{Path: p, Op: op})
+		}
+	}
+	fw.pending = map[string]string{}
+	fw.order = nil
+	fw.timer = nil
+	closed := fw.closed
+	fw.mu.Unlock()
+	if closed {
+		return
+	}
+
+	changes = fw.dropIgnored(changes)
+	if len(changes) == 0 {
+		return
+	}
+	frame := fsChangesFrame{Type: "files_changed", Files: changes}
+	if len(changes) > fsWatchMaxFiles {
+		frame.Files, frame.Truncated = changes[:fsWatchMaxFiles], len(changes)-fsWatchMaxFiles
+	}
+
+	fw.mu.Lock()
+	fw.seq++
+	frame.Seq = fw.seq
+	fw.recent = append(fw.recent, frame.Files...)
+	if len(fw.recent) > fsWatchRecent {
+		fw.recent = append([]fsChange(nil), fw.recent[len(fw.recent)-fsWatchRecent:]...)
+	}
+	fw.mu.Unlock()
+	fw.send(frame)
+}
+
+// dropIgnored removes paths .gitignore excludes. Tracked files are kept
+// (git check-ignore does not report them).
+func (fw *sessionFSWatcher) dropIgnored(changes []fsChange) []fsChange {
+	if !fw.gitRepo || len(changes) == 0 {
+		return changes
+	}
+	var stdin bytes.Buffer
+	for _, c := range changes {
+		stdin.WriteString(c.Path)
+		stdin.WriteByte(0)
+	}
+	out, err := fw.git(stdin.Bytes(), "check-ignore", "-z", "--stdin")
+	if err != nil || out == "" {
+		return changes
+	}
+	ignored := map[string]bool{}
+	for _, p := range strings.Split(out, "\x00") {
+		ignored[p] = true
+	}
+	kept := changes[:0]
+	for _, c := range changes {
+		if !ignored[c.Path] {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// replay returns the recent changes as a frame for a newly connected client,
+// or nil when there are none.
+func (fw *sessionFSWatcher) replay() *fsChangesFrame {
+	if fw == nil {
+		return nil
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if len(fw.recent) == 0 {
+		return nil
+	}
+	return &fsChangesFrame{Type: "files_changed", Seq: fw.seq, Replay: true, Files: append([]fsChange(nil), fw.recent...)}
+}
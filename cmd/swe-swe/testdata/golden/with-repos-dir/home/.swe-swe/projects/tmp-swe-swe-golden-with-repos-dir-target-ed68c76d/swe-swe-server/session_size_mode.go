@@ -0,0 +1,112 @@
+// session_size_mode.go -- per-connection "follow" vs "independent" sizing.
+//
+// The PTY is sized to the smallest rows and cols across the session's
+// clients, so every viewer sees the same grid -- and one phone viewer
+// shrinks the desktop user's terminal. A client is in one of two modes:
+//
+//   - follow (default): its terminal size counts toward the PTY size.
+//   - independent: it still reports its size, but is left out of PTY sizing
+//     and renders the PTY grid scaled (and cropped) to its own viewport.
+//
+// A client picks its mode with ?size=independent on /ws/{uuid}, or switches
+// at any time with
+//
+//	{"type": "set_size_mode", "data": {"mode": "independent"}}
+//
+// which is answered with {"type": "size_mode", "mode": "independent"}. When
+// every client is independent the PTY is sized from all of them, as if they
+// all followed, rather than dropping to the 80x24 default. The status
+// message reports independentViewers.
+package main
+
+import (
+	"log"
+
+	"github.com/creack/pty"
+)
+
+const (
+	sizeModeFollow      = "follow"
+	sizeModeIndependent = "independent"
+)
+
+// parseSizeMode validates a size mode; "" means follow.
+func parseSizeMode(v string) (string, bool) {
+	switch v {
+	case "", sizeModeFollow:
+		return sizeModeFollow, true
+	case sizeModeIndependent:
+		return sizeModeIndependent, true
+	}
+	return "", false
+}
+
+// sizingClientSizes returns the client sizes that count toward the PTY size:
+// the following clients', or everyone's when all are independent. Call with
+// s.mu held.
+func (s *Session) sizingClientSizes() []TermSize {
+	all := make([]TermSize, 0, len(s.wsClientSizes))
+	following := make([]TermSize, 0, len(s.wsClientSizes))
+	for conn, size := range s.wsClientSizes {
+		all = append(all, size)
+		if !s.sizeIndependent[conn] {
+			following = append(following, size)
+		}
+	}
+	if len(following) == 0 {
+		return all
+	}
+	return following
+}
+
+// SetClientSizeMode puts conn in follow or independent mode and resizes the
+// PTY to the clients that now count.
+func (s *Session) SetClientSizeMode(conn *SafeConn, mode string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mode == sizeModeIndependent {
+		if s.sizeIndependent == nil {
+			s.sizeIndependent = make(map[*SafeConn]bool)
+		}
+		s.sizeIndependent[conn] = true
+	} else {
+		delete(s.sizeIndependent, conn)
+	}
+	s.resizePTYToClients()
+
+	// Broadcast status after lock is released
+	go s.BroadcastStatus()
+}
+
+// resizePTYToClients applies calculateMinSize to the PTY and the virtual
+// terminal when it changed. Call with s.mu held.
+func (s *Session) resizePTYToClients() {
+	if (len(s.wsClientSizes) == 0 && !s.pinned()) || s.PTY == nil {
+		return
+	}
+	minRows, minCols := s.calculateMinSize()
+
+	// Only resize if session's min size actually changed
+	if s.ptySize.Rows == minRows && s.ptySize.Cols == minCols {
+		return
+	}
+	s.ptySize = TermSize{Rows: minRows, Cols: minCols}
+
+	// Track max dimensions for recording playback
+	if s.Metadata != nil {
+		if minCols > s.Metadata.MaxCols {
+			s.Metadata.MaxCols = minCols
+		}
+		if minRows > s.Metadata.MaxRows {
+			s.Metadata.MaxRows = minRows
+		}
+	}
+	pty.Setsize(s.PTY, &pty.Winsize{Rows: minRows, Cols: minCols})
+	s.recorder.Load().Resize(minRows, minCols)
+	log.Printf("Session %s: resized PTY to %dx%d (from %d clients)", s.UUID, minCols, minRows, len(s.wsClientSizes))
+
+	// Also resize the virtual terminal for accurate snapshots
+	s.vtMu.Lock()
+	s.vt.Resize(int(minCols), int(minRows))
+	s.vtMu.Unlock()
+}
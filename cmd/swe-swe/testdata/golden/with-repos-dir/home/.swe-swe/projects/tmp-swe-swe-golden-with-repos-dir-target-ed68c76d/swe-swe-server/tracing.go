@@ -0,0 +1,568 @@
+// tracing.go -- optional OpenTelemetry (OTLP) tracing for swe-swe-server.
+//
+// Operators hosting swe-swe for a team want to see where a slow session
+// connect or a slow preview goes. Tracing is off unless an OTLP endpoint is
+// configured through the standard OTEL_* environment variables; when it is
+// on, spans are recorded around:
+//
+//   - ws.connect                 the whole WebSocket join (upgrade to first frame)
+//   - session.get_or_create      attach-or-spawn, with session.new
+//   - git.worktree_add           worktree creation for a new session
+//   - session.snapshot.generate  VT screen snapshot rendering
+//   - ws.send_chunked            each chunked scrollback/snapshot send
+//   - proxy.request              App Preview / agent chat proxy requests
+//   - repo.prepare               the new-session dialog's clone/fetch/init
+//
+// There is deliberately no OpenTelemetry SDK dependency: the exporter below
+// speaks OTLP/HTTP with the JSON encoding, which every OTLP collector accepts
+// on :4318, and a handful of spans per request needs neither samplers nor
+// metrics -- every span is exported. W3C traceparent headers are honoured on
+// incoming requests and injected into proxied ones, so an app that is
+// itself instrumented shows up under swe-swe's proxy span.
+//
+// Recognised variables (everything else in the OTEL_* family is ignored):
+//
+//	OTEL_SDK_DISABLED=true                 force off
+//	OTEL_TRACES_EXPORTER=none              force off (otlp, the default, is the only exporter)
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT     full URL, used as-is
+//	OTEL_EXPORTER_OTLP_ENDPOINT            base URL; /v1/traces is appended
+//	OTEL_EXPORTER_OTLP_[TRACES_]HEADERS    k=v,k2=v2 (values URL-decoded)
+//	OTEL_EXPORTER_OTLP_[TRACES_]PROTOCOL   must be http/json if set
+//	OTEL_SERVICE_NAME                      default "swe-swe-server"
+//	OTEL_RESOURCE_ATTRIBUTES               k=v,k2=v2
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	traceQueueSize     = 2048
+	traceBatchSize     = 256
+	traceFlushInterval = 5 * time.Second
+	traceExportTimeout = 10 * time.Second
+)
+
+// OTLP span kinds and status codes (opentelemetry-proto trace.proto).
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+// tracer is the process-wide exporter; nil when tracing is off, which makes
+// startSpan return nil spans and every span method a no-op.
+var tracer *traceExporter
+
+// traceConfig is what the OTEL_* variables resolve to.
+type traceConfig struct {
+	Endpoint string
+	Headers  map[string]string
+	Resource map[string]string
+}
+
+// loadTraceConfig reads the OTEL_* variables through getenv. ok is false when
+// tracing should stay off; err explains a configuration it refuses (a
+// protocol other than http/json).
+func loadTraceConfig(getenv func(string) string) (cfg traceConfig, ok bool, err error) {
+	if strings.EqualFold(getenv("OTEL_SDK_DISABLED"), "true") {
+		return cfg, false, nil
+	}
+	if exp := strings.TrimSpace(getenv("OTEL_TRACES_EXPORTER")); exp != "" && exp != "otlp" {
+		if exp == "none" {
+			return cfg, false, nil
+		}
+		return cfg, false, fmt.Errorf("OTEL_TRACES_EXPORTER=%q not supported (want otlp or none)", exp)
+	}
+
+	cfg.Endpoint = strings.TrimSpace(getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+	if cfg.Endpoint == "" {
+		base := strings.TrimSpace(getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+		if base == "" {
+			return cfg, false, nil
+		}
+		cfg.Endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if u, perr := url.Parse(cfg.Endpoint); perr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return cfg, false, fmt.Errorf("invalid OTLP endpoint %q (want http(s)://host:port[/path])", cfg.Endpoint)
+	}
+
+	proto := firstNonEmpty(getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"), getenv("OTEL_EXPORTER_OTLP_PROTOCOL"))
+	if proto != "" && proto != "http/json" {
+		return cfg, false, fmt.Errorf("OTLP protocol %q not supported (swe-swe-server exports http/json only)", proto)
+	}
+
+	cfg.Headers = parseOTelKeyValues(firstNonEmpty(getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"), getenv("OTEL_EXPORTER_OTLP_HEADERS")))
+	cfg.Resource = parseOTelKeyValues(getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if name := strings.TrimSpace(getenv("OTEL_SERVICE_NAME")); name != "" {
+		cfg.Resource["service.name"] = name
+	} else if cfg.Resource["service.name"] == "" {
+		cfg.Resource["service.name"] = "swe-swe-server"
+	}
+	return cfg, true, nil
+}
+
+// parseOTelKeyValues parses the "k=v,k2=v2" list format shared by
+// OTEL_EXPORTER_OTLP_HEADERS and OTEL_RESOURCE_ATTRIBUTES. Values are
+// URL-decoded; malformed entries are skipped.
+func parseOTelKeyValues(s string) map[string]string {
+	out := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(item, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			continue
+		}
+		if dv, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = dv
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out
+}
+
+// setupTracing starts the exporter when the environment asks for it. A
+// refused configuration is logged and leaves tracing off rather than
+// stopping the server.
+func setupTracing() {
+	cfg, ok, err := loadTraceConfig(os.Getenv)
+	if err != nil {
+		log.Printf("Warning: tracing disabled: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	tracer = newTraceExporter(cfg)
+	go tracer.run()
+	log.Printf("Tracing enabled: exporting OTLP/JSON spans to %s as %s", cfg.Endpoint, cfg.Resource["service.name"])
+}
+
+// shutdownTracing flushes queued spans. Called once on server exit.
+func shutdownTracing(ctx context.Context) {
+	if tracer == nil {
+		return
+	}
+	tracer.shutdown(ctx)
+}
+
+// traceSpan is one in-flight span. A nil *traceSpan is valid and ignores
+// every call, so instrumented code never checks whether tracing is on.
+type traceSpan struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu     sync.Mutex
+	attrs  map[string]interface{}
+	errMsg string
+	ended  bool
+}
+
+type spanContextKey struct{}
+
+// remoteParent is a span context received in a traceparent header.
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// startSpan starts a span as a child of the span (or extracted traceparent)
+// in ctx, returning a context carrying the new span. attrs are key/value
+// pairs. ctx may be nil.
+func startSpan(ctx context.Context, name string, attrs ...interface{}) (context.Context, *traceSpan) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if tracer == nil {
+		return ctx, nil
+	}
+	sp := &traceSpan{name: name, kind: spanKindInternal, start: time.Now()}
+	switch parent := ctx.Value(spanContextKey{}).(type) {
+	case *traceSpan:
+		sp.traceID, sp.parentID = parent.traceID, parent.spanID
+	case remoteParent:
+		sp.traceID, sp.parentID = parent.traceID, parent.spanID
+	default:
+		rand.Read(sp.traceID[:])
+	}
+	rand.Read(sp.spanID[:])
+	sp.SetAttrs(attrs...)
+	return context.WithValue(ctx, spanContextKey{}, sp), sp
+}
+
+// spanFromContext returns the span started into ctx, or nil.
+func spanFromContext(ctx context.Context) *traceSpan {
+	sp, _ := ctx.Value(spanContextKey{}).(*traceSpan)
+	return sp
+}
+
+// startServerSpan starts a SERVER span for an incoming request, continuing
+// the caller's trace when the request carries a valid traceparent header.
+func startServerSpan(r *http.Request, name string, attrs ...interface{}) (context.Context, *traceSpan) {
+	ctx := r.Context()
+	if tracer == nil {
+		return ctx, nil
+	}
+	if p, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		ctx = context.WithValue(ctx, spanContextKey{}, p)
+	}
+	ctx, sp := startSpan(ctx, name, append([]interface{}{"http.request.method", r.Method, "url.path", r.URL.Path}, attrs...)...)
+	sp.kind = spanKindServer
+	return ctx, sp
+}
+
+// SetAttrs records key/value attribute pairs on the span.
+func (sp *traceSpan) SetAttrs(kv ...interface{}) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			continue
+		}
+		if sp.attrs == nil {
+			sp.attrs = map[string]interface{}{}
+		}
+		sp.attrs[key] = kv[i+1]
+	}
+}
+
+// End finishes the span, marking it failed when err is non-nil, and queues it
+// for export. Later calls are ignored.
+func (sp *traceSpan) End(err error) {
+	if sp == nil {
+		return
+	}
+	end := time.Now()
+	sp.mu.Lock()
+	if sp.ended {
+		sp.mu.Unlock()
+		return
+	}
+	sp.ended = true
+	if err != nil {
+		sp.errMsg = err.Error()
+	}
+	s := sp.otlp(end)
+	sp.mu.Unlock()
+	tracer.enqueue(s)
+}
+
+// traceparent formats the span as a W3C traceparent header value.
+func (sp *traceSpan) traceparent() string {
+	return "00-" + hex.EncodeToString(sp.traceID[:]) + "-" + hex.EncodeToString(sp.spanID[:]) + "-01"
+}
+
+// parseTraceparent accepts version-00 W3C traceparent values.
+func parseTraceparent(h string) (remoteParent, bool) {
+	var p remoteParent
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return p, false
+	}
+	if _, err := hex.Decode(p.traceID[:], []byte(parts[1])); err != nil {
+		return p, false
+	}
+	if _, err := hex.Decode(p.spanID[:], []byte(parts[2])); err != nil {
+		return p, false
+	}
+	if p.traceID == ([16]byte{}) || p.spanID == ([8]byte{}) {
+		return p, false
+	}
+	return p, true
+}
+
+// statusRecorder captures the response status for a span while keeping the
+// Flusher/Hijacker the wrapped writer offers (the proxy streams SSE and
+// upgrades WebSockets).
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijack not supported")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// traceHandler wraps next in a SERVER span named name and propagates the
+// span to next via the request context and a traceparent header. With
+// tracing off it is next, unchanged.
+func traceHandler(w http.ResponseWriter, r *http.Request, name string, next http.Handler, attrs ...interface{}) {
+	if tracer == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+	ctx, sp := startServerSpan(r, name, attrs...)
+	r = r.WithContext(ctx)
+	r.Header.Set("traceparent", sp.traceparent())
+	rec := &statusRecorder{ResponseWriter: w}
+	next.ServeHTTP(rec, r)
+	sp.SetAttrs("http.response.status_code", rec.status)
+	var err error
+	if rec.status >= 500 {
+		err = fmt.Errorf("HTTP %d", rec.status)
+	}
+	sp.End(err)
+}
+
+// OTLP/JSON wire types. IDs are hex strings and 64-bit integers are decimal
+// strings, per the OTLP JSON encoding.
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+func otlpValue(v interface{}) otlpAnyValue {
+	switch x := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &x}
+	case bool:
+		return otlpAnyValue{BoolValue: &x}
+	case int:
+		s := strconv.Itoa(x)
+		return otlpAnyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &x}
+	case time.Duration:
+		s := strconv.FormatInt(x.Milliseconds(), 10)
+		return otlpAnyValue{IntValue: &s}
+	}
+	s := fmt.Sprint(v)
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpAttrs(m map[string]interface{}) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(m))
+	for k, v := range m {
+		out = append(out, otlpKeyValue{Key: k, Value: otlpValue(v)})
+	}
+	return out
+}
+
+// otlp converts the finished span; caller holds sp.mu.
+func (sp *traceSpan) otlp(end time.Time) otlpSpan {
+	s := otlpSpan{
+		TraceID:           hex.EncodeToString(sp.traceID[:]),
+		SpanID:            hex.EncodeToString(sp.spanID[:]),
+		Name:              sp.name,
+		Kind:              sp.kind,
+		StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        otlpAttrs(sp.attrs),
+		Status:            otlpStatus{Code: spanStatusOK},
+	}
+	if sp.parentID != ([8]byte{}) {
+		s.ParentSpanID = hex.EncodeToString(sp.parentID[:])
+	}
+	if sp.errMsg != "" {
+		s.Status = otlpStatus{Code: spanStatusError, Message: sp.errMsg}
+	}
+	return s
+}
+
+// traceExporter queues finished spans and POSTs them in batches. The queue
+// is bounded: when the collector is down or slow, spans are dropped (and
+// counted) rather than blocking a WebSocket join.
+type traceExporter struct {
+	cfg      traceConfig
+	client   *http.Client
+	queue    chan otlpSpan
+	flushReq chan chan struct{}
+	resource []otlpKeyValue
+
+	mu      sync.Mutex
+	dropped int
+}
+
+func newTraceExporter(cfg traceConfig) *traceExporter {
+	res := map[string]interface{}{}
+	for k, v := range cfg.Resource {
+		res[k] = v
+	}
+	return &traceExporter{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: traceExportTimeout},
+		queue:    make(chan otlpSpan, traceQueueSize),
+		flushReq: make(chan chan struct{}),
+		resource: otlpAttrs(res),
+	}
+}
+
+func (e *traceExporter) enqueue(s otlpSpan) {
+	select {
+	case e.queue <- s:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+// run batches spans until the process exits: a batch is sent when it is full,
+// every traceFlushInterval, and on shutdown's flush request.
+func (e *traceExporter) run() {
+	defer recoverGoroutine("trace exporter")
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	send := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flushReq:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+// shutdown flushes whatever is queued, waiting at most until ctx is done.
+func (e *traceExporter) shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	select {
+	case e.flushReq <- done:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// export POSTs one batch. Failures are logged and the batch discarded;
+// tracing must never back-pressure the server.
+func (e *traceExporter) export(spans []otlpSpan) {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": e.resource},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "swe-swe-server", "version": Version},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		log.Printf("Warning: trace export: %v", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Warning: trace export: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		log.Printf("Warning: trace export to %s failed: %v", e.cfg.Endpoint, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Warning: trace export to %s: HTTP %d (%d spans dropped)", e.cfg.Endpoint, resp.StatusCode, len(spans))
+	}
+	e.mu.Lock()
+	dropped := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("Warning: trace queue full, dropped %d spans", dropped)
+	}
+}
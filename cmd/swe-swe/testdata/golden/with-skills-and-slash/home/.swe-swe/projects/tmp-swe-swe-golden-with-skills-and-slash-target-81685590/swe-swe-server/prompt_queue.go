@@ -0,0 +1,166 @@
+// prompt_queue.go -- prompts held until the agent is waiting for one.
+//
+// Typing a prompt while the agent is mid-generation interrupts it or lands
+// half-drawn in its input box, so from a phone there is no good way to line
+// up the next instruction. A client can instead send
+//
+//	{"type": "prompt", "text": "..."}
+//
+// and the server queues it. Queued prompts are typed one at a time, in
+// order, each once the session's output has been quiet for
+// promptQuietPeriod -- the agent has stopped streaming and sits at its
+// prompt -- the way a deep link's prompt is (typePrompt). The sender gets
+// {"type": "prompt_queued", "queued": N} or {"type": "prompt_error",
+// "message": "..."}; every client sees "queuedPrompts" in the status message
+// while any are waiting. {"type": "clear_prompts"} drops the prompts not yet
+// typed.
+//
+// SWE_PROMPT_QUIET sets the quiet period, e.g. 5s (default 3s).
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// promptQueueMax bounds the prompts waiting in one session.
+	promptQueueMax = 20
+	// promptQueueMaxText bounds one queued prompt.
+	promptQueueMaxText = 16 << 10
+)
+
+// promptQuietPeriod is how long output must stay quiet before the next
+// queued prompt is typed.
+var promptQuietPeriod = 3 * time.Second
+
+// promptQueue is a session's prompts waiting to be typed.
+type promptQueue struct {
+	mu       sync.Mutex
+	pending  []string
+	draining bool // a drainPrompts goroutine is running
+}
+
+// loadPromptQuiet reads SWE_PROMPT_QUIET.
+func loadPromptQuiet() error {
+	v := strings.TrimSpace(os.Getenv("SWE_PROMPT_QUIET"))
+	if v == "" {
+		return nil
+	}
+	d, err := parseTimeoutSetting("SWE_PROMPT_QUIET", v)
+	if err != nil {
+		return err
+	}
+	promptQuietPeriod = d
+	log.Printf("Prompt queue quiet period from SWE_PROMPT_QUIET: %s", d)
+	return nil
+}
+
+// QueuePrompt adds text to the session's prompt queue and returns how many
+// prompts are now waiting.
+func (s *Session) QueuePrompt(text string) (int, error) {
+	text = strings.TrimRight(text, "\r\n")
+	switch {
+	case strings.TrimSpace(text) == "":
+		return 0, errors.New("empty prompt")
+	case len(text) > promptQueueMaxText:
+		return 0, fmt.Errorf("prompt is longer than %d bytes", promptQueueMaxText)
+	}
+	q := &s.prompts
+	q.mu.Lock()
+	if len(q.pending) >= promptQueueMax {
+		q.mu.Unlock()
+		return 0, fmt.Errorf("%d prompts are already queued", promptQueueMax)
+	}
+	q.pending = append(q.pending, text)
+	n := len(q.pending)
+	start := !q.draining
+	q.draining = true
+	q.mu.Unlock()
+
+	if start {
+		go s.drainPrompts(promptQuietPeriod)
+	}
+	log.Printf("Session %s: queued a prompt (%d bytes, %d waiting)", s.UUID, len(text), n)
+	s.BroadcastStatus()
+	return n, nil
+}
+
+// ClearPrompts drops the prompts not yet typed.
+func (s *Session) ClearPrompts() {
+	s.prompts.mu.Lock()
+	n := len(s.prompts.pending)
+	s.prompts.pending = nil
+	s.prompts.mu.Unlock()
+	if n > 0 {
+		log.Printf("Session %s: cleared %d queued prompts", s.UUID, n)
+		s.BroadcastStatus()
+	}
+}
+
+// queuedPrompts returns how many prompts are waiting.
+func (s *Session) queuedPrompts() int {
+	s.prompts.mu.Lock()
+	defer s.prompts.mu.Unlock()
+	return len(s.prompts.pending)
+}
+
+// drainPrompts types the queued prompts as the agent goes quiet for quiet,
+// until the queue is empty or the session ends. The caller reads
+// promptQuietPeriod so this goroutine never touches the setting.
+func (s *Session) drainPrompts(quiet time.Duration) {
+	q := &s.prompts
+	defer func() {
+		q.mu.Lock()
+		q.draining = false
+		q.mu.Unlock()
+	}()
+	defer recoverGoroutine("prompt queue for session " + s.UUID)
+	for {
+		if !s.waitForQuietOutput(quiet) {
+			return // session ending
+		}
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.draining = false
+			q.mu.Unlock()
+			return
+		}
+		text := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		if err := s.typePrompt(text); err != nil {
+			log.Printf("Session %s: queued prompt: %v", s.UUID, err)
+		} else {
+			log.Printf("Session %s: typed a queued prompt (%d bytes)", s.UUID, len(text))
+		}
+		s.BroadcastStatus()
+	}
+}
+
+// waitForQuietOutput returns true once the session has written no output
+// for quiet, or false if the session ends first.
+func (s *Session) waitForQuietOutput(quiet time.Duration) bool {
+	const poll = 100 * time.Millisecond
+	lastTotal := int64(-1)
+	quietSince := time.Now()
+	for !s.isEnding() {
+		s.vtMu.Lock()
+		total := s.ring.Total()
+		s.vtMu.Unlock()
+		if total != lastTotal {
+			lastTotal = total
+			quietSince = time.Now()
+		} else if time.Since(quietSince) >= quiet {
+			return true
+		}
+		time.Sleep(poll)
+	}
+	return false
+}
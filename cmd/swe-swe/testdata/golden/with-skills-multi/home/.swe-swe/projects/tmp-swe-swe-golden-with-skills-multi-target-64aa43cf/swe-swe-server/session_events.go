@@ -0,0 +1,126 @@
+// session_events.go -- bounded per-session buffer of server-side events.
+//
+// When a session misbehaves (PTY read errors, broadcast failures, snapshot
+// sends that die half way) the evidence used to exist only in server stdout.
+// logHandler (logging.go) now also appends every record that names a live
+// session -- explicitly via a "session" attr, or a UUID in a bridged
+// log.Printf message -- to that session's ring buffer, regardless of the
+// current log level. GET /api/session/{uuid}/events serves the buffer to the
+// session page's Diagnostics pane.
+//
+// The registry is a sync.Map, not the sessions map: log calls happen while
+// sessionsMu is held, and the log handler must never take that lock.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionEventLimit bounds each session's buffer; older events are dropped.
+const sessionEventLimit = 500
+
+// sessionEvent is one buffered server-side log record.
+type sessionEvent struct {
+	Seq       uint64            `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Level     string            `json:"level"`
+	Subsystem string            `json:"subsystem,omitempty"`
+	Message   string            `json:"msg"`
+	Attrs     map[string]string `json:"attrs,omitempty"`
+}
+
+// sessionEventLog is a fixed-size ring of events with monotonically
+// increasing sequence numbers, so a poller can ask for "everything after N".
+type sessionEventLog struct {
+	mu     sync.Mutex
+	events []sessionEvent
+	start  int    // index of the oldest event once the ring is full
+	next   uint64 // seq the next event gets (first event is 1)
+}
+
+func (l *sessionEventLog) add(ev sessionEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next++
+	ev.Seq = l.next
+	if len(l.events) < sessionEventLimit {
+		l.events = append(l.events, ev)
+		return
+	}
+	l.events[l.start] = ev
+	l.start = (l.start + 1) % sessionEventLimit
+}
+
+// since returns buffered events with Seq > after, oldest first, and the seq
+// of the newest event (pass it back as after to poll for more).
+func (l *sessionEventLog) since(after uint64) ([]sessionEvent, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]sessionEvent, 0, len(l.events))
+	for i := range l.events {
+		ev := l.events[(l.start+i)%len(l.events)]
+		if ev.Seq > after {
+			out = append(out, ev)
+		}
+	}
+	return out, l.next
+}
+
+// sessionEventLogs maps session UUID -> *sessionEventLog for live sessions.
+var sessionEventLogs sync.Map
+
+// registerSessionEvents starts buffering events for uuid. Called when the
+// session is added to the sessions map.
+func registerSessionEvents(uuid string) {
+	sessionEventLogs.LoadOrStore(uuid, &sessionEventLog{})
+}
+
+// unregisterSessionEvents drops the buffer. Called at the end of Close.
+func unregisterSessionEvents(uuid string) {
+	sessionEventLogs.Delete(uuid)
+}
+
+// recordSessionEvent appends ev to uuid's buffer if the session is live.
+func recordSessionEvent(uuid string, ev sessionEvent) {
+	if v, ok := sessionEventLogs.Load(uuid); ok {
+		v.(*sessionEventLog).add(ev)
+	}
+}
+
+// handleSessionEventsAPI handles GET /api/session/{uuid}/events[?since=N]:
+//
+//	{"events": [{"seq": 1, "time": "...", "level": "WARN", "subsystem": "ws",
+//	             "msg": "...", "attrs": {"remote": "..."}}, ...],
+//	 "next": 42}
+//
+// Poll with since=<next> to fetch only newer events.
+func handleSessionEventsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionUUID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/events")
+	v, ok := sessionEventLogs.Load(sessionUUID)
+	if sessionUUID == "" || !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	var after uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+	events, next := v.(*sessionEventLog).since(after)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": events, "next": next})
+}
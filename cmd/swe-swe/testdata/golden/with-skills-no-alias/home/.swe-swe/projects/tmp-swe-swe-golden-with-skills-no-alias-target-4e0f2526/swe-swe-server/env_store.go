@@ -94,7 +94,7 @@ func sessionEnvVars(sid string, lookup func(string) string) (kept, dropped []str
 	if !ok {
 		return nil, nil
 	}
-	kept = parseEnvLines(raw, lookup, isReservedEnvKey, &dropped)
+	kept = parseEnvLines(raw, sid, lookup, isReservedEnvKey, &dropped)
 	return kept, dropped
 }
 
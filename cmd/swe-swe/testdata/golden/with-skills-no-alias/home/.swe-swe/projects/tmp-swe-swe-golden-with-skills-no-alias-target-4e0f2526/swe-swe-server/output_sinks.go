@@ -0,0 +1,475 @@
+// output_sinks.go -- tee agent output to a team's own logging.
+//
+// A workspace can list sinks in .swe-swe/output-sinks.json, read like
+// .swe-swe/env from the session's working directory when it starts:
+//
+//	[
+//	  {"type": "file", "path": "/var/log/swe-swe"},
+//	  {"type": "syslog", "address": "udp://logs.internal:514", "tag": "swe-swe"},
+//	  {"type": "loki", "url": "http://loki:3100/loki/api/v1/push",
+//	   "labels": {"team": "web"}},
+//	  {"type": "elasticsearch", "url": "http://es:9200", "index": "swe-swe",
+//	   "headers": {"Authorization": "ApiKey ${ES_API_KEY}"}}
+//	]
+//
+// Each sink receives the PTY output as lines of text, with escape sequences
+// stripped and a line redrawn in place (progress bars, spinners) reduced to
+// what it last showed. Every line carries the session UUID, the assistant and
+// the repo (named as in usage reports). A file sink appends to
+// session-<uuid>.log in its directory (relative to the working directory if
+// not absolute); syslog with no address logs locally.
+// ${VAR} in url, address and header values comes from the session's
+// environment, so a token can stay in .swe-swe/env.
+//
+// Lines are handed to a per-session goroutine and sent in batches every
+// outputSinkInterval. The PTY reader never waits on a sink: lines beyond
+// outputSinkQueue are dropped and counted in the server log. Shell
+// sub-sessions share their parent's workspace and are not teed.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// outputSinksFile is the workspace's sink list.
+	outputSinksFile = "output-sinks.json"
+	// outputSinkInterval is how often queued lines are sent.
+	outputSinkInterval = time.Second
+	// outputSinkQueue is how many lines may wait before new ones are dropped.
+	outputSinkQueue = 10000
+	// outputSinkMaxLine cuts a line that never ends.
+	outputSinkMaxLine = 4096
+)
+
+// outputSinkConfig is one entry of output-sinks.json.
+type outputSinkConfig struct {
+	Type    string            `json:"type"` // file, syslog, loki, elasticsearch
+	Path    string            `json:"path,omitempty"`
+	Address string            `json:"address,omitempty"`
+	Tag     string            `json:"tag,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Index   string            `json:"index,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// outputLine is one line of agent output.
+type outputLine struct {
+	Time time.Time
+	Text string
+}
+
+// outputSource tags the lines of one session.
+type outputSource struct {
+	Session   string
+	Assistant string
+	Repo      string
+}
+
+// outputSink receives batches of one session's lines.
+type outputSink interface {
+	send(src outputSource, lines []outputLine) error
+	close() error
+}
+
+// outputTee frames a session's PTY output into lines and feeds the sinks.
+type outputTee struct {
+	src     outputSource
+	sinks   []outputSink
+	names   []string // sink types, for log messages
+	mu      sync.Mutex
+	partial []byte // the line being written
+	redraw  bool   // a "\r" came: more text replaces the line
+	esc     int    // escape-sequence state
+	closed  bool
+	queue   chan outputLine
+	dropped int
+	done    chan struct{}
+}
+
+// startOutputSinks reads the workspace's sinks and starts teeing the
+// session's output to them. A bad sink list is logged; the session runs
+// without it.
+func (s *Session) startOutputSinks() {
+	if s.ParentUUID != "" {
+		return
+	}
+	workDir := s.effectiveWorkDir()
+	data, err := os.ReadFile(filepath.Join(workDir, ".swe-swe", outputSinksFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Session %s: output sinks: %v", s.UUID, err)
+		}
+		return
+	}
+	var configs []outputSinkConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		log.Printf("Session %s: output sinks: %s: %v", s.UUID, outputSinksFile, err)
+		return
+	}
+	lookup := envLookup(s.spawnEnv())
+	tee := &outputTee{
+		src:   outputSource{Session: s.UUID, Assistant: s.Assistant, Repo: usageRepo(workDir)},
+		queue: make(chan outputLine, outputSinkQueue),
+		done:  make(chan struct{}),
+	}
+	for _, c := range configs {
+		sink, err := newOutputSink(c, workDir, lookup)
+		if err != nil {
+			log.Printf("Session %s: output sink %q: %v", s.UUID, c.Type, err)
+			continue
+		}
+		tee.sinks = append(tee.sinks, sink)
+		tee.names = append(tee.names, c.Type)
+	}
+	if len(tee.sinks) == 0 {
+		return
+	}
+	s.sinks = tee
+	go tee.run()
+}
+
+// newOutputSink opens the sink a config entry describes. lookup expands
+// ${VAR} in its url, address and headers.
+func newOutputSink(c outputSinkConfig, workDir string, lookup func(string) string) (outputSink, error) {
+	expand := func(v string) string { return os.Expand(v, lookup) }
+	switch c.Type {
+	case "file":
+		if c.Path == "" {
+			return nil, errors.New("path is required")
+		}
+		dir := c.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(workDir, dir)
+		}
+		return &fileOutputSink{dir: dir}, nil
+	case "syslog":
+		network, raddr := "", ""
+		if c.Address != "" {
+			u, err := url.Parse(expand(c.Address))
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("address %q: want udp://host:port or tcp://host:port", c.Address)
+			}
+			network, raddr = u.Scheme, u.Host
+		}
+		tag := c.Tag
+		if tag == "" {
+			tag = "swe-swe"
+		}
+		return &syslogOutputSink{network: network, raddr: raddr, tag: tag}, nil
+	case "loki", "elasticsearch":
+		if c.URL == "" {
+			return nil, errors.New("url is required")
+		}
+		if c.Type == "elasticsearch" && c.Index == "" {
+			return nil, errors.New("index is required")
+		}
+		headers := map[string]string{}
+		for k, v := range c.Headers {
+			headers[k] = expand(v)
+		}
+		sink := &httpOutputSink{kind: c.Type, url: expand(c.URL), headers: headers, labels: c.Labels, client: &http.Client{Timeout: 10 * time.Second}}
+		if c.Type == "elasticsearch" {
+			sink.url = strings.TrimSuffix(sink.url, "/") + "/" + url.PathEscape(c.Index) + "/_bulk"
+		}
+		return sink, nil
+	}
+	return nil, errors.New("unknown type; want file, syslog, loki or elasticsearch")
+}
+
+// Escape-sequence states of outputTee.write; a sequence can be split
+// across PTY reads.
+const (
+	escNone    = iota
+	escStart   // after ESC
+	escCSI     // ESC [ ... final byte
+	escString  // ESC ] or ESC P ... BEL or ESC \
+	escStrEsc  // ESC inside a string
+	escCharset // ESC ( and one more byte
+)
+
+// write frames PTY output into lines and queues the complete ones. Safe to
+// call on a nil tee.
+func (t *outputTee) write(data []byte) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	now := time.Now()
+	for _, b := range data {
+		switch t.esc {
+		case escStart:
+			switch b {
+			case '[':
+				t.esc = escCSI
+			case ']', 'P', '_', '^':
+				t.esc = escString
+			case '(', ')', '*', '+':
+				t.esc = escCharset
+			default:
+				t.esc = escNone
+			}
+			continue
+		case escCSI:
+			if b >= 0x40 && b <= 0x7e {
+				t.esc = escNone
+			}
+			continue
+		case escString:
+			if b == 0x07 {
+				t.esc = escNone
+			} else if b == 0x1b {
+				t.esc = escStrEsc
+			}
+			continue
+		case escStrEsc:
+			t.esc = escString
+			if b == '\\' {
+				t.esc = escNone
+			}
+			continue
+		case escCharset:
+			t.esc = escNone
+			continue
+		}
+		switch {
+		case b == 0x1b:
+			t.esc = escStart
+		case b == '\n':
+			t.emitLocked(now)
+		case b == '\r':
+			// "\r\n" ends the line; "\r" then more text redraws it.
+			t.redraw = true
+		case b == '\t' || b >= 0x20 && b != 0x7f:
+			if t.redraw {
+				t.partial, t.redraw = t.partial[:0], false
+			}
+			t.partial = append(t.partial, b)
+			if len(t.partial) >= outputSinkMaxLine {
+				t.emitLocked(now)
+			}
+		}
+	}
+}
+
+// emitLocked queues the line being written. Must hold t.mu.
+func (t *outputTee) emitLocked(now time.Time) {
+	text := strings.TrimRight(string(t.partial), " ")
+	t.partial, t.redraw = t.partial[:0], false
+	if text == "" {
+		return
+	}
+	select {
+	case t.queue <- outputLine{Time: now, Text: text}:
+	default:
+		t.dropped++
+	}
+}
+
+// close sends the last partial line and what is queued, then closes the
+// sinks. Safe to call on a nil tee.
+func (t *outputTee) close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.emitLocked(time.Now())
+	t.closed = true
+	close(t.queue)
+	t.mu.Unlock()
+	<-t.done
+}
+
+// run sends queued lines in batches until the queue is closed.
+func (t *outputTee) run() {
+	defer close(t.done)
+	defer recoverGoroutine("output sinks for session " + t.src.Session)
+	ticker := time.NewTicker(outputSinkInterval)
+	defer ticker.Stop()
+	var batch []outputLine
+	for open := true; open; {
+		select {
+		case line, ok := <-t.queue:
+			if ok {
+				batch = append(batch, line)
+				continue
+			}
+			open = false
+		case <-ticker.C:
+		}
+		if len(batch) > 0 {
+			t.send(batch)
+			batch = nil
+		}
+	}
+	for i, sink := range t.sinks {
+		if err := sink.close(); err != nil {
+			log.Printf("Session %s: output sink %s: %v", t.src.Session, t.names[i], err)
+		}
+	}
+}
+
+// send hands a batch to every sink, logging the ones that fail.
+func (t *outputTee) send(batch []outputLine) {
+	for i, sink := range t.sinks {
+		if err := sink.send(t.src, batch); err != nil {
+			log.Printf("Session %s: output sink %s: %v (%d lines lost)", t.src.Session, t.names[i], err, len(batch))
+		}
+	}
+	t.mu.Lock()
+	dropped := t.dropped
+	t.dropped = 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("Session %s: output sinks fell behind, %d lines dropped", t.src.Session, dropped)
+	}
+}
+
+// fileOutputSink appends to session-<uuid>.log in dir.
+type fileOutputSink struct {
+	dir string
+	f   *os.File
+}
+
+func (s *fileOutputSink) send(src outputSource, lines []outputLine) error {
+	if s.f == nil {
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(s.dir, "session-"+src.Session+".log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		s.f = f
+	}
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.Time.UTC().Format(time.RFC3339Nano) + " " + l.Text + "\n")
+	}
+	_, err := io.WriteString(s.f, b.String())
+	return err
+}
+
+func (s *fileOutputSink) close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// syslogOutputSink writes each line as a syslog message. It connects on
+// the first batch, so starting a session never waits on the network.
+type syslogOutputSink struct {
+	network, raddr, tag string
+	w                   *syslog.Writer
+}
+
+func (s *syslogOutputSink) send(src outputSource, lines []outputLine) error {
+	if s.w == nil {
+		w, err := syslog.Dial(s.network, s.raddr, syslog.LOG_INFO|syslog.LOG_USER, s.tag)
+		if err != nil {
+			return err
+		}
+		s.w = w
+	}
+	for _, l := range lines {
+		if err := s.w.Info(fmt.Sprintf("session=%s assistant=%s repo=%s %s", src.Session, src.Assistant, src.Repo, l.Text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogOutputSink) close() error {
+	if s.w == nil {
+		return nil
+	}
+	return s.w.Close()
+}
+
+// httpOutputSink posts batches to Loki's push API or Elasticsearch's bulk
+// API.
+type httpOutputSink struct {
+	kind    string
+	url     string
+	headers map[string]string
+	labels  map[string]string
+	client  *http.Client
+}
+
+func (s *httpOutputSink) send(src outputSource, lines []outputLine) error {
+	var body []byte
+	contentType := "application/json"
+	if s.kind == "loki" {
+		stream := map[string]string{"job": "swe-swe", "session": src.Session, "assistant": src.Assistant, "repo": src.Repo}
+		for k, v := range s.labels {
+			stream[k] = v
+		}
+		values := make([][2]string, len(lines))
+		for i, l := range lines {
+			values[i] = [2]string{fmt.Sprint(l.Time.UnixNano()), l.Text}
+		}
+		var err error
+		body, err = json.Marshal(map[string]any{"streams": []any{map[string]any{"stream": stream, "values": values}}})
+		if err != nil {
+			return err
+		}
+	} else {
+		contentType = "application/x-ndjson"
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		for _, l := range lines {
+			enc.Encode(map[string]any{"create": map[string]any{}})
+			enc.Encode(map[string]any{"@timestamp": l.Time.UTC(), "session": src.Session, "assistant": src.Assistant, "repo": src.Repo, "message": l.Text})
+		}
+		body = b.Bytes()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	if s.kind == "elasticsearch" {
+		// The bulk API answers 200 and reports failed items in the body.
+		var result struct {
+			Errors bool `json:"errors"`
+		}
+		if json.Unmarshal(reply, &result) == nil && result.Errors {
+			return errors.New("elasticsearch rejected some lines")
+		}
+	}
+	return nil
+}
+
+func (s *httpOutputSink) close() error { return nil }
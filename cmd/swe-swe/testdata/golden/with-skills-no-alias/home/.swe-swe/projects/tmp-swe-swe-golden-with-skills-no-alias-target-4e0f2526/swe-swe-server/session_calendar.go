@@ -0,0 +1,132 @@
+// session_calendar.go -- iCal calendar of session history.
+//
+//	GET /feeds/sessions.ics[?repo=NAME][&key=KEY]
+//
+// returns every ended agent session as a calendar event from its start to
+// its end, titled with the assistant and the session's name or summary, so
+// time spent in agent sessions shows up in a calendar app for retros and
+// timesheets. repo limits it to one workspace, named as in usage reports
+// ("workspace" for the default workspace).
+//
+// The events come from the usage ledger (usage_report.go), which keeps each
+// completed session's times, assistant, repo, name and summary after its
+// recording is deleted. Past events therefore stay in the calendar. The
+// ledger is brought up to date on each request, so a session shows up as soon
+// as it ends. Access is as for the recordings feed (recordings_feed.go): the
+// owner's login or ?key=.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCalendarPath = "/feeds/sessions.ics"
+	// icalTimeLayout is an iCalendar UTC DATE-TIME.
+	icalTimeLayout = "20060102T150405Z"
+)
+
+// icalEscape escapes an iCalendar TEXT value (RFC 5545 3.3.11).
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// icalLine writes one content line, folded at 75 octets without splitting a
+// UTF-8 sequence (RFC 5545 3.1).
+func icalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// Continuation lines lose one octet to the leading space.
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// buildSessionCalendar renders the ledger entries for repo (all repos when
+// empty) as an iCalendar, oldest first. host qualifies the event UIDs.
+func buildSessionCalendar(entries []usageEntry, repo, host string, now time.Time) string {
+	var selected []usageEntry
+	for _, e := range entries {
+		if repo == "" || e.Repo == repo {
+			selected = append(selected, e)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].StartedAt.Before(selected[j].StartedAt) })
+
+	name := "swe-swe sessions"
+	if repo != "" {
+		name += " (" + repo + ")"
+	}
+	var b strings.Builder
+	for _, line := range []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//swe-swe//Session history//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + icalEscape(name),
+	} {
+		icalLine(&b, line)
+	}
+	stamp := now.UTC().Format(icalTimeLayout)
+	for _, e := range selected {
+		title := e.Agent
+		if t := firstNonEmpty(e.Name, e.Summary); t != "" {
+			title += ": " + t
+		}
+		desc := fmt.Sprintf("%s session in %s, %s", e.Agent, e.Repo, formatFeedDuration(e.EndedAt.Sub(e.StartedAt)))
+		if e.Summary != "" && e.Summary != e.Name {
+			desc += "\n" + e.Summary
+		}
+		for _, line := range []string{
+			"BEGIN:VEVENT",
+			"UID:" + e.UUID + "@" + host,
+			"DTSTAMP:" + stamp,
+			"DTSTART:" + e.StartedAt.UTC().Format(icalTimeLayout),
+			"DTEND:" + e.EndedAt.UTC().Format(icalTimeLayout),
+			"SUMMARY:" + icalEscape(title),
+			"DESCRIPTION:" + icalEscape(desc),
+			"CATEGORIES:" + icalEscape(e.Agent) + "," + icalEscape(e.Repo),
+			"TRANSP:TRANSPARENT",
+			"END:VEVENT",
+		} {
+			icalLine(&b, line)
+		}
+	}
+	icalLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// handleSessionCalendar serves GET /feeds/sessions.ics.
+func handleSessionCalendar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !feedAuthorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := collectUsage(); err != nil {
+		log.Printf("Usage: %v", err)
+	}
+	entries, err := readUsageLedger()
+	if err != nil {
+		http.Error(w, "Failed to read session history", http.StatusInternalServerError)
+		return
+	}
+	repo := strings.TrimSpace(r.URL.Query().Get("repo"))
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(buildSessionCalendar(entries, repo, r.Host, time.Now())))
+}
@@ -0,0 +1,354 @@
+// session_copy.go -- copy a large range of terminal rows through the server.
+//
+// Copying thousands of lines out of the browser terminal is unreliable: the
+// selection is rebuilt from xterm.js's buffer, long lines come out broken at
+// the terminal width, and big clipboard writes fail. Instead the page asks
+// for the rows on the session's WebSocket:
+//
+//	{"type": "copy_rows", "data": {"start": -5000, "end": -1}}
+//
+// Rows count from the top of the session's history -- the rows scrolled off
+// the screen, then the screen itself -- and a negative row counts back from
+// the end, so -1 is the bottom row of the screen. A page can send its
+// xterm.js buffer rows minus the buffer's length. end is inclusive. The
+// server rebuilds the history by replaying the session's output (the last
+// copyReplayMax bytes of its recording log, or the in-memory ring buffer
+// when it has no recording) through an emulator of the terminal's size, and
+// joins rows that were wrapped at the terminal width back into one line.
+// Only the sender gets the answer:
+//
+//	{"type": "copy_ready", "url": "/api/session/{uuid}/copy/{token}",
+//	 "rows": 5000, "lines": 4212, "bytes": 391220}
+//	{"type": "copy_error", "message": "..."}
+//
+// The URL serves the text once, within copyDownloadTTL. Secrets are masked
+// (secrets.go). Like xterm.js, the history skips the alternate screen and
+// forgets what an ESC [3J cleared.
+package main
+
+import (
+	"bytes"
+	crypto_rand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hinshun/vt10x"
+)
+
+const (
+	// copyReplayMax is how much of the recording log is replayed.
+	copyReplayMax = 8 << 20
+	// copyMaxRows bounds one copy.
+	copyMaxRows = 100000
+	// copyDownloadTTL is how long a copy's URL works.
+	copyDownloadTTL = 5 * time.Minute
+	// copyMaxPending is how many unfetched copies the server keeps.
+	copyMaxPending = 32
+)
+
+// vtCursorWrapNext is vt10x's cursor state bit for a pending autowrap.
+const vtCursorWrapNext = 1 << 1
+
+// historyRow is one row of a terminal's history.
+type historyRow struct {
+	text    string
+	wrapped bool // the line goes on in the next row
+}
+
+// historyRecorder replays output into an emulator and keeps the rows that
+// scroll off its top.
+type historyRecorder struct {
+	vt          vt10x.Terminal
+	cols, rows  int
+	top, bottom int // scrolling region, as set by ESC [r
+	history     []historyRow
+}
+
+// terminalHistory replays output on a cols x rows terminal and returns every
+// row it showed: those that scrolled off, then the final screen.
+func terminalHistory(output []byte, cols, rows int) []historyRow {
+	h := &historyRecorder{vt: vt10x.New(vt10x.WithSize(cols, rows)), cols: cols, rows: rows, bottom: rows - 1}
+	h.feed(output)
+	all := h.history
+	for y := 0; y < rows; y++ {
+		all = append(all, h.row(y))
+	}
+	return all
+}
+
+// row reads row y of the screen.
+func (h *historyRecorder) row(y int) historyRow {
+	var b strings.Builder
+	for x := 0; x < h.cols; x++ {
+		ch := h.vt.Cell(x, y).Char
+		if ch == 0 {
+			ch = ' '
+		}
+		b.WriteRune(ch)
+	}
+	r := historyRow{text: b.String(), wrapped: h.vt.Cell(h.cols-1, y).Mode&vtAttrWrap != 0}
+	if !r.wrapped {
+		r.text = strings.TrimRight(r.text, " ")
+	}
+	return r
+}
+
+// scrollsIntoHistory reports whether a scroll now would move rows into the
+// history: the region starts at the top and the main screen is showing.
+func (h *historyRecorder) scrollsIntoHistory() bool {
+	return h.top == 0 && h.vt.Mode()&vt10x.ModeAltScreen == 0
+}
+
+// atBottom reports whether the cursor is on the region's last row, where a
+// newline scrolls.
+func (h *historyRecorder) atBottom() bool {
+	return h.vt.Cursor().Y == h.bottom
+}
+
+// keep moves the top n rows into the history, before they scroll away.
+func (h *historyRecorder) keep(n int) {
+	for y := 0; y < min(n, h.bottom+1); y++ {
+		h.history = append(h.history, h.row(y))
+	}
+}
+
+// feed writes output to the emulator in pieces small enough that every
+// scroll is seen coming.
+func (h *historyRecorder) feed(out []byte) {
+	for i := 0; i < len(out); {
+		switch c := out[i]; {
+		case c == 0x1b:
+			j := escapeEnd(out, i) + 1 // recording_chapters.go
+			h.escape(out[i:j])
+			h.vt.Write(out[i:j])
+			i = j
+		case c == '\n' || c == '\v' || c == '\f':
+			if h.atBottom() && h.scrollsIntoHistory() {
+				h.keep(1)
+			}
+			h.vt.Write(out[i : i+1])
+			i++
+		default:
+			j := i
+			for j < len(out) && out[j] != 0x1b && out[j] != '\n' && out[j] != '\v' && out[j] != '\f' {
+				j++
+			}
+			h.text(out[i:j])
+			i = j
+		}
+	}
+}
+
+// text writes a run of text without newlines. Off the bottom row, up to a
+// row's worth at a time cannot scroll; on it, each character that would
+// autowrap scrolls first.
+func (h *historyRecorder) text(p []byte) {
+	for len(p) > 0 {
+		if !h.atBottom() {
+			n := min(len(p), h.cols)
+			for n < len(p) && n > 1 && !utf8.RuneStart(p[n]) {
+				n--
+			}
+			h.vt.Write(p[:n])
+			p = p[n:]
+			continue
+		}
+		_, n := utf8.DecodeRune(p)
+		if p[0] >= 0x20 && p[0] != 0x7f && h.vt.Cursor().State&vtCursorWrapNext != 0 && h.vt.Mode()&vt10x.ModeWrap != 0 && h.scrollsIntoHistory() {
+			h.keep(1)
+		}
+		h.vt.Write(p[:n])
+		p = p[n:]
+	}
+}
+
+// escape tracks the escape sequences that scroll, set the scrolling region
+// or clear the history. It runs before seq is written.
+func (h *historyRecorder) escape(seq []byte) {
+	if len(seq) < 2 {
+		return
+	}
+	switch seq[1] {
+	case 'D', 'E': // IND, NEL
+		if h.atBottom() && h.scrollsIntoHistory() {
+			h.keep(1)
+		}
+	case 'c': // RIS
+		h.top, h.bottom = 0, h.rows-1
+	case '[':
+		if len(seq) < 3 {
+			return
+		}
+		params, final := string(seq[2:len(seq)-1]), seq[len(seq)-1]
+		if strings.HasPrefix(params, "?") {
+			return
+		}
+		args := strings.Split(params, ";")
+		arg := func(i, def int) int {
+			if i < len(args) {
+				if n, err := strconv.Atoi(args[i]); err == nil && n > 0 {
+					return n
+				}
+			}
+			return def
+		}
+		switch final {
+		case 'S': // SU: scroll up
+			if h.scrollsIntoHistory() {
+				h.keep(arg(0, 1))
+			}
+		case 'r': // DECSTBM
+			top, bottom := arg(0, 1)-1, arg(1, h.rows)-1
+			if top < bottom && bottom < h.rows {
+				h.top, h.bottom = top, bottom
+			}
+		case 'J':
+			if params == "3" {
+				h.history = nil
+			}
+		}
+	}
+}
+
+// historyText joins rows[start..end] into text, one line per unwrapped row.
+func historyText(rows []historyRow, start, end int) (text string, lines int) {
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		b.WriteString(rows[i].text)
+		if !rows[i].wrapped || i == end {
+			b.WriteByte('\n')
+			lines++
+		}
+	}
+	return b.String(), lines
+}
+
+// copyOutput returns the output to replay for a copy: the end of the
+// recording log, from a line start, or the ring buffer.
+func (s *Session) copyOutput() []byte {
+	if f, err := os.Open(recordingPath(s.RecordingPrefix + ".log")); err == nil {
+		defer f.Close()
+		if info, err := f.Stat(); err == nil {
+			start, end := scrollbackBounds(f, info.Size())
+			from := max(start, end-copyReplayMax)
+			buf := make([]byte, end-from)
+			n, _ := f.ReadAt(buf, from)
+			buf = buf[:n]
+			if from > start {
+				if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+					buf = buf[i+1:]
+				}
+			}
+			if len(buf) > 0 {
+				return buf
+			}
+		}
+	}
+	s.vtMu.Lock()
+	defer s.vtMu.Unlock()
+	return s.ring.Bytes()
+}
+
+// copyDownload is a copy waiting to be fetched.
+type copyDownload struct {
+	session string
+	text    string
+	expires time.Time
+}
+
+var (
+	copyDownloads   = map[string]copyDownload{}
+	copyDownloadsMu sync.Mutex
+)
+
+// copyRows extracts rows start..end (negative counts from the end) and
+// returns the one-time URL and what it holds.
+func (s *Session) copyRows(start, end int) (map[string]any, error) {
+	s.vtMu.Lock()
+	cols, rows := 0, 0
+	if s.vt != nil {
+		cols, rows = s.vt.Size()
+	}
+	s.vtMu.Unlock()
+	if cols <= 0 || rows <= 0 {
+		return nil, errors.New("the session has no terminal")
+	}
+	history := terminalHistory(s.copyOutput(), cols, rows)
+	if start < 0 {
+		start += len(history)
+	}
+	if end < 0 {
+		end += len(history)
+	}
+	start, end = max(start, 0), min(end, len(history)-1)
+	if start > end {
+		return nil, errors.New("no rows in that range")
+	}
+	if end-start+1 > copyMaxRows {
+		return nil, fmt.Errorf("at most %d rows at a time", copyMaxRows)
+	}
+	text, lines := historyText(history, start, end)
+	text = maskSecrets(text)
+
+	b := make([]byte, 16)
+	if _, err := crypto_rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	copyDownloadsMu.Lock()
+	for t, d := range copyDownloads {
+		if now.After(d.expires) {
+			delete(copyDownloads, t)
+		}
+	}
+	if len(copyDownloads) >= copyMaxPending {
+		copyDownloadsMu.Unlock()
+		return nil, errors.New("too many copies waiting to be fetched; try again in a few minutes")
+	}
+	copyDownloads[token] = copyDownload{session: s.UUID, text: text, expires: now.Add(copyDownloadTTL)}
+	copyDownloadsMu.Unlock()
+
+	log.Printf("Session %s: copied rows %d-%d (%d lines, %d bytes)", s.UUID, start, end, lines, len(text))
+	return map[string]any{
+		"type":  "copy_ready",
+		"url":   "/api/session/" + s.UUID + "/copy/" + token,
+		"rows":  end - start + 1,
+		"lines": lines,
+		"bytes": len(text),
+	}, nil
+}
+
+// handleSessionCopyAPI serves GET /api/session/{uuid}/copy/{token}, once.
+func handleSessionCopyAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/api/session/")
+	sessionUUID, token, _ := strings.Cut(rest, "/copy/")
+	copyDownloadsMu.Lock()
+	d, ok := copyDownloads[token]
+	if ok && d.session == sessionUUID {
+		delete(copyDownloads, token)
+	}
+	copyDownloadsMu.Unlock()
+	if !ok || d.session != sessionUUID || time.Now().After(d.expires) {
+		http.Error(w, "Copy not found or already fetched", http.StatusNotFound)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="terminal-%s.txt"`, sessionUUID[:min(8, len(sessionUUID))]))
+	w.Write([]byte(d.text))
+}
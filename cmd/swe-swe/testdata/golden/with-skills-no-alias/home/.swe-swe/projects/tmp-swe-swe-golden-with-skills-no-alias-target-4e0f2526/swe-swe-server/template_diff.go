@@ -0,0 +1,322 @@
+// template_diff.go -- what updating a repo's swe-swe files would change,
+// without changing anything.
+//
+// setupSweSweFiles only writes templates a repo does not have yet, and
+// --dump-container-templates writes all of them to a scratch directory for
+// the /swe-swe:update flow to merge three ways (docs/adr/0030). Neither says
+// up front what an update would do. diffContainerTemplates compares, for
+// every embedded template, its baseline (.swe-swe/baseline/<path>), the
+// repo's copy and the template, following the update rules:
+//
+//	unchanged  the repo's copy is the template already
+//	add        the repo has no copy; the template would be written
+//	update     the repo's copy is still the baseline; it would be replaced
+//	keep       the template is the baseline; the repo's edits stay
+//	ask        no baseline, and the repo's copy differs: the user decides
+//	merge      both the repo's copy and the template moved off the baseline
+//
+// Each result but unchanged and keep carries a unified diff from the repo's
+// copy to the template; merge also carries localDiff, from the baseline to
+// the repo's copy (what the user changed). Nothing is written:
+//
+//	swe-swe-server --diff-container-templates /workspace
+//	GET /api/repo/template-diff?path=/repos/x/workspace
+//	    -> {"path": "...", "changes": 2, "files": [{"path": ".swe-swe/docs/AGENTS.md",
+//	        "action": "merge", "baseline": true, "diff": "--- a/...", "localDiff": "..."}, ...]}
+//
+// path is a repository as in /api/repo/env (the workspace when left out).
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Template update actions; see the file comment.
+const (
+	templateUnchanged = "unchanged"
+	templateAdd       = "add"
+	templateUpdate    = "update"
+	templateKeep      = "keep"
+	templateAsk       = "ask"
+	templateMerge     = "merge"
+)
+
+// templateChange is what an update would do to one template in a repo.
+type templateChange struct {
+	Path      string `json:"path"`
+	Action    string `json:"action"`
+	Baseline  bool   `json:"baseline"`            // .swe-swe/baseline has a copy
+	Diff      string `json:"diff,omitempty"`      // repo's copy -> template
+	LocalDiff string `json:"localDiff,omitempty"` // baseline -> repo's copy, for merge
+}
+
+// diffContainerTemplates reports what updating repoDir's swe-swe files to
+// the embedded templates would do, in template path order.
+func diffContainerTemplates(repoDir string) ([]templateChange, error) {
+	var changes []templateChange
+	err := fs.WalkDir(containerTemplatesFS, "container-templates", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath := strings.TrimPrefix(path, "container-templates/")
+		tmpl, err := containerTemplatesFS.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read embedded %s: %w", path, err)
+		}
+		current, hasCurrent, err := readOptionalFile(filepath.Join(repoDir, relPath))
+		if err != nil {
+			return err
+		}
+		baseline, hasBaseline, err := readOptionalFile(filepath.Join(repoDir, ".swe-swe", "baseline", relPath))
+		if err != nil {
+			return err
+		}
+		changes = append(changes, classifyTemplateChange(relPath, tmpl, current, hasCurrent, baseline, hasBaseline))
+		return nil
+	})
+	return changes, err
+}
+
+// classifyTemplateChange applies the update rules to one template.
+func classifyTemplateChange(relPath string, tmpl, current []byte, hasCurrent bool, baseline []byte, hasBaseline bool) templateChange {
+	c := templateChange{Path: relPath, Baseline: hasBaseline}
+	switch {
+	case !hasCurrent:
+		c.Action = templateAdd
+		c.Diff = unifiedDiff("/dev/null", "b/"+relPath, nil, tmpl)
+		return c
+	case bytes.Equal(current, tmpl):
+		c.Action = templateUnchanged
+		return c
+	case !hasBaseline:
+		c.Action = templateAsk
+	case bytes.Equal(baseline, current):
+		c.Action = templateUpdate
+	case bytes.Equal(baseline, tmpl):
+		c.Action = templateKeep
+		return c
+	default:
+		c.Action = templateMerge
+		c.LocalDiff = unifiedDiff("a/.swe-swe/baseline/"+relPath, "b/"+relPath, baseline, current)
+	}
+	c.Diff = unifiedDiff("a/"+relPath, "b/"+relPath, current, tmpl)
+	return c
+}
+
+// readOptionalFile reads path; ok is false when it does not exist.
+func readOptionalFile(path string) (data []byte, ok bool, err error) {
+	data, err = os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	return data, err == nil, err
+}
+
+// writeTemplateChanges prints changes for --diff-container-templates: one
+// line per template, then the diffs of those an update would touch.
+func writeTemplateChanges(w io.Writer, changes []templateChange) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "%-9s %s\n", c.Action, c.Path)
+	}
+	for _, c := range changes {
+		if c.LocalDiff != "" {
+			fmt.Fprintf(w, "\n# %s: your changes since the baseline\n%s", c.Path, c.LocalDiff)
+		}
+		if c.Diff != "" {
+			fmt.Fprintf(w, "\n# %s: %s\n%s", c.Path, c.Action, c.Diff)
+		}
+	}
+}
+
+// handleRepoTemplateDiffAPI handles GET /api/repo/template-diff?path=...
+func handleRepoTemplateDiffAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repoPath := r.URL.Query().Get("path")
+	if repoPath == "" {
+		repoPath = workspaceDir
+	}
+	repoPath, err := policyRepoPath.Resolve(repoPath)
+	if err != nil {
+		http.Error(w, "Invalid repository path", http.StatusBadRequest)
+		return
+	}
+	if fi, err := os.Stat(repoPath); err != nil || !fi.IsDir() {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	changes, err := diffContainerTemplates(repoPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n := 0
+	for _, c := range changes {
+		if c.Action != templateUnchanged && c.Action != templateKeep {
+			n++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"path": repoPath, "changes": n, "files": changes})
+}
+
+// diffContext is the number of unchanged lines around each change.
+const diffContext = 3
+
+// diffMaxCells bounds the line-matching table; files too different to fit
+// are shown as all of one removed and all of the other added.
+const diffMaxCells = 1 << 22
+
+// unifiedDiff is a unified diff from a to b, "" when they are equal.
+func unifiedDiff(fromName, toName string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	ops := diffLineOps(splitDiffLines(a), splitDiffLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	// aAt[k] and bAt[k] count the lines of a and b before ops[k].
+	aAt, bAt := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for k, op := range ops {
+		aAt[k+1], bAt[k+1] = aAt[k], bAt[k]
+		if op.kind != '+' {
+			aAt[k+1]++
+		}
+		if op.kind != '-' {
+			bAt[k+1]++
+		}
+	}
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start, end := max(0, i-diffContext), i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next < len(ops) && next-end <= 2*diffContext {
+				end = next
+				continue
+			}
+			end = min(len(ops), end+diffContext)
+			break
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aAt[start], aAt[end]-aAt[start]), hunkRange(bAt[start], bAt[end]-bAt[start]))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats a hunk's start and length; an empty range starts at the
+// line before it.
+func hunkRange(before, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, n)
+}
+
+// diffOp is one line of a diff: kind ' ' kept, '-' removed, '+' added.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitDiffLines splits s into lines, each keeping its "\n".
+func splitDiffLines(s []byte) []string {
+	lines := strings.SplitAfter(string(s), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLineOps matches a's lines to b's by longest common subsequence.
+func diffLineOps(a, b []string) []diffOp {
+	var head, tail []diffOp
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append(tail, diffOp{' ', a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	ops := head
+	n, m := len(a), len(b)
+	if (n+1)*(m+1) > diffMaxCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+	} else {
+		// lcs[i*(m+1)+j] is the LCS length of a[i:] and b[j:].
+		lcs := make([]int32, (n+1)*(m+1))
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+				} else {
+					lcs[i*(m+1)+j] = max(lcs[(i+1)*(m+1)+j], lcs[i*(m+1)+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n && j < m {
+			switch {
+			case a[i] == b[j]:
+				ops = append(ops, diffOp{' ', a[i]})
+				i, j = i+1, j+1
+			case lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+				ops = append(ops, diffOp{'-', a[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', b[j]})
+				j++
+			}
+		}
+		for ; i < n; i++ {
+			ops = append(ops, diffOp{'-', a[i]})
+		}
+		for ; j < m; j++ {
+			ops = append(ops, diffOp{'+', b[j]})
+		}
+	}
+	for k := len(tail) - 1; k >= 0; k-- {
+		ops = append(ops, tail[k])
+	}
+	return ops
+}
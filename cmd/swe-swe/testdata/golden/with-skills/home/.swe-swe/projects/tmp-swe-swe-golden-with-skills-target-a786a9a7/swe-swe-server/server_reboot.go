@@ -103,7 +103,7 @@ func handleServerRebootAPI(w http.ResponseWriter, r *http.Request) {
 	if f, ok := w.(http.Flusher); ok {
 		f.Flush()
 	}
-	log.Printf("Reboot requested via web UI from %s (compose project %s)", r.RemoteAddr, project)
+	log.Printf("Reboot requested via web UI from %s (compose project %s)", clientLabel(r), project)
 	if err := triggerRebuildReboot(project); err != nil {
 		log.Printf("Reboot trigger failed: %v", err)
 	}
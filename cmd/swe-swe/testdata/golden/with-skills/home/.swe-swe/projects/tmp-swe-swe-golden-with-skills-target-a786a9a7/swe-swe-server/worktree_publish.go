@@ -0,0 +1,210 @@
+// worktree_publish.go -- POST /api/worktree/{branch}/publish: run the repo's
+// pre-publish checks in a worktree, then push its branch.
+//
+// An agent that pushes a branch which does not build gets it PR'd anyway. A
+// repo declares its checks in .swe-swe/env (read from the worktree, layered
+// over the server environment), each a shell command run with `sh -c` in the
+// worktree, in this order:
+//
+//	SWE_PUBLISH_BUILD=go build ./...
+//	SWE_PUBLISH_LINT=go vet ./...
+//	SWE_PUBLISH_TEST=go test ./...     (defaults to SWE_TEST_CMD)
+//
+// Unset checks are skipped. When every check passes the branch is pushed with
+// `git push -u origin {branch}`. When one fails, SWE_PUBLISH_ON_FAIL decides:
+// "refuse" (default) stops before pushing, "warn" pushes anyway and reports
+// the failures. A request with {"force": true} pushes despite failures too.
+//
+// The response streams NDJSON as the work happens:
+//
+//	{"type": "check", "name": "build", "command": "go build ./..."}
+//	{"type": "output", "data": "..."}
+//	{"type": "check_result", "name": "build", "passed": true, "exitCode": 0, "durationMs": 812}
+//	{"type": "push", "command": "git push -u origin fix/login"}
+//	{"type": "done", "pushed": true, "failed": [], "warnings": []}
+//
+// A refused publish ends with {"type": "done", "pushed": false, "refused":
+// true, "failed": ["lint"]}. Each check (and the push) is killed after
+// SWE_TEST_TIMEOUT, and all of them when the client disconnects. The worktree
+// must have {branch} checked out. Bad requests are plain HTTP errors before
+// any streaming starts.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// publishCheck is one configured pre-publish check.
+type publishCheck struct {
+	Name    string
+	Command string
+}
+
+// publishChecks returns the checks configured in env, in run order.
+func publishChecks(env []string) []publishCheck {
+	lookup := envLookup(env)
+	test := lookup("SWE_PUBLISH_TEST")
+	if strings.TrimSpace(test) == "" {
+		test = lookup("SWE_TEST_CMD")
+	}
+	var checks []publishCheck
+	for _, c := range []publishCheck{
+		{"build", lookup("SWE_PUBLISH_BUILD")},
+		{"lint", lookup("SWE_PUBLISH_LINT")},
+		{"test", test},
+	} {
+		if c.Command = strings.TrimSpace(c.Command); c.Command != "" {
+			checks = append(checks, c)
+		}
+	}
+	return checks
+}
+
+// publishRefuses reports whether failed checks stop the push, per
+// SWE_PUBLISH_ON_FAIL in env.
+func publishRefuses(env []string) (bool, error) {
+	switch v := strings.ToLower(strings.TrimSpace(envLookup(env)("SWE_PUBLISH_ON_FAIL"))); v {
+	case "", "refuse":
+		return true, nil
+	case "warn":
+		return false, nil
+	default:
+		return true, fmt.Errorf("SWE_PUBLISH_ON_FAIL=%q: want refuse or warn", v)
+	}
+}
+
+// publishRequest is the optional POST /api/worktree/{branch}/publish body.
+type publishRequest struct {
+	Path  string `json:"path"`  // worktree directory (e.g. under /repos); defaults to the default repo's worktree for branch
+	Force bool   `json:"force"` // push even when a check fails
+}
+
+// validBranchName reports whether git accepts name as a branch name.
+func validBranchName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return false
+	}
+	return exec.Command("git", "check-ref-format", "--branch", name).Run() == nil
+}
+
+// resolveBranchWorktree returns the worktree directory for branch: path, or by
+// default the default repo's worktree for it. The worktree must have branch
+// checked out.
+func resolveBranchWorktree(branch, path string) (string, error) {
+	if path == "" {
+		path = filepath.Join(worktreeDir, worktreeDirName(branch))
+	}
+	path, err := policyWorkDir.Resolve(path)
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("no worktree at %s", path)
+	}
+	out, err := gitInWorkDir(path, "branch", "--show-current")
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git worktree", path)
+	}
+	if current := strings.TrimSpace(out); current != branch {
+		return "", fmt.Errorf("%s has %q checked out, not %q", path, current, branch)
+	}
+	return path, nil
+}
+
+// handleWorktreePublishAPI handles POST /api/worktree/{branch}/publish.
+func handleWorktreePublishAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	branch := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/worktree/"), "/publish")
+	if !validBranchName(branch) {
+		http.Error(w, fmt.Sprintf("Invalid branch %q", branch), http.StatusBadRequest)
+		return
+	}
+	var req publishRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	dir, err := resolveBranchWorktree(branch, req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	env := os.Environ()
+	env = append(env, loadEnvFile(filepath.Join(dir, ".swe-swe", "env"), "", envLookup(env))...)
+	refuse, err := publishRefuses(env)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	refuse = refuse && !req.Force
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	stream := newExecStream(w)
+	log.Printf("Publish from %s: %s in %s", clientLabel(r), branch, dir)
+
+	failed := []string{}
+	for _, c := range publishChecks(env) {
+		stream.event(map[string]any{"type": "check", "name": c.Name, "command": c.Command})
+		start := time.Now()
+		exitCode, timedOut, err := runShellCommand(r.Context(), c.Command, dir, env, stream, testTimeout)
+		result := map[string]any{
+			"type":       "check_result",
+			"name":       c.Name,
+			"passed":     err == nil && exitCode == 0,
+			"exitCode":   exitCode,
+			"durationMs": time.Since(start).Milliseconds(),
+		}
+		if timedOut {
+			result["timedOut"] = true
+		}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		stream.event(result)
+		if err != nil || exitCode != 0 {
+			failed = append(failed, c.Name)
+		}
+		if r.Context().Err() != nil {
+			log.Printf("Publish from %s: %s: client went away", clientLabel(r), branch)
+			return
+		}
+	}
+
+	if len(failed) > 0 && refuse {
+		log.Printf("Publish from %s: %s refused, failed checks: %s", clientLabel(r), branch, strings.Join(failed, ", "))
+		stream.event(map[string]any{"type": "done", "pushed": false, "refused": true, "failed": failed})
+		return
+	}
+	warnings := []string{}
+	for _, name := range failed {
+		warnings = append(warnings, fmt.Sprintf("%s check failed; pushed anyway", name))
+	}
+
+	push := "git push -u origin " + shellQuote(branch)
+	stream.event(map[string]any{"type": "push", "command": push})
+	exitCode, _, err := runShellCommand(r.Context(), push, dir, env, stream, testTimeout)
+	done := map[string]any{"type": "done", "pushed": err == nil && exitCode == 0, "failed": failed, "warnings": warnings}
+	if err != nil || exitCode != 0 {
+		done["error"] = fmt.Sprintf("git push exited %d", exitCode)
+		if err != nil {
+			done["error"] = err.Error()
+		}
+	}
+	log.Printf("Publish from %s: %s pushed=%v failed=%v", clientLabel(r), branch, done["pushed"], failed)
+	stream.event(done)
+}
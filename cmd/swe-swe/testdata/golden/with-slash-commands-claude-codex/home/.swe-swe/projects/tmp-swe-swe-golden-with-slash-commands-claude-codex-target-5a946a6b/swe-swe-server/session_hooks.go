@@ -0,0 +1,323 @@
+// session_hooks.go -- repo-defined scripts run when a session starts and ends.
+//
+// A repository can check in executable scripts that swe-swe-server runs for
+// each agent session in it:
+//
+//	.swe-swe/hooks/on-session-start   after the session process is spawned
+//	.swe-swe/hooks/on-session-end     after the session process exits
+//
+// (swe-swe/hooks/ is accepted too, matching where swe-swe/env lived before it
+// moved under .swe-swe/.) Typical uses: run linters, push a WIP branch, clean
+// temp files. A hook runs in the session's working directory with the
+// session's environment plus SWE_HOOK (the hook name) and, for on-session-end,
+// SWE_SESSION_EXIT_CODE. Like git hooks, a file that is not executable is
+// skipped with a warning.
+//
+// Hooks run in their own process group and are killed when SWE_HOOK_TIMEOUT
+// (default 60s) elapses, so a hanging script cannot pin anything. Their
+// combined output is appended to the recording's .hooks.txt sidecar (and
+// shown in the terminal when the session ends with a browser attached);
+// start/finish lines land in the session's server events.
+//
+// Shell sub-sessions (ParentUUID set) share their parent's working tree and
+// do not run hooks, and neither do sessions on an untrusted checkout
+// (SessionParams.UntrustedRepo). on-session-end runs at most once per session, whether the
+// process exited on its own or the session was ended.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	hookSessionStart = "on-session-start"
+	hookSessionEnd   = "on-session-end"
+	// hookBranchMoved runs in a repo, not a session, when a background
+	// fetch moves one of origin's branches (repo_fetch.go).
+	hookBranchMoved = "on-branch-moved"
+
+	// hookMaxOutputBytes caps what one hook run writes to the sidecar; the
+	// hook keeps running, later output is dropped.
+	hookMaxOutputBytes = 256 << 10
+)
+
+// hookDirs are searched in order, relative to the session's working directory.
+var hookDirs = []string{".swe-swe/hooks", "swe-swe/hooks"}
+
+// hookTimeout bounds each hook run. Set from SWE_HOOK_TIMEOUT by
+// loadHookTimeout.
+var hookTimeout = 60 * time.Second
+
+// loadHookTimeout applies SWE_HOOK_TIMEOUT: a Go duration ("2m") or a number
+// of seconds. Empty keeps the default.
+func loadHookTimeout() error {
+	v := strings.TrimSpace(os.Getenv("SWE_HOOK_TIMEOUT"))
+	if v == "" {
+		return nil
+	}
+	d, err := parseTimeoutSetting("SWE_HOOK_TIMEOUT", v)
+	if err != nil {
+		return err
+	}
+	hookTimeout = d
+	log.Printf("Session hook timeout from SWE_HOOK_TIMEOUT: %s", d)
+	return nil
+}
+
+// parseTimeoutSetting parses a positive Go duration or a bare number of
+// seconds; env names the setting in the error.
+func parseTimeoutSetting(env, v string) (time.Duration, error) {
+	s := v
+	if n, err := strconv.Atoi(s); err == nil {
+		s = strconv.Itoa(n) + "s"
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q (want a duration like 90s or 2m)", env, v)
+	}
+	return d, nil
+}
+
+// findSessionHook returns the path of the named hook under workDir, or "" if
+// the repo does not define it. A hook that exists but is not an executable
+// regular file is reported as an error so the caller can warn about it.
+func findSessionHook(workDir, name string) (string, error) {
+	for _, dir := range hookDirs {
+		path := filepath.Join(workDir, dir, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			return "", fmt.Errorf("%s is not a regular file", path)
+		}
+		if fi.Mode().Perm()&0o111 == 0 {
+			return "", fmt.Errorf("%s is not executable (chmod +x to enable it)", path)
+		}
+		return path, nil
+	}
+	return "", nil
+}
+
+// hookResult describes one finished hook run.
+type hookResult struct {
+	ExitCode int
+	TimedOut bool
+	Elapsed  time.Duration
+}
+
+// runHook runs path in dir with env, copying combined output to out, and
+// kills its whole process group after timeout. err is set only when the hook
+// could not be started or waited on; a non-zero exit is reported in
+// ExitCode.
+func runHook(path, dir string, env []string, out io.Writer, timeout time.Duration) (hookResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 2 * time.Second
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return hookResult{ExitCode: -1}, err
+	}
+	err := cmd.Wait()
+	res := hookResult{Elapsed: time.Since(start), TimedOut: errors.Is(ctx.Err(), context.DeadlineExceeded)}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+		err = nil
+	default:
+		res.ExitCode = -1
+	}
+	if res.TimedOut {
+		res.ExitCode = -1
+		err = nil
+	}
+	return res, err
+}
+
+// cappedWriter passes through the first n bytes and silently drops the rest.
+type cappedWriter struct {
+	w         io.Writer
+	n         int
+	truncated bool
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if c.n <= 0 {
+		c.truncated = c.truncated || len(p) > 0
+		return len(p), nil
+	}
+	q := p
+	if len(q) > c.n {
+		q = q[:c.n]
+		c.truncated = true
+	}
+	c.n -= len(q)
+	if _, err := c.w.Write(q); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// terminalWriter echoes hook output into the session terminal, translating
+// "\n" to "\r\n" since the PTY that normally does so is gone.
+type terminalWriter struct{ s *Session }
+
+func (t terminalWriter) Write(p []byte) (int, error) {
+	data := []byte(strings.ReplaceAll(string(p), "\n", "\r\n"))
+	t.s.vtMu.Lock()
+	t.s.vt.Write(data)
+	t.s.writeToRing(data)
+	t.s.vtMu.Unlock()
+	t.s.Broadcast(data)
+	return len(p), nil
+}
+
+// hookEnv returns the session's spawn environment (which already carries
+// SESSION_UUID) plus the hook variables.
+func (s *Session) hookEnv(name string, extra ...string) []string {
+	env := append(s.spawnEnv(), "SWE_HOOK="+name)
+	return append(env, extra...)
+}
+
+// spawnEnv returns a copy of the environment the session's process was
+// started with, or the server's own before there is one.
+func (s *Session) spawnEnv() []string {
+	s.mu.RLock()
+	var base []string
+	if s.Cmd != nil && s.Cmd.Env != nil {
+		base = s.Cmd.Env
+	}
+	s.mu.RUnlock()
+	if base == nil {
+		base = os.Environ()
+	}
+	return append([]string{}, base...)
+}
+
+// runSessionStartHook runs on-session-start for a newly spawned session. It
+// returns immediately; the hook runs in the background.
+func (s *Session) runSessionStartHook() {
+	if s.ParentUUID != "" || s.untrustedRepo {
+		return
+	}
+	go func() {
+		defer recoverGoroutine(fmt.Sprintf("%s hook for session %s", hookSessionStart, s.UUID))
+		s.runSessionHook(hookSessionStart, nil)
+	}()
+}
+
+// runSessionEndHook runs on-session-end once per session, in the background.
+// showInTerminal echoes the output to attached browsers as well.
+func (s *Session) runSessionEndHook(exitCode int, showInTerminal bool) {
+	if s.ParentUUID != "" || s.untrustedRepo {
+		return
+	}
+	s.endHookOnce.Do(func() {
+		go func() {
+			defer recoverGoroutine(fmt.Sprintf("%s hook for session %s", hookSessionEnd, s.UUID))
+			var term io.Writer
+			if showInTerminal {
+				term = terminalWriter{s}
+			}
+			s.runSessionHook(hookSessionEnd, term, "SWE_SESSION_EXIT_CODE="+strconv.Itoa(exitCode))
+		}()
+	})
+}
+
+// runSessionHook finds and runs the named hook, appending its output to the
+// recording's .hooks.txt sidecar (and to term, when non-nil).
+func (s *Session) runSessionHook(name string, term io.Writer, extraEnv ...string) {
+	dir := s.effectiveWorkDir()
+	path, err := findSessionHook(dir, name)
+	if err != nil {
+		s.logger().Warn("session hook skipped", "hook", name, "error", err)
+		return
+	}
+	if path == "" {
+		return
+	}
+
+	s.mu.RLock()
+	recUUID := s.RecordingUUID
+	s.mu.RUnlock()
+	var sinks []io.Writer
+	if recUUID != "" {
+		f, err := os.OpenFile(sessionHooksPath(recUUID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			s.logger().Warn("session hook output not recorded", "hook", name, "error", err)
+		} else {
+			defer f.Close()
+			fmt.Fprintf(f, "=== %s %s (%s)\n", time.Now().UTC().Format(time.RFC3339), name, path)
+			sinks = append(sinks, f)
+		}
+	}
+	if term != nil {
+		fmt.Fprintf(term, "\n[Running %s hook]\n", name)
+		sinks = append(sinks, term)
+	}
+	out := &cappedWriter{w: io.MultiWriter(sinks...), n: hookMaxOutputBytes}
+
+	s.logger().Info("session hook started", "hook", name, "path", path, "timeout", hookTimeout)
+	res, err := runHook(path, dir, s.hookEnv(name, extraEnv...), out, hookTimeout)
+
+	var summary string
+	switch {
+	case err != nil:
+		summary = fmt.Sprintf("failed to run: %v", err)
+	case res.TimedOut:
+		summary = fmt.Sprintf("killed after %s timeout", hookTimeout)
+	default:
+		summary = fmt.Sprintf("exited %d after %s", res.ExitCode, res.Elapsed.Round(time.Millisecond))
+	}
+	if out.truncated {
+		summary += fmt.Sprintf(" (output truncated at %d bytes)", hookMaxOutputBytes)
+	}
+	for _, w := range sinks {
+		fmt.Fprintf(w, "[%s hook %s]\n", name, summary)
+	}
+	if err != nil || res.TimedOut || res.ExitCode != 0 {
+		s.logger().Warn("session hook finished", "hook", name, "result", summary)
+	} else {
+		s.logger().Info("session hook finished", "hook", name, "result", summary)
+	}
+}
+
+// sessionHooksPath is the recording sidecar that collects hook output.
+func sessionHooksPath(recUUID string) string {
+	return recordingDir(recUUID) + "/session-" + recUUID + ".hooks.txt"
+}
+
+// sessionExitCode returns the exit code of the session's process if it has
+// been reaped, else -1.
+func sessionExitCode(s *Session) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.Cmd != nil && s.Cmd.ProcessState != nil {
+		return s.Cmd.ProcessState.ExitCode()
+	}
+	return -1
+}